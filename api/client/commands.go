@@ -866,6 +866,10 @@ func (cli *DockerCli) CmdTop(args ...string) error {
 
 func (cli *DockerCli) CmdPort(args ...string) error {
 	cmd := cli.Subcmd("port", "CONTAINER [PRIVATE_PORT[/PROTO]]", "List port mappings for the CONTAINER, or lookup the public-facing port that is NAT-ed to the PRIVATE_PORT")
+	flPublish := opts.NewListOpts(nil)
+	cmd.Var(&flPublish, []string{"p", "-publish"}, fmt.Sprintf("Publish a port of the running container to the host\nformat: %s", nat.PortSpecTemplateFormat))
+	flUnpublish := opts.NewListOpts(nil)
+	cmd.Var(&flUnpublish, []string{"-unpublish"}, "Unpublish a host port of the running container, leaving its other ports alone\nformat: [ip:]hostPort[/proto]")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -874,17 +878,37 @@ func (cli *DockerCli) CmdPort(args ...string) error {
 		return nil
 	}
 
-	steam, _, err := cli.call("GET", "/containers/"+cmd.Arg(0)+"/json", nil, false)
-	if err != nil {
+	if flUnpublish.Len() > 0 {
+		if cmd.NArg() != 1 || flPublish.Len() > 0 {
+			cmd.Usage()
+			return nil
+		}
+		_, _, err := readBody(cli.call("POST", "/containers/"+cmd.Arg(0)+"/unpublish", map[string][]string{"Ports": flUnpublish.GetAll()}, false))
 		return err
 	}
-
-	env := engine.Env{}
-	if err := env.Decode(steam); err != nil {
-		return err
+	if flPublish.Len() > 0 {
+		if cmd.NArg() != 1 {
+			cmd.Usage()
+			return nil
+		}
+		stream, _, err := cli.call("POST", "/containers/"+cmd.Arg(0)+"/ports", map[string][]string{"Ports": flPublish.GetAll()}, false)
+		if err != nil {
+			return err
+		}
+		published := nat.PortMap{}
+		if err := json.NewDecoder(stream).Decode(&published); err != nil {
+			return err
+		}
+		for from, frontends := range published {
+			for _, frontend := range frontends {
+				fmt.Fprintf(cli.out, "%s -> %s:%s\n", from, frontend.HostIp, frontend.HostPort)
+			}
+		}
+		return nil
 	}
-	ports := nat.PortMap{}
-	if err := env.GetSubEnv("NetworkSettings").GetJson("Ports", &ports); err != nil {
+
+	mappings, err := cli.containerPorts(cmd.Arg(0))
+	if err != nil {
 		return err
 	}
 
@@ -900,24 +924,84 @@ func (cli *DockerCli) CmdPort(args ...string) error {
 			proto = parts[1]
 		}
 		natPort := port + "/" + proto
-		if frontends, exists := ports[nat.Port(port+"/"+proto)]; exists && frontends != nil {
-			for _, frontend := range frontends {
-				fmt.Fprintf(cli.out, "%s:%s\n", frontend.HostIp, frontend.HostPort)
+		found := false
+		for _, m := range mappings {
+			if strconv.Itoa(m.ContainerPort) == port && m.Proto == proto {
+				fmt.Fprintf(cli.out, "%s:%d\n", m.HostIP, m.HostPort)
+				found = true
 			}
+		}
+		if found {
 			return nil
 		}
 		return fmt.Errorf("Error: No public port '%s' published for %s", natPort, cmd.Arg(0))
 	}
 
-	for from, frontends := range ports {
-		for _, frontend := range frontends {
-			fmt.Fprintf(cli.out, "%s -> %s:%s\n", from, frontend.HostIp, frontend.HostPort)
-		}
+	for _, m := range mappings {
+		fmt.Fprintf(cli.out, "%d/%s -> %s:%d\n", m.ContainerPort, m.Proto, m.HostIP, m.HostPort)
 	}
 
 	return nil
 }
 
+// portMapping is a port mapping of a container, as listed by docker port.
+type portMapping struct {
+	Proto         string
+	HostIP        string
+	HostPort      int
+	ContainerPort int
+}
+
+// containerPorts returns the port mappings of a container. The daemon
+// answers from those of its network driver since the API 1.16, the older
+// ones have only those of the network settings of the container.
+func (cli *DockerCli) containerPorts(name string) ([]portMapping, error) {
+	var mappings []portMapping
+
+	serverVersion, err := cli.serverAPIVersion()
+	if err != nil {
+		return nil, err
+	}
+	if serverVersion.GreaterThanOrEqualTo("1.16") {
+		body, _, err := readBody(cli.call("GET", "/containers/"+name+"/ports", nil, false))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &mappings); err != nil {
+			return nil, err
+		}
+		return mappings, nil
+	}
+
+	stream, _, err := cli.call("GET", "/containers/"+name+"/json", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	env := engine.Env{}
+	if err := env.Decode(stream); err != nil {
+		return nil, err
+	}
+	ports := nat.PortMap{}
+	if err := env.GetSubEnv("NetworkSettings").GetJson("Ports", &ports); err != nil {
+		return nil, err
+	}
+	for port, frontends := range ports {
+		for _, frontend := range frontends {
+			hostPort, err := strconv.Atoi(frontend.HostPort)
+			if err != nil {
+				continue
+			}
+			mappings = append(mappings, portMapping{
+				Proto:         port.Proto(),
+				HostIP:        frontend.HostIp,
+				HostPort:      hostPort,
+				ContainerPort: port.Int(),
+			})
+		}
+	}
+	return mappings, nil
+}
+
 // 'docker rmi IMAGE' removes all images with the name IMAGE
 func (cli *DockerCli) CmdRmi(args ...string) error {
 	var (
@@ -1840,6 +1924,43 @@ func (cli *DockerCli) CmdLogs(args ...string) error {
 	return cli.streamHelper("GET", "/containers/"+name+"/logs?"+v.Encode(), env.GetSubEnv("Config").GetBool("Tty"), nil, cli.out, cli.err, nil)
 }
 
+func (cli *DockerCli) CmdNetwork(args ...string) error {
+	cmd := cli.Subcmd("network", "check", "Diagnose the container networking of the daemon")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 1 || cmd.Arg(0) != "check" {
+		cmd.Usage()
+		return nil
+	}
+
+	body, _, err := readBody(cli.call("GET", "/network/check", nil, false))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+
+	failures := 0
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tMESSAGE")
+	for _, check := range outs.Data {
+		status := "ok"
+		if !check.GetBool("Ok") {
+			status = "failed"
+			failures++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.Get("Name"), status, check.Get("Message"))
+	}
+	w.Flush()
+	if failures > 0 {
+		return &utils.StatusError{StatusCode: 1}
+	}
+	return nil
+}
+
 func (cli *DockerCli) CmdAttach(args ...string) error {
 	var (
 		cmd     = cli.Subcmd("attach", "CONTAINER", "Attach to a running container")
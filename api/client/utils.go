@@ -22,6 +22,7 @@ import (
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/docker/pkg/term"
+	"github.com/docker/docker/pkg/version"
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/utils"
 )
@@ -256,6 +257,23 @@ func (cli *DockerCli) getTtySize() (int, int) {
 	return int(ws.Height), int(ws.Width)
 }
 
+// serverAPIVersion returns the API version of the daemon, 1.0 for those too
+// old to tell.
+func (cli *DockerCli) serverAPIVersion() (version.Version, error) {
+	body, _, err := readBody(cli.call("GET", "/version", nil, false))
+	if err != nil {
+		return "", err
+	}
+	env := engine.Env{}
+	if err := env.Decode(bytes.NewReader(body)); err != nil {
+		return "", err
+	}
+	if v := env.Get("ApiVersion"); v != "" {
+		return version.Version(v), nil
+	}
+	return "1.0", nil
+}
+
 func readBody(stream io.ReadCloser, statusCode int, err error) ([]byte, int, error) {
 	if stream != nil {
 		defer stream.Close()
@@ -216,6 +216,141 @@ func postContainersUnpause(eng *engine.Engine, version version.Version, w http.R
 	return nil
 }
 
+func postContainersBlackhole(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("blackhole", vars["name"])
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersUnblackhole(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("unblackhole", vars["name"])
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersMirror(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("mirror", vars["name"])
+	job.Setenv("Target", r.Form.Get("target"))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersUnmirror(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("unmirror", vars["name"])
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func getContainersCapture(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("container_capture", vars["name"])
+	job.Setenv("Filter", r.Form.Get("filter"))
+	job.Setenv("Duration", r.Form.Get("duration"))
+	job.Setenv("Count", r.Form.Get("count"))
+	if snaplen := r.Form.Get("snaplen"); snaplen != "" {
+		job.Setenv("Snaplen", snaplen)
+	}
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	job.Stdout.Add(utils.NewWriteFlusher(w))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func postContainersNetem(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("container_netem", vars["name"])
+	job.Setenv("Delay", r.Form.Get("delay"))
+	job.Setenv("Jitter", r.Form.Get("jitter"))
+	job.Setenv("Loss", r.Form.Get("loss"))
+	job.Setenv("Reorder", r.Form.Get("reorder"))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersPartition(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("container_partition", vars["name"])
+	job.SetenvList("With", r.Form["with"])
+	job.Setenv("Duration", r.Form.Get("duration"))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersUnpartition(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("container_unpartition", vars["name"])
+	job.SetenvList("With", r.Form["with"])
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func getContainersExport(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -344,6 +479,192 @@ func getContainersTop(eng *engine.Engine, version version.Version, w http.Respon
 	return job.Run()
 }
 
+func postContainersPorts(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := checkForJson(r); err != nil {
+		return err
+	}
+	job := eng.Job("container_publish", vars["name"])
+	if err := job.DecodeEnv(r.Body); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func postContainersUnpublish(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := checkForJson(r); err != nil {
+		return err
+	}
+	job := eng.Job("container_unpublish", vars["name"])
+	if err := job.DecodeEnv(r.Body); err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersAddresses(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	job := eng.Job("container_address", vars["name"])
+	// Any free address is given without a body
+	if r.Body != nil && (r.ContentLength > 0 || r.ContentLength == -1) {
+		if err := checkForJson(r); err != nil {
+			return err
+		}
+		if err := job.DecodeEnv(r.Body); err != nil {
+			return err
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func postTaps(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	job := eng.Job("tap_create", vars["name"])
+	// A tap with any free address and no port is created without a body
+	if r.Body != nil && (r.ContentLength > 0 || r.ContentLength == -1) {
+		if err := checkForJson(r); err != nil {
+			return err
+		}
+		if err := job.DecodeEnv(r.Body); err != nil {
+			return err
+		}
+	}
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, *out)
+}
+
+func deleteTaps(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := eng.Job("tap_delete", vars["name"]).Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func getContainersPorts(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	job := eng.Job("container_ports", vars["name"])
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func getContainersNetstate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	job := eng.Job("container_netstate", vars["name"])
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func postContainersNetstate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := checkForJson(r); err != nil {
+		return err
+	}
+	snapshot, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	job := eng.Job("container_netimport", vars["name"])
+	job.Setenv("Snapshot", string(snapshot))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersMigratePrepare(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	job := eng.Job("migration_prepare", vars["name"])
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func postContainersMigrateReserve(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if err := checkForJson(r); err != nil {
+		return err
+	}
+	snapshot, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	job := eng.Job("migration_reserve", vars["name"])
+	job.Setenv("Snapshot", string(snapshot))
+	job.Setenv("KeepIP", r.Form.Get("keepip"))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersMigrateCommit(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	job := eng.Job("migration_commit", vars["name"])
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersMigrateAbort(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	job := eng.Job("migration_abort", vars["name"])
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func getContainersJSON(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -1171,6 +1492,46 @@ func writeCorsHeaders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS")
 }
 
+func getNetworkMetrics(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	job := eng.Job("network_metrics")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func getNetworkCheck(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("network_check")
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
+func getNetworkConfig(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	w.Header().Set("Content-Type", "application/json")
+	job := eng.Job("network_config")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func getNetworks(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	w.Header().Set("Content-Type", "application/json")
+	job := eng.Job("networks")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func postNetworkConfig(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := checkForJson(r); err != nil {
+		return err
+	}
+	job := eng.Job("network_config")
+	if err := job.DecodeEnv(r.Body); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
 func ping(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	_, err := w.Write([]byte{'O', 'K'})
 	return err
@@ -1248,6 +1609,10 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/events":                         getEvents,
 			"/info":                           getInfo,
 			"/version":                        getVersion,
+			"/network/metrics":                getNetworkMetrics,
+			"/network/check":                  getNetworkCheck,
+			"/network/config":                 getNetworkConfig,
+			"/networks":                       getNetworks,
 			"/images/json":                    getImagesJSON,
 			"/images/viz":                     getImagesViz,
 			"/images/search":                  getImagesSearch,
@@ -1261,35 +1626,56 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/containers/{name:.*}/changes":   getContainersChanges,
 			"/containers/{name:.*}/json":      getContainersByName,
 			"/containers/{name:.*}/top":       getContainersTop,
+			"/containers/{name:.*}/ports":     getContainersPorts,
+			"/containers/{name:.*}/netstate":  getContainersNetstate,
+			"/containers/{name:.*}/capture":   getContainersCapture,
 			"/containers/{name:.*}/logs":      getContainersLogs,
 			"/containers/{name:.*}/attach/ws": wsContainersAttach,
 		},
 		"POST": {
-			"/auth":                         postAuth,
-			"/commit":                       postCommit,
-			"/build":                        postBuild,
-			"/images/create":                postImagesCreate,
-			"/images/load":                  postImagesLoad,
-			"/images/{name:.*}/push":        postImagesPush,
-			"/images/{name:.*}/tag":         postImagesTag,
-			"/containers/create":            postContainersCreate,
-			"/containers/{name:.*}/kill":    postContainersKill,
-			"/containers/{name:.*}/pause":   postContainersPause,
-			"/containers/{name:.*}/unpause": postContainersUnpause,
-			"/containers/{name:.*}/restart": postContainersRestart,
-			"/containers/{name:.*}/start":   postContainersStart,
-			"/containers/{name:.*}/stop":    postContainersStop,
-			"/containers/{name:.*}/wait":    postContainersWait,
-			"/containers/{name:.*}/resize":  postContainersResize,
-			"/containers/{name:.*}/attach":  postContainersAttach,
-			"/containers/{name:.*}/copy":    postContainersCopy,
-			"/containers/{name:.*}/exec":    postContainerExecCreate,
-			"/exec/{name:.*}/start":         postContainerExecStart,
-			"/exec/{name:.*}/resize":        postContainerExecResize,
+			"/auth":                                 postAuth,
+			"/commit":                               postCommit,
+			"/build":                                postBuild,
+			"/images/create":                        postImagesCreate,
+			"/images/load":                          postImagesLoad,
+			"/images/{name:.*}/push":                postImagesPush,
+			"/images/{name:.*}/tag":                 postImagesTag,
+			"/containers/create":                    postContainersCreate,
+			"/containers/{name:.*}/kill":            postContainersKill,
+			"/containers/{name:.*}/pause":           postContainersPause,
+			"/containers/{name:.*}/unpause":         postContainersUnpause,
+			"/containers/{name:.*}/blackhole":       postContainersBlackhole,
+			"/containers/{name:.*}/unblackhole":     postContainersUnblackhole,
+			"/containers/{name:.*}/mirror":          postContainersMirror,
+			"/containers/{name:.*}/unmirror":        postContainersUnmirror,
+			"/containers/{name:.*}/netem":           postContainersNetem,
+			"/containers/{name:.*}/partition":       postContainersPartition,
+			"/containers/{name:.*}/unpartition":     postContainersUnpartition,
+			"/containers/{name:.*}/netstate":        postContainersNetstate,
+			"/containers/{name:.*}/migrate/prepare": postContainersMigratePrepare,
+			"/containers/{name:.*}/migrate/reserve": postContainersMigrateReserve,
+			"/containers/{name:.*}/migrate/commit":  postContainersMigrateCommit,
+			"/containers/{name:.*}/migrate/abort":   postContainersMigrateAbort,
+			"/containers/{name:.*}/restart":         postContainersRestart,
+			"/containers/{name:.*}/start":           postContainersStart,
+			"/containers/{name:.*}/stop":            postContainersStop,
+			"/containers/{name:.*}/wait":            postContainersWait,
+			"/containers/{name:.*}/resize":          postContainersResize,
+			"/containers/{name:.*}/attach":          postContainersAttach,
+			"/containers/{name:.*}/copy":            postContainersCopy,
+			"/containers/{name:.*}/ports":           postContainersPorts,
+			"/containers/{name:.*}/unpublish":       postContainersUnpublish,
+			"/containers/{name:.*}/addresses":       postContainersAddresses,
+			"/containers/{name:.*}/exec":            postContainerExecCreate,
+			"/exec/{name:.*}/start":                 postContainerExecStart,
+			"/exec/{name:.*}/resize":                postContainerExecResize,
+			"/network/config":                       postNetworkConfig,
+			"/taps/{name:.*}":                       postTaps,
 		},
 		"DELETE": {
 			"/containers/{name:.*}": deleteContainers,
 			"/images/{name:.*}":     deleteImages,
+			"/taps/{name:.*}":       deleteTaps,
 		},
 		"OPTIONS": {
 			"": optionsHandler,
@@ -474,6 +474,91 @@ func TestDeleteContainers(t *testing.T) {
 	}
 }
 
+func TestGetContainersCapture(t *testing.T) {
+	eng := engine.New()
+	name := "foo"
+	var called bool
+	eng.Register("container_capture", func(job *engine.Job) engine.Status {
+		called = true
+		if job.Args[0] != name {
+			t.Fatalf("name != '%s': %#v", name, job.Args[0])
+		}
+		if filter := job.Getenv("Filter"); filter != "tcp port 80" {
+			t.Fatalf("Filter != 'tcp port 80': %#v", filter)
+		}
+		if duration := job.GetenvInt("Duration"); duration != 30 {
+			t.Fatalf("Duration != 30: %d", duration)
+		}
+		if job.EnvExists("Snaplen") {
+			t.Fatalf("Snaplen set when it shouldn't")
+		}
+		if _, err := job.Stdout.Write([]byte("pcap")); err != nil {
+			return job.Error(err)
+		}
+		return engine.StatusOK
+	})
+	r := serveRequest("GET", "/containers/"+name+"/capture?filter=tcp+port+80&duration=30", nil, eng, t)
+	if !called {
+		t.Fatal("handler was not called")
+	}
+	assertContentType(r, "application/vnd.tcpdump.pcap", t)
+	if body := r.Body.String(); body != "pcap" {
+		t.Fatalf("Expected the capture, got %q", body)
+	}
+}
+
+func TestPostContainersNetem(t *testing.T) {
+	eng := engine.New()
+	name := "foo"
+	var called bool
+	eng.Register("container_netem", func(job *engine.Job) engine.Status {
+		called = true
+		if job.Args[0] != name {
+			t.Fatalf("name != '%s': %#v", name, job.Args[0])
+		}
+		if delay := job.Getenv("Delay"); delay != "100ms" {
+			t.Fatalf("Delay != '100ms': %#v", delay)
+		}
+		if loss := job.Getenv("Loss"); loss != "0.5%" {
+			t.Fatalf("Loss != '0.5%%': %#v", loss)
+		}
+		return engine.StatusOK
+	})
+	r := serveRequest("POST", "/containers/"+name+"/netem?delay=100ms&loss=0.5%25", bytes.NewReader(nil), eng, t)
+	if !called {
+		t.Fatal("handler was not called")
+	}
+	if r.Code != http.StatusNoContent {
+		t.Fatalf("Got status %d, expected %d", r.Code, http.StatusNoContent)
+	}
+}
+
+func TestPostContainersPartition(t *testing.T) {
+	eng := engine.New()
+	name := "foo"
+	var called bool
+	eng.Register("container_partition", func(job *engine.Job) engine.Status {
+		called = true
+		if job.Args[0] != name {
+			t.Fatalf("name != '%s': %#v", name, job.Args[0])
+		}
+		if with := job.GetenvList("With"); len(with) != 2 || with[0] != "bar" || with[1] != "baz" {
+			t.Fatalf("With != [bar baz]: %#v", with)
+		}
+		if duration := job.GetenvInt("Duration"); duration != 60 {
+			t.Fatalf("Duration != 60: %d", duration)
+		}
+		return engine.StatusOK
+	})
+	r := serveRequest("POST", "/containers/"+name+"/partition?with=bar&with=baz&duration=60", bytes.NewReader(nil), eng, t)
+	if !called {
+		t.Fatal("handler was not called")
+	}
+	if r.Code != http.StatusNoContent {
+		t.Fatalf("Got status %d, expected %d", r.Code, http.StatusNoContent)
+	}
+}
+
 func serveRequest(method, target string, body io.Reader, eng *engine.Engine, t *testing.T) *httptest.ResponseRecorder {
 	return serveRequestUsingVersion(method, target, api.APIVERSION, body, eng, t)
 }
@@ -6,6 +6,8 @@ import (
 	"github.com/docker/docker/api"
 	apiserver "github.com/docker/docker/api/server"
 	"github.com/docker/docker/daemon/networkdriver/bridge"
+	"github.com/docker/docker/daemon/networkdriver/plugin"
+	"github.com/docker/docker/daemon/networkdriver/sriov"
 	"github.com/docker/docker/dockerversion"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/events"
@@ -53,7 +55,24 @@ func remote(eng *engine.Engine) error {
 // These components should be broken off into plugins of their own.
 //
 func daemon(eng *engine.Engine) error {
-	return eng.Register("init_networkdriver", bridge.InitDriver)
+	if err := eng.Register("init_networkdriver", initNetworkDriver); err != nil {
+		return err
+	}
+	return eng.Register("network_rollback", bridge.Rollback)
+}
+
+// initNetworkDriver hands the networks of the containers over to the
+// network plugin given in Plugin, gives them the virtual functions of the
+// SR-IOV physical function given in SriovPF, or sets them up with the
+// bridge driver.
+func initNetworkDriver(job *engine.Job) engine.Status {
+	if job.Getenv("Plugin") != "" {
+		return plugin.InitDriver(job)
+	}
+	if job.Getenv("SriovPF") != "" {
+		return sriov.InitDriver(job)
+	}
+	return bridge.InitDriver(job)
 }
 
 // builtins jobs independent of any subsystem
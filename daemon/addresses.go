@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"github.com/docker/docker/engine"
+)
+
+// ContainerAddAddress gives the interface of a running container a secondary
+// address of its network, the RequestedIP or any free one, written as IP.
+// The address is the container's until it stops.
+func (daemon *Daemon) ContainerAddAddress(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	mode := container.hostConfig.NetworkMode
+	if !container.Running {
+		return job.Errorf("Cannot add an address to %s, it is not running", name)
+	}
+	if container.Config.NetworkDisabled || !mode.IsPrivate() {
+		return job.Errorf("Cannot add an address to %s, it has no network of its own", name)
+	}
+
+	add := job.Eng.Job("add_address", container.ID)
+	add.Setenv("RequestedIP", job.Getenv("RequestedIP"))
+	out, err := add.Stdout.AddEnv()
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := add.Run(); err != nil {
+		return job.Error(err)
+	}
+	settings := container.NetworkSettings
+	settings.SecondaryIPAddresses = append(settings.SecondaryIPAddresses, out.Get("IP"))
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,60 @@
+package daemon
+
+import (
+	"github.com/docker/docker/engine"
+)
+
+// ContainerBlackhole cuts a running container off the network without
+// stopping it: all its traffic is dropped and its published ports refuse
+// the connections, until ContainerUnblackhole lets it flow again. The
+// container stays cut off across restarts of the daemon, and is back on
+// the network once it stops.
+func (daemon *Daemon) ContainerBlackhole(job *engine.Job) engine.Status {
+	return daemon.setBlackhole(job, true)
+}
+
+// ContainerUnblackhole lets the traffic of a container cut off by
+// ContainerBlackhole flow again.
+func (daemon *Daemon) ContainerUnblackhole(job *engine.Job) engine.Status {
+	return daemon.setBlackhole(job, false)
+}
+
+func (daemon *Daemon) setBlackhole(job *engine.Job, blackhole bool) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	mode := container.hostConfig.NetworkMode
+	if !container.Running {
+		return job.Errorf("Cannot change the network of %s, it is not running", name)
+	}
+	if container.Config.NetworkDisabled || !mode.IsPrivate() {
+		return job.Errorf("Cannot change the network of %s, it has no network of its own", name)
+	}
+	if container.NetworkSettings.Blackholed == blackhole {
+		return engine.StatusOK
+	}
+
+	set := job.Eng.Job("set_blackhole", container.ID)
+	set.SetenvBool("Blackhole", blackhole)
+	if err := set.Run(); err != nil {
+		return job.Error(err)
+	}
+	container.NetworkSettings.Blackholed = blackhole
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	if blackhole {
+		container.LogEvent("blackhole")
+	} else {
+		container.LogEvent("unblackhole")
+	}
+	return engine.StatusOK
+}
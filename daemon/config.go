@@ -26,11 +26,57 @@ type Config struct {
 	Mirrors                     []string
 	EnableIptables              bool
 	EnableIpForward             bool
+	NeighThresholds             bool
 	EnableIpMasq                bool
+	IpMasqSource                string
+	SnatPool                    []string
+	Dscp                        string
 	DefaultIp                   net.IP
 	BridgeIface                 string
 	BridgeIP                    string
 	FixedCIDR                   string
+	BlockMetadata               bool
+	AllowIcmp                   bool
+	ResetConnections            bool
+	Multicast                   bool
+	MulticastSnooping           bool
+	MulticastQuerier            bool
+	ProxyDualStack              bool
+	ProtectHost                 bool
+	HostAccess                  []string
+	PublishIfaces               []string
+	NetflowCollector            string
+	NetworkReconcileInterval    int
+	NetworkInstance             string
+	NetworkRollback             bool
+	NetworkDryRun               bool
+	NetworkCleanup              bool
+	NetworkAdopt                bool
+	NetworkRootless             bool
+	NetworkHelper               string
+	NetworkPlugin               string
+	SriovPF                     string
+	SriovNetwork                string
+	SriovVlan                   int
+	NetworkNetworkd             string
+	NetworkUpstream             string
+	NetworkHooks                []string
+	NetworkPolicy               string
+	NetworkCloudRoutes          string
+	NetworkGCEAliasIP           bool
+	NetworkFloatingIPs          []string
+	NetworkIgnoredRoutes        []string
+	NetworkCandidates           []string
+	NetworkPoolThresholds       []string
+	NetworkMaxPorts             int
+	NetworkMaxAddresses         int
+	NetworkPortConflict         string
+	NetworkUplinkTables         string
+	NetworkAccounting           bool
+	MdnsIface                   string
+	DiscoveryBackend            string
+	MappingTemplates            []string
+	MappingReload               string
 	InsecureRegistries          []string
 	InterContainerCommunication bool
 	UseIpv6                     bool
@@ -53,10 +99,56 @@ func (config *Config) InstallFlags() {
 	flag.BoolVar(&config.AutoRestart, []string{"#r", "#-restart"}, true, "--restart on the daemon has been deprecated in favor of --restart policies on docker run")
 	flag.BoolVar(&config.EnableIptables, []string{"#iptables", "-iptables"}, true, "Enable Docker's addition of iptables rules")
 	flag.BoolVar(&config.EnableIpForward, []string{"#ip-forward", "-ip-forward"}, true, "Enable net.ipv4.ip_forward")
+	flag.BoolVar(&config.NeighThresholds, []string{"-neigh-thresholds"}, true, "Raise the thresholds of the neighbor table of the host, net.ipv4.neigh.default.gc_thresh*, to the number of addresses of the containers")
 	flag.BoolVar(&config.EnableIpMasq, []string{"-ip-masq"}, true, "Enable IP masquerading for bridge's IP range")
+	flag.StringVar(&config.IpMasqSource, []string{"-ip-masq-source"}, "", "Use SNAT to this address instead of MASQUERADE for the bridge's IP range")
+	opts.IPListVar(&config.SnatPool, []string{"-snat-pool"}, "Host address that containers can be given as their own outbound source address")
+	flag.StringVar(&config.Dscp, []string{"-dscp"}, "", "DSCP value or class (ex: AF41) to mark the traffic of the bridge's IP range with")
 	flag.StringVar(&config.BridgeIP, []string{"#bip", "-bip"}, "", "Use this CIDR notation address for the network bridge's IP, not compatible with -b")
 	flag.StringVar(&config.BridgeIface, []string{"b", "-bridge"}, "", "Attach containers to a pre-existing network bridge\nuse 'none' to disable container networking")
 	flag.StringVar(&config.FixedCIDR, []string{"-fixed-cidr"}, "", "IPv4 subnet for fixed IPs (ex: 10.20.0.0/16)\nthis subnet must be nested in the bridge subnet (which is defined by -b or --bip)")
+	flag.BoolVar(&config.BlockMetadata, []string{"-block-metadata"}, true, "Prevent containers from reaching the cloud metadata service and other link-local addresses, except those run with --allow-metadata")
+	flag.BoolVar(&config.AllowIcmp, []string{"-icmp"}, true, "Let the pings to the containers and the ICMP errors of the path MTU discovery through the firewall")
+	flag.BoolVar(&config.Multicast, []string{"-multicast"}, false, "Forward the multicast and the broadcast among the containers, for their discovery protocols")
+	flag.BoolVar(&config.MulticastSnooping, []string{"-multicast-snooping"}, true, "Have the bridge forward the multicast only to the containers which joined the group, rather than flood it")
+	flag.BoolVar(&config.MulticastQuerier, []string{"-multicast-querier"}, false, "Have the bridge send the IGMP and MLD queries, for its snooping to keep track of the groups without a multicast router")
+	flag.BoolVar(&config.ProxyDualStack, []string{"-proxy-dual-stack"}, false, "Have the userland proxies dial the IPv6 link-local address of the containers along with their IPv4 one, whichever connects first serving the connection")
+	flag.BoolVar(&config.ResetConnections, []string{"-reset-connections"}, false, "Reset the connections to the published ports of a container and forget those it tracked when it stops, for its clients to fail fast")
+	flag.BoolVar(&config.ProtectHost, []string{"-protect-host"}, false, "Prevent containers from reaching services on the host, except published ports and --host-access ones")
+	opts.ListVar(&config.HostAccess, []string{"-host-access"}, "Host port containers may reach when --protect-host is set (ex: 53/udp)")
+	opts.ListVar(&config.PublishIfaces, []string{"-publish-iface"}, "Only publish container ports on this host interface")
+	flag.StringVar(&config.NetflowCollector, []string{"-netflow-collector"}, "", "Export the flows of the containers to this NetFlow v9 collector (ex: 10.0.0.1:2055)")
+	flag.StringVar(&config.NetworkInstance, []string{"-network-instance"}, "", "Name of this daemon among those of the host, giving it a bridge, iptables chains and network state of its own (ex: test)")
+	flag.IntVar(&config.NetworkReconcileInterval, []string{"-network-reconcile-interval"}, 30, "Seconds between the repairs of the bridge and of the iptables rules changed by someone else, 0 to disable")
+	flag.BoolVar(&config.NetworkRollback, []string{"-network-rollback"}, false, "Undo the changes to the host networking recorded in the network journal and exit")
+	flag.BoolVar(&config.NetworkDryRun, []string{"-network-dry-run"}, false, "Log the changes to the host networking instead of making them")
+	flag.BoolVar(&config.NetworkAdopt, []string{"-network-adopt"}, false, "Keep the iptables rules of the port mappings of the previous daemon instead of recreating them, removing only the orphans")
+	flag.BoolVar(&config.NetworkRootless, []string{"-network-rootless"}, false, "Set up the container networks without privileges: no bridge nor iptables, a slirp4netns userspace NAT per container")
+	flag.StringVar(&config.NetworkHelper, []string{"-network-helper"}, "", "Make the changes to the host networking in this privileged helper, the docker-network-helper binary, so that the daemon can run without CAP_NET_ADMIN")
+	flag.StringVar(&config.NetworkPlugin, []string{"-network-plugin"}, "", "Network the containers with the external plugin listening on this unix socket instead of the bridge")
+	flag.StringVar(&config.SriovPF, []string{"-sriov-pf"}, "", "Network the containers with the virtual functions of this SR-IOV physical function instead of the bridge, one per container (ex: eth2)")
+	flag.StringVar(&config.SriovNetwork, []string{"-sriov-network"}, "", "Network of the SR-IOV physical function the containers get their addresses from, as the address of its gateway in CIDR notation (ex: 192.168.1.1/24)")
+	flag.IntVar(&config.SriovVlan, []string{"-sriov-vlan"}, 0, "VLAN the virtual functions of the containers are tagged with, 0 for none")
+	flag.StringVar(&config.NetworkNetworkd, []string{"-network-networkd"}, "", "Cooperate with systemd-networkd: 'unmanaged' to keep it off the bridge, 'units' to have it create the bridge")
+	flag.StringVar(&config.NetworkUpstream, []string{"-network-upstream-forwarding"}, "", "Have the router of the local network forward the ports published with --publish-upstream, with 'natpmp' or 'upnp'")
+	flag.StringVar(&config.MdnsIface, []string{"-mdns-iface"}, "", "Advertise the published ports on the local network of this interface with mDNS/DNS-SD")
+	flag.StringVar(&config.DiscoveryBackend, []string{"-discovery-backend"}, "", "Register the published ports in this service discovery backend, consul://host:port or etcd://host:port/prefix")
+	opts.ListVar(&config.MappingTemplates, []string{"-mapping-template"}, "Render the port mappings through this template whenever they change, given as src:dest (ex: /etc/docker/haproxy.tmpl:/etc/haproxy/haproxy.cfg)")
+	flag.StringVar(&config.MappingReload, []string{"-mapping-reload"}, "", "Command run by the shell when a file rendered by --mapping-template changes (ex: 'systemctl reload haproxy')")
+	flag.StringVar(&config.NetworkPolicy, []string{"-network-policy"}, "", "Have the policy engine listening on this unix socket allow, deny or change the ips and ports allocated to the containers")
+	flag.StringVar(&config.NetworkCloudRoutes, []string{"-network-cloud-routes"}, "", "Have the cloud route the bridge network to this host, with 'aws' or 'openstack', followed by ':' and the route table or the router if given (ex: aws:rtb-0a1b2c3d)")
+	flag.BoolVar(&config.NetworkGCEAliasIP, []string{"-network-gce-alias-ip"}, false, "Give the bridge the alias IP range of this GCE instance, routed to it by the VPC, so that the containers are reached by their own addresses without NAT; implies --ip-masq=false")
+	opts.ListVar(&config.NetworkCandidates, []string{"-network-candidate"}, "Address and netmask the bridge is given when the private and the RFC 6598 shared ranges all overlap the networks of the host (ex: 198.18.42.1/24)")
+	opts.ListVar(&config.NetworkPoolThresholds, []string{"-network-pool-threshold"}, "Percentage of the pool of the container ips or of the host ports warned about, with a log line and an event, once allocated; defaults to 80 and 95")
+	flag.IntVar(&config.NetworkMaxPorts, []string{"-network-max-ports"}, 0, "Ports each container can publish, unless run with --max-ports, 0 for no limit")
+	flag.IntVar(&config.NetworkMaxAddresses, []string{"-network-max-addresses"}, 0, "Secondary addresses each container can have, unless run with --max-addresses, 0 for no limit")
+	opts.ListVar(&config.NetworkIgnoredRoutes, []string{"-network-ignore-route"}, "Route prefix not taken as local when checking the network of the bridge for overlaps, such as the aggregate of a corporate VPN (ex: 10.0.0.0/8)")
+	opts.ListVar(&config.NetworkFloatingIPs, []string{"-network-vip"}, "Floating IP moving between the hosts, such as a VRRP virtual IP of keepalived, the ports published on it listening only while it is on this host")
+	flag.StringVar(&config.NetworkPortConflict, []string{"-network-port-conflict"}, "", "Publish a host port requested which is taken on the next free one instead of failing the start of the container: 'next' for the ports above it, or a set of ports to pick from (ex: 8000-8100,9000)")
+	flag.BoolVar(&config.NetworkAccounting, []string{"-network-accounting"}, false, "Count the traffic of every container with iptables, for the network stats, rather than of only the containers with a park policy")
+	flag.StringVar(&config.NetworkUplinkTables, []string{"-network-uplink-tables"}, "200-252", "Range of the routing tables the daemon sets up for the containers leaving the host through a specific uplink, those with routes or rules being skipped")
+	opts.ListVar(&config.NetworkHooks, []string{"-network-hook"}, "Executable run on each network event, given as JSON on its standard input")
+	flag.BoolVar(&config.NetworkCleanup, []string{"-network-cleanup"}, false, "Remove the bridge and the iptables rules created by the daemon when it exits")
 	opts.ListVar(&config.InsecureRegistries, []string{"-insecure-registry"}, "Enable insecure communication with specified registries (no certificate verification for HTTPS and enable HTTP fallback)")
 	flag.BoolVar(&config.InterContainerCommunication, []string{"#icc", "-icc"}, true, "Enable inter-container communication")
 	flag.BoolVar(&config.UseIpv6, []string{"#ipv6", "-ipv6"}, false, "Use ipv6")
@@ -11,6 +11,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -36,6 +38,10 @@ import (
 
 const DefaultPathEnv = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
 
+// parallelPortAllocations bounds the ports of a container published at the
+// same time, each taking a few iptables commands and a userland proxy.
+const parallelPortAllocations = 8
+
 var (
 	ErrNotATTY               = errors.New("The PTY is not a file")
 	ErrNoTTY                 = errors.New("No PTY found")
@@ -66,6 +72,8 @@ type Container struct {
 	Image  string
 
 	NetworkSettings *NetworkSettings
+	ImportedNetwork string `json:",omitempty"` // snapshot of a network exported elsewhere, set up at the next start
+	NetworkReserved bool   `json:",omitempty"` // the network is set up for the container moving in to start with
 
 	ResolvConfPath string
 	HostnamePath   string
@@ -94,6 +102,9 @@ type Container struct {
 	activeLinks  map[string]*links.Link
 	monitor      *containerMonitor
 	execCommands *execStore
+
+	// The interface was restored after a restart of the daemon, to start with
+	networkRestored bool
 }
 
 func (container *Container) FromDisk() error {
@@ -202,6 +213,9 @@ func populateCommand(c *Container, env []string) error {
 		Mtu:       c.daemon.config.Mtu,
 		Interface: nil,
 	}
+	if c.hostConfig.Mtu != 0 {
+		en.Mtu = c.hostConfig.Mtu
+	}
 
 	parts := strings.SplitN(string(c.hostConfig.NetworkMode), ":", 2)
 	switch parts[0] {
@@ -209,14 +223,17 @@ func populateCommand(c *Container, env []string) error {
 	case "host":
 		en.HostNetworking = true
 	case "bridge", "": // empty string to support existing containers
-		if !c.Config.NetworkDisabled {
+		// Without a bridge, in rootless mode, the network is set up by the
+		// driver once the container runs
+		if !c.Config.NetworkDisabled && c.NetworkSettings.Bridge != "" {
 			network := c.NetworkSettings
 			en.Interface = &execdriver.NetworkInterface{
-				Gateway:     network.Gateway,
-				Bridge:      network.Bridge,
-				IPAddress:   network.IPAddress,
-				IPPrefixLen: network.IPPrefixLen,
-				MacAddress:  network.MacAddress,
+				Gateway:           network.Gateway,
+				Bridge:            network.Bridge,
+				IPAddress:         network.IPAddress,
+				IPPrefixLen:       network.IPPrefixLen,
+				MacAddress:        network.MacAddress,
+				HostInterfaceName: network.HostInterfaceName,
 			}
 		}
 	case "container":
@@ -292,6 +309,8 @@ func (container *Container) Start() (err error) {
 	if container.Running {
 		return nil
 	}
+	// The port mappings take over the ports held for the start on demand
+	container.daemon.holdOnDemand(container, false)
 
 	// if we encounter and error during start we need to ensure that any other
 	// setup has been cleaned up properly
@@ -334,7 +353,13 @@ func (container *Container) Start() (err error) {
 		return err
 	}
 
-	return container.waitForStart()
+	if err := container.waitForStart(); err != nil {
+		return err
+	}
+	container.daemon.advertise(container)
+	container.daemon.registerServices(container)
+	container.daemon.exportMappings()
+	return nil
 }
 
 func (container *Container) Run() error {
@@ -449,6 +474,22 @@ func (container *Container) AllocateNetwork() error {
 	if container.Config.NetworkDisabled || !mode.IsPrivate() {
 		return nil
 	}
+	if container.NetworkReserved {
+		// Set up already, as the container moved from another host
+		container.NetworkReserved = false
+		return nil
+	}
+	if container.networkRestored {
+		container.networkRestored = false
+		return nil
+	}
+	if container.ImportedNetwork != "" {
+		if err := container.importNetwork(container.ImportedNetwork, false); err != nil {
+			return err
+		}
+		container.ImportedNetwork = ""
+		return nil
+	}
 
 	var (
 		env *engine.Env
@@ -457,6 +498,7 @@ func (container *Container) AllocateNetwork() error {
 	)
 
 	job := eng.Job("allocate_interface", container.ID)
+	container.setNetworkJobEnv(job)
 	if env, err = job.Stdout.AddEnv(); err != nil {
 		return err
 	}
@@ -502,25 +544,103 @@ func (container *Container) AllocateNetwork() error {
 	}
 
 	container.NetworkSettings.PortMapping = nil
-
-	for port := range portSpecs {
-		if err = container.allocatePort(eng, port, bindings); err != nil {
+	if ttl := container.hostConfig.PublishTTL; ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
 			eng.Job("release_interface", container.ID).Run()
-			return err
+			return fmt.Errorf("Invalid publish TTL %s: %s", ttl, err)
 		}
+		container.NetworkSettings.PortsExpire = time.Now().Add(d).UTC().Format(time.RFC3339Nano)
+	}
+
+	ports := make([]nat.Port, 0, len(portSpecs))
+	for port := range portSpecs {
+		ports = append(ports, port)
+	}
+	if _, err = container.allocatePorts(eng, ports, bindings); err != nil {
+		eng.Job("release_interface", container.ID).Run()
+		return err
 	}
 	container.WriteHostConfig()
 
 	container.NetworkSettings.Ports = bindings
 	container.NetworkSettings.Bridge = env.Get("Bridge")
+	container.NetworkSettings.HostInterfaceName = env.Get("HostInterfaceName")
 	container.NetworkSettings.IPAddress = env.Get("IP")
 	container.NetworkSettings.IPPrefixLen = env.GetInt("IPPrefixLen")
 	container.NetworkSettings.MacAddress = env.Get("MacAddress")
 	container.NetworkSettings.Gateway = env.Get("Gateway")
+	container.NetworkSettings.ServiceVIP = env.Get("ServiceVIP")
+	container.expirePorts()
 
 	return nil
 }
 
+// expirePorts unpublishes the ports of the container once they expire, the
+// network driver unmapping them at the same time.
+func (container *Container) expirePorts() {
+	settings := container.NetworkSettings
+	if settings.PortsExpire == "" {
+		return
+	}
+	expires, err := time.Parse(time.RFC3339Nano, settings.PortsExpire)
+	if err != nil {
+		return
+	}
+	time.AfterFunc(expires.Sub(time.Now()), func() {
+		container.Lock()
+		defer container.Unlock()
+		// The network of the container might have been released meanwhile
+		if container.NetworkSettings != settings {
+			return
+		}
+		for port := range settings.Ports {
+			settings.Ports[port] = nil
+		}
+		if err := container.toDisk(); err != nil {
+			log.Errorf("Unable to save %s once its ports expired: %s", utils.TruncateID(container.ID), err)
+		}
+	})
+}
+
+// setServiceVIP has the network driver make the container a backend of its
+// service, checked on its lowest exposed TCP port.
+func (container *Container) setServiceVIP(job *engine.Job) {
+	if container.hostConfig.ServiceVIP == "" {
+		return
+	}
+	job.Setenv("ServiceVIP", container.hostConfig.ServiceVIP)
+	job.Setenv("RequestedServiceVIP", container.NetworkSettings.ServiceVIP)
+	checkPort := 0
+	for port := range container.Config.ExposedPorts {
+		if p := port.Int(); port.Proto() == "tcp" && (checkPort == 0 || p < checkPort) {
+			checkPort = p
+		}
+	}
+	job.SetenvInt("ServiceCheckPort", checkPort)
+}
+
+// attachNetwork lets the network driver complete the network of the
+// container once its process runs, as the rootless network needs, and
+// records the path of its network namespace.
+func (container *Container) attachNetwork(pid int) error {
+	mode := container.hostConfig.NetworkMode
+	if container.Config.NetworkDisabled || !mode.IsPrivate() {
+		return nil
+	}
+	job := container.daemon.eng.Job("attach_interface", container.ID)
+	job.SetenvInt("Pid", pid)
+	env, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+	container.NetworkSettings.NetnsPath = env.Get("NetnsPath")
+	return nil
+}
+
 func (container *Container) ReleaseNetwork() {
 	if container.Config.NetworkDisabled {
 		return
@@ -529,12 +649,47 @@ func (container *Container) ReleaseNetwork() {
 
 	eng.Job("release_interface", container.ID).Run()
 	container.NetworkSettings = &NetworkSettings{}
+	container.networkRestored = false
 }
 
 func (container *Container) isNetworkAllocated() bool {
 	return container.NetworkSettings.IPAddress != ""
 }
 
+// setNetworkJobEnv fills the allocate_interface job with the network
+// settings of the container's host config.
+func (container *Container) setNetworkJobEnv(job *engine.Job) {
+	// For the network policy to tell the containers apart
+	job.Setenv("ContainerName", strings.TrimPrefix(container.Name, "/"))
+	job.Setenv("Image", container.Config.Image)
+	container.setServiceVIP(job)
+	if mtu := container.hostConfig.Mtu; mtu != 0 {
+		job.SetenvInt("Mtu", mtu)
+	}
+	if sysctls := container.hostConfig.Sysctls; len(sysctls) > 0 {
+		job.SetenvJson("Sysctls", sysctls)
+	}
+	if devices := container.hostConfig.NetDevices; len(devices) > 0 {
+		job.SetenvList("NetDevices", devices)
+	}
+	if container.hostConfig.StickyIP {
+		job.SetenvBool("StickyIP", true)
+	}
+	if container.hostConfig.AllowMetadata {
+		job.SetenvBool("AllowLinkLocal", true)
+	}
+	// Parking goes by the traffic counted
+	if container.hostConfig.ParkPolicy.Action != "" {
+		job.SetenvBool("Accounting", true)
+	}
+	if limit := container.hostConfig.MaxPorts; limit != 0 {
+		job.SetenvInt("MaxPorts", limit)
+	}
+	if limit := container.hostConfig.MaxAddresses; limit != 0 {
+		job.SetenvInt("MaxAddresses", limit)
+	}
+}
+
 func (container *Container) RestoreNetwork() error {
 	mode := container.hostConfig.NetworkMode
 	// Don't attempt a restore if we previously didn't allocate networking.
@@ -546,27 +701,46 @@ func (container *Container) RestoreNetwork() error {
 
 	eng := container.daemon.eng
 
+	// The network driver rebuilds the interface as it was, settings and port
+	// mappings included, if it saved it before the restart.
+	err := eng.Job("restore_interface", container.ID).Run()
+	if err == nil {
+		container.expirePorts()
+		container.networkRestored = true
+		return nil
+	}
+	log.Debugf("Unable to restore the network of %s, allocating it again: %s", container.ID, err)
+
 	// Re-allocate the interface with the same IP and MAC address.
 	job := eng.Job("allocate_interface", container.ID)
 	job.Setenv("RequestedIP", container.NetworkSettings.IPAddress)
 	job.Setenv("RequestedMac", container.NetworkSettings.MacAddress)
+	container.setNetworkJobEnv(job)
 	if err := job.Run(); err != nil {
 		return err
 	}
 
 	// Re-allocate any previously allocated ports.
+	ports := make([]nat.Port, 0, len(container.NetworkSettings.Ports))
 	for port := range container.NetworkSettings.Ports {
-		if err := container.allocatePort(eng, port, container.NetworkSettings.Ports); err != nil {
-			return err
-		}
+		ports = append(ports, port)
+	}
+	if _, err := container.allocatePorts(eng, ports, container.NetworkSettings.Ports); err != nil {
+		return err
 	}
+	container.expirePorts()
+	container.networkRestored = true
 	return nil
 }
 
 // cleanup releases any network resources allocated to the container along with any rules
 // around how containers are linked together.  It also unmounts the container's root filesystem.
 func (container *Container) cleanup() {
+	container.daemon.unadvertise(container)
+	container.daemon.deregisterServices(container)
 	container.ReleaseNetwork()
+	container.daemon.releaseMirrors(container)
+	container.daemon.exportMappings()
 
 	// Disable all active links
 	if container.activeLinks != nil {
@@ -578,6 +752,9 @@ func (container *Container) cleanup() {
 	if err := container.Unmount(); err != nil {
 		log.Errorf("%v: Failed to umount filesystem: %v", container.ID, err)
 	}
+
+	// The container stopped, the next connection to its ports starts it
+	container.daemon.holdOnDemand(container, true)
 }
 
 func (container *Container) KillSig(sig int) error {
@@ -932,21 +1109,22 @@ func (container *Container) setupContainerDns() error {
 	}
 
 	if config.NetworkMode != "host" {
+		daemonDns, daemonDnsSearch := daemon.dnsSettings()
 		// check configurations for any container/daemon dns settings
-		if len(config.Dns) > 0 || len(daemon.config.Dns) > 0 || len(config.DnsSearch) > 0 || len(daemon.config.DnsSearch) > 0 {
+		if len(config.Dns) > 0 || len(daemonDns) > 0 || len(config.DnsSearch) > 0 || len(daemonDnsSearch) > 0 {
 			var (
 				dns       = resolvconf.GetNameservers(resolvConf)
 				dnsSearch = resolvconf.GetSearchDomains(resolvConf)
 			)
 			if len(config.Dns) > 0 {
 				dns = config.Dns
-			} else if len(daemon.config.Dns) > 0 {
-				dns = daemon.config.Dns
+			} else if len(daemonDns) > 0 {
+				dns = daemonDns
 			}
 			if len(config.DnsSearch) > 0 {
 				dnsSearch = config.DnsSearch
-			} else if len(daemon.config.DnsSearch) > 0 {
-				dnsSearch = daemon.config.DnsSearch
+			} else if len(daemonDnsSearch) > 0 {
+				dnsSearch = daemonDnsSearch
 			}
 			return resolvconf.Build(container.ResolvConfPath, dns, dnsSearch)
 		}
@@ -1192,8 +1370,65 @@ func (container *Container) waitForStart() error {
 	return nil
 }
 
-func (container *Container) allocatePort(eng *engine.Engine, port nat.Port, bindings nat.PortMap) error {
-	binding := bindings[port]
+// allocatePorts publishes the ports of the container as bound in bindings,
+// several at a time, and replaces their bindings by the published ones. It
+// returns the ports published, those before the first failure if any.
+func (container *Container) allocatePorts(eng *engine.Engine, ports []nat.Port, bindings nat.PortMap) ([]nat.Port, error) {
+	var (
+		requested = make([][]nat.PortBinding, len(ports))
+		published = make([][]nat.PortBinding, len(ports))
+		errs      = make([]error, len(ports))
+		done      = make([]bool, len(ports))
+		failed    int32
+		wg        sync.WaitGroup
+		slots     = make(chan struct{}, parallelPortAllocations)
+	)
+	for i, port := range ports {
+		requested[i] = bindings[port]
+	}
+	for i, port := range ports {
+		slots <- struct{}{}
+		if atomic.LoadInt32(&failed) != 0 {
+			// No point in publishing the rest
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func(i int, port nat.Port) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if published[i], errs[i] = container.allocatePort(eng, port, requested[i]); errs[i] != nil {
+				atomic.StoreInt32(&failed, 1)
+			} else {
+				done[i] = true
+			}
+		}(i, port)
+	}
+	wg.Wait()
+
+	var (
+		allocated []nat.Port
+		firstErr  error
+	)
+	for i, port := range ports {
+		if !done[i] {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		bindings[port] = published[i]
+		allocated = append(allocated, port)
+	}
+	return allocated, firstErr
+}
+
+// allocatePort publishes a port of the container as bound in binding, and
+// returns the bindings it is published with.
+func (container *Container) allocatePort(eng *engine.Engine, port nat.Port, binding []nat.PortBinding) ([]nat.PortBinding, error) {
+	binding = append([]nat.PortBinding{}, binding...)
 	if container.hostConfig.PublishAllPorts && len(binding) == 0 {
 		binding = append(binding, nat.PortBinding{})
 	}
@@ -1206,21 +1441,34 @@ func (container *Container) allocatePort(eng *engine.Engine, port nat.Port, bind
 		job.Setenv("HostPort", b.HostPort)
 		job.Setenv("Proto", port.Proto())
 		job.Setenv("ContainerPort", port.Port())
+		job.SetenvBool("Upstream", container.hostConfig.PublishUpstream)
+		job.Setenv("Expires", container.NetworkSettings.PortsExpire)
+		job.Setenv("Name", container.hostConfig.PortNames[string(port)])
+		for _, p := range container.hostConfig.NoTrackPorts {
+			if p == string(port) {
+				job.SetenvBool("NoTrack", true)
+			}
+		}
+		if len(container.hostConfig.PortLabels) > 0 {
+			job.SetenvJson("Labels", container.hostConfig.PortLabels)
+		}
 
 		portEnv, err := job.Stdout.AddEnv()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if err := job.Run(); err != nil {
-			return err
+			return nil, err
 		}
 		b.HostIp = portEnv.Get("HostIP")
 		b.HostPort = portEnv.Get("HostPort")
+		if requested := portEnv.Get("RequestedHostPort"); requested != "" {
+			log.Infof("The host port %s of %s is taken, %s is published on %s instead", requested, utils.TruncateID(container.ID), port, b.HostPort)
+		}
 
 		binding[i] = b
 	}
-	bindings[port] = binding
-	return nil
+	return binding, nil
 }
 
 func (container *Container) GetProcessLabel() string {
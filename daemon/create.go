@@ -97,5 +97,6 @@ func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.Hos
 	if err := container.ToDisk(); err != nil {
 		return nil, nil, err
 	}
+	daemon.holdOnDemand(container, true)
 	return container, warnings, nil
 }
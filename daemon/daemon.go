@@ -28,6 +28,7 @@ import (
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/broadcastwriter"
+	"github.com/docker/docker/pkg/discovery"
 	"github.com/docker/docker/pkg/graphdb"
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/pkg/namesgenerator"
@@ -98,36 +99,64 @@ type Daemon struct {
 	driver         graphdriver.Driver
 	execDriver     execdriver.Driver
 	trustStore     *trust.TrustStore
+	dnsLock        sync.Mutex // guards the dns settings of config
+	onDemand       *onDemandPorts
+	parkStop       chan struct{}    // stops parking the idle containers, if watching them, when closed
+	advertiser     *advertiser      // nil unless the ports are advertised with mDNS
+	registrar      *registrar       // nil unless the ports are registered for service discovery
+	exporter       *mappingExporter // nil unless the port mappings are rendered through templates
 }
 
 // Install installs daemon capabilities to eng.
 func (daemon *Daemon) Install(eng *engine.Engine) error {
 	// FIXME: remove ImageDelete's dependency on Daemon, then move to graph/
 	for name, method := range map[string]engine.Handler{
-		"attach":            daemon.ContainerAttach,
-		"commit":            daemon.ContainerCommit,
-		"container_changes": daemon.ContainerChanges,
-		"container_copy":    daemon.ContainerCopy,
-		"container_inspect": daemon.ContainerInspect,
-		"containers":        daemon.Containers,
-		"create":            daemon.ContainerCreate,
-		"rm":                daemon.ContainerRm,
-		"export":            daemon.ContainerExport,
-		"info":              daemon.CmdInfo,
-		"kill":              daemon.ContainerKill,
-		"logs":              daemon.ContainerLogs,
-		"pause":             daemon.ContainerPause,
-		"resize":            daemon.ContainerResize,
-		"restart":           daemon.ContainerRestart,
-		"start":             daemon.ContainerStart,
-		"stop":              daemon.ContainerStop,
-		"top":               daemon.ContainerTop,
-		"unpause":           daemon.ContainerUnpause,
-		"wait":              daemon.ContainerWait,
-		"image_delete":      daemon.ImageDelete, // FIXME: see above
-		"execCreate":        daemon.ContainerExecCreate,
-		"execStart":         daemon.ContainerExecStart,
-		"execResize":        daemon.ContainerExecResize,
+		"attach":                daemon.ContainerAttach,
+		"commit":                daemon.ContainerCommit,
+		"container_changes":     daemon.ContainerChanges,
+		"container_copy":        daemon.ContainerCopy,
+		"container_inspect":     daemon.ContainerInspect,
+		"containers":            daemon.Containers,
+		"create":                daemon.ContainerCreate,
+		"rm":                    daemon.ContainerRm,
+		"export":                daemon.ContainerExport,
+		"info":                  daemon.CmdInfo,
+		"kill":                  daemon.ContainerKill,
+		"logs":                  daemon.ContainerLogs,
+		"pause":                 daemon.ContainerPause,
+		"resize":                daemon.ContainerResize,
+		"restart":               daemon.ContainerRestart,
+		"start":                 daemon.ContainerStart,
+		"stop":                  daemon.ContainerStop,
+		"top":                   daemon.ContainerTop,
+		"container_ports":       daemon.ContainerPorts,
+		"container_publish":     daemon.ContainerPublish,
+		"container_unpublish":   daemon.ContainerUnpublish,
+		"container_address":     daemon.ContainerAddAddress,
+		"blackhole":             daemon.ContainerBlackhole,
+		"unblackhole":           daemon.ContainerUnblackhole,
+		"mirror":                daemon.ContainerMirror,
+		"unmirror":              daemon.ContainerUnmirror,
+		"container_capture":     daemon.ContainerCapture,
+		"container_netem":       daemon.ContainerNetem,
+		"container_partition":   daemon.ContainerPartition,
+		"container_unpartition": daemon.ContainerUnpartition,
+		"container_netstate":    daemon.ContainerNetworkExport,
+		"container_netimport":   daemon.ContainerNetworkImport,
+		"migration_prepare":     daemon.ContainerMigrationPrepare,
+		"migration_reserve":     daemon.ContainerMigrationReserve,
+		"migration_commit":      daemon.ContainerMigrationCommit,
+		"migration_abort":       daemon.ContainerMigrationAbort,
+		"unpause":               daemon.ContainerUnpause,
+		"wait":                  daemon.ContainerWait,
+		"image_delete":          daemon.ImageDelete, // FIXME: see above
+		"execCreate":            daemon.ContainerExecCreate,
+		"execStart":             daemon.ContainerExecStart,
+		"execResize":            daemon.ContainerExecResize,
+		"network_config":        daemon.NetworkConfig,
+		"networks":              daemon.ListNetworks,
+		"tap_create":            daemon.CreateTap,
+		"tap_delete":            daemon.DeleteTap,
 	} {
 		if err := eng.Register(name, method); err != nil {
 			return err
@@ -380,6 +409,11 @@ func (daemon *Daemon) restore() error {
 				(container.hostConfig.RestartPolicy.Name == "on-failure" && container.ExitCode != 0) {
 				log.Debugf("Starting container %s", container.ID)
 
+				// The interface comes back as saved, with its ports
+				if err := container.RestoreNetwork(); err != nil {
+					log.Debugf("Failed to restore the network of %s: %s", container.ID, err)
+					container.ReleaseNetwork()
+				}
 				if err := container.Start(); err != nil {
 					log.Debugf("Failed to start container %s: %s", container.ID, err)
 				}
@@ -389,7 +423,16 @@ func (daemon *Daemon) restore() error {
 
 	for _, c := range registeredContainers {
 		c.registerVolumes()
+		if !c.IsRunning() {
+			c.restoreReservedNetwork()
+			daemon.holdOnDemand(c, true)
+		} else {
+			daemon.advertise(c)
+			daemon.registerServices(c)
+			c.restoreMirror()
+		}
 	}
+	daemon.pruneSavedInterfaces(registeredContainers)
 
 	if !debug {
 		fmt.Println()
@@ -399,6 +442,22 @@ func (daemon *Daemon) restore() error {
 	return nil
 }
 
+// pruneSavedInterfaces has the network driver forget the interfaces it saved
+// for the containers which are gone or have their network torn down.
+func (daemon *Daemon) pruneSavedInterfaces(containers []*Container) {
+	var keep []string
+	for _, c := range containers {
+		if c.IsRunning() || c.NetworkReserved {
+			keep = append(keep, c.ID)
+		}
+	}
+	job := daemon.eng.Job("prune_interfaces")
+	job.SetenvList("Keep", keep)
+	if err := job.Run(); err != nil {
+		log.Debugf("Unable to prune the saved network interfaces: %s", err)
+	}
+}
+
 func (daemon *Daemon) checkDeprecatedExpose(config *runconfig.Config) bool {
 	if config != nil {
 		if config.PortSpecs != nil {
@@ -728,12 +787,57 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 	if config.BridgeIface != "" && config.BridgeIP != "" {
 		return nil, fmt.Errorf("You specified -b & --bip, mutually exclusive options. Please specify only one.")
 	}
+	if config.NetworkRootless {
+		if config.BridgeIface != "" || config.BridgeIP != "" || config.FixedCIDR != "" {
+			return nil, fmt.Errorf("You specified --network-rootless with -b, --bip or --fixed-cidr. The rootless network has no bridge. Please unset them.")
+		}
+		// Without privileges there is no firewall nor ip forwarding to set up
+		config.EnableIptables = false
+		config.EnableIpForward = false
+		config.NeighThresholds = false
+		config.BlockMetadata = false
+	}
+	if config.NetworkGCEAliasIP {
+		if config.BridgeIface != "" || config.BridgeIP != "" || config.FixedCIDR != "" {
+			return nil, fmt.Errorf("You specified --network-gce-alias-ip with -b, --bip or --fixed-cidr. The bridge network is the alias IP range of the instance. Please unset them.")
+		}
+		if config.IpMasqSource != "" {
+			return nil, fmt.Errorf("You specified --network-gce-alias-ip with --ip-masq-source. The alias IP range of the instance is routed by the VPC without NAT. Please unset --ip-masq-source.")
+		}
+		// The VPC routes the addresses of the containers itself
+		config.EnableIpMasq = false
+	}
+	if config.NetworkPlugin != "" && (config.NetworkRootless || config.NetworkHelper != "") {
+		return nil, fmt.Errorf("You specified --network-plugin with --network-rootless or --network-helper. The network plugin sets up the host networking itself. Please unset them.")
+	}
+	if config.SriovPF != "" {
+		if config.SriovNetwork == "" {
+			return nil, fmt.Errorf("You specified --sriov-pf without --sriov-network. The containers get their addresses from the network of the physical function. Please set --sriov-network.")
+		}
+		if config.NetworkPlugin != "" || config.NetworkRootless || config.NetworkHelper != "" {
+			return nil, fmt.Errorf("You specified --sriov-pf with --network-plugin, --network-rootless or --network-helper. The containers get virtual functions in place of the bridge. Please unset them.")
+		}
+	}
 	if !config.EnableIptables && !config.InterContainerCommunication {
 		return nil, fmt.Errorf("You specified --iptables=false with --icc=false. ICC uses iptables to function. Please set --icc or --iptables to true.")
 	}
+	if !config.EnableIptables && config.BlockMetadata {
+		// On by default, the block goes without the firewall it is made of
+		log.Warnf("The containers can reach the cloud metadata service: blocking it needs --iptables")
+		config.BlockMetadata = false
+	}
+	if !config.EnableIptables && config.ProtectHost {
+		return nil, fmt.Errorf("You specified --iptables=false with --protect-host=true. Host protection uses iptables to function. Please set --protect-host to false or --iptables to true.")
+	}
+	if !config.EnableIptables && config.Dscp != "" {
+		return nil, fmt.Errorf("You specified --iptables=false with --dscp. DSCP marking uses iptables to function. Please unset --dscp or set --iptables to true.")
+	}
 	if !config.EnableIptables && config.EnableIpMasq {
 		config.EnableIpMasq = false
 	}
+	if !config.EnableIpMasq && config.IpMasqSource != "" {
+		return nil, fmt.Errorf("You specified --ip-masq-source without IP masquerading. Please set --ip-masq and --iptables to true.")
+	}
 	config.DisableNetwork = config.BridgeIface == disableNetworkBridge
 
 	// Claim the pidfile first, to avoid any and all unexpected race conditions.
@@ -853,11 +957,55 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 		job.SetenvBool("InterContainerCommunication", config.InterContainerCommunication)
 		job.SetenvBool("UseIpv6", config.UseIpv6)
 		job.SetenvBool("EnableIpForward", config.EnableIpForward)
+		job.SetenvBool("Accounting", config.NetworkAccounting)
+		job.SetenvBool("NeighThresholds", config.NeighThresholds)
 		job.SetenvBool("EnableIpMasq", config.EnableIpMasq)
+		job.Setenv("MasqSource", config.IpMasqSource)
+		job.SetenvList("SnatPool", config.SnatPool)
+		job.Setenv("Dscp", config.Dscp)
+		job.Setenv("Instance", config.NetworkInstance)
 		job.Setenv("BridgeIface", config.BridgeIface)
 		job.Setenv("BridgeIP", config.BridgeIP)
 		job.Setenv("FixedCIDR", config.FixedCIDR)
+		job.SetenvInt("Mtu", config.Mtu)
 		job.Setenv("DefaultBindingIP", config.DefaultIp.String())
+		job.SetenvBool("BlockMetadata", config.BlockMetadata)
+		job.SetenvBool("AllowIcmp", config.AllowIcmp)
+		job.SetenvBool("ResetConnections", config.ResetConnections)
+		job.SetenvBool("Multicast", config.Multicast)
+		job.SetenvBool("MulticastSnooping", config.MulticastSnooping)
+		job.SetenvBool("MulticastQuerier", config.MulticastQuerier)
+		job.SetenvBool("ProxyDualStack", config.ProxyDualStack)
+		job.SetenvBool("ProtectHost", config.ProtectHost)
+		job.SetenvList("HostAccess", config.HostAccess)
+		job.SetenvList("PublishIfaces", config.PublishIfaces)
+		job.Setenv("NetflowCollector", config.NetflowCollector)
+		job.SetenvInt("ReconcileInterval", config.NetworkReconcileInterval)
+		job.Setenv("Root", config.Root)
+		// Keeps the other daemons of the host off the bridge and the chains
+		job.Setenv("LockDir", "/var/run/docker-network")
+		job.SetenvBool("DryRun", config.NetworkDryRun)
+		job.SetenvBool("AdoptRules", config.NetworkAdopt)
+		job.SetenvBool("Rootless", config.NetworkRootless)
+		job.Setenv("Helper", config.NetworkHelper)
+		job.Setenv("Plugin", config.NetworkPlugin)
+		job.Setenv("SriovPF", config.SriovPF)
+		job.Setenv("SriovNetwork", config.SriovNetwork)
+		job.SetenvInt("SriovVlan", config.SriovVlan)
+		job.Setenv("Networkd", config.NetworkNetworkd)
+		job.Setenv("UpstreamForwarding", config.NetworkUpstream)
+		job.SetenvList("Hooks", config.NetworkHooks)
+		job.Setenv("AllocationPolicy", config.NetworkPolicy)
+		job.Setenv("CloudRoutes", config.NetworkCloudRoutes)
+		job.SetenvBool("GCEAliasIP", config.NetworkGCEAliasIP)
+		job.SetenvList("FloatingIPs", config.NetworkFloatingIPs)
+		job.SetenvList("IgnoredRoutes", config.NetworkIgnoredRoutes)
+		job.SetenvList("CandidateAddrs", config.NetworkCandidates)
+		job.SetenvList("PoolThresholds", config.NetworkPoolThresholds)
+		job.SetenvInt("MaxPorts", config.NetworkMaxPorts)
+		job.SetenvInt("MaxAddresses", config.NetworkMaxAddresses)
+		job.Setenv("PortConflict", config.NetworkPortConflict)
+		job.Setenv("UplinkTables", config.NetworkUplinkTables)
 
 		if err := job.Run(); err != nil {
 			return nil, err
@@ -912,10 +1060,35 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 		execDriver:     ed,
 		eng:            eng,
 		trustStore:     t,
+		onDemand:       newOnDemandPorts(),
+	}
+	if config.MdnsIface != "" && !config.DisableNetwork {
+		if daemon.advertiser, err = newAdvertiser(config.MdnsIface); err != nil {
+			return nil, err
+		}
+	}
+	if config.DiscoveryBackend != "" && !config.DisableNetwork {
+		backend, err := discovery.New(config.DiscoveryBackend)
+		if err != nil {
+			return nil, err
+		}
+		daemon.registrar = newRegistrar(backend)
+	}
+	if len(config.MappingTemplates) > 0 {
+		if daemon.exporter, err = newMappingExporter(config.MappingTemplates, config.MappingReload); err != nil {
+			return nil, err
+		}
 	}
 	if err := daemon.restore(); err != nil {
 		return nil, err
 	}
+	if !config.DisableNetwork {
+		daemon.parkStop = make(chan struct{})
+		go daemon.watchIdle(daemon.parkStop)
+	}
+	if daemon.exporter != nil {
+		go daemon.exporter.run(daemon)
+	}
 	// Setup shutdown handlers
 	// FIXME: can these shutdown handlers be registered closer to their source?
 	eng.OnShutdown(func() {
@@ -925,6 +1098,13 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 		if err := daemon.shutdown(); err != nil {
 			log.Errorf("daemon.shutdown(): %s", err)
 		}
+		if !config.DisableNetwork {
+			job := eng.Job("shutdown_networkdriver")
+			job.SetenvBool("Cleanup", config.NetworkCleanup)
+			if err := job.Run(); err != nil {
+				log.Errorf("shutdown_networkdriver: %s", err)
+			}
+		}
 		if err := portallocator.ReleaseAll(); err != nil {
 			log.Errorf("portallocator.ReleaseAll(): %s", err)
 		}
@@ -940,6 +1120,22 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 }
 
 func (daemon *Daemon) shutdown() error {
+	// The containers stopped aren't started on demand anymore
+	daemon.closeOnDemand()
+	if daemon.parkStop != nil {
+		close(daemon.parkStop)
+	}
+	if daemon.advertiser != nil {
+		// The browsers are told the services are gone
+		daemon.advertiser.Close()
+	}
+	if daemon.registrar != nil {
+		daemon.registrar.close()
+	}
+	if daemon.exporter != nil {
+		close(daemon.exporter.stop)
+	}
+
 	group := sync.WaitGroup{}
 	log.Debugf("starting clean shutdown of all containers...")
 	for _, container := range daemon.List() {
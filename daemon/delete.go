@@ -93,6 +93,17 @@ func (daemon *Daemon) Destroy(container *Container) error {
 	if err := container.Stop(3); err != nil {
 		return err
 	}
+	daemon.releaseOnDemand(container)
+	if container.hostConfig.StickyIP {
+		// The container won't start again to take its ip back
+		if err := daemon.eng.Job("release_reservation", container.ID).Run(); err != nil {
+			log.Debugf("Unable to release the ip reserved for %s: %s", container.ID, err)
+		}
+	}
+	if container.NetworkReserved {
+		// The container moving in won't start to use its network
+		container.releaseReservedNetwork(daemon.eng)
+	}
 
 	// Deregister the container before removing its directory, to avoid race conditions
 	daemon.idIndex.Delete(container.ID)
@@ -0,0 +1,159 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/pkg/discovery"
+	"github.com/docker/docker/utils"
+)
+
+// registrationInterval is the time between the registrations of the
+// services, which keep them from expiring and register again those the
+// backend failed to.
+var registrationInterval = 30 * time.Second
+
+// registrar keeps the published ports of the running containers registered
+// in a service discovery backend.
+type registrar struct {
+	backend discovery.Backend
+
+	sync.Mutex
+	services map[string][]*discovery.Service // by container id
+	stop     chan struct{}                   // stops the registrations when closed
+}
+
+func newRegistrar(backend discovery.Backend) *registrar {
+	r := &registrar{
+		backend:  backend,
+		services: make(map[string][]*discovery.Service),
+		stop:     make(chan struct{}),
+	}
+	go r.refresh()
+	return r
+}
+
+func (r *registrar) register(id string, services []*discovery.Service) {
+	for _, s := range services {
+		if err := r.backend.Register(s); err != nil {
+			log.WithField("container", id).Warnf("Unable to register the service %s: %s", s.ID, err)
+		}
+	}
+}
+
+func (r *registrar) refresh() {
+	ticker := time.NewTicker(registrationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.Lock()
+		for id, services := range r.services {
+			r.register(id, services)
+		}
+		r.Unlock()
+	}
+}
+
+// close stops the registrations and deregisters the services.
+func (r *registrar) close() {
+	close(r.stop)
+	r.Lock()
+	defer r.Unlock()
+	for id := range r.services {
+		r.deregister(id)
+	}
+}
+
+func (r *registrar) deregister(id string) {
+	for _, s := range r.services[id] {
+		if err := r.backend.Deregister(s); err != nil {
+			log.WithField("container", id).Warnf("Unable to deregister the service %s: %s", s.ID, err)
+		}
+	}
+	delete(r.services, id)
+}
+
+// registeredServices returns the services of the port mappings of the
+// container, named after it. A container publishing several ports has the
+// container port in the name of each.
+func registeredServices(container *Container) []*discovery.Service {
+	if container.NetworkSettings == nil {
+		return nil
+	}
+	var (
+		name     = strings.TrimPrefix(container.Name, "/")
+		ports    = make([]string, 0, len(container.NetworkSettings.Ports))
+		services []*discovery.Service
+	)
+	for port, bindings := range container.NetworkSettings.Ports {
+		if len(bindings) > 0 {
+			ports = append(ports, string(port))
+		}
+	}
+	sort.Strings(ports)
+	for _, p := range ports {
+		port := nat.Port(p)
+		serviceName := name
+		if len(ports) > 1 {
+			serviceName = name + "-" + port.Port()
+		}
+		for _, b := range container.NetworkSettings.Ports[port] {
+			hostPort, err := strconv.Atoi(b.HostPort)
+			if err != nil {
+				continue
+			}
+			s := &discovery.Service{
+				ID:    fmt.Sprintf("%s:%s:%d:%s", utils.TruncateID(container.ID), b.HostIp, hostPort, port.Proto()),
+				Name:  serviceName,
+				Port:  hostPort,
+				Proto: port.Proto(),
+				Tags:  []string{string(port)},
+			}
+			// The ports published on all the addresses are registered
+			// with the address of the host
+			if ip := net.ParseIP(b.HostIp); ip != nil && !ip.IsUnspecified() {
+				s.Address = ip.String()
+			}
+			services = append(services, s)
+		}
+	}
+	return services
+}
+
+// registerServices registers the port mappings of the running container.
+func (daemon *Daemon) registerServices(container *Container) {
+	r := daemon.registrar
+	if r == nil {
+		return
+	}
+	services := registeredServices(container)
+	r.Lock()
+	defer r.Unlock()
+	r.deregister(container.ID)
+	if len(services) > 0 {
+		r.services[container.ID] = services
+		r.register(container.ID, services)
+	}
+}
+
+// deregisterServices deregisters the port mappings of the container.
+func (daemon *Daemon) deregisterServices(container *Container) {
+	r := daemon.registrar
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.deregister(container.ID)
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/pkg/discovery"
+)
+
+type fakeBackend struct {
+	registered map[string]*discovery.Service
+}
+
+func (b *fakeBackend) Register(s *discovery.Service) error {
+	b.registered[s.ID] = s
+	return nil
+}
+
+func (b *fakeBackend) Deregister(s *discovery.Service) error {
+	delete(b.registered, s.ID)
+	return nil
+}
+
+func TestRegisterServices(t *testing.T) {
+	backend := &fakeBackend{registered: make(map[string]*discovery.Service)}
+	daemon := &Daemon{registrar: newRegistrar(backend)}
+	container := &Container{
+		ID:   "0123456789abcdef",
+		Name: "/web",
+		NetworkSettings: &NetworkSettings{Ports: nat.PortMap{
+			"80/tcp":  {{HostIp: "0.0.0.0", HostPort: "8080"}, {HostIp: "10.0.0.1", HostPort: "8081"}},
+			"53/udp":  {{HostIp: "", HostPort: "5353"}},
+			"443/tcp": nil,
+		}},
+	}
+
+	daemon.registerServices(container)
+	if len(backend.registered) != 3 {
+		t.Fatalf("Expected 3 services, got %v", backend.registered)
+	}
+	s := backend.registered["0123456789ab:0.0.0.0:8080:tcp"]
+	if s == nil || s.Name != "web-80" || s.Address != "" || s.Port != 8080 || s.Proto != "tcp" || s.Tags[0] != "80/tcp" {
+		t.Fatalf("Unexpected service %v", s)
+	}
+	if s := backend.registered["0123456789ab:10.0.0.1:8081:tcp"]; s == nil || s.Address != "10.0.0.1" {
+		t.Fatalf("Expected the address of the port mapping, got %v", s)
+	}
+	if s := backend.registered["0123456789ab::5353:udp"]; s == nil || s.Name != "web-53" {
+		t.Fatalf("Unexpected service %v", s)
+	}
+
+	// A single port takes the name of the container
+	container.NetworkSettings.Ports = nat.PortMap{"80/tcp": {{HostIp: "0.0.0.0", HostPort: "8080"}}}
+	daemon.registerServices(container)
+	if len(backend.registered) != 1 || backend.registered["0123456789ab:0.0.0.0:8080:tcp"].Name != "web" {
+		t.Fatalf("Expected the services to be replaced, got %v", backend.registered)
+	}
+
+	daemon.deregisterServices(container)
+	if len(backend.registered) != 0 {
+		t.Fatalf("Expected the services to be deregistered, got %v", backend.registered)
+	}
+	daemon.registrar.close()
+}
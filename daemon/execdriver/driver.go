@@ -63,11 +63,12 @@ type Network struct {
 }
 
 type NetworkInterface struct {
-	Gateway     string `json:"gateway"`
-	IPAddress   string `json:"ip"`
-	IPPrefixLen int    `json:"ip_prefix_len"`
-	MacAddress  string `json:"mac_address"`
-	Bridge      string `json:"bridge"`
+	Gateway           string `json:"gateway"`
+	IPAddress         string `json:"ip"`
+	IPPrefixLen       int    `json:"ip_prefix_len"`
+	MacAddress        string `json:"mac_address"`
+	Bridge            string `json:"bridge"`
+	HostInterfaceName string `json:"host_interface_name"` // empty for a random name
 }
 
 type Resources struct {
@@ -14,6 +14,9 @@ const LxcTemplate = `
 lxc.network.type = veth
 lxc.network.link = {{.Network.Interface.Bridge}}
 lxc.network.name = eth0
+{{if .Network.Interface.HostInterfaceName}}
+lxc.network.veth.pair = {{.Network.Interface.HostInterfaceName}}
+{{end}}
 lxc.network.mtu = {{.Network.Mtu}}
 {{else if .Network.HostNetworking}}
 lxc.network.type = none
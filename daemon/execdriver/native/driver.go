@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -23,6 +24,7 @@ import (
 	consolepkg "github.com/docker/libcontainer/console"
 	"github.com/docker/libcontainer/namespaces"
 	_ "github.com/docker/libcontainer/namespaces/nsenter"
+	"github.com/docker/libcontainer/network"
 	"github.com/docker/libcontainer/system"
 )
 
@@ -121,6 +123,11 @@ func (d *driver) Run(c *execdriver.Command, pipes *execdriver.Pipes, startCallba
 
 		return &c.ProcessConfig.Cmd
 	}, func() {
+		if c.Network.Interface != nil && c.Network.Interface.HostInterfaceName != "" {
+			if err := renameHostInterface(dataPath, c.Network.Interface.HostInterfaceName); err != nil {
+				log.Printf("Failed to name the interface of %s %s: %s", c.ID, c.Network.Interface.HostInterfaceName, err)
+			}
+		}
 		if startCallback != nil {
 			c.ContainerPid = c.ProcessConfig.Process.Pid
 			startCallback(&c.ProcessConfig, c.ContainerPid)
@@ -128,6 +135,33 @@ func (d *driver) Run(c *execdriver.Command, pipes *execdriver.Pipes, startCallba
 	})
 }
 
+// renameHostInterface gives the host side of the veth pair libcontainer
+// created with a random name the name chosen by the network driver, and
+// records it in the state of the container for the stats to be read from it.
+func renameHostInterface(dataPath, name string) error {
+	state, err := libcontainer.GetState(dataPath)
+	if err != nil {
+		return err
+	}
+	veth := state.NetworkState.VethHost
+	if veth == "" || veth == name {
+		return nil
+	}
+	// The kernel only renames the interfaces that are down
+	if err := network.InterfaceDown(veth); err != nil {
+		return err
+	}
+	if err := network.ChangeInterfaceName(veth, name); err != nil {
+		network.InterfaceUp(veth)
+		return err
+	}
+	if err := network.InterfaceUp(name); err != nil {
+		return err
+	}
+	state.NetworkState.VethHost = name
+	return libcontainer.SaveState(dataPath, state)
+}
+
 func (d *driver) Kill(p *execdriver.Command, sig int) error {
 	return syscall.Kill(p.ProcessConfig.Process.Pid, syscall.Signal(sig))
 }
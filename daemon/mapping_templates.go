@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// renderDelay is the time the mapping templates are rendered after a
+// change, for the changes of the containers starting or stopping together
+// to be rendered once.
+var renderDelay = 500 * time.Millisecond
+
+// exportedMapping is a port mapping as seen by the mapping templates.
+type exportedMapping struct {
+	ID            string // container id
+	Name          string // container name, without the leading /
+	ContainerIP   string
+	ContainerPort string // ex: 80
+	Proto         string
+	HostIp        string
+	HostPort      string
+}
+
+// mappingTable is the table of the port mappings of the running
+// containers given to the mapping templates.
+type mappingTable struct {
+	Mappings []exportedMapping            // sorted by container name and port
+	ByPort   map[string][]exportedMapping // by container port, ex: "80/tcp"
+}
+
+// mappingTemplate renders the port mappings to the file dest.
+type mappingTemplate struct {
+	tmpl *template.Template
+	dest string
+}
+
+// mappingExporter renders the port mappings through the user templates
+// whenever they change, and runs the reload command if a file changed.
+type mappingExporter struct {
+	templates []*mappingTemplate
+	reload    string
+	changed   chan struct{} // signals a change of the port mappings
+	stop      chan struct{} // stops the rendering when closed
+}
+
+// newMappingExporter parses the templates, given as src:dest.
+func newMappingExporter(specs []string, reload string) (*mappingExporter, error) {
+	e := &mappingExporter{
+		reload:  reload,
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid mapping template %s, expected src:dest", spec)
+		}
+		tmpl, err := template.ParseFiles(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse the mapping template %s: %s", parts[0], err)
+		}
+		e.templates = append(e.templates, &mappingTemplate{tmpl: tmpl, dest: parts[1]})
+	}
+	return e, nil
+}
+
+// newMappingTable returns the table of the port mappings of the running
+// containers.
+func newMappingTable(containers []*Container) *mappingTable {
+	m := &mappingTable{ByPort: make(map[string][]exportedMapping)}
+	for _, container := range containers {
+		if !container.IsRunning() || container.NetworkSettings == nil {
+			continue
+		}
+		for port, bindings := range container.NetworkSettings.Ports {
+			for _, b := range bindings {
+				m.Mappings = append(m.Mappings, exportedMapping{
+					ID:            container.ID,
+					Name:          strings.TrimPrefix(container.Name, "/"),
+					ContainerIP:   container.NetworkSettings.IPAddress,
+					ContainerPort: port.Port(),
+					Proto:         port.Proto(),
+					HostIp:        b.HostIp,
+					HostPort:      b.HostPort,
+				})
+			}
+		}
+	}
+	sort.Sort(byNameAndPort(m.Mappings))
+	for _, mapping := range m.Mappings {
+		port := mapping.ContainerPort + "/" + mapping.Proto
+		m.ByPort[port] = append(m.ByPort[port], mapping)
+	}
+	return m
+}
+
+type byNameAndPort []exportedMapping
+
+func (s byNameAndPort) Len() int      { return len(s) }
+func (s byNameAndPort) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byNameAndPort) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	if s[i].ContainerPort != s[j].ContainerPort {
+		pi, _ := strconv.Atoi(s[i].ContainerPort)
+		pj, _ := strconv.Atoi(s[j].ContainerPort)
+		return pi < pj
+	}
+	if s[i].Proto != s[j].Proto {
+		return s[i].Proto < s[j].Proto
+	}
+	return s[i].HostIp+":"+s[i].HostPort < s[j].HostIp+":"+s[j].HostPort
+}
+
+// render renders the templates, and tells whether a file changed.
+func (e *mappingExporter) render(mappings *mappingTable) (bool, error) {
+	changed := false
+	for _, t := range e.templates {
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, mappings); err != nil {
+			return changed, fmt.Errorf("Unable to render %s: %s", t.dest, err)
+		}
+		if current, err := ioutil.ReadFile(t.dest); err == nil && bytes.Equal(current, buf.Bytes()) {
+			continue
+		}
+		// Renamed into place, for the load balancer to never read half
+		// of the file
+		tmp := filepath.Join(filepath.Dir(t.dest), "."+filepath.Base(t.dest)+".tmp")
+		if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+			return changed, err
+		}
+		if err := os.Rename(tmp, t.dest); err != nil {
+			os.Remove(tmp)
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// run renders the templates once at start, then after each change of the
+// port mappings, until stopped.
+func (e *mappingExporter) run(daemon *Daemon) {
+	for {
+		changed, err := e.render(newMappingTable(daemon.List()))
+		if err != nil {
+			log.Errorf("%s", err)
+		}
+		if changed && e.reload != "" {
+			if out, err := exec.Command("/bin/sh", "-c", e.reload).CombinedOutput(); err != nil {
+				log.Errorf("Mapping templates reload command failed: %s: %s", err, strings.TrimSpace(string(out)))
+			}
+		}
+
+		select {
+		case <-e.stop:
+			return
+		case <-e.changed:
+		}
+		select {
+		case <-e.stop:
+			return
+		case <-time.After(renderDelay):
+		}
+		// The changes during the delay are rendered now
+		select {
+		case <-e.changed:
+		default:
+		}
+	}
+}
+
+// exportMappings renders the mapping templates again, the port mappings
+// having changed.
+func (daemon *Daemon) exportMappings() {
+	if daemon.exporter == nil {
+		return
+	}
+	select {
+	case daemon.exporter.changed <- struct{}{}:
+	default:
+		// Already pending
+	}
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/nat"
+)
+
+func TestRenderMappingTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-mapping-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "haproxy.tmpl")
+	tmpl := "{{range $port, $mappings := .ByPort}}backend {{$port}}\n{{range $mappings}}  server {{.Name}} {{.ContainerIP}}:{{.ContainerPort}} # {{.HostIp}}:{{.HostPort}}\n{{end}}{{end}}"
+	if err := ioutil.WriteFile(src, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "haproxy.cfg")
+	e, err := newMappingExporter([]string{src + ":" + dest}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newContainer := func(name, ip string, running bool, ports nat.PortMap) *Container {
+		c := &Container{ID: name + "_id", Name: "/" + name, State: NewState(), NetworkSettings: &NetworkSettings{IPAddress: ip, Ports: ports}}
+		c.State.Running = running
+		return c
+	}
+	containers := []*Container{
+		newContainer("web2", "172.17.0.3", true, nat.PortMap{"80/tcp": {{HostIp: "0.0.0.0", HostPort: "49154"}}}),
+		newContainer("web1", "172.17.0.2", true, nat.PortMap{"80/tcp": {{HostIp: "0.0.0.0", HostPort: "49153"}}}),
+		newContainer("stopped", "172.17.0.4", false, nat.PortMap{"80/tcp": {{HostIp: "0.0.0.0", HostPort: "49155"}}}),
+	}
+	changed, err := e.render(newMappingTable(containers))
+	if err != nil || !changed {
+		t.Fatalf("Expected the file to be rendered, got %v, %v", changed, err)
+	}
+	out, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "backend 80/tcp\n  server web1 172.17.0.2:80 # 0.0.0.0:49153\n  server web2 172.17.0.3:80 # 0.0.0.0:49154\n"
+	if string(out) != expected {
+		t.Fatalf("Expected %q, got %q", expected, out)
+	}
+
+	// Nothing to reload
+	if changed, err := e.render(newMappingTable(containers)); err != nil || changed {
+		t.Fatalf("Expected the file to be unchanged, got %v, %v", changed, err)
+	}
+
+	if _, err := newMappingExporter([]string{src}, ""); err == nil {
+		t.Fatal("Expected a template without destination to be refused")
+	}
+}
@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/pkg/mdns"
+)
+
+// serviceTypes are the DNS-SD service types of the well-known ports of the
+// containers. The other ports are advertised as _docker._tcp and
+// _docker._udp services.
+var serviceTypes = map[nat.Port]string{
+	"21/tcp":    "_ftp._tcp",
+	"22/tcp":    "_ssh._tcp",
+	"53/udp":    "_domain._udp",
+	"80/tcp":    "_http._tcp",
+	"443/tcp":   "_https._tcp",
+	"445/tcp":   "_smb._tcp",
+	"631/tcp":   "_ipp._tcp",
+	"1883/tcp":  "_mqtt._tcp",
+	"3306/tcp":  "_mysql._tcp",
+	"5432/tcp":  "_postgresql._tcp",
+	"5900/tcp":  "_rfb._tcp",
+	"6379/tcp":  "_redis._tcp",
+	"8000/tcp":  "_http._tcp",
+	"8080/tcp":  "_http._tcp",
+	"8443/tcp":  "_https._tcp",
+	"27017/tcp": "_mongodb._tcp",
+}
+
+// advertiser advertises the published ports of the running containers on
+// the local network, named after the containers.
+type advertiser struct {
+	*mdns.Responder
+
+	sync.Mutex
+	services map[string][]*mdns.Service // by container id
+}
+
+func newAdvertiser(ifaceName string) (*advertiser, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to advertise the containers on %s: %s", ifaceName, err)
+	}
+	r, err := mdns.NewResponder(iface)
+	if err != nil {
+		return nil, err
+	}
+	return &advertiser{Responder: r, services: make(map[string][]*mdns.Service)}, nil
+}
+
+func serviceType(port nat.Port) string {
+	if t, exists := serviceTypes[port]; exists {
+		return t
+	}
+	return "_docker._" + port.Proto()
+}
+
+// containerServices returns the services of the ports of the container
+// published on the addresses advertised. A container publishing several
+// ports of the same type has the container port in the name of each.
+func containerServices(container *Container, addrs []net.IP) []*mdns.Service {
+	if container.NetworkSettings == nil {
+		return nil
+	}
+	var (
+		name     = strings.TrimPrefix(container.Name, "/")
+		ports    = make([]string, 0, len(container.NetworkSettings.Ports))
+		services []*mdns.Service
+		exposed  []nat.Port // container port of each service
+		perType  = make(map[string]int)
+	)
+	for port := range container.NetworkSettings.Ports {
+		ports = append(ports, string(port))
+	}
+	sort.Strings(ports)
+	for _, p := range ports {
+		port := nat.Port(p)
+		// A port published several times is advertised once
+		for _, b := range container.NetworkSettings.Ports[port] {
+			hostPort, err := strconv.Atoi(b.HostPort)
+			if err != nil || !advertisedIP(b.HostIp, addrs) {
+				continue
+			}
+			services = append(services, &mdns.Service{
+				Instance: name,
+				Type:     serviceType(port),
+				Port:     hostPort,
+				Text:     []string{"container=" + name, "port=" + string(port)},
+			})
+			exposed = append(exposed, port)
+			perType[serviceType(port)]++
+			break
+		}
+	}
+	for i, s := range services {
+		if perType[s.Type] > 1 {
+			s.Instance = name + "-" + exposed[i].Port()
+		}
+	}
+	return services
+}
+
+// advertisedIP tells whether a port published on hostIP is reachable at
+// the addresses advertised.
+func advertisedIP(hostIP string, addrs []net.IP) bool {
+	ip := net.ParseIP(hostIP)
+	if ip == nil || ip.IsUnspecified() {
+		return true
+	}
+	for _, addr := range addrs {
+		if addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// advertise advertises the published ports of the running container.
+func (daemon *Daemon) advertise(container *Container) {
+	a := daemon.advertiser
+	if a == nil {
+		return
+	}
+	services := containerServices(container, a.Addrs())
+	a.Lock()
+	defer a.Unlock()
+	for _, s := range a.services[container.ID] {
+		a.Unregister(s.Instance, s.Type)
+	}
+	delete(a.services, container.ID)
+	for _, s := range services {
+		a.Register(s)
+	}
+	if len(services) > 0 {
+		a.services[container.ID] = services
+	}
+}
+
+// unadvertise stops advertising the ports of the container.
+func (daemon *Daemon) unadvertise(container *Container) {
+	a := daemon.advertiser
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	for _, s := range a.services[container.ID] {
+		a.Unregister(s.Instance, s.Type)
+	}
+	delete(a.services, container.ID)
+}
@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/nat"
+)
+
+func TestContainerServices(t *testing.T) {
+	container := &Container{
+		Name: "/web",
+		NetworkSettings: &NetworkSettings{Ports: nat.PortMap{
+			"80/tcp":   {{HostIp: "0.0.0.0", HostPort: "8080"}, {HostIp: "192.168.1.10", HostPort: "8080"}},
+			"8000/tcp": {{HostIp: "192.168.1.10", HostPort: "8000"}},
+			"9000/udp": {{HostIp: "", HostPort: "9000"}},
+			// Not reachable from the local network
+			"22/tcp": {{HostIp: "127.0.0.1", HostPort: "2222"}},
+			// Exposed only
+			"443/tcp": nil,
+		}},
+	}
+	services := containerServices(container, []net.IP{net.ParseIP("192.168.1.10")})
+	if len(services) != 3 {
+		t.Fatalf("Expected 3 services, got %d", len(services))
+	}
+	expected := []struct {
+		instance, serviceType string
+		port                  int
+	}{
+		{"web-80", "_http._tcp", 8080},
+		{"web-8000", "_http._tcp", 8000},
+		{"web", "_docker._udp", 9000},
+	}
+	for i, e := range expected {
+		s := services[i]
+		if s.Instance != e.instance || s.Type != e.serviceType || s.Port != e.port {
+			t.Fatalf("Expected %s.%s on port %d, got %s.%s on port %d", e.instance, e.serviceType, e.port, s.Instance, s.Type, s.Port)
+		}
+	}
+	if text := services[0].Text; len(text) != 2 || text[0] != "container=web" || text[1] != "port=80/tcp" {
+		t.Fatalf("Unexpected text %v", text)
+	}
+}
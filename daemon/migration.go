@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/utils"
+)
+
+// A container moves to another host in steps, its network handed over
+// along with it:
+//
+// 1. ContainerMigrationPrepare drains the container on the source host, its
+//    established connections going on, and writes the snapshot of its
+//    network;
+// 2. ContainerMigrationReserve sets up the network of the snapshot on the
+//    target host for a container created there, reserving its addresses and
+//    host ports and installing their rules, before it starts;
+// 3. once the container is stopped on the source and started on the target,
+//    ContainerMigrationCommit announces its addresses from the target, for
+//    the neighbors to send it its traffic there.
+//
+// ContainerMigrationAbort undoes the first two steps, on either host.
+
+// ContainerMigrationPrepare drains a running container about to move to
+// another host, and writes the snapshot of its network to be reserved there.
+func (daemon *Daemon) ContainerMigrationPrepare(job *engine.Job) engine.Status {
+	container, err := daemon.migratingContainer(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	container.Lock()
+	defer container.Unlock()
+	if !container.Running {
+		return job.Errorf("Cannot migrate the network of %s, it is not running", job.Args[0])
+	}
+
+	if !container.NetworkSettings.Draining {
+		drain := job.Eng.Job("drain_interface", container.ID)
+		drain.SetenvBool("Drain", true)
+		if err := drain.Run(); err != nil {
+			return job.Error(err)
+		}
+	}
+	// The snapshot is written once whole, for a failed export not to leave
+	// half a document behind
+	var snapshot bytes.Buffer
+	export := job.Eng.Job("export_interface", container.ID)
+	export.Stdout.Add(&snapshot)
+	if err := export.Run(); err != nil {
+		container.undrain(job.Eng)
+		return job.Error(err)
+	}
+	container.NetworkSettings.Draining = true
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	if _, err := snapshot.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	container.LogEvent("migrate-prepare")
+	return engine.StatusOK
+}
+
+// ContainerMigrationReserve sets up the network of the snapshot in
+// "Snapshot" for a container which is not running, for it to start with.
+// The addresses and host ports taken on this host are replaced by free
+// ones, or the reservation fails with "KeepIP" if the addresses are.
+func (daemon *Daemon) ContainerMigrationReserve(job *engine.Job) engine.Status {
+	container, err := daemon.migratingContainer(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	container.Lock()
+	defer container.Unlock()
+	if container.Running {
+		return job.Errorf("Cannot reserve the network of %s, it is running", job.Args[0])
+	}
+	if container.NetworkReserved {
+		return job.Errorf("The network of %s is already reserved", job.Args[0])
+	}
+
+	if err := container.importNetwork(job.Getenv("Snapshot"), job.GetenvBool("KeepIP")); err != nil {
+		return job.Error(err)
+	}
+	container.NetworkReserved = true
+	container.ImportedNetwork = ""
+	if err := container.toDisk(); err != nil {
+		container.releaseReservedNetwork(job.Eng)
+		return job.Error(err)
+	}
+	container.LogEvent("migrate-reserve")
+	return engine.StatusOK
+}
+
+// ContainerMigrationCommit announces the addresses of a container started
+// on this host with the network reserved for it.
+func (daemon *Daemon) ContainerMigrationCommit(job *engine.Job) engine.Status {
+	container, err := daemon.migratingContainer(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	container.Lock()
+	defer container.Unlock()
+	if !container.Running {
+		return job.Errorf("Cannot commit the migration of %s, it is not running", job.Args[0])
+	}
+	if container.NetworkSettings.Draining {
+		return job.Errorf("Cannot commit the migration of %s on the host it moves from", job.Args[0])
+	}
+
+	if err := job.Eng.Job("announce_interface", container.ID).Run(); err != nil {
+		return job.Error(err)
+	}
+	container.LogEvent("migrate-commit")
+	return engine.StatusOK
+}
+
+// ContainerMigrationAbort accepts the new connections to a container drained
+// on this host again, or releases the network reserved for a container on
+// this host.
+func (daemon *Daemon) ContainerMigrationAbort(job *engine.Job) engine.Status {
+	container, err := daemon.migratingContainer(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	container.Lock()
+	defer container.Unlock()
+
+	switch {
+	case container.Running && container.NetworkSettings.Draining:
+		if err := container.undrain(job.Eng); err != nil {
+			return job.Error(err)
+		}
+		container.NetworkSettings.Draining = false
+	case !container.Running && container.NetworkReserved:
+		container.releaseReservedNetwork(job.Eng)
+	default:
+		return job.Errorf("No migration of %s in progress", job.Args[0])
+	}
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	container.LogEvent("migrate-abort")
+	return engine.StatusOK
+}
+
+// migratingContainer returns the container of a migration job, with a
+// network of its own.
+func (daemon *Daemon) migratingContainer(job *engine.Job) (*Container, error) {
+	if len(job.Args) != 1 {
+		return nil, fmt.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return nil, fmt.Errorf("No such container: %s", name)
+	}
+	if container.Config.NetworkDisabled || !container.hostConfig.NetworkMode.IsPrivate() {
+		return nil, fmt.Errorf("Cannot migrate the network of %s, it has no network of its own", name)
+	}
+	return container, nil
+}
+
+func (container *Container) undrain(eng *engine.Engine) error {
+	drain := eng.Job("drain_interface", container.ID)
+	drain.SetenvBool("Drain", false)
+	return drain.Run()
+}
+
+// releaseReservedNetwork gives the network reserved for the container back.
+func (container *Container) releaseReservedNetwork(eng *engine.Engine) {
+	eng.Job("release_interface", container.ID).Run()
+	container.NetworkReserved = false
+	container.NetworkSettings = &NetworkSettings{}
+}
+
+// restoreReservedNetwork sets up the network reserved for the container
+// again after a restart of the daemon, or gives it up if it can't be.
+func (container *Container) restoreReservedNetwork() {
+	if !container.NetworkReserved || container.IsRunning() {
+		return
+	}
+	eng := container.daemon.eng
+	err := eng.Job("restore_interface", container.ID).Run()
+	if err == nil {
+		return
+	}
+	log.Infof("Unable to restore the network reserved for %s: %s", utils.TruncateID(container.ID), err)
+	container.releaseReservedNetwork(eng)
+	if err := container.toDisk(); err != nil {
+		log.Errorf("Unable to save %s: %s", utils.TruncateID(container.ID), err)
+	}
+}
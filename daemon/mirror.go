@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/utils"
+)
+
+// ContainerMirror copies all the traffic of a running container to the
+// interface of the running container "Target", such as a packet analyzer,
+// in place of any other it was copied to. The traffic is mirrored across
+// restarts of the daemon, until ContainerUnmirror or either container
+// stops.
+func (daemon *Daemon) ContainerMirror(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	targetName := job.Getenv("Target")
+	if targetName == "" {
+		return job.Errorf("Bad parameter: the container to mirror the traffic to is missing")
+	}
+	target := daemon.Get(targetName)
+	if target == nil {
+		return job.Errorf("No such container: %s", targetName)
+	}
+	if !target.IsRunning() {
+		return job.Errorf("Cannot mirror the traffic to %s, it is not running", targetName)
+	}
+	if target.Config.NetworkDisabled || !target.hostConfig.NetworkMode.IsPrivate() {
+		return job.Errorf("Cannot mirror the traffic to %s, it has no network of its own", targetName)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	if err := container.checkMirrored(name); err != nil {
+		return job.Error(err)
+	}
+	if container.NetworkSettings.MirroredTo == target.ID {
+		return engine.StatusOK
+	}
+
+	set := job.Eng.Job("set_mirror", container.ID)
+	set.Setenv("Target", target.ID)
+	if err := set.Run(); err != nil {
+		return job.Error(err)
+	}
+	container.NetworkSettings.MirroredTo = target.ID
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	container.LogEvent("mirror")
+	return engine.StatusOK
+}
+
+// ContainerUnmirror stops copying the traffic of a container mirrored by
+// ContainerMirror.
+func (daemon *Daemon) ContainerUnmirror(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	if err := container.checkMirrored(name); err != nil {
+		return job.Error(err)
+	}
+	if container.NetworkSettings.MirroredTo == "" {
+		return engine.StatusOK
+	}
+
+	if err := job.Eng.Job("set_mirror", container.ID).Run(); err != nil {
+		return job.Error(err)
+	}
+	container.NetworkSettings.MirroredTo = ""
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	container.LogEvent("unmirror")
+	return engine.StatusOK
+}
+
+// checkMirrored tells whether the traffic of the container can be mirrored.
+func (container *Container) checkMirrored(name string) error {
+	if !container.Running {
+		return fmt.Errorf("Cannot mirror the traffic of %s, it is not running", name)
+	}
+	if container.Config.NetworkDisabled || !container.hostConfig.NetworkMode.IsPrivate() {
+		return fmt.Errorf("Cannot mirror the traffic of %s, it has no network of its own", name)
+	}
+	return nil
+}
+
+// releaseMirrors forgets the containers whose traffic was mirrored to the
+// container, the network driver stopping the mirrors as it releases its
+// network.
+func (daemon *Daemon) releaseMirrors(container *Container) {
+	for _, c := range daemon.List() {
+		if c == container || c.NetworkSettings.MirroredTo != container.ID {
+			continue
+		}
+		c.Lock()
+		if c.NetworkSettings.MirroredTo == container.ID {
+			c.NetworkSettings.MirroredTo = ""
+			if err := c.toDisk(); err != nil {
+				log.Errorf("Unable to save %s: %s", utils.TruncateID(c.ID), err)
+			}
+			c.LogEvent("unmirror")
+		}
+		c.Unlock()
+	}
+}
+
+// restoreMirror copies the traffic of the running container again after a
+// restart of the daemon, or gives the mirror up if it can't.
+func (container *Container) restoreMirror() {
+	if container.NetworkSettings.MirroredTo == "" {
+		return
+	}
+	eng := container.daemon.eng
+	set := eng.Job("set_mirror", container.ID)
+	set.Setenv("Target", container.NetworkSettings.MirroredTo)
+	err := set.Run()
+	if err == nil {
+		return
+	}
+	log.Infof("Unable to mirror the traffic of %s again: %s", utils.TruncateID(container.ID), err)
+	container.NetworkSettings.MirroredTo = ""
+	if err := container.toDisk(); err != nil {
+		log.Errorf("Unable to save %s: %s", utils.TruncateID(container.ID), err)
+	}
+}
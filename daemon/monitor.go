@@ -245,6 +245,10 @@ func (m *containerMonitor) callback(processConfig *execdriver.ProcessConfig, pid
 
 	m.container.setRunning(pid)
 
+	if err := m.container.attachNetwork(pid); err != nil {
+		log.Errorf("Error setting up the network of %s: %s", m.container.ID, err)
+	}
+
 	// signal that the process has started
 	// close channel only if not closed
 	select {
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"encoding/json"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/utils"
+)
+
+// ContainerNetworkExport writes the snapshot of the network of a running
+// container, its addresses, port mappings and settings, as a JSON document
+// to be imported by ContainerNetworkImport, on this host or another.
+func (daemon *Daemon) ContainerNetworkExport(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	mode := container.hostConfig.NetworkMode
+	if !container.Running {
+		return job.Errorf("Cannot export the network of %s, it is not running", name)
+	}
+	if container.Config.NetworkDisabled || !mode.IsPrivate() {
+		return job.Errorf("Cannot export the network of %s, it has no network of its own", name)
+	}
+	export := job.Eng.Job("export_interface", container.ID)
+	export.Stdout.Add(job.Stdout)
+	if err := export.Run(); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerNetworkImport gives a container which is not running the network
+// of the snapshot in "Snapshot", set up in place of a new one at its next
+// start. The addresses and host ports of the snapshot taken on this host
+// are replaced by free ones.
+func (daemon *Daemon) ContainerNetworkImport(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	snapshot := job.Getenv("Snapshot")
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(snapshot), &document); err != nil {
+		return job.Errorf("Bad parameter: invalid network snapshot: %s", err)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	mode := container.hostConfig.NetworkMode
+	if container.Running {
+		return job.Errorf("Cannot import the network of %s, it is running", name)
+	}
+	if container.Config.NetworkDisabled || !mode.IsPrivate() {
+		return job.Errorf("Cannot import the network of %s, it has no network of its own", name)
+	}
+	container.ImportedNetwork = snapshot
+	if err := container.toDisk(); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// importNetwork sets up the network of snapshot for the container, in place
+// of allocating a new one, failing rather than giving it other addresses
+// with keepIP.
+func (container *Container) importNetwork(snapshot string, keepIP bool) error {
+	job := container.daemon.eng.Job("import_interface", container.ID)
+	job.Setenv("Snapshot", snapshot)
+	job.SetenvBool("KeepIP", keepIP)
+	env, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var mappings []struct {
+		Proto         string
+		HostIP        string
+		HostPort      int
+		ContainerPort int
+	}
+	if err := env.GetJson("Ports", &mappings); err != nil {
+		container.daemon.eng.Job("release_interface", container.ID).Run()
+		return err
+	}
+	ports := make(nat.PortMap)
+	for _, m := range mappings {
+		port := nat.NewPort(m.Proto, strconv.Itoa(m.ContainerPort))
+		ports[port] = append(ports[port], nat.PortBinding{HostIp: m.HostIP, HostPort: strconv.Itoa(m.HostPort)})
+	}
+
+	container.NetworkSettings.PortMapping = nil
+	container.NetworkSettings.Ports = ports
+	container.NetworkSettings.Bridge = env.Get("Bridge")
+	container.NetworkSettings.HostInterfaceName = env.Get("HostInterfaceName")
+	container.NetworkSettings.IPAddress = env.Get("IP")
+	container.NetworkSettings.IPPrefixLen = env.GetInt("IPPrefixLen")
+	container.NetworkSettings.MacAddress = env.Get("MacAddress")
+	container.NetworkSettings.Gateway = env.Get("Gateway")
+	container.NetworkSettings.ServiceVIP = env.Get("ServiceVIP")
+	container.NetworkSettings.SecondaryIPAddresses = env.GetList("SecondaryIPs")
+	log.Infof("Imported the network of %s: %s", utils.TruncateID(container.ID), env.Get("IP"))
+	return nil
+}
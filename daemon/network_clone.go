@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/runconfig"
+)
+
+// A container created with NetworkFrom takes the network settings of
+// another one, for a new version of a service to be created alongside the
+// running one: its published ports, its DNS settings, its links, network
+// mode and service. The settings given for the new container win over
+// those of the other one, and the new container gets addresses of its own
+// when it starts, its ip being neither shared nor reserved.
+
+// cloneNetwork copies the network settings of the container NetworkFrom
+// into hostConfig, the settings of container.
+func (daemon *Daemon) cloneNetwork(container *Container, hostConfig *runconfig.HostConfig) error {
+	source := daemon.Get(hostConfig.NetworkFrom)
+	if source == nil {
+		return fmt.Errorf("No such container to copy the network of: %s", hostConfig.NetworkFrom)
+	}
+	if source.ID == container.ID {
+		return fmt.Errorf("Cannot copy the network of %s to itself", hostConfig.NetworkFrom)
+	}
+	cloneHostNetwork(hostConfig, source.hostConfig)
+	container.Config.ExposedPorts = mergeExposedPorts(container.Config.ExposedPorts, source.Config.ExposedPorts)
+	return nil
+}
+
+// cloneHostNetwork copies the network settings of src left unset in dst.
+// The bridge network being the default, any other network mode of src is
+// taken.
+func cloneHostNetwork(dst, src *runconfig.HostConfig) {
+	if len(dst.PortBindings) == 0 && len(src.PortBindings) > 0 {
+		dst.PortBindings = make(nat.PortMap, len(src.PortBindings))
+		for port, bindings := range src.PortBindings {
+			dst.PortBindings[port] = append([]nat.PortBinding(nil), bindings...)
+		}
+	}
+	dst.PublishAllPorts = dst.PublishAllPorts || src.PublishAllPorts
+	if len(dst.Dns) == 0 {
+		dst.Dns = append([]string(nil), src.Dns...)
+	}
+	if len(dst.DnsSearch) == 0 {
+		dst.DnsSearch = append([]string(nil), src.DnsSearch...)
+	}
+	if len(dst.ExtraHosts) == 0 {
+		dst.ExtraHosts = append([]string(nil), src.ExtraHosts...)
+	}
+	if len(dst.Links) == 0 {
+		dst.Links = append([]string(nil), src.Links...)
+	}
+	if dst.NetworkMode == "" || dst.NetworkMode == "bridge" {
+		dst.NetworkMode = src.NetworkMode
+	}
+	if dst.ServiceVIP == "" {
+		dst.ServiceVIP = src.ServiceVIP
+	}
+	dst.PublishUpstream = dst.PublishUpstream || src.PublishUpstream
+	if dst.PublishTTL == "" {
+		dst.PublishTTL = src.PublishTTL
+	}
+	if len(dst.PortNames) == 0 && len(src.PortNames) > 0 {
+		dst.PortNames = make(map[string]string, len(src.PortNames))
+		for port, name := range src.PortNames {
+			dst.PortNames[port] = name
+		}
+	}
+	if len(dst.PortLabels) == 0 && len(src.PortLabels) > 0 {
+		dst.PortLabels = make(map[string]string, len(src.PortLabels))
+		for key, value := range src.PortLabels {
+			dst.PortLabels[key] = value
+		}
+	}
+	if len(dst.NoTrackPorts) == 0 {
+		dst.NoTrackPorts = append([]string(nil), src.NoTrackPorts...)
+	}
+	if dst.Mtu == 0 {
+		dst.Mtu = src.Mtu
+	}
+	if dst.MaxPorts == 0 {
+		dst.MaxPorts = src.MaxPorts
+	}
+	if dst.MaxAddresses == 0 {
+		dst.MaxAddresses = src.MaxAddresses
+	}
+	if len(dst.Sysctls) == 0 && len(src.Sysctls) > 0 {
+		dst.Sysctls = make(map[string]string, len(src.Sysctls))
+		for key, value := range src.Sysctls {
+			dst.Sysctls[key] = value
+		}
+	}
+}
+
+// mergeExposedPorts returns the ports of dst along with those of src.
+func mergeExposedPorts(dst, src map[nat.Port]struct{}) map[nat.Port]struct{} {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[nat.Port]struct{}, len(src))
+	}
+	for port := range src {
+		dst[port] = struct{}{}
+	}
+	return dst
+}
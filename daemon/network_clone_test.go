@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/runconfig"
+)
+
+func TestCloneHostNetwork(t *testing.T) {
+	src := &runconfig.HostConfig{
+		PortBindings: nat.PortMap{"80/tcp": []nat.PortBinding{{HostIp: "127.0.0.1", HostPort: "8080"}}},
+		Dns:          []string{"10.0.0.53"},
+		DnsSearch:    []string{"example.com"},
+		Links:        []string{"/db:db"},
+		NetworkMode:  "bridge",
+		ServiceVIP:   "web",
+		PortNames:    map[string]string{"80/tcp": "http"},
+		Mtu:          1400,
+		MaxPorts:     4,
+		StickyIP:     true,
+		NetDevices:   []string{"eth1"},
+	}
+	dst := &runconfig.HostConfig{NetworkMode: "bridge", Dns: []string{"8.8.8.8"}}
+	cloneHostNetwork(dst, src)
+
+	if len(dst.PortBindings["80/tcp"]) != 1 || dst.PortBindings["80/tcp"][0].HostPort != "8080" {
+		t.Fatalf("Expected the published ports to be copied, got %v", dst.PortBindings)
+	}
+	if len(dst.Dns) != 1 || dst.Dns[0] != "8.8.8.8" {
+		t.Fatalf("Expected the DNS servers given to be kept, got %v", dst.Dns)
+	}
+	if len(dst.DnsSearch) != 1 || len(dst.Links) != 1 || dst.ServiceVIP != "web" || dst.PortNames["80/tcp"] != "http" || dst.Mtu != 1400 || dst.MaxPorts != 4 {
+		t.Fatalf("Expected the network settings to be copied, got %+v", dst)
+	}
+	if dst.StickyIP || len(dst.NetDevices) != 0 {
+		t.Fatalf("Expected the addresses and the interfaces of the container not to be shared, got %+v", dst)
+	}
+	dst.PortBindings["80/tcp"][0].HostPort = "8081"
+	if src.PortBindings["80/tcp"][0].HostPort != "8080" {
+		t.Fatal("Expected the published ports to be copies")
+	}
+
+	exposed := mergeExposedPorts(map[nat.Port]struct{}{"443/tcp": {}}, map[nat.Port]struct{}{"80/tcp": {}})
+	if len(exposed) != 2 {
+		t.Fatalf("Expected the ports exposed by both containers, got %v", exposed)
+	}
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/opts"
+)
+
+// dnsSettings returns the dns servers and search domains given to the
+// containers which don't set their own.
+func (daemon *Daemon) dnsSettings() (dns, dnsSearch []string) {
+	daemon.dnsLock.Lock()
+	defer daemon.dnsLock.Unlock()
+	return daemon.config.Dns, daemon.config.DnsSearch
+}
+
+// NetworkConfig changes the networking settings given, for the containers
+// started from then on: the Dns servers and DnsSearch domains, as well as
+// the PortRange and the DefaultBindingIP of the published ports. The running
+// containers are left alone, but for the MulticastSnooping and the
+// MulticastQuerier of the bridge. It writes the current settings.
+func (daemon *Daemon) NetworkConfig(job *engine.Job) engine.Status {
+	dns, dnsSearch := daemon.dnsSettings()
+	if job.EnvExists("Dns") {
+		dns = nil
+		for _, ip := range job.GetenvList("Dns") {
+			ip, err := opts.ValidateIPAddress(ip)
+			if err != nil {
+				return job.Error(err)
+			}
+			dns = append(dns, ip)
+		}
+	}
+	if job.EnvExists("DnsSearch") {
+		dnsSearch = nil
+		for _, domain := range job.GetenvList("DnsSearch") {
+			domain, err := opts.ValidateDnsSearch(domain)
+			if err != nil {
+				return job.Error(err)
+			}
+			dnsSearch = append(dnsSearch, domain)
+		}
+	}
+
+	out := &engine.Env{}
+	if !daemon.config.DisableNetwork {
+		driver := job.Eng.Job("configure_network")
+		driver.Setenv("PortRange", job.Getenv("PortRange"))
+		driver.Setenv("DefaultBindingIP", job.Getenv("DefaultBindingIP"))
+		for _, key := range []string{"MulticastSnooping", "MulticastQuerier"} {
+			if job.EnvExists(key) {
+				driver.SetenvBool(key, job.GetenvBool(key))
+			}
+		}
+		settings, err := driver.Stdout.AddEnv()
+		if err != nil {
+			return job.Error(err)
+		}
+		if err := driver.Run(); err != nil {
+			return job.Error(err)
+		}
+		out = settings
+	} else if job.Getenv("PortRange") != "" || job.Getenv("DefaultBindingIP") != "" || job.EnvExists("MulticastSnooping") || job.EnvExists("MulticastQuerier") {
+		return job.Errorf("The networking of the containers is disabled")
+	}
+
+	daemon.dnsLock.Lock()
+	daemon.config.Dns, daemon.config.DnsSearch = dns, dnsSearch
+	daemon.dnsLock.Unlock()
+
+	out.SetList("Dns", dns)
+	out.SetList("DnsSearch", dnsSearch)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
@@ -9,13 +9,22 @@ import (
 type PortMapping map[string]string // Deprecated
 
 type NetworkSettings struct {
-	IPAddress   string
-	IPPrefixLen int
-	MacAddress  string
-	Gateway     string
-	Bridge      string
-	PortMapping map[string]PortMapping // Deprecated
-	Ports       nat.PortMap
+	IPAddress         string
+	IPPrefixLen       int
+	MacAddress        string
+	Gateway           string
+	Bridge            string
+	HostInterfaceName string                 // host side of the veth pair, empty for a name chosen when the container starts
+	PortMapping       map[string]PortMapping // Deprecated
+	Ports             nat.PortMap
+	ServiceVIP        string // VIP of the service the container backs, empty if none
+	PortsExpire       string // RFC 3339 time the published ports expire at, empty for never
+
+	SecondaryIPAddresses []string // added to the interface of the running container
+	NetnsPath            string   // network namespace of the running container, for ip netns and the like
+	Blackholed           bool     // all the traffic of the running container is dropped
+	Draining             bool     // the new connections to the running container are refused, as it moves to another host
+	MirroredTo           string   // container the traffic of the running container is copied to, empty if none
 }
 
 func (settings *NetworkSettings) PortMappingAPI() *engine.Table {
@@ -0,0 +1,240 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/proxy"
+)
+
+// trafficCounters are the counters of a container, Rx being the traffic to
+// the container and Tx the traffic from it.
+type trafficCounters struct {
+	RxBytes, RxPackets int64
+	TxBytes, TxPackets int64
+}
+
+// accountingJumps returns the jumps to the accounting chain, for the traffic
+// of the bridge only. The traffic between two containers goes through the
+// first one alone, not to be counted twice.
+func (d *Driver) accountingJumps() [][]string {
+	return [][]string{
+		{"FORWARD", "-i", d.bridgeIface, "-j", d.accountingChain},
+		{"FORWARD", "!", "-i", d.bridgeIface, "-o", d.bridgeIface, "-j", d.accountingChain},
+		{"INPUT", "-i", d.bridgeIface, "-j", d.accountingChain},
+		{"OUTPUT", "-o", d.bridgeIface, "-j", d.accountingChain},
+	}
+}
+
+func (d *Driver) accountingArgs(ip net.IP) [][]string {
+	return [][]string{
+		{d.accountingChain, "-s", ip.String()},
+		{d.accountingChain, "-d", ip.String()},
+	}
+}
+
+// removeAccountingChain removes the accounting chain. Errors are ignored,
+// the chain might not exist.
+func (d *Driver) removeAccountingChain() {
+	for _, args := range d.accountingJumps() {
+		d.firewall.Raw(false, append([]string{"-D"}, args...)...)
+	}
+	// The jump of all the forwarded traffic of the previous versions
+	d.firewall.Raw(false, "-D", "FORWARD", "-j", d.accountingChain)
+	d.firewall.Raw(false, "-F", d.accountingChain)
+	d.firewall.Raw(false, "-X", d.accountingChain)
+}
+
+// setupAccounting (re)creates an empty accounting chain.
+func (d *Driver) setupAccounting() error {
+	d.accountingLock.Lock()
+	defer d.accountingLock.Unlock()
+
+	return d.resetAccounting()
+}
+
+func (d *Driver) resetAccounting() error {
+	d.removeAccountingChain()
+	d.accountingReady = false
+
+	if err := d.execRule(false, "-N", d.accountingChain); err != nil {
+		return err
+	}
+	for _, args := range d.accountingJumps() {
+		if err := d.execRule(false, append([]string{"-I"}, args...)...); err != nil {
+			return err
+		}
+	}
+	d.accountingReady = true
+	return nil
+}
+
+// accountingSetUp tells whether the accounting chain is set up.
+func (d *Driver) accountingSetUp() bool {
+	d.accountingLock.Lock()
+	defer d.accountingLock.Unlock()
+
+	return d.accountingReady
+}
+
+// startAccounting starts counting the traffic of the container with the
+// given ip, setting up the accounting chain along with the first container
+// counted unless it was with the driver.
+func (d *Driver) startAccounting(ip net.IP) error {
+	d.accountingLock.Lock()
+	if !d.accountingReady {
+		if err := d.resetAccounting(); err != nil {
+			d.accountingLock.Unlock()
+			return err
+		}
+	}
+	d.accountingLock.Unlock()
+
+	for _, args := range d.accountingArgs(ip) {
+		if err := d.execRule(false, append([]string{"-A"}, args...)...); err != nil {
+			d.stopAccounting(ip)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) stopAccounting(ip net.IP) {
+	for _, args := range d.accountingArgs(ip) {
+		d.firewall.Raw(false, append([]string{"-D"}, args...)...)
+	}
+}
+
+// parseAccounting reads the counters of every container out of the
+// `iptables -v -S` listing of the accounting chain.
+func (d *Driver) parseAccounting(output string) map[string]*trafficCounters {
+	counters := make(map[string]*trafficCounters)
+	get := func(ip string) *trafficCounters {
+		ip = strings.TrimSuffix(ip, "/32")
+		if counters[ip] == nil {
+			counters[ip] = &trafficCounters{}
+		}
+		return counters[ip]
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != d.accountingChain {
+			continue
+		}
+		var (
+			src, dst       string
+			packets, bytes int64
+			err1, err2     error
+			hasCounters    bool
+		)
+		for i := 2; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "-s":
+				src = fields[i+1]
+			case "-d":
+				dst = fields[i+1]
+			case "-c":
+				if i+2 < len(fields) {
+					packets, err1 = strconv.ParseInt(fields[i+1], 10, 64)
+					bytes, err2 = strconv.ParseInt(fields[i+2], 10, 64)
+					hasCounters = err1 == nil && err2 == nil
+				}
+			}
+		}
+		if !hasCounters {
+			continue
+		}
+		if src != "" {
+			c := get(src)
+			c.TxPackets += packets
+			c.TxBytes += bytes
+		}
+		if dst != "" {
+			c := get(dst)
+			c.RxPackets += packets
+			c.RxBytes += bytes
+		}
+	}
+	return counters
+}
+
+func (d *Driver) readAccounting() (map[string]*trafficCounters, error) {
+	output, err := d.firewall.Raw(false, "-v", "-S", d.accountingChain)
+	if err != nil {
+		return nil, err
+	}
+	return d.parseAccounting(string(output)), nil
+}
+
+// NetworkStats returns the traffic counters of a container accounted: the
+// bytes and packets it received (RxBytes, RxPackets) and sent (TxBytes,
+// TxPackets) since its interface was allocated, along with the Latency of the
+// connections to its published TCP ports through the userland proxies.
+func (d *Driver) NetworkStats(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if !network.Accounted {
+		return job.Errorf("The traffic of %s is not counted, network accounting is enabled with --network-accounting", id)
+	}
+
+	counters, err := d.readAccounting()
+	if err != nil {
+		return job.Error(err)
+	}
+	c, ok := counters[network.IP.String()]
+	if !ok {
+		return job.Errorf("No traffic counters for %s", network.IP)
+	}
+
+	out := engine.Env{}
+	out.SetInt64("RxBytes", c.RxBytes)
+	out.SetInt64("RxPackets", c.RxPackets)
+	out.SetInt64("TxBytes", c.TxBytes)
+	out.SetInt64("TxPackets", c.TxPackets)
+	if err := out.SetJson("Latency", mappingsLatency(d.hostMappings(network))); err != nil {
+		return job.Error(err)
+	}
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// mappingLatency is the latency of the connections to a published port.
+type mappingLatency struct {
+	HostIP    string
+	HostPort  int
+	Container string
+	Connect   proxy.Histogram
+	FirstByte proxy.Histogram
+}
+
+// mappingsLatency returns the latency of the connections to the host ports
+// of mappings, as reported by their userland proxies so far.
+func mappingsLatency(mappings []net.Addr) []mappingLatency {
+	out := []mappingLatency{}
+	for _, nat := range mappings {
+		m, err := portmapper.Lookup(nat)
+		if err != nil || m.Latency == nil {
+			continue
+		}
+		out = append(out, mappingLatency{
+			HostIP:    hostAddrIP(nat).String(),
+			HostPort:  mappedHostPort(nat).Port,
+			Container: m.Container,
+			Connect:   m.Latency.Connect,
+			FirstByte: m.Latency.FirstByte,
+		})
+	}
+	return out
+}
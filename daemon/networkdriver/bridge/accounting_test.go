@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseAccounting(t *testing.T) {
+	d := newDriver(&Config{})
+	output := `-N DOCKER-ACCT
+-A DOCKER-ACCT -s 172.17.0.2/32 -c 10 840
+-A DOCKER-ACCT -d 172.17.0.2/32 -c 12 15000
+-A DOCKER-ACCT -s 172.17.0.3/32 -c 0 0
+-A DOCKER-ACCT -d 172.17.0.3/32 -c 4 256
+-A FORWARD -s 172.17.0.4/32 -c 1 1
+`
+	counters := d.parseAccounting(output)
+	if len(counters) != 2 {
+		t.Fatalf("Expected counters for 2 containers, got %d", len(counters))
+	}
+
+	c := counters["172.17.0.2"]
+	if c == nil {
+		t.Fatal("Expected counters for 172.17.0.2")
+	}
+	if c.TxPackets != 10 || c.TxBytes != 840 || c.RxPackets != 12 || c.RxBytes != 15000 {
+		t.Fatalf("Unexpected counters %+v", c)
+	}
+
+	c = counters["172.17.0.3"]
+	if c == nil {
+		t.Fatal("Expected counters for 172.17.0.3")
+	}
+	if c.TxPackets != 0 || c.RxPackets != 4 || c.RxBytes != 256 {
+		t.Fatalf("Unexpected counters %+v", c)
+	}
+}
+
+func TestStartAccounting(t *testing.T) {
+	d := newDriver(&Config{EnableIptables: true})
+
+	dryRunDriver(d)
+
+	// The chain is set up along with the first container counted
+	if d.accountingSetUp() {
+		t.Fatal("Expected no accounting chain before any container is counted")
+	}
+	if err := d.startAccounting(net.ParseIP("172.17.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.startAccounting(net.ParseIP("172.17.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	if n := strings.Count(plan, "-N DOCKER-ACCT"); n != 1 {
+		t.Fatalf("Expected the chain to be set up once, got %d times:\n%s", n, plan)
+	}
+	for _, rule := range []string{
+		"-I FORWARD -i docker0 -j DOCKER-ACCT",
+		"-I FORWARD ! -i docker0 -o docker0 -j DOCKER-ACCT",
+		"-A DOCKER-ACCT -s 172.17.0.3",
+	} {
+		if !strings.Contains(plan, rule) {
+			t.Fatalf("Expected %q, got:\n%s", rule, plan)
+		}
+	}
+	if strings.Contains(plan, "-I FORWARD -j DOCKER-ACCT") {
+		t.Fatalf("Expected the forwarded traffic of the bridge only to be counted, got:\n%s", plan)
+	}
+}
@@ -0,0 +1,162 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+// activity is the last network activity seen of a container, for the daemon
+// to park the idle ones. The activity is sampled when asked for, so it is
+// known at the resolution of the network_activity jobs.
+type activity struct {
+	packets        int64                // counted by the accounting, in both directions
+	lastActive     time.Time            // last change of packets
+	connections    map[string]uint64    // counted by the DNAT rules, by mapping
+	lastConnection map[string]time.Time // by mapping, ex: "tcp/0.0.0.0:8080"
+}
+
+// activityResolution is the age under which the activity is not sampled
+// again, the daemon asking for that of each container in turn.
+const activityResolution = time.Second
+
+type activities struct {
+	sync.Mutex
+	c       map[string]*activity // by container id
+	sampled time.Time
+}
+
+// connectionCounters reads the connections to the port mappings out of the
+// `iptables -t nat -v -S` listing of the chain of the driver, by container
+// ip and mapping.
+func connectionCounters(natOutput string) map[string]map[string]uint64 {
+	connections := make(map[string]map[string]uint64)
+	for _, line := range strings.Split(natOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		opts, packets, _, ok := ruleCounters(fields[2:])
+		if !ok || opts["-j"] != "DNAT" {
+			continue
+		}
+		containerIP, _, err := net.SplitHostPort(opts["--to-destination"])
+		if err != nil {
+			continue
+		}
+		hostIP := strings.TrimSuffix(opts["-d"], "/32")
+		if hostIP == "" || hostIP == "0.0.0.0/0" {
+			hostIP = "0.0.0.0"
+		}
+		if connections[containerIP] == nil {
+			connections[containerIP] = make(map[string]uint64)
+		}
+		connections[containerIP][opts["-p"]+"/"+net.JoinHostPort(hostIP, opts["--dport"])] += packets
+	}
+	return connections
+}
+
+// updateActivity records the activity of the accounted containers given
+// their traffic and connection counters, read at now. A container is active
+// as soon as its counters change, starting with the first time it is seen.
+func (d *Driver) updateActivity(now time.Time, counters map[string]*trafficCounters, connections map[string]map[string]uint64) {
+	d.activity.Lock()
+	defer d.activity.Unlock()
+
+	d.activity.sampled = now
+	current := d.currentInterfaces.All()
+	for id := range d.activity.c {
+		if _, exists := current[id]; !exists {
+			delete(d.activity.c, id)
+		}
+	}
+	for id, iface := range current {
+		if !iface.Accounted {
+			continue
+		}
+		a := d.activity.c[id]
+		if a == nil {
+			a = &activity{
+				lastActive:     now,
+				connections:    make(map[string]uint64),
+				lastConnection: make(map[string]time.Time),
+			}
+			d.activity.c[id] = a
+		}
+		ip := iface.IP.String()
+		var packets int64
+		if c := counters[ip]; c != nil {
+			packets = c.RxPackets + c.TxPackets
+		}
+		if packets != a.packets {
+			a.packets = packets
+			a.lastActive = now
+		}
+		for mapping, n := range connections[ip] {
+			// The counters restart along with the rules reinstalled
+			if n != a.connections[mapping] && n > 0 {
+				a.lastConnection[mapping] = now
+				a.lastActive = now
+			}
+			a.connections[mapping] = n
+		}
+	}
+}
+
+// sampleActivity reads the counters of the containers and records their
+// activity, unless it was just done.
+func (d *Driver) sampleActivity() error {
+	d.activity.Lock()
+	recent := time.Since(d.activity.sampled) < activityResolution
+	d.activity.Unlock()
+	if recent {
+		return nil
+	}
+	counters, err := d.readAccounting()
+	if err != nil {
+		return err
+	}
+	natOutput, err := d.firewall.Raw(false, "-t", "nat", "-v", "-S", d.chain)
+	if err != nil {
+		return err
+	}
+	d.updateActivity(time.Now(), counters, connectionCounters(string(natOutput)))
+	return nil
+}
+
+// NetworkActivity returns the last network activity of a container: the
+// last time it sent or received traffic (LastActive) and the last
+// connection to each of its port mappings (LastConnections), as seen since
+// the previous network_activity job.
+func (d *Driver) NetworkActivity(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if !network.Accounted {
+		return job.Errorf("The traffic of %s is not counted, network accounting is enabled with --network-accounting or a park policy", id)
+	}
+	if err := d.sampleActivity(); err != nil {
+		return job.Error(err)
+	}
+
+	d.activity.Lock()
+	a := d.activity.c[id]
+	out := engine.Env{}
+	if a != nil {
+		out.Set("LastActive", a.lastActive.Format(time.RFC3339Nano))
+		out.SetJson("LastConnections", a.lastConnection)
+	}
+	d.activity.Unlock()
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
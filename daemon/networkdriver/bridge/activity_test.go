@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionCounters(t *testing.T) {
+	output := `-N DOCKER
+-A DOCKER -d 127.0.0.1/32 ! -i docker0 -p tcp -m tcp --dport 8080 -c 3 180 -j DNAT --to-destination 172.17.0.2:80
+-A DOCKER ! -i docker0 -p tcp -m tcp --dport 8443 -c 0 0 -j DNAT --to-destination 172.17.0.2:443
+-A DOCKER ! -i docker0 -p udp -m udp --dport 5353 -c 7 420 -j DNAT --to-destination 172.17.0.3:53
+-A DOCKER -i docker0 -c 2 120 -j RETURN
+`
+	connections := connectionCounters(output)
+	if len(connections) != 2 {
+		t.Fatalf("Expected the connections of 2 containers, got %v", connections)
+	}
+	if n := connections["172.17.0.2"]["tcp/127.0.0.1:8080"]; n != 3 {
+		t.Fatalf("Expected 3 connections to tcp/127.0.0.1:8080, got %d", n)
+	}
+	if n, exists := connections["172.17.0.2"]["tcp/0.0.0.0:8443"]; !exists || n != 0 {
+		t.Fatalf("Expected no connection to tcp/0.0.0.0:8443, got %v", connections["172.17.0.2"])
+	}
+	if n := connections["172.17.0.3"]["udp/0.0.0.0:5353"]; n != 7 {
+		t.Fatalf("Expected 7 connections to udp/0.0.0.0:5353, got %d", n)
+	}
+}
+
+func TestUpdateActivity(t *testing.T) {
+	d := newDriver(&Config{})
+	d.currentInterfaces.Set("accounted", &networkInterface{IP: net.ParseIP("172.17.0.2"), Accounted: true})
+	d.currentInterfaces.Set("unaccounted", &networkInterface{IP: net.ParseIP("172.17.0.3")})
+
+	var (
+		start    = time.Now()
+		mapping  = "tcp/0.0.0.0:8080"
+		counters = map[string]*trafficCounters{"172.17.0.2": {RxPackets: 4, TxPackets: 2}}
+		conns    = map[string]map[string]uint64{"172.17.0.2": {mapping: 1}}
+	)
+	d.updateActivity(start, counters, conns)
+	a := d.activity.c["accounted"]
+	if a == nil || !a.lastActive.Equal(start) || !a.lastConnection[mapping].Equal(start) {
+		t.Fatalf("Expected the container to be active at first sight, got %+v", a)
+	}
+	if _, exists := d.activity.c["unaccounted"]; exists {
+		t.Fatal("Expected no activity for a container whose traffic isn't counted")
+	}
+
+	// Idle
+	d.updateActivity(start.Add(time.Minute), counters, conns)
+	if !a.lastActive.Equal(start) {
+		t.Fatalf("Expected the container to have been idle since %s, got %s", start, a.lastActive)
+	}
+
+	// Traffic without new connections
+	later := start.Add(2 * time.Minute)
+	counters["172.17.0.2"].TxPackets++
+	d.updateActivity(later, counters, conns)
+	if !a.lastActive.Equal(later) || !a.lastConnection[mapping].Equal(start) {
+		t.Fatalf("Expected traffic at %s and no new connection, got %+v", later, a)
+	}
+
+	// A new connection
+	latest := start.Add(3 * time.Minute)
+	conns["172.17.0.2"][mapping] = 2
+	counters["172.17.0.2"].RxPackets++
+	d.updateActivity(latest, counters, conns)
+	if !a.lastActive.Equal(latest) || !a.lastConnection[mapping].Equal(latest) {
+		t.Fatalf("Expected a connection at %s, got %+v", latest, a)
+	}
+
+	// The activity of the released containers is forgotten
+	d.currentInterfaces.Delete("accounted")
+	d.updateActivity(latest, counters, conns)
+	if len(d.activity.c) != 0 {
+		t.Fatalf("Expected the activity of the released container to be forgotten, got %v", d.activity.c)
+	}
+}
@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/engine"
+)
+
+// A container can have secondary addresses of the bridge network on its
+// interface, for the virtual hosting or the FTP servers needing several.
+// They are leased from the ip allocator as the primary one, added to the
+// interface in the namespace of the container once it runs, and released
+// along with the interface.
+
+// containerIface is the name of the interface of the containers, in their
+// namespace.
+const containerIface = "eth0"
+
+// AddAddress gives the interface of a container a secondary address, the
+// RequestedIP or any free address of the bridge network, and writes it as
+// IP. The address is added right away to a running container.
+func (d *Driver) AddAddress(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	if d.config.Rootless {
+		return job.Errorf("The rootless network has no secondary address")
+	}
+	iface := d.currentInterfaces.Get(id)
+	if iface == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if limit := d.addressLimit(iface); limit > 0 && len(iface.SecondaryIPs) >= limit {
+		return job.Errorf("%s can't have more than %d secondary addresses", id, limit)
+	}
+
+	var requestedIP net.IP
+	if requested := job.Getenv("RequestedIP"); requested != "" {
+		if requestedIP = net.ParseIP(requested); requestedIP == nil || requestedIP.To4() == nil {
+			return job.Errorf("Invalid secondary address %s, it must be an IPv4 address", requested)
+		}
+	}
+	ip, err := ipallocator.RequestIP(d.bridgeNetwork, requestedIP)
+	if err != nil {
+		return job.Error(err)
+	}
+	if iface.Pid != 0 {
+		if err := d.setSecondaryAddress(iface.Pid, ip); err != nil {
+			ipallocator.ReleaseIP(d.bridgeNetwork, ip)
+			return job.Error(err)
+		}
+	}
+	if iface.Blackholed {
+		if err := d.blackholeIP(ip); err != nil {
+			ipallocator.ReleaseIP(d.bridgeNetwork, ip)
+			return job.Error(err)
+		}
+	}
+	if iface.Draining {
+		if err := d.drainIP(ip); err != nil {
+			if iface.Blackholed {
+				d.removeBlackholeIP(ip, true)
+			}
+			ipallocator.ReleaseIP(d.bridgeNetwork, ip)
+			return job.Error(err)
+		}
+	}
+	iface.SecondaryIPs = append(iface.SecondaryIPs, ip)
+
+	// Restored with the same address
+	d.saveJob(id, "add_address", map[string]string{"RequestedIP": ip.String()})
+	d.logEvent(job.Eng, eventAddress, id, ip.String())
+
+	out := engine.Env{}
+	out.Set("IP", ip.String())
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// setSecondaryAddress adds ip to the interface in the network namespace of
+// the process pid.
+func (d *Driver) setSecondaryAddress(pid int, ip net.IP) error {
+	size, _ := d.bridgeNetwork.Mask.Size()
+	addr := fmt.Sprintf("%s/%d", ip, size)
+	if err := d.runCommand("nsenter", "--net=/proc/"+strconv.Itoa(pid)+"/ns/net", "ip", "addr", "add", addr, "dev", containerIface); err != nil {
+		return fmt.Errorf("Unable to add the address %s to the container: %s", ip, err)
+	}
+	return nil
+}
+
+// attachSecondaryAddresses adds the secondary addresses of iface to the
+// running container, of process pid.
+func (d *Driver) attachSecondaryAddresses(iface *networkInterface, pid int) error {
+	iface.Pid = pid
+	for _, ip := range iface.SecondaryIPs {
+		if err := d.setSecondaryAddress(pid, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) releaseSecondaryAddresses(iface *networkInterface) {
+	for _, ip := range iface.SecondaryIPs {
+		if err := ipallocator.ReleaseIP(d.bridgeNetwork, ip); err != nil {
+			log.Infof("Unable to release the secondary ip %s: %s", ip, err)
+		}
+	}
+	iface.SecondaryIPs = nil
+}
@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/engine"
+)
+
+func TestAddAddress(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	if res := d.Allocate(eng.Job("allocate_interface", "address_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+
+	job := eng.Job("add_address", "address_container")
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP(out.Get("IP"))
+	iface := d.currentInterfaces.Get("address_container")
+	if ip == nil || !d.bridgeNetwork.Contains(ip) || ip.Equal(iface.IP) {
+		t.Fatalf("Expected a secondary address of the bridge network, got %s", out.Get("IP"))
+	}
+	if len(iface.SecondaryIPs) != 1 || !iface.SecondaryIPs[0].Equal(ip) {
+		t.Fatalf("Expected the secondary address %s, got %v", ip, iface.SecondaryIPs)
+	}
+
+	// The address is leased
+	job = eng.Job("add_address", "address_container")
+	job.Setenv("RequestedIP", ip.String())
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected the address to be taken already")
+	}
+	job = eng.Job("add_address", "address_container")
+	job.Setenv("RequestedIP", "fe80::1")
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected an IPv6 address to be refused")
+	}
+
+	d.saved.Lock()
+	jobs := d.saved.jobs["address_container"]
+	d.saved.Unlock()
+	if len(jobs) != 2 || jobs[1].Name != "add_address" || jobs[1].Env["RequestedIP"] != ip.String() {
+		t.Fatalf("Expected the secondary address to be saved, got %v", jobs)
+	}
+
+	if err := eng.Job("release_interface", "address_container").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipallocator.RequestIP(d.bridgeNetwork, ip); err != nil {
+		t.Fatalf("Expected the secondary address to be released: %s", err)
+	}
+	ipallocator.ReleaseIP(d.bridgeNetwork, ip)
+}
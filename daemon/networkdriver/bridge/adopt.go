@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// savedMappings returns the DNAT rules expected for the port mappings of the
+// saved interfaces, as their protocol, host port and destination.
+func (d *Driver) savedMappings() map[string]bool {
+	d.saved.Lock()
+	defer d.saved.Unlock()
+
+	known := make(map[string]bool)
+	for _, jobs := range d.saved.jobs {
+		if len(jobs) == 0 || jobs[0].Name != "allocate_interface" {
+			continue
+		}
+		ip := jobs[0].Env["RequestedIP"]
+		for _, j := range jobs[1:] {
+			if j.Name == "allocate_port" {
+				known[j.Env["Proto"]+" "+j.Env["HostPort"]+" "+net.JoinHostPort(ip, j.Env["ContainerPort"])] = true
+			}
+		}
+	}
+	return known
+}
+
+// orphanRules returns the rules of an `iptables -S` listing of the chain of
+// the port mappings which don't belong to any of the known mappings.
+func (d *Driver) orphanRules(listing string, known map[string]bool) [][]string {
+	var orphans [][]string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != d.chain {
+			continue
+		}
+		opts, _, _, _ := ruleCounters(fields[2:])
+		if opts["-j"] == "DNAT" && known[opts["-p"]+" "+opts["--dport"]+" "+opts["--to-destination"]] {
+			continue
+		}
+		orphans = append(orphans, fields[2:])
+	}
+	return orphans
+}
+
+// adoptChain takes over the chain of the port mappings left by a previous
+// daemon instead of recreating it: the rules of the mappings of the saved
+// interfaces are kept, so that their traffic goes on while the daemon
+// restarts, and only the orphans are removed, along with the FORWARD rules
+// letting their traffic through. It returns nil if there is no chain to
+// adopt.
+func (d *Driver) adoptChain(config *Config) (*iptables.Chain, error) {
+	chain, err := d.firewall.AdoptChain(config.UseIpv6, d.chain, d.bridgeIface, config.PublishIfaces)
+	if err != nil || chain == nil {
+		return chain, err
+	}
+	listing, err := d.firewall.Raw(config.UseIpv6, "-t", "nat", "-S", d.chain)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := d.orphanRules(string(listing), d.savedMappings())
+	for _, rule := range orphans {
+		log.Infof("Removing the orphaned rule %s of %s", strings.Join(rule, " "), d.chain)
+		d.firewall.Raw(config.UseIpv6, append([]string{"-t", "nat", "-D", d.chain}, rule...)...)
+
+		opts, _, _, _ := ruleCounters(rule)
+		if host, port, err := net.SplitHostPort(opts["--to-destination"]); err == nil && opts["-j"] == "DNAT" {
+			d.firewall.Raw(config.UseIpv6, "-D", "FORWARD", "!", "-i", d.bridgeIface, "-o", d.bridgeIface,
+				"-p", opts["-p"], "-d", host, "--dport", port, "-j", "ACCEPT")
+		}
+	}
+	log.Infof("Adopted the iptables chain %s, %d orphaned rules removed", d.chain, len(orphans))
+	return chain, nil
+}
@@ -0,0 +1,25 @@
+package bridge
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrphanRules(t *testing.T) {
+	d := newDriver(&Config{})
+	d.saved.jobs["adopted"] = []savedJob{
+		{Name: "allocate_interface", Env: map[string]string{"RequestedIP": "172.17.0.2"}},
+		{Name: "allocate_port", Env: map[string]string{"Proto": "tcp", "HostPort": "8080", "ContainerPort": "80"}},
+	}
+
+	listing := `-N DOCKER
+-A DOCKER ! -i docker0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.17.0.2:80
+-A DOCKER ! -i docker0 -p tcp -m tcp --dport 8081 -j DNAT --to-destination 172.17.0.3:80
+`
+	orphans := d.orphanRules(listing, d.savedMappings())
+	expected := [][]string{strings.Fields("! -i docker0 -p tcp -m tcp --dport 8081 -j DNAT --to-destination 172.17.0.3:80")}
+	if !reflect.DeepEqual(orphans, expected) {
+		t.Fatalf("Expected the orphans to be %v, got %v", expected, orphans)
+	}
+}
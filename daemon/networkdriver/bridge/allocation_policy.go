@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/docker/daemon/networkdriver/policy"
+	"github.com/docker/docker/engine"
+)
+
+// preAllocateIP asks the policy engine, if any, about the ip requested for
+// the container, which it can change. It returns the range the ip must be
+// allocated from, nil for any.
+func (d *Driver) preAllocateIP(job *engine.Job, requestedIP *net.IP) (*net.IPNet, error) {
+	if d.policy == nil {
+		return nil, nil
+	}
+	resp, err := d.policy.PreAllocateIP(&policy.PreAllocateIPRequest{
+		Container:   job.Args[0],
+		Network:     d.bridgeNetwork.String(),
+		RequestedIP: job.Getenv("RequestedIP"),
+		Options:     job.Environ(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.RequestedIP != "" {
+		if *requestedIP = net.ParseIP(resp.RequestedIP); *requestedIP == nil {
+			return nil, fmt.Errorf("The network policy engine gave the invalid ip %s", resp.RequestedIP)
+		}
+	}
+	if resp.Range == "" {
+		return nil, nil
+	}
+	_, ipRange, err := net.ParseCIDR(resp.Range)
+	if err != nil {
+		return nil, fmt.Errorf("The network policy engine gave the invalid range %s: %s", resp.Range, err)
+	}
+	if !d.bridgeNetwork.Contains(ipRange.IP) {
+		return nil, fmt.Errorf("The range %s of the network policy is out of the network %s", ipRange, d.bridgeNetwork)
+	}
+	if *requestedIP != nil && !ipRange.Contains(*requestedIP) {
+		return nil, fmt.Errorf("The ip %s is out of the range %s of the network policy", *requestedIP, ipRange)
+	}
+	return ipRange, nil
+}
+
+// preAllocatePort asks the policy engine, if any, about the host ip and
+// port the container port is about to be published on, and returns those
+// to publish it on.
+func (d *Driver) preAllocatePort(job *engine.Job, ip net.IP, hostPort int) (net.IP, int, error) {
+	if d.policy == nil {
+		return ip, hostPort, nil
+	}
+	resp, err := d.policy.PreAllocatePort(&policy.PreAllocatePortRequest{
+		Container:     job.Args[0],
+		Proto:         job.Getenv("Proto"),
+		HostIP:        ip.String(),
+		HostPort:      hostPort,
+		ContainerPort: job.GetenvInt("ContainerPort"),
+		Options:       job.Environ(),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.HostIP != "" {
+		if ip = net.ParseIP(resp.HostIP); ip == nil {
+			return nil, 0, fmt.Errorf("The network policy engine gave the invalid host ip %s", resp.HostIP)
+		}
+		// The publishing interfaces still apply
+		if ip, err = d.restrictBindingIP(ip); err != nil {
+			return nil, 0, err
+		}
+	}
+	if resp.HostPort != 0 {
+		hostPort = resp.HostPort
+	}
+	return ip, hostPort, nil
+}
@@ -0,0 +1,145 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/policy"
+	"github.com/docker/docker/engine"
+)
+
+// NetworkPolicy gives the containers of the tenant "blue" the addresses of
+// a range of their own and publishes the ports on 127.0.0.1, denying those
+// of the container "closed_container", and the container ports 22 once
+// published.
+type NetworkPolicy struct {
+	blueRange string
+}
+
+func (p *NetworkPolicy) PreAllocateIP(req *policy.PreAllocateIPRequest, resp *policy.PreAllocateIPResponse) error {
+	if req.Options["Tenant"] == "blue" {
+		resp.Range = p.blueRange
+	}
+	return nil
+}
+
+func (p *NetworkPolicy) PostAllocateIP(req *policy.PostAllocateIPRequest, resp *policy.PostAllocateIPResponse) error {
+	return nil
+}
+
+func (p *NetworkPolicy) PreAllocatePort(req *policy.PreAllocatePortRequest, resp *policy.PreAllocatePortResponse) error {
+	if req.Container == "closed_container" {
+		resp.Deny = "no ports for you"
+	}
+	resp.HostIP = "127.0.0.1"
+	return nil
+}
+
+func (p *NetworkPolicy) PostAllocatePort(req *policy.PostAllocatePortRequest, resp *policy.PostAllocatePortResponse) error {
+	if req.ContainerPort == 22 {
+		resp.Deny = "no ssh"
+	}
+	return nil
+}
+
+func servePolicy(t *testing.T, p *NetworkPolicy) (string, func()) {
+	dir, err := ioutil.TempDir("", "docker-network-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "policy.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(p); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return socket, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAllocationPolicy(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	_, network, _ := net.ParseCIDR(d.bridgeNetwork.String())
+	blueRange := &net.IPNet{IP: append(net.IP{}, network.IP.To4()...), Mask: net.CIDRMask(28, 32)}
+	blueRange.IP[3] += 64
+	socket, stop := servePolicy(t, &NetworkPolicy{blueRange: blueRange.String()})
+	defer stop()
+	d.policy = policy.NewClient(socket)
+
+	job := eng.Job("allocate_interface", "blue_container")
+	job.Setenv("Tenant", "blue")
+	if res := d.Allocate(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	if ip := d.currentInterfaces.Get("blue_container").IP; !blueRange.Contains(ip) {
+		t.Fatalf("Expected an ip of the range %s of the tenant, got %s", blueRange, ip)
+	}
+	defer d.Release(eng.Job("release_interface", "blue_container"))
+
+	// A static ip out of the range of the tenant
+	job = eng.Job("allocate_interface", "static_container")
+	job.Setenv("Tenant", "blue")
+	staticIP := append(net.IP{}, network.IP.To4()...)
+	staticIP[3] = 200
+	job.Setenv("RequestedIP", staticIP.String())
+	if res := d.Allocate(job); res == engine.StatusOK {
+		t.Fatal("Expected the static ip out of the range of the tenant to be refused")
+	}
+
+	// The policy engine publishes the ports on 127.0.0.1
+	port := strconv.Itoa(findFreePort(t))
+	job = eng.Job("allocate_port", "blue_container")
+	job.Setenv("Proto", "tcp")
+	job.Setenv("HostPort", port)
+	job.Setenv("ContainerPort", "80")
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("HostIP") != "127.0.0.1" || out.Get("HostPort") != port {
+		t.Fatalf("Expected the port to be published on 127.0.0.1:%s, got %s:%s", port, out.Get("HostIP"), out.Get("HostPort"))
+	}
+
+	job = newPortAllocationJob(eng, findFreePort(t))
+	job.Args = []string{"closed_container"}
+	if res := d.AllocatePort(job); res == engine.StatusOK {
+		t.Fatal("Expected the ports of closed_container to be denied")
+	}
+
+	// Denied once published, the port is unpublished
+	job = newPortAllocationJob(eng, findFreePort(t))
+	job.Args = []string{"blue_container"}
+	job.Setenv("ContainerPort", "22")
+	if res := d.AllocatePort(job); res == engine.StatusOK {
+		t.Fatal("Expected the port 22 to be denied")
+	}
+	if mappings := d.currentInterfaces.Get("blue_container").PortMappings; len(mappings) != 1 {
+		t.Fatalf("Expected the denied port to be unpublished, got %v", mappings)
+	}
+}
@@ -0,0 +1,107 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// A running container can be cut off from the network without stopping it,
+// for incident response or to see how its peers cope with losing it: all
+// the traffic of its addresses is dropped, to and from the other containers,
+// the host and the outside alike, and the userland proxies of its published
+// ports are stopped so that no connection is accepted on its behalf. The
+// rules are inserted first, ahead of the port mappings and the links.
+
+// blackholeArgs returns the rules dropping the traffic of ip.
+func blackholeArgs(ip net.IP) [][]string {
+	return [][]string{
+		{"FORWARD", "-s", ip.String(), "-j", "DROP"},
+		{"FORWARD", "-d", ip.String(), "-j", "DROP"},
+		{"INPUT", "-s", ip.String(), "-j", "DROP"},
+		{"OUTPUT", "-d", ip.String(), "-j", "DROP"},
+	}
+}
+
+// blackhole drops all the traffic of iface and suspends its proxies.
+func (d *Driver) blackhole(iface *networkInterface) error {
+	ips := append([]net.IP{iface.IP}, iface.SecondaryIPs...)
+	for i, ip := range ips {
+		if err := d.blackholeIP(ip); err != nil {
+			for _, done := range ips[:i] {
+				d.removeBlackholeIP(done, !iface.Draining)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBlackhole lets the traffic of iface flow again and resumes its
+// proxies, unless it is draining.
+func (d *Driver) removeBlackhole(iface *networkInterface) {
+	for _, ip := range append([]net.IP{iface.IP}, iface.SecondaryIPs...) {
+		d.removeBlackholeIP(ip, !iface.Draining)
+	}
+}
+
+func (d *Driver) blackholeIP(ip net.IP) error {
+	for i, args := range blackholeArgs(ip) {
+		if err := d.execRule(ip.To4() == nil, append([]string{"-I"}, args...)...); err != nil {
+			for _, done := range blackholeArgs(ip)[:i] {
+				d.firewall.Raw(ip.To4() == nil, append([]string{"-D"}, done...)...)
+			}
+			return err
+		}
+	}
+	if _, err := portmapper.SetSuspended(ip, true); err != nil {
+		log.Infof("Unable to suspend the proxies of %s: %s", ip, err)
+	}
+	return nil
+}
+
+func (d *Driver) removeBlackholeIP(ip net.IP, resume bool) {
+	for _, args := range blackholeArgs(ip) {
+		d.firewall.Raw(ip.To4() == nil, append([]string{"-D"}, args...)...)
+	}
+	if !resume {
+		return
+	}
+	if _, err := portmapper.SetSuspended(ip, false); err != nil {
+		log.Infof("Unable to resume the proxies of %s: %s", ip, err)
+	}
+}
+
+// SetBlackhole drops all the traffic of a running container while
+// "Blackhole" is true, and lets it flow again otherwise.
+func (d *Driver) SetBlackhole(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+		enable  = job.GetenvBool("Blackhole")
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if !d.iptablesEnabled {
+		return job.Errorf("Cutting a container off the network requires iptables to be enabled")
+	}
+	if network.Blackholed == enable {
+		return engine.StatusOK
+	}
+
+	if enable {
+		log.WithField("container", id).Infof("Dropping all the traffic of the container")
+		if err := d.blackhole(network); err != nil {
+			return job.Error(err)
+		}
+	} else {
+		log.WithField("container", id).Infof("Letting the traffic of the container flow again")
+		d.removeBlackhole(network)
+	}
+	network.Blackholed = enable
+	d.saveJob(id, job.Name, job.Environ())
+	return engine.StatusOK
+}
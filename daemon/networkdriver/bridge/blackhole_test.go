@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestSetBlackhole(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := &Driver{
+		iptablesEnabled:   true,
+		currentInterfaces: ifaces{c: make(map[string]*networkInterface)},
+		saved:             savedInterfaces{jobs: make(map[string][]savedJob)},
+	}
+	d.currentInterfaces.Set("blackholed", &networkInterface{IP: net.ParseIP("172.17.0.5"), SecondaryIPs: []net.IP{net.ParseIP("172.17.0.6")}})
+	dryRunDriver(d)
+
+	for _, test := range []struct {
+		blackhole bool
+		changes   []string
+	}{
+		{true, []string{
+			"iptables -I FORWARD -s 172.17.0.5 -j DROP",
+			"iptables -I FORWARD -d 172.17.0.5 -j DROP",
+			"iptables -I INPUT -s 172.17.0.5 -j DROP",
+			"iptables -I OUTPUT -d 172.17.0.5 -j DROP",
+			"iptables -I FORWARD -d 172.17.0.6 -j DROP",
+		}},
+		{false, []string{
+			"iptables -D FORWARD -s 172.17.0.5 -j DROP",
+			"iptables -D OUTPUT -d 172.17.0.5 -j DROP",
+			"iptables -D INPUT -s 172.17.0.6 -j DROP",
+		}},
+	} {
+		d.changes.planned = nil
+		job := eng.Job("set_blackhole", "blackholed")
+		job.SetenvBool("Blackhole", test.blackhole)
+		if res := d.SetBlackhole(job); res != engine.StatusOK {
+			t.Fatal("Failed to set the blackhole")
+		}
+		if d.currentInterfaces.Get("blackholed").Blackholed != test.blackhole {
+			t.Fatalf("Expected the interface to be blackholed: %v", test.blackhole)
+		}
+		plan := strings.Join(d.changes.plan(), "\n")
+		for _, change := range test.changes {
+			if !strings.Contains(plan, change) {
+				t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+			}
+		}
+	}
+
+	if res := d.SetBlackhole(eng.Job("set_blackhole", "unknown")); res == engine.StatusOK {
+		t.Fatal("Expected a container without network to be refused")
+	}
+}
@@ -0,0 +1,141 @@
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+// Captures stop after defaultCaptureDuration unless told otherwise, and are
+// never allowed to run longer than maxCaptureDuration.
+const (
+	defaultCaptureDuration = 10 * time.Second
+	maxCaptureDuration     = 5 * time.Minute
+)
+
+// captureArgs returns the tcpdump arguments capturing the traffic of the
+// container with the given ip on the bridge, as a pcap stream on stdout.
+func (d *Driver) captureArgs(ip net.IP, filter string, count, snaplen int) ([]string, error) {
+	if err := checkCaptureFilter(filter); err != nil {
+		return nil, err
+	}
+	args := []string{"-i", d.bridgeIface, "-n", "-U", "-w", "-", "-s", strconv.Itoa(snaplen)}
+	if count > 0 {
+		args = append(args, "-c", strconv.Itoa(count))
+	}
+	expr := "host " + ip.String()
+	if filter != "" {
+		expr += " and (" + filter + ")"
+	}
+	return append(args, expr), nil
+}
+
+// checkCaptureFilter refuses the filters whose parentheses don't balance,
+// such as "x) or (net 0.0.0.0/0", which would close the parenthesis they are
+// put in and capture the traffic of the other containers.
+func checkCaptureFilter(filter string) error {
+	depth := 0
+	for _, c := range filter {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return fmt.Errorf("Invalid capture filter %q: unbalanced parentheses", filter)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("Invalid capture filter %q: unbalanced parentheses", filter)
+	}
+	return nil
+}
+
+// captureDuration returns how long a capture should last, given the number
+// of seconds requested.
+func captureDuration(seconds int) (time.Duration, error) {
+	if seconds < 0 {
+		return 0, fmt.Errorf("Invalid capture duration %d", seconds)
+	}
+	d := time.Duration(seconds) * time.Second
+	if d == 0 {
+		return defaultCaptureDuration, nil
+	}
+	if d > maxCaptureDuration {
+		return 0, fmt.Errorf("Capture duration %s exceeds the maximum of %s", d, maxCaptureDuration)
+	}
+	return d, nil
+}
+
+// CaptureTraffic streams a pcap of the traffic of a container on the job's
+// stdout. The capture can be narrowed with a BPF expression in "Filter", and
+// stops after "Duration" seconds or "Count" packets, whichever comes first.
+// "Snaplen" limits the number of bytes captured of each packet.
+func (d *Driver) CaptureTraffic(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+		snaplen = 65535
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	duration, err := captureDuration(job.GetenvInt("Duration"))
+	if err != nil {
+		return job.Error(err)
+	}
+	if job.EnvExists("Snaplen") {
+		if snaplen = job.GetenvInt("Snaplen"); snaplen <= 0 || snaplen > 65535 {
+			return job.Errorf("Invalid snaplen %s", job.Getenv("Snaplen"))
+		}
+	}
+
+	args, err := d.captureArgs(network.IP, job.Getenv("Filter"), job.GetenvInt("Count"), snaplen)
+	if err != nil {
+		return job.Error(err)
+	}
+	path, err := exec.LookPath("tcpdump")
+	if err != nil {
+		return job.Errorf("tcpdump not found: %s", err)
+	}
+	var (
+		stderr bytes.Buffer
+		cmd    = exec.Command(path, args...)
+	)
+	cmd.Stdout = job.Stdout
+	cmd.Stderr = &stderr
+
+	log.WithField("container", id).Debugf("Capturing the traffic for %s: %v", duration, cmd.Args)
+	if err := cmd.Start(); err != nil {
+		return job.Error(err)
+	}
+
+	var (
+		timedOut bool
+		mu       sync.Mutex
+	)
+	timer := time.AfterFunc(duration, func() {
+		mu.Lock()
+		timedOut = true
+		mu.Unlock()
+		// tcpdump flushes its output on SIGINT
+		cmd.Process.Signal(syscall.SIGINT)
+	})
+	err = cmd.Wait()
+	timer.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil && !timedOut {
+		return job.Errorf("Capture failed: %s (%s)", bytes.TrimSpace(stderr.Bytes()), err)
+	}
+	return engine.StatusOK
+}
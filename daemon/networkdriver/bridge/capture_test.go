@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureArgs(t *testing.T) {
+	d := newDriver(&Config{})
+	ip := net.ParseIP("172.17.0.2")
+
+	args, err := d.captureArgs(ip, "", 0, 65535)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr := args[len(args)-1]; expr != "host 172.17.0.2" {
+		t.Fatalf("Unexpected capture filter %s", expr)
+	}
+
+	args, err = d.captureArgs(ip, "tcp port 80 or icmp", 100, 96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(args, " "); !strings.Contains(s, "-c 100") || !strings.Contains(s, "-s 96") {
+		t.Fatalf("Unexpected capture arguments %s", s)
+	}
+	if expr := args[len(args)-1]; expr != "host 172.17.0.2 and (tcp port 80 or icmp)" {
+		t.Fatalf("Unexpected capture filter %s", expr)
+	}
+
+	// The filter can't reach out of the traffic of the container
+	for _, filter := range []string{"x) or (net 0.0.0.0/0", "tcp) or (udp", "(tcp", "udp)"} {
+		if _, err := d.captureArgs(ip, filter, 0, 65535); err == nil {
+			t.Fatalf("Expected the filter %q to be refused", filter)
+		}
+	}
+	if _, err := d.captureArgs(ip, "(tcp port 80) or (udp and not port 53)", 0, 65535); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaptureDuration(t *testing.T) {
+	if d, err := captureDuration(0); err != nil || d != defaultCaptureDuration {
+		t.Fatalf("Expected default duration, got %s (%v)", d, err)
+	}
+	if d, err := captureDuration(30); err != nil || d != 30*time.Second {
+		t.Fatalf("Expected 30s, got %s (%v)", d, err)
+	}
+	if _, err := captureDuration(3600); err == nil {
+		t.Fatal("Expected duration above the maximum to be rejected")
+	}
+	if _, err := captureDuration(-1); err == nil {
+		t.Fatal("Expected negative duration to be rejected")
+	}
+}
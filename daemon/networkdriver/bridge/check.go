@@ -0,0 +1,184 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// checkTimeout bounds the connections made to the port mappings.
+const checkTimeout = 2 * time.Second
+
+// networkCheck is the outcome of a check of the network. When it failed,
+// the message tells what is broken and how to fix it.
+type networkCheck struct {
+	Name    string
+	OK      bool
+	Message string
+}
+
+func passed(name, format string, a ...interface{}) networkCheck {
+	return networkCheck{Name: name, OK: true, Message: fmt.Sprintf(format, a...)}
+}
+
+func failed(name, format string, a ...interface{}) networkCheck {
+	return networkCheck{Name: name, Message: fmt.Sprintf(format, a...)}
+}
+
+// check diagnoses the network of the driver: the setup the containers need
+// to reach the outside world, and the path from the host to each TCP port
+// mapping, through the userland proxy and through the DNAT rule.
+func (d *Driver) check() []networkCheck {
+	checks := []networkCheck{checkIpForward(), d.checkBridge(), d.checkNeighTable()}
+	if d.iptablesEnabled {
+		checks = append(checks, d.checkChain())
+		if d.config.EnableIpMasq {
+			checks = append(checks, d.checkMasquerade())
+		}
+	}
+
+	hosts := make(map[string]bool)
+	for _, iface := range d.currentInterfaces.All() {
+		for _, host := range d.hostMappings(iface) {
+			hosts[host.String()] = true
+		}
+	}
+	for _, m := range portmapper.Mappings() {
+		if m.Proto != "tcp" || !hosts[m.Host] {
+			continue
+		}
+		checks = append(checks, d.checkMapping(m)...)
+	}
+	return checks
+}
+
+func checkIpForward() networkCheck {
+	const name = "ip_forward"
+	value, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil {
+		return failed(name, "Unable to read net.ipv4.ip_forward: %s", err)
+	}
+	if strings.TrimSpace(string(value)) != "1" {
+		return failed(name, "IPv4 forwarding is disabled, the containers can't reach the outside world: run `sysctl -w net.ipv4.ip_forward=1` or start the daemon with --ip-forward")
+	}
+	return passed(name, "IPv4 forwarding is enabled")
+}
+
+func (d *Driver) checkNeighTable() networkCheck {
+	const name = "neighbor_table"
+	p := neighSysctl(d.bridgeNetwork, "gc_thresh3")
+	key := sysctlName(p)
+	limit, err := readSysctlInt(p)
+	if err != nil {
+		return failed(name, "Unable to read %s: %s", key, err)
+	}
+	if entries := d.neighEntries(); entries*10 >= limit*9 {
+		return failed(name, "The containers have %d addresses for %d entries of the neighbor table, their traffic is dropped beyond it: run `sysctl -w %s=%d` or start the daemon with --neigh-thresholds", entries, limit, key, entries+neighHeadroom)
+	}
+	return passed(name, "The neighbor table has room for the containers")
+}
+
+func (d *Driver) checkBridge() networkCheck {
+	const name = "bridge"
+	iface, err := net.InterfaceByName(d.bridgeIface)
+	if err != nil {
+		return failed(name, "Bridge %s is missing: restart the daemon to create it again", d.bridgeIface)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return failed(name, "Bridge %s is down: run `ip link set %s up`", d.bridgeIface, d.bridgeIface)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return failed(name, "Unable to read the addresses of %s: %s", d.bridgeIface, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(d.bridgeNetwork.IP) {
+			return passed(name, "Bridge %s is up with the address %s", d.bridgeIface, d.bridgeNetwork)
+		}
+	}
+	return failed(name, "Bridge %s lost its address: run `ip addr add %s dev %s`", d.bridgeIface, d.bridgeNetwork, d.bridgeIface)
+}
+
+func (d *Driver) checkChain() networkCheck {
+	const name = "chain"
+	if !d.portChain.Exists() {
+		return failed(name, "The iptables chain %s or the jumps to it are missing, the ports aren't published: restart the daemon or enable --network-reconcile-interval", d.chain)
+	}
+	return passed(name, "The iptables chain %s is set up", d.chain)
+}
+
+func (d *Driver) checkMasquerade() networkCheck {
+	const name = "masquerade"
+	if !d.firewall.Exists(IsIpv6(d.bridgeNetwork), d.natRuleArgs(d.bridgeNetwork, d.config.IpMasqSource)...) {
+		return failed(name, "The POSTROUTING rule translating the traffic of %s is missing, the containers can't reach the outside world: restart the daemon or enable --network-reconcile-interval", d.bridgeNetwork)
+	}
+	return passed(name, "The traffic of %s is translated", d.bridgeNetwork)
+}
+
+// checkMapping connects to a TCP port mapping through the userland proxy,
+// which serves the loopback address, and through the DNAT rule, which serves
+// the other local addresses.
+func (d *Driver) checkMapping(m portmapper.MappingState) []networkCheck {
+	host, err := net.ResolveTCPAddr("tcp", m.Host)
+	if err != nil {
+		return []networkCheck{failed("proxy "+m.Host, "Invalid port mapping %s: %s", m.Host, err)}
+	}
+
+	var (
+		checks []networkCheck
+		dnat   = d.iptablesEnabled && !m.Untracked
+		// The local connections to a loopback alias go through DNAT
+		alias = dnat && iptables.IsLoopbackAlias(host.IP)
+	)
+	if host.IP.IsUnspecified() || host.IP.IsLoopback() && !alias {
+		addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: host.Port}
+		if host.IP.IsLoopback() {
+			addr.IP = host.IP
+		}
+		checks = append(checks, dialCheck("proxy "+m.Host, addr, m.Container,
+			"the userland proxy of %s isn't running or the container doesn't listen on all its interfaces", m.Host))
+	}
+	if dnat && (!host.IP.IsLoopback() || alias) {
+		addr := host
+		if host.IP.IsUnspecified() {
+			// The bridge address is local, its traffic goes through DNAT
+			addr = &net.TCPAddr{IP: d.bridgeNetwork.IP, Port: host.Port}
+		}
+		checks = append(checks, dialCheck("dnat "+m.Host, addr, m.Container,
+			"the DNAT rule of %s is missing or the container doesn't listen on all its interfaces", m.Host))
+	}
+	return checks
+}
+
+func dialCheck(name string, addr *net.TCPAddr, container, hint string, a ...interface{}) networkCheck {
+	conn, err := net.DialTimeout("tcp", addr.String(), checkTimeout)
+	if err != nil {
+		return failed(name, "Unable to reach %s through %s: %s; %s", container, addr, err, fmt.Sprintf(hint, a...))
+	}
+	conn.Close()
+	return passed(name, "%s is reachable through %s", container, addr)
+}
+
+// CheckNetwork diagnoses the network, writing a table of the checks made
+// with their Name, whether they are Ok and a Message telling how to fix
+// those failed.
+func (d *Driver) CheckNetwork(job *engine.Job) engine.Status {
+	outs := engine.NewTable("", 0)
+	for _, c := range d.check() {
+		out := &engine.Env{}
+		out.Set("Name", c.Name)
+		out.SetBool("Ok", c.OK)
+		out.Set("Message", c.Message)
+		outs.Add(out)
+	}
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
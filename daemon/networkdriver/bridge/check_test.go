@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func findCheck(checks []networkCheck, name string) *networkCheck {
+	for i := range checks {
+		if checks[i].Name == name {
+			return &checks[i]
+		}
+	}
+	return nil
+}
+
+func TestCheckNetwork(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+
+	if c := findCheck(d.check(), "bridge"); c == nil || !c.OK {
+		t.Fatalf("Expected the bridge to be set up, got %+v", c)
+	}
+
+	job := eng.Job("allocate_interface", "check_container")
+	if res := d.Allocate(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	defer d.Release(eng.Job("release_interface", "check_container"))
+
+	port := findFreePort(t)
+	job = eng.Job("allocate_port", "check_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.SetenvInt("HostPort", port)
+	job.Setenv("Proto", "tcp")
+	job.SetenvInt("ContainerPort", 80)
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate port")
+	}
+
+	// The proxies of the tests don't listen
+	name := "proxy 127.0.0.1:" + strconv.Itoa(port)
+	if c := findCheck(d.check(), name); c == nil || c.OK {
+		t.Fatalf("Expected the mapping to be unreachable, got %+v", c)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if c := findCheck(d.check(), name); c == nil || !c.OK {
+		t.Fatalf("Expected the mapping to be reachable, got %+v", c)
+	}
+}
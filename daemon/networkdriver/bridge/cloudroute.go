@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/cloudroute"
+)
+
+// In routed mode, the containers of the hosts reach each other by their own
+// addresses, without an overlay: the network of the cloud routes the
+// network of the bridge to the host, in the route table of the VPC on AWS
+// or in the Neutron router on OpenStack. The route is installed once the
+// driver is installed and installed again periodically, so that a route
+// removed is repaired, and it is removed on cleanup. The route of another
+// host, on the same network, is neither taken over nor removed.
+const cloudRouteInterval = 5 * time.Minute
+
+// newCloudRouter finds the network of the host in the cloud given by spec.
+var newCloudRouter = cloudroute.New
+
+type cloudRoute struct {
+	sync.Mutex
+	spec      string
+	router    cloudroute.Router // nil until the network of the host is found
+	installed bool
+	stop      chan struct{} // stops the repairs, if running, when closed
+}
+
+// bridgeSubnet returns the network of the bridge the cloud routes to the
+// host.
+func (d *Driver) bridgeSubnet() *net.IPNet {
+	return &net.IPNet{IP: d.bridgeNetwork.IP.Mask(d.bridgeNetwork.Mask), Mask: d.bridgeNetwork.Mask}
+}
+
+// installCloudRoute has the cloud route the network of the bridge to the
+// host, or keep routing it.
+func (d *Driver) installCloudRoute() error {
+	c := d.cloudRoute
+	c.Lock()
+	defer c.Unlock()
+	if c.router == nil {
+		router, err := newCloudRouter(c.spec)
+		if err != nil {
+			return err
+		}
+		c.router = router
+	}
+	subnet := d.bridgeSubnet()
+	if err := c.router.AddRoute(subnet); err != nil {
+		return err
+	}
+	if !c.installed {
+		log.Infof("The cloud routes %s to this host", subnet)
+		c.installed = true
+	}
+	return nil
+}
+
+// removeCloudRoute has the cloud stop routing the network of the bridge to
+// the host.
+func (d *Driver) removeCloudRoute() {
+	c := d.cloudRoute
+	c.Lock()
+	defer c.Unlock()
+	if !c.installed {
+		return
+	}
+	subnet := d.bridgeSubnet()
+	if err := c.router.RemoveRoute(subnet); err != nil {
+		log.Warnf("Unable to remove the route of %s from the cloud: %s", subnet, err)
+		return
+	}
+	c.installed = false
+}
+
+// startCloudRoute installs the route of the bridge network, and repairs it
+// until stopCloudRoute is called. The cloud out of reach doesn't keep the
+// daemon from starting, the route being installed once it is reached.
+func (d *Driver) startCloudRoute() {
+	stop := make(chan struct{})
+	d.cloudRoute.stop = stop
+	go func() {
+		ticker := time.NewTicker(cloudRouteInterval)
+		defer ticker.Stop()
+		for {
+			if err := d.installCloudRoute(); err != nil {
+				log.Warnf("Unable to route %s to this host in the cloud: %s", d.bridgeSubnet(), err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopCloudRoute stops the repairs, and with cleanup removes the route.
+func (d *Driver) stopCloudRoute(cleanup bool) {
+	if d.cloudRoute == nil {
+		return
+	}
+	if d.cloudRoute.stop != nil {
+		close(d.cloudRoute.stop)
+		d.cloudRoute.stop = nil
+	}
+	if cleanup {
+		d.removeCloudRoute()
+	}
+}
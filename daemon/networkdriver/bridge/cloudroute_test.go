@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/cloudroute"
+)
+
+// fakeCloud routes the subnets in a table of its own.
+type fakeCloud struct {
+	err    error
+	routes map[string]bool
+}
+
+func (c *fakeCloud) AddRoute(subnet *net.IPNet) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.routes[subnet.String()] = true
+	return nil
+}
+
+func (c *fakeCloud) RemoveRoute(subnet *net.IPNet) error {
+	delete(c.routes, subnet.String())
+	return nil
+}
+
+func TestCloudRoute(t *testing.T) {
+	cloud := &fakeCloud{err: fmt.Errorf("metadata service out of reach"), routes: make(map[string]bool)}
+	defer func(newRouter func(string) (cloudroute.Router, error)) { newCloudRouter = newRouter }(newCloudRouter)
+	newCloudRouter = func(spec string) (cloudroute.Router, error) {
+		if spec != "aws:rtb-1234" {
+			t.Fatalf("Unexpected cloud %s", spec)
+		}
+		return cloud, nil
+	}
+
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+	d.cloudRoute = &cloudRoute{spec: "aws:rtb-1234"}
+	subnet := d.bridgeSubnet()
+	if !subnet.Contains(d.bridgeNetwork.IP) || subnet.IP.Equal(d.bridgeNetwork.IP) {
+		t.Fatalf("Expected the network of the bridge %s, got %s", d.bridgeNetwork, subnet)
+	}
+
+	// The cloud out of reach, the route is installed once it is reached
+	if err := d.installCloudRoute(); err == nil {
+		t.Fatal("Expected the route to fail")
+	}
+	cloud.err = nil
+	if err := d.installCloudRoute(); err != nil {
+		t.Fatal(err)
+	}
+	if !cloud.routes[subnet.String()] {
+		t.Fatalf("Expected %s to be routed, got %v", subnet, cloud.routes)
+	}
+
+	// The route is kept unless cleaning up
+	d.stopCloudRoute(false)
+	if !cloud.routes[subnet.String()] {
+		t.Fatal("Expected the route to be kept")
+	}
+	d.stopCloudRoute(true)
+	if len(cloud.routes) != 0 {
+		t.Fatalf("Expected the route to be removed, got %v", cloud.routes)
+	}
+}
@@ -0,0 +1,314 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/cloudroute"
+)
+
+// Config holds the settings the driver is set up with. The zero value sets
+// up the default bridge on the first free private network, without
+// iptables.
+type Config struct {
+	Instance                    string // name of the daemon among those of the host, naming its default bridge, chain and state files
+	BridgeIface                 string // defaults to DefaultNetworkBridge, or docker-<instance>, the only bridge created if missing
+	Chain                       string // iptables chain of the port mappings, prefix of the other chains; defaults to DefaultChain, or DOCKER-<INSTANCE>
+	BridgeIP                    string // address of the bridge, in CIDR notation
+	FixedCIDR                   string // subnet of the bridge network the container ips are allocated from
+	Mtu                         int    // MTU of the bridge when it is created, 0 for the kernel default
+	UseIpv6                     bool
+	EnableIptables              bool
+	InterContainerCommunication bool
+	EnableIpMasq                bool
+	EnableIpForward             bool
+	Accounting                  bool     // count the traffic of every container, not only of those asking for it, needs iptables
+	NeighThresholds             bool     // raise the thresholds of the neighbor table to the pool of the containers
+	IpMasqSource                net.IP   // source of the outgoing traffic instead of masquerading, nil if none
+	SnatPool                    []net.IP // outbound addresses shared among the containers, needs iptables
+	DefaultBindingIP            net.IP   // host ip the ports are published on when none is requested, nil for 0.0.0.0
+	PortRangeBegin              int      // range of the ports published when none is requested, 0 for the default
+	PortRangeEnd                int
+	BlockMetadata               bool     // block the link-local addresses, needs iptables
+	AllowIcmp                   bool     // accept the pings and the path MTU discovery errors to the containers, needs iptables
+	Multicast                   bool     // accept the multicast and the broadcast among the containers, flooding the bridge unless MulticastQuerier
+	MulticastSnooping           bool     // the bridge forwards the multicast only to the containers which joined the group
+	MulticastQuerier            bool     // the bridge sends the IGMP and MLD queries, for the snooping to keep track of the groups
+	ResetConnections            bool     // abort the connections of the containers released rather than letting them time out
+	ProxyDualStack              bool     // the userland proxies dial the IPv6 link-local address of the IPv4 containers too
+	ProtectHost                 bool     // block the host services but those of HostAccess, needs iptables
+	HostAccess                  []string // host services reachable from the containers (ex: "53/udp")
+	Dscp                        string   // DSCP marking of the outgoing traffic, needs iptables
+	PublishIfaces               []string // if not empty, the only interfaces ports are published on
+	NetflowCollector            string   // address the flow records are exported to, empty if none
+	Root                        string   // directory of the journal and of the saved interfaces, empty for none
+	LockDir                     string   // directory of the host-wide locks on the bridge and the chain, empty for none
+	DryRun                      bool     // log the changes to the host instead of making them
+	AdoptRules                  bool     // keep the rules of the saved port mappings left by the previous run, needs iptables
+	Rootless                    bool     // no bridge nor iptables, the containers go through a userspace NAT
+	Helper                      string   // path of the privileged helper changing the host networking, empty for the daemon itself
+	Networkd                    string   // "unmanaged" to keep systemd-networkd off the bridge, "units" to have it create the bridge, empty to ignore it
+	UpstreamForwarding          string   // "natpmp" or "upnp" for the router to forward the ports asked for, empty for none
+	Hooks                       []string // executables run on each network event, given as JSON on their standard input
+	AllocationPolicy            string   // unix socket of the policy engine allowing the ips and ports allocated, empty for none
+	CloudRoutes                 string   // "aws" or "openstack", with ":" and the route table or router if given, for the cloud to route the bridge network to the host, empty for none
+	GCEAliasIP                  bool     // the bridge network is the alias IP range of the GCE instance, routed to it by the VPC
+	FloatingIPs                 []net.IP // ips moving between the hosts, such as VRRP virtual ips, the ports published on them listening while they are on the host
+	PortConflict                string   // "next", or a set of ports (ex: "8000-8100,9000"), for a host port requested which is taken to be substituted, empty to fail
+	UplinkTables                string   // range of the routing tables set up for the egress devices (ex: "200-252"), empty for 200-252
+
+	// CommandTimeout bounds the time each ip, tc and iptables command can
+	// take, 0 for iptables.DefaultTimeout
+	CommandTimeout time.Duration
+
+	// ReconcileInterval is the period of the reconciliation repairing the
+	// drift between the network of the driver and the kernel, 0 for none
+	ReconcileInterval time.Duration
+
+	// IgnoredRoutes are the route prefixes not taken as local when checking
+	// the network of the bridge for overlaps, such as a VPN aggregate
+	IgnoredRoutes []*net.IPNet
+
+	// CandidateAddrs are the addresses the bridge is given, the first not
+	// overlapping the networks of the host, once the private and the shared
+	// ranges all do (ex: "198.18.42.1/24")
+	CandidateAddrs []string
+
+	// Executor, if set, runs the commands changing the host networking in
+	// place of the daemon and of the helper, such as to test the driver
+	Executor Executor
+
+	// PoolThresholds are the percentages of the pools of the container ips
+	// and of the host ports warned about once allocated, 80 and 95 if none
+	PoolThresholds []int
+
+	// MaxPorts and MaxAddresses are the ports each container can publish
+	// and the secondary addresses it can have, unless given limits of its
+	// own, 0 for no limit
+	MaxPorts     int
+	MaxAddresses int
+}
+
+// ConfigFromJob reads the settings given to the init_networkdriver job.
+func ConfigFromJob(job *engine.Job) (*Config, error) {
+	config := &Config{
+		Instance:                    job.Getenv("Instance"),
+		BridgeIface:                 job.Getenv("BridgeIface"),
+		Chain:                       job.Getenv("Chain"),
+		BridgeIP:                    job.Getenv("BridgeIP"),
+		FixedCIDR:                   job.Getenv("FixedCIDR"),
+		Mtu:                         job.GetenvInt("Mtu"),
+		UseIpv6:                     job.GetenvBool("UseIpv6"),
+		EnableIptables:              job.GetenvBool("EnableIptables"),
+		InterContainerCommunication: job.GetenvBool("InterContainerCommunication"),
+		EnableIpMasq:                job.GetenvBool("EnableIpMasq"),
+		EnableIpForward:             job.GetenvBool("EnableIpForward"),
+		Accounting:                  job.GetenvBool("Accounting"),
+		NeighThresholds:             job.GetenvBool("NeighThresholds"),
+		BlockMetadata:               job.GetenvBool("BlockMetadata"),
+		AllowIcmp:                   job.GetenvBool("AllowIcmp"),
+		Multicast:                   job.GetenvBool("Multicast"),
+		MulticastSnooping:           job.GetenvBool("MulticastSnooping"),
+		MulticastQuerier:            job.GetenvBool("MulticastQuerier"),
+		ResetConnections:            job.GetenvBool("ResetConnections"),
+		ProxyDualStack:              job.GetenvBool("ProxyDualStack"),
+		ProtectHost:                 job.GetenvBool("ProtectHost"),
+		HostAccess:                  job.GetenvList("HostAccess"),
+		Dscp:                        job.Getenv("Dscp"),
+		PublishIfaces:               job.GetenvList("PublishIfaces"),
+		NetflowCollector:            job.Getenv("NetflowCollector"),
+		Root:                        job.Getenv("Root"),
+		LockDir:                     job.Getenv("LockDir"),
+		DryRun:                      job.GetenvBool("DryRun"),
+		AdoptRules:                  job.GetenvBool("AdoptRules"),
+		Rootless:                    job.GetenvBool("Rootless"),
+		Helper:                      job.Getenv("Helper"),
+		Networkd:                    job.Getenv("Networkd"),
+		UpstreamForwarding:          job.Getenv("UpstreamForwarding"),
+		Hooks:                       job.GetenvList("Hooks"),
+		AllocationPolicy:            job.Getenv("AllocationPolicy"),
+		CloudRoutes:                 job.Getenv("CloudRoutes"),
+		GCEAliasIP:                  job.GetenvBool("GCEAliasIP"),
+		PortConflict:                job.Getenv("PortConflict"),
+		UplinkTables:                job.Getenv("UplinkTables"),
+		CommandTimeout:              time.Duration(job.GetenvInt("CommandTimeout")) * time.Second,
+		ReconcileInterval:           time.Duration(job.GetenvInt("ReconcileInterval")) * time.Second,
+		MaxPorts:                    job.GetenvInt("MaxPorts"),
+		MaxAddresses:                job.GetenvInt("MaxAddresses"),
+	}
+
+	for _, addr := range job.GetenvList("SnatPool") {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("Bad parameter: invalid SNAT pool ip %s", addr)
+		}
+		config.SnatPool = append(config.SnatPool, ip)
+	}
+	for _, addr := range job.GetenvList("FloatingIPs") {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("Bad parameter: invalid floating ip %s", addr)
+		}
+		config.FloatingIPs = append(config.FloatingIPs, ip)
+	}
+	for _, prefix := range job.GetenvList("IgnoredRoutes") {
+		_, network, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Bad parameter: invalid route prefix %s", prefix)
+		}
+		config.IgnoredRoutes = append(config.IgnoredRoutes, network)
+	}
+	for _, addr := range job.GetenvList("CandidateAddrs") {
+		if _, _, err := net.ParseCIDR(addr); err != nil {
+			return nil, fmt.Errorf("Bad parameter: invalid bridge candidate %s", addr)
+		}
+		config.CandidateAddrs = append(config.CandidateAddrs, addr)
+	}
+	if source := job.Getenv("MasqSource"); source != "" {
+		if config.IpMasqSource = net.ParseIP(source); config.IpMasqSource == nil {
+			return nil, fmt.Errorf("Bad parameter: invalid masquerading source ip %s", source)
+		}
+	}
+	if defaultIP := job.Getenv("DefaultBindingIP"); defaultIP != "" {
+		if config.DefaultBindingIP = net.ParseIP(defaultIP); config.DefaultBindingIP == nil {
+			return nil, fmt.Errorf("Bad parameter: invalid default binding ip %s", defaultIP)
+		}
+	}
+	if r := job.Getenv("PortRange"); r != "" {
+		var err error
+		if config.PortRangeBegin, config.PortRangeEnd, err = parsePortRange(r); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range job.GetenvList("PoolThresholds") {
+		percentage, err := strconv.Atoi(strings.TrimSuffix(t, "%"))
+		if err != nil || percentage < 1 || percentage > 100 {
+			return nil, fmt.Errorf("Bad parameter: invalid pool threshold %s, it must be a percentage from 1 to 100", t)
+		}
+		config.PoolThresholds = append(config.PoolThresholds, percentage)
+	}
+	return config, nil
+}
+
+// validate checks the settings depending on one another.
+func (config *Config) validate() error {
+	if !config.EnableIptables {
+		switch {
+		case len(config.SnatPool) > 0:
+			return fmt.Errorf("The SNAT pool requires iptables to be enabled")
+		case config.BlockMetadata:
+			return fmt.Errorf("Blocking the metadata service requires iptables to be enabled")
+		case config.ProtectHost:
+			return fmt.Errorf("Protecting host services requires iptables to be enabled")
+		case config.Dscp != "":
+			return fmt.Errorf("DSCP marking requires iptables to be enabled")
+		case config.AdoptRules:
+			return fmt.Errorf("Adopting the rules of the previous run requires iptables to be enabled")
+		case config.Accounting:
+			return fmt.Errorf("Network accounting requires iptables to be enabled")
+		}
+	}
+	if config.Rootless {
+		switch {
+		case config.EnableIptables:
+			return fmt.Errorf("The rootless network can't be set up with iptables")
+		case config.EnableIpForward:
+			return fmt.Errorf("The rootless network can't enable ip forwarding")
+		case config.BridgeIface != "" || config.BridgeIP != "" || config.FixedCIDR != "":
+			return fmt.Errorf("The rootless network has no bridge to configure")
+		case config.Helper != "":
+			return fmt.Errorf("The rootless network has no use for the network helper")
+		case config.Networkd != "":
+			return fmt.Errorf("The rootless network has no bridge for systemd-networkd to leave alone")
+		case config.UpstreamForwarding != "":
+			return fmt.Errorf("The rootless network can't have the router forward its ports")
+		case config.AllocationPolicy != "":
+			return fmt.Errorf("The rootless network has no allocations for the policy engine to allow")
+		case config.CloudRoutes != "" || config.GCEAliasIP:
+			return fmt.Errorf("The rootless network has no bridge network for the cloud to route")
+		case len(config.FloatingIPs) > 0:
+			return fmt.Errorf("The rootless network can't publish ports on the floating ips of the host")
+		case config.PortConflict != "":
+			return fmt.Errorf("The rootless network can't substitute the host ports taken")
+		case config.MaxAddresses != 0:
+			return fmt.Errorf("The rootless network has no secondary address to limit")
+		}
+	}
+	if config.MaxPorts < 0 || config.MaxAddresses < 0 {
+		return fmt.Errorf("Invalid limit on the ports or the addresses of the containers, it must be positive")
+	}
+	if config.PortConflict != "" {
+		if _, err := parseConflictPolicy(config.PortConflict); err != nil {
+			return err
+		}
+	}
+	if config.UplinkTables != "" {
+		if _, _, err := parseTableRange(config.UplinkTables); err != nil {
+			return err
+		}
+	}
+	switch config.UpstreamForwarding {
+	case "", upstreamNatpmp, upstreamUpnp:
+	default:
+		return fmt.Errorf("Invalid upstream forwarding protocol %s, it must be %s or %s", config.UpstreamForwarding, upstreamNatpmp, upstreamUpnp)
+	}
+	if config.CloudRoutes != "" && !cloudroute.ValidSpec(config.CloudRoutes) {
+		return fmt.Errorf("Invalid cloud %s, it must be aws or openstack, with the route table or router after a colon if given", config.CloudRoutes)
+	}
+	if config.GCEAliasIP {
+		switch {
+		case config.BridgeIface != "" || config.BridgeIP != "" || config.FixedCIDR != "":
+			return fmt.Errorf("The bridge network is the alias IP range of the instance, it can't be configured")
+		case config.EnableIpMasq:
+			return fmt.Errorf("The alias IP range of the instance is routed by the VPC, it must not be masqueraded")
+		case config.CloudRoutes != "":
+			return fmt.Errorf("The alias IP range of the instance is already routed by the VPC")
+		}
+	}
+	switch config.Networkd {
+	case "", networkdUnmanaged:
+	case networkdUnits:
+		if config.BridgeIface != "" {
+			return fmt.Errorf("systemd-networkd can only create the bridge of the daemon, not %s", config.BridgeIface)
+		}
+	default:
+		return fmt.Errorf("Invalid systemd-networkd mode %s, it must be %s or %s", config.Networkd, networkdUnmanaged, networkdUnits)
+	}
+	for _, hook := range config.Hooks {
+		if !path.IsAbs(hook) {
+			return fmt.Errorf("Invalid network hook %s, it must be an absolute path", hook)
+		}
+	}
+	if config.Instance != "" && !validInstance.MatchString(config.Instance) {
+		return fmt.Errorf("Invalid instance name %s, it must be 1 to 8 lowercase letters or digits", config.Instance)
+	}
+	// Leave room for the suffixes of the other chains
+	if max := maxChainName - len("-EG-") - 8; len(config.Chain) > max {
+		return fmt.Errorf("Invalid iptables chain %s, it must be at most %d characters long", config.Chain, max)
+	}
+	if config.BridgeIP != "" {
+		if _, _, err := net.ParseCIDR(config.BridgeIP); err != nil {
+			return err
+		}
+	}
+	if config.FixedCIDR != "" {
+		if _, _, err := net.ParseCIDR(config.FixedCIDR); err != nil {
+			return err
+		}
+	}
+	if config.Mtu < 0 {
+		return fmt.Errorf("Invalid MTU %d", config.Mtu)
+	}
+	if config.CommandTimeout < 0 {
+		return fmt.Errorf("Invalid command timeout %s", config.CommandTimeout)
+	}
+	if config.ReconcileInterval < 0 {
+		return fmt.Errorf("Invalid reconciliation interval %s", config.ReconcileInterval)
+	}
+	return nil
+}
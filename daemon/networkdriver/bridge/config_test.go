@@ -0,0 +1,101 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestConfigFromJob(t *testing.T) {
+	eng := engine.New()
+	job := eng.Job("initdriver")
+	job.Setenv("BridgeIface", "br0")
+	job.SetenvInt("Mtu", 9000)
+	job.SetenvBool("EnableIptables", true)
+	job.Setenv("MasqSource", "192.168.1.2")
+	job.SetenvList("SnatPool", []string{"192.168.1.3", "192.168.1.4"})
+	job.Setenv("PortRange", "30000-32767")
+	job.SetenvList("IgnoredRoutes", []string{"10.0.0.0/8"})
+	job.SetenvList("CandidateAddrs", []string{"198.18.42.1/24"})
+	job.SetenvList("PoolThresholds", []string{"70", "90%"})
+	job.SetenvInt("MaxPorts", 20)
+
+	config, err := ConfigFromJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.BridgeIface != "br0" || config.Mtu != 9000 || !config.EnableIptables {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+	if !config.IpMasqSource.Equal(net.ParseIP("192.168.1.2")) || len(config.SnatPool) != 2 {
+		t.Fatalf("Unexpected outbound addresses %v %v", config.IpMasqSource, config.SnatPool)
+	}
+	if config.PortRangeBegin != 30000 || config.PortRangeEnd != 32767 {
+		t.Fatalf("Unexpected port range %d-%d", config.PortRangeBegin, config.PortRangeEnd)
+	}
+	if config.DefaultBindingIP != nil {
+		t.Fatalf("Expected no default binding ip, got %s", config.DefaultBindingIP)
+	}
+	if len(config.IgnoredRoutes) != 1 || config.IgnoredRoutes[0].String() != "10.0.0.0/8" {
+		t.Fatalf("Unexpected ignored routes %v", config.IgnoredRoutes)
+	}
+	if len(config.CandidateAddrs) != 1 || config.CandidateAddrs[0] != "198.18.42.1/24" {
+		t.Fatalf("Unexpected bridge candidates %v", config.CandidateAddrs)
+	}
+	if len(config.PoolThresholds) != 2 || config.PoolThresholds[0] != 70 || config.PoolThresholds[1] != 90 {
+		t.Fatalf("Unexpected pool thresholds %v", config.PoolThresholds)
+	}
+	if config.MaxPorts != 20 || config.MaxAddresses != 0 {
+		t.Fatalf("Unexpected limits %d %d", config.MaxPorts, config.MaxAddresses)
+	}
+
+	job.SetenvList("SnatPool", []string{"nowhere"})
+	if _, err := ConfigFromJob(job); err == nil {
+		t.Fatal("Expected an invalid SNAT pool ip to be rejected")
+	}
+	job.SetenvList("SnatPool", nil)
+	job.SetenvList("IgnoredRoutes", []string{"10.0.0.0"})
+	if _, err := ConfigFromJob(job); err == nil {
+		t.Fatal("Expected an invalid route prefix to be rejected")
+	}
+	job.SetenvList("IgnoredRoutes", nil)
+	job.SetenvList("CandidateAddrs", []string{"198.18.42.1"})
+	if _, err := ConfigFromJob(job); err == nil {
+		t.Fatal("Expected an invalid bridge candidate to be rejected")
+	}
+	job.SetenvList("CandidateAddrs", nil)
+	job.SetenvList("PoolThresholds", []string{"120"})
+	if _, err := ConfigFromJob(job); err == nil {
+		t.Fatal("Expected an invalid pool threshold to be rejected")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, config := range []*Config{
+		{SnatPool: []net.IP{net.ParseIP("192.168.1.3")}},
+		{BlockMetadata: true},
+		{ProtectHost: true},
+		{Dscp: "af11"},
+		{BridgeIP: "172.17.42.1"},
+		{Mtu: -1},
+		{Networkd: "manage"},
+		{Networkd: networkdUnits, BridgeIface: "br0"},
+		{UpstreamForwarding: "pcp"},
+		{Rootless: true, UpstreamForwarding: upstreamUpnp},
+		{CloudRoutes: "gce"},
+		{Rootless: true, CloudRoutes: "aws"},
+		{GCEAliasIP: true, FixedCIDR: "10.8.1.0/24"},
+		{GCEAliasIP: true, EnableIpMasq: true},
+		{Rootless: true, FloatingIPs: []net.IP{net.ParseIP("192.168.1.100")}},
+		{Rootless: true, MaxAddresses: 2},
+		{MaxPorts: -1},
+	} {
+		if err := config.validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", config)
+		}
+	}
+	if err := (&Config{EnableIptables: true, BlockMetadata: true}).validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,34 +1,68 @@
 package bridge
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/networkdriver"
 	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/daemon/networkdriver/policy"
 	"github.com/docker/docker/daemon/networkdriver/portallocator"
 	"github.com/docker/docker/daemon/networkdriver/portmapper"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/pkg/iptables"
 	"github.com/docker/docker/pkg/networkfs/resolvconf"
 	"github.com/docker/docker/pkg/parsers/kernel"
+	"github.com/docker/docker/pkg/systemd"
 	"github.com/docker/libcontainer/netlink"
 )
 
 const (
 	DefaultNetworkBridge     = "docker0"
+	DefaultChain             = "DOCKER"
 	MaxAllocatedPortAttempts = 10
 )
 
 // Network interface represents the networking stack of a container
 type networkInterface struct {
-	IP           net.IP
-	PortMappings []net.Addr // there are mappings to the host interfaces
+	IP               net.IP
+	PortMappings     []net.Addr            // there are mappings to the host interfaces
+	EgressPolicy     []*egressRule         // allowed outbound destinations, nil if unrestricted
+	LinkLocalAllowed bool                  // exempt from the link-local block
+	SnatGroup        string                // group sharing the outbound address
+	SnatIP           net.IP                // outbound address from the SNAT pool, nil if none
+	Dscp             string                // DSCP marking of the outgoing traffic, empty if none
+	RoutingPolicy    *routingPolicy        // routing table of the egress traffic, nil for the main one
+	Uplink           *uplink               // way out of the host, nil for the default route
+	Bandwidth        *bandwidth            // traffic shaping limits, nil if unlimited
+	Netem            *netem                // emulated network faults, nil if none
+	Accounted        bool                  // whether the traffic is counted
+	Forwards         []*slirpForward       // ports published in rootless mode
+	Upstream         []*upstreamForwarding // ports forwarded by the router upstream
+	Service          string                // service the container backs behind its VIP, empty if none
+	SecondaryIPs     []net.IP              // addresses of the interface besides IP
+	HostIface        string                // host side of the veth pair of the container
+	Mtu              int                   // MTU of the container, 0 for the one of the bridge
+	Sysctls          map[string]string     // kernel parameters of the network namespace of the container
+	NetDevices       []*netDevice          // physical interfaces of the host given to the container
+	Pid              int                   // process of the running container, 0 until attached
+	Netns            string                // name of the network namespace of the running container, empty until attached
+	Tap              string                // tap device of a virtual machine in place of the veth pair, empty for a container
+	Blackholed       bool                  // all the traffic is dropped
+	Draining         bool                  // the new connections are refused
+	Mirror           string                // container the traffic is copied to, empty if none
+	MaxPorts         int                   // ports the container can publish, 0 for the limit of the daemon
+	MaxAddresses     int                   // secondary addresses the container can have, 0 for the limit of the daemon
+	Slirp            *slirp                `json:"-"` // userspace NAT of the running container in rootless mode
+	portsStarting    int                   // ports being published, counted against MaxPorts
 }
 
 type ifaces struct {
@@ -49,6 +83,23 @@ func (i *ifaces) Get(key string) *networkInterface {
 	return res
 }
 
+func (i *ifaces) Delete(key string) {
+	i.Lock()
+	delete(i.c, key)
+	i.Unlock()
+}
+
+// All returns a copy of the interfaces, by container id.
+func (i *ifaces) All() map[string]*networkInterface {
+	i.Lock()
+	defer i.Unlock()
+	res := make(map[string]*networkInterface, len(i.c))
+	for key, n := range i.c {
+		res[key] = n
+	}
+	return res
+}
+
 var (
 	addrs = []string{
 		// Here we don't follow the convention of using the 1st IP of the range for the gateway.
@@ -70,119 +121,472 @@ var (
 		"192.168.44.1/24",
 	}
 
-	bridgeIface   string
-	bridgeNetwork *net.IPNet
+	// The shared address space of RFC 6598, tried once the private ranges
+	// all overlap the networks of the host, as on corporate VPNs routing
+	// the whole of 10.0.0.0/8 or 172.16.0.0/12
+	sharedAddrs = []string{
+		"100.64.42.1/16",
+		"100.80.42.1/16",
+		"100.96.42.1/16",
+		"100.112.42.1/24",
+		"100.113.42.1/24",
+	}
 
-	defaultBindingIP  = net.ParseIP("0.0.0.0")
-	currentInterfaces = ifaces{c: make(map[string]*networkInterface)}
+	checkRouteOverlaps = networkdriver.CheckRouteOverlaps
 )
 
+// Driver is the network of the containers attached to a bridge: the bridge,
+// its firewall rules and the interfaces of the containers. Several drivers
+// can run in the same process, on distinct bridges and iptables chains, each
+// with a journal of its own of the changes it makes to the host. The ip and
+// port allocators and the port mappings are shared by all of them, the ports
+// and routing tables being those of the host.
+type Driver struct {
+	bridgeIface     string
+	bridgeNetwork   *net.IPNet
+	chain           string          // chain of the port mappings, prefix of the other chains
+	accountingChain string          // counts the traffic of each container, in each direction
+	hostAccessChain string          // filters the traffic from the containers to the host itself
+	portChain       *iptables.Chain // nil without iptables
+	iptablesEnabled bool
+	blockLinkLocal  bool
+	protectHost     bool
+	publishIPs      []net.IP // if not empty, the only addresses ports are published on
+	snatAddrs       *snatPool
+
+	bindingLock       sync.Mutex // guards defaultBindingIP, which can change at runtime
+	defaultBindingIP  net.IP
+	currentInterfaces ifaces
+	saved             savedInterfaces
+	reservations      reservations
+	mappingsPath      string     // file the allocations are written to for the agents of the host, empty if none
+	activity          activities // last network activity of the containers, for parking the idle ones
+
+	config *Config        // the settings the drift is repaired with
+	eng    *engine.Engine // publishes the events of the reconciliation, nil until installed
+	claims []*os.File     // locks keeping the other daemons off the bridge and the chain
+
+	flowExportStop   chan struct{}   // stops the running flow exporter, if any, when closed
+	stateDumpSignals chan os.Signal  // gets the signals asking for a dump of the state
+	reconcileStop    chan struct{}   // stops the reconciliation, if running, when closed
+	upstream         *upstreamRouter // forwards the ports asked for on the router, nil if none
+	hooks            *hookRunner     // runs the hooks of the operators on the events, nil if none
+	policy           *policy.Client  // allows the allocations, nil if they all are
+	cloudRoute       *cloudRoute     // routes the bridge network to the host in the cloud, nil if not routed
+	floatingIPStop   chan struct{}   // stops the watch of the floating ips, if running, when closed
+	floatingPresent  map[string]bool // the floating ips on the host
+	services         services        // services behind the VIPs, by name
+	portConflict     *conflictPolicy // substitutes the host ports taken, nil to fail
+	expiries         portExpiries    // unmap the ports which expire
+	netDevices       netDevices      // physical interfaces claimed by the containers
+	neighWarned      int32           // whether the containers nearly filling the neighbor table were warned about
+	snooping         snooping        // multicast snooping of the bridge, which can change at runtime
+	portsLock        sync.Mutex      // guards the mappings of the interfaces, the ports of a container being published concurrently
+	poolWarnings     poolWarnings    // thresholds the pools of the ips and the ports are past
+	firstUplinkTable int             // range of the routing tables of the uplinks
+	lastUplinkTable  int
+	accountingLock   sync.Mutex // guards accountingReady
+	accountingReady  bool       // whether the accounting chain is set up
+	policyTables     policyTables
+	uplinkTables     uplinkTables
+	shaping          shaping
+	partitions       partitions
+	repairs          uint64 // drifts between the network and the kernel repaired by the reconciliation
+
+	changes        *journal          // the changes made to the host, the plan of them in dry-run mode
+	dryRun         bool              // the changes to the host are logged and planned rather than made
+	commandTimeout time.Duration     // the ip and tc commands are killed past it, 0 for never
+	firewall       *iptables.Context // runs the iptables commands of the driver
+	executor       Executor          // runs the commands changing the host networking, nil for the daemon itself
+	helper         *networkHelper    // the privileged helper the executor is, nil if none
+}
+
+// InitDriver sets up a driver with the settings given to the job, read by
+// ConfigFromJob, and registers its jobs.
 func InitDriver(job *engine.Job) engine.Status {
+	config, err := ConfigFromJob(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	d, err := NewDriver(config)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	// https://github.com/docker/docker/issues/2768
+	job.Eng.Hack_SetGlobalVar("httpapi.bridgeIP", d.bridgeNetwork.IP)
+
+	if err := d.Install(job.Eng); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// NewDriver sets up the bridge and the firewall as given by config. If any
+// step fails, the links, addresses, routes and rules created so far are
+// removed, so that no half configured bridge is left behind.
+func NewDriver(config *Config) (*Driver, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	d := newDriver(config)
+	if config.LockDir != "" && !config.DryRun && !config.Rootless {
+		if err := d.claim(config.LockDir); err != nil {
+			return nil, err
+		}
+	}
+	if config.Helper != "" && !config.DryRun {
+		h, err := startHelper(config.Helper)
+		if err != nil {
+			d.releaseClaims()
+			return nil, err
+		}
+		d.setHelper(h)
+	}
+	tx := d.changes.begin()
+	if err := d.setup(config); err != nil {
+		if !d.dryRun {
+			log.Infof("Undoing the network setup")
+			d.rollback(tx)
+		}
+		d.stopHelper()
+		d.releaseClaims()
+		return nil, err
+	}
+	d.changes.end()
+	return d, nil
+}
+
+func newDriver(config *Config) *Driver {
+	d := &Driver{
+		bridgeIface:       config.BridgeIface,
+		chain:             config.Chain,
+		config:            config,
+		defaultBindingIP:  net.ParseIP("0.0.0.0"),
+		currentInterfaces: ifaces{c: make(map[string]*networkInterface)},
+		saved:             savedInterfaces{jobs: make(map[string][]savedJob)},
+		reservations:      reservations{ips: make(map[string]string)},
+		activity:          activities{c: make(map[string]*activity)},
+		floatingPresent:   make(map[string]bool),
+		services:          services{m: make(map[string]*service)},
+		expiries:          portExpiries{timers: make(map[string]*time.Timer)},
+		netDevices:        netDevices{owners: make(map[string]string)},
+		policyTables:      policyTables{refs: make(map[int]int)},
+		uplinkTables:      uplinkTables{byUplink: make(map[string]*uplinkTable)},
+		partitions:        partitions{pairs: make(map[ipPair]*time.Timer)},
+		changes:           newJournal(config.DryRun),
+		dryRun:            config.DryRun,
+		commandTimeout:    config.CommandTimeout,
+		firewall:          iptables.NewContext(),
+	}
+	if d.commandTimeout == 0 {
+		d.commandTimeout = iptables.DefaultTimeout
+	}
+	// In dry-run mode the changes to the host are logged instead of made
+	d.firewall.SetTimeout(d.commandTimeout)
+	d.firewall.SetDryRun(d.dryRun)
+	d.firewall.SetRecorder(d.changes.record)
+	if config.Executor != nil {
+		d.setExecutor(config.Executor)
+	}
+	if d.bridgeIface == "" {
+		d.bridgeIface = instanceBridge(config.Instance)
+	}
+	if d.chain == "" {
+		d.chain = instanceChain(config.Instance)
+	}
+	if config.UpstreamForwarding != "" {
+		d.upstream = &upstreamRouter{protocol: config.UpstreamForwarding, dryRun: config.DryRun}
+	}
+	if len(config.Hooks) > 0 {
+		d.hooks = newHookRunner(config.Hooks, d.commandTimeout)
+	}
+	if config.AllocationPolicy != "" {
+		d.policy = policy.NewClient(config.AllocationPolicy)
+	}
+	if config.CloudRoutes != "" {
+		d.cloudRoute = &cloudRoute{spec: config.CloudRoutes}
+	}
+	if config.PortConflict != "" {
+		// Checked by validate
+		d.portConflict, _ = parseConflictPolicy(config.PortConflict)
+	}
+	tables := config.UplinkTables
+	if tables == "" {
+		tables = defaultUplinkTables
+	}
+	// Checked by validate
+	d.firstUplinkTable, d.lastUplinkTable, _ = parseTableRange(tables)
+	d.accountingChain = d.chain + "-ACCT"
+	d.hostAccessChain = d.chain + "-INPUT"
+	return d
+}
+
+// Install registers the jobs of the driver on eng. The reconciliation of the
+// network, if enabled, starts along with the first install, its repairs
+// being published on the event stream of eng.
+func (d *Driver) Install(eng *engine.Engine) error {
+	for name, f := range map[string]engine.Handler{
+		"allocate_interface":     d.Allocate,
+		"release_interface":      d.Release,
+		"allocate_port":          d.AllocatePort,
+		"link":                   d.LinkContainers,
+		"set_egress_policy":      d.SetEgressPolicy,
+		"set_routing_policy":     d.SetRoutingPolicy,
+		"set_bandwidth":          d.SetBandwidth,
+		"network_stats":          d.NetworkStats,
+		"network_activity":       d.NetworkActivity,
+		"set_netem":              d.SetNetem,
+		"set_blackhole":          d.SetBlackhole,
+		"set_mirror":             d.SetMirror,
+		"partition":              d.PartitionContainers,
+		"capture_traffic":        d.CaptureTraffic,
+		"network_metrics":        d.NetworkMetrics,
+		"network_state":          d.DumpState,
+		"network_check":          d.CheckNetwork,
+		"port_mappings":          d.PortMappings,
+		"release_port":           d.ReleasePort,
+		"add_address":            d.AddAddress,
+		"list_networks":          d.ListNetworks,
+		"restore_interface":      d.RestoreInterface,
+		"prune_interfaces":       d.PruneInterfaces,
+		"export_interface":       d.ExportInterface,
+		"import_interface":       d.ImportInterface,
+		"drain_interface":        d.DrainInterface,
+		"announce_interface":     d.AnnounceInterface,
+		"release_reservation":    d.ReleaseReservation,
+		"attach_interface":       d.AttachInterface,
+		"configure_network":      d.ConfigureDriver,
+		"shutdown_networkdriver": d.Shutdown,
+	} {
+		if err := eng.Register(name, f); err != nil {
+			return err
+		}
+	}
+
+	if d.eng == nil {
+		d.eng = eng
+		if d.config.ReconcileInterval > 0 && !d.dryRun && !d.config.Rootless {
+			d.startReconcile(d.config.ReconcileInterval)
+		}
+		if d.upstream != nil && !d.dryRun {
+			d.startUpstreamRenewal()
+		}
+		if d.cloudRoute != nil && !d.dryRun {
+			d.startCloudRoute()
+		}
+		if len(d.config.FloatingIPs) > 0 {
+			d.startFloatingIPWatch()
+		}
+		d.restoreTaps()
+	}
+	return nil
+}
+
+func (d *Driver) setup(config *Config) error {
 	var (
-		network        *net.IPNet
-		enableIPTables = job.GetenvBool("EnableIptables")
-		icc            = job.GetenvBool("InterContainerCommunication")
-		useIpv6        = job.GetenvBool("UseIpv6")
-		ipMasq         = job.GetenvBool("EnableIpMasq")
-		ipForward      = job.GetenvBool("EnableIpForward")
-		bridgeIP       = job.Getenv("BridgeIP")
-		fixedCIDR      = job.Getenv("FixedCIDR")
+		network            *net.IPNet
+		useIpv6            = config.UseIpv6
+		usingDefaultBridge = config.BridgeIface == ""
 	)
 
-	if defaultIP := job.Getenv("DefaultBindingIP"); defaultIP != "" {
-		defaultBindingIP = net.ParseIP(defaultIP)
+	if config.DefaultBindingIP != nil {
+		d.setDefaultBindingIP(config.DefaultBindingIP)
+	}
+	portmapper.AddFloatingIPs(config.FloatingIPs)
+	if config.PortRangeBegin != 0 || config.PortRangeEnd != 0 {
+		if err := portallocator.SetPortRange(config.PortRangeBegin, config.PortRangeEnd); err != nil {
+			return err
+		}
+	}
+
+	if config.EnableIptables {
+		// Stamp the rules with their owner
+		d.firewall.SetCommenter(d.changes.comment)
+		d.firewall.SetDeleteCheck(d.changes.owns)
+	}
+	if config.Rootless {
+		return d.setupRootless()
+	}
+	if !d.dryRun {
+		if err := d.checkKernel(config); err != nil {
+			return err
+		}
+		// The published ports bound by systemd are served as they are
+		for _, f := range systemd.PortFiles() {
+			if err := portmapper.AddActivatedSocket(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	if config.GCEAliasIP {
+		if err := useGCEAliasRange(config); err != nil {
+			return err
+		}
+	}
+
+	if config.Root != "" && !d.dryRun {
+		if err := d.changes.open(statePath(config.Root, journalName, config.Instance)); err != nil {
+			return err
+		}
+		if err := d.loadSavedInterfaces(statePath(config.Root, savedInterfacesName, config.Instance)); err != nil {
+			return err
+		}
+		if err := d.loadReservations(statePath(config.Root, reservationsName, config.Instance)); err != nil {
+			return err
+		}
+		d.mappingsPath = statePath(config.Root, mappingsFileName, config.Instance)
 	}
 
-	bridgeIface = job.Getenv("BridgeIface")
-	usingDefaultBridge := false
-	if bridgeIface == "" {
-		usingDefaultBridge = true
-		bridgeIface = DefaultNetworkBridge
+	if config.Networkd != "" {
+		if err := d.setupNetworkd(config); err != nil {
+			return err
+		}
 	}
 
-	addr, err := networkdriver.GetIfaceAddr(bridgeIface, !useIpv6, useIpv6)
+	addr, err := networkdriver.GetIfaceAddr(d.bridgeIface, !useIpv6, useIpv6)
 	if err != nil {
 		// If we're not using the default bridge, fail without trying to create it
 		if !usingDefaultBridge {
-			return job.Error(err)
+			return err
 		}
 		// If the bridge interface is not found (or has no address), try to create it and/or add an address
-		if err := configureBridge(bridgeIP); err != nil {
-			return job.Error(err)
+		configured, err := d.configureBridge(config.BridgeIP, config.Mtu)
+		if err != nil {
+			return err
 		}
 
-		addr, err = networkdriver.GetIfaceAddr(bridgeIface, !useIpv6, useIpv6)
-		if err != nil {
-			return job.Error(err)
+		if d.dryRun {
+			// The bridge wasn't created, go on with the address it would have
+			addr = configured
+		} else if addr, err = networkdriver.GetIfaceAddr(d.bridgeIface, !useIpv6, useIpv6); err != nil {
+			return err
 		}
 		network = addr.(*net.IPNet)
 	} else {
 		network = addr.(*net.IPNet)
 		// validate that the bridge ip matches the ip specified by BridgeIP
-		if bridgeIP != "" {
-			bip, _, err := net.ParseCIDR(bridgeIP)
+		if config.BridgeIP != "" {
+			bip, _, err := net.ParseCIDR(config.BridgeIP)
 			if err != nil {
-				return job.Error(err)
+				return err
 			}
 			if !network.IP.Equal(bip) {
-				return job.Errorf("bridge ip (%s) does not match existing bridge configuration %s", network.IP, bip)
+				return fmt.Errorf("bridge ip (%s) does not match existing bridge configuration %s", network.IP, bip)
 			}
 		}
 	}
 
 	// Configure iptables for link support
-	if enableIPTables {
-		if err := setupIPTables(addr, icc, ipMasq); err != nil {
-			return job.Error(err)
+	if config.EnableIptables {
+		if err := d.setupIPTables(addr, config.InterContainerCommunication, config.EnableIpMasq, config.IpMasqSource); err != nil {
+			return err
+		}
+		if err := d.setupLinkLocalBlock(config.BlockMetadata); err != nil {
+			return err
+		}
+		if err := d.setupIcmp(IsIpv6(addr), config.AllowIcmp); err != nil {
+			return err
+		}
+		if err := d.setupMulticast(IsIpv6(addr), config.Multicast); err != nil {
+			return err
+		}
+		if err := d.setupNetworkDscp(config.Dscp); err != nil {
+			return err
+		}
+		if config.Accounting {
+			if err := d.setupAccounting(); err != nil {
+				return err
+			}
+		} else {
+			// Set up along with the first container asking to be counted
+			d.removeAccountingChain()
+		}
+		if config.ProtectHost {
+			shared, err := parseHostPorts(config.HostAccess)
+			if err != nil {
+				return err
+			}
+			if err := d.setupHostAccess(shared); err != nil {
+				return err
+			}
+		} else {
+			d.removeHostAccessChain()
 		}
 	}
 
-	if ipForward {
+	if config.EnableIpForward {
 		// Enable IPv4 forwarding
-		if err := ioutil.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte{'1', '\n'}, 0644); err != nil {
-			job.Logf("WARNING: unable to enable IPv4 forwarding: %s\n", err)
+		if err := d.setSysctl("/proc/sys/net/ipv4/ip_forward", "1"); err != nil {
+			log.Warnf("Unable to enable IPv4 forwarding: %s", err)
 		}
 	}
+	if err := d.setSnooping(snoopingOf(config)); err != nil {
+		log.Warnf("Unable to set the multicast snooping of %s: %s", d.bridgeIface, err)
+	}
 
-	// We can always try removing the iptables
-	if err := iptables.RemoveExistingChain(useIpv6, "DOCKER"); err != nil {
-		return job.Error(err)
+	var chain *iptables.Chain
+	if config.EnableIptables && config.AdoptRules {
+		if chain, err = d.adoptChain(config); err != nil {
+			return err
+		}
+	}
+	if chain == nil {
+		// We can always try removing the iptables
+		if err := d.firewall.RemoveExistingChain(useIpv6, d.chain); err != nil {
+			return err
+		}
+		if config.EnableIptables {
+			if chain, err = d.firewall.NewChain(useIpv6, d.chain, d.bridgeIface, config.PublishIfaces); err != nil {
+				return err
+			}
+		}
 	}
+	d.portChain = chain
 
-	if enableIPTables {
-		chain, err := iptables.NewChain(useIpv6, "DOCKER", bridgeIface)
+	d.publishIPs = nil
+	for _, name := range config.PublishIfaces {
+		ips, err := ifaceIPs(name)
 		if err != nil {
-			return job.Error(err)
+			return err
 		}
-		portmapper.SetIptablesChain(chain)
+		d.publishIPs = append(d.publishIPs, ips...)
+	}
+
+	d.snatAddrs = nil
+	if len(config.SnatPool) > 0 {
+		d.snatAddrs = newSnatPool(config.SnatPool)
 	}
 
-	bridgeNetwork = network
-	if fixedCIDR != "" {
-		_, subnet, err := net.ParseCIDR(fixedCIDR)
+	d.iptablesEnabled = config.EnableIptables
+	d.blockLinkLocal = config.BlockMetadata
+	d.protectHost = config.ProtectHost
+	d.bridgeNetwork = network
+	if config.FixedCIDR != "" {
+		_, subnet, err := net.ParseCIDR(config.FixedCIDR)
 		if err != nil {
-			return job.Error(err)
+			return err
 		}
 		log.Debugf("Subnet: %v", subnet)
-		if err := ipallocator.RegisterSubnet(bridgeNetwork, subnet); err != nil {
-			return job.Error(err)
+		if err := ipallocator.RegisterSubnet(d.bridgeNetwork, subnet); err != nil {
+			return err
 		}
 	}
+	d.holdReservations()
+	if config.NeighThresholds {
+		d.sizeNeighTable(config)
+	}
 
-	// https://github.com/docker/docker/issues/2768
-	job.Eng.Hack_SetGlobalVar("httpapi.bridgeIP", bridgeNetwork.IP)
-
-	for name, f := range map[string]engine.Handler{
-		"allocate_interface": Allocate,
-		"release_interface":  Release,
-		"allocate_port":      AllocatePort,
-		"link":               LinkContainers,
-	} {
-		if err := job.Eng.Register(name, f); err != nil {
-			return job.Error(err)
+	if config.NetflowCollector != "" {
+		if err := d.startFlowExport(config.NetflowCollector, d.bridgeNetwork); err != nil {
+			return err
 		}
 	}
-	return engine.StatusOK
+
+	d.syncMappingsFile()
+	d.dumpStateOnSignal()
+	return nil
 }
 
 func IsIpv6(addr net.Addr) bool {
@@ -190,16 +594,57 @@ func IsIpv6(addr net.Addr) bool {
 	return ip.To16() != nil
 }
 
-func setupIPTables(addr net.Addr, icc, ipmasq bool) error {
+// natRuleArgs returns the POSTROUTING rule translating outgoing container
+// traffic, SNAT to a fixed source if one is given and MASQUERADE otherwise.
+func (d *Driver) natRuleArgs(addr net.Addr, snatIP net.IP) []string {
+	args := []string{"POSTROUTING", "-t", "nat", "-s", addr.String(), "!", "-o", d.bridgeIface, "-j"}
+	if snatIP != nil {
+		return append(args, "SNAT", "--to-source", snatIP.String())
+	}
+	return append(args, "MASQUERADE")
+}
+
+// removeNatRules deletes any bridge wide MASQUERADE or SNAT rule left for
+// the bridge by a previous configuration, so switching between the two is
+// clean. The SNAT rules of single containers, whose source is a host
+// rather than a network, are kept.
+func (d *Driver) removeNatRules(ipv6 bool) {
+	d.firewall.DeleteMatching(ipv6, "nat", "POSTROUTING", func(rule []string) bool {
+		r := " " + strings.Join(rule, " ") + " "
+		return strings.Contains(r, " ! -o "+d.bridgeIface+" ") &&
+			(strings.Contains(r, " -j MASQUERADE ") || strings.Contains(r, " -j SNAT ")) &&
+			isNetworkSource(rule)
+	})
+}
+
+// isNetworkSource returns whether the source of a rule, in the iptables -S
+// format, is a network rather than a single host.
+func isNetworkSource(rule []string) bool {
+	for i := 0; i < len(rule)-1; i++ {
+		if rule[i] != "-s" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(rule[i+1])
+		if err != nil {
+			return false
+		}
+		ones, bits := network.Mask.Size()
+		return ones < bits
+	}
+	return false
+}
+
+func (d *Driver) setupIPTables(addr net.Addr, icc, ipmasq bool, snatIP net.IP) error {
 	// Enable NAT
 
 	useIpv6 := IsIpv6(addr)
 
 	if ipmasq {
-		natArgs := []string{"POSTROUTING", "-t", "nat", "-s", addr.String(), "!", "-o", bridgeIface, "-j", "MASQUERADE"}
+		natArgs := d.natRuleArgs(addr, snatIP)
 
-		if !iptables.Exists(useIpv6, natArgs...) {
-			if output, err := iptables.Raw(useIpv6, append([]string{"-I"}, natArgs...)...); err != nil {
+		if !d.firewall.Exists(useIpv6, natArgs...) {
+			d.removeNatRules(useIpv6)
+			if output, err := d.firewall.Raw(useIpv6, append([]string{"-I"}, natArgs...)...); err != nil {
 				return fmt.Errorf("Unable to enable network bridge NAT: %s", err)
 			} else if len(output) != 0 {
 				return fmt.Errorf("Error iptables postrouting: %s", output)
@@ -208,28 +653,28 @@ func setupIPTables(addr net.Addr, icc, ipmasq bool) error {
 	}
 
 	var (
-		args       = []string{"FORWARD", "-i", bridgeIface, "-o", bridgeIface, "-j"}
+		args       = []string{"FORWARD", "-i", d.bridgeIface, "-o", d.bridgeIface, "-j"}
 		acceptArgs = append(args, "ACCEPT")
 		dropArgs   = append(args, "DROP")
 	)
 
 	if !icc {
-		iptables.Raw(useIpv6, append([]string{"-D"}, acceptArgs...)...)
+		d.firewall.Raw(useIpv6, append([]string{"-D"}, acceptArgs...)...)
 
-		if !iptables.Exists(useIpv6, dropArgs...) {
+		if !d.firewall.Exists(useIpv6, dropArgs...) {
 			log.Debugf("Disable inter-container communication")
-			if output, err := iptables.Raw(useIpv6, append([]string{"-I"}, dropArgs...)...); err != nil {
+			if output, err := d.firewall.Raw(useIpv6, append([]string{"-I"}, dropArgs...)...); err != nil {
 				return fmt.Errorf("Unable to prevent intercontainer communication: %s", err)
 			} else if len(output) != 0 {
 				return fmt.Errorf("Error disabling intercontainer communication: %s", output)
 			}
 		}
 	} else {
-		iptables.Raw(useIpv6, append([]string{"-D"}, dropArgs...)...)
+		d.firewall.Raw(useIpv6, append([]string{"-D"}, dropArgs...)...)
 
-		if !iptables.Exists(useIpv6, acceptArgs...) {
+		if !d.firewall.Exists(useIpv6, acceptArgs...) {
 			log.Debugf("Enable inter-container communication")
-			if output, err := iptables.Raw(useIpv6, append([]string{"-I"}, acceptArgs...)...); err != nil {
+			if output, err := d.firewall.Raw(useIpv6, append([]string{"-I"}, acceptArgs...)...); err != nil {
 				return fmt.Errorf("Unable to allow intercontainer communication: %s", err)
 			} else if len(output) != 0 {
 				return fmt.Errorf("Error enabling intercontainer communication: %s", output)
@@ -238,9 +683,9 @@ func setupIPTables(addr net.Addr, icc, ipmasq bool) error {
 	}
 
 	// Accept all non-intercontainer outgoing packets
-	outgoingArgs := []string{"FORWARD", "-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}
-	if !iptables.Exists(useIpv6, outgoingArgs...) {
-		if output, err := iptables.Raw(useIpv6, append([]string{"-I"}, outgoingArgs...)...); err != nil {
+	outgoingArgs := d.outgoingArgs()
+	if !d.firewall.Exists(useIpv6, outgoingArgs...) {
+		if output, err := d.firewall.Raw(useIpv6, append([]string{"-I"}, outgoingArgs...)...); err != nil {
 			return fmt.Errorf("Unable to allow outgoing packets: %s", err)
 		} else if len(output) != 0 {
 			return fmt.Errorf("Error iptables allow outgoing: %s", output)
@@ -248,10 +693,10 @@ func setupIPTables(addr net.Addr, icc, ipmasq bool) error {
 	}
 
 	// Accept incoming packets for existing connections
-	existingArgs := []string{"FORWARD", "-o", bridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
+	existingArgs := []string{"FORWARD", "-o", d.bridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
 
-	if !iptables.Exists(useIpv6, existingArgs...) {
-		if output, err := iptables.Raw(useIpv6, append([]string{"-I"}, existingArgs...)...); err != nil {
+	if !d.firewall.Exists(useIpv6, existingArgs...) {
+		if output, err := d.firewall.Raw(useIpv6, append([]string{"-I"}, existingArgs...)...); err != nil {
 			return fmt.Errorf("Unable to allow incoming packets: %s", err)
 		} else if len(output) != 0 {
 			return fmt.Errorf("Error iptables allow incoming: %s", output)
@@ -260,12 +705,171 @@ func setupIPTables(addr net.Addr, icc, ipmasq bool) error {
 	return nil
 }
 
+// outgoingArgs returns the FORWARD rule accepting the traffic from the
+// containers to the outside world.
+func (d *Driver) outgoingArgs() []string {
+	return []string{"FORWARD", "-i", d.bridgeIface, "!", "-o", d.bridgeIface, "-j", "ACCEPT"}
+}
+
+// execRule runs a single iptables command, treating any output as a failure.
+func (d *Driver) execRule(ipv6 bool, args ...string) error {
+	if output, err := d.firewall.Raw(ipv6, args...); err != nil {
+		return err
+	} else if len(output) != 0 {
+		return fmt.Errorf("Error iptables %s: %s", strings.Join(args, " "), output)
+	}
+	return nil
+}
+
+// runCommand runs a networking tool such as ip or tc.
+func (d *Driver) runCommand(name string, args ...string) error {
+	if d.dryRun {
+		d.changes.record(name, args, nil)
+		return nil
+	}
+	var (
+		output []byte
+		err    error
+	)
+	if d.executor != nil {
+		log.Debugf("%s %v, in the executor", name, args)
+		output, err = d.executor.Run(name, args...)
+	} else {
+		path, lookErr := exec.LookPath(name)
+		if lookErr != nil {
+			return fmt.Errorf("%s not found: %s", name, lookErr)
+		}
+		log.Debugf("%s, %v", path, args)
+		output, err = commandOutput(exec.Command(path, args...), d.commandTimeout)
+	}
+	d.changes.record(name, args, err)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s (%s)", name, strings.Join(args, " "), output, err)
+	}
+	return nil
+}
+
+// commandOutput runs cmd as cmd.CombinedOutput does, killing it if it runs
+// for longer than timeout.
+func commandOutput(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	if timeout == 0 {
+		return cmd.CombinedOutput()
+	}
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return b.Bytes(), err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		return b.Bytes(), fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// setSysctl writes a kernel parameter, given by its /proc/sys path.
+func (d *Driver) setSysctl(path, value string) error {
+	if d.dryRun {
+		d.changes.record("sysctl", []string{"-w", strings.TrimPrefix(path, "/proc/sys/") + "=" + value}, nil)
+		return nil
+	}
+	if d.executor != nil {
+		_, err := d.executor.Run("sysctl", path, value)
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(value+"\n"), 0644)
+}
+
+// runIp runs an iproute2 command. Where ip isn't installed, such as on
+// minimal hosts, the change is made through netlink instead.
+func (d *Driver) runIp(args ...string) error {
+	if d.dryRun || d.executor != nil {
+		return d.runCommand("ip", args...)
+	}
+	if _, err := exec.LookPath("ip"); err == nil {
+		return d.runCommand("ip", args...)
+	}
+	log.Debugf("netlink, ip %v", args)
+	err := ipNetlink(args)
+	d.changes.record("ip", args, err)
+	if err != nil {
+		return fmt.Errorf("ip %s failed: %s", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
 // configureBridge attempts to create and configure a network bridge interface named `ifaceName` on the host
 // If bridgeIP is empty, it will try to find a non-conflicting IP from the Docker-specified private ranges
 // If the bridge `ifaceName` already exists, it will only perform the IP address association with the existing
 // bridge (fixes issue #8444)
 // If an address which doesn't conflict with existing interfaces can't be found, an error is returned.
-func configureBridge(bridgeIP string) error {
+// The address given to the bridge is returned.
+func (d *Driver) configureBridge(bridgeIP string, mtu int) (net.Addr, error) {
+	ifaceAddr, err := d.bridgeAddress(bridgeIP)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Creating bridge %s with network %s", d.bridgeIface, ifaceAddr)
+
+	if err := d.createBridgeIface(d.bridgeIface, mtu); err != nil {
+		// the bridge may already exist, therefore we can ignore an "exists" error
+		if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+
+	ipAddr, ipNet, err := net.ParseCIDR(ifaceAddr)
+	if err != nil {
+		return nil, err
+	}
+	addr := &net.IPNet{IP: ipAddr, Mask: ipNet.Mask}
+
+	if d.dryRun {
+		d.changes.record("ip", []string{"addr", "add", ifaceAddr, "dev", d.bridgeIface}, nil)
+		d.changes.record("ip", []string{"link", "set", d.bridgeIface, "up"}, nil)
+		return addr, nil
+	}
+
+	iface, err := net.InterfaceByName(d.bridgeIface)
+	if err != nil {
+		return nil, err
+	}
+
+	if ipAddr.To16() != nil {
+		// Enable IPv6 on the bridge
+		procFile := "/proc/sys/net/ipv6/conf/" + iface.Name + "/disable_ipv6"
+		if err := d.setSysctl(procFile, "0"); err != nil {
+			return nil, fmt.Errorf("Unable to enable IPv6 addresses on bridge: %s\n", err)
+		}
+	}
+
+	err = d.linkChange(func() error {
+		return netlink.NetworkLinkAddIp(iface, ipAddr, ipNet)
+	}, "addr", "add", ifaceAddr, "dev", d.bridgeIface)
+	d.changes.record("ip", []string{"addr", "add", ifaceAddr, "dev", d.bridgeIface}, err)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to add private network: %s", err)
+	}
+	if err := d.linkChange(func() error {
+		return netlink.NetworkLinkUp(iface)
+	}, "link", "set", d.bridgeIface, "up"); err != nil {
+		return nil, fmt.Errorf("Unable to start network bridge: %s", err)
+	}
+	return addr, nil
+}
+
+// bridgeAddress returns the address of the bridge to create: bridgeIP, or
+// a range of the private ones, of the shared ones or of the candidates of
+// the configuration overlapping neither the routes, nor the addresses, nor
+// the nameservers of the host.
+func (d *Driver) bridgeAddress(bridgeIP string) (string, error) {
 	nameservers := []string{}
 	resolvConf, _ := resolvconf.Get()
 	// we don't check for an error here, because we don't really care
@@ -280,18 +884,27 @@ func configureBridge(bridgeIP string) error {
 	if len(bridgeIP) != 0 {
 		_, _, err := net.ParseCIDR(bridgeIP)
 		if err != nil {
-			return err
+			return "", err
 		}
 		ifaceAddr = bridgeIP
+		// The network might be the one of another daemon's bridge
+		_, network, _ := net.ParseCIDR(bridgeIP)
+		if err := checkRouteOverlaps(network, d.config.IgnoredRoutes...); err != nil {
+			return "", fmt.Errorf("Unable to create the bridge %s on %s: %s", d.bridgeIface, bridgeIP, err)
+		}
 	} else {
-		for _, addr := range addrs {
+		candidates := append(append(append([]string{}, addrs...), sharedAddrs...), d.config.CandidateAddrs...)
+		for i, addr := range candidates {
 			_, dockerNetwork, err := net.ParseCIDR(addr)
 			if err != nil {
-				return err
+				return "", err
 			}
 			if err := networkdriver.CheckNameserverOverlaps(nameservers, dockerNetwork); err == nil {
-				if err := networkdriver.CheckRouteOverlaps(dockerNetwork); err == nil {
+				if err := checkRouteOverlaps(dockerNetwork, d.config.IgnoredRoutes...); err == nil {
 					ifaceAddr = addr
+					if i >= len(addrs) {
+						log.Infof("The private ranges all overlap the networks of the host, the bridge %s takes %s", d.bridgeIface, addr)
+					}
 					break
 				} else {
 					log.Debugf("%s %s", addr, err)
@@ -301,80 +914,69 @@ func configureBridge(bridgeIP string) error {
 	}
 
 	if ifaceAddr == "" {
-		return fmt.Errorf("Could not find a free IP address range for interface '%s'. Please configure its address manually and run 'docker -b %s'", bridgeIface, bridgeIface)
+		return "", fmt.Errorf("Could not find a free IP address range for interface '%s'. Please configure its address manually and run 'docker -b %s', or give more candidates with --network-candidate", d.bridgeIface, d.bridgeIface)
 	}
-	log.Debugf("Creating bridge %s with network %s", bridgeIface, ifaceAddr)
+	return ifaceAddr, nil
+}
 
-	if err := createBridgeIface(bridgeIface); err != nil {
-		// the bridge may already exist, therefore we can ignore an "exists" error
-		if !os.IsExist(err) {
-			return err
+func (d *Driver) createBridgeIface(name string, mtu int) error {
+	kv, err := kernel.GetKernelVersion()
+	// only set the bridge's mac address if the kernel version is > 3.3
+	// before that it was not supported
+	setBridgeMacAddr := err == nil && (kv.Kernel >= 3 && kv.Major >= 3)
+	log.Debugf("setting bridge mac address = %v", setBridgeMacAddr)
+	if d.dryRun {
+		d.changes.record("ip", []string{"link", "add", name, "type", "bridge"}, nil)
+		if mtu != 0 {
+			d.changes.record("ip", []string{"link", "set", name, "mtu", strconv.Itoa(mtu)}, nil)
 		}
+		return nil
 	}
-
-	iface, err := net.InterfaceByName(bridgeIface)
-	if err != nil {
+	err = d.linkChange(func() error {
+		return netlink.CreateBridge(name, setBridgeMacAddr)
+	}, "link", "add", name, "type", "bridge")
+	d.changes.record("ip", []string{"link", "add", name, "type", "bridge"}, err)
+	if err != nil || mtu == 0 {
 		return err
 	}
-
-	ipAddr, ipNet, err := net.ParseCIDR(ifaceAddr)
+	iface, err := net.InterfaceByName(name)
 	if err != nil {
 		return err
 	}
-
-	if ipAddr.To16() != nil {
-		// Enable IPv6 on the bridge
-		procFile := "/proc/sys/net/ipv6/conf/" + iface.Name + "/disable_ipv6"
-		if err := ioutil.WriteFile(procFile, []byte{'0', '\n'}, 0644); err != nil {
-			return fmt.Errorf("Unable to enable IPv6 addresses on bridge: %s\n", err)
-		}
-	}
-
-	if netlink.NetworkLinkAddIp(iface, ipAddr, ipNet); err != nil {
-		return fmt.Errorf("Unable to add private network: %s", err)
-	}
-	if err := netlink.NetworkLinkUp(iface); err != nil {
-		return fmt.Errorf("Unable to start network bridge: %s", err)
+	if err := d.linkChange(func() error {
+		return netlink.NetworkSetMTU(iface, mtu)
+	}, "link", "set", name, "mtu", strconv.Itoa(mtu)); err != nil {
+		return fmt.Errorf("Unable to set the MTU of the bridge: %s", err)
 	}
 	return nil
 }
 
-func createBridgeIface(name string) error {
-	kv, err := kernel.GetKernelVersion()
-	// only set the bridge's mac address if the kernel version is > 3.3
-	// before that it was not supported
-	setBridgeMacAddr := err == nil && (kv.Kernel >= 3 && kv.Major >= 3)
-	log.Debugf("setting bridge mac address = %v", setBridgeMacAddr)
-	return netlink.CreateBridge(name, setBridgeMacAddr)
+// vethName returns the name of the host side of the veth pair of a
+// container, dkr- followed by as much of its id as the name of an
+// interface can hold, for tcpdump, tc and the monitoring to tell the
+// traffic of the container by.
+func vethName(id string) string {
+	const maxName = 15 // IFNAMSIZ, less the terminating nul
+	name := "dkr-" + id
+	if len(name) > maxName {
+		name = name[:maxName]
+	}
+	return name
 }
 
-// Generate a IEEE802 compliant MAC address from the given IP address.
-//
-// The generator is guaranteed to be consistent: the same IP will always yield the same
-// MAC address. This is to avoid ARP cache issues.
-func generateMacAddr(ip net.IP) net.HardwareAddr {
-	hw := make(net.HardwareAddr, 6)
-
-	// The first byte of the MAC address has to comply with these rules:
-	// 1. Unicast: Set the least-significant bit to 0.
-	// 2. Address is locally administered: Set the second-least-significant bit (U/L) to 1.
-	// 3. As "small" as possible: The veth address has to be "smaller" than the bridge address.
-	hw[0] = 0x02
-
-	// The first 24 bits of the MAC represent the Organizationally Unique Identifier (OUI).
-	// Since this address is locally administered, we can do whatever we want as long as
-	// it doesn't conflict with other addresses.
-	hw[1] = 0x42
-
-	// Insert the IP address into the last 32 bits of the MAC address.
-	// This is a simple way to guarantee the address will be consistent and unique.
-	copy(hw[2:], ip.To4())
-
-	return hw
+// linkLocalIPv6 returns the IPv6 link-local address the kernel gives the
+// interface of mac, its modified EUI-64.
+func linkLocalIPv6(mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0xfe, 0x80
+	ip[8], ip[9], ip[10] = mac[0]^0x02, mac[1], mac[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = mac[3], mac[4], mac[5]
+	return ip
 }
 
 // Allocate a network interface
-func Allocate(job *engine.Job) engine.Status {
+func (d *Driver) Allocate(job *engine.Job) engine.Status {
 	var (
 		ip          net.IP
 		mac         net.HardwareAddr
@@ -383,33 +985,167 @@ func Allocate(job *engine.Job) engine.Status {
 		requestedIP = net.ParseIP(job.Getenv("RequestedIP"))
 	)
 
-	if requestedIP != nil {
-		ip, err = ipallocator.RequestIP(bridgeNetwork, requestedIP)
+	if d.config.Rootless {
+		return d.allocateRootless(job)
+	}
+	ipRange, err := d.preAllocateIP(job, &requestedIP)
+	if err != nil {
+		return job.Error(err)
+	}
+	if job.GetenvBool("StickyIP") {
+		ip, err = d.allocateStickyIP(id, requestedIP, ipRange)
 	} else {
-		ip, err = ipallocator.RequestIP(bridgeNetwork, nil)
+		ip, err = d.requestIP(requestedIP, ipRange)
 	}
 	if err != nil {
 		return job.Error(err)
 	}
+	d.changes.own(ip, id)
 
 	// If no explicit mac address was given, generate a random one.
 	if mac, err = net.ParseMAC(job.Getenv("RequestedMac")); err != nil {
-		mac = generateMacAddr(ip)
+		mac = networkdriver.GenerateMacAddr(ip)
+	}
+	if d.policy != nil {
+		if err := d.policy.PostAllocateIP(&policy.PostAllocateIPRequest{Container: id, IP: ip.String(), MacAddress: mac.String()}); err != nil {
+			d.releaseInterface(&networkInterface{IP: ip})
+			return job.Error(err)
+		}
 	}
 
 	out := engine.Env{}
 	out.Set("IP", ip.String())
-	out.Set("Mask", bridgeNetwork.Mask.String())
-	out.Set("Gateway", bridgeNetwork.IP.String())
+	out.Set("Mask", d.bridgeNetwork.Mask.String())
+	out.Set("Gateway", d.bridgeNetwork.IP.String())
 	out.Set("MacAddress", mac.String())
-	out.Set("Bridge", bridgeIface)
+	out.Set("Bridge", d.bridgeIface)
+	out.Set("HostInterfaceName", vethName(id))
 
-	size, _ := bridgeNetwork.Mask.Size()
+	size, _ := d.bridgeNetwork.Mask.Size()
 	out.SetInt("IPPrefixLen", size)
 
-	currentInterfaces.Set(id, &networkInterface{
-		IP: ip,
-	})
+	iface := &networkInterface{
+		IP:        ip,
+		HostIface: vethName(id),
+	}
+	if err := setQuotas(iface, job); err != nil {
+		d.releaseInterface(iface)
+		return job.Error(err)
+	}
+	if mtu := job.GetenvInt("Mtu"); mtu != 0 {
+		if err := d.checkContainerMtu(mtu); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.Mtu = mtu
+	}
+	if job.EnvExists("Sysctls") {
+		var sysctls map[string]string
+		if err := job.GetenvJson("Sysctls", &sysctls); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		if err := checkSysctls(sysctls); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.Sysctls = sysctls
+	}
+	if names := job.GetenvList("NetDevices"); len(names) > 0 {
+		devices, err := d.claimNetDevices(id, names)
+		if err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.NetDevices = devices
+	}
+	if tap := job.Getenv("Tap"); tap != "" {
+		if err := d.setupTap(iface, id, tap, job.Getenv("TapUser")); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		out.Set("HostInterfaceName", tap)
+	}
+	if d.iptablesEnabled && (d.config.Accounting || job.GetenvBool("Accounting")) {
+		if err := d.startAccounting(ip); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.Accounted = true
+	}
+	if d.blockLinkLocal && job.GetenvBool("AllowLinkLocal") {
+		if err := d.allowLinkLocal(ip); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.LinkLocalAllowed = true
+	}
+	if group, requested := job.Getenv("SnatGroup"), job.Getenv("SnatIP"); group != "" || requested != "" {
+		if err := d.acquireSnat(iface, id, group, requested); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		out.Set("SnatIP", iface.SnatIP.String())
+	}
+	if dscp := job.Getenv("Dscp"); dscp != "" {
+		if !d.iptablesEnabled {
+			d.releaseInterface(iface)
+			return job.Errorf("DSCP marking requires iptables to be enabled")
+		}
+		if err := d.setupContainerDscp(ip, dscp); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.Dscp = dscp
+	}
+	if u, err := parseUplink(job.Getenv("EgressDevice"), job.Getenv("EgressGateway")); err != nil {
+		d.releaseInterface(iface)
+		return job.Error(err)
+	} else if u != nil {
+		if err := d.setupUplink(ip, u); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.Uplink = u
+	}
+	if b, err := parseBandwidth(job); err != nil {
+		d.releaseInterface(iface)
+		return job.Error(err)
+	} else if b != nil {
+		if err := d.applyShaping(ip, b, nil); err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		iface.Bandwidth = b
+	}
+	if name := job.Getenv("ServiceVIP"); name != "" {
+		if !d.iptablesEnabled {
+			d.releaseInterface(iface)
+			return job.Errorf("Service ips require iptables to be enabled")
+		}
+		vip, err := d.joinService(iface, id, name, net.ParseIP(job.Getenv("RequestedServiceVIP")), job.GetenvInt("ServiceCheckPort"))
+		if err != nil {
+			d.releaseInterface(iface)
+			return job.Error(err)
+		}
+		out.Set("ServiceVIP", vip.String())
+	}
+	if d.config.ProxyDualStack && ip.To4() != nil && len(mac) == 6 {
+		portmapper.SetFallbackAddr(ip, &net.IPAddr{IP: linkLocalIPv6(mac), Zone: d.bridgeIface})
+	}
+	d.currentInterfaces.Set(id, iface)
+	d.logEvent(job.Eng, eventAllocate, id, ip.String())
+	d.warnNeighTable()
+	d.checkPools(job.Eng)
+
+	// Restoring the interface must give it the same addresses
+	env := job.Environ()
+	env["RequestedIP"] = ip.String()
+	env["RequestedMac"] = mac.String()
+	if vip := out.Get("ServiceVIP"); vip != "" {
+		env["RequestedServiceVIP"] = vip
+	}
+	d.saveJob(id, "allocate_interface", env)
 
 	out.WriteTo(job.Stdout)
 
@@ -417,42 +1153,129 @@ func Allocate(job *engine.Job) engine.Status {
 }
 
 // release an interface for a select ip
-func Release(job *engine.Job) engine.Status {
+func (d *Driver) Release(job *engine.Job) engine.Status {
 	var (
 		id                 = job.Args[0]
-		containerInterface = currentInterfaces.Get(id)
+		containerInterface = d.currentInterfaces.Get(id)
 	)
 
 	if containerInterface == nil {
+		// The interface might not have been restored after a restart
+		d.forgetSavedJobs(id)
 		return job.Errorf("No network information to release for %s", id)
 	}
 
-	for _, nat := range containerInterface.PortMappings {
-		if err := portmapper.Unmap(nat); err != nil {
+	for _, nat := range d.hostMappings(containerInterface) {
+		d.logMappingEvent(job.Eng, eventUnmap, id, nat, addrDetail(nat))
+	}
+	d.releaseInterface(containerInterface)
+	d.currentInterfaces.Delete(id)
+	d.releaseMirrors(id)
+	d.forgetSavedJobs(id)
+	d.logEvent(job.Eng, eventRelease, id, containerInterface.IP.String())
+	return engine.StatusOK
+}
+
+// releaseInterface undoes everything set up for a container interface: its
+// port mappings, its firewall rules and its ip lease. It copes with partially
+// set up interfaces, so it is also used to roll back a failed allocation.
+func (d *Driver) releaseInterface(iface *networkInterface) {
+	if d.config.Rootless {
+		d.releaseRootless(iface)
+		return
+	}
+	unmap := portmapper.Unmap
+	if d.config.ResetConnections {
+		unmap = portmapper.UnmapReset
+	}
+	d.portsLock.Lock()
+	mappings := iface.PortMappings
+	iface.PortMappings = nil
+	d.portsLock.Unlock()
+	for _, nat := range mappings {
+		d.cancelExpiry(nat)
+		if err := unmap(nat); err != nil {
 			log.Infof("Unable to unmap port %s: %s", nat, err)
 		}
+		if d.protectHost {
+			d.unshareHostPort(mappedHostPort(nat))
+		}
 	}
+	portmapper.SetFallbackAddr(iface.IP, nil)
+	if d.config.ResetConnections {
+		d.flushContainerConntrack(iface)
+	}
+	d.releaseUpstream(iface)
 
-	if err := ipallocator.ReleaseIP(bridgeNetwork, containerInterface.IP); err != nil {
-		log.Infof("Unable to release ip %s", err)
+	if iface.EgressPolicy != nil {
+		d.removeEgressPolicy(iface.IP)
 	}
-	return engine.StatusOK
+	if iface.LinkLocalAllowed {
+		d.removeLinkLocalExemption(iface.IP)
+	}
+	if iface.Blackholed {
+		d.removeBlackhole(iface)
+	}
+	if iface.Draining {
+		d.undrain(iface)
+	}
+	if iface.Mirror != "" {
+		d.unmirror(iface)
+	}
+	d.releaseSnat(iface)
+	d.leaveService(iface)
+	if iface.Dscp != "" {
+		d.removeContainerDscp(iface.IP, iface.Dscp)
+	}
+	if iface.RoutingPolicy != nil {
+		d.removeRoutingPolicy(iface.IP, iface.RoutingPolicy)
+	}
+	if iface.Uplink != nil {
+		d.removeUplink(iface.IP, iface.Uplink)
+	}
+	if iface.Bandwidth != nil || iface.Netem != nil {
+		d.removeShaping(iface.IP)
+	}
+	if iface.Accounted {
+		d.stopAccounting(iface.IP)
+	}
+	if d.iptablesEnabled {
+		d.healContainer(iface.IP)
+	}
+
+	d.releaseSecondaryAddresses(iface)
+	d.releaseNetDevices(iface)
+	d.removeNetnsName(iface)
+	d.removeTap(iface)
+	// A reserved ip stays taken while the container is stopped
+	if !d.isReserved(iface.IP) {
+		if err := ipallocator.ReleaseIP(d.bridgeNetwork, iface.IP); err != nil {
+			log.Infof("Unable to release ip %s", err)
+		}
+	}
+	d.changes.disown(iface.IP)
 }
 
 // Allocate an external port and map it to the interface
-func AllocatePort(job *engine.Job) engine.Status {
+func (d *Driver) AllocatePort(job *engine.Job) engine.Status {
 	var (
 		err error
 
-		ip            = defaultBindingIP
+		ip            = d.getDefaultBindingIP()
 		id            = job.Args[0]
 		hostIP        = job.Getenv("HostIP")
 		hostPort      = job.GetenvInt("HostPort")
 		containerPort = job.GetenvInt("ContainerPort")
 		proto         = job.Getenv("Proto")
-		network       = currentInterfaces.Get(id)
+		noTrack       = job.GetenvBool("NoTrack")
+		upstream      = job.GetenvBool("Upstream")
+		network       = d.currentInterfaces.Get(id)
 	)
 
+	if upstream && d.upstream == nil {
+		return job.Errorf("Forwarding the port on the router requires an upstream forwarding protocol")
+	}
+
 	if hostIP != "" {
 		ip = net.ParseIP(hostIP)
 		if ip == nil {
@@ -460,6 +1283,41 @@ func AllocatePort(job *engine.Job) engine.Status {
 		}
 	}
 
+	if ip, err = d.restrictBindingIP(ip); err != nil {
+		return job.Error(err)
+	}
+	var expires time.Time
+	if e := job.Getenv("Expires"); e != "" {
+		if expires, err = time.Parse(time.RFC3339Nano, e); err != nil {
+			return job.Errorf("Bad parameter: invalid expiry %s", e)
+		}
+	}
+	// Named and labeled for the tools listing the mappings
+	name := job.Getenv("Name")
+	var labels map[string]string
+	if err := job.GetenvJson("Labels", &labels); err != nil {
+		return job.Errorf("Bad parameter: invalid labels %s", job.Getenv("Labels"))
+	}
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if err := d.reservePort(id, network); err != nil {
+		return job.Error(err)
+	}
+	defer d.unreservePort(network)
+	if d.config.Rootless {
+		switch {
+		case !expires.IsZero():
+			return job.Errorf("The ports published by the rootless network can't expire")
+		case name != "" || len(labels) > 0:
+			return job.Errorf("The ports published by the rootless network can't be named nor labeled")
+		}
+		return d.allocateRootlessPort(job, network, ip)
+	}
+	if ip, hostPort, err = d.preAllocatePort(job, ip, hostPort); err != nil {
+		return job.Error(err)
+	}
+
 	// host ip, proto, and host port
 	var container net.Addr
 	switch proto {
@@ -478,9 +1336,23 @@ func AllocatePort(job *engine.Job) engine.Status {
 	// yields.
 	//
 
-	var host net.Addr
+	var (
+		host     net.Addr
+		firewall portmapper.Firewall
+	)
+	if d.portChain != nil {
+		firewall = d.portChain
+		if !noTrack && iptables.IsLoopbackAlias(ip) {
+			d.portsLock.Lock()
+			err := d.setupLoopbackNat()
+			d.portsLock.Unlock()
+			if err != nil {
+				return job.Error(err)
+			}
+		}
+	}
 	for i := 0; i < MaxAllocatedPortAttempts; i++ {
-		if host, err = portmapper.Map(container, ip, hostPort); err == nil {
+		if host, err = portmapper.MapOnChain(firewall, container, ip, hostPort, noTrack); err == nil {
 			break
 		}
 
@@ -501,11 +1373,45 @@ func AllocatePort(job *engine.Job) engine.Status {
 		}
 	}
 
+	requestedPort := hostPort
+	if err != nil && hostPort != 0 && d.portConflict != nil && portTaken(err) {
+		host, err = d.resolvePortConflict(job, firewall, container, ip, hostPort, noTrack)
+	}
 	if err != nil {
 		return job.Error(err)
 	}
+	if d.policy != nil {
+		if err := d.policy.PostAllocatePort(&policy.PostAllocatePortRequest{
+			Container:     id,
+			Proto:         proto,
+			HostIP:        ip.String(),
+			HostPort:      mappedHostPort(host).Port,
+			ContainerIP:   network.IP.String(),
+			ContainerPort: containerPort,
+		}); err != nil {
+			portmapper.Unmap(host)
+			return job.Error(err)
+		}
+	}
+
+	// The userland proxy must stay reachable from the other containers
+	if d.protectHost {
+		if err := d.shareHostPort(mappedHostPort(host)); err != nil {
+			portmapper.Unmap(host)
+			return job.Error(err)
+		}
+	}
 
+	if name != "" || len(labels) > 0 {
+		portmapper.Label(host, name, labels)
+	}
+	d.portsLock.Lock()
 	network.PortMappings = append(network.PortMappings, host)
+	d.portsLock.Unlock()
+	d.logMappingEvent(job.Eng, eventMap, id, host, addrDetail(host)+"->"+container.String())
+	if !expires.IsZero() {
+		d.expireAt(job.Eng, id, host, expires)
+	}
 
 	out := engine.Env{}
 	switch netAddr := host.(type) {
@@ -516,6 +1422,31 @@ func AllocatePort(job *engine.Job) engine.Status {
 		out.Set("HostIP", netAddr.IP.String())
 		out.SetInt("HostPort", netAddr.Port)
 	}
+	if requestedPort != 0 && out.GetInt("HostPort") != requestedPort {
+		out.SetInt("RequestedHostPort", requestedPort)
+	}
+
+	// The router being out of the host's hands, failing to have it forward
+	// the port doesn't fail the mapping, it is tried again on renewal
+	if upstream {
+		f := &upstreamForwarding{Proto: proto, HostPort: out.GetInt("HostPort")}
+		d.portsLock.Lock()
+		network.Upstream = append(network.Upstream, f)
+		d.portsLock.Unlock()
+		if err := d.upstream.forward(f); err != nil {
+			log.WithField("container", id).Warnf("Unable to forward the port %s/%d on the router: %s", f.Proto, f.HostPort, err)
+		}
+		if port := d.upstream.port(f); port != 0 {
+			out.SetInt("UpstreamPort", port)
+		}
+	}
+
+	// Restoring the mapping must give it the same host port
+	env := job.Environ()
+	env["HostIP"] = out.Get("HostIP")
+	env["HostPort"] = out.Get("HostPort")
+	d.saveJob(id, "allocate_port", env)
+	d.checkPools(job.Eng)
 	if _, err := out.WriteTo(job.Stdout); err != nil {
 		return job.Error(err)
 	}
@@ -523,7 +1454,50 @@ func AllocatePort(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
-func LinkContainers(job *engine.Job) engine.Status {
+// ifaceIPs returns the IPv4 addresses of the named host interface.
+func ifaceIPs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("Interface %s has no IPv4 addresses to publish ports on", name)
+	}
+	return ips, nil
+}
+
+// restrictBindingIP enforces the publishing interfaces policy: unspecified
+// addresses are narrowed down to the first address of the first publishing
+// interface, explicit ones must belong to one of them.
+func (d *Driver) restrictBindingIP(ip net.IP) (net.IP, error) {
+	if len(d.publishIPs) == 0 {
+		return ip, nil
+	}
+	if ip.IsUnspecified() {
+		return d.publishIPs[0], nil
+	}
+	if d.isFloatingIP(ip) {
+		return ip, nil
+	}
+	for _, allowed := range d.publishIPs {
+		if allowed.Equal(ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("Bad parameter: %s is not an address of a publishing interface", ip)
+}
+
+func (d *Driver) LinkContainers(job *engine.Job) engine.Status {
 	var (
 		action       = job.Args[0]
 		childIP      = job.Getenv("ChildIP")
@@ -539,8 +1513,8 @@ func LinkContainers(job *engine.Job) engine.Status {
 
 	for _, p := range ports {
 		port, proto := split(p)
-		if output, err := iptables.Raw(useIpv6, action, "FORWARD",
-			"-i", bridgeIface, "-o", bridgeIface,
+		if output, err := d.firewall.Raw(useIpv6, action, "FORWARD",
+			"-i", d.bridgeIface, "-o", d.bridgeIface,
 			"-p", proto,
 			"-s", parentIP,
 			"--dport", port,
@@ -551,8 +1525,8 @@ func LinkContainers(job *engine.Job) engine.Status {
 			return job.Errorf("Error toggle iptables forward: %s", output)
 		}
 
-		if output, err := iptables.Raw(useIpv6, action, "FORWARD",
-			"-i", bridgeIface, "-o", bridgeIface,
+		if output, err := d.firewall.Raw(useIpv6, action, "FORWARD",
+			"-i", d.bridgeIface, "-o", d.bridgeIface,
 			"-p", proto,
 			"-s", childIP,
 			"--sport", port,
@@ -2,11 +2,16 @@ package bridge
 
 import (
 	"net"
+	"os/exec"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/daemon/networkdriver"
 	"github.com/docker/docker/daemon/networkdriver/portmapper"
 	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/iptables"
 )
 
 func init() {
@@ -14,6 +19,38 @@ func init() {
 	portmapper.NewProxy = portmapper.NewMockProxyCommand
 }
 
+// initTestDriver sets up a driver with the default settings, its jobs
+// installed on eng.
+func initTestDriver(t *testing.T, eng *engine.Engine) *Driver {
+	d, err := NewDriver(&Config{})
+	if err != nil {
+		t.Fatalf("Failed to initialize network driver: %s", err)
+	}
+	if err := d.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// dryRunDriver makes d plan the changes it would make to the host rather
+// than make them, d.changes.plan() listing them, until the function
+// returned puts it back.
+func dryRunDriver(d *Driver) func() {
+	if d.changes == nil {
+		d.changes = newJournal(false)
+	}
+	if d.firewall == nil {
+		d.firewall = iptables.NewContext()
+		d.firewall.SetRecorder(d.changes.record)
+	}
+	d.dryRun, d.changes.dryRun = true, true
+	d.firewall.SetDryRun(true)
+	return func() {
+		d.dryRun, d.changes.dryRun = false, false
+		d.firewall.SetDryRun(false)
+	}
+}
+
 func findFreePort(t *testing.T) int {
 	l, err := net.Listen("tcp", ":0")
 	if err != nil {
@@ -57,23 +94,20 @@ func TestAllocatePortDetection(t *testing.T) {
 	freePort := findFreePort(t)
 
 	// Init driver
-	job := eng.Job("initdriver")
-	if res := InitDriver(job); res != engine.StatusOK {
-		t.Fatal("Failed to initialize network driver")
-	}
+	d := initTestDriver(t, eng)
 
 	// Allocate interface
-	job = eng.Job("allocate_interface", "container_id")
-	if res := Allocate(job); res != engine.StatusOK {
+	job := eng.Job("allocate_interface", "container_id")
+	if res := d.Allocate(job); res != engine.StatusOK {
 		t.Fatal("Failed to allocate network interface")
 	}
 
 	// Allocate same port twice, expect failure on second call
 	job = newPortAllocationJob(eng, freePort)
-	if res := AllocatePort(job); res != engine.StatusOK {
+	if res := d.AllocatePort(job); res != engine.StatusOK {
 		t.Fatal("Failed to find a free port to allocate")
 	}
-	if res := AllocatePort(job); res == engine.StatusOK {
+	if res := d.AllocatePort(job); res == engine.StatusOK {
 		t.Fatal("Duplicate port allocation granted by AllocatePort")
 	}
 }
@@ -91,30 +125,247 @@ func TestHostnameFormatChecking(t *testing.T) {
 	}
 
 	// Allocate interface
-	job = eng.Job("allocate_interface", "container_id")
-	if res := Allocate(job); res != engine.StatusOK {
+	if err := eng.Job("allocate_interface", "container_id").Run(); err != nil {
 		t.Fatal("Failed to allocate network interface")
 	}
 
 	// Allocate port with invalid HostIP, expect failure with Bad Request http status
-	job = newPortAllocationJobWithInvalidHostIP(eng, freePort)
-	if res := AllocatePort(job); res == engine.StatusOK {
+	if err := newPortAllocationJobWithInvalidHostIP(eng, freePort).Run(); err == nil {
 		t.Fatal("Failed to check invalid HostIP")
 	}
 }
 
-func TestMacAddrGeneration(t *testing.T) {
-	ip := net.ParseIP("192.168.0.1")
-	mac := generateMacAddr(ip).String()
+func TestLinkLocalIPv6(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	if ip := linkLocalIPv6(mac); !ip.Equal(net.ParseIP("fe80::42:acff:fe11:2")) {
+		t.Fatalf("Expected the link-local address fe80::42:acff:fe11:2, got %s", ip)
+	}
+}
+
+func TestVethName(t *testing.T) {
+	if name := vethName("4fa6e0f0c6786287f1ba6c1c7ba4d4b0d14c5e5fda1a7c9e0a47b4c0d1bc8e1f"); name != "dkr-4fa6e0f0c67" {
+		t.Fatalf("Expected the veth dkr-4fa6e0f0c67, got %s", name)
+	}
+	if name := vethName("short"); name != "dkr-short" {
+		t.Fatalf("Expected the veth dkr-short, got %s", name)
+	}
+
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	job := eng.Job("allocate_interface", "veth_container")
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("HostInterfaceName") != "dkr-veth_contai" || d.currentInterfaces.Get("veth_container").HostIface != "dkr-veth_contai" {
+		t.Fatalf("Expected the veth to be named after the container, got %s", out.Get("HostInterfaceName"))
+	}
+}
+
+func TestRestrictBindingIP(t *testing.T) {
+	d := newDriver(&Config{})
+
+	unspecified := net.ParseIP("0.0.0.0")
+	if ip, err := d.restrictBindingIP(unspecified); err != nil || !ip.Equal(unspecified) {
+		t.Fatalf("Expected unrestricted binding, got %s (%v)", ip, err)
+	}
+
+	d.publishIPs = []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}
+	if ip, err := d.restrictBindingIP(unspecified); err != nil || !ip.Equal(d.publishIPs[0]) {
+		t.Fatalf("Expected %s, got %s (%v)", d.publishIPs[0], ip, err)
+	}
+	if ip, err := d.restrictBindingIP(net.ParseIP("10.0.0.3")); err != nil || !ip.Equal(d.publishIPs[1]) {
+		t.Fatalf("Expected %s, got %s (%v)", d.publishIPs[1], ip, err)
+	}
+	if _, err := d.restrictBindingIP(net.ParseIP("192.168.1.1")); err == nil {
+		t.Fatal("Expected binding outside of the publishing interfaces to fail")
+	}
+}
+
+func TestBridgeAddressCandidates(t *testing.T) {
+	d := newDriver(&Config{CandidateAddrs: []string{"198.18.42.1/24"}})
+	defer func() {
+		checkRouteOverlaps = networkdriver.CheckRouteOverlaps
+	}()
+	var taken []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		taken = append(taken, network)
+	}
+	checkRouteOverlaps = func(toCheck *net.IPNet, ignored ...*net.IPNet) error {
+		for _, network := range taken {
+			if networkdriver.NetworkOverlaps(toCheck, network) {
+				return &networkdriver.OverlapError{Network: toCheck, Conflict: network}
+			}
+		}
+		return nil
+	}
+
+	// The private ranges all overlap the routes of a VPN
+	if addr, err := d.bridgeAddress(""); err != nil || addr != "100.64.42.1/16" {
+		t.Fatalf("Expected the first shared range, got %s (%v)", addr, err)
+	}
+	_, shared, _ := net.ParseCIDR("100.64.0.0/10")
+	taken = append(taken, shared)
+	if addr, err := d.bridgeAddress(""); err != nil || addr != "198.18.42.1/24" {
+		t.Fatalf("Expected the candidate of the configuration, got %s (%v)", addr, err)
+	}
+	_, candidate, _ := net.ParseCIDR("198.18.0.0/15")
+	taken = append(taken, candidate)
+	if _, err := d.bridgeAddress(""); err == nil {
+		t.Fatal("Expected no range to be left")
+	}
+}
+
+func TestNatRuleArgs(t *testing.T) {
+	d := newDriver(&Config{})
+
+	addr := &net.IPNet{IP: net.ParseIP("172.17.42.1"), Mask: net.CIDRMask(16, 32)}
+	masq := strings.Join(d.natRuleArgs(addr, nil), " ")
+	if expected := "POSTROUTING -t nat -s 172.17.42.1/16 ! -o docker0 -j MASQUERADE"; masq != expected {
+		t.Fatalf("Expected %q, got %q", expected, masq)
+	}
+	snat := strings.Join(d.natRuleArgs(addr, net.ParseIP("10.0.0.2")), " ")
+	if expected := "POSTROUTING -t nat -s 172.17.42.1/16 ! -o docker0 -j SNAT --to-source 10.0.0.2"; snat != expected {
+		t.Fatalf("Expected %q, got %q", expected, snat)
+	}
+}
+
+func TestRemoveNatRules(t *testing.T) {
+	e := &RecordingExecutor{}
+	e.Respond = func(name string, args []string) ([]byte, error) {
+		if strings.Contains(strings.Join(args, " "), "-S POSTROUTING") {
+			return []byte(strings.Join([]string{
+				"-P POSTROUTING ACCEPT",
+				"-A POSTROUTING -s 172.17.0.5/32 ! -o docker0 -j SNAT --to-source 10.0.0.3",
+				"-A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE",
+				"-A POSTROUTING -s 10.1.0.0/16 ! -o docker1 -j MASQUERADE",
+			}, "\n")), nil
+		}
+		return nil, nil
+	}
+	d := newDriver(&Config{Executor: e})
+	d.removeNatRules(false)
+	var deleted []string
+	for _, line := range e.Lines() {
+		if strings.Contains(line, " -D ") {
+			deleted = append(deleted, line)
+		}
+	}
+	// The SNAT rules of the containers and the rules of other bridges are kept
+	if len(deleted) != 1 || !strings.HasSuffix(deleted[0], "-t nat -D POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE") {
+		t.Fatalf("Expected only the bridge wide rule to be deleted, got %v", deleted)
+	}
+}
+
+func TestMultipleDrivers(t *testing.T) {
+	eng1, eng2 := engine.New(), engine.New()
+	eng1.Logging, eng2.Logging = false, false
+
+	d1 := initTestDriver(t, eng1)
+	d2, err := NewDriver(&Config{Chain: "DOCKER-TEST"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Install(eng2); err != nil {
+		t.Fatal(err)
+	}
+	if d2.accountingChain != "DOCKER-TEST-ACCT" || d2.egressChainName(net.ParseIP("172.17.0.2")) != "DOCKER-TEST-EG-172.17.0.2" {
+		t.Fatalf("Expected the chains of the driver to be namespaced, got %s", d2.accountingChain)
+	}
+
+	if err := eng1.Job("allocate_interface", "multi_container").Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer eng1.Job("release_interface", "multi_container").Run()
+	if d1.currentInterfaces.Get("multi_container") == nil {
+		t.Fatal("Expected the interface to be allocated by the first driver")
+	}
+	if d2.currentInterfaces.Get("multi_container") != nil {
+		t.Fatal("Expected the interface not to be seen by the second driver")
+	}
+	if err := eng2.Job("release_interface", "multi_container").Run(); err == nil {
+		t.Fatal("Expected the second driver to know nothing of the interface")
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	timeout := 50 * time.Millisecond
 
-	// Should be consistent.
-	if generateMacAddr(ip).String() != mac {
-		t.Fatal("Inconsistent MAC address")
+	start := time.Now()
+	if _, err := commandOutput(exec.Command("sleep", "5"), timeout); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected the command to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the command to be killed, it ran for %s", elapsed)
+	}
+	// A loaded machine can take longer than the timeout to run a command
+	if output, err := commandOutput(exec.Command("echo", "done"), 10*time.Second); err != nil || string(output) != "done\n" {
+		t.Fatalf("Unexpected output %q (%v)", output, err)
 	}
+}
 
-	// Should be unique.
-	ip2 := net.ParseIP("192.168.0.2")
-	if generateMacAddr(ip2).String() == mac {
-		t.Fatal("Non-unique MAC address")
+func TestAllocatePortConflict(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	freePort := findFreePort(t)
+	otherPort := findFreePort(t)
+
+	d, err := NewDriver(&Config{PortConflict: strconv.Itoa(otherPort)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+	if res := d.Allocate(eng.Job("allocate_interface", "container_id")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+
+	// The port taken is substituted by the one of the set
+	job := newPortAllocationJob(eng, freePort)
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate the free port")
+	}
+	job = newPortAllocationJob(eng, freePort)
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatalf("Expected the port taken to be substituted, got %s", err)
+	}
+	if out.GetInt("HostPort") != otherPort || out.GetInt("RequestedHostPort") != freePort {
+		t.Fatalf("Expected %d in place of %d, got %v", otherPort, freePort, out)
+	}
+
+	// Once the set is taken as well, the allocation fails
+	if res := d.AllocatePort(newPortAllocationJob(eng, freePort)); res == engine.StatusOK {
+		t.Fatal("Expected the allocation to fail with the ports of the set taken")
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	p, err := parseConflictPolicy("8000-8002,9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports := p.candidates(8001); len(ports) != 3 || ports[0] != 8000 || ports[1] != 8002 || ports[2] != 9000 {
+		t.Fatalf("Unexpected candidates %v", ports)
+	}
+	p, _ = parseConflictPolicy(portConflictNext)
+	if ports := p.candidates(65533); len(ports) != 2 || ports[0] != 65534 {
+		t.Fatalf("Unexpected candidates %v", ports)
+	}
+	for _, spec := range []string{"later", "9000-8000", "0", "8000-70000", ""} {
+		if _, err := parseConflictPolicy(spec); err == nil {
+			t.Fatalf("Expected %q to be refused", spec)
+		}
 	}
 }
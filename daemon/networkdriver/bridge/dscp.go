@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// dscpClasses are the class names understood by the iptables DSCP target.
+var dscpClasses = map[string]bool{
+	"CS0": true, "CS1": true, "CS2": true, "CS3": true, "CS4": true, "CS5": true, "CS6": true, "CS7": true,
+	"AF11": true, "AF12": true, "AF13": true, "AF21": true, "AF22": true, "AF23": true,
+	"AF31": true, "AF32": true, "AF33": true, "AF41": true, "AF42": true, "AF43": true,
+	"EF": true, "BE": true,
+}
+
+// parseDscp validates a DSCP value, either a number between 0 and 63 or a
+// class name such as AF41 or EF, and returns the matching target arguments.
+func parseDscp(value string) ([]string, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 0 || n > 63 {
+			return nil, fmt.Errorf("Invalid DSCP value %d: must be between 0 and 63", n)
+		}
+		return []string{"-j", "DSCP", "--set-dscp", strconv.Itoa(n)}, nil
+	}
+	class := strings.ToUpper(value)
+	if !dscpClasses[class] {
+		return nil, fmt.Errorf("Invalid DSCP class %s", value)
+	}
+	return []string{"-j", "DSCP", "--set-dscp-class", class}, nil
+}
+
+// dscpArgs returns the mangle rule marking traffic from the bridge, or from
+// a single container if ip is not nil.
+func (d *Driver) dscpArgs(ip net.IP, target []string) []string {
+	args := []string{"PREROUTING", "-t", "mangle", "-i", d.bridgeIface}
+	if ip != nil {
+		args = append(args, "-s", ip.String())
+	}
+	return append(args, target...)
+}
+
+// setupNetworkDscp marks all the traffic of the bridge, or none if value is
+// empty. It is inserted first in the chain so per container markings, which
+// are appended, win.
+func (d *Driver) setupNetworkDscp(value string) error {
+	// Drop the marking of a previous configuration
+	d.firewall.DeleteMatching(false, "mangle", "PREROUTING", func(rule []string) bool {
+		r := strings.Join(rule, " ")
+		return strings.HasPrefix(r, "-i "+d.bridgeIface+" -j DSCP ")
+	})
+	if value == "" {
+		return nil
+	}
+
+	target, err := parseDscp(value)
+	if err != nil {
+		return err
+	}
+	return d.execRule(false, append([]string{"-I"}, d.dscpArgs(nil, target)...)...)
+}
+
+func (d *Driver) setupContainerDscp(ip net.IP, value string) error {
+	target, err := parseDscp(value)
+	if err != nil {
+		return err
+	}
+	return d.execRule(false, append([]string{"-A"}, d.dscpArgs(ip, target)...)...)
+}
+
+func (d *Driver) removeContainerDscp(ip net.IP, value string) {
+	if target, err := parseDscp(value); err == nil {
+		d.firewall.Raw(false, append([]string{"-D"}, d.dscpArgs(ip, target)...)...)
+	}
+}
@@ -0,0 +1,28 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDscp(t *testing.T) {
+	for value, expected := range map[string]string{
+		"0":    "-j DSCP --set-dscp 0",
+		"46":   "-j DSCP --set-dscp 46",
+		"af41": "-j DSCP --set-dscp-class AF41",
+		"EF":   "-j DSCP --set-dscp-class EF",
+	} {
+		target, err := parseDscp(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(target, " ") != expected {
+			t.Fatalf("Expected %q, got %q", expected, strings.Join(target, " "))
+		}
+	}
+	for _, value := range []string{"", "-1", "64", "AF44", "gold"} {
+		if _, err := parseDscp(value); err == nil {
+			t.Fatalf("Expected error parsing %q", value)
+		}
+	}
+}
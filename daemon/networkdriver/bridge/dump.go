@@ -0,0 +1,213 @@
+package bridge
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// interfaceState describes a container interface, along with the iptables
+// rules the driver set up for it.
+type interfaceState struct {
+	IP            string
+	HostInterface string            `json:",omitempty"` // host side of the veth pair
+	Mtu           int               `json:",omitempty"`
+	Sysctls       map[string]string `json:",omitempty"`
+	NetDevices    []string          `json:",omitempty"` // physical interfaces given to the container
+	Netns         string            `json:",omitempty"` // name of the network namespace
+	Tap           string            `json:",omitempty"` // tap device of a virtual machine
+	SecondaryIPs  []string          `json:",omitempty"`
+	PortMappings  []string          `json:",omitempty"`
+	EgressPolicy  []string          `json:",omitempty"`
+	SnatIP        string            `json:",omitempty"`
+	Dscp          string            `json:",omitempty"`
+	RoutingPolicy *routingPolicy
+	Uplink        *uplink
+	Bandwidth     *bandwidth
+	Netem         *netem
+	Upstream      []*upstreamForwarding `json:",omitempty"`
+	Rules         []string
+}
+
+// networkState is the state of the driver as it believes it to be, to be
+// compared with the actual state of the kernel.
+type networkState struct {
+	Bridge     string
+	Network    string
+	Iptables   bool
+	Interfaces map[string]*interfaceState
+	Mappings   []portmapper.MappingState
+	Partitions [][2]string
+	Rules      []string
+	Planned    []string `json:",omitempty"` // changes skipped in dry-run mode
+}
+
+func ruleString(action string, args []string) string {
+	return action + " " + strings.Join(args, " ")
+}
+
+// interfaceRules returns the iptables rules the driver set up for iface, in
+// the form they were added.
+func (d *Driver) interfaceRules(iface *networkInterface) []string {
+	var rules []string
+	if iface.Accounted {
+		for _, args := range d.accountingArgs(iface.IP) {
+			rules = append(rules, ruleString("-A", args))
+		}
+	}
+	if iface.EgressPolicy != nil {
+		rules = append(rules, ruleString("-I", d.egressJumpArgs(iface.IP)))
+		for _, args := range egressChainRules(d.egressChainName(iface.IP), iface.EgressPolicy) {
+			rules = append(rules, strings.Join(args, " "))
+		}
+	}
+	if iface.LinkLocalAllowed {
+		rules = append(rules, ruleString("-I", d.linkLocalExemptArgs(iface.IP)))
+	}
+	if iface.SnatIP != nil {
+		rules = append(rules, ruleString("-I", d.snatArgs(iface.IP, iface.SnatIP)))
+	}
+	if iface.Dscp != "" {
+		if target, err := parseDscp(iface.Dscp); err == nil {
+			rules = append(rules, ruleString("-A", d.dscpArgs(iface.IP, target)))
+		}
+	}
+	if iface.RoutingPolicy != nil {
+		rules = append(rules, ruleString("-A", d.policyMarkArgs(iface.IP, iface.RoutingPolicy.Table)))
+	}
+	return rules
+}
+
+// networkRules returns the bridge wide iptables rules of the driver's own
+// features.
+func (d *Driver) networkRules() []string {
+	var rules []string
+	if d.accountingSetUp() {
+		for _, args := range d.accountingJumps() {
+			rules = append(rules, ruleString("-I", args))
+		}
+	}
+	if d.blockLinkLocal {
+		rules = append(rules, ruleString("-I", d.linkLocalBlockArgs()))
+	}
+	if d.iptablesEnabled && d.config != nil && d.config.AllowIcmp {
+		for _, args := range d.icmpArgs(IsIpv6(d.bridgeNetwork)) {
+			rules = append(rules, ruleString("-I", args))
+		}
+	}
+	if d.iptablesEnabled && d.config != nil && d.config.Multicast {
+		for _, args := range d.multicastArgs(IsIpv6(d.bridgeNetwork)) {
+			rules = append(rules, ruleString("-I", args))
+		}
+	}
+	if d.protectHost {
+		rules = append(rules, ruleString("-I", d.hostAccessJumpArgs()))
+	}
+	return rules
+}
+
+func (d *Driver) currentState() *networkState {
+	state := &networkState{
+		Bridge:     d.bridgeIface,
+		Network:    d.bridgeNetwork.String(),
+		Iptables:   d.iptablesEnabled,
+		Interfaces: make(map[string]*interfaceState),
+		Mappings:   portmapper.Mappings(),
+		Planned:    d.changes.plan(),
+	}
+	if d.iptablesEnabled {
+		state.Rules = d.networkRules()
+	}
+
+	for id, iface := range d.currentInterfaces.All() {
+		s := &interfaceState{
+			IP:            iface.IP.String(),
+			HostInterface: iface.HostIface,
+			Mtu:           iface.Mtu,
+			Sysctls:       iface.Sysctls,
+			Netns:         iface.Netns,
+			Tap:           iface.Tap,
+			Dscp:          iface.Dscp,
+			RoutingPolicy: iface.RoutingPolicy,
+			Uplink:        iface.Uplink,
+			Bandwidth:     iface.Bandwidth,
+			Netem:         iface.Netem,
+			Rules:         d.interfaceRules(iface),
+		}
+		for _, ip := range iface.SecondaryIPs {
+			s.SecondaryIPs = append(s.SecondaryIPs, ip.String())
+		}
+		for _, dev := range iface.NetDevices {
+			s.NetDevices = append(s.NetDevices, dev.Name)
+		}
+		for _, f := range d.upstreamForwardings(iface) {
+			forwarding := *f
+			forwarding.UpstreamPort = d.upstream.port(f)
+			s.Upstream = append(s.Upstream, &forwarding)
+		}
+		for _, addr := range d.hostMappings(iface) {
+			s.PortMappings = append(s.PortMappings, addr.String())
+		}
+		for _, r := range iface.EgressPolicy {
+			s.EgressPolicy = append(s.EgressPolicy, r.String())
+		}
+		if iface.SnatIP != nil {
+			s.SnatIP = iface.SnatIP.String()
+		}
+		state.Interfaces[id] = s
+	}
+
+	d.partitions.Lock()
+	for p := range d.partitions.pairs {
+		state.Partitions = append(state.Partitions, [2]string(p))
+		state.Rules = append(state.Rules,
+			ruleString("-I", d.partitionArgs(p[0], p[1])),
+			ruleString("-I", d.partitionArgs(p[1], p[0])))
+	}
+	d.partitions.Unlock()
+
+	return state
+}
+
+// DumpState writes the state of the driver as JSON: the bridge, the
+// container interfaces, the port mappings and the iptables rules the driver
+// believes to be set up. It helps diagnosing drifts between the daemon and
+// the kernel.
+func (d *Driver) DumpState(job *engine.Job) engine.Status {
+	if err := json.NewEncoder(job.Stdout).Encode(d.currentState()); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// dumpStateOnSignal logs the state of the driver each time the daemon gets
+// a SIGUSR1, until stopStateDump is called.
+func (d *Driver) dumpStateOnSignal() {
+	d.stopStateDump()
+	c := make(chan os.Signal, 1)
+	d.stateDumpSignals = c
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for _ = range c {
+			data, err := json.MarshalIndent(d.currentState(), "", "  ")
+			if err != nil {
+				log.Errorf("Unable to dump the network state: %s", err)
+				continue
+			}
+			log.Infof("Network state:\n%s", data)
+		}
+	}()
+}
+
+func (d *Driver) stopStateDump() {
+	if d.stateDumpSignals != nil {
+		signal.Stop(d.stateDumpSignals)
+		close(d.stateDumpSignals)
+		d.stateDumpSignals = nil
+	}
+}
@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestInterfaceRules(t *testing.T) {
+	d := newDriver(&Config{})
+
+	iface := &networkInterface{
+		IP:            net.ParseIP("172.17.0.2"),
+		Accounted:     true,
+		SnatIP:        net.ParseIP("10.0.0.5"),
+		Dscp:          "EF",
+		RoutingPolicy: &routingPolicy{Table: 100},
+	}
+	expected := []string{
+		"-A DOCKER-ACCT -s 172.17.0.2",
+		"-A DOCKER-ACCT -d 172.17.0.2",
+		"-I POSTROUTING -t nat -s 172.17.0.2 ! -o docker0 -j SNAT --to-source 10.0.0.5",
+		"-A PREROUTING -t mangle -i docker0 -s 172.17.0.2 -j DSCP --set-dscp-class EF",
+		"-A PREROUTING -t mangle -i docker0 -s 172.17.0.2 -j MARK --set-mark 100",
+	}
+	rules := d.interfaceRules(iface)
+	if strings.Join(rules, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected rules:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(rules, "\n"))
+	}
+
+	if rules := d.interfaceRules(&networkInterface{IP: net.ParseIP("172.17.0.3")}); len(rules) != 0 {
+		t.Fatalf("Expected no rules, got %v", rules)
+	}
+}
@@ -0,0 +1,160 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/engine"
+)
+
+// egressRule allows outbound traffic from a container to a destination
+// network, optionally restricted to a single port and protocol.
+type egressRule struct {
+	Network *net.IPNet
+	Proto   string
+	Port    int
+}
+
+// parseEgressRule parses a rule of the form CIDR[:PORT[/PROTO]], e.g.
+// "10.0.0.0/8", "10.1.2.3/32:5432" or "10.1.2.3/32:53/udp". The protocol
+// defaults to tcp when a port is given.
+func parseEgressRule(rule string) (*egressRule, error) {
+	parts := strings.SplitN(rule, ":", 2)
+	_, network, err := net.ParseCIDR(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid egress rule %s: %s", rule, err)
+	}
+	r := &egressRule{Network: network}
+	if len(parts) == 1 {
+		return r, nil
+	}
+
+	port, proto := parts[1], "tcp"
+	if i := strings.Index(port, "/"); i != -1 {
+		port, proto = port[:i], port[i+1:]
+	}
+	if proto != "tcp" && proto != "udp" {
+		return nil, fmt.Errorf("Invalid egress rule %s: unsupported protocol %s", rule, proto)
+	}
+	if r.Port, err = strconv.Atoi(port); err != nil || r.Port <= 0 || r.Port > 65535 {
+		return nil, fmt.Errorf("Invalid egress rule %s: invalid port %s", rule, port)
+	}
+	r.Proto = proto
+	return r, nil
+}
+
+func (r *egressRule) String() string {
+	if r.Port == 0 {
+		return r.Network.String()
+	}
+	return fmt.Sprintf("%s:%d/%s", r.Network, r.Port, r.Proto)
+}
+
+// egressChainName returns the name of the filter chain holding the egress
+// policy of the container with the given ip.
+func (d *Driver) egressChainName(ip net.IP) string {
+	return shortChainName(d.chain+"-EG-", ip)
+}
+
+// egressChainRules renders the rules of an egress chain. Replies to
+// connections initiated from outside are always let through, everything
+// else must match one of the allowed destinations.
+func egressChainRules(chain string, rules []*egressRule) [][]string {
+	out := [][]string{
+		{"-A", chain, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+	}
+	for _, r := range rules {
+		args := []string{"-A", chain, "-d", r.Network.String()}
+		if r.Port != 0 {
+			args = append(args, "-p", r.Proto, "--dport", strconv.Itoa(r.Port))
+		}
+		out = append(out, append(args, "-j", "RETURN"))
+	}
+	return append(out, []string{"-A", chain, "-j", "DROP"})
+}
+
+func (d *Driver) egressJumpArgs(ip net.IP) []string {
+	return []string{"FORWARD", "-i", d.bridgeIface, "-s", ip.String(), "-j", d.egressChainName(ip)}
+}
+
+// applyEgressPolicy (re)creates the egress chain of the container with the
+// given ip and hooks it into FORWARD for traffic leaving the bridge.
+func (d *Driver) applyEgressPolicy(ip net.IP, rules []*egressRule) error {
+	var (
+		ipv6  = ip.To4() == nil
+		chain = d.egressChainName(ip)
+	)
+
+	d.removeEgressPolicy(ip)
+
+	if err := d.execRule(ipv6, "-N", chain); err != nil {
+		return err
+	}
+	for _, args := range egressChainRules(chain, rules) {
+		if err := d.execRule(ipv6, args...); err != nil {
+			d.removeEgressPolicy(ip)
+			return err
+		}
+	}
+	if err := d.execRule(ipv6, append([]string{"-I"}, d.egressJumpArgs(ip)...)...); err != nil {
+		d.removeEgressPolicy(ip)
+		return err
+	}
+	return nil
+}
+
+// removeEgressPolicy removes the egress chain of the container with the
+// given ip. Errors are ignored, the chain might not exist.
+func (d *Driver) removeEgressPolicy(ip net.IP) {
+	var (
+		ipv6  = ip.To4() == nil
+		chain = d.egressChainName(ip)
+	)
+	d.firewall.Raw(ipv6, append([]string{"-D"}, d.egressJumpArgs(ip)...)...)
+	d.firewall.Raw(ipv6, "-F", chain)
+	d.firewall.Raw(ipv6, "-X", chain)
+}
+
+// SetEgressPolicy replaces the outbound firewall policy of a container.
+// The "Allow" list holds the permitted destinations; an empty list removes
+// the policy and leaves the container unrestricted.
+func (d *Driver) SetEgressPolicy(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		allow   = job.GetenvList("Allow")
+		network = d.currentInterfaces.Get(id)
+		rules   []*egressRule
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if !d.iptablesEnabled {
+		return job.Errorf("Egress policies require iptables to be enabled")
+	}
+
+	for _, a := range allow {
+		r, err := parseEgressRule(a)
+		if err != nil {
+			return job.Error(err)
+		}
+		rules = append(rules, r)
+	}
+
+	if len(rules) == 0 {
+		d.removeEgressPolicy(network.IP)
+		network.EgressPolicy = nil
+		d.saveJob(id, job.Name, job.Environ())
+		return engine.StatusOK
+	}
+
+	log.WithField("container", id).Debugf("Setting egress policy to %v", rules)
+	if err := d.applyEgressPolicy(network.IP, rules); err != nil {
+		return job.Error(err)
+	}
+	network.EgressPolicy = rules
+	d.saveJob(id, job.Name, job.Environ())
+	return engine.StatusOK
+}
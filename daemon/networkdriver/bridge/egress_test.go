@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseEgressRule(t *testing.T) {
+	for rule, expected := range map[string]string{
+		"10.0.0.0/8":            "10.0.0.0/8",
+		"10.1.2.3/32:5432":      "10.1.2.3/32:5432/tcp",
+		"10.1.2.0/24:53/udp":    "10.1.2.0/24:53/udp",
+		"192.168.1.7/16:80/tcp": "192.168.0.0/16:80/tcp",
+	} {
+		r, err := parseEgressRule(rule)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", rule, err)
+		}
+		if r.String() != expected {
+			t.Fatalf("Expected %s, got %s", expected, r)
+		}
+	}
+
+	for _, rule := range []string{"", "10.0.0.1", "10.0.0.0/8:", "10.0.0.0/8:0", "10.0.0.0/8:70000", "10.0.0.0/8:53/icmp"} {
+		if _, err := parseEgressRule(rule); err == nil {
+			t.Fatalf("Expected error parsing %q", rule)
+		}
+	}
+}
+
+func TestEgressChainRules(t *testing.T) {
+	d := newDriver(&Config{})
+	ip := net.ParseIP("172.17.0.5")
+	chain := d.egressChainName(ip)
+	if chain != "DOCKER-EG-172.17.0.5" {
+		t.Fatalf("Unexpected chain name %s", chain)
+	}
+
+	r1, _ := parseEgressRule("10.0.0.0/8")
+	r2, _ := parseEgressRule("10.1.2.3/32:53/udp")
+	rules := egressChainRules(chain, []*egressRule{r1, r2})
+
+	expected := []string{
+		"-A DOCKER-EG-172.17.0.5 -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN",
+		"-A DOCKER-EG-172.17.0.5 -d 10.0.0.0/8 -j RETURN",
+		"-A DOCKER-EG-172.17.0.5 -d 10.1.2.3/32 -p udp --dport 53 -j RETURN",
+		"-A DOCKER-EG-172.17.0.5 -j DROP",
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %d", len(expected), len(rules))
+	}
+	for i, args := range rules {
+		if strings.Join(args, " ") != expected[i] {
+			t.Fatalf("Expected %q, got %q", expected[i], strings.Join(args, " "))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// Network events are published on the daemon event stream, with the
+// container id and the details of the operation in place of the image.
+const (
+	eventAllocate = "net:allocate"
+	eventRelease  = "net:release"
+	eventMap      = "net:map"
+	eventUnmap    = "net:unmap"
+	eventRepair   = "net:repair"
+
+	// A floating ip arrived on the host or left it, the detail being the ip
+	// followed by "arrived" or "left"
+	eventFloatingIP = "net:vip"
+
+	// The traffic of a service fails over to a backend, the detail being the
+	// name of the service followed by its VIP
+	eventFailover = "net:failover"
+
+	// A port mapping expired and was removed, the detail being its host
+	// address
+	eventExpire = "net:expire"
+
+	// A container got a secondary address, the detail being the address
+	eventAddress = "net:address"
+
+	// A route added to the host overlaps the network of the bridge, the
+	// detail being its destination followed by its interface
+	eventRouteOverlap = "net:route-overlap"
+
+	// A pool of the container ips or of the host ports crossed a threshold,
+	// the detail being "ip" followed by the network, or the protocol
+	// followed by the host ip, then by the percentage allocated
+	eventPoolUsage = "net:pool-usage"
+)
+
+// logEvent publishes a network event, and gives it to the hooks if any.
+// Errors are only logged, there might be no event stream, when the driver
+// is used on its own for instance.
+func (d *Driver) logEvent(eng *engine.Engine, action, id, detail string) {
+	d.publishEvent(eng, &hookEvent{Event: action, Container: id, Detail: detail})
+}
+
+// logMappingEvent publishes an event of the port mapping of host, named
+// after it in the event stream, its name and labels given to the hooks.
+func (d *Driver) logMappingEvent(eng *engine.Engine, action, id string, host net.Addr, detail string) {
+	e := &hookEvent{Event: action, Container: id, Detail: detail}
+	e.Name, e.Labels = portmapper.Labels(host)
+	d.publishEvent(eng, e)
+}
+
+func (d *Driver) publishEvent(eng *engine.Engine, e *hookEvent) {
+	detail := e.Detail
+	if e.Name != "" {
+		detail += " " + e.Name
+	}
+	if err := eng.Job("log", e.Event, e.Container, detail).Run(); err != nil {
+		log.WithField("container", e.Container).Debugf("Unable to log %s event: %s", e.Event, err)
+	}
+	if d.hooks != nil {
+		e.Bridge = d.bridgeIface
+		e.Time = time.Now().UTC()
+		d.hooks.send(e)
+	}
+}
+
+// addrDetail describes a port mapping address, ex: "tcp/0.0.0.0:49153".
+func addrDetail(addr net.Addr) string {
+	return fmt.Sprintf("%s/%s", addr.Network(), addr)
+}
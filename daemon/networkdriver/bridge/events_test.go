@@ -0,0 +1,101 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestNetworkEvents(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	var events []string
+	eng.Register("log", func(job *engine.Job) engine.Status {
+		events = append(events, strings.Join(job.Args, " "))
+		return engine.StatusOK
+	})
+
+	d := initTestDriver(t, eng)
+
+	job := eng.Job("allocate_interface", "events_container")
+	if res := d.Allocate(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	ip := d.currentInterfaces.Get("events_container").IP.String()
+
+	port := strconv.Itoa(findFreePort(t))
+	job = eng.Job("allocate_port", "events_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("HostPort", port)
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", port)
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate port")
+	}
+
+	if res := d.Release(eng.Job("release_interface", "events_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+
+	mapping := "tcp/127.0.0.1:" + port
+	expected := []string{
+		eventAllocate + " events_container " + ip,
+		eventMap + " events_container " + mapping + "->" + ip + ":" + port,
+		eventUnmap + " events_container " + mapping,
+		eventRelease + " events_container " + ip,
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected events:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(events, "\n"))
+	}
+}
+
+func TestLabeledMappingEvents(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	var events []string
+	eng.Register("log", func(job *engine.Job) engine.Status {
+		events = append(events, strings.Join(job.Args, " "))
+		return engine.StatusOK
+	})
+
+	d := initTestDriver(t, eng)
+	if res := d.Allocate(eng.Job("allocate_interface", "labeled_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	defer d.Release(eng.Job("release_interface", "labeled_container"))
+	ip := d.currentInterfaces.Get("labeled_container").IP.String()
+
+	port := strconv.Itoa(findFreePort(t))
+	job := eng.Job("allocate_port", "labeled_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("HostPort", port)
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", port)
+	job.Setenv("Name", "http")
+	job.SetenvJson("Labels", map[string]string{"service": "web"})
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate port")
+	}
+
+	expected := eventMap + " labeled_container tcp/127.0.0.1:" + port + "->" + ip + ":" + port + " http"
+	if last := events[len(events)-1]; last != expected {
+		t.Fatalf("Expected the event %s, got %s", expected, last)
+	}
+	state := d.allocationsState().Interfaces["labeled_container"]
+	if len(state.Mappings) != 1 || state.Mappings[0].Name != "http" || state.Mappings[0].Labels["service"] != "web" {
+		t.Fatalf("Expected the mapping to be listed with its name and labels, got %+v", state.Mappings)
+	}
+
+	job = eng.Job("allocate_port", "labeled_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", "81")
+	job.Setenv("Labels", "service=web")
+	if res := d.AllocatePort(job); res == engine.StatusOK {
+		t.Fatal("Expected invalid labels to be refused")
+	}
+}
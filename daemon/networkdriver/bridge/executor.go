@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+)
+
+// Executor runs the commands changing the host networking in place of the
+// daemon: ip, tc, iptables, ip6tables, iptables-save, modprobe, conntrack,
+// and "sysctl" given the path of a setting of /proc/sys/net or of a bridge
+// option followed by its value. The netlink changes come as the ip commands
+// making them. The privileged helper is one, and a RecordingExecutor lets
+// the driver be tested without privileges.
+type Executor interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+// setExecutor makes e run the commands of the driver changing the host
+// networking, the iptables rules of its port mappings included, or the
+// daemon itself if e is nil.
+func (d *Driver) setExecutor(e Executor) {
+	d.executor = e
+	if e == nil {
+		d.firewall.SetRunner(nil)
+		return
+	}
+	d.firewall.SetRunner(func(cmd string, args []string) ([]byte, error) {
+		return e.Run(cmd, args...)
+	})
+}
+
+// RecordingExecutor records the commands rather than running them, for the
+// tests to check the changes the driver would make to the host.
+type RecordingExecutor struct {
+	sync.Mutex
+	Commands [][]string // each command, its name followed by its arguments
+
+	// Respond, if set, gives the output and the error of each command,
+	// which otherwise succeeds without output
+	Respond func(name string, args []string) ([]byte, error)
+}
+
+func (e *RecordingExecutor) Run(name string, args ...string) ([]byte, error) {
+	e.Lock()
+	e.Commands = append(e.Commands, append([]string{name}, args...))
+	e.Unlock()
+	if e.Respond != nil {
+		return e.Respond(name, args)
+	}
+	return nil, nil
+}
+
+// Lines returns the commands recorded as command lines.
+func (e *RecordingExecutor) Lines() []string {
+	e.Lock()
+	defer e.Unlock()
+	lines := make([]string, len(e.Commands))
+	for i, cmd := range e.Commands {
+		lines[i] = strings.Join(cmd, " ")
+	}
+	return lines
+}
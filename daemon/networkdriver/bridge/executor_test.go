@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestRecordingExecutor(t *testing.T) {
+	e := &RecordingExecutor{}
+	eng := engine.New()
+	eng.Logging = false
+	d := newDriver(&Config{Executor: e})
+	d.currentInterfaces.Set("web", &networkInterface{IP: net.ParseIP("172.17.0.5"), HostIface: "dkr-web"})
+	d.currentInterfaces.Set("snort", &networkInterface{IP: net.ParseIP("172.17.0.6"), HostIface: "dkr-snort"})
+
+	job := eng.Job("set_mirror", "web")
+	job.Setenv("Target", "snort")
+	if res := d.SetMirror(job); res != engine.StatusOK {
+		t.Fatal("Failed to mirror the traffic")
+	}
+	if err := d.setSysctl("/proc/sys/net/ipv4/ip_forward", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.execRule(false, "-t", "nat", "-A", "POSTROUTING", "-s", "172.17.0.0/16", "-j", "MASQUERADE"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Join(e.Lines(), "\n")
+	for _, command := range []string{
+		"tc qdisc add dev dkr-web handle ffff: ingress",
+		"tc filter add dev dkr-web parent 1: protocol all prio 1 u32 match u32 0 0 action mirred egress mirror dev dkr-snort",
+		"sysctl /proc/sys/net/ipv4/ip_forward 1",
+		"-t nat -A POSTROUTING -s 172.17.0.0/16 -j MASQUERADE",
+	} {
+		if !strings.Contains(lines, command) {
+			t.Fatalf("Expected %q to be run, got:\n%s", command, lines)
+		}
+	}
+
+	// The failures of the commands come back to the driver
+	e.Respond = func(name string, args []string) ([]byte, error) {
+		return []byte("RTNETLINK answers: Operation not permitted"), errors.New("exit status 2")
+	}
+	if err := d.runCommand("ip", "link", "set", "dkr-web", "up"); err == nil || !strings.Contains(err.Error(), "Operation not permitted") {
+		t.Fatalf("Expected the command to fail, got %v", err)
+	}
+}
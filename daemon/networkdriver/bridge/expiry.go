@@ -0,0 +1,147 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// A port mapping can expire, for the temporary shares such as a development
+// container shown to someone for an hour: once past its Expires time, the
+// port is unmapped and released and a net:expire event is published. The
+// time being saved along with the mapping, a mapping restored after a
+// restart keeps its deadline, and one which expired meanwhile expires as
+// soon as it is restored.
+type portExpiries struct {
+	sync.Mutex
+	timers map[string]*time.Timer // by host address of the mappings
+}
+
+// expireAt unmaps host, a port mapping of the container id, at expires.
+func (d *Driver) expireAt(eng *engine.Engine, id string, host net.Addr, expires time.Time) {
+	d.expiries.Lock()
+	defer d.expiries.Unlock()
+	key := addrDetail(host)
+	if t := d.expiries.timers[key]; t != nil {
+		t.Stop()
+	}
+	d.expiries.timers[key] = time.AfterFunc(expires.Sub(time.Now()), func() {
+		d.expirePort(eng, id, host)
+	})
+}
+
+// cancelExpiry keeps host, unmapped, from expiring.
+func (d *Driver) cancelExpiry(host net.Addr) {
+	d.expiries.Lock()
+	defer d.expiries.Unlock()
+	key := addrDetail(host)
+	if t := d.expiries.timers[key]; t != nil {
+		t.Stop()
+		delete(d.expiries.timers, key)
+	}
+}
+
+func (d *Driver) expirePort(eng *engine.Engine, id string, host net.Addr) {
+	d.expiries.Lock()
+	delete(d.expiries.timers, addrDetail(host))
+	d.expiries.Unlock()
+
+	iface := d.currentInterfaces.Get(id)
+	if iface == nil || !d.hasMapping(iface, host) {
+		return
+	}
+	log.WithField("container", id).Infof("The port mapping %s expired", addrDetail(host))
+	d.logMappingEvent(eng, eventExpire, id, host, addrDetail(host))
+	d.unmapPort(iface, host)
+	d.forgetSavedPort(id, host)
+}
+
+// hostMappings returns the port mappings of iface, which change as the
+// ports are published, unpublished and expire.
+func (d *Driver) hostMappings(iface *networkInterface) []net.Addr {
+	d.portsLock.Lock()
+	defer d.portsLock.Unlock()
+	return append([]net.Addr(nil), iface.PortMappings...)
+}
+
+func (d *Driver) hasMapping(iface *networkInterface, host net.Addr) bool {
+	for _, nat := range d.hostMappings(iface) {
+		if addrDetail(nat) == addrDetail(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmapPort removes host from the port mappings of iface.
+func (d *Driver) unmapPort(iface *networkInterface, host net.Addr) {
+	var (
+		key      = addrDetail(host)
+		nat      net.Addr
+		upstream *upstreamForwarding
+	)
+	d.portsLock.Lock()
+	for i, m := range iface.PortMappings {
+		if addrDetail(m) == key {
+			nat = m
+			iface.PortMappings = append(iface.PortMappings[:i:i], iface.PortMappings[i+1:]...)
+			break
+		}
+	}
+	if nat != nil {
+		p := mappedHostPort(nat)
+		for j, f := range iface.Upstream {
+			if f.Proto == p.Proto && f.HostPort == p.Port {
+				upstream = f
+				iface.Upstream = append(iface.Upstream[:j:j], iface.Upstream[j+1:]...)
+				break
+			}
+		}
+	}
+	d.portsLock.Unlock()
+	if nat == nil {
+		return
+	}
+
+	if err := portmapper.Unmap(nat); err != nil {
+		log.Infof("Unable to unmap port %s: %s", nat, err)
+	}
+	if d.protectHost {
+		d.unshareHostPort(mappedHostPort(nat))
+	}
+	if upstream != nil {
+		d.upstream.remove(upstream)
+	}
+}
+
+// forgetSavedPort keeps host, unmapped, from being mapped again on restore.
+func (d *Driver) forgetSavedPort(id string, host net.Addr) {
+	p := mappedHostPort(host)
+	d.saved.Lock()
+	defer d.saved.Unlock()
+
+	jobs := d.saved.jobs[id]
+	for i, j := range jobs {
+		if j.Name == "allocate_port" && j.Env["Proto"] == p.Proto && j.Env["HostPort"] == strconv.Itoa(p.Port) && j.Env["HostIP"] == hostAddrIP(host).String() {
+			d.saved.jobs[id] = append(jobs[:i], jobs[i+1:]...)
+			break
+		}
+	}
+	d.writeSavedInterfaces()
+	d.writeMappingsFile()
+}
+
+// hostAddrIP returns the host ip of a port mapping.
+func hostAddrIP(host net.Addr) net.IP {
+	switch a := host.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestPortExpiry(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	eng.Register("log", func(job *engine.Job) engine.Status {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, strings.Join(job.Args, " "))
+		return engine.StatusOK
+	})
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	if res := d.Allocate(eng.Job("allocate_interface", "expiry_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+
+	expiring, kept := findFreePort(t), findFreePort(t)
+	job := newPortAllocationJob(eng, expiring)
+	job.Args[0] = "expiry_container"
+	job.Setenv("Expires", time.Now().Add(50*time.Millisecond).Format(time.RFC3339Nano))
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate the expiring port")
+	}
+	job = newPortAllocationJob(eng, kept)
+	job.Args[0] = "expiry_container"
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate the port")
+	}
+	iface := d.currentInterfaces.Get("expiry_container")
+	if n := len(d.hostMappings(iface)); n != 2 {
+		t.Fatalf("Expected 2 port mappings, got %d", n)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if mappings := d.hostMappings(iface); len(mappings) != 1 || mappedHostPort(mappings[0]).Port != kept {
+		t.Fatalf("Expected only the port %d to be left, got %v", kept, mappings)
+	}
+	d.saved.Lock()
+	jobs := d.saved.jobs["expiry_container"]
+	d.saved.Unlock()
+	if len(jobs) != 2 || jobs[1].Env["Expires"] != "" {
+		t.Fatalf("Expected the expired mapping to be forgotten, got %v", jobs)
+	}
+	mu.Lock()
+	last := events[len(events)-1]
+	mu.Unlock()
+	if expected := eventExpire + " expiry_container tcp/127.0.0.1:" + strconv.Itoa(expiring); last != expected {
+		t.Fatalf("Expected the event %s, got %s", expected, last)
+	}
+
+	// The expired port is free again
+	job = newPortAllocationJob(eng, expiring)
+	job.Args[0] = "expiry_container"
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Expected the expired port to be released")
+	}
+
+	job = newPortAllocationJob(eng, findFreePort(t))
+	job.Args[0] = "expiry_container"
+	job.Setenv("Expires", "in an hour")
+	if res := d.AllocatePort(job); res == engine.StatusOK {
+		t.Fatal("Expected an invalid expiry to be refused")
+	}
+}
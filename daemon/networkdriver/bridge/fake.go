@@ -0,0 +1,380 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/docker/docker/daemon/networkdriver"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+// FakeNetworkManager is a NetworkManager kept in memory, leaving the host
+// alone, for the tools embedding the network and the API handlers to be
+// tested without privileges. The containers are given the addresses of its
+// network in order, from the second after the gateway, and the ports are
+// published from portallocator.BeginPortRange in order, the lowest free
+// first, so that the same allocations give the same settings every time.
+type FakeNetworkManager struct {
+	sync.Mutex
+	network    *net.IPNet
+	bridge     string
+	interfaces map[string]*fakeInterface
+	ips        map[string]string // address to container
+	ports      map[string]string // proto/port to container
+	closed     bool
+}
+
+type fakeInterface struct {
+	ip   net.IP
+	nats []Nat
+}
+
+// NewFakeNetworkManager returns a FakeNetworkManager giving the addresses of
+// network, the address of the gateway, on a bridge named bridge. A nil
+// network is 172.17.42.1/16 and an empty bridge DefaultNetworkBridge, as
+// the driver would set up.
+func NewFakeNetworkManager(network *net.IPNet, bridge string) *FakeNetworkManager {
+	if network == nil {
+		network = &net.IPNet{IP: net.ParseIP("172.17.42.1").To4(), Mask: net.CIDRMask(16, 32)}
+	}
+	if bridge == "" {
+		bridge = DefaultNetworkBridge
+	}
+	return &FakeNetworkManager{
+		network:    network,
+		bridge:     bridge,
+		interfaces: make(map[string]*fakeInterface),
+		ips:        make(map[string]string),
+		ports:      make(map[string]string),
+	}
+}
+
+// Install registers the jobs of the driver the fake plays, such as for the
+// API handlers running them: allocate_interface, release_interface,
+// allocate_port, release_port, port_mappings and network_stats, and the
+// jobs the daemon runs for every container and at shutdown:
+// attach_interface, restore_interface, prune_interfaces,
+// release_reservation and shutdown_networkdriver.
+func (m *FakeNetworkManager) Install(eng *engine.Engine) error {
+	for name, f := range map[string]engine.Handler{
+		"allocate_interface":     m.allocateJob,
+		"release_interface":      m.releaseJob,
+		"allocate_port":          m.allocatePortJob,
+		"release_port":           m.releasePortJob,
+		"port_mappings":          m.portMappingsJob,
+		"network_stats":          m.statsJob,
+		"attach_interface":       m.attachJob,
+		"restore_interface":      m.restoreJob,
+		"prune_interfaces":       m.noopJob,
+		"release_reservation":    m.noopJob,
+		"shutdown_networkdriver": m.shutdownJob,
+	} {
+		if err := eng.Register(name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *FakeNetworkManager) Allocate(id string, options *engine.Env) (*engine.Env, error) {
+	if options == nil {
+		options = &engine.Env{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return nil, fmt.Errorf("The network is closed")
+	}
+	if _, exists := m.interfaces[id]; exists {
+		return nil, fmt.Errorf("%s already has an interface", id)
+	}
+	ip, err := m.allocateIP(net.ParseIP(options.Get("RequestedIP")))
+	if err != nil {
+		return nil, err
+	}
+	mac, err := net.ParseMAC(options.Get("RequestedMac"))
+	if err != nil {
+		mac = networkdriver.GenerateMacAddr(ip)
+	}
+	m.ips[ip.String()] = id
+	m.interfaces[id] = &fakeInterface{ip: ip}
+
+	out := &engine.Env{}
+	out.Set("IP", ip.String())
+	out.Set("Mask", m.network.Mask.String())
+	out.Set("Gateway", m.network.IP.String())
+	out.Set("MacAddress", mac.String())
+	out.Set("Bridge", m.bridge)
+	out.Set("HostInterfaceName", vethName(id))
+	size, _ := m.network.Mask.Size()
+	out.SetInt("IPPrefixLen", size)
+	return out, nil
+}
+
+// allocateIP returns requested if it is free, else the first free address
+// of the network.
+func (m *FakeNetworkManager) allocateIP(requested net.IP) (net.IP, error) {
+	if requested != nil {
+		if !m.network.Contains(requested) || requested.Equal(m.network.IP) {
+			return nil, fmt.Errorf("The requested address %s isn't available on %s", requested, m.network)
+		}
+		if _, used := m.ips[requested.String()]; used {
+			return nil, fmt.Errorf("The requested address %s is already allocated", requested)
+		}
+		return requested, nil
+	}
+	base := m.network.IP.Mask(m.network.Mask).To4()
+	if base == nil {
+		return nil, fmt.Errorf("The fake network only gives IPv4 addresses")
+	}
+	ones, bits := m.network.Mask.Size()
+	first := binary.BigEndian.Uint32(base)
+	// The network and the broadcast addresses aren't given
+	for i := uint32(2); i < uint32(1)<<uint(bits-ones)-1; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, first+i)
+		if _, used := m.ips[ip.String()]; !used && !ip.Equal(m.network.IP) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("No address left on %s", m.network)
+}
+
+func (m *FakeNetworkManager) Release(id string) error {
+	m.Lock()
+	defer m.Unlock()
+	iface, exists := m.interfaces[id]
+	if !exists {
+		return fmt.Errorf("No network information to release for %s", id)
+	}
+	for _, nat := range iface.nats {
+		delete(m.ports, fakePortKey(nat.Proto, nat.HostPort))
+	}
+	delete(m.ips, iface.ip.String())
+	delete(m.interfaces, id)
+	return nil
+}
+
+func (m *FakeNetworkManager) MapPort(id string, options *engine.Env) (net.Addr, error) {
+	var (
+		hostIP        = net.ParseIP(options.Get("HostIP"))
+		hostPort      = options.GetInt("HostPort")
+		containerPort = options.GetInt("ContainerPort")
+		proto         = options.Get("Proto")
+	)
+	if hostIP == nil {
+		if options.Get("HostIP") != "" {
+			return nil, fmt.Errorf("Bad parameter: invalid host ip %s", options.Get("HostIP"))
+		}
+		hostIP = net.IPv4zero
+	}
+	if proto == "" {
+		proto = "tcp"
+	}
+	if proto != "tcp" && proto != "udp" {
+		return nil, fmt.Errorf("unsupported address type %s", proto)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	iface, exists := m.interfaces[id]
+	if !exists {
+		return nil, fmt.Errorf("No network information for %s", id)
+	}
+	if hostPort == 0 {
+		for port := portallocator.BeginPortRange; port <= portallocator.EndPortRange; port++ {
+			if _, used := m.ports[fakePortKey(proto, port)]; !used {
+				hostPort = port
+				break
+			}
+		}
+		if hostPort == 0 {
+			return nil, portallocator.ErrAllPortsAllocated
+		}
+	} else if _, used := m.ports[fakePortKey(proto, hostPort)]; used {
+		return nil, fmt.Errorf("Bind for %s:%d failed: port is already allocated", hostIP, hostPort)
+	}
+	m.ports[fakePortKey(proto, hostPort)] = id
+	iface.nats = append(iface.nats, Nat{
+		Proto:         proto,
+		HostIP:        hostIP,
+		HostPort:      hostPort,
+		ContainerIP:   iface.ip,
+		ContainerPort: containerPort,
+		Name:          options.Get("Name"),
+	})
+	if proto == "udp" {
+		return &net.UDPAddr{IP: hostIP, Port: hostPort}, nil
+	}
+	return &net.TCPAddr{IP: hostIP, Port: hostPort}, nil
+}
+
+// UnmapPort unpublishes the port proto/hostPort of the container.
+func (m *FakeNetworkManager) UnmapPort(id, proto string, hostPort int) error {
+	m.Lock()
+	defer m.Unlock()
+	iface, exists := m.interfaces[id]
+	if !exists {
+		return fmt.Errorf("No network information for %s", id)
+	}
+	for i, nat := range iface.nats {
+		if nat.Proto == proto && nat.HostPort == hostPort {
+			iface.nats = append(iface.nats[:i], iface.nats[i+1:]...)
+			delete(m.ports, fakePortKey(proto, hostPort))
+			return nil
+		}
+	}
+	return fmt.Errorf("No port %s/%d published for %s", proto, hostPort, id)
+}
+
+func (m *FakeNetworkManager) PortMappings(id string) ([]Nat, error) {
+	m.Lock()
+	defer m.Unlock()
+	iface, exists := m.interfaces[id]
+	if !exists {
+		return nil, fmt.Errorf("No network information for %s", id)
+	}
+	nats := make([]Nat, len(iface.nats))
+	copy(nats, iface.nats)
+	return nats, nil
+}
+
+// Stats returns null counters, no traffic going through the fake.
+func (m *FakeNetworkManager) Stats(id string) (*engine.Env, error) {
+	m.Lock()
+	defer m.Unlock()
+	if _, exists := m.interfaces[id]; !exists {
+		return nil, fmt.Errorf("No network information for %s", id)
+	}
+	out := &engine.Env{}
+	out.SetInt64("RxBytes", 0)
+	out.SetInt64("RxPackets", 0)
+	out.SetInt64("TxBytes", 0)
+	out.SetInt64("TxPackets", 0)
+	if err := out.SetJson("Latency", []mappingLatency{}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Close forgets the interfaces and the port mappings, there being nothing
+// to clean up.
+func (m *FakeNetworkManager) Close(cleanup bool) error {
+	m.Lock()
+	defer m.Unlock()
+	m.interfaces = make(map[string]*fakeInterface)
+	m.ips = make(map[string]string)
+	m.ports = make(map[string]string)
+	m.closed = true
+	return nil
+}
+
+func fakePortKey(proto string, port int) string {
+	return fmt.Sprintf("%s/%d", proto, port)
+}
+
+func (m *FakeNetworkManager) allocateJob(job *engine.Job) engine.Status {
+	out, err := m.Allocate(job.Args[0], job.Env())
+	if err != nil {
+		return job.Error(err)
+	}
+	out.WriteTo(job.Stdout)
+	return engine.StatusOK
+}
+
+func (m *FakeNetworkManager) releaseJob(job *engine.Job) engine.Status {
+	if err := m.Release(job.Args[0]); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func (m *FakeNetworkManager) allocatePortJob(job *engine.Job) engine.Status {
+	host, err := m.MapPort(job.Args[0], job.Env())
+	if err != nil {
+		return job.Error(err)
+	}
+	out := engine.Env{}
+	switch addr := host.(type) {
+	case *net.TCPAddr:
+		out.Set("HostIP", addr.IP.String())
+		out.SetInt("HostPort", addr.Port)
+	case *net.UDPAddr:
+		out.Set("HostIP", addr.IP.String())
+		out.SetInt("HostPort", addr.Port)
+	}
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func (m *FakeNetworkManager) releasePortJob(job *engine.Job) engine.Status {
+	var (
+		proto    = job.Getenv("Proto")
+		hostPort = job.GetenvInt("HostPort")
+	)
+	if err := m.UnmapPort(job.Args[0], proto, hostPort); err != nil {
+		return job.Error(err)
+	}
+	out := engine.Env{}
+	out.SetInt("HostPort", hostPort)
+	out.WriteTo(job.Stdout)
+	return engine.StatusOK
+}
+
+func (m *FakeNetworkManager) portMappingsJob(job *engine.Job) engine.Status {
+	nats, err := m.PortMappings(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := json.NewEncoder(job.Stdout).Encode(nats); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func (m *FakeNetworkManager) statsJob(job *engine.Job) engine.Status {
+	out, err := m.Stats(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// attachJob has nothing to set up in the namespace of the container, and
+// no namespace to name.
+func (m *FakeNetworkManager) attachJob(job *engine.Job) engine.Status {
+	return engine.StatusOK
+}
+
+// restoreJob keeps the interface the fake still holds, the fake saving
+// nothing across the restarts of the process.
+func (m *FakeNetworkManager) restoreJob(job *engine.Job) engine.Status {
+	m.Lock()
+	defer m.Unlock()
+	if _, exists := m.interfaces[job.Args[0]]; !exists {
+		return job.Errorf("No saved network information for %s", job.Args[0])
+	}
+	return engine.StatusOK
+}
+
+// noopJob stands for the jobs about the saved interfaces and the
+// reservations, which the fake has none of.
+func (m *FakeNetworkManager) noopJob(job *engine.Job) engine.Status {
+	return engine.StatusOK
+}
+
+func (m *FakeNetworkManager) shutdownJob(job *engine.Job) engine.Status {
+	if err := m.Close(job.GetenvBool("Cleanup")); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
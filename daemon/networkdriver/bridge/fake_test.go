@@ -0,0 +1,163 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+func TestFakeNetworkManager(t *testing.T) {
+	var m NetworkManager = NewFakeNetworkManager(nil, "")
+
+	first, err := m.Allocate("first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Get("IP") != "172.17.0.2" || first.Get("Gateway") != "172.17.42.1" || first.GetInt("IPPrefixLen") != 16 || first.Get("Bridge") != DefaultNetworkBridge {
+		t.Fatalf("Unexpected interface settings %v", first)
+	}
+	options := &engine.Env{}
+	options.Set("RequestedIP", "172.17.0.3")
+	if _, err := m.Allocate("second", options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Allocate("third", options); err == nil {
+		t.Fatal("Expected the address of another container to be refused")
+	}
+	third, err := m.Allocate("third", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Get("IP") != "172.17.0.4" {
+		t.Fatalf("Expected the next free address, got %s", third.Get("IP"))
+	}
+
+	options = &engine.Env{}
+	options.SetInt("ContainerPort", 80)
+	host, err := m.MapPort("first", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := host.(*net.TCPAddr); !ok || addr.Port != portallocator.BeginPortRange || !addr.IP.Equal(net.IPv4zero) {
+		t.Fatalf("Unexpected host address %v", host)
+	}
+	options.SetInt("HostPort", portallocator.BeginPortRange)
+	if _, err := m.MapPort("second", options); err == nil {
+		t.Fatal("Expected the port published already to be refused")
+	}
+	nats, err := m.PortMappings("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nats) != 1 || nats[0].ContainerPort != 80 || !nats[0].ContainerIP.Equal(net.ParseIP("172.17.0.2")) {
+		t.Fatalf("Unexpected port mappings %+v", nats)
+	}
+
+	// The address and the ports of a container released are given again
+	if err := m.Release("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Release("first"); err == nil {
+		t.Fatal("Expected the interface to be released already")
+	}
+	again, err := m.Allocate("again", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Get("IP") != "172.17.0.2" {
+		t.Fatalf("Expected the address released to be given again, got %s", again.Get("IP"))
+	}
+	if host, err := m.MapPort("again", options); err != nil || host.(*net.TCPAddr).Port != portallocator.BeginPortRange {
+		t.Fatalf("Expected the port released to be published again, got %v (%v)", host, err)
+	}
+	if err := m.Close(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Allocate("closed", nil); err == nil {
+		t.Fatal("Expected the closed network to refuse the interfaces")
+	}
+}
+
+func TestFakeNetworkManagerJobs(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	_, network, _ := net.ParseCIDR("10.1.0.0/24")
+	network.IP = net.ParseIP("10.1.0.1").To4()
+	if err := NewFakeNetworkManager(network, "br-test").Install(eng); err != nil {
+		t.Fatal(err)
+	}
+
+	job := eng.Job("allocate_interface", "web")
+	settings, _ := job.Stdout.AddEnv()
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Get("IP") != "10.1.0.2" || settings.Get("Bridge") != "br-test" {
+		t.Fatalf("Unexpected interface settings %v", settings)
+	}
+
+	// The jobs the daemon runs for every container are all there
+	job = eng.Job("attach_interface", "web")
+	job.SetenvInt("Pid", 1)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("restore_interface", "web").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("restore_interface", "unknown").Run(); err == nil {
+		t.Fatal("Expected the interface never allocated not to be restored")
+	}
+	job = eng.Job("prune_interfaces")
+	job.SetenvList("Keep", []string{"web"})
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	job = eng.Job("allocate_port", "web")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("Proto", "udp")
+	job.SetenvInt("ContainerPort", 53)
+	job.SetenvInt("HostPort", 5353)
+	published, _ := job.Stdout.AddEnv()
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if published.Get("HostIP") != "127.0.0.1" || published.GetInt("HostPort") != 5353 {
+		t.Fatalf("Unexpected host address %v", published)
+	}
+
+	var out bytes.Buffer
+	job = eng.Job("port_mappings", "web")
+	job.Stdout.Add(&out)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var nats []Nat
+	if err := json.Unmarshal(out.Bytes(), &nats); err != nil {
+		t.Fatal(err)
+	}
+	if len(nats) != 1 || nats[0].Proto != "udp" || nats[0].HostPort != 5353 {
+		t.Fatalf("Unexpected port mappings %+v", nats)
+	}
+
+	job = eng.Job("release_port", "web")
+	job.Setenv("Proto", "udp")
+	job.SetenvInt("HostPort", 5353)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("release_interface", "web").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("network_stats", "web").Run(); err == nil {
+		t.Fatal("Expected the stats of a released container to fail")
+	}
+	if err := eng.Job("shutdown_networkdriver").Run(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,212 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"github.com/docker/libcontainer/system"
+)
+
+// An address released by a container is soon given to another one, and the
+// neighbors on the bridge, the host among them, may still hold the MAC
+// address of the container which had it before: they send it the traffic
+// of the new one until their entry expires. Once the container runs, the
+// driver announces its addresses from its namespace, as a host brought up
+// on a network does: a gratuitous ARP for an IPv4 address, an unsolicited
+// neighbor advertisement for an IPv6 one. The announcements being sent from
+// the interface of the container, its own kernel doesn't receive them.
+
+var (
+	broadcastMac = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	allNodesMac  = net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}
+	allNodesIP   = net.ParseIP("ff02::1")
+)
+
+const (
+	ethPArp  = 0x0806
+	ethPIPv6 = 0x86dd
+)
+
+// announceAddresses announces the addresses of iface in the namespace of
+// the running container, of process pid. It is best effort, the neighbors
+// learning the address when their entry expires otherwise.
+func (d *Driver) announceAddresses(iface *networkInterface, pid int) {
+	ips := append([]net.IP{iface.IP}, iface.SecondaryIPs...)
+	if d.dryRun {
+		for _, ip := range ips {
+			d.changes.record("arp", []string{"announce", ip.String(), "netns", strconv.Itoa(pid)}, nil)
+		}
+		return
+	}
+	err := inNetns(pid, func() error {
+		for _, ip := range ips {
+			if err := announceAddress(ip); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Infof("Unable to announce the addresses of the container: %s", err)
+	}
+}
+
+// announceAddress sends the announcement of ip on the interface having it,
+// in the current network namespace.
+func announceAddress(ip net.IP) error {
+	iface, err := interfaceOf(ip)
+	if err != nil {
+		return err
+	}
+	var (
+		frame []byte
+		dst   net.HardwareAddr
+		proto uint16
+	)
+	if ip.To4() != nil {
+		frame, dst, proto = gratuitousArp(iface.HardwareAddr, ip), broadcastMac, ethPArp
+	} else {
+		frame, dst, proto = unsolicitedAdvert(iface.HardwareAddr, ip), allNodesMac, ethPIPv6
+	}
+
+	s, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(s)
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(proto),
+		Ifindex:  iface.Index,
+		Halen:    uint8(len(dst)),
+	}
+	copy(addr.Addr[:], dst)
+	if err := syscall.Sendto(s, frame, 0, addr); err != nil {
+		return fmt.Errorf("Unable to announce %s on %s: %s", ip, iface.Name, err)
+	}
+	return nil
+}
+
+// interfaceOf returns the interface having the address ip.
+func interfaceOf(ip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No interface has the address %s", ip)
+}
+
+// inNetns runs f in the network namespace of the process pid.
+func inNetns(pid int, f func() error) error {
+	runtime.LockOSThread()
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+	target, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer target.Close()
+	if err := system.Setns(target.Fd(), syscall.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	err = f()
+	// The thread stays locked, and goes away with the goroutine, if it
+	// can't be put back in the namespace of the daemon
+	if restoreErr := system.Setns(origin.Fd(), syscall.CLONE_NEWNET); restoreErr != nil {
+		log.Errorf("Unable to go back to the network namespace of the daemon: %s", restoreErr)
+		return err
+	}
+	runtime.UnlockOSThread()
+	return err
+}
+
+// gratuitousArp returns the ethernet frame of the gratuitous ARP of ip, at
+// the MAC address mac: a broadcast request for ip by ip.
+func gratuitousArp(mac net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 0, 60)
+	b = append(b, broadcastMac...)
+	b = append(b, mac...)
+	b = append(b, 0x08, 0x06)                         // ARP
+	b = append(b, 0x00, 0x01, 0x08, 0x00, 0x06, 0x04) // ethernet, IPv4
+	b = append(b, 0x00, 0x01)                         // request
+	b = append(b, mac...)
+	b = append(b, ip.To4()...)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	b = append(b, ip.To4()...)
+	// Padded to the minimum size of a frame
+	return b[:cap(b)]
+}
+
+// unsolicitedAdvert returns the ethernet frame of the unsolicited neighbor
+// advertisement of ip, at the MAC address mac, to all the nodes.
+func unsolicitedAdvert(mac net.HardwareAddr, ip net.IP) []byte {
+	icmp := make([]byte, 32)
+	icmp[0] = 136  // neighbor advertisement
+	icmp[4] = 0x20 // override
+	copy(icmp[8:24], ip.To16())
+	icmp[24] = 2 // target link-layer address option
+	icmp[25] = 1 // of 8 bytes
+	copy(icmp[26:32], mac)
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(ip.To16(), allNodesIP, icmp))
+
+	b := make([]byte, 0, 14+40+len(icmp))
+	b = append(b, allNodesMac...)
+	b = append(b, mac...)
+	b = append(b, 0x86, 0xdd)
+	b = append(b, 0x60, 0, 0, 0) // version 6
+	b = append(b, byte(len(icmp)>>8), byte(len(icmp)))
+	b = append(b, syscall.IPPROTO_ICMPV6, 255) // hop limit required by neighbor discovery
+	b = append(b, ip.To16()...)
+	b = append(b, allNodesIP...)
+	return append(b, icmp...)
+}
+
+// icmpv6Checksum returns the checksum of an ICMPv6 message from src to
+// dst, whose checksum field is zero.
+func icmpv6Checksum(src, dst net.IP, msg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	add([]byte{0, 0, byte(len(msg) >> 8), byte(len(msg)), 0, 0, 0, syscall.IPPROTO_ICMPV6})
+	add(msg)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// htons returns v in the network byte order, as the sockets take it.
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return nativeEndian.Uint16(b)
+}
@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAnnouncementFrames(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:05")
+
+	arp := gratuitousArp(mac, net.ParseIP("172.17.0.5"))
+	if len(arp) != 60 || !bytes.Equal(arp[0:6], broadcastMac) || !bytes.Equal(arp[6:12], mac) {
+		t.Fatalf("Expected a broadcast frame from %s, got %x", mac, arp)
+	}
+	if !bytes.Equal(arp[22:28], mac) || !bytes.Equal(arp[28:32], []byte{172, 17, 0, 5}) || !bytes.Equal(arp[38:42], []byte{172, 17, 0, 5}) {
+		t.Fatalf("Expected the ARP request of 172.17.0.5 by itself, got %x", arp)
+	}
+
+	ip := net.ParseIP("fd00::5")
+	na := unsolicitedAdvert(mac, ip)
+	if len(na) != 14+40+32 || !bytes.Equal(na[0:6], allNodesMac) || na[20] != syscall.IPPROTO_ICMPV6 || na[21] != 255 {
+		t.Fatalf("Expected an advertisement to all the nodes, got %x", na)
+	}
+	icmp := na[54:]
+	if icmp[0] != 136 || !bytes.Equal(icmp[8:24], ip) || !bytes.Equal(icmp[26:32], mac) {
+		t.Fatalf("Expected the advertisement of %s at %s, got %x", ip, mac, icmp)
+	}
+	// The checksum of a message holding its checksum is zero
+	if sum := icmpv6Checksum(ip, allNodesIP, icmp); sum != 0 {
+		t.Fatalf("Expected a valid checksum, got %x", sum)
+	}
+}
+
+func TestAnnounceAddresses(t *testing.T) {
+	// A process in a namespace of its own stands for the container, its
+	// interface being on a veth pair whose host end is listened on
+	ns := exec.Command("unshare", "-n", "sleep", "30")
+	if err := ns.Start(); err != nil {
+		t.Skipf("Unable to create a network namespace: %s", err)
+	}
+	defer ns.Wait()
+	defer ns.Process.Kill()
+	time.Sleep(100 * time.Millisecond)
+	pid := strconv.Itoa(ns.Process.Pid)
+
+	if output, err := exec.Command("ip", "link", "add", "dkrga0", "type", "veth", "peer", "name", "dkrga1").CombinedOutput(); err != nil {
+		t.Skipf("Unable to create an interface: %s (%s)", err, output)
+	}
+	defer exec.Command("ip", "link", "del", "dkrga0").Run()
+	for _, args := range [][]string{
+		{"ip", "link", "set", "dkrga0", "up"},
+		{"ip", "link", "set", "dkrga1", "netns", pid},
+		{"nsenter", "--net=/proc/" + pid + "/ns/net", "ip", "addr", "add", "10.99.0.2/24", "dev", "dkrga1"},
+		{"nsenter", "--net=/proc/" + pid + "/ns/net", "ip", "link", "set", "dkrga1", "up"},
+	} {
+		if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			t.Fatalf("%s failed: %s (%s)", strings.Join(args, " "), err, output)
+		}
+	}
+	host, err := net.InterfaceByName("dkrga0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPArp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(s)
+	if err := syscall.Bind(s, &syscall.SockaddrLinklayer{Protocol: htons(ethPArp), Ifindex: host.Index}); err != nil {
+		t.Fatal(err)
+	}
+	tv := syscall.NsecToTimeval(int64(5 * time.Second))
+	syscall.SetsockoptTimeval(s, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+
+	d := &Driver{}
+	d.announceAddresses(&networkInterface{IP: net.ParseIP("10.99.0.2")}, ns.Process.Pid)
+
+	buf := make([]byte, 128)
+	for {
+		n, _, err := syscall.Recvfrom(s, buf, 0)
+		if err != nil {
+			t.Fatalf("Expected the address to be announced: %s", err)
+		}
+		// The kernel of the namespace may send ARP requests of its own
+		if n >= 42 && bytes.Equal(buf[28:32], []byte{10, 99, 0, 2}) && bytes.Equal(buf[38:42], []byte{10, 99, 0, 2}) {
+			break
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/docker/pkg/cloudroute"
+)
+
+// On GCE, the VPC routes the alias IP ranges of an instance to it natively.
+// Given one to the bridge, the containers are reached by their own
+// addresses from the whole VPC, without routes, NAT nor overlay.
+
+// gceAliasRanges reads the alias IP ranges of the instance.
+var gceAliasRanges = cloudroute.GCEAliasRanges
+
+// useGCEAliasRange sets the bridge network to the first IPv4 alias IP range
+// of the instance large enough for the bridge and a container, the bridge
+// taking its first address.
+func useGCEAliasRange(config *Config) error {
+	ranges, err := gceAliasRanges()
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		ip := r.IP.To4()
+		if ones, _ := r.Mask.Size(); ip == nil || ones > 30 {
+			continue
+		}
+		bridgeIP := append(net.IP{}, ip...)
+		bridgeIP[3]++
+		config.BridgeIP = (&net.IPNet{IP: bridgeIP, Mask: r.Mask}).String()
+		log.Infof("Allocating the container ips from the alias IP range %s of the instance", r)
+		return nil
+	}
+	return fmt.Errorf("The instance has no IPv4 alias IP range of 4 addresses or more, got %v", ranges)
+}
@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUseGCEAliasRange(t *testing.T) {
+	defer func(aliasRanges func() ([]*net.IPNet, error)) { gceAliasRanges = aliasRanges }(gceAliasRanges)
+	var ranges []*net.IPNet
+	gceAliasRanges = func() ([]*net.IPNet, error) {
+		return ranges, nil
+	}
+	for _, r := range []string{"10.8.9.4/32", "10.8.1.0/24"} {
+		_, network, _ := net.ParseCIDR(r)
+		ranges = append(ranges, network)
+	}
+
+	// The single address is too small for the bridge network
+	config := &Config{GCEAliasIP: true}
+	if err := useGCEAliasRange(config); err != nil {
+		t.Fatal(err)
+	}
+	if config.BridgeIP != "10.8.1.1/24" {
+		t.Fatalf("Expected the bridge ip 10.8.1.1/24, got %s", config.BridgeIP)
+	}
+
+	ranges = ranges[:1]
+	if err := useGCEAliasRange(&Config{GCEAliasIP: true}); err == nil {
+		t.Fatal("Expected the instance without a large enough alias IP range to be refused")
+	}
+}
@@ -0,0 +1,315 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/reexec"
+)
+
+// The harness runs a test in a throwaway network namespace, the test binary
+// being run again there, for the driver to set up the bridge, the rules and
+// the proxies as on a host of its own. The containers are processes in
+// network namespaces of their own, wired to the bridge with a veth pair as
+// the execution driver would.
+
+// netnsHarnessEnv is set in the environment of the test binary run again in
+// the throwaway network namespace.
+const netnsHarnessEnv = "DOCKER_NETNS_HARNESS"
+
+func init() {
+	// The userland proxies of the harness run the test binary again
+	if reexec.Init() {
+		os.Exit(0)
+	}
+}
+
+// inThrowawayNetns returns true when the test runs in the throwaway network
+// namespace. Otherwise it runs the test there, failing if it fails there,
+// and returns false.
+func inThrowawayNetns(t *testing.T) bool {
+	if os.Getenv(netnsHarnessEnv) != "" {
+		if output, err := exec.Command("ip", "link", "set", "lo", "up").CombinedOutput(); err != nil {
+			t.Fatalf("Unable to set up the loopback interface: %s (%s)", err, output)
+		}
+		return true
+	}
+	if testing.Short() {
+		t.Skip("The network namespace harness is skipped in short mode")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), netnsHarnessEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, exited := err.(*exec.ExitError); !exited {
+			t.Skipf("Unable to create a network namespace: %s", err)
+		}
+		t.Fatalf("Failed in the throwaway network namespace: %s\n%s", err, output)
+	}
+	if strings.Contains(string(output), "--- SKIP") {
+		t.Skipf("Skipped in the throwaway network namespace:\n%s", output)
+	}
+	return false
+}
+
+// testNetns is a network namespace of its own, held by a process.
+type testNetns struct {
+	cmd *exec.Cmd
+	Pid int
+}
+
+func newTestNetns(t *testing.T) *testNetns {
+	cmd := exec.Command("sleep", "600")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET, Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unable to create a network namespace: %s", err)
+	}
+	n := &testNetns{cmd: cmd, Pid: cmd.Process.Pid}
+	n.run(t, "ip", "link", "set", "lo", "up")
+	return n
+}
+
+// run runs a command in the namespace.
+func (n *testNetns) run(t *testing.T, args ...string) {
+	args = append([]string{"--net=/proc/" + strconv.Itoa(n.Pid) + "/ns/net"}, args...)
+	if output, err := exec.Command("nsenter", args...).CombinedOutput(); err != nil {
+		t.Fatalf("nsenter %s failed: %s (%s)", strings.Join(args, " "), err, output)
+	}
+}
+
+// link wires n to the harness namespace with a veth pair, host being its
+// end in the harness namespace and eth0 its end in n, with the address
+// addr, routing the traffic through gateway if not empty.
+func (n *testNetns) link(t *testing.T, host, addr, gateway string) {
+	harnessRun(t, "ip", "link", "add", host, "type", "veth", "peer", "name", "eth0", "netns", strconv.Itoa(n.Pid))
+	n.run(t, "ip", "addr", "add", addr, "dev", "eth0")
+	n.run(t, "ip", "link", "set", "eth0", "up")
+	if gateway != "" {
+		n.run(t, "ip", "route", "add", "default", "via", gateway)
+	}
+}
+
+// listen serves a connection at a time on the address of the namespace,
+// writing the remote address of each.
+func (n *testNetns) listen(t *testing.T, addr string) net.Listener {
+	var l net.Listener
+	if err := inNetns(n.Pid, func() (err error) {
+		l, err = net.Listen("tcp", addr)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, conn.RemoteAddr())
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+// dial connects from the namespace, or from the harness namespace if n is
+// nil, to a listener of the harness at addr, and returns the address the
+// listener saw the connection from.
+func (n *testNetns) dial(t *testing.T, addr string) string {
+	var (
+		conn net.Conn
+		err  error
+	)
+	dial := func() error {
+		conn, err = net.DialTimeout("tcp", addr, 5*time.Second)
+		return err
+	}
+	if n == nil {
+		dial()
+	} else {
+		inNetns(n.Pid, dial)
+	}
+	if err != nil {
+		t.Fatalf("Unable to reach %s: %s", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	seen, err := ioutil.ReadAll(conn)
+	if err != nil || len(seen) == 0 {
+		t.Fatalf("Unable to reach %s through: %s (%v)", addr, seen, err)
+	}
+	host, _, _ := net.SplitHostPort(string(seen))
+	return host
+}
+
+func (n *testNetns) close() {
+	n.cmd.Process.Kill()
+	n.cmd.Wait()
+}
+
+// harnessRun runs a command in the harness namespace.
+func harnessRun(t *testing.T, args ...string) {
+	if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		t.Fatalf("%s failed: %s (%s)", strings.Join(args, " "), err, output)
+	}
+}
+
+// startHarnessContainer allocates the interface of a container, and wires
+// a namespace standing for the container to the bridge as the execution
+// driver would. It returns the namespace with the settings of the interface.
+func startHarnessContainer(t *testing.T, eng *engine.Engine, id string) (*testNetns, *engine.Env) {
+	job := eng.Job("allocate_interface", id)
+	settings, _ := job.Stdout.AddEnv()
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	n := newTestNetns(t)
+	host := settings.Get("HostInterfaceName")
+	n.link(t, host, settings.Get("IP")+"/"+settings.Get("IPPrefixLen"), settings.Get("Gateway"))
+	harnessRun(t, "ip", "link", "set", host, "master", settings.Get("Bridge"), "up")
+
+	job = eng.Job("attach_interface", id)
+	job.SetenvInt("Pid", n.Pid)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return n, settings
+}
+
+// publishHarnessPort publishes the port 80 of the container on the host,
+// on hostIP if not empty, and returns the host port.
+func publishHarnessPort(t *testing.T, eng *engine.Engine, id, hostIP string) int {
+	job := eng.Job("allocate_port", id)
+	job.Setenv("HostIP", hostIP)
+	job.Setenv("Proto", "tcp")
+	job.SetenvInt("ContainerPort", 80)
+	out, _ := job.Stdout.AddEnv()
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.GetInt("HostPort")
+}
+
+// withUserlandProxy runs the actual userland proxies rather than the mock
+// of the other tests.
+func withUserlandProxy() func() {
+	mock := portmapper.NewProxy
+	portmapper.NewProxy = portmapper.NewProxyCommand
+	return func() { portmapper.NewProxy = mock }
+}
+
+func TestHarnessBridge(t *testing.T) {
+	if !inThrowawayNetns(t) {
+		return
+	}
+	defer withUserlandProxy()()
+	eng := engine.New()
+	eng.Logging = false
+	d, err := NewDriver(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close(true)
+	if err := d.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+
+	web, settings := startHarnessContainer(t, eng, "harness_web")
+	defer web.close()
+	client, clientSettings := startHarnessContainer(t, eng, "harness_client")
+	defer client.close()
+	if gateway := d.bridgeNetwork.IP.String(); settings.Get("Gateway") != gateway {
+		t.Fatalf("Expected the containers to go through the bridge %s, got %s", gateway, settings.Get("Gateway"))
+	}
+	l := web.listen(t, ":80")
+	defer l.Close()
+
+	// The containers reach each other on the bridge
+	if seen := client.dial(t, settings.Get("IP")+":80"); seen != clientSettings.Get("IP") {
+		t.Fatalf("Expected the container to be reached from the other, seen from %s", seen)
+	}
+
+	// The port published on the loopback address goes through the
+	// userland proxy, the container seeing the connections from the bridge
+	port := publishHarnessPort(t, eng, "harness_web", "127.0.0.1")
+	if seen := (*testNetns)(nil).dial(t, "127.0.0.1:"+strconv.Itoa(port)); seen != settings.Get("Gateway") {
+		t.Fatalf("Expected the connection to come from the bridge, seen from %s", seen)
+	}
+
+	// The port is unpublished with the container
+	if err := eng.Job("release_interface", "harness_web").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+		t.Fatal("Expected the port to be unpublished with the container")
+	}
+}
+
+func TestHarnessNat(t *testing.T) {
+	if !inThrowawayNetns(t) {
+		return
+	}
+	if _, err := exec.LookPath("iptables"); err != nil {
+		t.Skip("iptables is not installed")
+	}
+	defer withUserlandProxy()()
+	eng := engine.New()
+	eng.Logging = false
+	d, err := NewDriver(&Config{
+		EnableIptables:              true,
+		EnableIpMasq:                true,
+		EnableIpForward:             true,
+		InterContainerCommunication: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close(true)
+	if err := d.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+
+	// The outside world, on the uplink of the host
+	outside := newTestNetns(t)
+	defer outside.close()
+	outside.link(t, "dkrhup", "198.51.100.1/24", "")
+	harnessRun(t, "ip", "addr", "add", "198.51.100.2/24", "dev", "dkrhup")
+	harnessRun(t, "ip", "link", "set", "dkrhup", "up")
+	remote := outside.listen(t, "198.51.100.1:8000")
+	defer remote.Close()
+
+	web, settings := startHarnessContainer(t, eng, "harness_web")
+	defer web.close()
+	l := web.listen(t, ":80")
+	defer l.Close()
+
+	// The traffic of the containers to the outside world is masqueraded
+	if seen := web.dial(t, "198.51.100.1:8000"); seen != "198.51.100.2" {
+		t.Fatalf("Expected the connection to be masqueraded as the host, seen from %s", seen)
+	}
+
+	// The outside world reaches the port published through the DNAT rules,
+	// the container seeing the actual source
+	port := publishHarnessPort(t, eng, "harness_web", "")
+	if seen := outside.dial(t, "198.51.100.2:"+strconv.Itoa(port)); seen != "198.51.100.1" {
+		t.Fatalf("Expected the connection to be forwarded from the outside world, seen from %s", seen)
+	}
+
+	// The containers reach each other past the FORWARD rules
+	client, clientSettings := startHarnessContainer(t, eng, "harness_client")
+	defer client.close()
+	if seen := client.dial(t, settings.Get("IP")+":80"); seen != clientSettings.Get("IP") {
+		t.Fatalf("Expected the container to be reached from the other, seen from %s", seen)
+	}
+}
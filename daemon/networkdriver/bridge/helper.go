@@ -0,0 +1,382 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// helperCommandName is the name the network helper is run under. The
+// helper is a binary of its own, built from ./docker-network-helper, owned
+// by root with the setuid bit, which only the group of the daemon can run,
+// so that the daemon can run without CAP_NET_ADMIN. It runs nothing but
+// HelperMain, whatever its argv[0].
+const helperCommandName = "docker-network-helper"
+
+// helperCommands are the commands the helper runs for the daemon, and
+// nothing else, each with the check of its arguments. The options running
+// other programs or reading other files, such as ip netns exec, ip -batch,
+// iptables --modprobe or the modprobe options, are refused.
+var helperCommands = map[string]func(args []string) error{
+	"ip":            checkIpArgs,
+	"iptables":      checkIptablesArgs,
+	"ip6tables":     checkIptablesArgs,
+	"iptables-save": checkIptablesArgs,
+	"tc":            checkTcArgs,
+	"modprobe":      checkModprobeArgs,
+	"sysctl":        checkSysctlArgs, // writes a setting of /proc/sys/net, or an option of a bridge
+	"conntrack":     checkConntrackArgs,
+	"nsenter":       checkNsenterArgs,
+}
+
+// nsenterCommands are the commands the helper runs in the network namespace
+// of a container, with nsenter.
+var nsenterCommands = map[string]func(args []string) error{
+	"ip":     checkIpArgs,
+	"sysctl": checkNsSysctlArgs,
+}
+
+// helperPath is where the helper looks for the commands, the PATH of the
+// daemon being left out along with the rest of its environment.
+var helperPath = []string{"/sbin", "/usr/sbin", "/bin", "/usr/bin"}
+
+type helperRequest struct {
+	Command string
+	Args    []string
+}
+
+type helperResponse struct {
+	Output []byte
+	Error  string
+}
+
+// networkHelper is the client of the helper process, which it talks to
+// over a socket pair, one request at a time.
+type networkHelper struct {
+	sync.Mutex
+	cmd  *exec.Cmd
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// startHelper starts the helper at path and sends it the ip, tc and
+// iptables commands, the sysctl settings and the netlink changes.
+func startHelper(path string) (*networkHelper, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	local, remote := os.NewFile(uintptr(fds[0]), "helper"), os.NewFile(uintptr(fds[1]), "helper")
+	defer local.Close()
+	defer remote.Close()
+
+	cmd := &exec.Cmd{
+		Path:       path,
+		Args:       []string{helperCommandName},
+		Stderr:     os.Stderr,
+		ExtraFiles: []*os.File{remote},
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Unable to start the network helper %s: %s", path, err)
+	}
+	conn, err := net.FileConn(local)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	h := newNetworkHelper(conn)
+	h.cmd = cmd
+	// Makes sure the helper runs
+	if _, err := h.Run("ip", "link", "show", "lo"); err != nil {
+		h.stop()
+		return nil, fmt.Errorf("The network helper %s doesn't work: %s", path, err)
+	}
+	return h, nil
+}
+
+func newNetworkHelper(conn net.Conn) *networkHelper {
+	return &networkHelper{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}
+}
+
+// Run runs a command in the helper.
+func (h *networkHelper) Run(name string, args ...string) ([]byte, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if err := h.enc.Encode(&helperRequest{Command: name, Args: args}); err != nil {
+		return nil, fmt.Errorf("Unable to reach the network helper: %s", err)
+	}
+	var response helperResponse
+	if err := h.dec.Decode(&response); err != nil {
+		return nil, fmt.Errorf("Unable to reach the network helper: %s", err)
+	}
+	if response.Error != "" {
+		return response.Output, fmt.Errorf("%s", response.Error)
+	}
+	return response.Output, nil
+}
+
+func (h *networkHelper) stop() {
+	h.conn.Close()
+	if h.cmd != nil {
+		h.cmd.Wait()
+	}
+}
+
+// setHelper makes h, or the daemon itself if h is nil, change the host
+// networking.
+func (d *Driver) setHelper(h *networkHelper) {
+	d.helper = h
+	if h == nil {
+		d.setExecutor(nil)
+		return
+	}
+	d.setExecutor(h)
+}
+
+// stopHelper stops the helper of the driver, once the changes are undone,
+// and lets the daemon change the host networking again after an executor.
+func (d *Driver) stopHelper() {
+	if d.helper != nil && d.config.Helper != "" {
+		d.helper.stop()
+		d.setHelper(nil)
+	}
+	if d.config.Executor != nil {
+		d.setExecutor(nil)
+	}
+}
+
+// linkChange makes the change of the ip command args with f, or with the
+// executor if there is one.
+func (d *Driver) linkChange(f func() error, args ...string) error {
+	if d.executor != nil {
+		_, err := d.executor.Run("ip", args...)
+		return err
+	}
+	return f()
+}
+
+// HelperMain is the helper process, serving the requests of the daemon on
+// fd 3 until the daemon goes away.
+func HelperMain() {
+	// Run as root altogether, the commands run being set up for root rather
+	// than for the user of the daemon
+	if os.Geteuid() == 0 && os.Getuid() != 0 {
+		if err := syscall.Setresuid(0, 0, 0); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", helperCommandName, err)
+			os.Exit(1)
+		}
+	}
+	conn, err := net.FileConn(os.NewFile(3, "daemon"))
+	if err == nil {
+		err = serveHelper(conn, runHelperCommand)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", helperCommandName, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// serveHelper serves the requests of the daemon on conn, one at a time,
+// the commands allowed being run by run.
+func serveHelper(conn net.Conn, run func(name string, args []string) ([]byte, error)) error {
+	var (
+		dec = json.NewDecoder(conn)
+		enc = json.NewEncoder(conn)
+	)
+	for {
+		var request helperRequest
+		if err := dec.Decode(&request); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var response helperResponse
+		output, err := serveHelperRequest(&request, run)
+		response.Output = output
+		if err != nil {
+			response.Error = err.Error()
+		}
+		if err := enc.Encode(&response); err != nil {
+			return err
+		}
+	}
+}
+
+// serveHelperRequest runs a command allowed to the daemon, with arguments
+// allowed to it.
+func serveHelperRequest(request *helperRequest, run func(name string, args []string) ([]byte, error)) ([]byte, error) {
+	check, ok := helperCommands[request.Command]
+	if !ok {
+		return nil, fmt.Errorf("The network helper doesn't run %s", request.Command)
+	}
+	if err := check(request.Args); err != nil {
+		return nil, err
+	}
+	if request.Command == "sysctl" {
+		return nil, ioutil.WriteFile(filepath.Clean(request.Args[0]), []byte(request.Args[1]+"\n"), 0644)
+	}
+	return run(request.Command, request.Args)
+}
+
+// runHelperCommand runs a command of the helper from the system
+// directories, with an empty environment so that no variable of the daemon,
+// such as XTABLES_LIBDIR or MODPROBE_OPTIONS, changes what it does.
+func runHelperCommand(name string, args []string) ([]byte, error) {
+	for _, dir := range helperPath {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err != nil || fi.IsDir() || fi.Mode()&0111 == 0 {
+			continue
+		}
+		cmd := exec.Command(path, args...)
+		cmd.Env = []string{"PATH=" + strings.Join(helperPath, ":")}
+		return cmd.CombinedOutput()
+	}
+	if name == "ip" {
+		return nil, ipNetlink(args)
+	}
+	return nil, fmt.Errorf("%s not found in %s", name, strings.Join(helperPath, ":"))
+}
+
+// refuseHelperArgs returns the error of the helper refusing args.
+func refuseHelperArgs(name string, args []string) error {
+	return fmt.Errorf("The network helper doesn't run %s %s", name, strings.Join(args, " "))
+}
+
+// isAbbrev returns whether arg is min characters of word or more, as the
+// iproute2 tools take the abbreviations of their keywords.
+func isAbbrev(arg, word string, min int) bool {
+	return len(arg) >= min && strings.HasPrefix(word, arg)
+}
+
+// checkIpArgs refuses ip with other options than the output ones, such as
+// -batch or -force, and the netns and vrf objects, which run commands.
+func checkIpArgs(args []string) error {
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		switch strings.TrimPrefix(args[i], "-") {
+		case "4", "6", "o", "oneline", "d", "details", "s", "stats", "j", "json", "br", "brief":
+		default:
+			return refuseHelperArgs("ip", args)
+		}
+	}
+	if i < len(args) && (isAbbrev(args[i], "netns", 3) || isAbbrev(args[i], "vrf", 1)) {
+		return refuseHelperArgs("ip", args)
+	}
+	return nil
+}
+
+// checkTcArgs refuses tc with other options than the output ones, such as
+// -batch or -force, and the exec object, which runs commands.
+func checkTcArgs(args []string) error {
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		switch strings.TrimPrefix(args[i], "-") {
+		case "s", "stats", "statistics", "d", "details", "p", "pretty", "j", "json":
+		default:
+			return refuseHelperArgs("tc", args)
+		}
+	}
+	if i < len(args) && isAbbrev(args[i], "exec", 1) {
+		return refuseHelperArgs("tc", args)
+	}
+	return nil
+}
+
+// checkIptablesArgs refuses the iptables options loading the modules with
+// another program, given in full, abbreviated or among short options.
+func checkIptablesArgs(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			if name := strings.SplitN(arg[2:], "=", 2)[0]; isAbbrev(name, "modprobe", 3) {
+				return refuseHelperArgs("iptables", args)
+			}
+		} else if strings.HasPrefix(arg, "-") && strings.Contains(arg, "M") {
+			return refuseHelperArgs("iptables", args)
+		}
+	}
+	return nil
+}
+
+// checkModprobeArgs only lets modprobe load modules by name, without any
+// option such as -C, which reads another configuration.
+func checkModprobeArgs(args []string) error {
+	if len(args) == 0 {
+		return refuseHelperArgs("modprobe", args)
+	}
+	for _, arg := range args {
+		if !validModule.MatchString(arg) {
+			return refuseHelperArgs("modprobe", args)
+		}
+	}
+	return nil
+}
+
+var validModule = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_-]*$`)
+
+// checkConntrackArgs refuses the conntrack commands other than the listing
+// and the deletion of the entries.
+func checkConntrackArgs(args []string) error {
+	for _, arg := range args {
+		switch arg {
+		case "-I", "--create", "-U", "--update", "-E", "--event", "-F", "--flush", "--load-file":
+			return refuseHelperArgs("conntrack", args)
+		}
+	}
+	return nil
+}
+
+// checkSysctlArgs only lets the settings of /proc/sys/net and the options
+// of the bridges be written.
+func checkSysctlArgs(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Invalid sysctl setting %v", args)
+	}
+	path := filepath.Clean(args[0])
+	bridgeOption := strings.HasPrefix(path, "/sys/class/net/") && filepath.Base(filepath.Dir(path)) == "bridge"
+	if !strings.HasPrefix(path, "/proc/sys/net/") && !bridgeOption {
+		return fmt.Errorf("The network helper doesn't set %s", path)
+	}
+	return nil
+}
+
+var nsenterNet = regexp.MustCompile(`^--net=/proc/[0-9]+/ns/net$`)
+
+// checkNsenterArgs only lets nsenter enter the network namespace of a
+// process, to run one of nsenterCommands there.
+func checkNsenterArgs(args []string) error {
+	if len(args) < 2 || !nsenterNet.MatchString(args[0]) {
+		return refuseHelperArgs("nsenter", args)
+	}
+	check, ok := nsenterCommands[args[1]]
+	if !ok {
+		return refuseHelperArgs("nsenter", args)
+	}
+	return check(args[2:])
+}
+
+// checkNsSysctlArgs only lets sysctl write one of the parameters a container
+// can have, without any other option such as -p, which reads another file.
+func checkNsSysctlArgs(args []string) error {
+	if len(args) != 2 || args[0] != "-w" {
+		return refuseHelperArgs("sysctl", args)
+	}
+	setting := strings.SplitN(args[1], "=", 2)
+	if len(setting) != 2 || checkSysctls(map[string]string{setting[0]: setting[1]}) != nil {
+		return refuseHelperArgs("sysctl", args)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNetworkHelper(t *testing.T) {
+	var run RecordingExecutor
+	run.Respond = func(name string, args []string) ([]byte, error) {
+		if name == "ip" && args[len(args)-1] == "nosuchlink0" {
+			return []byte("Device \"nosuchlink0\" does not exist."), fmt.Errorf("exit status 1")
+		}
+		return nil, nil
+	}
+	daemon, server := net.Pipe()
+	go serveHelper(server, func(name string, args []string) ([]byte, error) {
+		return run.Run(name, args...)
+	})
+	h := newNetworkHelper(daemon)
+	defer h.stop()
+	d := newDriver(&Config{})
+	d.setHelper(h)
+
+	if err := d.runCommand("ip", "link", "show", "lo"); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"sh", "-c", "true"},
+		{"sysctl", "/proc/sys/kernel/core_pattern", "core"},
+		{"sysctl", "/proc/sys/net/../kernel/core_pattern", "core"},
+		{"sysctl", "/sys/class/net/docker0/address", "02:42:ac:11:00:01"},
+		{"sysctl", "/sys/class/net/docker0/bridge/../../../../../kernel/mm/ksm/run", "1"},
+		{"ip", "netns", "exec", "ns1", "/bin/sh"},
+		{"ip", "net", "e", "ns1", "/bin/sh"},
+		{"ip", "vrf", "exec", "red", "/bin/sh"},
+		{"ip", "-batch", "/tmp/commands"},
+		{"ip", "-b", "/tmp/commands"},
+		{"ip", "-force", "link", "show"},
+		{"tc", "exec", "bpf", "import", "/tmp/sock", "run", "/bin/sh"},
+		{"tc", "-batch", "/tmp/commands"},
+		{"iptables", "--modprobe=/tmp/sh", "-L"},
+		{"iptables", "--modp", "/tmp/sh", "-L"},
+		{"iptables", "-nM/tmp/sh", "-L"},
+		{"iptables-save", "-M", "/tmp/sh"},
+		{"modprobe", "-C", "/tmp/modprobe.conf", "br_netfilter"},
+		{"modprobe", "--config=/tmp/modprobe.conf", "br_netfilter"},
+		{"modprobe"},
+		{"nsenter", "--net=/proc/1/ns/net", "sh"},
+		{"nsenter", "--target=1", "--net", "ip", "link", "show"},
+		{"nsenter", "--net=/tmp/ns", "ip", "link", "show"},
+		{"nsenter", "--net=/proc/1/ns/net", "ip", "netns", "exec", "ns1", "/bin/sh"},
+		{"nsenter", "--net=/proc/1/ns/net", "sysctl", "-p", "/tmp/sysctl.conf"},
+		{"nsenter", "--net=/proc/1/ns/net", "sysctl", "-w", "kernel.core_pattern=|/tmp/sh"},
+	} {
+		if _, err := h.Run(args[0], args[1:]...); err == nil || !strings.Contains(err.Error(), "The network helper doesn't") {
+			t.Fatalf("Expected the helper to refuse %v, got %v", args, err)
+		}
+	}
+	if err := d.runCommand("ip", "link", "show", "nosuchlink0"); err == nil || !strings.Contains(err.Error(), "ip link show nosuchlink0 failed") {
+		t.Fatalf("Expected the failure of the command to be reported, got %v", err)
+	}
+	if _, err := d.firewall.Raw(false, "-n", "-L", "FORWARD"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.runCommand("modprobe", "br_netfilter"); err != nil {
+		t.Fatal(err)
+	}
+	// The secondary addresses and the parameters of the containers
+	if err := d.runCommand("nsenter", "--net=/proc/42/ns/net", "ip", "addr", "add", "172.17.0.9/16", "dev", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.runCommand("nsenter", "--net=/proc/42/ns/net", "sysctl", "-w", "net.core.somaxconn=1024"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"ip link show lo",
+		"ip link show nosuchlink0",
+		"iptables -n -L FORWARD",
+		"modprobe br_netfilter",
+		"nsenter --net=/proc/42/ns/net ip addr add 172.17.0.9/16 dev eth0",
+		"nsenter --net=/proc/42/ns/net sysctl -w net.core.somaxconn=1024",
+	}
+	// iptables waits for the xtables lock where it can
+	ran := strings.Replace(strings.Join(run.Lines(), "\n"), " --wait", "", -1)
+	if ran != strings.Join(expected, "\n") {
+		t.Fatalf("Expected the helper to run %v, ran %v", expected, run.Lines())
+	}
+}
@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The network events are given to the hooks of the operators as well, the
+// executables integrating the containers with the firewalls, DNS or
+// inventory systems of the site. Each one is run with the event as JSON on
+// its standard input, in the order of the events, bounded by the command
+// timeout. The events don't wait for the hooks, those the hooks are too
+// slow for being dropped.
+const hookQueueSize = 256
+
+// hookEvent is the JSON payload given to the hooks.
+type hookEvent struct {
+	Event     string // ex: net:map
+	Container string // container id, empty for the events of the bridge
+	Detail    string // ex: the ip allocated, or tcp/0.0.0.0:49153->172.17.0.2:80
+	Bridge    string
+	Time      time.Time
+	Name      string            `json:",omitempty"` // of the port mapping of the event, if any
+	Labels    map[string]string `json:",omitempty"` // of the port mapping of the event, if any
+}
+
+type hookRunner struct {
+	hooks   []string
+	timeout time.Duration // the hooks are killed past it, 0 for never
+	events  chan *hookEvent
+	stop    chan struct{} // stops running the hooks when closed
+}
+
+func newHookRunner(hooks []string, timeout time.Duration) *hookRunner {
+	r := &hookRunner{
+		hooks:   hooks,
+		timeout: timeout,
+		events:  make(chan *hookEvent, hookQueueSize),
+		stop:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *hookRunner) run() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case e := <-r.events:
+			payload, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			for _, hook := range r.hooks {
+				cmd := exec.Command(hook)
+				cmd.Stdin = bytes.NewReader(payload)
+				if output, err := commandOutput(cmd, r.timeout); err != nil {
+					log.WithField("container", e.Container).Warnf("Network hook %s failed on %s: %s: %s", hook, e.Event, err, strings.TrimSpace(string(output)))
+				}
+			}
+		}
+	}
+}
+
+// send queues e for the hooks.
+func (r *hookRunner) send(e *hookEvent) {
+	select {
+	case r.events <- e:
+	default:
+		log.WithField("container", e.Container).Warnf("Network hooks too slow, dropped the %s event", e.Event)
+	}
+}
+
+func (r *hookRunner) close() {
+	close(r.stop)
+}
@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestNetworkHooks(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	dir, err := ioutil.TempDir("", "docker-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The hook appends the events it is given, one per line
+	events := filepath.Join(dir, "events")
+	hook := filepath.Join(dir, "hook")
+	if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\ncat >> "+events+"\necho >> "+events+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDriver(&Config{Hooks: []string{hook}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.hooks.close()
+	if err := d.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+	if res := d.Allocate(eng.Job("allocate_interface", "hooks_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	ip := d.currentInterfaces.Get("hooks_container").IP.String()
+	if res := d.Release(eng.Job("release_interface", "hooks_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+
+	var lines []string
+	for deadline := time.Now().Add(5 * time.Second); len(lines) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, _ := ioutil.ReadFile(events)
+		lines = strings.Fields(string(data))
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Expected the hook to be run twice, got %v", lines)
+	}
+	for i, expected := range []string{eventAllocate, eventRelease} {
+		var e hookEvent
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Event != expected || e.Container != "hooks_container" || e.Detail != ip {
+			t.Fatalf("Expected the %s event of %s, got %+v", expected, ip, e)
+		}
+	}
+}
+
+func TestValidateHooks(t *testing.T) {
+	if err := (&Config{Hooks: []string{"hook.sh"}}).validate(); err == nil {
+		t.Fatal("Expected a relative hook path to be refused")
+	}
+}
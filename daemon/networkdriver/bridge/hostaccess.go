@@ -0,0 +1,108 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// hostPort is a port on the host that containers are allowed to reach.
+type hostPort struct {
+	Proto string
+	Port  int
+}
+
+// parseHostPort parses a PORT[/PROTO] specification, tcp being the default.
+func parseHostPort(spec string) (hostPort, error) {
+	port, proto := spec, "tcp"
+	if i := strings.Index(spec, "/"); i != -1 {
+		port, proto = spec[:i], spec[i+1:]
+	}
+	if proto != "tcp" && proto != "udp" {
+		return hostPort{}, fmt.Errorf("Invalid host port %s: unsupported protocol %s", spec, proto)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return hostPort{}, fmt.Errorf("Invalid host port %s", spec)
+	}
+	return hostPort{Proto: proto, Port: p}, nil
+}
+
+// parseHostPorts parses the ports of the --host-access list.
+func parseHostPorts(specs []string) ([]hostPort, error) {
+	var ports []hostPort
+	for _, spec := range specs {
+		p, err := parseHostPort(spec)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+func (d *Driver) hostAcceptArgs(p hostPort) []string {
+	return []string{d.hostAccessChain, "-p", p.Proto, "--dport", strconv.Itoa(p.Port), "-j", "ACCEPT"}
+}
+
+// hostAccessRules renders the content of the host access chain: replies and
+// shared ports are accepted, anything else addressed to the host is dropped.
+func (d *Driver) hostAccessRules(shared []hostPort) [][]string {
+	out := [][]string{
+		{"-A", d.hostAccessChain, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	for _, p := range shared {
+		out = append(out, append([]string{"-A"}, d.hostAcceptArgs(p)...))
+	}
+	return append(out, []string{"-A", d.hostAccessChain, "-j", "DROP"})
+}
+
+func (d *Driver) hostAccessJumpArgs() []string {
+	return []string{"INPUT", "-i", d.bridgeIface, "-j", d.hostAccessChain}
+}
+
+// removeHostAccessChain removes the host access chain. Errors are ignored,
+// the chain might not exist.
+func (d *Driver) removeHostAccessChain() {
+	d.firewall.Raw(false, append([]string{"-D"}, d.hostAccessJumpArgs()...)...)
+	d.firewall.Raw(false, "-F", d.hostAccessChain)
+	d.firewall.Raw(false, "-X", d.hostAccessChain)
+}
+
+// setupHostAccess (re)creates the chain that keeps containers from reaching
+// services listening on the host, except for the shared ports.
+func (d *Driver) setupHostAccess(shared []hostPort) error {
+	d.removeHostAccessChain()
+
+	if err := d.execRule(false, "-N", d.hostAccessChain); err != nil {
+		return err
+	}
+	for _, args := range d.hostAccessRules(shared) {
+		if err := d.execRule(false, args...); err != nil {
+			return err
+		}
+	}
+	return d.execRule(false, append([]string{"-I"}, d.hostAccessJumpArgs()...)...)
+}
+
+// shareHostPort lets containers reach a port on the host, typically the
+// userland proxy of a published port.
+func (d *Driver) shareHostPort(p hostPort) error {
+	return d.execRule(false, append([]string{"-I"}, d.hostAcceptArgs(p)...)...)
+}
+
+func (d *Driver) unshareHostPort(p hostPort) {
+	d.firewall.Raw(false, append([]string{"-D"}, d.hostAcceptArgs(p)...)...)
+}
+
+// mappedHostPort returns the host side of a port mapping.
+func mappedHostPort(host net.Addr) hostPort {
+	switch a := host.(type) {
+	case *net.TCPAddr:
+		return hostPort{Proto: "tcp", Port: a.Port}
+	case *net.UDPAddr:
+		return hostPort{Proto: "udp", Port: a.Port}
+	}
+	return hostPort{}
+}
@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHostPort(t *testing.T) {
+	for spec, expected := range map[string]hostPort{
+		"53/udp":   {Proto: "udp", Port: 53},
+		"6379":     {Proto: "tcp", Port: 6379},
+		"6379/tcp": {Proto: "tcp", Port: 6379},
+	} {
+		p, err := parseHostPort(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != expected {
+			t.Fatalf("Expected %v, got %v", expected, p)
+		}
+	}
+	for _, spec := range []string{"", "0", "redis", "53/icmp", "65536/tcp"} {
+		if _, err := parseHostPort(spec); err == nil {
+			t.Fatalf("Expected error parsing %q", spec)
+		}
+	}
+}
+
+func TestHostAccessRules(t *testing.T) {
+	d := newDriver(&Config{})
+	rules := d.hostAccessRules([]hostPort{{Proto: "udp", Port: 53}})
+	expected := []string{
+		"-A DOCKER-INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"-A DOCKER-INPUT -p udp --dport 53 -j ACCEPT",
+		"-A DOCKER-INPUT -j DROP",
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %d", len(expected), len(rules))
+	}
+	for i, args := range rules {
+		if strings.Join(args, " ") != expected[i] {
+			t.Fatalf("Expected %q, got %q", expected[i], strings.Join(args, " "))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package bridge
+
+import ()
+
+// Where the FORWARD policy drops what isn't accepted, the pings of the
+// health checks to the containers are dropped, and so are the ICMP errors
+// of the path MTU discovery which conntrack doesn't relate to a connection,
+// such as after the flush of its table, leaving the connections of the
+// containers hanging on the larger packets. With AllowIcmp, the driver
+// accepts both, the pings coming from outside the bridge only for the
+// inter-container communication to be left to --icc.
+
+// icmpArgs returns the FORWARD rules accepting the pings to the containers
+// and the ICMP errors of the path MTU discovery.
+func (d *Driver) icmpArgs(ipv6 bool) [][]string {
+	proto, typeOption, echo, tooBig := "icmp", "--icmp-type", "echo-request", "fragmentation-needed"
+	if ipv6 {
+		proto, typeOption, tooBig = "icmpv6", "--icmpv6-type", "packet-too-big"
+	}
+	return [][]string{
+		{"FORWARD", "!", "-i", d.bridgeIface, "-o", d.bridgeIface, "-p", proto, typeOption, echo, "-j", "ACCEPT"},
+		{"FORWARD", "-o", d.bridgeIface, "-p", proto, typeOption, tooBig, "-j", "ACCEPT"},
+	}
+}
+
+// setupIcmp accepts the pings and the ICMP errors of the path MTU
+// discovery to the containers, or removes the rules if allow is false.
+func (d *Driver) setupIcmp(ipv6, allow bool) error {
+	for _, args := range d.icmpArgs(ipv6) {
+		if !allow {
+			d.firewall.Raw(ipv6, append([]string{"-D"}, args...)...)
+			continue
+		}
+		if d.firewall.Exists(ipv6, args...) {
+			continue
+		}
+		if err := d.execRule(ipv6, append([]string{"-I"}, args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
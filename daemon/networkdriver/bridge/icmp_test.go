@@ -0,0 +1,40 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetupIcmp(t *testing.T) {
+	d := &Driver{bridgeIface: "docker0"}
+	dryRunDriver(d)
+
+	for _, test := range []struct {
+		ipv6, allow bool
+		changes     []string
+	}{
+		{false, true, []string{
+			"iptables -I FORWARD ! -i docker0 -o docker0 -p icmp --icmp-type echo-request -j ACCEPT",
+			"iptables -I FORWARD -o docker0 -p icmp --icmp-type fragmentation-needed -j ACCEPT",
+		}},
+		{true, true, []string{
+			"ip6tables -I FORWARD ! -i docker0 -o docker0 -p icmpv6 --icmpv6-type echo-request -j ACCEPT",
+			"ip6tables -I FORWARD -o docker0 -p icmpv6 --icmpv6-type packet-too-big -j ACCEPT",
+		}},
+		{false, false, []string{
+			"iptables -D FORWARD ! -i docker0 -o docker0 -p icmp --icmp-type echo-request -j ACCEPT",
+			"iptables -D FORWARD -o docker0 -p icmp --icmp-type fragmentation-needed -j ACCEPT",
+		}},
+	} {
+		d.changes.planned = nil
+		if err := d.setupIcmp(test.ipv6, test.allow); err != nil {
+			t.Fatal(err)
+		}
+		plan := strings.Join(d.changes.plan(), "\n")
+		for _, change := range test.changes {
+			if !strings.Contains(plan, change) {
+				t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+			}
+		}
+	}
+}
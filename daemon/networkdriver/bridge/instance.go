@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxChainName is the longest name iptables accepts for a chain.
+const maxChainName = 28
+
+// validInstance matches the names of the daemon instances, short enough for
+// the bridge and the chains named after them.
+var validInstance = regexp.MustCompile(`^[a-z0-9]{1,8}$`)
+
+// instanceBridge and instanceChain return the default bridge and chain of a
+// daemon instance, so that several daemons of a host don't share them.
+func instanceBridge(instance string) string {
+	if instance == "" {
+		return DefaultNetworkBridge
+	}
+	return "docker-" + instance
+}
+
+func instanceChain(instance string) string {
+	if instance == "" {
+		return DefaultChain
+	}
+	return DefaultChain + "-" + strings.ToUpper(instance)
+}
+
+// statePath returns the path of a state file in root, the files of the
+// instances sharing root being told apart by their name.
+func statePath(root, name, instance string) string {
+	if instance != "" {
+		ext := path.Ext(name)
+		name = strings.TrimSuffix(name, ext) + "-" + instance + ext
+	}
+	return path.Join(root, name)
+}
+
+// shortChainName returns prefix followed by the ip, in hexadecimal if its
+// usual form makes the name too long for iptables.
+func shortChainName(prefix string, ip net.IP) string {
+	if name := prefix + ip.String(); len(name) <= maxChainName {
+		return name
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return prefix + hex.EncodeToString(ip)
+}
+
+// claim takes host-wide locks on the bridge and the chain of the driver,
+// in dir, so that another daemon using them fails to start rather than
+// fighting over them. The locks go away along with the process.
+func (d *Driver) claim(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for _, name := range []string{"bridge-" + d.bridgeIface, "chain-" + d.chain} {
+		f, err := os.OpenFile(path.Join(dir, name+".lock"), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			d.releaseClaims()
+			return err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			owner, _ := ioutil.ReadAll(f)
+			f.Close()
+			d.releaseClaims()
+			if err == syscall.EWOULDBLOCK {
+				return fmt.Errorf("The %s is used by another daemon (pid %s), give each daemon its own with --network-instance",
+					strings.Replace(name, "-", " ", 1), strings.TrimSpace(string(owner)))
+			}
+			return err
+		}
+		f.Truncate(0)
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		d.claims = append(d.claims, f)
+	}
+	return nil
+}
+
+func (d *Driver) releaseClaims() {
+	for _, f := range d.claims {
+		f.Close()
+	}
+	d.claims = nil
+}
@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInstanceNames(t *testing.T) {
+	d := newDriver(&Config{Instance: "test"})
+	if d.bridgeIface != "docker-test" || d.chain != "DOCKER-TEST" || d.accountingChain != "DOCKER-TEST-ACCT" {
+		t.Fatalf("Unexpected names %s %s %s", d.bridgeIface, d.chain, d.accountingChain)
+	}
+	if p := statePath("/var/lib/docker", savedInterfacesName, "test"); p != "/var/lib/docker/network-interfaces-test.json" {
+		t.Fatalf("Unexpected state file %s", p)
+	}
+	if p := statePath("/var/lib/docker", journalName, ""); p != "/var/lib/docker/network-journal" {
+		t.Fatalf("Unexpected state file %s", p)
+	}
+
+	d = newDriver(&Config{Instance: "abcdefgh"})
+	if name := d.egressChainName(net.ParseIP("172.17.123.234")); name != "DOCKER-ABCDEFGH-EG-ac117bea" {
+		t.Fatalf("Expected the egress chain name to be shortened, got %s", name)
+	}
+	for _, config := range []*Config{{Instance: "Test"}, {Instance: "toolongname"}, {Chain: "A-VERY-LONG-CHAIN-NAME"}} {
+		if err := config.validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestClaim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-network-locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newDriver(&Config{Instance: "claim"})
+	if err := d.claim(dir); err != nil {
+		t.Fatal(err)
+	}
+	other := newDriver(&Config{Instance: "claim"})
+	if err := other.claim(dir); err == nil || !strings.Contains(err.Error(), "bridge docker-claim is used by another daemon") {
+		t.Fatalf("Expected the bridge to be in use, got %v", err)
+	}
+	if err := newDriver(&Config{Instance: "other"}).claim(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	d.releaseClaims()
+	if err := other.claim(dir); err != nil {
+		t.Fatal(err)
+	}
+	other.releaseClaims()
+}
@@ -0,0 +1,424 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/docker/libcontainer/netlink"
+)
+
+// The routing attributes the kernel headers define but syscall doesn't
+const (
+	rtaTable     = 15
+	fraSrc       = 2
+	fraFwmark    = 10
+	fraTable     = 15
+	frActToTable = 1
+)
+
+var (
+	nativeEndian binary.ByteOrder = binary.LittleEndian
+	netlinkSeq   uint32
+)
+
+func init() {
+	var x uint16 = 1
+	if (*[2]byte)(unsafe.Pointer(&x))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// ipNetlink makes the change of the iproute2 command ip with args through
+// netlink, for the hosts where ip isn't installed. Only the commands the
+// driver runs are supported.
+func ipNetlink(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("Unsupported ip command %v", args)
+	}
+	switch args[0] {
+	case "link":
+		return linkNetlink(args[1], args[2:])
+	case "addr", "address":
+		return addrNetlink(args[1], args[2:])
+	case "route":
+		return routeNetlink(args[1], args[2:])
+	case "rule":
+		return ruleNetlink(args[1], args[2:])
+	}
+	return fmt.Errorf("Unsupported ip command %v", args)
+}
+
+// ipOptions splits the arguments of an ip command in the keyword options
+// and the value given without a keyword, such as the route destination.
+func ipOptions(args []string, keywords ...string) (map[string]string, string, error) {
+	var (
+		options = make(map[string]string)
+		value   string
+	)
+	for i := 0; i < len(args); i++ {
+		known := false
+		for _, k := range keywords {
+			if args[i] == k {
+				known = true
+				break
+			}
+		}
+		switch {
+		case known && i+1 < len(args):
+			options[args[i]] = args[i+1]
+			i++
+		case !known && value == "":
+			value = args[i]
+		default:
+			return nil, "", fmt.Errorf("Unsupported ip option %s", args[i])
+		}
+	}
+	return options, value, nil
+}
+
+func linkNetlink(action string, args []string) error {
+	if action == "set" && len(args) >= 2 {
+		iface, err := net.InterfaceByName(args[0])
+		if err != nil {
+			return err
+		}
+		switch {
+		case args[1] == "up":
+			return netlink.NetworkLinkUp(iface)
+		case args[1] == "down":
+			return netlink.NetworkLinkDown(iface)
+		case args[1] == "mtu" && len(args) == 3:
+			mtu, err := strconv.Atoi(args[2])
+			if err != nil {
+				return err
+			}
+			return netlink.NetworkSetMTU(iface, mtu)
+		}
+		return fmt.Errorf("Unsupported ip link command %s %v", action, args)
+	}
+
+	options, name, err := ipOptions(args, "type")
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "add":
+		return netlink.NetworkLinkAdd(name, options["type"])
+	case "del", "delete":
+		return netlink.NetworkLinkDel(name)
+	}
+	return fmt.Errorf("Unsupported ip link command %s %v", action, args)
+}
+
+func addrNetlink(action string, args []string) error {
+	options, cidr, err := ipOptions(args, "dev")
+	if err != nil {
+		return err
+	}
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	iface, err := net.InterfaceByName(options["dev"])
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "add":
+		return netlink.NetworkLinkAddIp(iface, ip, ipNet)
+	case "del", "delete":
+		return netlink.NetworkLinkDelIp(iface, ip, ipNet)
+	}
+	return fmt.Errorf("Unsupported ip addr command %s %v", action, args)
+}
+
+func routeNetlink(action string, args []string) error {
+	options, dst, err := ipOptions(args, "via", "dev", "table")
+	if err != nil {
+		return err
+	}
+	table, err := routeTable(options["table"])
+	if err != nil {
+		return err
+	}
+	if action == "flush" {
+		return flushTable(table)
+	}
+
+	if dst == "default" {
+		dst = "0.0.0.0/0"
+	}
+	_, dstNet, err := net.ParseCIDR(dst)
+	if err != nil {
+		return err
+	}
+	family, dstIP := ipFamily(dstNet.IP)
+	ones, _ := dstNet.Mask.Size()
+	msg := &syscall.RtMsg{
+		Family:   family,
+		Dst_len:  uint8(ones),
+		Table:    uint8(table),
+		Protocol: syscall.RTPROT_BOOT,
+		Scope:    syscall.RT_SCOPE_LINK,
+		Type:     syscall.RTN_UNICAST,
+	}
+	if table > 255 {
+		msg.Table = syscall.RT_TABLE_UNSPEC
+	}
+	attrs := [][]byte{uint32Attr(rtaTable, uint32(table))}
+	if ones > 0 {
+		attrs = append(attrs, rtAttr(syscall.RTA_DST, dstIP))
+	}
+	if via := options["via"]; via != "" {
+		gw := net.ParseIP(via)
+		if gw == nil {
+			return fmt.Errorf("Invalid gateway %s", via)
+		}
+		_, gwIP := ipFamily(gw)
+		attrs = append(attrs, rtAttr(syscall.RTA_GATEWAY, gwIP))
+		msg.Scope = syscall.RT_SCOPE_UNIVERSE
+	}
+	if dev := options["dev"]; dev != "" {
+		iface, err := net.InterfaceByName(dev)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, uint32Attr(syscall.RTA_OIF, uint32(iface.Index)))
+	}
+
+	var msgType, flags int
+	switch action {
+	case "add":
+		msgType, flags = syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL
+	case "replace":
+		msgType, flags = syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE
+	case "del", "delete":
+		msgType = syscall.RTM_DELROUTE
+		msg.Scope = syscall.RT_SCOPE_NOWHERE
+	default:
+		return fmt.Errorf("Unsupported ip route command %s %v", action, args)
+	}
+	return netlinkRequest(msgType, flags, (*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(msg))[:], attrs...)
+}
+
+// flushTable removes the routes of the routing table.
+func flushTable(table int) error {
+	for _, family := range []int{syscall.AF_INET, syscall.AF_INET6} {
+		routes, err := routesOf(family, table)
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			if err := netlinkRequest(syscall.RTM_DELROUTE, 0, route); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tableInUse tells whether the routing table has routes or is looked up by
+// a rule.
+func tableInUse(table int) (bool, error) {
+	for _, family := range []int{syscall.AF_INET, syscall.AF_INET6} {
+		routes, err := routesOf(family, table)
+		if err != nil {
+			return false, err
+		}
+		rules, err := ruleMessagesOf(family, table)
+		if err != nil {
+			return false, err
+		}
+		if len(routes) > 0 || len(rules) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// routesOf returns the netlink messages of the routes of a table.
+func routesOf(family, table int) ([][]byte, error) {
+	return tableMessages(syscall.RTM_GETROUTE, syscall.RTM_NEWROUTE, family, table)
+}
+
+// ruleMessagesOf returns the netlink messages of the rules looking up a
+// table.
+func ruleMessagesOf(family, table int) ([][]byte, error) {
+	return tableMessages(syscall.RTM_GETRULE, syscall.RTM_NEWRULE, family, table)
+}
+
+// tableMessages dumps the routes or the rules, whose headers are laid out
+// alike, and returns the messages of those of table.
+func tableMessages(request, msgType, family, table int) ([][]byte, error) {
+	rib, err := syscall.NetlinkRIB(request, family)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var entries [][]byte
+	for _, m := range msgs {
+		if int(m.Header.Type) != msgType || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		t := int(m.Data[4])
+		// syscall only parses the attributes of the routes, not of the rules
+		for b := m.Data[syscall.SizeofRtMsg:]; len(b) >= syscall.SizeofRtAttr; {
+			l := int(nativeEndian.Uint16(b[0:2]))
+			if l < syscall.SizeofRtAttr || l > len(b) {
+				break
+			}
+			if nativeEndian.Uint16(b[2:4]) == rtaTable && l >= syscall.SizeofRtAttr+4 {
+				t = int(nativeEndian.Uint32(b[syscall.SizeofRtAttr:]))
+			}
+			if a := (l + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1); a < len(b) {
+				b = b[a:]
+			} else {
+				break
+			}
+		}
+		if t == table {
+			entries = append(entries, m.Data)
+		}
+	}
+	return entries, nil
+}
+
+func ruleNetlink(action string, args []string) error {
+	options, _, err := ipOptions(args, "from", "fwmark", "table")
+	if err != nil {
+		return err
+	}
+	table, err := routeTable(options["table"])
+	if err != nil {
+		return err
+	}
+	// The header of the rules is laid out as the one of the routes
+	msg := &syscall.RtMsg{
+		Family: syscall.AF_INET,
+		Table:  uint8(table),
+		Type:   frActToTable,
+	}
+	if table > 255 {
+		msg.Table = syscall.RT_TABLE_UNSPEC
+	}
+	attrs := [][]byte{uint32Attr(fraTable, uint32(table))}
+	if from := options["from"]; from != "" {
+		ip := net.ParseIP(from)
+		if ip == nil {
+			return fmt.Errorf("Invalid source %s", from)
+		}
+		family, src := ipFamily(ip)
+		msg.Family = family
+		msg.Src_len = uint8(len(src) * 8)
+		attrs = append(attrs, rtAttr(fraSrc, src))
+	}
+	if fwmark := options["fwmark"]; fwmark != "" {
+		mark, err := strconv.ParseUint(fwmark, 0, 32)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, uint32Attr(fraFwmark, uint32(mark)))
+	}
+
+	body := (*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(msg))[:]
+	switch action {
+	case "add":
+		return netlinkRequest(syscall.RTM_NEWRULE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, body, attrs...)
+	case "del", "delete":
+		return netlinkRequest(syscall.RTM_DELRULE, 0, body, attrs...)
+	}
+	return fmt.Errorf("Unsupported ip rule command %s %v", action, args)
+}
+
+func routeTable(s string) (int, error) {
+	switch s {
+	case "", "main":
+		return syscall.RT_TABLE_MAIN, nil
+	case "default":
+		return syscall.RT_TABLE_DEFAULT, nil
+	case "local":
+		return syscall.RT_TABLE_LOCAL, nil
+	}
+	table, err := strconv.Atoi(s)
+	if err != nil || table <= 0 {
+		return 0, fmt.Errorf("Invalid routing table %s", s)
+	}
+	return table, nil
+}
+
+func ipFamily(ip net.IP) (uint8, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return syscall.AF_INET, ip4
+	}
+	return syscall.AF_INET6, ip.To16()
+}
+
+func rtAttr(attrType int, value []byte) []byte {
+	b := make([]byte, (syscall.SizeofRtAttr+len(value)+syscall.RTA_ALIGNTO-1)&^(syscall.RTA_ALIGNTO-1))
+	nativeEndian.PutUint16(b[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+	nativeEndian.PutUint16(b[2:4], uint16(attrType))
+	copy(b[syscall.SizeofRtAttr:], value)
+	return b
+}
+
+func uint32Attr(attrType int, value uint32) []byte {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, value)
+	return rtAttr(attrType, b)
+}
+
+// netlinkRequest sends a request to the kernel and waits for its
+// acknowledgement.
+func netlinkRequest(msgType, flags int, body []byte, attrs ...[]byte) error {
+	s, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(s)
+	if err := syscall.Bind(s, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	seq := atomic.AddUint32(&netlinkSeq, 1)
+	b := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(body))
+	b = append(b, body...)
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	nativeEndian.PutUint16(b[4:6], uint16(msgType))
+	nativeEndian.PutUint16(b[6:8], uint16(flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK))
+	nativeEndian.PutUint32(b[8:12], seq)
+	if err := syscall.Sendto(s, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(s, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq || m.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if errno := -int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return syscall.Errno(errno)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestIpNetlink(t *testing.T) {
+	const bridge = "dockernl0"
+	for _, args := range [][]string{
+		{"link", "add", bridge, "type", "bridge"},
+		{"link", "set", bridge, "mtu", "1400"},
+		{"link", "set", bridge, "up"},
+		{"addr", "add", "10.250.0.1/24", "dev", bridge},
+		{"route", "replace", "10.250.0.0/24", "dev", bridge, "table", "250"},
+		{"route", "replace", "default", "via", "10.250.0.254", "table", "250"},
+		{"rule", "add", "fwmark", "250", "table", "250"},
+		{"rule", "add", "from", "10.250.0.2", "table", "250"},
+	} {
+		if err := ipNetlink(args); err != nil {
+			t.Fatalf("ip %v: %s", args, err)
+		}
+	}
+	defer ipNetlink([]string{"link", "del", bridge})
+
+	routes, err := routesOf(syscall.AF_INET, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes in the table, got %d", len(routes))
+	}
+	rules, err := ruleMessagesOf(syscall.AF_INET, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules looking up the table, got %d", len(rules))
+	}
+
+	// The rules alone keep the table in use
+	if err := ipNetlink([]string{"route", "flush", "table", "250"}); err != nil {
+		t.Fatal(err)
+	}
+	if inUse, err := tableInUse(250); err != nil || !inUse {
+		t.Fatalf("Expected the table looked up by the rules to be in use (%v)", err)
+	}
+
+	for _, args := range [][]string{
+		{"rule", "del", "fwmark", "250", "table", "250"},
+		{"rule", "del", "from", "10.250.0.2", "table", "250"},
+		{"addr", "del", "10.250.0.1/24", "dev", bridge},
+	} {
+		if err := ipNetlink(args); err != nil {
+			t.Fatalf("ip %v: %s", args, err)
+		}
+	}
+	if routes, err := routesOf(syscall.AF_INET, 250); err != nil || len(routes) != 0 {
+		t.Fatalf("Expected the table to be flushed, got %d routes (%v)", len(routes), err)
+	}
+	if inUse, err := tableInUse(250); err != nil || inUse {
+		t.Fatalf("Expected the table to be free (%v)", err)
+	}
+	if err := ipNetlink([]string{"rule", "del", "fwmark", "250", "table", "250"}); err == nil {
+		t.Fatal("Expected the deleted rule to be gone")
+	}
+	if err := ipNetlink([]string{"route", "replace", "default", "metric", "10"}); err == nil {
+		t.Fatal("Expected the unsupported option to be refused")
+	}
+}
@@ -0,0 +1,541 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+// The journal records every ip, tc and iptables command the driver runs, one
+// JSON entry per line, so that the changes made to the host can be audited
+// and undone even if the daemon crashed before cleaning up after itself.
+const (
+	journalName = "network-journal"
+	// The journal is pruned of the changes already undone once it grows
+	// beyond this size
+	journalMaxSize = 1 << 20
+)
+
+type journalEntry struct {
+	Time      time.Time
+	Container string `json:",omitempty"`
+	Command   string
+	Args      []string
+	Error     string `json:",omitempty"`
+}
+
+type journal struct {
+	sync.Mutex
+	f       *os.File
+	path    string
+	owners  map[string]string // container ids by ip
+	rules   map[string]string // comments of the iptables rules added, by rule
+	planned []string
+	tx      *transaction
+
+	// In dry-run mode the changes are logged and kept in the journal's plan
+	// instead of being made
+	dryRun bool
+}
+
+func newJournal(dryRun bool) *journal {
+	return &journal{owners: make(map[string]string), rules: make(map[string]string), dryRun: dryRun}
+}
+
+// transaction collects the changes made while it is open, so that a setup
+// failing halfway can be undone.
+type transaction struct {
+	changes []*journalEntry
+}
+
+// open starts appending the changes to the journal at path.
+func (j *journal) open(path string) error {
+	if fi, err := os.Stat(path); err == nil && fi.Size() > journalMaxSize {
+		if err := pruneJournal(path); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// The rules added by a previous run are the daemon's to delete too
+	if entries, err := readJournal(path); err == nil {
+		j.learn(pendingChanges(entries))
+	}
+
+	j.Lock()
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f = f
+	j.path = path
+	j.Unlock()
+	return nil
+}
+
+// closeJournal stops journaling the changes. With undo, the pending changes
+// of the journal are undone.
+func (d *Driver) closeJournal(undo bool) error {
+	j := d.changes
+	j.Lock()
+	f, path := j.f, j.path
+	j.f, j.path = nil, ""
+	j.Unlock()
+
+	if f == nil {
+		return nil
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if undo {
+		return d.rollbackJournal(path)
+	}
+	return nil
+}
+
+// own attributes the changes involving ip to the container id.
+func (j *journal) own(ip net.IP, id string) {
+	j.Lock()
+	j.owners[ip.String()] = id
+	j.Unlock()
+}
+
+func (j *journal) disown(ip net.IP) {
+	j.Lock()
+	delete(j.owners, ip.String())
+	j.Unlock()
+}
+
+// record appends a command to the journal, along with the container it was
+// run for, found from the container ips among its arguments. In dry-run mode
+// the command wasn't run, it is added to the plan instead.
+func (j *journal) record(cmd string, args []string, err error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if (cmd == "iptables" || cmd == "ip6tables") && err == nil {
+		j.track(args)
+	}
+	if j.dryRun {
+		change := cmd + " " + strings.Join(args, " ")
+		log.Infof("Dry run: %s", change)
+		j.planned = append(j.planned, change)
+		return
+	}
+	if undoArgs(cmd, args) == nil && !isRemoval(cmd, args) {
+		// Only changes are of interest, not listings
+		return
+	}
+	e := &journalEntry{
+		Time:    time.Now().UTC(),
+		Command: cmd,
+		Args:    args,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	for _, arg := range args {
+		if id, exists := j.owners[argIP(arg)]; exists {
+			e.Container = id
+			break
+		}
+	}
+	if j.tx != nil {
+		j.tx.changes = append(j.tx.changes, e)
+	}
+	if j.f == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		log.Errorf("Unable to write the network journal: %s", err)
+	}
+}
+
+// begin opens a transaction, only one can be open at a time.
+func (j *journal) begin() *transaction {
+	j.Lock()
+	defer j.Unlock()
+	j.tx = &transaction{}
+	return j.tx
+}
+
+// end closes the open transaction, keeping its changes.
+func (j *journal) end() {
+	j.Lock()
+	j.tx = nil
+	j.Unlock()
+}
+
+// rollback closes the transaction and undoes its changes.
+func (d *Driver) rollback(tx *transaction) {
+	d.changes.end()
+	d.undoChanges(pendingChanges(tx.changes))
+}
+
+// plan returns the changes that would have been made so far in dry-run
+// mode.
+func (j *journal) plan() []string {
+	j.Lock()
+	defer j.Unlock()
+	return append([]string{}, j.planned...)
+}
+
+// comment returns the comment of an iptables rule: "docker", followed by
+// the id of the container the rule is for, if any. A rule keeps the comment
+// it was added with until it is deleted, even if the container was released
+// in between, so that the deletion matches it.
+func (j *journal) comment(args []string) string {
+	j.Lock()
+	defer j.Unlock()
+
+	r := parseRule(args)
+	if r.action != "-A" && r.action != "-I" {
+		if c, exists := j.rules[r.key]; exists {
+			return c
+		}
+	}
+	c := "docker"
+	for _, arg := range args {
+		if id, exists := j.owners[argIP(arg)]; exists {
+			c += ":" + id
+			break
+		}
+	}
+	return c
+}
+
+// owns returns whether an iptables rule about to be deleted belongs to the
+// daemon: it is in a chain of its own, or it was added by the daemon, in this
+// run or in a previous one recorded in the journal. The other rules of the
+// built-in chains were added by someone else and must be left alone.
+func (j *journal) owns(args []string) bool {
+	r := parseRule(args)
+	if !builtinChains[r.chain] {
+		return true
+	}
+	j.Lock()
+	defer j.Unlock()
+	_, exists := j.rules[r.key]
+	return exists
+}
+
+// track keeps up with the iptables rules added and deleted.
+func (j *journal) track(args []string) {
+	r := parseRule(args)
+	switch r.action {
+	case "-A", "-I":
+		if j.rules == nil {
+			j.rules = make(map[string]string)
+		}
+		j.rules[r.key] = r.comment
+	case "-D":
+		delete(j.rules, r.key)
+	}
+}
+
+// learn tracks the iptables rules added by the given changes, such as the
+// pending changes of a previous run.
+func (j *journal) learn(changes []*journalEntry) {
+	j.Lock()
+	defer j.Unlock()
+	for _, e := range changes {
+		if (e.Command == "iptables" || e.Command == "ip6tables") && e.Error == "" {
+			j.track(e.Args)
+		}
+	}
+}
+
+var builtinChains = map[string]bool{
+	"INPUT":       true,
+	"OUTPUT":      true,
+	"FORWARD":     true,
+	"PREROUTING":  true,
+	"POSTROUTING": true,
+}
+
+type rule struct {
+	key     string // table, chain and specification, without the comment
+	action  string // in its short form
+	chain   string
+	comment string
+}
+
+func parseRule(args []string) rule {
+	var (
+		r    rule
+		spec = make([]string, 0, len(args))
+	)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-A", "--append", "-I", "--insert", "-D", "--delete", "-C", "--check":
+			r.action = "-" + strings.ToUpper(strings.TrimLeft(args[i], "-")[:1])
+			if i+1 < len(args) {
+				r.chain = args[i+1]
+				spec = append(spec, r.chain)
+				i++
+			}
+			// Drop the rule number
+			if r.action == "-I" && i+1 < len(args) && isNumber(args[i+1]) {
+				i++
+			}
+		case "-m":
+			if i+3 < len(args) && args[i+1] == "comment" && args[i+2] == "--comment" {
+				r.comment = args[i+3]
+				i += 3
+				continue
+			}
+			spec = append(spec, args[i])
+		default:
+			spec = append(spec, args[i])
+		}
+	}
+	r.key = strings.Join(spec, " ")
+	return r
+}
+
+// argIP strips an argument such as "172.17.0.2/32" or "172.17.0.2:80" down
+// to its address.
+func argIP(arg string) string {
+	if i := strings.IndexAny(arg, "/:"); i != -1 && strings.Count(arg, ":") <= 1 {
+		arg = arg[:i]
+	}
+	return arg
+}
+
+// iptablesAction returns the index of the action of an iptables command,
+// or -1.
+func iptablesAction(args []string) int {
+	for i, arg := range args {
+		switch arg {
+		case "-A", "-I", "-N", "-D", "-F", "-X", "--new", "--delete":
+			return i
+		}
+	}
+	return -1
+}
+
+// undoArgs returns the arguments of the command undoing a change, or nil if
+// the command changes nothing or isn't undone on its own.
+func undoArgs(cmd string, args []string) []string {
+	undo := append([]string{}, args...)
+	switch cmd {
+	case "iptables", "ip6tables":
+		i := iptablesAction(args)
+		if i == -1 || i+1 >= len(args) {
+			return nil
+		}
+		switch args[i] {
+		case "-A":
+			undo[i] = "-D"
+		case "-I":
+			undo[i] = "-D"
+			// Drop the rule number
+			if i+2 < len(args) && isNumber(args[i+2]) {
+				undo = append(undo[:i+2], undo[i+3:]...)
+			}
+		case "-N", "--new":
+			undo[i] = "-X"
+			undo = undo[:i+2]
+		default:
+			return nil
+		}
+	case "ip":
+		if len(args) < 2 || (args[1] != "add" && args[1] != "replace") {
+			return nil
+		}
+		undo[1] = "del"
+	case "tc":
+		// Classes and filters go along with the qdiscs they are attached to
+		if len(args) < 4 || args[0] != "qdisc" || (args[1] != "add" && args[1] != "replace") {
+			return nil
+		}
+		for _, arg := range args[2:] {
+			if arg == "root" || arg == "ingress" {
+				return []string{"qdisc", "del", "dev", args[3], arg}
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+	return undo
+}
+
+func isRemoval(cmd string, args []string) bool {
+	switch cmd {
+	case "iptables", "ip6tables":
+		i := iptablesAction(args)
+		return i != -1 && undoArgs(cmd, args) == nil
+	case "ip", "tc":
+		for _, arg := range args {
+			if arg == "del" || arg == "delete" || arg == "flush" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isNumber(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func readJournal(path string) ([]*journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries []*journalEntry
+		scanner = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		e := &journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			// The last entry might have been cut short by a crash
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// pendingChanges returns the successful changes of the journal that weren't
+// undone later on, in the order they were made.
+func pendingChanges(entries []*journalEntry) []*journalEntry {
+	var pending []*journalEntry
+	for _, e := range entries {
+		if e.Error != "" {
+			continue
+		}
+		if undoArgs(e.Command, e.Args) != nil {
+			pending = append(pending, e)
+			continue
+		}
+		// Cancel the latest change this command undoes
+		args := strings.Join(e.Args, " ")
+		for i := len(pending) - 1; i >= 0; i-- {
+			if p := pending[i]; p.Command == e.Command && strings.Join(undoArgs(p.Command, p.Args), " ") == args {
+				pending = append(pending[:i], pending[i+1:]...)
+				break
+			}
+		}
+	}
+	return pending
+}
+
+// pruneJournal rewrites the journal with only its pending changes.
+func pruneJournal(path string) error {
+	entries, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range pendingChanges(entries) {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// rollbackJournal undoes the pending changes of the journal at path and
+// empties it.
+func (d *Driver) rollbackJournal(path string) error {
+	entries, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	pending := pendingChanges(entries)
+	d.changes.learn(pending)
+	d.undoChanges(pending)
+	if d.dryRun {
+		return nil
+	}
+	return os.Truncate(path, 0)
+}
+
+// undoChanges undoes the given changes, most recent first. Failures, such as
+// for changes already reverted by hand, are ignored.
+func (d *Driver) undoChanges(changes []*journalEntry) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		var (
+			e    = changes[i]
+			args = undoArgs(e.Command, e.Args)
+			err  error
+		)
+		if e.Command == "iptables" || e.Command == "ip6tables" {
+			if a := iptablesAction(args); args[a] == "-X" {
+				// Chains have to be empty to be deleted
+				d.firewall.Raw(e.Command == "ip6tables", append(append([]string{}, args[:a]...), "-F", args[a+1])...)
+			}
+			_, err = d.firewall.Raw(e.Command == "ip6tables", args...)
+		} else if e.Command == "ip" {
+			err = d.runIp(args...)
+		} else {
+			err = d.runCommand(e.Command, args...)
+		}
+		if err != nil {
+			log.Debugf("Unable to undo %s %s: %s", e.Command, strings.Join(e.Args, " "), err)
+		}
+	}
+}
+
+// Rollback undoes the changes to the host networking recorded in the journal
+// of the daemon whose root directory is given by the Root variable, and
+// whose instance is given by Instance if several daemons share it. It is
+// meant to clean up after a daemon that isn't running anymore. With DryRun
+// set, the changes undoing the journal are only logged.
+func Rollback(job *engine.Job) engine.Status {
+	root := job.Getenv("Root")
+	if root == "" {
+		return job.Errorf("No daemon root directory given")
+	}
+	d := newDriver(&Config{DryRun: job.GetenvBool("DryRun")})
+	if path := job.Getenv("Helper"); path != "" && !d.dryRun {
+		h, err := startHelper(path)
+		if err != nil {
+			return job.Error(err)
+		}
+		d.setHelper(h)
+		defer func() {
+			h.stop()
+			d.setHelper(nil)
+		}()
+	}
+
+	if err := d.rollbackJournal(statePath(root, journalName, job.Getenv("Instance"))); err != nil && !os.IsNotExist(err) {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,183 @@
+package bridge
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUndoArgs(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		args string
+		undo string
+	}{
+		{"iptables", "-t nat -A DOCKER -p tcp --dport 80 -j DNAT --to-destination 172.17.0.2:80", "-t nat -D DOCKER -p tcp --dport 80 -j DNAT --to-destination 172.17.0.2:80"},
+		{"iptables", "-I FORWARD 1 -s 172.17.0.2 -j DOCKER-EGRESS", "-D FORWARD -s 172.17.0.2 -j DOCKER-EGRESS"},
+		{"ip6tables", "-t nat -N DOCKER", "-t nat -X DOCKER"},
+		{"iptables", "-t nat -D DOCKER -j RETURN", ""},
+		{"iptables", "-t nat -v -S DOCKER", ""},
+		{"ip", "rule add fwmark 10 table 10", "rule del fwmark 10 table 10"},
+		{"ip", "route replace default via 10.0.0.1 table 10", "route del default via 10.0.0.1 table 10"},
+		{"ip", "route flush table 10", ""},
+		{"tc", "qdisc replace dev docker0 root handle 1: htb", "qdisc del dev docker0 root"},
+		{"tc", "qdisc add dev docker0 ingress", "qdisc del dev docker0 ingress"},
+		{"tc", "class add dev docker0 parent 1: classid 1:2 htb rate 1mbit", ""},
+	}
+	for _, test := range tests {
+		undo := strings.Join(undoArgs(test.cmd, strings.Fields(test.args)), " ")
+		if undo != test.undo {
+			t.Fatalf("Expected %s %s to be undone by %q, got %q", test.cmd, test.args, test.undo, undo)
+		}
+	}
+}
+
+func TestJournalRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j := &journal{owners: make(map[string]string)}
+	if err := j.open(path.Join(dir, journalName)); err != nil {
+		t.Fatal(err)
+	}
+	defer j.f.Close()
+
+	j.own(net.ParseIP("172.17.0.2"), "abc")
+	j.record("iptables", strings.Fields("-t nat -A DOCKER -p tcp --dport 80 -j DNAT --to-destination 172.17.0.2:80"), nil)
+	j.record("iptables", strings.Fields("-t nat -L DOCKER"), nil)
+	j.record("ip", strings.Fields("rule add fwmark 10 table 10"), nil)
+	j.disown(net.ParseIP("172.17.0.2"))
+	j.record("iptables", strings.Fields("-A FORWARD -s 172.17.0.2 -j ACCEPT"), nil)
+
+	entries, err := readJournal(path.Join(dir, journalName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected the listing to be left out of the journal, got %d entries", len(entries))
+	}
+	if entries[0].Container != "abc" || entries[1].Container != "" || entries[2].Container != "" {
+		t.Fatalf("Unexpected owners %q %q %q", entries[0].Container, entries[1].Container, entries[2].Container)
+	}
+}
+
+func TestPendingChanges(t *testing.T) {
+	entry := func(cmd, args, err string) *journalEntry {
+		return &journalEntry{Command: cmd, Args: strings.Fields(args), Error: err}
+	}
+	var (
+		chain   = entry("iptables", "-t nat -N DOCKER", "")
+		mapping = entry("iptables", "-t nat -A DOCKER -p tcp --dport 80 -j DNAT --to-destination 172.17.0.2:80", "")
+		rule    = entry("ip", "rule add fwmark 10 table 10", "")
+	)
+	pending := pendingChanges([]*journalEntry{
+		chain,
+		entry("iptables", "-I FORWARD 1 -s 172.17.0.3 -j DROP", ""),
+		mapping,
+		entry("ip", "rule add fwmark 11 table 11", "exit status 2"),
+		entry("iptables", "-D FORWARD -s 172.17.0.3 -j DROP", ""),
+		rule,
+	})
+	if expected := []*journalEntry{chain, mapping, rule}; !reflect.DeepEqual(pending, expected) {
+		t.Fatalf("Expected the pending changes to be %v, got %v", expected, pending)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	d := newDriver(&Config{DryRun: true})
+
+	if err := d.runIp("link", "set", "docker-dry0", "up"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.setSysctl("/proc/sys/net/ipv4/ip_forward", "1"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ip link set docker-dry0 up", "sysctl -w net/ipv4/ip_forward=1"}
+	if plan := d.changes.plan(); !reflect.DeepEqual(plan, expected) {
+		t.Fatalf("Expected the plan to be %v, got %v", expected, plan)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	d := newDriver(&Config{})
+	j := d.changes
+	tx := j.begin()
+	j.record("ip", strings.Fields("link add docker-tx0 type bridge"), nil)
+	j.record("iptables", strings.Fields("-t nat -A POSTROUTING -s 10.0.0.0/8 ! -o docker-tx0 -j MASQUERADE"), nil)
+	j.record("iptables", strings.Fields("-I FORWARD -o docker-tx0 -j ACCEPT"), errors.New("exit status 1"))
+	if len(tx.changes) != 3 {
+		t.Fatalf("Expected 3 changes in the transaction, got %d", len(tx.changes))
+	}
+
+	// Undo the changes in dry-run mode to find out what is run
+	dryRunDriver(d)
+	d.rollback(tx)
+
+	expected := []string{
+		"iptables -t nat -D POSTROUTING -s 10.0.0.0/8 ! -o docker-tx0 -j MASQUERADE",
+		"ip link del docker-tx0 type bridge",
+	}
+	if plan := d.changes.plan(); !reflect.DeepEqual(plan, expected) {
+		t.Fatalf("Expected the rollback to run %v, got %v", expected, plan)
+	}
+	if j.tx != nil {
+		t.Fatal("Expected the transaction to be closed")
+	}
+}
+
+func TestRuleComments(t *testing.T) {
+	j := &journal{owners: make(map[string]string)}
+	j.own(net.ParseIP("172.17.0.2"), "abc")
+
+	add := strings.Fields("-t nat -I POSTROUTING 1 -s 172.17.0.2 ! -o docker0 -j MASQUERADE")
+	if c := j.comment(add); c != "docker:abc" {
+		t.Fatalf("Expected the rule to be owned by the container, got %q", c)
+	}
+	if c := j.comment(strings.Fields("-I FORWARD -i docker0 -j ACCEPT")); c != "docker" {
+		t.Fatalf("Expected the rule to be owned by the daemon only, got %q", c)
+	}
+
+	j.record("iptables", strings.Fields("-t nat -I POSTROUTING 1 -s 172.17.0.2 ! -o docker0 -m comment --comment docker:abc -j MASQUERADE"), nil)
+
+	// The container is gone by the time the rule is deleted
+	j.disown(net.ParseIP("172.17.0.2"))
+	del := strings.Fields("-t nat -D POSTROUTING -s 172.17.0.2 ! -o docker0 -j MASQUERADE")
+	if c := j.comment(del); c != "docker:abc" {
+		t.Fatalf("Expected the rule to keep its comment, got %q", c)
+	}
+	j.record("iptables", strings.Fields("-t nat -D POSTROUTING -s 172.17.0.2 ! -o docker0 -m comment --comment docker:abc -j MASQUERADE"), nil)
+	if c := j.comment(del); c != "docker" {
+		t.Fatalf("Expected the comment to be forgotten once the rule is deleted, got %q", c)
+	}
+}
+
+func TestRuleOwnership(t *testing.T) {
+	j := &journal{owners: make(map[string]string)}
+	del := strings.Fields("-t nat -D POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE")
+	if j.owns(del) {
+		t.Fatal("Expected a rule added by someone else not to be deleted")
+	}
+	if !j.owns(strings.Fields("-D DOCKER -p tcp -d 0/0 --dport 80 -j DNAT --to-destination 172.17.0.2:80")) {
+		t.Fatal("Expected the rules of the docker chains to be deleted")
+	}
+
+	j.record("iptables", strings.Fields("-t nat -A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -m comment --comment docker -j MASQUERADE"), nil)
+	if !j.owns(del) {
+		t.Fatal("Expected a rule added by docker to be deleted")
+	}
+
+	// The rules added by a previous run are recorded in its journal
+	j = &journal{owners: make(map[string]string)}
+	j.learn([]*journalEntry{{Command: "iptables", Args: strings.Fields("-t nat -A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE")}})
+	if !j.owns(del) {
+		t.Fatal("Expected a rule added by a previous run to be deleted")
+	}
+}
@@ -0,0 +1,40 @@
+package bridge
+
+import (
+	"net"
+)
+
+// linkLocalNetwork covers the cloud metadata services (169.254.169.254 on
+// EC2, GCE, OpenStack and Azure) as well as any other link-local host.
+var linkLocalNetwork = &net.IPNet{IP: net.IPv4(169, 254, 0, 0), Mask: net.CIDRMask(16, 32)}
+
+func (d *Driver) linkLocalBlockArgs() []string {
+	return []string{"FORWARD", "-i", d.bridgeIface, "-d", linkLocalNetwork.String(), "-j", "DROP"}
+}
+
+func (d *Driver) linkLocalExemptArgs(ip net.IP) []string {
+	return []string{"FORWARD", "-i", d.bridgeIface, "-s", ip.String(), "-d", linkLocalNetwork.String(), "-j", "ACCEPT"}
+}
+
+// setupLinkLocalBlock drops all container traffic to link-local destinations,
+// or removes a previously installed rule if block is false.
+func (d *Driver) setupLinkLocalBlock(block bool) error {
+	args := d.linkLocalBlockArgs()
+	if !block {
+		d.firewall.Raw(false, append([]string{"-D"}, args...)...)
+		return nil
+	}
+	if d.firewall.Exists(false, args...) {
+		return nil
+	}
+	return d.execRule(false, append([]string{"-I"}, args...)...)
+}
+
+// allowLinkLocal exempts a single container from the link-local block.
+func (d *Driver) allowLinkLocal(ip net.IP) error {
+	return d.execRule(false, append([]string{"-I"}, d.linkLocalExemptArgs(ip)...)...)
+}
+
+func (d *Driver) removeLinkLocalExemption(ip net.IP) {
+	d.firewall.Raw(false, append([]string{"-D"}, d.linkLocalExemptArgs(ip)...)...)
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Logger receives the log messages of the driver, along with key/value
+// fields giving their context, such as the container concerned. It lets
+// embedders route the network logs into their own logging pipeline.
+type Logger interface {
+	Log(level logrus.Level, fields logrus.Fields, msg string)
+}
+
+// logrusLogger logs through the standard logrus logger, like the rest of
+// the daemon.
+type logrusLogger struct{}
+
+func (logrusLogger) Log(level logrus.Level, fields logrus.Fields, msg string) {
+	entry := logrus.WithFields(fields)
+	switch level {
+	case logrus.DebugLevel:
+		entry.Debug(msg)
+	case logrus.InfoLevel:
+		entry.Info(msg)
+	case logrus.WarnLevel:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
+
+var logger Logger = logrusLogger{}
+
+// SetLogger makes the driver log through l. It must be called before the
+// driver is initialized.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// fieldLogger formats the messages of the driver and hands them to the
+// logger, along with its fields.
+type fieldLogger struct {
+	fields logrus.Fields
+}
+
+var log = &fieldLogger{}
+
+// WithField returns a logger adding the given key/value field to the
+// messages.
+func (l *fieldLogger) WithField(key string, value interface{}) *fieldLogger {
+	fields := make(logrus.Fields, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &fieldLogger{fields: fields}
+}
+
+func (l *fieldLogger) logf(level logrus.Level, format string, args ...interface{}) {
+	logger.Log(level, l.fields, fmt.Sprintf(format, args...))
+}
+
+func (l *fieldLogger) Debugf(format string, args ...interface{}) {
+	l.logf(logrus.DebugLevel, format, args...)
+}
+
+func (l *fieldLogger) Infof(format string, args ...interface{}) {
+	l.logf(logrus.InfoLevel, format, args...)
+}
+
+func (l *fieldLogger) Warnf(format string, args ...interface{}) {
+	l.logf(logrus.WarnLevel, format, args...)
+}
+
+func (l *fieldLogger) Errorf(format string, args ...interface{}) {
+	l.logf(logrus.ErrorLevel, format, args...)
+}
@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type recordingLogger struct {
+	levels   []logrus.Level
+	fields   []logrus.Fields
+	messages []string
+}
+
+func (r *recordingLogger) Log(level logrus.Level, fields logrus.Fields, msg string) {
+	r.levels = append(r.levels, level)
+	r.fields = append(r.fields, fields)
+	r.messages = append(r.messages, msg)
+}
+
+func TestSetLogger(t *testing.T) {
+	r := &recordingLogger{}
+	SetLogger(r)
+	defer SetLogger(logrusLogger{})
+
+	log.Infof("bridge %s", "docker0")
+	l := log.WithField("container", "abc")
+	l.WithField("port", 80).Errorf("failed")
+	l.Debugf("done")
+
+	if len(r.messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(r.messages))
+	}
+	if r.levels[0] != logrus.InfoLevel || r.messages[0] != "bridge docker0" || len(r.fields[0]) != 0 {
+		t.Fatalf("Unexpected message %v %q %v", r.levels[0], r.messages[0], r.fields[0])
+	}
+	if r.levels[1] != logrus.ErrorLevel || r.fields[1]["container"] != "abc" || r.fields[1]["port"] != 80 {
+		t.Fatalf("Unexpected message %v %q %v", r.levels[1], r.messages[1], r.fields[1])
+	}
+	if len(r.fields[2]) != 1 {
+		t.Fatalf("Expected fields not to leak between loggers, got %v", r.fields[2])
+	}
+}
@@ -0,0 +1,44 @@
+package bridge
+
+import (
+	"path"
+
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// The ports published on a loopback alias, such as 127.10.0.2:80, let the
+// developers run many containers locally on the same port. The local
+// connections to an alias go through the DNAT rule of its mapping, like
+// those to the other addresses of the host: the kernel routes the loopback
+// traffic out to the bridge once route_localnet is set on it, and its
+// source is masqueraded for the replies of the containers to come back.
+// Since route_localnet would let the containers reach the services of the
+// host listening on the loopback, the new connections from the bridge to
+// the loopback are dropped.
+
+// loopbackNatArgs returns the rules routing the local connections to the
+// loopback aliases into the containers.
+func (d *Driver) loopbackNatArgs() [][]string {
+	loopback := iptables.LoopbackCidr(false)
+	return [][]string{
+		{"POSTROUTING", "-t", "nat", "-s", loopback, "-o", d.bridgeIface, "-j", "MASQUERADE"},
+		{"INPUT", "-i", d.bridgeIface, "-d", loopback, "-m", "conntrack", "!", "--ctstate", "RELATED,ESTABLISHED", "-j", "DROP"},
+	}
+}
+
+// setupLoopbackNat sets the bridge up for the ports published on the
+// loopback aliases, once the first of them is.
+func (d *Driver) setupLoopbackNat() error {
+	if err := d.setSysctl(path.Join("/proc/sys/net/ipv4/conf", d.bridgeIface, "route_localnet"), "1"); err != nil {
+		return err
+	}
+	for _, args := range d.loopbackNatArgs() {
+		if d.firewall.Exists(false, args...) {
+			continue
+		}
+		if err := d.execRule(false, append([]string{"-I"}, args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/iptables"
+)
+
+func TestLoopbackAlias(t *testing.T) {
+	d := &Driver{bridgeIface: "docker0"}
+	dryRunDriver(d)
+
+	if err := d.setupLoopbackNat(); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := d.firewall.NewChain(false, "DOCKER", "docker0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Forward(iptables.Add, net.ParseIP("127.10.0.2"), 80, "tcp", "172.17.0.2", 80); err != nil {
+		t.Fatal(err)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, change := range []string{
+		"sysctl -w net/ipv4/conf/docker0/route_localnet=1",
+		"iptables -I POSTROUTING -t nat -s 127.0.0.0/8 -o docker0 -j MASQUERADE",
+		"iptables -I INPUT -i docker0 -d 127.0.0.0/8 -m conntrack ! --ctstate RELATED,ESTABLISHED -j DROP",
+		"iptables -t nat -A DOCKER -p tcp -d 127.10.0.2 --dport 80 ! -i docker0 -j DNAT --to-destination 172.17.0.2:80",
+		"iptables -t nat -A OUTPUT -p tcp -d 127.10.0.2 --dport 80 -j DOCKER",
+	} {
+		if !strings.Contains(plan, change) {
+			t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+		}
+	}
+
+	// The loopback itself is left to the userland proxy
+	d.changes.planned = nil
+	if err := chain.Forward(iptables.Add, net.ParseIP("127.0.0.1"), 80, "tcp", "172.17.0.2", 80); err != nil {
+		t.Fatal(err)
+	}
+	if plan := strings.Join(d.changes.plan(), "\n"); strings.Contains(plan, "OUTPUT") {
+		t.Fatalf("Expected no jump for the loopback, got:\n%s", plan)
+	}
+}
@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+
+	"github.com/docker/docker/engine"
+)
+
+// NetworkManager is the networking of the containers, for the tools
+// embedding it outside of the daemon. The options and the settings returned
+// are the variables of the jobs of the driver: allocate_interface,
+// allocate_port and network_stats.
+type NetworkManager interface {
+	// Allocate gives the container an interface on the bridge and returns
+	// its settings: IP, Mask, Gateway, MacAddress, Bridge and IPPrefixLen.
+	Allocate(id string, options *engine.Env) (*engine.Env, error)
+	// Release releases the interface of the container along with its port
+	// mappings.
+	Release(id string) error
+	// MapPort publishes the ContainerPort of the container on the host and
+	// returns the host address it was published on.
+	MapPort(id string, options *engine.Env) (net.Addr, error)
+	// PortMappings returns the ports the container is published on.
+	PortMappings(id string) ([]Nat, error)
+	// Stats returns the traffic counters of the container, and the latency
+	// of the connections to its published ports.
+	Stats(id string) (*engine.Env, error)
+	// Close tears the network down. With cleanup, the changes made to the
+	// host are undone.
+	Close(cleanup bool) error
+}
+
+type networkManager struct {
+	driver *Driver
+	eng    *engine.Engine
+}
+
+// NewNetworkManager sets up a network as given by config, with the jobs of
+// its driver on an engine of its own.
+func NewNetworkManager(config *Config) (NetworkManager, error) {
+	d, err := NewDriver(config)
+	if err != nil {
+		return nil, err
+	}
+	eng := engine.New()
+	eng.Logging = false
+	if err := d.Install(eng); err != nil {
+		d.Close(false)
+		return nil, err
+	}
+	return &networkManager{driver: d, eng: eng}, nil
+}
+
+// run runs a job of the driver and returns its output.
+func (m *networkManager) run(name, id string, options *engine.Env) (*engine.Env, error) {
+	job := m.eng.Job(name, id)
+	if options != nil {
+		job.Env().Init(options)
+	}
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := job.Run(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (m *networkManager) Allocate(id string, options *engine.Env) (*engine.Env, error) {
+	return m.run("allocate_interface", id, options)
+}
+
+func (m *networkManager) Release(id string) error {
+	return m.eng.Job("release_interface", id).Run()
+}
+
+func (m *networkManager) MapPort(id string, options *engine.Env) (net.Addr, error) {
+	out, err := m.run("allocate_port", id, options)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(out.Get("HostIP"))
+	if options.Get("Proto") == "udp" {
+		return &net.UDPAddr{IP: ip, Port: out.GetInt("HostPort")}, nil
+	}
+	return &net.TCPAddr{IP: ip, Port: out.GetInt("HostPort")}, nil
+}
+
+func (m *networkManager) PortMappings(id string) ([]Nat, error) {
+	var out bytes.Buffer
+	job := m.eng.Job("port_mappings", id)
+	job.Stdout.Add(&out)
+	if err := job.Run(); err != nil {
+		return nil, err
+	}
+	var nats []Nat
+	if err := json.Unmarshal(out.Bytes(), &nats); err != nil {
+		return nil, err
+	}
+	return nats, nil
+}
+
+func (m *networkManager) Stats(id string) (*engine.Env, error) {
+	return m.run("network_stats", id, nil)
+}
+
+func (m *networkManager) Close(cleanup bool) error {
+	return m.driver.Close(cleanup)
+}
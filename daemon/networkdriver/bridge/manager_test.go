@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestNetworkManager(t *testing.T) {
+	m, err := NewNetworkManager(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := m.Allocate("manager_container", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if net.ParseIP(settings.Get("IP")) == nil || settings.Get("Bridge") != DefaultNetworkBridge {
+		t.Fatalf("Unexpected interface settings %v", settings)
+	}
+
+	options := &engine.Env{}
+	options.Set("HostIP", "127.0.0.1")
+	options.Set("Proto", "udp")
+	options.SetInt("ContainerPort", 53)
+	host, err := m.MapPort("manager_container", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := host.(*net.UDPAddr); !ok || !addr.IP.Equal(net.ParseIP("127.0.0.1")) || addr.Port == 0 {
+		t.Fatalf("Unexpected host address %v", host)
+	}
+
+	nats, err := m.PortMappings("manager_container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Nat{Proto: "udp", HostIP: net.ParseIP("127.0.0.1"), HostPort: host.(*net.UDPAddr).Port, ContainerIP: net.ParseIP(settings.Get("IP")), ContainerPort: 53}
+	if len(nats) != 1 || nats[0].Proto != expected.Proto || !nats[0].HostIP.Equal(expected.HostIP) || nats[0].HostPort != expected.HostPort || !nats[0].ContainerIP.Equal(expected.ContainerIP) || nats[0].ContainerPort != expected.ContainerPort {
+		t.Fatalf("Expected the port mapping %+v, got %+v", expected, nats)
+	}
+	if _, err := m.PortMappings("unknown_container"); err == nil {
+		t.Fatal("Expected the port mappings of an unknown container to fail")
+	}
+
+	if _, err := m.Stats("manager_container"); err == nil {
+		t.Fatal("Expected the stats to require iptables")
+	}
+	if err := m.Release("manager_container"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Release("manager_container"); err == nil {
+		t.Fatal("Expected the interface to be released already")
+	}
+	if err := m.Close(false); err != nil {
+		t.Fatal(err)
+	}
+}
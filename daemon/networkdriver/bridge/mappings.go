@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+)
+
+// The addresses and port mappings of the containers are kept in a file of
+// the root of the daemon, rewritten whenever they change, for the agents of
+// the host to read them even when the API is unreachable.
+const mappingsFileName = "network-mappings.json"
+
+// interfaceAllocation is what was allocated to the interface of a
+// container.
+type interfaceAllocation struct {
+	IP         string
+	MacAddress string                    `json:",omitempty"`
+	Mappings   []portmapper.MappingState `json:",omitempty"`
+}
+
+// allocationsState is the content of the mappings file.
+type allocationsState struct {
+	Updated    time.Time
+	Bridge     string
+	Network    string
+	Interfaces map[string]*interfaceAllocation // by container id
+}
+
+func (d *Driver) allocationsState() *allocationsState {
+	state := &allocationsState{
+		Updated:    time.Now().UTC(),
+		Bridge:     d.bridgeIface,
+		Interfaces: make(map[string]*interfaceAllocation),
+	}
+	if d.bridgeNetwork != nil {
+		state.Network = d.bridgeNetwork.String()
+	}
+	byIP := make(map[string]*interfaceAllocation)
+	for id, iface := range d.currentInterfaces.All() {
+		a := &interfaceAllocation{IP: iface.IP.String()}
+		if jobs := d.saved.jobs[id]; len(jobs) > 0 && jobs[0].Name == "allocate_interface" {
+			a.MacAddress = jobs[0].Env["RequestedMac"]
+		}
+		state.Interfaces[id] = a
+		byIP[a.IP] = a
+	}
+	for _, m := range portmapper.Mappings() {
+		host, _, err := net.SplitHostPort(m.Container)
+		if err != nil {
+			continue
+		}
+		if a := byIP[host]; a != nil {
+			a.Mappings = append(a.Mappings, m)
+		}
+	}
+	return state
+}
+
+// writeMappingsFile rewrites the mappings file, if any, with d.saved locked.
+func (d *Driver) writeMappingsFile() {
+	if d.mappingsPath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(d.allocationsState(), "", "  ")
+	if err != nil {
+		return
+	}
+	// Renamed into place, for the readers to never see half of it
+	if err := ioutil.WriteFile(d.mappingsPath+".tmp", data, 0644); err != nil {
+		log.Errorf("Unable to write the network mappings: %s", err)
+		return
+	}
+	if err := os.Rename(d.mappingsPath+".tmp", d.mappingsPath); err != nil {
+		log.Errorf("Unable to write the network mappings: %s", err)
+	}
+}
+
+func (d *Driver) syncMappingsFile() {
+	d.saved.Lock()
+	defer d.saved.Unlock()
+	d.writeMappingsFile()
+}
@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func readMappingsFile(t *testing.T, path string) *allocationsState {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state allocationsState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return &state
+}
+
+func TestMappingsFile(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	dir, err := ioutil.TempDir("", "docker-mappings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := initTestDriver(t, eng)
+	d.mappingsPath = filepath.Join(dir, mappingsFileName)
+	if res := d.Allocate(eng.Job("allocate_interface", "mappings_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	ip := d.currentInterfaces.Get("mappings_container").IP.String()
+
+	port := strconv.Itoa(findFreePort(t))
+	job := eng.Job("allocate_port", "mappings_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", port)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	state := readMappingsFile(t, d.mappingsPath)
+	a := state.Interfaces["mappings_container"]
+	if a == nil || a.IP != ip || a.MacAddress == "" {
+		t.Fatalf("Expected the addresses of the interface, got %+v", a)
+	}
+	if len(a.Mappings) != 1 || a.Mappings[0].Container != ip+":"+port || a.Mappings[0].Proto != "tcp" {
+		t.Fatalf("Expected the port mapping to %s:%s, got %+v", ip, port, a.Mappings)
+	}
+
+	if res := d.Release(eng.Job("release_interface", "mappings_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+	if state := readMappingsFile(t, d.mappingsPath); len(state.Interfaces) != 0 {
+		t.Fatalf("Expected the released interface to be removed, got %+v", state.Interfaces)
+	}
+	if _, err := os.Stat(d.mappingsPath + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be renamed, got %v", err)
+	}
+}
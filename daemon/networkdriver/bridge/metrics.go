@@ -0,0 +1,213 @@
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/iptables"
+	"github.com/docker/docker/pkg/proxy"
+)
+
+// metricSample is a value of a metric, along with its labels given as
+// alternating names and values. Suffix distinguishes the series of
+// summaries, such as "_sum" and "_count".
+type metricSample struct {
+	Labels []string
+	Value  float64
+	Suffix string
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric renders a metric in the Prometheus text exposition format.
+func writeMetric(w io.Writer, name, kind, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		var labels []string
+		for i := 0; i+1 < len(s.Labels); i += 2 {
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, s.Labels[i], labelEscaper.Replace(s.Labels[i+1])))
+		}
+		series := name + s.Suffix
+		if len(labels) > 0 {
+			series += "{" + strings.Join(labels, ",") + "}"
+		}
+		fmt.Fprintf(w, "%s %s\n", series, strconv.FormatFloat(s.Value, 'g', -1, 64))
+	}
+}
+
+// ruleCounters splits a rule of an `iptables -v -S` listing into its
+// options, negated ones being prefixed with "!", and its counters.
+func ruleCounters(fields []string) (opts map[string]string, packets, octets uint64, ok bool) {
+	opts = make(map[string]string)
+	negate := false
+	for i := 0; i < len(fields); i++ {
+		switch {
+		case fields[i] == "!":
+			negate = true
+			continue
+		case fields[i] == "-c" && i+2 < len(fields):
+			p, err1 := strconv.ParseUint(fields[i+1], 10, 64)
+			b, err2 := strconv.ParseUint(fields[i+2], 10, 64)
+			packets, octets, ok = p, b, err1 == nil && err2 == nil
+			i += 2
+		case strings.HasPrefix(fields[i], "-") && i+1 < len(fields):
+			name := fields[i]
+			if negate {
+				name = "!" + name
+			}
+			opts[name] = fields[i+1]
+			i++
+		}
+		negate = false
+	}
+	return
+}
+
+// mappingCounters reads the counters of the port mappings out of the
+// listings of the nat chain of the driver and of the FORWARD chain. The DNAT rules
+// only see the first packet of each connection, so they count connections,
+// while the FORWARD rules count the traffic to the containers.
+func (d *Driver) mappingCounters(natOutput, forwardOutput string) (connections, received []metricSample) {
+	forwarded := make(map[string]uint64)
+	for _, line := range strings.Split(forwardOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != "FORWARD" {
+			continue
+		}
+		opts, _, octets, ok := ruleCounters(fields[2:])
+		if !ok || opts["-j"] != "ACCEPT" || opts["--dport"] == "" || opts["-o"] != d.bridgeIface {
+			continue
+		}
+		dest := net.JoinHostPort(strings.TrimSuffix(opts["-d"], "/32"), opts["--dport"])
+		forwarded[opts["-p"]+" "+dest] = octets
+	}
+
+	for _, line := range strings.Split(natOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		opts, packets, _, ok := ruleCounters(fields[2:])
+		if !ok || opts["-j"] != "DNAT" {
+			continue
+		}
+		hostIP := strings.TrimSuffix(opts["-d"], "/32")
+		if hostIP == "" || hostIP == "0.0.0.0/0" {
+			hostIP = "0.0.0.0"
+		}
+		labels := []string{
+			"proto", opts["-p"],
+			"host_ip", hostIP,
+			"host_port", opts["--dport"],
+			"container", opts["--to-destination"],
+		}
+		connections = append(connections, metricSample{Labels: labels, Value: float64(packets)})
+		received = append(received, metricSample{Labels: labels, Value: float64(forwarded[opts["-p"]+" "+opts["--to-destination"]])})
+	}
+	return connections, received
+}
+
+// mappingLabels returns the labels of the metrics of the mapping m, those
+// of the counters of its rules.
+func mappingLabels(m portmapper.MappingState) []string {
+	hostIP, hostPort, _ := net.SplitHostPort(m.Host)
+	return []string{
+		"proto", m.Proto,
+		"host_ip", hostIP,
+		"host_port", hostPort,
+		"container", m.Container,
+	}
+}
+
+// histogramSamples returns the series of the histogram h: the cumulative
+// buckets, the sum and the count.
+func histogramSamples(h proxy.Histogram, labels []string) []metricSample {
+	var samples []metricSample
+	for i, count := range h.Cumulative() {
+		le := strconv.FormatFloat(proxy.LatencyBuckets[i], 'g', -1, 64)
+		samples = append(samples, metricSample{Labels: append(labels[:len(labels):len(labels)], "le", le), Value: float64(count), Suffix: "_bucket"})
+	}
+	return append(samples,
+		metricSample{Labels: append(labels[:len(labels):len(labels)], "le", "+Inf"), Value: float64(h.Count), Suffix: "_bucket"},
+		metricSample{Labels: labels, Value: h.Sum, Suffix: "_sum"},
+		metricSample{Labels: labels, Value: float64(h.Count), Suffix: "_count"})
+}
+
+// NetworkMetrics writes the networking metrics in the Prometheus text
+// exposition format: the use of the ip and port pools, the traffic of the
+// port mappings, the userland proxy errors and the latency of their
+// connections, the repairs of the drift and the time spent in iptables.
+func (d *Driver) NetworkMetrics(job *engine.Job) engine.Status {
+	var (
+		buf     = &bytes.Buffer{}
+		network = []string{"network", d.bridgeNetwork.String()}
+	)
+
+	allocated, size := ipallocator.Usage(d.bridgeNetwork)
+	writeMetric(buf, "docker_network_ip_pool_allocated", "gauge", "Container ips allocated on the bridge network.",
+		metricSample{Labels: network, Value: float64(allocated)})
+	writeMetric(buf, "docker_network_ip_pool_size", "gauge", "Container ips the bridge network can hand out.",
+		metricSample{Labels: network, Value: float64(size)})
+
+	var used, total []metricSample
+	for _, proto := range []string{"tcp", "udp"} {
+		allocated, size := portallocator.Usage(proto)
+		used = append(used, metricSample{Labels: []string{"proto", proto}, Value: float64(allocated)})
+		total = append(total, metricSample{Labels: []string{"proto", proto}, Value: float64(size)})
+	}
+	writeMetric(buf, "docker_network_port_pool_allocated", "gauge", "Host ports allocated from the dynamic range, over all host ips.", used...)
+	writeMetric(buf, "docker_network_port_pool_size", "gauge", "Host ports in the dynamic range.", total...)
+
+	if d.iptablesEnabled {
+		natOutput, err := d.firewall.Raw(false, "-t", "nat", "-v", "-S", d.chain)
+		if err != nil {
+			return job.Error(err)
+		}
+		forwardOutput, err := d.firewall.Raw(false, "-v", "-S", "FORWARD")
+		if err != nil {
+			return job.Error(err)
+		}
+		connections, received := d.mappingCounters(string(natOutput), string(forwardOutput))
+		writeMetric(buf, "docker_network_port_mapping_connections_total", "counter", "Connections to the port mappings.", connections...)
+		writeMetric(buf, "docker_network_port_mapping_received_bytes_total", "counter", "Bytes forwarded to the containers by the port mappings.", received...)
+	}
+
+	writeMetric(buf, "docker_network_proxy_errors_total", "counter", "Userland proxies that failed to start.",
+		metricSample{Value: float64(portmapper.ProxyErrors())})
+
+	var connect, firstByte []metricSample
+	for _, m := range portmapper.Mappings() {
+		if m.Latency == nil {
+			continue
+		}
+		labels := mappingLabels(m)
+		connect = append(connect, histogramSamples(m.Latency.Connect, labels)...)
+		firstByte = append(firstByte, histogramSamples(m.Latency.FirstByte, labels)...)
+	}
+	writeMetric(buf, "docker_network_proxy_connect_seconds", "histogram", "Time the userland proxies take to connect to the containers.", connect...)
+	writeMetric(buf, "docker_network_proxy_first_byte_seconds", "histogram", "Time from the connections accepted by the userland proxies to the first byte of the containers.", firstByte...)
+
+	writeMetric(buf, "docker_network_repairs_total", "counter", "Drifts between the network and the kernel repaired.",
+		metricSample{Value: float64(atomic.LoadUint64(&d.repairs))})
+
+	calls, failures, duration := iptables.Stats()
+	writeMetric(buf, "docker_network_iptables_duration_seconds", "summary", "Time spent running iptables.",
+		metricSample{Value: duration.Seconds(), Suffix: "_sum"},
+		metricSample{Value: float64(calls), Suffix: "_count"})
+	writeMetric(buf, "docker_network_iptables_failures_total", "counter", "Failed iptables runs.",
+		metricSample{Value: float64(failures)})
+
+	if _, err := buf.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/proxy"
+)
+
+func TestWriteMetric(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writeMetric(buf, "test_seconds", "summary", "Test durations.",
+		metricSample{Value: 1.5, Suffix: "_sum"},
+		metricSample{Value: 3, Suffix: "_count"})
+	writeMetric(buf, "test_total", "counter", "Test counter.",
+		metricSample{Labels: []string{"proto", "tcp", "name", `a"b`}, Value: 7})
+
+	expected := `# HELP test_seconds Test durations.
+# TYPE test_seconds summary
+test_seconds_sum 1.5
+test_seconds_count 3
+# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{proto="tcp",name="a\"b"} 7
+`
+	if buf.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestMappingCounters(t *testing.T) {
+	d := newDriver(&Config{})
+
+	nat := `-N DOCKER
+-A DOCKER ! -i docker0 -p tcp -m tcp --dport 8080 -c 12 720 -j DNAT --to-destination 172.17.0.2:80
+-A DOCKER -d 127.0.0.1/32 ! -i docker0 -p udp -m udp --dport 53 -c 4 240 -j DNAT --to-destination 172.17.0.3:53
+`
+	forward := `-P FORWARD ACCEPT -c 0 0
+-A FORWARD -d 172.17.0.2/32 ! -i docker0 -o docker0 -p tcp -m tcp --dport 80 -c 40 51200 -j ACCEPT
+-A FORWARD -i docker0 ! -o docker0 -c 100 9000 -j ACCEPT
+`
+	connections, received := d.mappingCounters(nat, forward)
+	if len(connections) != 2 || len(received) != 2 {
+		t.Fatalf("Expected counters for 2 mappings, got %v %v", connections, received)
+	}
+	if connections[0].Value != 12 || received[0].Value != 51200 {
+		t.Fatalf("Unexpected counters %v %v", connections[0], received[0])
+	}
+	if labels := connections[1].Labels; labels[3] != "127.0.0.1" || labels[5] != "53" || labels[7] != "172.17.0.3:53" {
+		t.Fatalf("Unexpected labels %v", labels)
+	}
+	if received[1].Value != 0 {
+		t.Fatalf("Expected no traffic for the udp mapping, got %v", received[1].Value)
+	}
+}
+
+func TestHistogramSamples(t *testing.T) {
+	h := proxy.Histogram{Buckets: make([]uint64, len(proxy.LatencyBuckets)), Count: 3, Sum: 0.7}
+	h.Buckets[0] = 1
+	h.Buckets[7] = 1
+
+	var buf bytes.Buffer
+	writeMetric(&buf, "latency_seconds", "histogram", "Latency.", histogramSamples(h, []string{"proto", "tcp"})...)
+	for _, line := range []string{
+		`latency_seconds_bucket{proto="tcp",le="0.0005"} 1`,
+		`latency_seconds_bucket{proto="tcp",le="0.05"} 1`,
+		`latency_seconds_bucket{proto="tcp",le="0.1"} 2`,
+		`latency_seconds_bucket{proto="tcp",le="10"} 2`,
+		`latency_seconds_bucket{proto="tcp",le="+Inf"} 3`,
+		`latency_seconds_sum{proto="tcp"} 0.7`,
+		`latency_seconds_count{proto="tcp"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Expected %q in:\n%s", line, buf.String())
+		}
+	}
+}
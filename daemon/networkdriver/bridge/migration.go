@@ -0,0 +1,110 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// A container moving to another host hands its network over in steps: the
+// interface is drained on the source, refusing the new connections while
+// the established ones go on, its snapshot is imported on the target to
+// reserve its addresses and host ports there and install their rules, and
+// once the container runs on the target its addresses are announced, for
+// the neighbors to send its traffic there rather than to the source.
+
+// drainArgs returns the rule refusing the new connections to ip.
+func drainArgs(ip net.IP) []string {
+	return []string{"FORWARD", "-d", ip.String(), "-m", "conntrack", "--ctstate", "NEW", "-j", "REJECT"}
+}
+
+// drain refuses the new connections to the addresses of iface, and stops
+// the proxies of its ports unless they are already.
+func (d *Driver) drain(iface *networkInterface) error {
+	ips := append([]net.IP{iface.IP}, iface.SecondaryIPs...)
+	for i, ip := range ips {
+		if err := d.drainIP(ip); err != nil {
+			for _, done := range ips[:i] {
+				d.firewall.Raw(done.To4() == nil, append([]string{"-D"}, drainArgs(done)...)...)
+			}
+			return err
+		}
+	}
+	if !iface.Blackholed {
+		suspendProxies(ips, true)
+	}
+	return nil
+}
+
+func (d *Driver) drainIP(ip net.IP) error {
+	return d.execRule(ip.To4() == nil, append([]string{"-I"}, drainArgs(ip)...)...)
+}
+
+// undrain accepts the new connections to the addresses of iface again.
+func (d *Driver) undrain(iface *networkInterface) {
+	ips := append([]net.IP{iface.IP}, iface.SecondaryIPs...)
+	for _, ip := range ips {
+		d.firewall.Raw(ip.To4() == nil, append([]string{"-D"}, drainArgs(ip)...)...)
+	}
+	if !iface.Blackholed {
+		suspendProxies(ips, false)
+	}
+}
+
+func suspendProxies(ips []net.IP, suspended bool) {
+	for _, ip := range ips {
+		if _, err := portmapper.SetSuspended(ip, suspended); err != nil {
+			log.Infof("Unable to change the proxies of %s: %s", ip, err)
+		}
+	}
+}
+
+// DrainInterface refuses the new connections to a running container while
+// "Drain" is true, the established ones going on, and accepts them again
+// otherwise. The userland proxies of its ports are stopped meanwhile. The
+// container is no longer drained once the daemon restarts.
+func (d *Driver) DrainInterface(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+		enable  = job.GetenvBool("Drain")
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if !d.iptablesEnabled {
+		return job.Errorf("Draining a container requires iptables to be enabled")
+	}
+	if network.Draining == enable {
+		return engine.StatusOK
+	}
+
+	if enable {
+		log.WithField("container", id).Infof("Refusing the new connections to the container")
+		if err := d.drain(network); err != nil {
+			return job.Error(err)
+		}
+	} else {
+		log.WithField("container", id).Infof("Accepting the new connections to the container again")
+		d.undrain(network)
+	}
+	network.Draining = enable
+	return engine.StatusOK
+}
+
+// AnnounceInterface announces the addresses of a running container, for
+// its neighbors to send it the traffic the host it moved from had.
+func (d *Driver) AnnounceInterface(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	network := d.currentInterfaces.Get(id)
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if network.Pid == 0 {
+		return job.Errorf("The network of %s is not attached to a running container", id)
+	}
+	d.announceAddresses(network, network.Pid)
+	return engine.StatusOK
+}
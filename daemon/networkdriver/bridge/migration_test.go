@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestDrainInterface(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := &Driver{
+		iptablesEnabled:   true,
+		currentInterfaces: ifaces{c: make(map[string]*networkInterface)},
+	}
+	d.currentInterfaces.Set("draining", &networkInterface{IP: net.ParseIP("172.17.0.5"), SecondaryIPs: []net.IP{net.ParseIP("172.17.0.6")}})
+	dryRunDriver(d)
+
+	for _, test := range []struct {
+		drain   bool
+		changes []string
+	}{
+		{true, []string{
+			"iptables -I FORWARD -d 172.17.0.5 -m conntrack --ctstate NEW -j REJECT",
+			"iptables -I FORWARD -d 172.17.0.6 -m conntrack --ctstate NEW -j REJECT",
+		}},
+		{false, []string{
+			"iptables -D FORWARD -d 172.17.0.5 -m conntrack --ctstate NEW -j REJECT",
+			"iptables -D FORWARD -d 172.17.0.6 -m conntrack --ctstate NEW -j REJECT",
+		}},
+	} {
+		d.changes.planned = nil
+		job := eng.Job("drain_interface", "draining")
+		job.SetenvBool("Drain", test.drain)
+		if res := d.DrainInterface(job); res != engine.StatusOK {
+			t.Fatal("Failed to drain the interface")
+		}
+		if d.currentInterfaces.Get("draining").Draining != test.drain {
+			t.Fatalf("Expected the interface to be draining: %v", test.drain)
+		}
+		plan := strings.Join(d.changes.plan(), "\n")
+		for _, change := range test.changes {
+			if !strings.Contains(plan, change) {
+				t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+			}
+		}
+	}
+
+	if res := d.AnnounceInterface(eng.Job("announce_interface", "draining")); res == engine.StatusOK {
+		t.Fatal("Expected the interface of a container not running to be refused an announcement")
+	}
+}
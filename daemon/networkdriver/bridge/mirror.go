@@ -0,0 +1,114 @@
+package bridge
+
+import (
+	"github.com/docker/docker/engine"
+)
+
+// A packet analyzer, such as ntop or snort, running in a container can
+// observe the traffic of another container without tooling on the host:
+// tc mirred copies the packets in and out of the host side of the veth pair
+// of the mirrored container to the veth pair of the analyzer, whose
+// interface receives them along with its own traffic. A container the
+// traffic is mirrored to can't have its own traffic mirrored, for the
+// copies not to go round in circles.
+
+// mirrorArgs returns the tc commands copying the packets in and out of the
+// host interface from to the host interface to.
+func mirrorArgs(from, to string) [][]string {
+	action := []string{"u32", "match", "u32", "0", "0", "action", "mirred", "egress", "mirror", "dev", to}
+	return [][]string{
+		{"qdisc", "add", "dev", from, "handle", "ffff:", "ingress"},
+		append([]string{"filter", "add", "dev", from, "parent", "ffff:", "protocol", "all", "prio", "1"}, action...),
+		{"qdisc", "add", "dev", from, "root", "handle", "1:", "prio"},
+		append([]string{"filter", "add", "dev", from, "parent", "1:", "protocol", "all", "prio", "1"}, action...),
+	}
+}
+
+// mirror copies the traffic of iface to the interface of target, in place
+// of where it was copied to before.
+func (d *Driver) mirror(iface, target *networkInterface) error {
+	d.unmirror(iface)
+	for _, args := range mirrorArgs(iface.HostIface, target.HostIface) {
+		if err := d.runTc(args...); err != nil {
+			d.unmirror(iface)
+			return err
+		}
+	}
+	return nil
+}
+
+// unmirror stops copying the traffic of iface. Errors are ignored, the
+// traffic might not be mirrored.
+func (d *Driver) unmirror(iface *networkInterface) {
+	// Deleting the qdiscs takes their filters along
+	d.runTc("qdisc", "del", "dev", iface.HostIface, "ingress")
+	d.runTc("qdisc", "del", "dev", iface.HostIface, "root")
+}
+
+// releaseMirrors stops copying the traffic of the containers mirrored to
+// the container id.
+func (d *Driver) releaseMirrors(id string) {
+	for source, iface := range d.currentInterfaces.All() {
+		if iface.Mirror == id {
+			log.WithField("container", source).Infof("Stopping the mirror of the traffic to %s", id)
+			d.unmirror(iface)
+			iface.Mirror = ""
+		}
+	}
+}
+
+// SetMirror copies all the traffic of a running container to the interface
+// of the running container "Target", or stops copying it if "Target" is
+// empty. The mirror stops once either container stops.
+func (d *Driver) SetMirror(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+		target  = job.Getenv("Target")
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if d.config.Rootless || network.HostIface == "" {
+		return job.Errorf("The network of %s has no interface on the host to mirror", id)
+	}
+	if target == "" {
+		if network.Mirror != "" {
+			log.WithField("container", id).Infof("Stopping the mirror of the traffic to %s", network.Mirror)
+			d.unmirror(network)
+			network.Mirror = ""
+		}
+		return engine.StatusOK
+	}
+
+	if target == id {
+		return job.Errorf("Cannot mirror the traffic of %s to itself", id)
+	}
+	targetNetwork := d.currentInterfaces.Get(target)
+	if targetNetwork == nil {
+		return job.Errorf("No network information for %s", target)
+	}
+	if targetNetwork.HostIface == "" {
+		return job.Errorf("The network of %s has no interface on the host to mirror to", target)
+	}
+	if targetNetwork.Mirror != "" {
+		return job.Errorf("Cannot mirror the traffic to %s, its own traffic is mirrored", target)
+	}
+	for source, iface := range d.currentInterfaces.All() {
+		if iface.Mirror == id {
+			return job.Errorf("Cannot mirror the traffic of %s, the traffic of %s is mirrored to it", id, source)
+		}
+	}
+	if network.Mirror == target {
+		return engine.StatusOK
+	}
+
+	log.WithField("container", id).Infof("Mirroring the traffic to %s", target)
+	if err := d.mirror(network, targetNetwork); err != nil {
+		network.Mirror = ""
+		return job.Error(err)
+	}
+	network.Mirror = target
+	return engine.StatusOK
+}
@@ -0,0 +1,67 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestSetMirror(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := &Driver{
+		config:            &Config{},
+		currentInterfaces: ifaces{c: make(map[string]*networkInterface)},
+	}
+	d.currentInterfaces.Set("web", &networkInterface{IP: net.ParseIP("172.17.0.5"), HostIface: "dkr-web"})
+	d.currentInterfaces.Set("snort", &networkInterface{IP: net.ParseIP("172.17.0.6"), HostIface: "dkr-snort"})
+	dryRunDriver(d)
+
+	job := eng.Job("set_mirror", "web")
+	job.Setenv("Target", "snort")
+	if res := d.SetMirror(job); res != engine.StatusOK {
+		t.Fatal("Failed to mirror the traffic")
+	}
+	if mirror := d.currentInterfaces.Get("web").Mirror; mirror != "snort" {
+		t.Fatalf("Expected the traffic to be mirrored to snort, got %q", mirror)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, change := range []string{
+		"tc qdisc add dev dkr-web handle ffff: ingress",
+		"tc filter add dev dkr-web parent ffff: protocol all prio 1 u32 match u32 0 0 action mirred egress mirror dev dkr-snort",
+		"tc qdisc add dev dkr-web root handle 1: prio",
+		"tc filter add dev dkr-web parent 1: protocol all prio 1 u32 match u32 0 0 action mirred egress mirror dev dkr-snort",
+	} {
+		if !strings.Contains(plan, change) {
+			t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+		}
+	}
+
+	for _, test := range []struct {
+		id, target string
+	}{
+		{"web", "web"},
+		{"web", "unknown"},
+		{"unknown", "snort"},
+		// The copies would go round in circles
+		{"snort", "web"},
+	} {
+		job := eng.Job("set_mirror", test.id)
+		job.Setenv("Target", test.target)
+		if res := d.SetMirror(job); res == engine.StatusOK {
+			t.Fatalf("Expected the mirror of %s to %s to be refused", test.id, test.target)
+		}
+	}
+
+	// The mirror stops with the container the traffic is copied to
+	d.changes.planned = nil
+	d.releaseMirrors("snort")
+	if mirror := d.currentInterfaces.Get("web").Mirror; mirror != "" {
+		t.Fatalf("Expected the mirror to stop, got %q", mirror)
+	}
+	if plan := strings.Join(d.changes.plan(), "\n"); !strings.Contains(plan, "tc qdisc del dev dkr-web root") {
+		t.Fatalf("Expected the qdiscs of the mirror to be deleted, got:\n%s", plan)
+	}
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// A container can have an MTU of its own, below the one of the bridge, such
+// as a container whose traffic goes through a VPN. The interface of the
+// container gets it, while the host side of its veth pair keeps the MTU of
+// the bridge: a bridge takes the smallest MTU of its ports, which would
+// lower the MTU of all the containers otherwise. The containers being given
+// their addresses statically, there is no router advertisement nor DHCP
+// option to advertise it in.
+
+// bridgeMtu returns the MTU of the bridge.
+func (d *Driver) bridgeMtu() (int, error) {
+	iface, err := net.InterfaceByName(d.bridgeIface)
+	if err != nil {
+		return 0, err
+	}
+	return iface.MTU, nil
+}
+
+// checkContainerMtu tells whether mtu can be the MTU of a container.
+func (d *Driver) checkContainerMtu(mtu int) error {
+	if mtu < 68 {
+		return fmt.Errorf("Invalid MTU %d, it must be at least 68", mtu)
+	}
+	// The bridge doesn't exist in dry-run mode
+	if bridge, err := d.bridgeMtu(); err == nil && mtu > bridge {
+		return fmt.Errorf("The MTU %d of the container is above the MTU %d of the bridge %s", mtu, bridge, d.bridgeIface)
+	}
+	return nil
+}
+
+// restoreVethMtu gives the host side of the veth pair of iface, given the
+// MTU of the container when the container started, the MTU of the bridge.
+func (d *Driver) restoreVethMtu(iface *networkInterface) error {
+	if iface.Mtu == 0 || iface.HostIface == "" {
+		return nil
+	}
+	bridge, err := d.bridgeMtu()
+	if err != nil {
+		return err
+	}
+	return d.runIp("link", "set", "dev", iface.HostIface, "mtu", strconv.Itoa(bridge))
+}
@@ -0,0 +1,39 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestContainerMtu(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	bridge, err := d.bridgeMtu()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := eng.Job("allocate_interface", "mtu_container")
+	job.SetenvInt("Mtu", bridge-100)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if mtu := d.currentInterfaces.Get("mtu_container").Mtu; mtu != bridge-100 {
+		t.Fatalf("Expected the MTU %d, got %d", bridge-100, mtu)
+	}
+
+	for _, mtu := range []int{bridge + 1, 67, -1} {
+		job := eng.Job("allocate_interface", "invalid_mtu_container")
+		job.SetenvInt("Mtu", mtu)
+		if err := job.Run(); err == nil {
+			t.Fatalf("Expected the MTU %d to be refused", mtu)
+		}
+		if d.currentInterfaces.Get("invalid_mtu_container") != nil {
+			t.Fatal("Expected the interface to be released")
+		}
+	}
+}
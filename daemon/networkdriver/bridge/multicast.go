@@ -0,0 +1,107 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"path"
+	"sync"
+)
+
+// The discovery protocols, such as SSDP and mDNS, and the clustering of some
+// databases, send multicast or broadcast to their peers. The bridge snooping
+// IGMP forwards the multicast only to the ports which joined the group, and
+// those joins go missing without a querier on the bridge, while --icc=false
+// drops all the traffic among the containers. With Multicast, the driver
+// accepts the multicast and the broadcast among the containers whatever
+// --icc, and has the bridge flood the multicast to all of them unless its
+// querier keeps the joins of the snooping fresh.
+
+// multicastArgs returns the FORWARD rules accepting the multicast and the
+// broadcast among the containers. IPv6 has no broadcast.
+func (d *Driver) multicastArgs(ipv6 bool) [][]string {
+	args := [][]string{
+		{"FORWARD", "-i", d.bridgeIface, "-o", d.bridgeIface, "-m", "pkttype", "--pkt-type", "multicast", "-j", "ACCEPT"},
+	}
+	if !ipv6 {
+		args = append(args, []string{"FORWARD", "-i", d.bridgeIface, "-o", d.bridgeIface, "-m", "pkttype", "--pkt-type", "broadcast", "-j", "ACCEPT"})
+	}
+	return args
+}
+
+// setupMulticast accepts the multicast and the broadcast among the
+// containers, or removes the rules if allow is false.
+func (d *Driver) setupMulticast(ipv6, allow bool) error {
+	for _, args := range d.multicastArgs(ipv6) {
+		if !allow {
+			d.firewall.Raw(ipv6, append([]string{"-D"}, args...)...)
+			continue
+		}
+		if d.firewall.Exists(ipv6, args...) {
+			continue
+		}
+		if err := d.execRule(ipv6, append([]string{"-I"}, args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snooping are the IGMP and MLD snooping settings of the bridge, which can
+// change at runtime: with snooping, the bridge forwards the multicast only
+// to the ports which joined the group, rather than flooding it to all the
+// containers, and with the querier it sends the queries keeping the joins
+// fresh where no multicast router on the network does.
+type snooping struct {
+	sync.Mutex
+	enabled bool
+	querier bool
+}
+
+// snoopingOf returns the snooping settings of config. The multicast among
+// the containers floods the bridge unless the querier is enabled.
+func snoopingOf(config *Config) (enabled, querier bool) {
+	enabled = config.MulticastSnooping && (!config.Multicast || config.MulticastQuerier)
+	return enabled, config.MulticastQuerier
+}
+
+// setSnooping turns the snooping and the querier of the bridge on or off.
+func (d *Driver) setSnooping(enabled, querier bool) error {
+	d.snooping.Lock()
+	defer d.snooping.Unlock()
+
+	if err := d.setBridgeOption(d.bridgeIface, "multicast_snooping", boolOption(enabled)); err != nil {
+		return err
+	}
+	if err := d.setBridgeOption(d.bridgeIface, "multicast_querier", boolOption(querier)); err != nil {
+		return err
+	}
+	d.snooping.enabled, d.snooping.querier = enabled, querier
+	return nil
+}
+
+// snoopingSettings returns whether the bridge snoops and sends the queries.
+func (d *Driver) snoopingSettings() (enabled, querier bool) {
+	d.snooping.Lock()
+	defer d.snooping.Unlock()
+	return d.snooping.enabled, d.snooping.querier
+}
+
+func boolOption(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// setBridgeOption writes an option of the bridge in sysfs.
+func (d *Driver) setBridgeOption(bridge, name, value string) error {
+	p := path.Join("/sys/class/net", bridge, "bridge", name)
+	if d.dryRun {
+		d.changes.record("echo", []string{value, ">", p}, nil)
+		return nil
+	}
+	if d.executor != nil {
+		_, err := d.executor.Run("sysctl", p, value)
+		return err
+	}
+	return ioutil.WriteFile(p, []byte(value+"\n"), 0644)
+}
@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestSetupMulticast(t *testing.T) {
+	d := &Driver{bridgeIface: "docker0"}
+	dryRunDriver(d)
+
+	for _, test := range []struct {
+		ipv6, allow bool
+		changes     []string
+	}{
+		{false, true, []string{
+			"iptables -I FORWARD -i docker0 -o docker0 -m pkttype --pkt-type multicast -j ACCEPT",
+			"iptables -I FORWARD -i docker0 -o docker0 -m pkttype --pkt-type broadcast -j ACCEPT",
+		}},
+		{true, true, []string{
+			"ip6tables -I FORWARD -i docker0 -o docker0 -m pkttype --pkt-type multicast -j ACCEPT",
+		}},
+		{false, false, []string{
+			"iptables -D FORWARD -i docker0 -o docker0 -m pkttype --pkt-type multicast -j ACCEPT",
+			"iptables -D FORWARD -i docker0 -o docker0 -m pkttype --pkt-type broadcast -j ACCEPT",
+		}},
+	} {
+		d.changes.planned = nil
+		if err := d.setupMulticast(test.ipv6, test.allow); err != nil {
+			t.Fatal(err)
+		}
+		plan := strings.Join(d.changes.plan(), "\n")
+		for _, change := range test.changes {
+			if !strings.Contains(plan, change) {
+				t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+			}
+		}
+		if test.ipv6 && strings.Contains(plan, "broadcast") {
+			t.Fatalf("Expected no broadcast rule for IPv6, got:\n%s", plan)
+		}
+	}
+}
+
+func TestSnoopingOf(t *testing.T) {
+	for _, test := range []struct {
+		config            Config
+		snooping, querier bool
+	}{
+		{Config{MulticastSnooping: true}, true, false},
+		{Config{MulticastSnooping: false, MulticastQuerier: true}, false, true},
+		// The multicast among the containers floods the bridge without a querier
+		{Config{Multicast: true, MulticastSnooping: true}, false, false},
+		{Config{Multicast: true, MulticastSnooping: true, MulticastQuerier: true}, true, true},
+	} {
+		if snooping, querier := snoopingOf(&test.config); snooping != test.snooping || querier != test.querier {
+			t.Fatalf("Expected %+v to snoop %v with querier %v, got %v and %v", test.config, test.snooping, test.querier, snooping, querier)
+		}
+	}
+}
+
+func TestConfigureSnooping(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := &Driver{bridgeIface: "docker0", config: &Config{}}
+	d.snooping.enabled = true
+	dryRunDriver(d)
+
+	job := eng.Job("configure_network")
+	job.SetenvBool("MulticastQuerier", true)
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := d.ConfigureDriver(job); res != engine.StatusOK {
+		t.Fatal("Failed to configure the driver")
+	}
+	if err := job.Stdout.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !out.GetBool("MulticastSnooping") || !out.GetBool("MulticastQuerier") {
+		t.Fatalf("Expected the snooping to be kept and the querier enabled, got %v", out)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, change := range []string{
+		"echo 1 > /sys/class/net/docker0/bridge/multicast_snooping",
+		"echo 1 > /sys/class/net/docker0/bridge/multicast_querier",
+	} {
+		if !strings.Contains(plan, change) {
+			t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+		}
+	}
+
+	// The settings left out don't touch the bridge
+	d.changes.planned = nil
+	if res := d.ConfigureDriver(eng.Job("configure_network")); res != engine.StatusOK {
+		t.Fatal("Failed to configure the driver")
+	}
+	if plan := d.changes.plan(); len(plan) != 0 {
+		t.Fatalf("Expected nothing to be planned, got %v", plan)
+	}
+}
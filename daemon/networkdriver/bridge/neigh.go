@@ -0,0 +1,118 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The host keeps a neighbor entry for each container it talks to, and the
+// kernel drops the entries beyond gc_thresh3, 1024 by default, so that the
+// containers past a few hundreds lose their traffic without a word in the
+// logs but "neighbour table overflow". With NeighThresholds, the driver
+// raises the thresholds to the number of addresses of the pool of the
+// containers, in the ratios of the kernel defaults, and it warns when the
+// containers come close to the limit either way.
+
+const (
+	// neighHeadroom are the entries left to the other neighbors of the host
+	neighHeadroom = 1024
+	// maxNeighEntries bounds the thresholds of the larger pools, such as
+	// the default /16
+	maxNeighEntries = 1 << 17
+)
+
+// neighSysctl returns the path of a threshold of the neighbor table of the
+// family of network.
+func neighSysctl(network *net.IPNet, name string) string {
+	family := "ipv4"
+	if network.IP.To4() == nil {
+		family = "ipv6"
+	}
+	return path.Join("/proc/sys/net", family, "neigh/default", name)
+}
+
+// poolSize returns the number of addresses of the pool of the containers,
+// the fixed CIDR if any or the bridge network.
+func (d *Driver) poolSize(config *Config) int {
+	pool := d.bridgeNetwork
+	if config.FixedCIDR != "" {
+		if _, subnet, err := net.ParseCIDR(config.FixedCIDR); err == nil {
+			pool = subnet
+		}
+	}
+	ones, bits := pool.Mask.Size()
+	if bits-ones >= 31 {
+		return maxNeighEntries
+	}
+	return 1 << uint(bits-ones)
+}
+
+// sizeNeighTable raises the thresholds of the neighbor table to the pool of
+// the containers. The thresholds set higher are kept.
+func (d *Driver) sizeNeighTable(config *Config) {
+	size := d.poolSize(config) + neighHeadroom
+	if size > maxNeighEntries {
+		size = maxNeighEntries
+	}
+	for _, t := range []struct {
+		name  string
+		value int
+	}{
+		{"gc_thresh1", size / 8},
+		{"gc_thresh2", size / 2},
+		{"gc_thresh3", size},
+	} {
+		p := neighSysctl(d.bridgeNetwork, t.name)
+		if current, err := readSysctlInt(p); err == nil && current >= t.value {
+			continue
+		}
+		if err := d.setSysctl(p, strconv.Itoa(t.value)); err != nil {
+			log.Warnf("Unable to raise %s: %s", p, err)
+		}
+	}
+}
+
+// neighEntries returns the neighbor entries of the containers on the host.
+func (d *Driver) neighEntries() int {
+	entries := 0
+	for _, iface := range d.currentInterfaces.All() {
+		entries += 1 + len(iface.SecondaryIPs)
+	}
+	return entries
+}
+
+// warnNeighTable warns once the containers take 90% of the neighbor table,
+// and again once they went back below it and came close again.
+func (d *Driver) warnNeighTable() {
+	p := neighSysctl(d.bridgeNetwork, "gc_thresh3")
+	limit, err := readSysctlInt(p)
+	if err != nil {
+		return
+	}
+	entries := d.neighEntries()
+	if entries*10 < limit*9 {
+		atomic.StoreInt32(&d.neighWarned, 0)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&d.neighWarned, 0, 1) {
+		log.Warnf("The neighbor table of the host is nearly full, with %d addresses of containers for %d entries: the traffic of the containers will be dropped beyond it, raise %s or start the daemon with --neigh-thresholds", entries, limit, sysctlName(p))
+	}
+}
+
+// sysctlName returns the name of a kernel parameter given by its /proc/sys
+// path, as sysctl takes it.
+func sysctlName(path string) string {
+	return strings.Replace(strings.TrimPrefix(path, "/proc/sys/"), "/", ".", -1)
+}
+
+func readSysctlInt(path string) (int, error) {
+	value, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(value)))
+}
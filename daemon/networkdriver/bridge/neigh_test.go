@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSizeNeighTable(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.1.0.0/16")
+	d := &Driver{bridgeNetwork: network}
+
+	dryRunDriver(d)
+	d.sizeNeighTable(&Config{FixedCIDR: "10.1.4.0/22"})
+	plan := strings.Join(d.changes.plan(), "\n")
+
+	// The 1024 addresses of the pool and the headroom, in the ratios of
+	// the kernel defaults, unless the thresholds are higher already
+	for name, value := range map[string]int{"gc_thresh1": 256, "gc_thresh2": 1024, "gc_thresh3": 2048} {
+		p := neighSysctl(network, name)
+		change := fmt.Sprintf("sysctl -w %s=%d", strings.TrimPrefix(p, "/proc/sys/"), value)
+		current, err := readSysctlInt(p)
+		if err != nil {
+			t.Skipf("Unable to read %s: %s", p, err)
+		}
+		if planned := strings.Contains(plan, change); planned != (current < value) {
+			t.Fatalf("Expected %q to be planned only if %s is lower than %d, got:\n%s", change, name, value, plan)
+		}
+	}
+
+	if p := neighSysctl(&net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)}, "gc_thresh3"); p != "/proc/sys/net/ipv6/neigh/default/gc_thresh3" {
+		t.Fatalf("Expected the IPv6 table for an IPv6 network, got %s", p)
+	}
+}
+
+func TestCheckNeighTable(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.1.0.0/16")
+	d := &Driver{bridgeNetwork: network, currentInterfaces: ifaces{c: make(map[string]*networkInterface)}}
+	limit, err := readSysctlInt(neighSysctl(network, "gc_thresh3"))
+	if err != nil || limit > 1<<16 {
+		t.Skip("Unable to fill the neighbor table of the host")
+	}
+	if c := d.checkNeighTable(); !c.OK {
+		t.Fatalf("Expected room for no container, got %s", c.Message)
+	}
+
+	for i := 0; i*10 < limit*9; i++ {
+		d.currentInterfaces.Set(fmt.Sprint(i), &networkInterface{})
+	}
+	if c := d.checkNeighTable(); c.OK || !strings.Contains(c.Message, "net.ipv4.neigh.default.gc_thresh3") {
+		t.Fatalf("Expected the neighbor table to be nearly full, got %+v", c)
+	}
+	d.warnNeighTable()
+	if d.neighWarned != 1 {
+		t.Fatal("Expected the containers nearly filling the neighbor table to be warned about")
+	}
+	d.currentInterfaces.Delete("0")
+	d.currentInterfaces.Delete("1")
+	d.warnNeighTable()
+	if d.neighWarned != 0 {
+		t.Fatal("Expected the warning to be reset once the containers went below the limit")
+	}
+}
@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/docker/docker/daemon/networkdriver"
+)
+
+// A container can have physical interfaces of the host of its own besides
+// its interface on the bridge, for the appliances packaged as containers
+// such as the routers or the IDS watching a network. The interfaces are
+// claimed when the interface of the container is allocated, moved into its
+// namespace once it runs, and given back to the host when it is released:
+// the kernel moves them back when the namespace goes away, under another
+// name if the container renamed them, so they are found again by their MAC
+// address.
+
+// netDevice is a physical interface of the host given to a container.
+type netDevice struct {
+	Name string
+	Mac  net.HardwareAddr
+	Up   bool // whether it was up on the host
+}
+
+// netDevices are the physical interfaces claimed by the containers.
+type netDevices struct {
+	sync.Mutex
+	owners map[string]string // container ids, by name of interface
+}
+
+// claimNetDevices checks that the interfaces names can be given to the
+// container id, and claims them.
+func (d *Driver) claimNetDevices(id string, names []string) ([]*netDevice, error) {
+	d.netDevices.Lock()
+	defer d.netDevices.Unlock()
+
+	var defaultIface string
+	if iface, err := networkdriver.GetDefaultRouteIface(); err == nil {
+		defaultIface = iface.Name
+	}
+	var devices []*netDevice
+	for _, name := range names {
+		if owner, claimed := d.netDevices.owners[name]; claimed && owner != id {
+			return nil, fmt.Errorf("The interface %s is given to the container %s already", name, owner)
+		}
+		if name == d.bridgeIface || name == defaultIface {
+			return nil, fmt.Errorf("The interface %s can't be given to a container, the host needs it", name)
+		}
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to find the interface %s: %s", name, err)
+		}
+		if iface.Flags&net.FlagLoopback != 0 {
+			return nil, fmt.Errorf("The interface %s can't be given to a container, the host needs it", name)
+		}
+		devices = append(devices, &netDevice{Name: name, Mac: iface.HardwareAddr, Up: iface.Flags&net.FlagUp != 0})
+	}
+	for _, dev := range devices {
+		d.netDevices.owners[dev.Name] = id
+	}
+	return devices, nil
+}
+
+// attachNetDevices moves the interfaces of iface into the namespace of the
+// running container, of process pid.
+func (d *Driver) attachNetDevices(iface *networkInterface, pid int) error {
+	for _, dev := range iface.NetDevices {
+		if err := d.runIp("link", "set", "dev", dev.Name, "netns", strconv.Itoa(pid)); err != nil {
+			return fmt.Errorf("Unable to move the interface %s into the container: %s", dev.Name, err)
+		}
+	}
+	return nil
+}
+
+// releaseNetDevices gives the interfaces of iface back to the host, with
+// their name and their state, and unclaims them.
+func (d *Driver) releaseNetDevices(iface *networkInterface) {
+	d.netDevices.Lock()
+	defer d.netDevices.Unlock()
+
+	for _, dev := range iface.NetDevices {
+		delete(d.netDevices.owners, dev.Name)
+		current := findNetDevice(dev)
+		if current == "" {
+			log.Infof("Unable to give the interface %s back to the host, it is in the namespace of the container still", dev.Name)
+			continue
+		}
+		if current != dev.Name {
+			if err := d.runIp("link", "set", "dev", current, "name", dev.Name); err != nil {
+				log.Infof("Unable to rename the interface %s back to %s: %s", current, dev.Name, err)
+				continue
+			}
+		}
+		if dev.Up {
+			if err := d.runIp("link", "set", "dev", dev.Name, "up"); err != nil {
+				log.Infof("Unable to bring the interface %s back up: %s", dev.Name, err)
+			}
+		}
+	}
+	iface.NetDevices = nil
+}
+
+// findNetDevice returns the name of dev on the host, empty if it isn't
+// there. Several interfaces can have the same MAC address, such as the
+// slaves of a bond, the one of the same name being preferred.
+func findNetDevice(dev *netDevice) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	found := ""
+	for _, i := range ifaces {
+		if len(dev.Mac) != 0 && i.HardwareAddr.String() != dev.Mac.String() {
+			continue
+		}
+		if i.Name == dev.Name {
+			return i.Name
+		}
+		if len(dev.Mac) != 0 && found == "" {
+			found = i.Name
+		}
+	}
+	return found
+}
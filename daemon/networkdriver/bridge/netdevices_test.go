@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestNetDevices(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+
+	// A veth pair stands for the physical interface
+	if output, err := exec.Command("ip", "link", "add", "dkrnd0", "type", "veth", "peer", "name", "dkrnd1").CombinedOutput(); err != nil {
+		t.Skipf("Unable to create an interface: %s (%s)", err, output)
+	}
+	defer exec.Command("ip", "link", "del", "dkrnd0").Run()
+	defer exec.Command("ip", "link", "del", "dkrnd9").Run()
+
+	job := eng.Job("allocate_interface", "appliance")
+	job.SetenvList("NetDevices", []string{"dkrnd0"})
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	devices := d.currentInterfaces.Get("appliance").NetDevices
+	if len(devices) != 1 || devices[0].Name != "dkrnd0" || len(devices[0].Mac) == 0 {
+		t.Fatalf("Expected the interface dkrnd0 to be given, got %v", devices)
+	}
+
+	for _, name := range []string{"dkrnd0", "lo", d.bridgeIface, "dkrnonexistent"} {
+		job := eng.Job("allocate_interface", "other_appliance")
+		job.SetenvList("NetDevices", []string{name})
+		if err := job.Run(); err == nil {
+			t.Fatalf("Expected the interface %s to be refused", name)
+		}
+		if d.currentInterfaces.Get("other_appliance") != nil {
+			t.Fatal("Expected the interface to be released")
+		}
+	}
+
+	restore := dryRunDriver(d)
+	job = eng.Job("attach_interface", "appliance")
+	job.SetenvInt("Pid", 42)
+	err := job.Run()
+	restore()
+	plan := strings.Join(d.changes.plan(), "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change := "ip link set dev dkrnd0 netns 42"; !strings.Contains(plan, change) {
+		t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+	}
+
+	// Back from the namespace of the container under another name
+	if output, err := exec.Command("ip", "link", "set", "dev", "dkrnd0", "name", "dkrnd9").CombinedOutput(); err != nil {
+		t.Fatalf("%s (%s)", err, output)
+	}
+	if err := eng.Job("release_interface", "appliance").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.InterfaceByName("dkrnd0"); err != nil {
+		t.Fatalf("Expected the interface to get its name back: %s", err)
+	}
+	job = eng.Job("allocate_interface", "other_appliance")
+	job.SetenvList("NetDevices", []string{"dkrnd0"})
+	if err := job.Run(); err != nil {
+		t.Fatalf("Expected the interface to be unclaimed: %s", err)
+	}
+}
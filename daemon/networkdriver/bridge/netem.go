@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/docker/docker/engine"
+)
+
+var (
+	validNetemTime    = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(us|ms|s)$`)
+	validNetemPercent = regexp.MustCompile(`^(100|[0-9]{1,2}(\.[0-9]+)?)%$`)
+)
+
+// netem describes the faults injected in the traffic to a container, in tc
+// units (ex: "100ms", "0.5%"). The traffic from the container is left alone,
+// the faults apply to both directions of a conversation all the same.
+type netem struct {
+	Delay   string
+	Jitter  string
+	Loss    string
+	Reorder string
+}
+
+// parseNetem builds the faults out of the Delay, Jitter, Loss and Reorder
+// variables of a job. It returns nil if none is given.
+func parseNetem(job *engine.Job) (*netem, error) {
+	n := &netem{
+		Delay:   job.Getenv("Delay"),
+		Jitter:  job.Getenv("Jitter"),
+		Loss:    job.Getenv("Loss"),
+		Reorder: job.Getenv("Reorder"),
+	}
+	if *n == (netem{}) {
+		return nil, nil
+	}
+	for _, t := range []string{n.Delay, n.Jitter} {
+		if t != "" && !validNetemTime.MatchString(t) {
+			return nil, fmt.Errorf("Invalid delay %s (ex: 100ms)", t)
+		}
+	}
+	for _, p := range []string{n.Loss, n.Reorder} {
+		if p != "" && !validNetemPercent.MatchString(p) {
+			return nil, fmt.Errorf("Invalid percentage %s (ex: 0.5%%)", p)
+		}
+	}
+	// netem only reorders and jitters delayed packets
+	if n.Delay == "" && (n.Jitter != "" || n.Reorder != "") {
+		return nil, fmt.Errorf("Jitter and reordering require a delay")
+	}
+	return n, nil
+}
+
+// args returns the netem qdisc parameters.
+func (n *netem) args() []string {
+	var args []string
+	if n.Delay != "" {
+		args = append(args, "delay", n.Delay)
+		if n.Jitter != "" {
+			args = append(args, n.Jitter)
+		}
+	}
+	if n.Loss != "" {
+		args = append(args, "loss", n.Loss)
+	}
+	if n.Reorder != "" {
+		args = append(args, "reorder", n.Reorder)
+	}
+	return args
+}
+
+// SetNetem injects latency, jitter, packet loss and reordering in the traffic
+// to a running container, replacing the faults injected so far. Leaving all
+// of Delay, Jitter, Loss and Reorder empty clears them.
+func (d *Driver) SetNetem(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+
+	n, err := parseNetem(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	if n == nil && network.Netem == nil {
+		return engine.StatusOK
+	}
+
+	log.WithField("container", id).Debugf("Injecting network faults: %+v", n)
+	if err := d.applyShaping(network.IP, network.Bandwidth, n); err != nil {
+		return job.Error(err)
+	}
+	network.Netem = n
+	d.saveJob(id, job.Name, job.Environ())
+	return engine.StatusOK
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestParseNetem(t *testing.T) {
+	eng := engine.New()
+
+	job := eng.Job("set_netem", "container_id")
+	if n, err := parseNetem(job); err != nil || n != nil {
+		t.Fatalf("Expected no faults, got %v (%v)", n, err)
+	}
+
+	job.Setenv("Delay", "100ms")
+	job.Setenv("Jitter", "10ms")
+	job.Setenv("Loss", "0.5%")
+	job.Setenv("Reorder", "25%")
+	n, err := parseNetem(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.Join(n.args(), " "); args != "delay 100ms 10ms loss 0.5% reorder 25%" {
+		t.Fatalf("Unexpected netem parameters %s", args)
+	}
+
+	for _, loss := range []string{"0.5", "101%", "lots", "-1%"} {
+		job.Setenv("Loss", loss)
+		if _, err := parseNetem(job); err == nil {
+			t.Fatalf("Expected loss %s to be rejected", loss)
+		}
+	}
+	job.Setenv("Loss", "")
+
+	job.Setenv("Delay", "100")
+	if _, err := parseNetem(job); err == nil {
+		t.Fatal("Expected delay without unit to be rejected")
+	}
+
+	job.Setenv("Delay", "")
+	if _, err := parseNetem(job); err == nil {
+		t.Fatal("Expected reordering without delay to be rejected")
+	}
+
+	job.Setenv("Jitter", "")
+	job.Setenv("Reorder", "")
+	job.Setenv("Loss", "2%")
+	if n, err = parseNetem(job); err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.Join(n.args(), " "); args != "loss 2%" {
+		t.Fatalf("Unexpected netem parameters %s", args)
+	}
+}
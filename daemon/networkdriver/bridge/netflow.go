@@ -0,0 +1,248 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The flow exporter periodically samples the conntrack table and ships the
+// traffic of the container flows since the previous sample to a NetFlow v9
+// collector.
+const (
+	netflowInterval   = time.Minute
+	netflowTemplateID = 256
+	netflowMaxRecords = 30 // keeps export packets below the usual MTU
+)
+
+// NetFlow v9 field types and lengths of the records, in the order of the
+// template.
+var netflowFields = []struct{ Type, Length uint16 }{
+	{8, 4},  // IPV4_SRC_ADDR
+	{12, 4}, // IPV4_DST_ADDR
+	{7, 2},  // L4_SRC_PORT
+	{11, 2}, // L4_DST_PORT
+	{4, 1},  // PROTOCOL
+	{1, 8},  // IN_BYTES
+	{2, 8},  // IN_PKTS
+}
+
+const netflowRecordLength = 4 + 4 + 2 + 2 + 1 + 8 + 8
+
+// flow is one direction of a conntrack entry.
+type flow struct {
+	Src, Dst         net.IP
+	SrcPort, DstPort uint16
+	Proto            uint8
+	Bytes, Packets   uint64
+}
+
+func (f *flow) key() string {
+	return strings.Join([]string{f.Src.String(), f.Dst.String(),
+		strconv.Itoa(int(f.SrcPort)), strconv.Itoa(int(f.DstPort)), strconv.Itoa(int(f.Proto))}, " ")
+}
+
+// parseConntrack reads the flows of the /proc/net/nf_conntrack listing. Each
+// entry gives two flows, the original and the reply directions. Entries
+// without counters, when conntrack accounting is disabled, are skipped.
+func parseConntrack(output string) []*flow {
+	var flows []*flow
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "ipv4" {
+			continue
+		}
+		proto, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+
+		var (
+			entry []*flow
+			cur   *flow
+		)
+		for _, field := range fields[4:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			// Every direction starts with its source address
+			if kv[0] == "src" {
+				if len(entry) == 2 {
+					break
+				}
+				cur = &flow{Proto: uint8(proto)}
+				entry = append(entry, cur)
+			}
+			if cur == nil {
+				continue
+			}
+			switch kv[0] {
+			case "src":
+				cur.Src = net.ParseIP(kv[1]).To4()
+			case "dst":
+				cur.Dst = net.ParseIP(kv[1]).To4()
+			case "sport":
+				port, _ := strconv.ParseUint(kv[1], 10, 16)
+				cur.SrcPort = uint16(port)
+			case "dport":
+				port, _ := strconv.ParseUint(kv[1], 10, 16)
+				cur.DstPort = uint16(port)
+			case "packets":
+				cur.Packets, _ = strconv.ParseUint(kv[1], 10, 64)
+			case "bytes":
+				cur.Bytes, _ = strconv.ParseUint(kv[1], 10, 64)
+			}
+		}
+		for _, f := range entry {
+			if f.Src != nil && f.Dst != nil && f.Packets > 0 {
+				flows = append(flows, f)
+			}
+		}
+	}
+	return flows
+}
+
+// flowSampler turns the cumulative conntrack counters into the traffic of
+// each flow since the previous sample.
+type flowSampler struct {
+	network *net.IPNet
+	last    map[string]*flow
+}
+
+func newFlowSampler(network *net.IPNet) *flowSampler {
+	return &flowSampler{network: network, last: make(map[string]*flow)}
+}
+
+// sample returns the container flows with traffic since the last call.
+func (s *flowSampler) sample(flows []*flow) []*flow {
+	var (
+		out  []*flow
+		seen = make(map[string]*flow)
+	)
+	for _, f := range flows {
+		if !s.network.Contains(f.Src) && !s.network.Contains(f.Dst) {
+			continue
+		}
+		k := f.key()
+		seen[k] = f
+		delta := *f
+		// Counters going down mean the tuple was reused by a new connection
+		if prev, ok := s.last[k]; ok && prev.Packets <= f.Packets && prev.Bytes <= f.Bytes {
+			delta.Packets -= prev.Packets
+			delta.Bytes -= prev.Bytes
+		}
+		if delta.Packets > 0 {
+			out = append(out, &delta)
+		}
+	}
+	s.last = seen
+	return out
+}
+
+// encodeNetflow builds a NetFlow v9 export packet of the given flows, which
+// must not be more than netflowMaxRecords. The template is sent along with
+// every packet so collectors can decode them regardless of losses.
+func encodeNetflow(flows []*flow, uptime time.Duration, now time.Time, sequence uint32) []byte {
+	var (
+		buf = &bytes.Buffer{}
+		w   = func(v interface{}) { binary.Write(buf, binary.BigEndian, v) }
+	)
+
+	// Header
+	w(uint16(9))
+	w(uint16(1 + len(flows)))
+	w(uint32(uptime / time.Millisecond))
+	w(uint32(now.Unix()))
+	w(sequence)
+	w(uint32(0)) // source id
+
+	// Template flowset
+	w(uint16(0))
+	w(uint16(4 + 4 + 4*len(netflowFields)))
+	w(uint16(netflowTemplateID))
+	w(uint16(len(netflowFields)))
+	for _, f := range netflowFields {
+		w(f.Type)
+		w(f.Length)
+	}
+
+	// Data flowset, padded to 32 bits
+	length := 4 + netflowRecordLength*len(flows)
+	padding := (4 - length%4) % 4
+	w(uint16(netflowTemplateID))
+	w(uint16(length + padding))
+	for _, f := range flows {
+		buf.Write(f.Src.To4())
+		buf.Write(f.Dst.To4())
+		w(f.SrcPort)
+		w(f.DstPort)
+		w(f.Proto)
+		w(f.Bytes)
+		w(f.Packets)
+	}
+	buf.Write(make([]byte, padding))
+	return buf.Bytes()
+}
+
+// startFlowExport ships the flows of the containers of network to the
+// collector at addr (host:port) until stopFlowExport is called.
+func (d *Driver) startFlowExport(addr string, network *net.IPNet) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	// The kernel doesn't count the traffic of connections by default
+	if err := d.setSysctl("/proc/sys/net/netfilter/nf_conntrack_acct", "1"); err != nil {
+		log.Infof("Unable to enable conntrack accounting, flows might not be exported: %s", err)
+	}
+
+	stop := make(chan struct{})
+	d.flowExportStop = stop
+	go func() {
+		var (
+			start    = time.Now()
+			sampler  = newFlowSampler(network)
+			ticker   = time.NewTicker(netflowInterval)
+			sequence uint32
+		)
+		defer conn.Close()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			output, err := ioutil.ReadFile("/proc/net/nf_conntrack")
+			if err != nil {
+				log.Errorf("Unable to read the conntrack table: %s", err)
+				continue
+			}
+			flows := sampler.sample(parseConntrack(string(output)))
+			for len(flows) > 0 {
+				n := len(flows)
+				if n > netflowMaxRecords {
+					n = netflowMaxRecords
+				}
+				sequence++
+				if _, err := conn.Write(encodeNetflow(flows[:n], time.Since(start), time.Now(), sequence)); err != nil {
+					log.Debugf("Unable to export flows to %s: %s", addr, err)
+				}
+				flows = flows[n:]
+			}
+		}
+	}()
+	return nil
+}
+
+func (d *Driver) stopFlowExport() {
+	if d.flowExportStop != nil {
+		close(d.flowExportStop)
+		d.flowExportStop = nil
+	}
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+const conntrackOutput = `ipv4     2 tcp      6 431999 ESTABLISHED src=172.17.0.2 dst=93.184.216.34 sport=40000 dport=80 packets=5 bytes=300 src=93.184.216.34 dst=10.0.0.5 sport=80 dport=40000 packets=4 bytes=1000 [ASSURED] mark=0 use=1
+ipv4     2 udp      17 20 src=10.0.0.5 dst=10.0.0.1 sport=5353 dport=53 packets=1 bytes=60 src=10.0.0.1 dst=10.0.0.5 sport=53 dport=5353 packets=1 bytes=90 mark=0 use=1
+ipv4     2 tcp      6 30 SYN_SENT src=172.17.0.3 dst=10.0.0.9 sport=41000 dport=22 [UNREPLIED] src=10.0.0.9 dst=10.0.0.5 sport=22 dport=41000 mark=0 use=1
+ipv6     10 tcp      6 20 src=::1 dst=::1 sport=1 dport=2 packets=1 bytes=1 src=::1 dst=::1 sport=2 dport=1 packets=1 bytes=1 mark=0 use=1
+`
+
+func TestParseConntrack(t *testing.T) {
+	flows := parseConntrack(conntrackOutput)
+	if len(flows) != 4 {
+		t.Fatalf("Expected 4 flows, got %d", len(flows))
+	}
+	f := flows[0]
+	if !f.Src.Equal(net.ParseIP("172.17.0.2")) || f.SrcPort != 40000 || f.DstPort != 80 || f.Proto != 6 || f.Packets != 5 || f.Bytes != 300 {
+		t.Fatalf("Unexpected flow %+v", f)
+	}
+	f = flows[1]
+	if !f.Dst.Equal(net.ParseIP("10.0.0.5")) || f.Packets != 4 || f.Bytes != 1000 {
+		t.Fatalf("Unexpected reply flow %+v", f)
+	}
+}
+
+func TestFlowSampler(t *testing.T) {
+	_, network, _ := net.ParseCIDR("172.17.0.0/16")
+	s := newFlowSampler(network)
+
+	flows := s.sample(parseConntrack(conntrackOutput))
+	if len(flows) != 1 || flows[0].Packets != 5 {
+		t.Fatalf("Expected the container flow only, got %v", flows)
+	}
+
+	f := *flows[0]
+	f.Packets, f.Bytes = 8, 500
+	flows = s.sample([]*flow{&f})
+	if len(flows) != 1 || flows[0].Packets != 3 || flows[0].Bytes != 200 {
+		t.Fatalf("Expected the traffic since the last sample, got %+v", flows[0])
+	}
+
+	if flows = s.sample([]*flow{&f}); len(flows) != 0 {
+		t.Fatalf("Expected no flow without traffic, got %v", flows)
+	}
+}
+
+func TestEncodeNetflow(t *testing.T) {
+	flows := parseConntrack(conntrackOutput)[:2]
+	packet := encodeNetflow(flows, 3*time.Second, time.Unix(1000, 0), 7)
+
+	templateLength := 4 + 4 + 4*len(netflowFields)
+	dataLength := 4 + 2*netflowRecordLength + 2 // padded
+	if len(packet) != 20+templateLength+dataLength {
+		t.Fatalf("Unexpected packet length %d", len(packet))
+	}
+	if v := binary.BigEndian.Uint16(packet); v != 9 {
+		t.Fatalf("Expected version 9, got %d", v)
+	}
+	if count := binary.BigEndian.Uint16(packet[2:]); count != 3 {
+		t.Fatalf("Expected 3 records, got %d", count)
+	}
+	if uptime := binary.BigEndian.Uint32(packet[4:]); uptime != 3000 {
+		t.Fatalf("Expected an uptime of 3000ms, got %d", uptime)
+	}
+	if seq := binary.BigEndian.Uint32(packet[12:]); seq != 7 {
+		t.Fatalf("Expected sequence 7, got %d", seq)
+	}
+
+	data := packet[20+templateLength:]
+	if id := binary.BigEndian.Uint16(data); id != netflowTemplateID {
+		t.Fatalf("Expected data flowset %d, got %d", netflowTemplateID, id)
+	}
+	if src := net.IP(data[4:8]); !src.Equal(net.ParseIP("172.17.0.2")) {
+		t.Fatalf("Unexpected source %s", src)
+	}
+}
@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"os"
+	"path"
+	"strconv"
+)
+
+// The network namespace of a running container is named after its short id
+// in netnsDir, where ip netns names its own, so that the external tools such
+// as the routing daemons, or ip netns exec when debugging, can enter it. The
+// name is a symbolic link to the namespace of the process of the container
+// rather than a bind mount, so that it doesn't keep the namespace alive once
+// the container stops, and it goes away along with the interface.
+var netnsDir = "/var/run/netns"
+
+func netnsName(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// nameNetns names the network namespace of the running container id, of
+// process pid, and returns its path. The rootless network, without the
+// privileges to name it, returns the path of the namespace of the process.
+func (d *Driver) nameNetns(iface *networkInterface, id string, pid int) (string, error) {
+	target := "/proc/" + strconv.Itoa(pid) + "/ns/net"
+	if d.config.Rootless {
+		return target, nil
+	}
+	p := path.Join(netnsDir, netnsName(id))
+	if d.dryRun {
+		d.changes.record("ln", []string{"-sf", target, p}, nil)
+		iface.Netns = p
+		return p, nil
+	}
+	if err := os.MkdirAll(netnsDir, 0755); err != nil {
+		return "", err
+	}
+	// Left by the previous run of the container
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.Symlink(target, p); err != nil {
+		return "", err
+	}
+	iface.Netns = p
+	return p, nil
+}
+
+func (d *Driver) removeNetnsName(iface *networkInterface) {
+	if iface.Netns == "" {
+		return
+	}
+	if d.dryRun {
+		d.changes.record("rm", []string{"-f", iface.Netns}, nil)
+	} else if err := os.Remove(iface.Netns); err != nil && !os.IsNotExist(err) {
+		log.Infof("Unable to remove the name %s of the network namespace: %s", iface.Netns, err)
+	}
+	iface.Netns = ""
+}
@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestNameNetns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-netns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	netnsDir = dir
+	defer func() { netnsDir = "/var/run/netns" }()
+
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+
+	id := "0123456789abcdef0123"
+	if err := eng.Job("allocate_interface", id).Run(); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("attach_interface", id)
+	job.SetenvInt("Pid", os.Getpid())
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p := out.Get("NetnsPath")
+	if p != path.Join(dir, "0123456789ab") {
+		t.Fatalf("Expected the namespace to be named after the short id, got %s", p)
+	}
+	if target, err := os.Readlink(p); err != nil || target != "/proc/"+strconv.Itoa(os.Getpid())+"/ns/net" {
+		t.Fatalf("Expected a link to the namespace of the process, got %s (%v)", target, err)
+	}
+
+	// Named again when the container restarts
+	if err := eng.Job("attach_interface", id).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := eng.Job("release_interface", id).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(p); !os.IsNotExist(err) {
+		t.Fatalf("Expected the name of the namespace to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver"
+)
+
+// On the hosts whose network systemd-networkd manages, networkd can
+// reconfigure or remove the bridge of the daemon, and udev give it an
+// address of its own. The daemon cooperates with them instead of fighting
+// them: it either tells them to leave the bridge alone, or has networkd
+// create the bridge from units of its own.
+const (
+	networkdUnmanaged = "unmanaged"
+	networkdUnits     = "units"
+
+	// The bridge of the units is waited for that long
+	networkdTimeout = 10 * time.Second
+)
+
+// networkdDir holds the runtime units of networkd and udev, gone on reboot
+// like the bridge.
+var networkdDir = "/run/systemd/network"
+
+// reloadNetworkd makes networkd and udev read their units again, the
+// commands being killed past timeout.
+var reloadNetworkd = func(timeout time.Duration) error {
+	if path, err := exec.LookPath("udevadm"); err == nil {
+		if output, err := commandOutput(exec.Command(path, "control", "--reload"), timeout); err != nil {
+			return fmt.Errorf("udevadm control --reload failed: %s (%s)", output, err)
+		}
+	}
+	path, err := exec.LookPath("networkctl")
+	if err != nil {
+		// networkd isn't there to reload
+		return nil
+	}
+	if output, err := commandOutput(exec.Command(path, "reload"), timeout); err != nil {
+		return fmt.Errorf("networkctl reload failed: %s (%s)", output, err)
+	}
+	return nil
+}
+
+// networkdUnitFiles returns the units of the bridge by file name. The .link
+// unit keeps udev from giving the bridge a MAC address of its own. In
+// unmanaged mode networkd is told to leave the bridge alone, otherwise it
+// creates the bridge with the address addr.
+func networkdUnitFiles(mode, bridge, addr string, mtu int) map[string]string {
+	name := "10-docker-" + bridge
+	units := map[string]string{
+		name + ".link": fmt.Sprintf("[Match]\nOriginalName=%s\n\n[Link]\nMACAddressPolicy=none\n", bridge),
+	}
+	if mode == networkdUnmanaged {
+		units[name+".network"] = fmt.Sprintf("[Match]\nName=%s\n\n[Link]\nUnmanaged=yes\n", bridge)
+		return units
+	}
+
+	netdev := fmt.Sprintf("[NetDev]\nName=%s\nKind=bridge\n", bridge)
+	if mtu != 0 {
+		netdev += fmt.Sprintf("MTUBytes=%d\n", mtu)
+	}
+	units[name+".netdev"] = netdev
+	units[name+".network"] = fmt.Sprintf("[Match]\nName=%s\n\n[Network]\nAddress=%s\nConfigureWithoutCarrier=yes\nLinkLocalAddressing=no\nIPv6AcceptRA=no\n", bridge, addr)
+	return units
+}
+
+// setupNetworkd writes the units of the bridge before it is set up. In
+// units mode it waits for networkd to have created the bridge.
+func (d *Driver) setupNetworkd(config *Config) error {
+	var addr string
+	if config.Networkd == networkdUnits {
+		// The bridge keeps the address it has
+		if existing, err := networkdriver.GetIfaceAddr(d.bridgeIface, true, false); err == nil {
+			addr = existing.String()
+		} else if addr, err = d.bridgeAddress(config.BridgeIP); err != nil {
+			return err
+		}
+	}
+	units := networkdUnitFiles(config.Networkd, d.bridgeIface, addr, config.Mtu)
+
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if d.dryRun {
+		for _, name := range names {
+			d.changes.record("networkd", []string{"write", path.Join(networkdDir, name)}, nil)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(networkdDir, 0755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(path.Join(networkdDir, name), []byte(units[name]), 0644); err != nil {
+			return fmt.Errorf("Unable to write the networkd unit %s: %s", name, err)
+		}
+	}
+	if err := reloadNetworkd(d.commandTimeout); err != nil {
+		if config.Networkd == networkdUnits {
+			return err
+		}
+		log.Warnf("Unable to reload systemd-networkd: %s", err)
+	}
+	if config.Networkd == networkdUnits {
+		return d.waitNetworkdBridge(addr)
+	}
+	return nil
+}
+
+// waitNetworkdBridge waits for networkd to have given the bridge its
+// address.
+func (d *Driver) waitNetworkdBridge(addr string) error {
+	ip, _, err := net.ParseCIDR(addr)
+	if err != nil {
+		return err
+	}
+	for start := time.Now(); time.Since(start) < networkdTimeout; time.Sleep(100 * time.Millisecond) {
+		if existing, err := networkdriver.GetIfaceAddr(d.bridgeIface, true, false); err == nil && existing.(*net.IPNet).IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("systemd-networkd didn't create the bridge %s with the address %s within %s", d.bridgeIface, addr, networkdTimeout)
+}
+
+// removeNetworkd removes the units of the bridge, and in units mode the
+// bridge networkd created, which it wouldn't remove itself.
+func (d *Driver) removeNetworkd() {
+	if d.config.Networkd == "" || d.dryRun {
+		return
+	}
+	name := "10-docker-" + d.bridgeIface
+	for _, ext := range []string{".link", ".netdev", ".network"} {
+		if err := os.Remove(path.Join(networkdDir, name+ext)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Unable to remove the networkd unit %s: %s", name+ext, err)
+		}
+	}
+	if err := reloadNetworkd(d.commandTimeout); err != nil {
+		log.Warnf("Unable to reload systemd-networkd: %s", err)
+	}
+	if d.config.Networkd == networkdUnits {
+		if err := d.runIp("link", "del", d.bridgeIface); err != nil {
+			log.Warnf("Unable to remove the bridge %s: %s", d.bridgeIface, err)
+		}
+	}
+}
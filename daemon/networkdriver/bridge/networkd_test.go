@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetworkdUnitFiles(t *testing.T) {
+	units := networkdUnitFiles(networkdUnits, "docker0", "172.17.42.1/16", 1400)
+	if len(units) != 3 {
+		t.Fatalf("Expected a .link, a .netdev and a .network unit, got %v", units)
+	}
+	if netdev := units["10-docker-docker0.netdev"]; !strings.Contains(netdev, "Kind=bridge\n") || !strings.Contains(netdev, "MTUBytes=1400\n") {
+		t.Fatalf("Unexpected .netdev unit\n%s", netdev)
+	}
+	if network := units["10-docker-docker0.network"]; !strings.Contains(network, "Address=172.17.42.1/16\n") {
+		t.Fatalf("Unexpected .network unit\n%s", network)
+	}
+
+	units = networkdUnitFiles(networkdUnmanaged, "docker0", "", 0)
+	if _, exists := units["10-docker-docker0.netdev"]; exists {
+		t.Fatal("Expected no .netdev unit for an unmanaged bridge")
+	}
+	if network := units["10-docker-docker0.network"]; !strings.Contains(network, "Unmanaged=yes\n") {
+		t.Fatalf("Unexpected .network unit\n%s", network)
+	}
+	if link := units["10-docker-docker0.link"]; !strings.Contains(link, "OriginalName=docker0\n") || !strings.Contains(link, "MACAddressPolicy=none\n") {
+		t.Fatalf("Unexpected .link unit\n%s", link)
+	}
+}
+
+func TestSetupNetworkdUnmanaged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-networkd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { networkdDir = orig }(networkdDir)
+	networkdDir = dir
+	reloads := 0
+	defer func(orig func(time.Duration) error) { reloadNetworkd = orig }(reloadNetworkd)
+	reloadNetworkd = func(time.Duration) error {
+		reloads++
+		return nil
+	}
+
+	config := &Config{Networkd: networkdUnmanaged}
+	d := newDriver(config)
+	if err := d.setupNetworkd(config); err != nil {
+		t.Fatal(err)
+	}
+	if reloads != 1 {
+		t.Fatalf("Expected networkd to be reloaded once, got %d", reloads)
+	}
+	for _, name := range []string{"10-docker-docker0.link", "10-docker-docker0.network"} {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.removeNetworkd()
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 {
+		t.Fatalf("Expected the units to be removed, got %d", len(names))
+	}
+}
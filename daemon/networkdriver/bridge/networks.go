@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+// NetworkInfo describes a network the containers of the host can join.
+type NetworkInfo struct {
+	Name              string
+	Driver            string
+	Subnets           []string
+	Containers        int  // attached to the network
+	Multicast         bool // the multicast and the broadcast go among the containers
+	MulticastSnooping bool // the bridge forwards the multicast only to the containers which joined the group
+	MulticastQuerier  bool // the bridge sends the IGMP and MLD queries
+
+	// RemainingIPs are the container ips the network can still hand out,
+	// and Utilization the percentage of those allocated
+	RemainingIPs int
+	Utilization  float64
+
+	// RemainingPorts are the host ports of the dynamic range still free on
+	// the default binding ip, by protocol
+	RemainingPorts map[string]int `json:",omitempty"`
+}
+
+// networkInfo returns the network of the driver: its bridge, or the slirp4netns
+// network of each container in rootless mode.
+func (d *Driver) networkInfo() NetworkInfo {
+	info := NetworkInfo{
+		Name:       d.bridgeIface,
+		Driver:     "bridge",
+		Containers: len(d.currentInterfaces.All()),
+		Multicast:  d.config != nil && d.config.Multicast,
+	}
+	if d.config.Rootless {
+		info.Name, info.Driver = "rootless", "rootless"
+	} else {
+		info.MulticastSnooping, info.MulticastQuerier = d.snoopingSettings()
+	}
+	if d.bridgeNetwork != nil {
+		subnet := &net.IPNet{IP: d.bridgeNetwork.IP.Mask(d.bridgeNetwork.Mask), Mask: d.bridgeNetwork.Mask}
+		info.Subnets = append(info.Subnets, subnet.String())
+
+		allocated, size := ipallocator.Usage(d.bridgeNetwork)
+		info.RemainingIPs = ipallocator.RemainingIPs(d.bridgeNetwork)
+		if size > 0 {
+			info.Utilization = float64(allocated) * 100 / float64(size)
+		}
+		info.RemainingPorts = make(map[string]int)
+		for _, proto := range []string{"tcp", "udp"} {
+			info.RemainingPorts[proto] = portallocator.RemainingPorts(d.getDefaultBindingIP(), proto)
+		}
+	}
+	return info
+}
+
+// ListNetworks is the job writing the networks of the driver, as a JSON
+// list of NetworkInfo.
+func (d *Driver) ListNetworks(job *engine.Job) engine.Status {
+	if err := json.NewEncoder(job.Stdout).Encode([]NetworkInfo{d.networkInfo()}); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+func TestListNetworks(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	if res := d.Allocate(eng.Job("allocate_interface", "networks_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+
+	var out bytes.Buffer
+	job := eng.Job("list_networks")
+	job.Stdout.Add(&out)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var networks []NetworkInfo
+	if err := json.Unmarshal(out.Bytes(), &networks); err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 {
+		t.Fatalf("Expected a single network, got %v", networks)
+	}
+	n := networks[0]
+	if n.Name != d.bridgeIface || n.Driver != "bridge" || n.Containers != 1 {
+		t.Fatalf("Unexpected network %+v", n)
+	}
+	if len(n.Subnets) != 1 {
+		t.Fatalf("Expected the subnet of the bridge, got %v", n.Subnets)
+	}
+	allocated, size := ipallocator.Usage(d.bridgeNetwork)
+	if n.RemainingIPs != size-allocated || n.Utilization != float64(allocated)*100/float64(size) || allocated == 0 {
+		t.Fatalf("Expected %d/%d ips to be used, got %d remaining and %v%%", allocated, size, n.RemainingIPs, n.Utilization)
+	}
+	if n.RemainingPorts["tcp"] != portallocator.RemainingPorts(nil, "tcp") || n.RemainingPorts["udp"] == 0 {
+		t.Fatalf("Expected the ports remaining on 0.0.0.0, got %v", n.RemainingPorts)
+	}
+	ip, subnet, err := net.ParseCIDR(n.Subnets[0])
+	if err != nil || !ip.Equal(subnet.IP) || !subnet.Contains(d.bridgeNetwork.IP) || subnet.String() != n.Subnets[0] {
+		t.Fatalf("Unexpected subnet %s of the bridge network %s", n.Subnets[0], d.bridgeNetwork)
+	}
+}
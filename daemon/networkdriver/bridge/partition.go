@@ -0,0 +1,149 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+// ipPair is an unordered pair of container ips.
+type ipPair [2]string
+
+func newIPPair(a, b string) ipPair {
+	if a > b {
+		a, b = b, a
+	}
+	return ipPair{a, b}
+}
+
+// partitions holds the pairs of containers that can't talk to each other,
+// along with the timer healing the partition if it is temporary.
+type partitions struct {
+	sync.Mutex
+	pairs map[ipPair]*time.Timer
+}
+
+func (d *Driver) partitionArgs(src, dst string) []string {
+	return []string{"FORWARD", "-i", d.bridgeIface, "-o", d.bridgeIface, "-s", src, "-d", dst, "-j", "DROP"}
+}
+
+// partition drops the traffic between two containers, in both directions.
+// The rules are inserted ahead of the link and icc ones.
+func (d *Driver) partition(p ipPair, expiry time.Duration) error {
+	d.partitions.Lock()
+	defer d.partitions.Unlock()
+
+	if timer, exists := d.partitions.pairs[p]; exists {
+		if timer != nil {
+			timer.Stop()
+		}
+	} else {
+		if err := d.execRule(false, append([]string{"-I"}, d.partitionArgs(p[0], p[1])...)...); err != nil {
+			return err
+		}
+		if err := d.execRule(false, append([]string{"-I"}, d.partitionArgs(p[1], p[0])...)...); err != nil {
+			d.firewall.Raw(false, append([]string{"-D"}, d.partitionArgs(p[0], p[1])...)...)
+			return err
+		}
+	}
+
+	var timer *time.Timer
+	if expiry > 0 {
+		timer = time.AfterFunc(expiry, func() {
+			log.Debugf("Partition between %s and %s expired", p[0], p[1])
+			d.healIf(func(q ipPair) bool { return q == p && d.partitions.pairs[q] == timer })
+		})
+	}
+	d.partitions.pairs[p] = timer
+	return nil
+}
+
+// healIf lets the traffic flow again between the pairs of containers for
+// which match returns true.
+func (d *Driver) healIf(match func(p ipPair) bool) {
+	d.partitions.Lock()
+	defer d.partitions.Unlock()
+
+	for p, timer := range d.partitions.pairs {
+		if !match(p) {
+			continue
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		d.firewall.Raw(false, append([]string{"-D"}, d.partitionArgs(p[0], p[1])...)...)
+		d.firewall.Raw(false, append([]string{"-D"}, d.partitionArgs(p[1], p[0])...)...)
+		delete(d.partitions.pairs, p)
+	}
+}
+
+// healContainer removes all the partitions involving the given ip.
+func (d *Driver) healContainer(ip net.IP) {
+	d.healIf(func(p ipPair) bool { return p[0] == ip.String() || p[1] == ip.String() })
+}
+
+// partitionPairs returns every pair of distinct ips of the list, once.
+func partitionPairs(ips []string) ([]ipPair, error) {
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+			return nil, fmt.Errorf("Bad parameter: invalid container ip %s", ip)
+		}
+	}
+	var (
+		pairs []ipPair
+		seen  = make(map[ipPair]bool)
+	)
+	for i := range ips {
+		for j := i + 1; j < len(ips); j++ {
+			p := newIPPair(ips[i], ips[j])
+			if ips[i] != ips[j] && !seen[p] {
+				pairs = append(pairs, p)
+				seen[p] = true
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// PartitionContainers cuts (action "-I") or restores (action "-D") the
+// traffic between every two containers of the "IPs" list, to test how
+// clustered software copes with network partitions. A partition is healed
+// automatically after "Duration" seconds if given.
+func (d *Driver) PartitionContainers(job *engine.Job) engine.Status {
+	var (
+		action   = job.Args[0]
+		duration = time.Duration(job.GetenvInt("Duration")) * time.Second
+	)
+
+	if !d.iptablesEnabled {
+		return job.Errorf("Network partitions require iptables to be enabled")
+	}
+	pairs, err := partitionPairs(job.GetenvList("IPs"))
+	if err != nil {
+		return job.Error(err)
+	}
+
+	switch action {
+	case "-I":
+		for _, p := range pairs {
+			if err := d.partition(p, duration); err != nil {
+				return job.Error(err)
+			}
+		}
+	case "-D":
+		d.healIf(func(q ipPair) bool {
+			for _, p := range pairs {
+				if q == p {
+					return true
+				}
+			}
+			return false
+		})
+	default:
+		return job.Errorf("Invalid partition action %s", action)
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,29 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestPartitionPairs(t *testing.T) {
+	pairs, err := partitionPairs([]string{"172.17.0.3", "172.17.0.2", "172.17.0.4", "172.17.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ipPair{
+		{"172.17.0.2", "172.17.0.3"},
+		{"172.17.0.3", "172.17.0.4"},
+		{"172.17.0.2", "172.17.0.4"},
+	}
+	if len(pairs) != len(expected) {
+		t.Fatalf("Expected %d pairs, got %v", len(expected), pairs)
+	}
+	for i, p := range pairs {
+		if p != expected[i] {
+			t.Fatalf("Expected pair %v, got %v", expected[i], p)
+		}
+	}
+
+	if _, err := partitionPairs([]string{"172.17.0.2", "db"}); err == nil {
+		t.Fatal("Expected invalid ip to be rejected")
+	}
+}
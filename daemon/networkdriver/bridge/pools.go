@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+// The use of the pools of the container ips and of the host ports is checked
+// at each allocation, for the operators to hear of a pool running out before
+// the containers fail to start: a warning is logged, and an event published,
+// each time a pool crosses one of the thresholds upwards.
+
+// defaultPoolThresholds are the percentages of a pool warned about when
+// none are configured.
+var defaultPoolThresholds = []int{80, 95}
+
+// poolWarnings holds the highest threshold each pool is past, by pool.
+type poolWarnings struct {
+	sync.Mutex
+	crossed map[string]int
+}
+
+// update records the percentage of the pool allocated, and returns the
+// threshold it crossed upwards since the last update, 0 if none. A pool
+// going back below a threshold is warned about again once it crosses it.
+func (w *poolWarnings) update(pool string, percentage float64, thresholds []int) int {
+	level := 0
+	for _, t := range thresholds {
+		if percentage >= float64(t) && t > level {
+			level = t
+		}
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.crossed == nil {
+		w.crossed = make(map[string]int)
+	}
+	previous := w.crossed[pool]
+	w.crossed[pool] = level
+	if level > previous {
+		return level
+	}
+	return 0
+}
+
+// checkPools warns about the pool of the container ips and those of the
+// host ports, on each host ip, past a threshold.
+func (d *Driver) checkPools(eng *engine.Engine) {
+	thresholds := d.config.PoolThresholds
+	if len(thresholds) == 0 {
+		thresholds = defaultPoolThresholds
+	}
+	if d.bridgeNetwork != nil {
+		if allocated, size := ipallocator.Usage(d.bridgeNetwork); size > 0 {
+			subnet := &net.IPNet{IP: d.bridgeNetwork.IP.Mask(d.bridgeNetwork.Mask), Mask: d.bridgeNetwork.Mask}
+			d.warnPool(eng, "ip", subnet.String(), float64(allocated)*100/float64(size), thresholds)
+		}
+	}
+	for _, proto := range []string{"tcp", "udp"} {
+		for ip, percentage := range portallocator.Utilization(proto) {
+			d.warnPool(eng, proto, ip, percentage, thresholds)
+		}
+	}
+}
+
+// warnPool warns about the pool of kind, "ip" or the protocol of the ports,
+// on network, the bridge network or the host ip, if it crossed a threshold.
+func (d *Driver) warnPool(eng *engine.Engine, kind, network string, percentage float64, thresholds []int) {
+	pool := kind + " " + network
+	t := d.poolWarnings.update(pool, percentage, thresholds)
+	if t == 0 {
+		return
+	}
+	if kind == "ip" {
+		log.Warnf("%.0f%% of the container ips of %s are allocated, past %d%%: the containers will fail to start once they all are, give the bridge a larger network with --bip or --fixed-cidr", percentage, network, t)
+	} else {
+		log.Warnf("%.0f%% of the %s host ports of the dynamic range are allocated on %s, past %d%%: the ports will fail to be published once they all are, widen the range with POST /network/config", percentage, kind, network, t)
+	}
+	if eng != nil {
+		d.logEvent(eng, eventPoolUsage, "", fmt.Sprintf("%s %.0f%%", pool, percentage))
+	}
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+func TestPoolWarnings(t *testing.T) {
+	var (
+		w          poolWarnings
+		thresholds = []int{80, 95}
+	)
+	for _, test := range []struct {
+		percentage float64
+		crossed    int
+	}{
+		{50, 0},
+		{80, 80},
+		{90, 0},
+		{99, 95},
+		{100, 0},
+		// Warned about again once it went back below
+		{70, 0},
+		{85, 80},
+	} {
+		if crossed := w.update("ip 10.8.0.0/24", test.percentage, thresholds); crossed != test.crossed {
+			t.Fatalf("Expected %v%% to cross %d, got %d", test.percentage, test.crossed, crossed)
+		}
+	}
+}
+
+func TestCheckPools(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	var events []string
+	eng.Register("log", func(job *engine.Job) engine.Status {
+		events = append(events, strings.Join(job.Args, " "))
+		return engine.StatusOK
+	})
+	defer portallocator.ReleaseAll()
+
+	// 10.8.0.0/29 hands out 5 ips
+	network := &net.IPNet{IP: net.ParseIP("10.8.0.1").To4(), Mask: net.CIDRMask(29, 32)}
+	d := &Driver{bridgeNetwork: network, config: &Config{PoolThresholds: []int{50}}}
+	var ips []net.IP
+	defer func() {
+		for _, ip := range ips {
+			ipallocator.ReleaseIP(network, ip)
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		ip, err := ipallocator.RequestIP(network, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ips = append(ips, ip)
+		d.checkPools(eng)
+	}
+	if len(events) != 1 || events[0] != "net:pool-usage  ip 10.8.0.0/29 60%" {
+		t.Fatalf("Expected the ip pool to be warned about once, got %q", events)
+	}
+
+	begin, end := portallocator.PortRange()
+	ip := net.ParseIP("127.0.0.1")
+	for port := begin; port < begin+(end-begin+1)/2+1; port++ {
+		if _, err := portallocator.RequestPort(ip, "tcp", port); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.checkPools(eng)
+	if len(events) != 2 || !strings.HasPrefix(events[1], "net:pool-usage  tcp 127.0.0.1 50") {
+		t.Fatalf("Expected the tcp ports of 127.0.0.1 to be warned about, got %q", events)
+	}
+}
@@ -0,0 +1,93 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// With a port conflict policy, a host port requested which is taken, by
+// another container or by a service of the host, is substituted by the
+// next free one rather than failing the start of the container: the ports
+// above it with "next", the ports of a set such as "8000-8100,9000" in the
+// order given otherwise. The port published is returned in place of the
+// one requested, which is returned as RequestedHostPort.
+const portConflictNext = "next"
+
+type conflictPolicy struct {
+	next   bool
+	ranges [][2]int // ports of the set, the bounds included
+}
+
+func parseConflictPolicy(spec string) (*conflictPolicy, error) {
+	if spec == portConflictNext {
+		return &conflictPolicy{next: true}, nil
+	}
+	p := &conflictPolicy{}
+	for _, part := range strings.Split(spec, ",") {
+		var (
+			begin, end int
+			err        error
+		)
+		if strings.Contains(part, "-") {
+			begin, end, err = parsePortRange(part)
+		} else {
+			begin, err = strconv.Atoi(part)
+			end = begin
+		}
+		if err != nil || begin < 1 || end > 65535 || begin > end {
+			return nil, fmt.Errorf("Invalid port conflict policy %s, it must be %s or a set of ports (ex: 8000-8100,9000)", spec, portConflictNext)
+		}
+		p.ranges = append(p.ranges, [2]int{begin, end})
+	}
+	return p, nil
+}
+
+// candidates returns the ports tried in order in place of hostPort.
+func (p *conflictPolicy) candidates(hostPort int) []int {
+	var ports []int
+	if p.next {
+		for port := hostPort + 1; port <= 65535; port++ {
+			ports = append(ports, port)
+		}
+		return ports
+	}
+	for _, r := range p.ranges {
+		for port := r[0]; port <= r[1]; port++ {
+			if port != hostPort {
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// portTaken tells whether mapping a port failed for it being taken, by
+// another mapping or by another process listening on it.
+func portTaken(err error) bool {
+	if _, ok := err.(portallocator.ErrPortAlreadyAllocated); ok {
+		return true
+	}
+	return strings.Contains(err.Error(), "address already in use")
+}
+
+// resolvePortConflict maps container on the first free port of the policy,
+// hostPort being taken.
+func (d *Driver) resolvePortConflict(job *engine.Job, firewall portmapper.Firewall, container net.Addr, ip net.IP, hostPort int, noTrack bool) (net.Addr, error) {
+	for _, port := range d.portConflict.candidates(hostPort) {
+		host, err := portmapper.MapOnChain(firewall, container, ip, port, noTrack)
+		if err == nil {
+			job.Logf("The host port %d is taken, publishing %s on %d instead", hostPort, container, port)
+			return host, nil
+		}
+		if !portTaken(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("The host port %d and the ports to publish in its place are all taken", hostPort)
+}
@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// Nat is a port mapping of a container, as the driver set it up: the host
+// address the port is published on, and the address of the container the
+// traffic goes to.
+type Nat struct {
+	Proto         string
+	HostIP        net.IP
+	HostPort      int
+	ContainerIP   net.IP
+	ContainerPort int
+	Name          string            `json:",omitempty"`
+	Labels        map[string]string `json:",omitempty"`
+}
+
+// portMappings returns the port mappings of iface, read from the state of
+// the driver rather than from the firewall.
+func (d *Driver) portMappings(iface *networkInterface) []Nat {
+	nats := []Nat{}
+	for _, host := range d.hostMappings(iface) {
+		state, err := portmapper.Lookup(host)
+		if err != nil {
+			continue
+		}
+		p := mappedHostPort(host)
+		n := Nat{
+			Proto:       p.Proto,
+			HostIP:      hostAddrIP(host),
+			HostPort:    p.Port,
+			ContainerIP: iface.IP,
+			Name:        state.Name,
+			Labels:      state.Labels,
+		}
+		if _, port, err := net.SplitHostPort(state.Container); err == nil {
+			n.ContainerPort, _ = strconv.Atoi(port)
+		}
+		nats = append(nats, n)
+	}
+	for _, f := range iface.Forwards {
+		nats = append(nats, Nat{
+			Proto:         f.Proto,
+			HostIP:        f.HostIP,
+			HostPort:      f.HostPort,
+			ContainerIP:   iface.IP,
+			ContainerPort: f.ContainerPort,
+		})
+	}
+	return nats
+}
+
+// PortMappings is the job writing the port mappings of a container, as a
+// JSON list of Nat.
+func (d *Driver) PortMappings(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	iface := d.currentInterfaces.Get(id)
+	if iface == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if err := json.NewEncoder(job.Stdout).Encode(d.portMappings(iface)); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
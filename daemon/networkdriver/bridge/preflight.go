@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+var (
+	// sysModuleDir lists the modules loaded into the kernel, and the
+	// built-in ones having parameters.
+	sysModuleDir = "/sys/module"
+	// modulesDir holds the modules of each kernel release.
+	modulesDir = "/lib/modules"
+	// modprobe is the command loading a module.
+	modprobe = "modprobe"
+)
+
+// kernelModules returns the kernel modules the network needs with config.
+func kernelModules(config *Config) []string {
+	modules := []string{"bridge", "veth"}
+	if config.EnableIptables {
+		modules = append(modules, "nf_nat", "xt_addrtype")
+		if config.UseIpv6 {
+			modules = append(modules, "ip6_tables")
+		}
+	}
+	return modules
+}
+
+// checkKernel makes sure the kernel has the features the network needs,
+// loading the missing modules, so that the daemon fails at startup with all
+// of them instead of halfway through the setup with the first one.
+func (d *Driver) checkKernel(config *Config) error {
+	var missing []string
+	for _, module := range kernelModules(config) {
+		if err := d.loadModule(module); err != nil {
+			missing = append(missing, fmt.Sprintf("%s (%s)", module, err))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("The kernel lacks features the network needs: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// loadModule makes sure the kernel module name is loaded or built in.
+func (d *Driver) loadModule(name string) error {
+	if _, err := os.Stat(path.Join(sysModuleDir, name)); err == nil {
+		return nil
+	}
+	dir := releaseModulesDir()
+	if builtinModule(dir, name) {
+		return nil
+	}
+	if _, err := exec.LookPath(modprobe); err != nil {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			// Without modules nor modprobe the kernel is likely built
+			// with everything in, the setup tells if it isn't
+			log.Debugf("Can't tell if the kernel has %s, no modules in %s", name, dir)
+			return nil
+		}
+	}
+	log.Infof("Loading the kernel module %s", name)
+	return d.runCommand(modprobe, name)
+}
+
+// releaseModulesDir returns the directory of the modules of the running
+// kernel.
+func releaseModulesDir() string {
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return modulesDir
+	}
+	return path.Join(modulesDir, strings.TrimSpace(string(release)))
+}
+
+// builtinModule tells whether the module name is built into the kernel
+// whose modules are in dir.
+func builtinModule(dir, name string) bool {
+	f, err := os.Open(path.Join(dir, "modules.builtin"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		file := path.Base(strings.TrimSpace(scanner.Text()))
+		if strings.Replace(strings.TrimSuffix(file, ".ko"), "-", "_", -1) == name {
+			return true
+		}
+	}
+	return false
+}
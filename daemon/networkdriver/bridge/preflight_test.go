@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCheckKernel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-kernel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(sys, modules, cmd string) {
+		sysModuleDir, modulesDir, modprobe = sys, modules, cmd
+	}(sysModuleDir, modulesDir, modprobe)
+	sysModuleDir = path.Join(dir, "sys")
+	modulesDir = path.Join(dir, "modules")
+	modprobe = "false"
+	d := newDriver(&Config{})
+
+	if err := os.MkdirAll(path.Join(sysModuleDir, "bridge"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	release := releaseModulesDir()
+	if err := os.MkdirAll(release, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(release, "modules.builtin"), []byte("kernel/drivers/net/veth.ko\nkernel/net/netfilter/xt_addrtype.ko\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.checkKernel(&Config{}); err != nil {
+		t.Fatal(err)
+	}
+	err = d.checkKernel(&Config{EnableIptables: true, UseIpv6: true})
+	if err == nil {
+		t.Fatal("Expected the missing modules to be reported")
+	}
+	for _, module := range []string{"nf_nat (", "ip6_tables ("} {
+		if !strings.Contains(err.Error(), module) {
+			t.Fatalf("Expected %s to be missing in %s", module, err)
+		}
+	}
+	if strings.Contains(err.Error(), "xt_addrtype") {
+		t.Fatalf("Expected the built-in xt_addrtype to be found: %s", err)
+	}
+}
@@ -0,0 +1,61 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/engine"
+)
+
+// The ports a container publishes and the secondary addresses it is given
+// can be limited, for a container gone wrong not to take the whole range of
+// the host ports or the whole bridge network from the others. The limits of
+// the daemon apply to the containers given none of their own.
+
+// setQuotas reads the limits of the container from the allocate_interface
+// job, 0 for those of the daemon.
+func setQuotas(iface *networkInterface, job *engine.Job) error {
+	iface.MaxPorts = job.GetenvInt("MaxPorts")
+	iface.MaxAddresses = job.GetenvInt("MaxAddresses")
+	if iface.MaxPorts < 0 || iface.MaxAddresses < 0 {
+		return fmt.Errorf("Invalid limit on the ports or the addresses of the container, it must be positive")
+	}
+	return nil
+}
+
+// portLimit returns the number of ports the container can publish, 0 if
+// unlimited.
+func (d *Driver) portLimit(iface *networkInterface) int {
+	if iface.MaxPorts > 0 {
+		return iface.MaxPorts
+	}
+	return d.config.MaxPorts
+}
+
+// addressLimit returns the number of secondary addresses the container can
+// have, 0 if unlimited.
+func (d *Driver) addressLimit(iface *networkInterface) int {
+	if iface.MaxAddresses > 0 {
+		return iface.MaxAddresses
+	}
+	return d.config.MaxAddresses
+}
+
+// reservePort counts a port the container is publishing against its limit,
+// the ports of a container being published concurrently, until
+// unreservePort once the mapping is set up or failed.
+func (d *Driver) reservePort(id string, iface *networkInterface) error {
+	limit := d.portLimit(iface)
+	d.portsLock.Lock()
+	defer d.portsLock.Unlock()
+	if limit > 0 && len(iface.PortMappings)+len(iface.Forwards)+iface.portsStarting >= limit {
+		return fmt.Errorf("%s can't publish more than %d ports", id, limit)
+	}
+	iface.portsStarting++
+	return nil
+}
+
+func (d *Driver) unreservePort(iface *networkInterface) {
+	d.portsLock.Lock()
+	iface.portsStarting--
+	d.portsLock.Unlock()
+}
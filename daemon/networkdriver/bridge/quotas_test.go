@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func publishQuotaPort(eng *engine.Engine, id string) error {
+	job := eng.Job("allocate_port", id)
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("Proto", "tcp")
+	job.SetenvInt("ContainerPort", 80)
+	return job.Run()
+}
+
+func TestPortQuota(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	d.config.MaxPorts = 1
+
+	if err := eng.Job("allocate_interface", "quota_default").Run(); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("allocate_interface", "quota_own")
+	job.SetenvInt("MaxPorts", 2)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Job("release_interface", "quota_default").Run()
+	defer eng.Job("release_interface", "quota_own").Run()
+
+	// The limit of the daemon applies to the container given none
+	if err := publishQuotaPort(eng, "quota_default"); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishQuotaPort(eng, "quota_default"); err == nil || !strings.Contains(err.Error(), "more than 1 ports") {
+		t.Fatalf("Expected the port over the limit to be refused, got %v", err)
+	}
+
+	// The container given a limit of its own goes past the one of the daemon
+	for i := 0; i < 2; i++ {
+		if err := publishQuotaPort(eng, "quota_own"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := publishQuotaPort(eng, "quota_own"); err == nil {
+		t.Fatal("Expected the port over the limit of the container to be refused")
+	}
+	if iface := d.currentInterfaces.Get("quota_own"); len(iface.PortMappings) != 2 || iface.portsStarting != 0 {
+		t.Fatalf("Expected 2 ports published and none pending, got %d and %d", len(iface.PortMappings), iface.portsStarting)
+	}
+
+	job = eng.Job("allocate_interface", "quota_invalid")
+	job.SetenvInt("MaxPorts", -1)
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected a negative limit to be refused")
+	}
+}
+
+func TestAddressQuota(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	d.config.MaxAddresses = 2
+
+	job := eng.Job("allocate_interface", "quota_addresses")
+	job.SetenvInt("MaxAddresses", 1)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Job("release_interface", "quota_addresses").Run()
+
+	if err := eng.Job("add_address", "quota_addresses").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("add_address", "quota_addresses").Run(); err == nil || !strings.Contains(err.Error(), "more than 1 secondary addresses") {
+		t.Fatalf("Expected the address over the limit to be refused, got %v", err)
+	}
+}
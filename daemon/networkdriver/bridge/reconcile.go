@@ -0,0 +1,285 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/libcontainer/netlink"
+)
+
+// reconcile compares the network the driver set up with the one of the
+// kernel and repairs the drift: the bridge being down or having lost its
+// address and, with iptables, the firewall rules removed by someone else,
+// such as by `iptables -F` or a reload of the firewall by a configuration
+// tool. The rules of the port mappings and those the driver keeps for the
+// containers are reinstalled too, but not those of the links, which belong
+// to the daemon.
+func (d *Driver) reconcile() error {
+	err := d.reconcileBridge()
+	if d.iptablesEnabled {
+		if ferr := d.reconcileFirewall(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+func (d *Driver) reconcileBridge() error {
+	iface, err := net.InterfaceByName(d.bridgeIface)
+	if err != nil {
+		// The interfaces of the containers went away along with the bridge
+		return fmt.Errorf("Bridge %s is gone: %s", d.bridgeIface, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		log.Warnf("Bridge %s is down, bringing it up", d.bridgeIface)
+		err := d.linkChange(func() error {
+			return netlink.NetworkLinkUp(iface)
+		}, "link", "set", d.bridgeIface, "up")
+		d.changes.record("ip", []string{"link", "set", d.bridgeIface, "up"}, err)
+		if err != nil {
+			return err
+		}
+		d.repaired(d.bridgeIface, "link up")
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(d.bridgeNetwork.IP) {
+			return nil
+		}
+	}
+	log.Warnf("Bridge %s lost its address %s, adding it back", d.bridgeIface, d.bridgeNetwork)
+	network := &net.IPNet{IP: d.bridgeNetwork.IP.Mask(d.bridgeNetwork.Mask), Mask: d.bridgeNetwork.Mask}
+	err = d.linkChange(func() error {
+		return netlink.NetworkLinkAddIp(iface, d.bridgeNetwork.IP, network)
+	}, "addr", "add", d.bridgeNetwork.String(), "dev", d.bridgeIface)
+	d.changes.record("ip", []string{"addr", "add", d.bridgeNetwork.String(), "dev", d.bridgeIface}, err)
+	if err != nil {
+		return err
+	}
+	d.repaired(d.bridgeIface, "address "+d.bridgeNetwork.String())
+	return nil
+}
+
+// reconcileFirewall reinstalls the rules of the bridge, the chain of the
+// port mappings along with its jumps, and the rules of the port mappings
+// which went missing. Those of the containers are checked as well once any
+// of them did.
+func (d *Driver) reconcileFirewall() error {
+	var (
+		config = d.config
+		ipv6   = IsIpv6(d.bridgeNetwork)
+	)
+	chainExists := d.portChain.Exists()
+	flushed := !chainExists || !d.firewall.Exists(ipv6, d.outgoingArgs()...)
+	if flushed {
+		log.Warnf("The firewall rules of %s are missing, reinstalling them", d.bridgeIface)
+		if err := d.setupIPTables(d.bridgeNetwork, config.InterContainerCommunication, config.EnableIpMasq, config.IpMasqSource); err != nil {
+			return err
+		}
+		if err := d.setupLinkLocalBlock(config.BlockMetadata); err != nil {
+			return err
+		}
+		if err := d.setupIcmp(ipv6, config.AllowIcmp); err != nil {
+			return err
+		}
+		if err := d.setupMulticast(ipv6, config.Multicast); err != nil {
+			return err
+		}
+		if err := d.setupNetworkDscp(config.Dscp); err != nil {
+			return err
+		}
+		d.repaired(d.bridgeIface, "iptables")
+	}
+	if !chainExists {
+		// Drop the jumps left, if any, before setting up the chain again
+		d.firewall.RemoveExistingChain(config.UseIpv6, d.chain)
+		if _, err := d.firewall.NewChain(config.UseIpv6, d.chain, d.bridgeIface, config.PublishIfaces); err != nil {
+			return err
+		}
+	}
+
+	repaired, err := portmapper.Reinstall(d.portChain)
+	if flushed || len(repaired) > 0 {
+		if cerr := d.reconcileContainers(); err == nil {
+			err = cerr
+		}
+	}
+	if len(repaired) == 0 {
+		return err
+	}
+	owners := make(map[string]string)
+	for id, iface := range d.currentInterfaces.All() {
+		for _, host := range d.hostMappings(iface) {
+			owners[host.String()] = id
+		}
+	}
+	for _, m := range repaired {
+		log.WithField("container", owners[m.Host]).Warnf("Reinstalled the firewall rules of the port mapping %s/%s", m.Proto, m.Host)
+		d.repaired(owners[m.Host], fmt.Sprintf("%s/%s->%s", m.Proto, m.Host, m.Container))
+	}
+	return err
+}
+
+// reconcileContainers reinstalls the rules the driver keeps for the
+// containers which went missing: the host access and accounting chains, the
+// egress policies, the link-local exemptions, the outbound addresses, the
+// DSCP markings and, last for them to stay ahead of the others, the drains,
+// blackholes and partitions.
+func (d *Driver) reconcileContainers() error {
+	ifaces := d.currentInterfaces.All()
+	if d.protectHost && !d.firewall.Exists(false, d.hostAccessJumpArgs()...) {
+		shared, err := parseHostPorts(d.config.HostAccess)
+		if err != nil {
+			return err
+		}
+		for _, iface := range ifaces {
+			for _, host := range d.hostMappings(iface) {
+				shared = append(shared, mappedHostPort(host))
+			}
+		}
+		if err := d.setupHostAccess(shared); err != nil {
+			return err
+		}
+		d.repaired(d.bridgeIface, "host access")
+	}
+	if d.accountingSetUp() && !d.firewall.Exists(false, d.accountingJumps()[0]...) {
+		if err := d.setupAccounting(); err != nil {
+			return err
+		}
+		for _, iface := range ifaces {
+			if !iface.Accounted {
+				continue
+			}
+			if err := d.startAccounting(iface.IP); err != nil {
+				return err
+			}
+		}
+		d.repaired(d.bridgeIface, "accounting")
+	}
+
+	for id, iface := range ifaces {
+		if iface.EgressPolicy != nil && !d.firewall.Exists(iface.IP.To4() == nil, d.egressJumpArgs(iface.IP)...) {
+			if err := d.applyEgressPolicy(iface.IP, iface.EgressPolicy); err != nil {
+				return err
+			}
+			d.repaired(id, "egress policy")
+		}
+		if iface.LinkLocalAllowed {
+			if err := d.ensureRule(false, id, "link-local exemption", "-I", d.linkLocalExemptArgs(iface.IP)); err != nil {
+				return err
+			}
+		}
+		if iface.SnatIP != nil {
+			if err := d.ensureRule(false, id, "SNAT "+iface.SnatIP.String(), "-I", d.snatArgs(iface.IP, iface.SnatIP)); err != nil {
+				return err
+			}
+		}
+		if iface.Dscp != "" {
+			target, err := parseDscp(iface.Dscp)
+			if err != nil {
+				return err
+			}
+			if err := d.ensureRule(false, id, "DSCP "+iface.Dscp, "-A", d.dscpArgs(iface.IP, target)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for id, iface := range ifaces {
+		for _, ip := range append([]net.IP{iface.IP}, iface.SecondaryIPs...) {
+			if iface.Draining {
+				if err := d.ensureRule(ip.To4() == nil, id, "drain "+ip.String(), "-I", drainArgs(ip)); err != nil {
+					return err
+				}
+			}
+			if !iface.Blackholed {
+				continue
+			}
+			for _, args := range blackholeArgs(ip) {
+				if err := d.ensureRule(ip.To4() == nil, id, "blackhole "+ip.String(), "-I", args); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	d.partitions.Lock()
+	defer d.partitions.Unlock()
+	for p := range d.partitions.pairs {
+		for _, args := range [][]string{d.partitionArgs(p[0], p[1]), d.partitionArgs(p[1], p[0])} {
+			if err := d.ensureRule(false, d.bridgeIface, "partition "+p[0]+" "+p[1], "-I", args); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureRule inserts (action "-I") or appends (action "-A") the rule of args
+// unless it exists, and counts the repair on behalf of id.
+func (d *Driver) ensureRule(ipv6 bool, id, detail, action string, args []string) error {
+	if d.firewall.Exists(ipv6, args...) {
+		return nil
+	}
+	if err := d.execRule(ipv6, append([]string{action}, args...)...); err != nil {
+		return err
+	}
+	d.repaired(id, detail)
+	return nil
+}
+
+// repaired counts a repair and publishes it as a net:repair event, on behalf
+// of the container concerned or of the bridge.
+func (d *Driver) repaired(id, detail string) {
+	atomic.AddUint64(&d.repairs, 1)
+	if d.eng != nil {
+		d.logEvent(d.eng, eventRepair, id, detail)
+	}
+}
+
+// startReconcile reconciles the network every interval, and as soon as the
+// route of the bridge goes away, until stopReconcile is called.
+func (d *Driver) startReconcile(interval time.Duration) {
+	stop := make(chan struct{})
+	d.reconcileStop = stop
+	now := make(chan struct{}, 1)
+	if err := watchRoutes(stop, func(c *routeChange) {
+		if d.routeChanged(c) {
+			select {
+			case now <- struct{}{}:
+			default:
+			}
+		}
+	}); err != nil {
+		log.Infof("Unable to watch the routes, the network of %s is reconciled every %s only: %s", d.bridgeIface, interval, err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-now:
+			}
+			if err := d.reconcile(); err != nil {
+				log.Errorf("Unable to reconcile the network of %s: %s", d.bridgeIface, err)
+			}
+		}
+	}()
+}
+
+func (d *Driver) stopReconcile() {
+	if d.reconcileStop != nil {
+		close(d.reconcileStop)
+		d.reconcileStop = nil
+	}
+}
@@ -0,0 +1,128 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/libcontainer/netlink"
+)
+
+func TestReconcileBridge(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	var events []string
+	eng.Register("log", func(job *engine.Job) engine.Status {
+		events = append(events, strings.Join(job.Args, " "))
+		return engine.StatusOK
+	})
+	d := initTestDriver(t, eng)
+
+	iface, err := net.InterfaceByName(d.bridgeIface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.NetworkLinkDown(iface); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if iface, err = net.InterfaceByName(d.bridgeIface); err != nil {
+		t.Fatal(err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		t.Fatal("Expected the bridge to be brought back up")
+	}
+	if len(events) != 1 || events[0] != "net:repair "+d.bridgeIface+" link up" {
+		t.Fatalf("Expected the repair to be published, got %v", events)
+	}
+
+	// Nothing is left to repair
+	events = nil
+	if err := d.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected no repair, got %v", events)
+	}
+}
+
+func TestReconcileFirewall(t *testing.T) {
+	d := newDriver(&Config{EnableIptables: true, InterContainerCommunication: true})
+	_, d.bridgeNetwork, _ = net.ParseCIDR("172.17.42.1/16")
+
+	// Repair the rules in dry-run mode to find out what is reinstalled
+	dryRunDriver(d)
+	d.portChain, _ = d.firewall.NewChain(false, d.chain, d.bridgeIface, nil)
+	d.changes.planned = nil
+
+	// Without iptables to check them, the rules are all missing
+	if err := d.reconcileFirewall(); err != nil {
+		t.Fatal(err)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, rule := range []string{
+		"-t nat -N DOCKER",
+		"-I FORWARD -i docker0 ! -o docker0 -j ACCEPT",
+	} {
+		if !strings.Contains(plan, rule) {
+			t.Fatalf("Expected %q to be reinstalled, got:\n%s", rule, plan)
+		}
+	}
+}
+
+func TestReconcileFirewallContainers(t *testing.T) {
+	d := newDriver(&Config{EnableIptables: true, InterContainerCommunication: true, ProtectHost: true, HostAccess: []string{"53/udp"}})
+	_, d.bridgeNetwork, _ = net.ParseCIDR("172.17.42.1/16")
+	d.protectHost = true
+	d.accountingReady = true
+
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+	d.currentInterfaces.Set("a", &networkInterface{
+		IP:               net.ParseIP("172.17.0.2"),
+		Accounted:        true,
+		EgressPolicy:     []*egressRule{{Network: allowed}},
+		LinkLocalAllowed: true,
+		SnatIP:           net.ParseIP("192.0.2.10"),
+		Dscp:             "EF",
+	})
+	d.currentInterfaces.Set("b", &networkInterface{
+		IP:         net.ParseIP("172.17.0.3"),
+		Blackholed: true,
+		Draining:   true,
+	})
+	d.partitions.pairs[newIPPair("172.17.0.2", "172.17.0.4")] = nil
+	dryRunDriver(d)
+	d.portChain, _ = d.firewall.NewChain(false, d.chain, d.bridgeIface, nil)
+	d.changes.planned = nil
+
+	// As after an `iptables -F`, none of the rules are there
+	if err := d.reconcileFirewall(); err != nil {
+		t.Fatal(err)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, rule := range []string{
+		"-I INPUT -i docker0 -j DOCKER-INPUT",
+		"-A DOCKER-INPUT -p udp --dport 53 -j ACCEPT",
+		"-I FORWARD -i docker0 -j DOCKER-ACCT",
+		"-A DOCKER-ACCT -s 172.17.0.2",
+		"-I FORWARD -i docker0 -s 172.17.0.2 -j " + d.egressChainName(net.ParseIP("172.17.0.2")),
+		"-I FORWARD -i docker0 -s 172.17.0.2 -d 169.254.0.0/16 -j ACCEPT",
+		"-I POSTROUTING -t nat -s 172.17.0.2 ! -o docker0 -j SNAT --to-source 192.0.2.10",
+		"-A PREROUTING -t mangle -i docker0 -s 172.17.0.2 -j DSCP --set-dscp-class EF",
+		"-I FORWARD -d 172.17.0.3 -m conntrack --ctstate NEW -j REJECT",
+		"-I FORWARD -s 172.17.0.3 -j DROP",
+		"-I FORWARD -i docker0 -o docker0 -s 172.17.0.2 -d 172.17.0.4 -j DROP",
+		"-I FORWARD -i docker0 -o docker0 -s 172.17.0.4 -d 172.17.0.2 -j DROP",
+	} {
+		if !strings.Contains(plan, rule) {
+			t.Fatalf("Expected %q to be reinstalled, got:\n%s", rule, plan)
+		}
+	}
+	if strings.Contains(plan, "172.17.0.3 -j "+d.egressChainName(net.ParseIP("172.17.0.3"))) {
+		t.Fatalf("Expected no egress policy for the unrestricted container, got:\n%s", plan)
+	}
+}
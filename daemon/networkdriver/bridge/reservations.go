@@ -0,0 +1,171 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/engine"
+)
+
+// A container run with StickyIP keeps its ip from one start to the next: the
+// ip is reserved to the container the first time it is allocated, and stays
+// taken from the pool while the container is stopped and across restarts of
+// the daemon, until the container is removed and its reservation released.
+const reservationsName = "network-reservations.json"
+
+type reservations struct {
+	sync.Mutex
+	path string
+	ips  map[string]string // reserved ips, by container id
+}
+
+// loadReservations reads the reservations of a previous run, and keeps
+// saving them to path.
+func (d *Driver) loadReservations(path string) error {
+	d.reservations.Lock()
+	defer d.reservations.Unlock()
+
+	d.reservations.path = path
+	d.reservations.ips = make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &d.reservations.ips)
+}
+
+func (d *Driver) writeReservations() {
+	if d.reservations.path == "" {
+		return
+	}
+	data, err := json.Marshal(d.reservations.ips)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(d.reservations.path+".tmp", data, 0600); err != nil {
+		log.Errorf("Unable to save the ip reservations: %s", err)
+		return
+	}
+	if err := os.Rename(d.reservations.path+".tmp", d.reservations.path); err != nil {
+		log.Errorf("Unable to save the ip reservations: %s", err)
+	}
+}
+
+// holdReservations takes the reserved ips from the pool, for no other
+// container to be given them while theirs are stopped. The reservations out
+// of the network, which changed since they were made, are dropped.
+func (d *Driver) holdReservations() {
+	d.reservations.Lock()
+	defer d.reservations.Unlock()
+
+	changed := false
+	for id, reserved := range d.reservations.ips {
+		ip := net.ParseIP(reserved)
+		if ip == nil || !d.bridgeNetwork.Contains(ip) {
+			log.Infof("Dropping the reservation of %s for %s, out of the network %s", reserved, id, d.bridgeNetwork)
+			delete(d.reservations.ips, id)
+			changed = true
+			continue
+		}
+		if _, err := ipallocator.RequestIP(d.bridgeNetwork, ip); err != nil && err != ipallocator.ErrIPAlreadyAllocated {
+			log.Infof("Unable to hold the ip %s reserved for %s: %s", ip, id, err)
+		}
+	}
+	if changed {
+		d.writeReservations()
+	}
+}
+
+// reservedIP returns the ip reserved for the container id, nil if none.
+func (d *Driver) reservedIP(id string) net.IP {
+	d.reservations.Lock()
+	defer d.reservations.Unlock()
+
+	return net.ParseIP(d.reservations.ips[id])
+}
+
+// isReserved returns whether ip is reserved for a container.
+func (d *Driver) isReserved(ip net.IP) bool {
+	d.reservations.Lock()
+	defer d.reservations.Unlock()
+
+	for _, reserved := range d.reservations.ips {
+		if ip.Equal(net.ParseIP(reserved)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Driver) reserveIP(id string, ip net.IP) {
+	d.reservations.Lock()
+	defer d.reservations.Unlock()
+
+	d.reservations.ips[id] = ip.String()
+	d.writeReservations()
+}
+
+// allocateStickyIP returns the ip reserved for the container id, held already,
+// or allocates one the usual way and reserves it.
+func (d *Driver) allocateStickyIP(id string, requestedIP net.IP, ipRange *net.IPNet) (net.IP, error) {
+	if ip := d.reservedIP(id); ip != nil {
+		if requestedIP != nil && !requestedIP.Equal(ip) {
+			return nil, fmt.Errorf("The ip %s is reserved for %s, it can't be given %s", ip, id, requestedIP)
+		}
+		if ipRange != nil && !ipRange.Contains(ip) {
+			return nil, fmt.Errorf("The ip %s reserved for %s is out of the range %s of the network policy", ip, id, ipRange)
+		}
+		return ip, nil
+	}
+	ip, err := d.requestIP(requestedIP, ipRange)
+	if err != nil {
+		return nil, err
+	}
+	d.reserveIP(id, ip)
+	return ip, nil
+}
+
+// requestIP allocates requestedIP if any, an ip of ipRange if any, or any
+// ip of the network.
+func (d *Driver) requestIP(requestedIP net.IP, ipRange *net.IPNet) (net.IP, error) {
+	switch {
+	case requestedIP != nil:
+		return ipallocator.RequestIP(d.bridgeNetwork, requestedIP)
+	case ipRange != nil:
+		return ipallocator.RequestIPInRange(d.bridgeNetwork, ipRange)
+	default:
+		return ipallocator.RequestIP(d.bridgeNetwork, nil)
+	}
+}
+
+// ReleaseReservation gives the ip reserved for a container back to the pool,
+// once the container is removed. The ip of a running container is released
+// along with its interface.
+func (d *Driver) ReleaseReservation(job *engine.Job) engine.Status {
+	id := job.Args[0]
+
+	d.reservations.Lock()
+	defer d.reservations.Unlock()
+
+	reserved, exists := d.reservations.ips[id]
+	if !exists {
+		return engine.StatusOK
+	}
+	delete(d.reservations.ips, id)
+	d.writeReservations()
+	if d.currentInterfaces.Get(id) != nil {
+		return engine.StatusOK
+	}
+	if err := ipallocator.ReleaseIP(d.bridgeNetwork, net.ParseIP(reserved)); err != nil {
+		return job.Errorf("Unable to release the ip %s reserved for %s: %s", reserved, id, err)
+	}
+	return engine.StatusOK
+}
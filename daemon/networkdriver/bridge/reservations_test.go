@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/engine"
+)
+
+func TestStickyIP(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+
+	allocate := func(id string, sticky bool, requested string) (string, error) {
+		job := eng.Job("allocate_interface", id)
+		job.SetenvBool("StickyIP", sticky)
+		job.Setenv("RequestedIP", requested)
+		out, err := job.Stdout.AddEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = job.Run()
+		return out.Get("IP"), err
+	}
+
+	ip, err := allocate("sticky_container", true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := d.Release(eng.Job("release_interface", "sticky_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+	if _, err := allocate("other_container", false, ip); err == nil {
+		t.Fatalf("Expected the ip %s reserved for the stopped container to be refused to another", ip)
+	}
+	if again, err := allocate("sticky_container", true, ""); err != nil || again != ip {
+		t.Fatalf("Expected the container to get %s back, got %s", ip, again)
+	}
+	if _, err := allocate("sticky_container", true, "172.16.42.250"); err == nil {
+		t.Fatal("Expected another ip to be refused to the container having one reserved")
+	}
+
+	// The reservation of a running container goes with its interface
+	if res := d.ReleaseReservation(eng.Job("release_reservation", "sticky_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release the reservation")
+	}
+	if res := d.Release(eng.Job("release_interface", "sticky_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+	if other, err := allocate("other_container", false, ip); err != nil || other != ip {
+		t.Fatalf("Expected the ip %s to be free once the reservation is released, got %s", ip, other)
+	}
+	d.Release(eng.Job("release_interface", "other_container"))
+}
+
+func TestHoldReservations(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+
+	root, err := ioutil.TempDir("", "docker-reservations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	p := path.Join(root, reservationsName)
+
+	ip := d.bridgeNetwork.IP.To4()
+	reserved := net.IP{ip[0], ip[1], ip[2], ip[3] + 200}
+	if err := ioutil.WriteFile(p, []byte(`{"held":"`+reserved.String()+`","moved":"192.0.2.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.loadReservations(p); err != nil {
+		t.Fatal(err)
+	}
+	d.holdReservations()
+	defer ipallocator.ReleaseIP(d.bridgeNetwork, reserved)
+
+	if _, err := ipallocator.RequestIP(d.bridgeNetwork, reserved); err != ipallocator.ErrIPAlreadyAllocated {
+		t.Fatalf("Expected the reserved ip to be held, got %v", err)
+	}
+	if d.reservedIP("moved") != nil {
+		t.Fatal("Expected the reservation out of the network to be dropped")
+	}
+
+	if err := d.loadReservations(p); err != nil {
+		t.Fatal(err)
+	}
+	if d.reservedIP("moved") != nil || d.reservedIP("held") == nil {
+		t.Fatalf("Expected the reservations to be saved, got %v", d.reservations.ips)
+	}
+}
@@ -0,0 +1,165 @@
+package bridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/engine"
+)
+
+// The jobs which set up the interfaces are saved, by container, so that the
+// interfaces can be rebuilt after a restart of the daemon, with the same ip,
+// mac address, host ports and settings.
+const savedInterfacesName = "network-interfaces.json"
+
+type savedJob struct {
+	Name string
+	Env  map[string]string
+}
+
+type savedInterfaces struct {
+	sync.Mutex
+	path string
+	jobs map[string][]savedJob
+}
+
+// loadSavedInterfaces reads the jobs saved by a previous run, and keeps
+// saving them to path.
+func (d *Driver) loadSavedInterfaces(path string) error {
+	d.saved.Lock()
+	defer d.saved.Unlock()
+
+	d.saved.path = path
+	d.saved.jobs = make(map[string][]savedJob)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &d.saved.jobs)
+}
+
+func (d *Driver) writeSavedInterfaces() {
+	if d.saved.path == "" {
+		return
+	}
+	data, err := json.Marshal(d.saved.jobs)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(d.saved.path+".tmp", data, 0600); err != nil {
+		log.Errorf("Unable to save the network interfaces: %s", err)
+		return
+	}
+	if err := os.Rename(d.saved.path+".tmp", d.saved.path); err != nil {
+		log.Errorf("Unable to save the network interfaces: %s", err)
+	}
+}
+
+// saveJob records a job run for the interface of a container. Allocating the
+// interface starts over, the ports and the secondary addresses add up and
+// the settings replace the previous ones.
+func (d *Driver) saveJob(id, name string, env map[string]string) {
+	d.saved.Lock()
+	defer d.saved.Unlock()
+
+	jobs := d.saved.jobs[id]
+	switch name {
+	case "allocate_interface":
+		jobs = nil
+	case "allocate_port", "add_address":
+	default:
+		for i, j := range jobs {
+			if j.Name == name {
+				jobs = append(jobs[:i], jobs[i+1:]...)
+				break
+			}
+		}
+	}
+	d.saved.jobs[id] = append(jobs, savedJob{Name: name, Env: env})
+	d.writeSavedInterfaces()
+	d.writeMappingsFile()
+}
+
+func (d *Driver) forgetSavedJobs(id string) {
+	d.saved.Lock()
+	defer d.saved.Unlock()
+
+	if _, exists := d.saved.jobs[id]; exists {
+		delete(d.saved.jobs, id)
+		d.writeSavedInterfaces()
+	}
+	d.writeMappingsFile()
+}
+
+// RestoreInterface rebuilds the interface of a container as it was before
+// the daemon restarted: the ip and mac address leases, the port mappings on
+// the same host ports along with their userland proxies, and the settings
+// changed since the allocation. It writes the same output as
+// allocate_interface.
+func (d *Driver) RestoreInterface(job *engine.Job) engine.Status {
+	id := job.Args[0]
+
+	d.saved.Lock()
+	jobs := append([]savedJob{}, d.saved.jobs[id]...)
+	d.saved.Unlock()
+
+	if len(jobs) == 0 || jobs[0].Name != "allocate_interface" {
+		return job.Errorf("No saved network information for %s", id)
+	}
+	if d.currentInterfaces.Get(id) != nil {
+		return job.Errorf("The network interface of %s is already set up", id)
+	}
+
+	for i, j := range jobs {
+		restore := job.Eng.Job(j.Name, id)
+		for key, value := range j.Env {
+			restore.Setenv(key, value)
+		}
+		if i == 0 {
+			restore.Stdout.Add(job.Stdout)
+		}
+		if err := restore.Run(); err != nil {
+			if i > 0 {
+				job.Eng.Job("release_interface", id).Run()
+			}
+			return job.Errorf("Unable to restore the network interface of %s: %s", id, err)
+		}
+	}
+	return engine.StatusOK
+}
+
+// PruneInterfaces forgets the saved interfaces of the containers which are
+// not in the Keep list and have no interface set up, such as the containers
+// removed while the daemon was down or left stopped by its restart. The taps
+// are kept, they go by their own lifecycle.
+func (d *Driver) PruneInterfaces(job *engine.Job) engine.Status {
+	keep := make(map[string]bool)
+	for _, id := range job.GetenvList("Keep") {
+		keep[id] = true
+	}
+
+	d.saved.Lock()
+	defer d.saved.Unlock()
+
+	pruned := false
+	for id, jobs := range d.saved.jobs {
+		if keep[id] || d.currentInterfaces.Get(id) != nil {
+			continue
+		}
+		if len(jobs) > 0 && jobs[0].Env["Tap"] != "" {
+			continue
+		}
+		delete(d.saved.jobs, id)
+		pruned = true
+	}
+	if pruned {
+		d.writeSavedInterfaces()
+		d.writeMappingsFile()
+	}
+	return engine.StatusOK
+}
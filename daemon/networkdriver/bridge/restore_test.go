@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestRestoreInterface(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	if res := d.Allocate(eng.Job("allocate_interface", "restore_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	ip := d.currentInterfaces.Get("restore_container").IP
+
+	port := strconv.Itoa(findFreePort(t))
+	job := eng.Job("allocate_port", "restore_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", port)
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	hostPort := out.GetInt("HostPort")
+
+	// Tear the interface down as a restart of the daemon would
+	d.releaseInterface(d.currentInterfaces.Get("restore_container"))
+	d.currentInterfaces.Delete("restore_container")
+
+	job = eng.Job("restore_interface", "restore_container")
+	if out, err = job.Stdout.AddEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("IP") != ip.String() {
+		t.Fatalf("Expected the interface to get %s back, got %s", ip, out.Get("IP"))
+	}
+	restored := d.currentInterfaces.Get("restore_container")
+	if len(restored.PortMappings) != 1 || restored.PortMappings[0].String() != "127.0.0.1:"+strconv.Itoa(hostPort) {
+		t.Fatalf("Expected the port mapping on 127.0.0.1:%d to be restored, got %v", hostPort, restored.PortMappings)
+	}
+
+	if res := d.Release(eng.Job("release_interface", "restore_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+	if res := d.RestoreInterface(eng.Job("restore_interface", "restore_container")); res == engine.StatusOK {
+		t.Fatal("Expected the saved interface to be forgotten once released")
+	}
+}
+
+func TestPruneInterfaces(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	for _, id := range []string{"running_container", "reserved_container", "removed_container"} {
+		if res := d.Allocate(eng.Job("allocate_interface", id)); res != engine.StatusOK {
+			t.Fatalf("Failed to allocate the network interface of %s", id)
+		}
+	}
+	defer d.Release(eng.Job("release_interface", "running_container"))
+
+	// Tear the interfaces down as a restart of the daemon would
+	for _, id := range []string{"reserved_container", "removed_container"} {
+		d.releaseInterface(d.currentInterfaces.Get(id))
+		d.currentInterfaces.Delete(id)
+	}
+
+	job := eng.Job("prune_interfaces")
+	job.SetenvList("Keep", []string{"reserved_container"})
+	if res := d.PruneInterfaces(job); res != engine.StatusOK {
+		t.Fatal("Failed to prune the saved interfaces")
+	}
+
+	d.saved.Lock()
+	_, running := d.saved.jobs["running_container"]
+	_, reserved := d.saved.jobs["reserved_container"]
+	_, removed := d.saved.jobs["removed_container"]
+	d.saved.Unlock()
+	if !running || !reserved {
+		t.Fatal("Expected the interfaces set up or kept to stay saved")
+	}
+	if removed {
+		t.Fatal("Expected the interface of the removed container to be forgotten")
+	}
+}
@@ -0,0 +1,322 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+// In rootless mode each container has a network of its own, set up in its
+// namespace by slirp4netns, a userspace NAT needing no privileges. The
+// addresses are those slirp4netns configures.
+const (
+	slirpNetwork = "10.0.2.0/24"
+	slirpIP      = "10.0.2.100"
+	slirpGateway = "10.0.2.2"
+	slirpDevice  = "tap0"
+	slirpMtu     = 65520
+	slirpTimeout = 10 * time.Second
+)
+
+// slirpCommand is the userspace NAT of the containers in rootless mode.
+var slirpCommand = "slirp4netns"
+
+// rootlessUnsupported are the settings of the containers needing the
+// privileges the rootless mode does without.
+var rootlessUnsupported = []string{
+	"AllowLinkLocal", "SnatGroup", "SnatIP", "Dscp",
+	"EgressDevice", "EgressGateway", "IngressRate", "EgressRate", "Mtu",
+	"Sysctls", "NetDevices", "Tap",
+}
+
+// slirpForward is a published port of a container in rootless mode,
+// forwarded by slirp4netns once the container runs.
+type slirpForward struct {
+	Proto         string
+	HostIP        net.IP
+	HostPort      int
+	ContainerPort int
+}
+
+// slirp is the userspace NAT of a running container.
+type slirp struct {
+	sync.Mutex
+	cmd    *exec.Cmd
+	socket string // path of the api socket
+}
+
+func (d *Driver) setupRootless() error {
+	_, d.bridgeNetwork, _ = net.ParseCIDR(slirpNetwork)
+	d.bridgeNetwork.IP = net.ParseIP(slirpGateway).To4()
+	d.bridgeIface = ""
+	return nil
+}
+
+// allocateRootless gives the container the addresses of its slirp4netns
+// network. The containers don't share a network, they all have the same.
+func (d *Driver) allocateRootless(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	for _, key := range rootlessUnsupported {
+		if job.Getenv(key) != "" {
+			return job.Errorf("%s isn't available with the rootless network", key)
+		}
+	}
+	// Restored containers ask for the address they had
+	if requested := job.Getenv("RequestedIP"); requested != "" && requested != slirpIP {
+		return job.Errorf("The rootless network can't give the address %s", requested)
+	}
+
+	ip := net.ParseIP(slirpIP)
+	mac, err := net.ParseMAC(job.Getenv("RequestedMac"))
+	if err != nil {
+		mac = networkdriver.GenerateMacAddr(ip)
+	}
+	out := engine.Env{}
+	out.Set("IP", ip.String())
+	out.Set("Mask", d.bridgeNetwork.Mask.String())
+	out.Set("Gateway", slirpGateway)
+	out.Set("MacAddress", mac.String())
+	out.Set("Bridge", "")
+	size, _ := d.bridgeNetwork.Mask.Size()
+	out.SetInt("IPPrefixLen", size)
+
+	iface := &networkInterface{IP: ip}
+	if err := setQuotas(iface, job); err != nil {
+		return job.Error(err)
+	}
+	d.currentInterfaces.Set(id, iface)
+	d.logEvent(job.Eng, eventAllocate, id, ip.String())
+	d.saveJob(id, "allocate_interface", job.Environ())
+	out.WriteTo(job.Stdout)
+	return engine.StatusOK
+}
+
+// allocateRootlessPort reserves the host port of a mapping, forwarded by
+// slirp4netns rather than by iptables and the userland proxy.
+func (d *Driver) allocateRootlessPort(job *engine.Job, iface *networkInterface, ip net.IP) engine.Status {
+	var (
+		id            = job.Args[0]
+		proto         = job.Getenv("Proto")
+		containerPort = job.GetenvInt("ContainerPort")
+	)
+	if proto != "tcp" && proto != "udp" {
+		return job.Errorf("unsupported address type %s", proto)
+	}
+	hostPort, err := portallocator.RequestPort(ip, proto, job.GetenvInt("HostPort"))
+	if err != nil {
+		return job.Error(err)
+	}
+	f := &slirpForward{Proto: proto, HostIP: ip, HostPort: hostPort, ContainerPort: containerPort}
+	if iface.Slirp != nil {
+		if err := iface.Slirp.forward(f); err != nil {
+			portallocator.ReleasePort(ip, proto, hostPort)
+			return job.Error(err)
+		}
+	}
+	iface.Forwards = append(iface.Forwards, f)
+	d.logEvent(job.Eng, eventMap, id, fmt.Sprintf("%s/%s:%d->%s:%d", proto, ip, hostPort, iface.IP, containerPort))
+
+	out := engine.Env{}
+	out.Set("HostIP", ip.String())
+	out.SetInt("HostPort", hostPort)
+	env := job.Environ()
+	env["HostIP"] = out.Get("HostIP")
+	env["HostPort"] = out.Get("HostPort")
+	d.saveJob(id, "allocate_port", env)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// releaseRootless stops the userspace NAT of the container and frees its
+// host ports.
+func (d *Driver) releaseRootless(iface *networkInterface) {
+	if iface.Slirp != nil {
+		iface.Slirp.stop()
+		iface.Slirp = nil
+	}
+	for _, f := range iface.Forwards {
+		if err := portallocator.ReleasePort(f.HostIP, f.Proto, f.HostPort); err != nil {
+			log.Infof("Unable to release port %s/%d: %s", f.Proto, f.HostPort, err)
+		}
+	}
+	iface.Forwards = nil
+}
+
+// AttachInterface completes the network of a container once it runs, with
+// the pid of its process in Pid: the host side of its veth pair gets the MTU
+// of the bridge back, the secondary addresses of its interface and its
+// kernel parameters are set in its network namespace and the physical
+// interfaces given to it are moved there, or the userspace NAT is set up
+// there for the rootless network. It writes the path of the namespace as
+// NetnsPath.
+func (d *Driver) AttachInterface(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	iface := d.currentInterfaces.Get(id)
+	if !d.config.Rootless {
+		if iface == nil {
+			return engine.StatusOK
+		}
+		if err := d.restoreVethMtu(iface); err != nil {
+			return job.Error(err)
+		}
+		if err := d.attachSecondaryAddresses(iface, job.GetenvInt("Pid")); err != nil {
+			return job.Error(err)
+		}
+		if err := d.setSysctls(iface, iface.Pid); err != nil {
+			return job.Error(err)
+		}
+		if err := d.attachNetDevices(iface, iface.Pid); err != nil {
+			return job.Error(err)
+		}
+		d.announceAddresses(iface, iface.Pid)
+		return d.writeNetns(job, iface)
+	}
+	if iface == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+
+	// The namespace of a restarted container is a new one
+	if iface.Slirp != nil {
+		iface.Slirp.stop()
+	}
+	s, err := startSlirp(job.GetenvInt("Pid"), d.slirpSocket(id))
+	if err != nil {
+		return job.Error(err)
+	}
+	for _, f := range iface.Forwards {
+		if err := s.forward(f); err != nil {
+			s.stop()
+			return job.Error(err)
+		}
+	}
+	iface.Slirp = s
+	return d.writeNetns(job, iface)
+}
+
+// writeNetns names the network namespace of the container attached, and
+// writes its path as NetnsPath.
+func (d *Driver) writeNetns(job *engine.Job, iface *networkInterface) engine.Status {
+	p, err := d.nameNetns(iface, job.Args[0], job.GetenvInt("Pid"))
+	if err != nil {
+		return job.Error(err)
+	}
+	out := engine.Env{}
+	out.Set("NetnsPath", p)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// slirpSocket returns the path of the api socket of the userspace NAT of a
+// container, short enough for a unix socket.
+func (d *Driver) slirpSocket(id string) string {
+	dir := os.TempDir()
+	if d.config.Root != "" {
+		dir = path.Join(d.config.Root, "network-slirp")
+	}
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return path.Join(dir, id+".sock")
+}
+
+// startSlirp starts slirp4netns in the network namespace of pid and waits
+// for it to have configured the interface of the container.
+func startSlirp(pid int, socket string) (*slirp, error) {
+	if err := os.MkdirAll(path.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	os.Remove(socket)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command(slirpCommand, "--configure", "--mtu", strconv.Itoa(slirpMtu),
+		"--disable-host-loopback", "--ready-fd", "3", "--api-socket", socket,
+		strconv.Itoa(pid), slirpDevice)
+	cmd.ExtraFiles = []*os.File{w}
+	log.Debugf("%s %v", slirpCommand, cmd.Args[1:])
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start %s: %s", slirpCommand, err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	s := &slirp{cmd: cmd, socket: socket}
+	select {
+	case err = <-ready:
+	case <-time.After(slirpTimeout):
+		err = fmt.Errorf("timed out")
+	}
+	if err != nil {
+		s.stop()
+		return nil, fmt.Errorf("%s didn't set up the network of %d: %s", slirpCommand, pid, err)
+	}
+	return s, nil
+}
+
+// forward asks slirp4netns to forward a host port to the container.
+func (s *slirp) forward(f *slirpForward) error {
+	s.Lock()
+	defer s.Unlock()
+
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	request := map[string]interface{}{
+		"execute": "add_hostfwd",
+		"arguments": map[string]interface{}{
+			"proto":      f.Proto,
+			"host_addr":  f.HostIP.String(),
+			"host_port":  f.HostPort,
+			"guest_addr": slirpIP,
+			"guest_port": f.ContainerPort,
+		},
+	}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return err
+	}
+	conn.(*net.UnixConn).CloseWrite()
+
+	var response struct {
+		Error *struct {
+			Desc string `json:"desc"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("Unable to forward %s/%s:%d: %s", f.Proto, f.HostIP, f.HostPort, response.Error.Desc)
+	}
+	return nil
+}
+
+func (s *slirp) stop() {
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	os.Remove(s.socket)
+}
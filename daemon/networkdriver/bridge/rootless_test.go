@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+func TestRootless(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	if err := (&Config{Rootless: true, EnableIptables: true}).validate(); err == nil {
+		t.Fatal("Expected the rootless network to refuse iptables")
+	}
+	d, err := NewDriver(&Config{Rootless: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+
+	job := eng.Job("allocate_interface", "container_id")
+	job.Setenv("Dscp", "af21")
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected DSCP marking to be refused")
+	}
+
+	job = eng.Job("allocate_interface", "container_id")
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("IP") != slirpIP || out.Get("Gateway") != slirpGateway || out.Get("Bridge") != "" {
+		t.Fatalf("Expected the slirp4netns addresses, got %v", out)
+	}
+
+	freePort := findFreePort(t)
+	if err := newPortAllocationJob(eng, freePort).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if forwards := d.currentInterfaces.Get("container_id").Forwards; len(forwards) != 1 || forwards[0].HostPort != freePort {
+		t.Fatalf("Expected the port to be forwarded once the container runs, got %v", forwards)
+	}
+
+	// The userspace NAT can't start
+	defer func(cmd string) { slirpCommand = cmd }(slirpCommand)
+	slirpCommand = "false"
+	job = eng.Job("attach_interface", "container_id")
+	job.SetenvInt("Pid", 1)
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected the failure of slirp4netns to be reported")
+	}
+
+	if err := eng.Job("release_interface", "container_id").Run(); err != nil {
+		t.Fatal(err)
+	}
+	hostIP := net.ParseIP("127.0.0.1")
+	if _, err := portallocator.RequestPort(hostIP, "tcp", freePort); err != nil {
+		t.Fatalf("Expected the host port to be released: %s", err)
+	}
+	portallocator.ReleasePort(hostIP, "tcp", freePort)
+}
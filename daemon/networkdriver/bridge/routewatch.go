@@ -0,0 +1,144 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver"
+)
+
+// While the network is reconciled, the routes of the host are watched
+// through netlink rather than read once: the network is reconciled right
+// away when the route of the bridge goes away, rather than at the next
+// period, and the routes added over the network of the bridge, such as
+// those of a VPN, are reported as they come.
+
+// The multicast groups of the route changes, which syscall doesn't define
+const (
+	rtmgrpIpv4Route = 0x40
+	rtmgrpIpv6Route = 0x400
+)
+
+// routeChange is a route added to or deleted from the kernel.
+type routeChange struct {
+	Deleted bool
+	Dst     *net.IPNet // nil for a default route
+	Oif     int        // index of the interface the route goes out of, 0 if none
+	Table   int
+}
+
+// parseRouteChange returns the route change of a netlink message, false for
+// the other messages.
+func parseRouteChange(m syscall.NetlinkMessage) (*routeChange, bool) {
+	if m.Header.Type != syscall.RTM_NEWROUTE && m.Header.Type != syscall.RTM_DELROUTE {
+		return nil, false
+	}
+	if len(m.Data) < syscall.SizeofRtMsg {
+		return nil, false
+	}
+	// struct rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type
+	dstLen, table := int(m.Data[1]), int(m.Data[4])
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return nil, false
+	}
+
+	c := &routeChange{Deleted: m.Header.Type == syscall.RTM_DELROUTE, Table: table}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.RTA_DST:
+			ip := net.IP(attr.Value)
+			c.Dst = &net.IPNet{IP: ip, Mask: net.CIDRMask(dstLen, len(ip)*8)}
+		case syscall.RTA_OIF:
+			if len(attr.Value) >= 4 {
+				c.Oif = int(nativeEndian.Uint32(attr.Value))
+			}
+		case rtaTable:
+			// The tables past 255 are only in the attribute
+			if len(attr.Value) >= 4 {
+				c.Table = int(nativeEndian.Uint32(attr.Value))
+			}
+		}
+	}
+	return c, true
+}
+
+// watchRoutes calls f with the changes of the routes of the host, until
+// stop is closed.
+func watchRoutes(stop <-chan struct{}, f func(*routeChange)) error {
+	s, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	groups := rtmgrpIpv4Route | rtmgrpIpv6Route
+	if err := syscall.Bind(s, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: uint32(groups)}); err != nil {
+		syscall.Close(s)
+		return err
+	}
+	// Woken up every second to see whether to stop
+	tv := syscall.NsecToTimeval(int64(time.Second))
+	if err := syscall.SetsockoptTimeval(s, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(s)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(s)
+		buf := make([]byte, syscall.Getpagesize())
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n, _, err := syscall.Recvfrom(s, buf, 0)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Errorf("Unable to watch the routes: %s", err)
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if c, ok := parseRouteChange(m); ok {
+					f(c)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// routeChanged reports the routes of the main table added over the network
+// of the bridge, but those within the ignored prefixes, and returns whether the network must be reconciled, the
+// route of the bridge having gone away.
+func (d *Driver) routeChanged(c *routeChange) bool {
+	if c.Table != syscall.RT_TABLE_MAIN || c.Dst == nil {
+		return false
+	}
+	bridge, err := net.InterfaceByName(d.bridgeIface)
+	if err != nil {
+		return true
+	}
+	if c.Oif == bridge.Index {
+		return c.Deleted && c.Dst.Contains(d.bridgeNetwork.IP)
+	}
+	if c.Deleted || !networkdriver.NetworkOverlaps(c.Dst, d.bridgeNetwork) || networkdriver.WithinAny(c.Dst, d.config.IgnoredRoutes) {
+		return false
+	}
+	via := "no interface"
+	if iface, err := net.InterfaceByIndex(c.Oif); err == nil {
+		via = iface.Name
+	}
+	log.Warnf("The route to %s on %s overlaps the network %s of %s, the containers might not reach it", c.Dst, via, d.bridgeNetwork, d.bridgeIface)
+	if d.eng != nil {
+		d.logEvent(d.eng, eventRouteOverlap, "", fmt.Sprintf("%s %s", c.Dst, via))
+	}
+	return false
+}
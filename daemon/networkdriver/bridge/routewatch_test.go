@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestParseRouteChange(t *testing.T) {
+	body := make([]byte, syscall.SizeofRtMsg)
+	body[0] = syscall.AF_INET
+	body[1] = 24
+	body[4] = syscall.RT_TABLE_MAIN
+	body = append(body, rtAttr(syscall.RTA_DST, []byte{10, 8, 0, 0})...)
+	body = append(body, uint32Attr(syscall.RTA_OIF, 7)...)
+
+	m := syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_DELROUTE}, Data: body}
+	c, ok := parseRouteChange(m)
+	if !ok {
+		t.Fatal("Expected a route change")
+	}
+	if !c.Deleted || c.Dst.String() != "10.8.0.0/24" || c.Oif != 7 || c.Table != syscall.RT_TABLE_MAIN {
+		t.Fatalf("Unexpected route change %+v", c)
+	}
+
+	m.Header.Type = syscall.RTM_NEWADDR
+	if _, ok := parseRouteChange(m); ok {
+		t.Fatal("Expected the address change to be ignored")
+	}
+}
+
+func TestRouteChanged(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	var events []string
+	eng.Register("log", func(job *engine.Job) engine.Status {
+		events = append(events, strings.Join(job.Args, " "))
+		return engine.StatusOK
+	})
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("No loopback interface")
+	}
+	_, bridgeRoute, _ := net.ParseCIDR("127.0.0.0/8")
+	_, overlapping, _ := net.ParseCIDR("127.1.0.0/16")
+	_, elsewhere, _ := net.ParseCIDR("10.8.0.0/24")
+	_, ignored, _ := net.ParseCIDR("127.128.0.0/9")
+	d := &Driver{
+		bridgeIface:   "lo",
+		bridgeNetwork: &net.IPNet{IP: net.ParseIP("127.0.0.1").To4(), Mask: net.CIDRMask(8, 32)},
+		eng:           eng,
+		config:        &Config{IgnoredRoutes: []*net.IPNet{ignored}},
+	}
+
+	for _, test := range []struct {
+		change    routeChange
+		reconcile bool
+	}{
+		// The route of the bridge went away
+		{routeChange{Deleted: true, Dst: bridgeRoute, Oif: lo.Index, Table: syscall.RT_TABLE_MAIN}, true},
+		{routeChange{Dst: bridgeRoute, Oif: lo.Index, Table: syscall.RT_TABLE_MAIN}, false},
+		{routeChange{Dst: overlapping, Table: syscall.RT_TABLE_MAIN}, false},
+		{routeChange{Dst: elsewhere, Table: syscall.RT_TABLE_MAIN}, false},
+		{routeChange{Dst: ignored, Table: syscall.RT_TABLE_MAIN}, false},
+		{routeChange{Deleted: true, Dst: bridgeRoute, Oif: lo.Index, Table: syscall.RT_TABLE_LOCAL}, false},
+	} {
+		change := test.change
+		if reconcile := d.routeChanged(&change); reconcile != test.reconcile {
+			t.Fatalf("Expected the reconciliation to be %v for %+v", test.reconcile, change)
+		}
+	}
+	if len(events) != 1 || events[0] != "net:route-overlap  127.1.0.0/16 no interface" {
+		t.Fatalf("Expected the overlapping route to be reported, got %q", events)
+	}
+}
@@ -0,0 +1,166 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/docker/docker/engine"
+)
+
+// routingPolicy steers the egress traffic of a container through a routing
+// table of its own. The traffic is marked with the table number and an
+// `ip rule` sends marked packets to that table.
+type routingPolicy struct {
+	Table   int
+	Gateway net.IP // default gateway installed in the table, if any
+	Device  string // device of the default route installed in the table, if any
+}
+
+// policyTables counts the containers routed through each table, so the ip
+// rule of a table is only removed along with its last container.
+type policyTables struct {
+	sync.Mutex
+	refs map[int]int
+}
+
+func (d *Driver) policyMarkArgs(ip net.IP, table int) []string {
+	return []string{"PREROUTING", "-t", "mangle", "-i", d.bridgeIface, "-s", ip.String(), "-j", "MARK", "--set-mark", strconv.Itoa(table)}
+}
+
+// setupPolicyTable installs the rule and routes of a table when its first
+// container joins it.
+func (d *Driver) setupPolicyTable(p *routingPolicy) error {
+	d.policyTables.Lock()
+	defer d.policyTables.Unlock()
+
+	table := strconv.Itoa(p.Table)
+	if d.policyTables.refs[p.Table] == 0 {
+		if err := d.runIp("rule", "add", "fwmark", table, "table", table); err != nil {
+			return err
+		}
+	}
+	if err := d.setupPolicyRoutes(p); err != nil {
+		if d.policyTables.refs[p.Table] == 0 {
+			d.runIp("rule", "del", "fwmark", table, "table", table)
+		}
+		return err
+	}
+	d.policyTables.refs[p.Table]++
+	return nil
+}
+
+func (d *Driver) setupPolicyRoutes(p *routingPolicy) error {
+	table := strconv.Itoa(p.Table)
+	// Containers can still reach each other and the host through the bridge
+	if err := d.runIp("route", "replace", d.bridgeNetwork.String(), "dev", d.bridgeIface, "table", table); err != nil {
+		return err
+	}
+	if p.Gateway != nil || p.Device != "" {
+		args := []string{"route", "replace", "default"}
+		if p.Gateway != nil {
+			args = append(args, "via", p.Gateway.String())
+		}
+		if p.Device != "" {
+			args = append(args, "dev", p.Device)
+		}
+		return d.runIp(append(args, "table", table)...)
+	}
+	return nil
+}
+
+// releasePolicyTable removes the rule of a table once its last container
+// left it. The routes are left alone, the table may be managed by the admin.
+func (d *Driver) releasePolicyTable(p *routingPolicy) {
+	d.policyTables.Lock()
+	defer d.policyTables.Unlock()
+
+	if d.policyTables.refs[p.Table]--; d.policyTables.refs[p.Table] > 0 {
+		return
+	}
+	delete(d.policyTables.refs, p.Table)
+	table := strconv.Itoa(p.Table)
+	if err := d.runIp("rule", "del", "fwmark", table, "table", table); err != nil {
+		log.Infof("Unable to remove routing rule for table %s: %s", table, err)
+	}
+}
+
+// applyRoutingPolicy routes the traffic of the container with the given ip
+// according to p.
+func (d *Driver) applyRoutingPolicy(ip net.IP, p *routingPolicy) error {
+	if err := d.setupPolicyTable(p); err != nil {
+		return err
+	}
+	if err := d.execRule(false, append([]string{"-A"}, d.policyMarkArgs(ip, p.Table)...)...); err != nil {
+		d.releasePolicyTable(p)
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) removeRoutingPolicy(ip net.IP, p *routingPolicy) {
+	d.firewall.Raw(false, append([]string{"-D"}, d.policyMarkArgs(ip, p.Table)...)...)
+	d.releasePolicyTable(p)
+}
+
+// parseRoutingPolicy builds a policy out of the Table, Gateway and Device
+// variables of a job. It returns nil if no table is given.
+func (d *Driver) parseRoutingPolicy(job *engine.Job) (*routingPolicy, error) {
+	if !job.EnvExists("Table") || job.Getenv("Table") == "" {
+		return nil, nil
+	}
+	p := &routingPolicy{
+		Table:  job.GetenvInt("Table"),
+		Device: job.Getenv("Device"),
+	}
+	// Leave the local, main and default tables alone, as well as the uplink ones
+	if p.Table <= 0 || p.Table > maxUplinkTable {
+		return nil, fmt.Errorf("Invalid routing table %s: must be between 1 and %d", job.Getenv("Table"), maxUplinkTable)
+	}
+	if p.Table >= d.firstUplinkTable && p.Table <= d.lastUplinkTable {
+		return nil, fmt.Errorf("Invalid routing table %s: tables %d to %d are those of the uplinks", job.Getenv("Table"), d.firstUplinkTable, d.lastUplinkTable)
+	}
+	if gw := job.Getenv("Gateway"); gw != "" {
+		if p.Gateway = net.ParseIP(gw); p.Gateway == nil {
+			return nil, fmt.Errorf("Bad parameter: invalid gateway %s", gw)
+		}
+	}
+	return p, nil
+}
+
+// SetRoutingPolicy routes the egress traffic of a container through the
+// routing table given in "Table", optionally pointing its default route at
+// "Gateway" and/or "Device" (a VPN tunnel for instance). An empty table
+// puts the container back on the main routing table.
+func (d *Driver) SetRoutingPolicy(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	if !d.iptablesEnabled {
+		return job.Errorf("Routing policies require iptables to be enabled")
+	}
+
+	p, err := d.parseRoutingPolicy(job)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	if network.RoutingPolicy != nil {
+		d.removeRoutingPolicy(network.IP, network.RoutingPolicy)
+		network.RoutingPolicy = nil
+	}
+	if p != nil {
+		if err := d.applyRoutingPolicy(network.IP, p); err != nil {
+			return job.Error(err)
+		}
+		network.RoutingPolicy = p
+	}
+	d.saveJob(id, job.Name, job.Environ())
+	return engine.StatusOK
+}
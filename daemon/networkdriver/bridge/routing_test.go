@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestParseRoutingPolicy(t *testing.T) {
+	eng := engine.New()
+	d := newDriver(&Config{})
+
+	job := eng.Job("set_routing_policy", "container_id")
+	if p, err := d.parseRoutingPolicy(job); err != nil || p != nil {
+		t.Fatalf("Expected no policy, got %v (%v)", p, err)
+	}
+
+	job.Setenv("Table", "100")
+	job.Setenv("Gateway", "10.8.0.1")
+	job.Setenv("Device", "tun0")
+	p, err := d.parseRoutingPolicy(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Table != 100 || !p.Gateway.Equal(net.ParseIP("10.8.0.1")) || p.Device != "tun0" {
+		t.Fatalf("Unexpected policy %+v", p)
+	}
+
+	for _, table := range []string{"0", "200", "253", "254", "255", "-1"} {
+		job.Setenv("Table", table)
+		if _, err := d.parseRoutingPolicy(job); err == nil {
+			t.Fatalf("Expected table %s to be rejected", table)
+		}
+	}
+
+	// The tables of the uplinks move along with their range
+	d = newDriver(&Config{UplinkTables: "100-120"})
+	job.Setenv("Table", "100")
+	if _, err := d.parseRoutingPolicy(job); err == nil {
+		t.Fatal("Expected a table of the uplinks to be rejected")
+	}
+	job.Setenv("Table", "200")
+	if _, err := d.parseRoutingPolicy(job); err != nil {
+		t.Fatal(err)
+	}
+
+	job.Setenv("Table", "150")
+	job.Setenv("Gateway", "vpn")
+	if _, err := d.parseRoutingPolicy(job); err == nil {
+		t.Fatal("Expected invalid gateway to be rejected")
+	}
+}
+
+func TestParseUplink(t *testing.T) {
+	if u, err := parseUplink("", ""); err != nil || u != nil {
+		t.Fatalf("Expected no uplink, got %v (%v)", u, err)
+	}
+	u, err := parseUplink("lo", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Device != "lo" || !u.Gateway.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("Unexpected uplink %+v", u)
+	}
+	if _, err := parseUplink("", "gateway"); err == nil {
+		t.Fatal("Expected invalid gateway to be rejected")
+	}
+	if _, err := parseUplink("nonexistent0", ""); err == nil {
+		t.Fatal("Expected unknown device to be rejected")
+	}
+}
+
+func TestParseTableRange(t *testing.T) {
+	first, last, err := parseTableRange("100-120")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 100 || last != 120 {
+		t.Fatalf("Expected 100-120, got %d-%d", first, last)
+	}
+	for _, r := range []string{"", "200", "a-b", "0-10", "120-100", "200-253", "200-255"} {
+		if _, _, err := parseTableRange(r); err == nil {
+			t.Fatalf("Expected range %q to be rejected", r)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+)
+
+// Several containers, of the same host, can back a service behind a single
+// virtual ip of the bridge network, for a primitive high availability of
+// the stateless services. The VIP is an address of the bridge whose
+// traffic is DNATed to a single backend, the active one. It stays active
+// for as long as its checks pass, and once they fail the traffic fails
+// over to the healthiest backend left: the one with the fewest failed
+// checks, then the fastest to answer, then the first to join. The backends
+// are checked by connecting to their check port, those without one being
+// always healthy.
+const (
+	serviceCheckInterval = 2 * time.Second
+	serviceCheckTimeout  = time.Second
+
+	// Consecutive failed checks after which a backend is down
+	serviceMaxFailures = 3
+)
+
+type serviceBackend struct {
+	ID        string
+	IP        net.IP
+	CheckPort int           // TCP port connected to by the checks, 0 for none
+	Failures  int           // consecutive failed checks
+	Latency   time.Duration // of the last check passed
+}
+
+func (b *serviceBackend) healthy() bool {
+	return b.Failures < serviceMaxFailures
+}
+
+// better tells whether b is healthier than other.
+func (b *serviceBackend) better(other *serviceBackend) bool {
+	if b.Failures != other.Failures {
+		return b.Failures < other.Failures
+	}
+	return b.Latency < other.Latency
+}
+
+type service struct {
+	Name     string
+	VIP      net.IP
+	Backends []*serviceBackend
+	Active   *serviceBackend // receives the traffic, nil until chosen
+}
+
+type services struct {
+	sync.Mutex
+	m    map[string]*service
+	stop chan struct{} // stops the checks, if running, when closed
+}
+
+// serviceDNATArgs returns the rule of chain, PREROUTING for the traffic of
+// the containers and OUTPUT for the one of the host, sending the traffic
+// of vip to backend.
+func serviceDNATArgs(chain string, vip, backend net.IP) []string {
+	return []string{chain, "-t", "nat", "-d", vip.String(), "-j", "DNAT", "--to-destination", backend.String()}
+}
+
+// serviceVIPArgs returns the rules letting the traffic of vip reach its
+// backends: masqueraded, for the replies of the backends to come back
+// through the host rather than straight to the containers on the bridge,
+// and accepted even without inter-container communication.
+func serviceVIPArgs(vip net.IP) [][]string {
+	match := []string{"-m", "conntrack", "--ctstate", "DNAT", "--ctorigdst", vip.String()}
+	return [][]string{
+		append(append([]string{"POSTROUTING", "-t", "nat"}, match...), "-j", "MASQUERADE"),
+		append(append([]string{"FORWARD"}, match...), "-j", "ACCEPT"),
+	}
+}
+
+// setupServiceVIP gives the bridge vip, and sets up its rules.
+func (d *Driver) setupServiceVIP(vip net.IP) error {
+	if err := d.runIp("addr", "add", vip.String()+"/32", "dev", d.bridgeIface); err != nil {
+		return err
+	}
+	for _, args := range serviceVIPArgs(vip) {
+		if err := d.execRule(false, append([]string{"-I"}, args...)...); err != nil {
+			d.removeServiceVIP(vip)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) removeServiceVIP(vip net.IP) {
+	for _, args := range serviceVIPArgs(vip) {
+		d.firewall.Raw(false, append([]string{"-D"}, args...)...)
+	}
+	if err := d.runIp("addr", "del", vip.String()+"/32", "dev", d.bridgeIface); err != nil {
+		log.Infof("Unable to remove the service ip %s from the bridge: %s", vip, err)
+	}
+}
+
+// activate sends the traffic of the VIP of s to backend, in place of the
+// active one. The lock must be held.
+func (d *Driver) activate(s *service, backend *serviceBackend) error {
+	for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+		if err := d.execRule(false, append([]string{"-I"}, serviceDNATArgs(chain, s.VIP, backend.IP)...)...); err != nil {
+			return err
+		}
+		if s.Active != nil {
+			d.firewall.Raw(false, append([]string{"-D"}, serviceDNATArgs(chain, s.VIP, s.Active.IP)...)...)
+		}
+	}
+	s.Active = backend
+	return nil
+}
+
+func (d *Driver) deactivate(s *service) {
+	if s.Active == nil {
+		return
+	}
+	for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+		d.firewall.Raw(false, append([]string{"-D"}, serviceDNATArgs(chain, s.VIP, s.Active.IP)...)...)
+	}
+	s.Active = nil
+}
+
+// failover keeps the active backend of s while it is healthy, and activates
+// the healthiest one otherwise. The lock must be held.
+func (d *Driver) failover(s *service) {
+	if s.Active != nil && s.Active.healthy() {
+		return
+	}
+	var best *serviceBackend
+	for _, b := range s.Backends {
+		if b.healthy() && (best == nil || b.better(best)) {
+			best = b
+		}
+	}
+	if best == nil {
+		// The active one might come back
+		return
+	}
+	if err := d.activate(s, best); err != nil {
+		log.Errorf("Unable to fail the service %s over to %s: %s", s.Name, best.IP, err)
+		return
+	}
+	log.Infof("The service %s at %s is served by %s", s.Name, s.VIP, best.IP)
+	d.logEvent(d.eng, eventFailover, best.ID, s.Name+" "+s.VIP.String())
+}
+
+// joinService makes the container of iface a backend of the service name,
+// whose VIP is allocated if the container is the first one, requestedVIP
+// if not nil. It returns the VIP.
+func (d *Driver) joinService(iface *networkInterface, id, name string, requestedVIP net.IP, checkPort int) (net.IP, error) {
+	d.services.Lock()
+	defer d.services.Unlock()
+
+	s := d.services.m[name]
+	if s == nil {
+		vip, err := ipallocator.RequestIP(d.bridgeNetwork, requestedVIP)
+		if err != nil && requestedVIP != nil {
+			// The VIP of the previous run might be taken
+			log.Infof("Unable to allocate the ip %s of the service %s again: %s", requestedVIP, name, err)
+			vip, err = ipallocator.RequestIP(d.bridgeNetwork, nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to allocate the ip of the service %s: %s", name, err)
+		}
+		if err := d.setupServiceVIP(vip); err != nil {
+			ipallocator.ReleaseIP(d.bridgeNetwork, vip)
+			return nil, err
+		}
+		s = &service{Name: name, VIP: vip}
+		d.services.m[name] = s
+		if d.services.stop == nil && !d.dryRun {
+			d.startServiceChecks()
+		}
+	}
+	s.Backends = append(s.Backends, &serviceBackend{ID: id, IP: iface.IP, CheckPort: checkPort})
+	iface.Service = name
+	d.failover(s)
+	return s.VIP, nil
+}
+
+// leaveService removes the container of iface from the backends of its
+// service, which fails over if it was the active one, and which goes away
+// along with its VIP once it has no backend left.
+func (d *Driver) leaveService(iface *networkInterface) {
+	if iface.Service == "" {
+		return
+	}
+	d.services.Lock()
+	defer d.services.Unlock()
+
+	s := d.services.m[iface.Service]
+	iface.Service = ""
+	if s == nil {
+		return
+	}
+	for i, b := range s.Backends {
+		if !b.IP.Equal(iface.IP) {
+			continue
+		}
+		s.Backends = append(s.Backends[:i], s.Backends[i+1:]...)
+		if s.Active == b {
+			d.deactivate(s)
+		}
+		break
+	}
+	if len(s.Backends) > 0 {
+		d.failover(s)
+		return
+	}
+	d.removeServiceVIP(s.VIP)
+	if err := ipallocator.ReleaseIP(d.bridgeNetwork, s.VIP); err != nil {
+		log.Infof("Unable to release the service ip %s: %s", s.VIP, err)
+	}
+	delete(d.services.m, s.Name)
+}
+
+// checkServices checks the backends of the services, and fails the
+// services whose active backend went down over.
+func (d *Driver) checkServices() {
+	type check struct {
+		backend *serviceBackend
+		addr    string
+	}
+	var checks []check
+	d.services.Lock()
+	for _, s := range d.services.m {
+		for _, b := range s.Backends {
+			if b.CheckPort != 0 {
+				checks = append(checks, check{b, net.JoinHostPort(b.IP.String(), strconv.Itoa(b.CheckPort))})
+			}
+		}
+	}
+	d.services.Unlock()
+
+	// The backends are checked without the lock held
+	failures := make(map[*serviceBackend]bool)
+	latencies := make(map[*serviceBackend]time.Duration)
+	for _, c := range checks {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", c.addr, serviceCheckTimeout)
+		if err != nil {
+			failures[c.backend] = true
+			continue
+		}
+		latencies[c.backend] = time.Since(start)
+		conn.Close()
+	}
+
+	d.services.Lock()
+	defer d.services.Unlock()
+	for _, c := range checks {
+		b := c.backend
+		if failures[b] {
+			if b.Failures++; b.Failures == serviceMaxFailures {
+				log.WithField("container", b.ID).Warnf("The backend %s is down", b.IP)
+			}
+			continue
+		}
+		b.Failures = 0
+		b.Latency = latencies[b]
+	}
+	for _, s := range d.services.m {
+		d.failover(s)
+	}
+}
+
+// startServiceChecks checks the backends until stopServiceChecks is called.
+// The lock must be held.
+func (d *Driver) startServiceChecks() {
+	stop := make(chan struct{})
+	d.services.stop = stop
+	go func() {
+		ticker := time.NewTicker(serviceCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			d.checkServices()
+		}
+	}()
+}
+
+func (d *Driver) stopServiceChecks() {
+	d.services.Lock()
+	defer d.services.Unlock()
+	if d.services.stop != nil {
+		close(d.services.stop)
+		d.services.stop = nil
+	}
+}
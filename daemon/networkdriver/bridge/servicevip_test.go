@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestServiceVIP(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+
+	// Set the service up in dry-run mode to find out what is changed
+	d.iptablesEnabled = true
+	dryRunDriver(d)
+
+	var vip string
+	for _, id := range []string{"web1", "web2"} {
+		job := eng.Job("allocate_interface", id)
+		job.Setenv("ServiceVIP", "web")
+		out, err := job.Stdout.AddEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if vip == "" {
+			vip = out.Get("ServiceVIP")
+		}
+		if out.Get("ServiceVIP") != vip || !d.bridgeNetwork.Contains(net.ParseIP(vip)) {
+			t.Fatalf("Expected the backends to share a VIP of the bridge network, got %s and %s", vip, out.Get("ServiceVIP"))
+		}
+	}
+	web1, web2 := d.currentInterfaces.Get("web1").IP, d.currentInterfaces.Get("web2").IP
+	s := d.services.m["web"]
+	if s.Active == nil || !s.Active.IP.Equal(web1) {
+		t.Fatalf("Expected the first backend to be active, got %v", s.Active)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, change := range []string{
+		"ip addr add " + vip + "/32 dev " + d.bridgeIface,
+		"-I PREROUTING -t nat -d " + vip + " -j DNAT --to-destination " + web1.String(),
+		"-I POSTROUTING -t nat -m conntrack --ctstate DNAT --ctorigdst " + vip + " -j MASQUERADE",
+	} {
+		if !strings.Contains(plan, change) {
+			t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+		}
+	}
+
+	// The active backend goes down
+	s.Active.Failures = serviceMaxFailures
+	d.failover(s)
+	if !s.Active.IP.Equal(web2) {
+		t.Fatalf("Expected the service to fail over to %s, got %s", web2, s.Active.IP)
+	}
+
+	// Released, the active backend hands the service over to the other one
+	// once it is back
+	if res := d.Release(eng.Job("release_interface", "web2")); res != engine.StatusOK {
+		t.Fatal("Failed to release the backend")
+	}
+	if s.Active != nil {
+		t.Fatalf("Expected no backend to be active while the other one is down, got %s", s.Active.IP)
+	}
+	s.Backends[0].Failures = 0
+	d.failover(s)
+	if s.Active == nil || !s.Active.IP.Equal(web1) {
+		t.Fatalf("Expected %s to be active again", web1)
+	}
+
+	// The VIP goes away along with the last backend
+	d.changes.planned = nil
+	if res := d.Release(eng.Job("release_interface", "web1")); res != engine.StatusOK {
+		t.Fatal("Failed to release the backend")
+	}
+	if _, ok := d.services.m["web"]; ok {
+		t.Fatal("Expected the service to be removed")
+	}
+	if plan := strings.Join(d.changes.plan(), "\n"); !strings.Contains(plan, "ip addr del "+vip+"/32") {
+		t.Fatalf("Expected the VIP to be removed from the bridge, got:\n%s", plan)
+	}
+}
+
+func TestCheckServices(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	up := l.Addr().(*net.TCPAddr).Port
+	down := findFreePort(t)
+
+	d := newDriver(&Config{DryRun: true})
+	local := net.ParseIP("127.0.0.1")
+	s := &service{Name: "web", VIP: net.ParseIP("172.17.0.250"), Backends: []*serviceBackend{
+		{ID: "web1", IP: local, CheckPort: down},
+		{ID: "web2", IP: local, CheckPort: up},
+	}}
+	s.Active = s.Backends[0]
+	d.services.m["web"] = s
+	d.eng = engine.New()
+	d.eng.Logging = false
+
+	for i := 0; i < serviceMaxFailures; i++ {
+		if s.Active != s.Backends[0] {
+			t.Fatalf("Expected the service to fail over after %d failed checks, not %d", serviceMaxFailures, i)
+		}
+		d.checkServices()
+	}
+	if s.Active != s.Backends[1] || s.Backends[1].Failures != 0 {
+		t.Fatalf("Expected the service to fail over to the backend up, got %+v", s.Active)
+	}
+}
@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+func (d *Driver) getDefaultBindingIP() net.IP {
+	d.bindingLock.Lock()
+	defer d.bindingLock.Unlock()
+	return d.defaultBindingIP
+}
+
+func (d *Driver) setDefaultBindingIP(ip net.IP) {
+	d.bindingLock.Lock()
+	d.defaultBindingIP = ip
+	d.bindingLock.Unlock()
+}
+
+// parsePortRange parses a range of ports such as "49153-65535".
+func parsePortRange(r string) (begin, end int, err error) {
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid port range %s (ex: 49153-65535)", r)
+	}
+	if begin, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("Invalid port range %s (ex: 49153-65535)", r)
+	}
+	if end, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("Invalid port range %s (ex: 49153-65535)", r)
+	}
+	return begin, end, nil
+}
+
+// ConfigureDriver changes the settings of the driver given, for the port
+// mappings made from then on: PortRange is the range of the ports published
+// when no host port is requested (ex: "49153-65535"), DefaultBindingIP the
+// host ip they are published on when none is requested. The running
+// containers are left alone, but for MulticastSnooping and MulticastQuerier
+// which change the multicast snooping of the bridge right away. It writes
+// the current settings.
+func (d *Driver) ConfigureDriver(job *engine.Job) engine.Status {
+	var (
+		begin, end        = portallocator.PortRange()
+		bindingIP         = d.getDefaultBindingIP()
+		snooping, querier = d.snoopingSettings()
+		err               error
+	)
+
+	if r := job.Getenv("PortRange"); r != "" {
+		if begin, end, err = parsePortRange(r); err != nil {
+			return job.Error(err)
+		}
+	}
+	if ip := job.Getenv("DefaultBindingIP"); ip != "" {
+		if bindingIP = net.ParseIP(ip); bindingIP == nil {
+			return job.Errorf("Bad parameter: invalid ip %s", ip)
+		}
+	}
+
+	if job.EnvExists("MulticastSnooping") || job.EnvExists("MulticastQuerier") {
+		if d.config.Rootless {
+			return job.Errorf("The rootless network has no bridge to snoop the multicast on")
+		}
+		if job.EnvExists("MulticastSnooping") {
+			snooping = job.GetenvBool("MulticastSnooping")
+		}
+		if job.EnvExists("MulticastQuerier") {
+			querier = job.GetenvBool("MulticastQuerier")
+		}
+	}
+
+	if err := portallocator.SetPortRange(begin, end); err != nil {
+		return job.Error(err)
+	}
+	d.setDefaultBindingIP(bindingIP)
+	if current, currentQuerier := d.snoopingSettings(); current != snooping || currentQuerier != querier {
+		if err := d.setSnooping(snooping, querier); err != nil {
+			return job.Error(err)
+		}
+	}
+
+	out := engine.Env{}
+	out.Set("PortRange", fmt.Sprintf("%d-%d", begin, end))
+	out.Set("DefaultBindingIP", bindingIP.String())
+	out.SetBool("MulticastSnooping", snooping)
+	out.SetBool("MulticastQuerier", querier)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
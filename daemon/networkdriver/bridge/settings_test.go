@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+func TestParsePortRange(t *testing.T) {
+	if begin, end, err := parsePortRange("30000-32767"); err != nil || begin != 30000 || end != 32767 {
+		t.Fatalf("Unexpected range %d-%d (%v)", begin, end, err)
+	}
+	for _, r := range []string{"30000", "30000-", "a-b", "-1"} {
+		if _, _, err := parsePortRange(r); err == nil {
+			t.Fatalf("Expected %q to be invalid", r)
+		}
+	}
+}
+
+func TestConfigureDriver(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	defer portallocator.SetPortRange(portallocator.BeginPortRange, portallocator.EndPortRange)
+
+	initTestDriver(t, eng)
+
+	job := eng.Job("configure_network")
+	job.Setenv("PortRange", "30000-30010")
+	job.Setenv("DefaultBindingIP", "127.0.0.1")
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("PortRange") != "30000-30010" || out.Get("DefaultBindingIP") != "127.0.0.1" {
+		t.Fatalf("Unexpected settings %v", out)
+	}
+	if begin, end := portallocator.PortRange(); begin != 30000 || end != 30010 {
+		t.Fatalf("Expected the port range to be 30000-30010, got %d-%d", begin, end)
+	}
+
+	// Bad settings change nothing
+	job = eng.Job("configure_network")
+	job.Setenv("PortRange", "40000-40010")
+	job.Setenv("DefaultBindingIP", "localhost")
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected an invalid ip to be rejected")
+	}
+	if begin, _ := portallocator.PortRange(); begin != 30000 {
+		t.Fatalf("Expected the port range to be kept, got %d", begin)
+	}
+}
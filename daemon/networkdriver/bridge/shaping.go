@@ -0,0 +1,213 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/docker/docker/engine"
+)
+
+// Container bandwidth limits and network faults are enforced on the bridge
+// rather than on the host side of the veth pair, which the exec driver only
+// creates when the container starts. Traffic to a container is shaped by its
+// own HTB class under the bridge root qdisc, with a netem leaf qdisc if faults
+// are injected, traffic from a container is policed by the bridge ingress
+// qdisc. Both are selected by u32 filters on the container ip.
+
+// unlimitedRate is the rate of the classes of containers with network
+// faults but no bandwidth limit.
+const unlimitedRate = "10gbit"
+
+var (
+	validRate  = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?([kmgt]?(bit|bps))$`)
+	validBurst = regexp.MustCompile(`^[0-9]+[kmg]?b?$`)
+)
+
+// bandwidth holds the limits of a container, in tc units (ex: "10mbit").
+type bandwidth struct {
+	Ingress string // rate of the traffic to the container
+	Egress  string // rate of the traffic from the container
+	Burst   string
+}
+
+// shaping guards the setup of the qdiscs of the bridge.
+type shaping struct {
+	sync.Mutex
+	ready bool // whether the bridge qdiscs are set up
+}
+
+func (d *Driver) runTc(args ...string) error {
+	return d.runCommand("tc", args...)
+}
+
+// parseBandwidth builds the limits out of the IngressRate, EgressRate and
+// Burst variables of a job. It returns nil if no rate is given.
+func parseBandwidth(job *engine.Job) (*bandwidth, error) {
+	b := &bandwidth{
+		Ingress: job.Getenv("IngressRate"),
+		Egress:  job.Getenv("EgressRate"),
+		Burst:   job.Getenv("Burst"),
+	}
+	if b.Ingress == "" && b.Egress == "" {
+		return nil, nil
+	}
+	for _, rate := range []string{b.Ingress, b.Egress} {
+		if rate != "" && !validRate.MatchString(rate) {
+			return nil, fmt.Errorf("Invalid rate %s (ex: 512kbit, 10mbit)", rate)
+		}
+	}
+	if b.Burst == "" {
+		b.Burst = "32k"
+	} else if !validBurst.MatchString(b.Burst) {
+		return nil, fmt.Errorf("Invalid burst %s (ex: 32k)", b.Burst)
+	}
+	return b, nil
+}
+
+// shapingSlot returns the slot of a container, the class minor number and
+// the filter priority used for it. The low 16 bits of the ip are unique on
+// bridges of /16 or less.
+func shapingSlot(ip net.IP) int {
+	ip4 := ip.To4()
+	slot := int(ip4[2])<<8 | int(ip4[3])
+	if slot == 0 || slot == 0xffff {
+		// Reserved by tc, these are the network and broadcast addresses of a /16
+		slot = 1
+	}
+	return slot
+}
+
+// netemHandle returns the major number of the netem qdisc of a container,
+// its slot unless that is 1, the handle of the root qdisc. The slot of the
+// bridge, which no container has, takes its place then: the bridge having
+// the slot 1 itself, no container has it.
+func (d *Driver) netemHandle(slot int) int {
+	if slot == 1 {
+		return shapingSlot(d.bridgeNetwork.IP)
+	}
+	return slot
+}
+
+// hexSlot formats a slot as the minor or major number of a tc handle.
+func hexSlot(slot int) string {
+	return strconv.FormatInt(int64(slot), 16)
+}
+
+// setupShapingQdiscs (re)creates the bridge qdiscs the first time they are
+// needed. Unclassified traffic goes through the HTB root untouched.
+func (d *Driver) setupShapingQdiscs() error {
+	d.shaping.Lock()
+	defer d.shaping.Unlock()
+
+	if d.shaping.ready {
+		return nil
+	}
+	d.runTc("qdisc", "del", "dev", d.bridgeIface, "ingress")
+	if err := d.runTc("qdisc", "replace", "dev", d.bridgeIface, "root", "handle", "1:", "htb"); err != nil {
+		return err
+	}
+	if err := d.runTc("qdisc", "add", "dev", d.bridgeIface, "ingress"); err != nil {
+		return err
+	}
+	d.shaping.ready = true
+	return nil
+}
+
+// applyShaping sets the bandwidth limits and network faults of the container
+// with the given ip, replacing any previous ones. Either can be nil.
+func (d *Driver) applyShaping(ip net.IP, b *bandwidth, n *netem) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("Traffic shaping is only supported for IPv4 containers")
+	}
+	if err := d.setupShapingQdiscs(); err != nil {
+		return err
+	}
+	d.removeShaping(ip)
+	if b == nil && n == nil {
+		return nil
+	}
+	if b == nil {
+		b = &bandwidth{Burst: "32k"}
+	}
+
+	var (
+		slot    = shapingSlot(ip)
+		classid = "1:" + hexSlot(slot)
+		prio    = strconv.Itoa(slot)
+	)
+	if b.Ingress != "" || n != nil {
+		rate := b.Ingress
+		if rate == "" {
+			rate = unlimitedRate
+		}
+		if err := d.runTc("class", "add", "dev", d.bridgeIface, "parent", "1:", "classid", classid,
+			"htb", "rate", rate, "ceil", rate, "burst", b.Burst); err != nil {
+			return err
+		}
+		if err := d.runTc("filter", "add", "dev", d.bridgeIface, "parent", "1:", "protocol", "ip", "prio", prio,
+			"u32", "match", "ip", "dst", ip.String()+"/32", "flowid", classid); err != nil {
+			d.removeShaping(ip)
+			return err
+		}
+	}
+	if n != nil {
+		args := []string{"qdisc", "add", "dev", d.bridgeIface, "parent", classid, "handle", hexSlot(d.netemHandle(slot)) + ":", "netem"}
+		if err := d.runTc(append(args, n.args()...)...); err != nil {
+			d.removeShaping(ip)
+			return err
+		}
+	}
+	if b.Egress != "" {
+		if err := d.runTc("filter", "add", "dev", d.bridgeIface, "parent", "ffff:", "protocol", "ip", "prio", prio,
+			"u32", "match", "ip", "src", ip.String()+"/32",
+			"police", "rate", b.Egress, "burst", b.Burst, "drop", "flowid", ":1"); err != nil {
+			d.removeShaping(ip)
+			return err
+		}
+	}
+	return nil
+}
+
+// removeShaping lifts the limits and faults of the container with the given
+// ip. Errors are ignored, they might not be set.
+func (d *Driver) removeShaping(ip net.IP) {
+	slot := shapingSlot(ip)
+	prio := strconv.Itoa(slot)
+	d.runTc("filter", "del", "dev", d.bridgeIface, "parent", "1:", "protocol", "ip", "prio", prio)
+	// Deleting the class takes its netem qdisc along
+	d.runTc("class", "del", "dev", d.bridgeIface, "classid", "1:"+hexSlot(slot))
+	d.runTc("filter", "del", "dev", d.bridgeIface, "parent", "ffff:", "protocol", "ip", "prio", prio)
+}
+
+// SetBandwidth changes the bandwidth limits of a running container. The
+// IngressRate and EgressRate variables are tc rates (ex: "10mbit"), leaving
+// both empty lifts the limits.
+func (d *Driver) SetBandwidth(job *engine.Job) engine.Status {
+	var (
+		id      = job.Args[0]
+		network = d.currentInterfaces.Get(id)
+	)
+
+	if network == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+
+	b, err := parseBandwidth(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	if b == nil && network.Bandwidth == nil {
+		return engine.StatusOK
+	}
+
+	log.WithField("container", id).Debugf("Limiting bandwidth to %+v", b)
+	if err := d.applyShaping(network.IP, b, network.Netem); err != nil {
+		return job.Error(err)
+	}
+	network.Bandwidth = b
+	d.saveJob(id, job.Name, job.Environ())
+	return engine.StatusOK
+}
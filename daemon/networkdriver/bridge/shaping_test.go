@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	eng := engine.New()
+
+	job := eng.Job("set_bandwidth", "container_id")
+	if b, err := parseBandwidth(job); err != nil || b != nil {
+		t.Fatalf("Expected no limits, got %v (%v)", b, err)
+	}
+
+	job.Setenv("IngressRate", "10mbit")
+	b, err := parseBandwidth(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Ingress != "10mbit" || b.Egress != "" || b.Burst != "32k" {
+		t.Fatalf("Unexpected limits %+v", b)
+	}
+
+	job.Setenv("EgressRate", "1.5mbps")
+	job.Setenv("Burst", "64kb")
+	if b, err = parseBandwidth(job); err != nil {
+		t.Fatal(err)
+	}
+	if b.Egress != "1.5mbps" || b.Burst != "64kb" {
+		t.Fatalf("Unexpected limits %+v", b)
+	}
+
+	for _, rate := range []string{"10", "fast", "10mb", "-1mbit", "10mbit;reboot"} {
+		job.Setenv("EgressRate", rate)
+		if _, err := parseBandwidth(job); err == nil {
+			t.Fatalf("Expected rate %s to be rejected", rate)
+		}
+	}
+
+	job.Setenv("EgressRate", "")
+	job.Setenv("Burst", "lots")
+	if _, err := parseBandwidth(job); err == nil {
+		t.Fatal("Expected invalid burst to be rejected")
+	}
+}
+
+func TestShapingSlot(t *testing.T) {
+	for ip, slot := range map[string]int{
+		"172.17.0.2":   2,
+		"172.17.1.1":   0x101,
+		"172.17.42.10": 0x2a0a,
+		"10.1.0.0":     1,
+		"10.1.255.255": 1,
+	} {
+		if s := shapingSlot(net.ParseIP(ip)); s != slot {
+			t.Fatalf("Expected slot %x for %s, got %x", slot, ip, s)
+		}
+	}
+}
+
+func TestApplyShaping(t *testing.T) {
+	e := &RecordingExecutor{}
+	_, network, _ := net.ParseCIDR("172.17.0.0/16")
+	network.IP = net.ParseIP("172.17.42.1")
+	d := newDriver(&Config{BridgeIface: "docker0", Executor: e})
+	d.bridgeNetwork = network
+	b := &bandwidth{Ingress: "10mbit", Egress: "1mbit", Burst: "32k"}
+	n := &netem{Delay: "100ms"}
+	for _, ip := range []string{"172.17.0.10", "172.17.0.1"} {
+		if err := d.applyShaping(net.ParseIP(ip), b, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Join(e.Lines(), "\n")
+	for _, command := range []string{
+		// The filter priorities are decimal, the handles hexadecimal
+		"tc class add dev docker0 parent 1: classid 1:a htb rate 10mbit ceil 10mbit burst 32k",
+		"tc filter add dev docker0 parent 1: protocol ip prio 10 u32 match ip dst 172.17.0.10/32 flowid 1:a",
+		"tc qdisc add dev docker0 parent 1:a handle a: netem delay 100ms",
+		"tc filter add dev docker0 parent ffff: protocol ip prio 10 u32 match ip src 172.17.0.10/32 police rate 1mbit burst 32k drop flowid :1",
+		// The netem qdisc of the slot 1 takes the handle of the bridge slot, 1: being the root
+		"tc class add dev docker0 parent 1: classid 1:1 htb rate 10mbit ceil 10mbit burst 32k",
+		"tc qdisc add dev docker0 parent 1:1 handle 2a01: netem delay 100ms",
+	} {
+		if !strings.Contains(lines, command) {
+			t.Fatalf("Expected %q to be run, got:\n%s", command, lines)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package bridge
+
+import "github.com/docker/docker/engine"
+
+// Close tears the driver down when the daemon exits: it stops the flow
+// exporter, the reconciliation and the state dumps, heals the partitions and releases the
+// interfaces left, along with their port mappings and userland proxies. With
+// cleanup, the changes recorded in the journal, such as the bridge and the
+// chains, are undone as well, the systemd-networkd units removed and the
+// route of the cloud to the bridge network removed.
+func (d *Driver) Close(cleanup bool) error {
+	d.stopFlowExport()
+	d.stopReconcile()
+	d.stopUpstreamRenewal()
+	d.stopCloudRoute(cleanup)
+	d.stopFloatingIPWatch()
+	d.stopServiceChecks()
+	d.stopStateDump()
+	if d.hooks != nil {
+		d.hooks.close()
+	}
+
+	for id, iface := range d.currentInterfaces.All() {
+		log.WithField("container", id).Debugf("Releasing the network interface")
+		d.releaseInterface(iface)
+		d.currentInterfaces.Delete(id)
+	}
+	d.syncMappingsFile()
+	if d.iptablesEnabled {
+		d.healIf(func(ipPair) bool { return true })
+	}
+
+	if cleanup {
+		log.Infof("Removing the bridge and the firewall rules of the daemon")
+	}
+	err := d.closeJournal(cleanup)
+	if cleanup {
+		d.removeNetworkd()
+	}
+	d.stopHelper()
+	d.releaseClaims()
+	return err
+}
+
+// Shutdown is the job closing the driver, cleaning up if the Cleanup
+// variable is set.
+func (d *Driver) Shutdown(job *engine.Job) engine.Status {
+	if err := d.Close(job.GetenvBool("Cleanup")); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
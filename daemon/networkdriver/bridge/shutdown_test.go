@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+func TestClose(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	if res := d.Allocate(eng.Job("allocate_interface", "close_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	ip := d.currentInterfaces.Get("close_container").IP.String()
+
+	port := strconv.Itoa(findFreePort(t))
+	job := eng.Job("allocate_port", "close_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("HostPort", port)
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", port)
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to allocate port")
+	}
+
+	if err := d.Close(false); err != nil {
+		t.Fatal(err)
+	}
+	if d.currentInterfaces.Get("close_container") != nil {
+		t.Fatal("Expected the interface to be released")
+	}
+	for _, m := range portmapper.Mappings() {
+		if m.Container == ip+":"+port {
+			t.Fatalf("Expected the port mapping to be removed, got %v", m)
+		}
+	}
+	if d.flowExportStop != nil || d.stateDumpSignals != nil {
+		t.Fatal("Expected the background tasks to be stopped")
+	}
+}
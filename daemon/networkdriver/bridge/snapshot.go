@@ -0,0 +1,202 @@
+package bridge
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/docker/docker/engine"
+)
+
+// The interface of a container can be exported as a portable document, and
+// imported on another host to set up an equivalent interface there, as a
+// building block for moving containers around. The document carries the
+// addresses and the port mappings of the interface for the tools to read,
+// and the jobs which set it up, replayed on import: the addresses and host
+// ports taken on the other host, or out of its network, are replaced by
+// free ones.
+const snapshotVersion = 1
+
+type interfaceSnapshot struct {
+	Version      int
+	IP           string
+	MacAddress   string
+	SecondaryIPs []string       `json:",omitempty"`
+	Ports        []snapshotPort `json:",omitempty"`
+	Jobs         []savedJob
+}
+
+type snapshotPort struct {
+	Proto         string
+	HostIP        string
+	HostPort      int
+	ContainerPort int
+	Name          string            `json:",omitempty"`
+	Labels        map[string]string `json:",omitempty"`
+}
+
+// snapshotPortOf describes the mapping set up by an allocate_port job.
+func snapshotPortOf(env map[string]string) snapshotPort {
+	p := snapshotPort{
+		Proto:  env["Proto"],
+		HostIP: env["HostIP"],
+		Name:   env["Name"],
+	}
+	p.HostPort, _ = strconv.Atoi(env["HostPort"])
+	p.ContainerPort, _ = strconv.Atoi(env["ContainerPort"])
+	if labels := env["Labels"]; labels != "" {
+		json.Unmarshal([]byte(labels), &p.Labels)
+	}
+	return p
+}
+
+// newSnapshot describes the interface set up by jobs, the first of which
+// allocated it.
+func newSnapshot(jobs []savedJob) *interfaceSnapshot {
+	snapshot := &interfaceSnapshot{
+		Version:    snapshotVersion,
+		IP:         jobs[0].Env["RequestedIP"],
+		MacAddress: jobs[0].Env["RequestedMac"],
+		Jobs:       jobs,
+	}
+	for _, j := range jobs[1:] {
+		switch j.Name {
+		case "add_address":
+			snapshot.SecondaryIPs = append(snapshot.SecondaryIPs, j.Env["RequestedIP"])
+		case "allocate_port":
+			snapshot.Ports = append(snapshot.Ports, snapshotPortOf(j.Env))
+		}
+	}
+	return snapshot
+}
+
+// ExportInterface writes the snapshot of the interface of a container, as
+// a JSON document.
+func (d *Driver) ExportInterface(job *engine.Job) engine.Status {
+	id := job.Args[0]
+
+	d.saved.Lock()
+	jobs := append([]savedJob{}, d.saved.jobs[id]...)
+	d.saved.Unlock()
+
+	if d.currentInterfaces.Get(id) == nil || len(jobs) == 0 || jobs[0].Name != "allocate_interface" {
+		return job.Errorf("No network information for %s", id)
+	}
+	if err := json.NewEncoder(job.Stdout).Encode(newSnapshot(jobs)); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// importedJobs are the jobs a snapshot may run, along with their parameters
+// dropped when the job fails on import, for the driver to choose another
+// address or host port. The mac address goes along with the ip it was
+// generated from.
+var importedJobs = map[string][]string{
+	"allocate_interface": {"RequestedIP", "RequestedMac", "RequestedServiceVIP"},
+	"add_address":        {"RequestedIP"},
+	"allocate_port":      {"HostPort"},
+	"set_egress_policy":  nil,
+	"set_routing_policy": nil,
+	"set_bandwidth":      nil,
+	"set_netem":          nil,
+	"set_blackhole":      nil,
+}
+
+// importJob runs a job of a snapshot for the container id, and runs it again
+// without the addresses or the host port it requested if they can't be had,
+// the addresses being kept at all costs with keepIP.
+func (d *Driver) importJob(job *engine.Job, id string, j savedJob, keepIP bool) (*engine.Env, error) {
+	run := func(env map[string]string) (*engine.Env, error) {
+		replay := job.Eng.Job(j.Name, id)
+		for key, value := range env {
+			replay.Setenv(key, value)
+		}
+		out, err := replay.Stdout.AddEnv()
+		if err != nil {
+			return nil, err
+		}
+		if err := replay.Run(); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	out, err := run(j.Env)
+	replaced := importedJobs[j.Name]
+	if err == nil || len(replaced) == 0 || j.Env[replaced[0]] == "" || (keepIP && replaced[0] == "RequestedIP") {
+		return out, err
+	}
+	log.WithField("container", id).Infof("Unable to keep the %s %s, choosing another: %s", replaced[0], j.Env[replaced[0]], err)
+	env := make(map[string]string, len(j.Env))
+	for key, value := range j.Env {
+		env[key] = value
+	}
+	for _, key := range replaced {
+		delete(env, key)
+	}
+	return run(env)
+}
+
+// ImportInterface sets up the interface of a container from the snapshot
+// given in "Snapshot", exported on this host or another, failing rather
+// than giving it other addresses with "KeepIP". It writes the same output
+// as allocate_interface, along with the secondary addresses and the port
+// mappings of the interface, which may differ from those of the snapshot.
+func (d *Driver) ImportInterface(job *engine.Job) engine.Status {
+	id := job.Args[0]
+
+	var snapshot interfaceSnapshot
+	if err := json.Unmarshal([]byte(job.Getenv("Snapshot")), &snapshot); err != nil {
+		return job.Errorf("Bad parameter: invalid network snapshot: %s", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return job.Errorf("Unsupported version %d of the network snapshot", snapshot.Version)
+	}
+	if len(snapshot.Jobs) == 0 || snapshot.Jobs[0].Name != "allocate_interface" {
+		return job.Errorf("Bad parameter: the network snapshot doesn't allocate an interface")
+	}
+	for _, j := range snapshot.Jobs[1:] {
+		if _, known := importedJobs[j.Name]; !known || j.Name == "allocate_interface" {
+			return job.Errorf("Bad parameter: unexpected job %s in the network snapshot", j.Name)
+		}
+	}
+	if d.currentInterfaces.Get(id) != nil {
+		return job.Errorf("The network interface of %s is already set up", id)
+	}
+
+	var (
+		out          *engine.Env
+		secondaryIPs []string
+		ports        []snapshotPort
+	)
+	for i, j := range snapshot.Jobs {
+		result, err := d.importJob(job, id, j, job.GetenvBool("KeepIP"))
+		if err != nil {
+			if i > 0 {
+				job.Eng.Job("release_interface", id).Run()
+			}
+			return job.Errorf("Unable to import the network interface of %s: %s", id, err)
+		}
+		switch j.Name {
+		case "allocate_interface":
+			out = result
+		case "add_address":
+			secondaryIPs = append(secondaryIPs, result.Get("IP"))
+		case "allocate_port":
+			p := snapshotPortOf(j.Env)
+			p.HostIP = result.Get("HostIP")
+			p.HostPort = result.GetInt("HostPort")
+			ports = append(ports, p)
+		}
+	}
+	if len(secondaryIPs) > 0 {
+		out.SetList("SecondaryIPs", secondaryIPs)
+	}
+	if len(ports) > 0 {
+		out.SetJson("Ports", ports)
+	}
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestExportImportInterface(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+
+	if res := d.Allocate(eng.Job("allocate_interface", "exported_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	ip := d.currentInterfaces.Get("exported_container").IP
+
+	port := strconv.Itoa(findFreePort(t))
+	job := eng.Job("allocate_port", "exported_container")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("HostPort", port)
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", "80")
+	job.Setenv("Name", "web")
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	job = eng.Job("export_interface", "exported_container")
+	job.Stdout.Add(&buf)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot interfaceSnapshot
+	if err := json.Unmarshal(buf.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.IP != ip.String() || len(snapshot.Ports) != 1 || strconv.Itoa(snapshot.Ports[0].HostPort) != port || snapshot.Ports[0].Name != "web" {
+		t.Fatalf("Expected the snapshot to describe %s published on 127.0.0.1:%s, got %+v", ip, port, snapshot)
+	}
+	if res := d.Release(eng.Job("release_interface", "exported_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+
+	// The ip of the snapshot was given to another container meanwhile
+	job = eng.Job("allocate_interface", "other_container")
+	job.Setenv("RequestedIP", ip.String())
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Release(eng.Job("release_interface", "other_container"))
+
+	job = eng.Job("import_interface", "imported_container")
+	job.Setenv("Snapshot", buf.String())
+	job.SetenvBool("KeepIP", true)
+	if err := job.Run(); err == nil {
+		t.Fatalf("Expected the import to fail rather than give up the ip %s", ip)
+	}
+
+	job = eng.Job("import_interface", "imported_container")
+	job.Setenv("Snapshot", buf.String())
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("IP") == "" || out.Get("IP") == ip.String() {
+		t.Fatalf("Expected the container to be given another ip than %s, got %q", ip, out.Get("IP"))
+	}
+	var ports []snapshotPort
+	if err := out.GetJson("Ports", &ports); err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || strconv.Itoa(ports[0].HostPort) != port || ports[0].Name != "web" {
+		t.Fatalf("Expected the port to be published on 127.0.0.1:%s again, got %+v", port, ports)
+	}
+	if res := d.Release(eng.Job("release_interface", "imported_container")); res != engine.StatusOK {
+		t.Fatal("Failed to release network interface")
+	}
+
+	snapshot.Jobs = append(snapshot.Jobs, savedJob{Name: "release_interface"})
+	data, _ := json.Marshal(snapshot)
+	job = eng.Job("import_interface", "imported_container")
+	job.Setenv("Snapshot", string(data))
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected a snapshot running other jobs to be refused")
+	}
+	if d.currentInterfaces.Get("imported_container") != nil {
+		t.Fatal("Expected the refused snapshot to set nothing up")
+	}
+}
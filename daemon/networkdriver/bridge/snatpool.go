@@ -0,0 +1,143 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+var ErrSnatNotInPool = errors.New("requested SNAT address is not part of the SNAT pool")
+
+// snatLease is a pool address handed out to a group of containers.
+type snatLease struct {
+	ip   net.IP
+	refs int
+}
+
+// snatPool hands out outbound source addresses to groups of containers.
+// Groups get a distinct address for as long as the pool allows it and then
+// share the least used one.
+type snatPool struct {
+	sync.Mutex
+	ips    []net.IP
+	groups map[string]*snatLease
+}
+
+func newSnatPool(ips []net.IP) *snatPool {
+	return &snatPool{
+		ips:    ips,
+		groups: make(map[string]*snatLease),
+	}
+}
+
+// usage returns how many groups each pool address is leased to.
+func (p *snatPool) usage() map[string]int {
+	used := make(map[string]int)
+	for _, l := range p.groups {
+		used[l.ip.String()]++
+	}
+	return used
+}
+
+// Acquire returns the address of group, leasing one if the group is new.
+// A non-nil requested address pins a new group to that address.
+func (p *snatPool) Acquire(group string, requested net.IP) (net.IP, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if l, ok := p.groups[group]; ok {
+		if requested != nil && !requested.Equal(l.ip) {
+			return nil, ErrSnatNotInPool
+		}
+		l.refs++
+		return l.ip, nil
+	}
+
+	var (
+		ip   net.IP
+		used = p.usage()
+	)
+	for _, candidate := range p.ips {
+		if requested != nil {
+			if candidate.Equal(requested) {
+				ip = candidate
+				break
+			}
+			continue
+		}
+		if ip == nil || used[candidate.String()] < used[ip.String()] {
+			ip = candidate
+		}
+	}
+	if ip == nil {
+		return nil, ErrSnatNotInPool
+	}
+	p.groups[group] = &snatLease{ip: ip, refs: 1}
+	return ip, nil
+}
+
+// Release drops one reference on the lease of group.
+func (p *snatPool) Release(group string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if l, ok := p.groups[group]; ok {
+		if l.refs--; l.refs <= 0 {
+			delete(p.groups, group)
+		}
+	}
+}
+
+func (d *Driver) snatArgs(containerIP, source net.IP) []string {
+	return []string{"POSTROUTING", "-t", "nat", "-s", containerIP.String(), "!", "-o", d.bridgeIface, "-j", "SNAT", "--to-source", source.String()}
+}
+
+// setupContainerSnat makes the traffic of a single container leave the host
+// with the given source address. The rule is inserted ahead of the bridge
+// wide MASQUERADE/SNAT rule.
+func (d *Driver) setupContainerSnat(containerIP, source net.IP) error {
+	return d.execRule(false, append([]string{"-I"}, d.snatArgs(containerIP, source)...)...)
+}
+
+func (d *Driver) removeContainerSnat(containerIP, source net.IP) {
+	d.firewall.Raw(false, append([]string{"-D"}, d.snatArgs(containerIP, source)...)...)
+}
+
+// acquireSnat leases an outbound address to the container, sharing it with
+// the other members of group. An empty group puts the container on its own.
+func (d *Driver) acquireSnat(iface *networkInterface, id, group, requested string) error {
+	if d.snatAddrs == nil {
+		return fmt.Errorf("No SNAT pool configured")
+	}
+	if group == "" {
+		group = id
+	}
+	var requestedIP net.IP
+	if requested != "" {
+		if requestedIP = net.ParseIP(requested); requestedIP == nil {
+			return fmt.Errorf("Bad parameter: invalid SNAT ip %s", requested)
+		}
+	}
+
+	ip, err := d.snatAddrs.Acquire(group, requestedIP)
+	if err != nil {
+		return err
+	}
+	if err := d.setupContainerSnat(iface.IP, ip); err != nil {
+		d.snatAddrs.Release(group)
+		return err
+	}
+	iface.SnatGroup = group
+	iface.SnatIP = ip
+	return nil
+}
+
+func (d *Driver) releaseSnat(iface *networkInterface) {
+	if iface.SnatIP == nil {
+		return
+	}
+	d.removeContainerSnat(iface.IP, iface.SnatIP)
+	d.snatAddrs.Release(iface.SnatGroup)
+	iface.SnatIP = nil
+}
@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSnatPoolDistinctAddresses(t *testing.T) {
+	pool := newSnatPool([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")})
+
+	a, err := pool.Acquire("tenant-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := pool.Acquire("tenant-b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(b) {
+		t.Fatalf("Expected distinct addresses, got %s twice", a)
+	}
+
+	// Members of a group share its address
+	if a2, err := pool.Acquire("tenant-a", nil); err != nil || !a2.Equal(a) {
+		t.Fatalf("Expected %s for the second member of tenant-a, got %s (%v)", a, a2, err)
+	}
+
+	// Once exhausted, the least used address is shared
+	pool.Release("tenant-a")
+	pool.Release("tenant-a")
+	c, err := pool.Acquire("tenant-c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equal(a) {
+		t.Fatalf("Expected released address %s to be reused, got %s", a, c)
+	}
+}
+
+func TestSnatPoolRequestedAddress(t *testing.T) {
+	pool := newSnatPool([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")})
+
+	ip, err := pool.Acquire("pinned", net.ParseIP("10.0.0.2"))
+	if err != nil || !ip.Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("Expected 10.0.0.2, got %s (%v)", ip, err)
+	}
+	if _, err := pool.Acquire("other", net.ParseIP("192.168.0.1")); err != ErrSnatNotInPool {
+		t.Fatalf("Expected %s, got %v", ErrSnatNotInPool, err)
+	}
+	if _, err := pool.Acquire("pinned", net.ParseIP("10.0.0.1")); err != ErrSnatNotInPool {
+		t.Fatalf("Expected %s when changing the address of a group, got %v", ErrSnatNotInPool, err)
+	}
+}
@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A container can have kernel parameters of its own in its network
+// namespace, such as a wider range of local ports for a proxy making many
+// outgoing connections. Only the namespaced parameters of containerSysctls
+// can be set, those of the host being out of reach of a container. They are
+// checked when the interface is allocated, and set in the namespace of the
+// container once it runs.
+
+// containerSysctls are the parameters a container can set, with the number
+// of integers their value is made of.
+var containerSysctls = map[string]int{
+	"net.ipv4.ip_local_port_range":  2,
+	"net.ipv4.tcp_keepalive_time":   1,
+	"net.ipv4.tcp_keepalive_intvl":  1,
+	"net.ipv4.tcp_keepalive_probes": 1,
+	"net.core.somaxconn":            1,
+}
+
+// checkSysctls tells whether sysctls can be the parameters of a container.
+func checkSysctls(sysctls map[string]string) error {
+	for key, value := range sysctls {
+		n, ok := containerSysctls[key]
+		if !ok {
+			return fmt.Errorf("The kernel parameter %s can't be set for a container", key)
+		}
+		fields := strings.Fields(value)
+		if len(fields) != n {
+			return fmt.Errorf("Invalid value %q of %s", value, key)
+		}
+		for _, f := range fields {
+			if i, err := strconv.Atoi(f); err != nil || i < 0 {
+				return fmt.Errorf("Invalid value %q of %s", value, key)
+			}
+		}
+		if key == "net.ipv4.ip_local_port_range" {
+			low, _ := strconv.Atoi(fields[0])
+			high, _ := strconv.Atoi(fields[1])
+			if low < 1 || high > 65535 || low > high {
+				return fmt.Errorf("Invalid value %q of %s, it must be a range of ports", value, key)
+			}
+		}
+	}
+	return nil
+}
+
+// setSysctls sets the parameters of iface in the network namespace of the
+// running container, of process pid.
+func (d *Driver) setSysctls(iface *networkInterface, pid int) error {
+	var keys []string
+	for key := range iface.Sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := strings.Join(strings.Fields(iface.Sysctls[key]), " ")
+		if err := d.runCommand("nsenter", "--net=/proc/"+strconv.Itoa(pid)+"/ns/net", "sysctl", "-w", key+"="+value); err != nil {
+			return fmt.Errorf("Unable to set %s of the container: %s", key, err)
+		}
+	}
+	return nil
+}
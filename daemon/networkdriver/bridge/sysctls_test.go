@@ -0,0 +1,63 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestContainerSysctls(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+
+	job := eng.Job("allocate_interface", "sysctl_container")
+	job.SetenvJson("Sysctls", map[string]string{
+		"net.core.somaxconn":           "1024",
+		"net.ipv4.ip_local_port_range": "10000  20000",
+	})
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(d.currentInterfaces.Get("sysctl_container").Sysctls); n != 2 {
+		t.Fatalf("Expected 2 kernel parameters, got %d", n)
+	}
+
+	for _, sysctls := range []map[string]string{
+		{"net.ipv4.ip_forward": "1"},
+		{"kernel.shmmax": "1024"},
+		{"net.core.somaxconn": "many"},
+		{"net.core.somaxconn": "1 2"},
+		{"net.ipv4.ip_local_port_range": "20000 10000"},
+		{"net.ipv4.ip_local_port_range": "1024"},
+	} {
+		job := eng.Job("allocate_interface", "invalid_sysctl_container")
+		job.SetenvJson("Sysctls", sysctls)
+		if err := job.Run(); err == nil {
+			t.Fatalf("Expected %v to be refused", sysctls)
+		}
+		if d.currentInterfaces.Get("invalid_sysctl_container") != nil {
+			t.Fatal("Expected the interface to be released")
+		}
+	}
+
+	// Set in the namespace of the container once it runs
+	dryRunDriver(d)
+	job = eng.Job("attach_interface", "sysctl_container")
+	job.SetenvInt("Pid", 42)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, change := range []string{
+		"nsenter --net=/proc/42/ns/net sysctl -w net.core.somaxconn=1024",
+		"nsenter --net=/proc/42/ns/net sysctl -w net.ipv4.ip_local_port_range=10000 20000",
+	} {
+		if !strings.Contains(plan, change) {
+			t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+)
+
+// A virtual machine, such as a QEMU/KVM guest run alongside the containers,
+// can be given an interface of the bridge network as a container is: with a
+// tap device on the bridge in place of a veth pair, its address leased from
+// the same pool and its ports published the same way. The allocation being
+// the one of a container whose Tap is the name of the device, the guest is
+// expected to use the MAC address and the address given.
+//
+// The taps have no container to restore them after a restart of the daemon,
+// so the driver restores them itself once installed, adopting the devices
+// still used by the guests.
+
+// setupTap creates the tap device name of the machine id, owned by user if
+// not empty, and adds it to the bridge. A device of that name is adopted,
+// unless it is the tap of another machine.
+func (d *Driver) setupTap(iface *networkInterface, id, name, user string) error {
+	if len(name) > 15 {
+		return fmt.Errorf("Invalid tap device %s, its name must be at most 15 characters", name)
+	}
+	for other, i := range d.currentInterfaces.All() {
+		if other == id {
+			return fmt.Errorf("The tap of %s is set up already", id)
+		}
+		if i.Tap == name {
+			return fmt.Errorf("The tap device %s is the one of %s", name, other)
+		}
+	}
+	if _, err := net.InterfaceByName(name); err != nil || d.dryRun {
+		args := []string{"tuntap", "add", "dev", name, "mode", "tap"}
+		if user != "" {
+			args = append(args, "user", user)
+		}
+		if err := d.runCommand("ip", args...); err != nil {
+			return err
+		}
+	}
+	iface.Tap = name
+	iface.HostIface = name
+	if err := d.runIp("link", "set", "dev", name, "master", d.bridgeIface); err != nil {
+		return err
+	}
+	return d.runIp("link", "set", "dev", name, "up")
+}
+
+func (d *Driver) removeTap(iface *networkInterface) {
+	if iface.Tap == "" {
+		return
+	}
+	if err := d.runIp("link", "del", "dev", iface.Tap); err != nil {
+		log.Infof("Unable to remove the tap device %s: %s", iface.Tap, err)
+	}
+	iface.Tap = ""
+}
+
+// restoreTaps restores the interfaces of the taps saved by the previous run
+// of the daemon.
+func (d *Driver) restoreTaps() {
+	d.saved.Lock()
+	var ids []string
+	for id, jobs := range d.saved.jobs {
+		if len(jobs) > 0 && jobs[0].Name == "allocate_interface" && jobs[0].Env["Tap"] != "" {
+			ids = append(ids, id)
+		}
+	}
+	d.saved.Unlock()
+
+	for _, id := range ids {
+		if err := d.eng.Job("restore_interface", id).Run(); err != nil {
+			log.Errorf("Unable to restore the tap of %s: %s", id, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestTap(t *testing.T) {
+	if _, err := os.Stat("/dev/net/tun"); err != nil {
+		t.Skip("Tap devices aren't available")
+	}
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	defer exec.Command("ip", "link", "del", "dkt-testvm").Run()
+
+	job := eng.Job("allocate_interface", "tap:testvm")
+	job.Setenv("Tap", "dkt-testvm")
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if ip := net.ParseIP(out.Get("IP")); ip == nil || !d.bridgeNetwork.Contains(ip) || out.Get("HostInterfaceName") != "dkt-testvm" {
+		t.Fatalf("Expected an address of the bridge network for the tap, got %v", out)
+	}
+	master, err := os.Readlink("/sys/class/net/dkt-testvm/master")
+	if err != nil || path.Base(master) != d.bridgeIface {
+		t.Fatalf("Expected the tap to be on the bridge %s, got %s (%v)", d.bridgeIface, master, err)
+	}
+
+	// The taps are restored by the driver, adopting the device of the guest
+	iface := d.currentInterfaces.Get("tap:testvm")
+	d.releaseInterface(&networkInterface{IP: iface.IP})
+	d.currentInterfaces.Delete("tap:testvm")
+	d.restoreTaps()
+	if restored := d.currentInterfaces.Get("tap:testvm"); restored == nil || !restored.IP.Equal(iface.IP) || restored.Tap != "dkt-testvm" {
+		t.Fatalf("Expected the tap to be restored with %s, got %+v", iface.IP, restored)
+	}
+
+	if err := eng.Job("release_interface", "tap:testvm").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.InterfaceByName("dkt-testvm"); err == nil {
+		t.Fatal("Expected the tap to be removed")
+	}
+
+	// A tap is set up once, and a device is the tap of a single machine
+	job = eng.Job("allocate_interface", "tap:testvm2")
+	job.Setenv("Tap", "dkt-testvm2")
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.Command("ip", "link", "del", "dkt-testvm2").Run()
+
+	for _, tap := range [][2]string{
+		{"tap:othervm", "dkt-name-too-long"},
+		{"tap:othervm", "dkt-testvm2"},
+		{"tap:testvm2", "dkt-testvm3"},
+	} {
+		job = eng.Job("allocate_interface", tap[0])
+		job.Setenv("Tap", tap[1])
+		if err := job.Run(); err == nil {
+			t.Fatalf("Expected the tap %v to be refused", tap)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+// ReleasePort unpublishes a single port of a running container, given its
+// Proto, HostPort and, if it was published on several addresses, HostIP.
+// Its rules and proxy go away and its host port is released, the other
+// mappings of the container being left alone. The connections tracked
+// through the mapping are deleted as well, for their traffic not to reach
+// the container anymore. The mapping unpublished is written as its HostIP,
+// HostPort and ContainerPort.
+func (d *Driver) ReleasePort(job *engine.Job) engine.Status {
+	var (
+		id       = job.Args[0]
+		proto    = job.Getenv("Proto")
+		hostPort = job.GetenvInt("HostPort")
+		hostIP   = net.ParseIP(job.Getenv("HostIP"))
+	)
+	if d.config.Rootless {
+		return job.Errorf("The ports published by the rootless network can't be unpublished")
+	}
+	iface := d.currentInterfaces.Get(id)
+	if iface == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+
+	var host net.Addr
+	for _, nat := range d.hostMappings(iface) {
+		p := mappedHostPort(nat)
+		if p.Proto != proto || p.Port != hostPort || (hostIP != nil && !hostAddrIP(nat).Equal(hostIP)) {
+			continue
+		}
+		if host != nil {
+			return job.Errorf("The port %s/%d of %s is published on several addresses, give the one to unpublish", proto, hostPort, id)
+		}
+		host = nat
+	}
+	if host == nil {
+		return job.Errorf("No port %s/%d published for %s", proto, hostPort, id)
+	}
+
+	out := engine.Env{}
+	out.Set("HostIP", hostAddrIP(host).String())
+	out.SetInt("HostPort", hostPort)
+	if state, err := portmapper.Lookup(host); err == nil {
+		if _, port, err := net.SplitHostPort(state.Container); err == nil {
+			out.Set("ContainerPort", port)
+		}
+	}
+
+	d.cancelExpiry(host)
+	d.logMappingEvent(job.Eng, eventUnmap, id, host, addrDetail(host))
+	d.unmapPort(iface, host)
+	d.forgetSavedPort(id, host)
+	d.flushConntrack(proto, hostAddrIP(host), hostPort)
+
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// flushConntrack deletes the connections tracked to a host port, on ip
+// unless it is unspecified.
+func (d *Driver) flushConntrack(proto string, ip net.IP, port int) {
+	args := []string{"-D", "-p", proto, "--orig-port-dst", strconv.Itoa(port)}
+	if ip != nil && !ip.IsUnspecified() {
+		args = append(args, "--orig-dst", ip.String())
+	}
+	// conntrack fails when no connection was deleted
+	if err := d.runCommand("conntrack", args...); err != nil {
+		log.Debugf("Unable to delete the connections to %s/%d: %s", proto, port, err)
+	}
+}
+
+// flushContainerConntrack deletes the connections tracked from and to the
+// addresses of iface, those of its published ports included, for the next
+// packets of their peers to be answered with a RST by the host rather than
+// be forwarded to an address gone.
+func (d *Driver) flushContainerConntrack(iface *networkInterface) {
+	for _, ip := range append([]net.IP{iface.IP}, iface.SecondaryIPs...) {
+		family := "ipv4"
+		if ip.To4() == nil {
+			family = "ipv6"
+		}
+		for _, direction := range []string{"--orig-src", "--reply-src"} {
+			// conntrack fails when no connection was deleted
+			if err := d.runCommand("conntrack", "-D", "-f", family, direction, ip.String()); err != nil {
+				log.Debugf("Unable to delete the connections of %s: %s", ip, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestReleasePort(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	d := initTestDriver(t, eng)
+	defer d.Close(false)
+	if res := d.Allocate(eng.Job("allocate_interface", "unpublish_container")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+
+	released, kept := findFreePort(t), findFreePort(t)
+	for _, port := range []int{released, kept} {
+		job := newPortAllocationJob(eng, port)
+		job.Args[0] = "unpublish_container"
+		if res := d.AllocatePort(job); res != engine.StatusOK {
+			t.Fatalf("Failed to allocate the port %d", port)
+		}
+	}
+
+	job := eng.Job("release_port", "unpublish_container")
+	job.Setenv("Proto", "tcp")
+	job.SetenvInt("HostPort", released)
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("HostIP") != "127.0.0.1" || out.GetInt("HostPort") != released || out.Get("ContainerPort") != strconv.Itoa(released) {
+		t.Fatalf("Unexpected port unpublished %v", out)
+	}
+
+	iface := d.currentInterfaces.Get("unpublish_container")
+	if len(iface.PortMappings) != 1 || mappedHostPort(iface.PortMappings[0]).Port != kept {
+		t.Fatalf("Expected only the port %d to be left, got %v", kept, iface.PortMappings)
+	}
+	d.saved.Lock()
+	jobs := d.saved.jobs["unpublish_container"]
+	d.saved.Unlock()
+	if len(jobs) != 2 || jobs[1].Env["HostPort"] != strconv.Itoa(kept) {
+		t.Fatalf("Expected the unpublished port to be forgotten, got %v", jobs)
+	}
+
+	// The unpublished port is free again
+	job = newPortAllocationJob(eng, released)
+	job.Args[0] = "unpublish_container"
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Expected the unpublished port to be released")
+	}
+
+	job = eng.Job("release_port", "unpublish_container")
+	job.Setenv("Proto", "udp")
+	job.SetenvInt("HostPort", kept)
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected a port not published to fail")
+	}
+}
+
+func TestFlushContainerConntrack(t *testing.T) {
+	d := newDriver(&Config{DryRun: true})
+
+	d.flushContainerConntrack(&networkInterface{IP: net.ParseIP("172.17.0.5"), SecondaryIPs: []net.IP{net.ParseIP("fd00::5")}})
+	plan := strings.Join(d.changes.plan(), "\n")
+	for _, change := range []string{
+		"conntrack -D -f ipv4 --orig-src 172.17.0.5",
+		"conntrack -D -f ipv4 --reply-src 172.17.0.5",
+		"conntrack -D -f ipv6 --orig-src fd00::5",
+		"conntrack -D -f ipv6 --reply-src fd00::5",
+	} {
+		if !strings.Contains(plan, change) {
+			t.Fatalf("Expected %q to be planned, got:\n%s", change, plan)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Routing tables set up for the containers leaving the host through a
+// specific uplink, unless given another range. Tables picked for routing
+// policies must stay clear of the range.
+const (
+	defaultUplinkTables = "200-252"
+	maxUplinkTable      = 252 // the default, main and local tables follow
+)
+
+var ErrNoUplinkTable = fmt.Errorf("no free routing table left for the uplinks")
+
+// parseTableRange parses a range of routing tables such as "200-252".
+func parseTableRange(r string) (first, last int, err error) {
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid routing table range %s (ex: %s)", r, defaultUplinkTables)
+	}
+	if first, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("Invalid routing table range %s (ex: %s)", r, defaultUplinkTables)
+	}
+	if last, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("Invalid routing table range %s (ex: %s)", r, defaultUplinkTables)
+	}
+	if first < 1 || first > last || last > maxUplinkTable {
+		return 0, 0, fmt.Errorf("Invalid routing table range %s: the tables must be between 1 and %d", r, maxUplinkTable)
+	}
+	return first, last, nil
+}
+
+// uplink is the way out of the host of a container on a multi-homed host.
+type uplink struct {
+	Device  string
+	Gateway net.IP
+	Table   int
+}
+
+func (u *uplink) key() string {
+	return u.Device + "/" + u.Gateway.String()
+}
+
+type uplinkTable struct {
+	table int
+	refs  int
+}
+
+// uplinkTables maps every uplink in use to the routing table sending traffic
+// through it.
+type uplinkTables struct {
+	sync.Mutex
+	byUplink map[string]*uplinkTable
+}
+
+// acquireUplinkTable returns the routing table of u, setting it up if u is
+// not in use yet.
+func (d *Driver) acquireUplinkTable(u *uplink) (int, error) {
+	d.uplinkTables.Lock()
+	defer d.uplinkTables.Unlock()
+
+	if t, ok := d.uplinkTables.byUplink[u.key()]; ok {
+		t.refs++
+		return t.table, nil
+	}
+
+	used := make(map[int]bool)
+	for _, t := range d.uplinkTables.byUplink {
+		used[t.table] = true
+	}
+	table := 0
+	for i := d.firstUplinkTable; i <= d.lastUplinkTable; i++ {
+		if used[i] {
+			continue
+		}
+		// Leave alone the tables of the admin and of the other daemons
+		inUse, err := tableInUse(i)
+		if err != nil {
+			return 0, err
+		}
+		if inUse {
+			log.Debugf("Routing table %d has routes or rules, skipping it", i)
+			continue
+		}
+		table = i
+		break
+	}
+	if table == 0 {
+		return 0, ErrNoUplinkTable
+	}
+
+	t := strconv.Itoa(table)
+	args := []string{"route", "replace", "default"}
+	if u.Gateway != nil {
+		args = append(args, "via", u.Gateway.String())
+	}
+	if u.Device != "" {
+		args = append(args, "dev", u.Device)
+	}
+	if err := d.runIp(append(args, "table", t)...); err != nil {
+		return 0, err
+	}
+	// Containers can still reach each other and the host through the bridge
+	if err := d.runIp("route", "replace", d.bridgeNetwork.String(), "dev", d.bridgeIface, "table", t); err != nil {
+		d.runIp("route", "flush", "table", t)
+		return 0, err
+	}
+	d.uplinkTables.byUplink[u.key()] = &uplinkTable{table: table, refs: 1}
+	return table, nil
+}
+
+func (d *Driver) releaseUplinkTable(u *uplink) {
+	d.uplinkTables.Lock()
+	defer d.uplinkTables.Unlock()
+
+	t, ok := d.uplinkTables.byUplink[u.key()]
+	if !ok {
+		return
+	}
+	if t.refs--; t.refs > 0 {
+		return
+	}
+	delete(d.uplinkTables.byUplink, u.key())
+	if err := d.runIp("route", "flush", "table", strconv.Itoa(t.table)); err != nil {
+		log.Infof("Unable to flush routing table %d: %s", t.table, err)
+	}
+}
+
+// parseUplink builds the uplink requested with the EgressDevice and
+// EgressGateway allocation parameters, nil if neither is set.
+func parseUplink(device, gateway string) (*uplink, error) {
+	if device == "" && gateway == "" {
+		return nil, nil
+	}
+	u := &uplink{Device: device}
+	if gateway != "" {
+		if u.Gateway = net.ParseIP(gateway); u.Gateway == nil {
+			return nil, fmt.Errorf("Bad parameter: invalid egress gateway %s", gateway)
+		}
+	}
+	if device != "" {
+		if _, err := net.InterfaceByName(device); err != nil {
+			return nil, fmt.Errorf("Bad parameter: invalid egress device %s: %s", device, err)
+		}
+	}
+	return u, nil
+}
+
+// setupUplink routes the traffic from the container ip through u, using a
+// source based routing rule.
+func (d *Driver) setupUplink(ip net.IP, u *uplink) error {
+	table, err := d.acquireUplinkTable(u)
+	if err != nil {
+		return err
+	}
+	if err := d.runIp("rule", "add", "from", ip.String(), "table", strconv.Itoa(table)); err != nil {
+		d.releaseUplinkTable(u)
+		return err
+	}
+	u.Table = table
+	return nil
+}
+
+func (d *Driver) removeUplink(ip net.IP, u *uplink) {
+	if err := d.runIp("rule", "del", "from", ip.String(), "table", strconv.Itoa(u.Table)); err != nil {
+		log.Infof("Unable to remove routing rule for %s: %s", ip, err)
+	}
+	d.releaseUplinkTable(u)
+}
@@ -0,0 +1,199 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/natpmp"
+	"github.com/docker/docker/pkg/upnp"
+)
+
+// On home and edge boxes, the published ports are out of reach behind the
+// router of the local network. The port mappings asked for are forwarded
+// by the router as well, with NAT-PMP or UPnP IGD, for as long as they
+// exist.
+const (
+	upstreamNatpmp = "natpmp"
+	upstreamUpnp   = "upnp"
+
+	// The forwardings are renewed halfway through their lifetime
+	upstreamLifetime = 2 * time.Hour
+
+	upstreamDiscoveryTimeout = 3 * time.Second
+)
+
+// portForwarder asks the router to forward its ports to those of the host.
+type portForwarder interface {
+	AddPortMapping(proto string, internalPort, externalPort int, lifetime time.Duration) (int, error)
+	DeletePortMapping(proto string, internalPort, externalPort int) error
+}
+
+// discoverForwarder finds the router speaking protocol.
+var discoverForwarder = func(protocol string) (portForwarder, error) {
+	switch protocol {
+	case upstreamNatpmp:
+		gateway, err := natpmp.DefaultGateway()
+		if err != nil {
+			return nil, err
+		}
+		return natpmp.NewClient(gateway), nil
+	case upstreamUpnp:
+		return upnp.Discover(upstreamDiscoveryTimeout)
+	}
+	return nil, fmt.Errorf("Unknown upstream forwarding protocol %s", protocol)
+}
+
+// upstreamForwarding is a port mapping forwarded by the router.
+type upstreamForwarding struct {
+	Proto        string
+	HostPort     int
+	UpstreamPort int // port of the router, 0 until it forwards it
+}
+
+// upstreamRouter is the router forwarding the ports, found once needed and
+// again after it failed. The router is called without holding the lock,
+// its round-trips taking seconds, so that the ports of other containers are
+// published meanwhile.
+type upstreamRouter struct {
+	sync.Mutex // guards forwarder and the upstream ports of the forwardings
+	protocol   string
+	dryRun     bool          // the forwardings are logged rather than asked for
+	forwarder  portForwarder // nil until found
+	stop       chan struct{} // stops the renewal, if running, when closed
+}
+
+// router returns the router, found first if needed.
+func (u *upstreamRouter) router() (portForwarder, error) {
+	u.Lock()
+	forwarder := u.forwarder
+	u.Unlock()
+	if forwarder != nil {
+		return forwarder, nil
+	}
+	forwarder, err := discoverForwarder(u.protocol)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find the %s router: %s", u.protocol, err)
+	}
+	u.Lock()
+	defer u.Unlock()
+	// Found meanwhile by another forwarding
+	if u.forwarder == nil {
+		u.forwarder = forwarder
+	}
+	return u.forwarder, nil
+}
+
+// forget has the router found again, after forwarder failed.
+func (u *upstreamRouter) forget(forwarder portForwarder) {
+	u.Lock()
+	if u.forwarder == forwarder {
+		u.forwarder = nil
+	}
+	u.Unlock()
+}
+
+// port returns the port of the router forwarding f, 0 if none.
+func (u *upstreamRouter) port(f *upstreamForwarding) int {
+	u.Lock()
+	defer u.Unlock()
+	return f.UpstreamPort
+}
+
+// forward asks the router to forward f, or to keep forwarding it. The port
+// of the router is that of the host, unless taken.
+func (u *upstreamRouter) forward(f *upstreamForwarding) error {
+	if u.dryRun {
+		log.Infof("Would forward the port %s/%d on the router", f.Proto, f.HostPort)
+		return nil
+	}
+	forwarder, err := u.router()
+	if err != nil {
+		return err
+	}
+	port := u.port(f)
+	if port == 0 {
+		port = f.HostPort
+	}
+	port, err = forwarder.AddPortMapping(f.Proto, f.HostPort, port, upstreamLifetime)
+	if err != nil {
+		// The router might have changed
+		u.forget(forwarder)
+		return err
+	}
+	u.Lock()
+	f.UpstreamPort = port
+	u.Unlock()
+	return nil
+}
+
+// remove asks the router to stop forwarding f.
+func (u *upstreamRouter) remove(f *upstreamForwarding) {
+	u.Lock()
+	port, forwarder := f.UpstreamPort, u.forwarder
+	f.UpstreamPort = 0
+	u.Unlock()
+	if port == 0 || forwarder == nil {
+		return
+	}
+	if err := forwarder.DeletePortMapping(f.Proto, f.HostPort, port); err != nil {
+		log.Infof("Unable to remove the forwarding of the port %s/%d from the router: %s", f.Proto, f.HostPort, err)
+	}
+}
+
+// upstreamForwardings returns the forwardings of iface, which change as
+// its ports are published and unpublished.
+func (d *Driver) upstreamForwardings(iface *networkInterface) []*upstreamForwarding {
+	d.portsLock.Lock()
+	defer d.portsLock.Unlock()
+	return append([]*upstreamForwarding(nil), iface.Upstream...)
+}
+
+// renewUpstream forwards again the ports of the containers, those the
+// router didn't forward yet included.
+func (d *Driver) renewUpstream() {
+	for id, iface := range d.currentInterfaces.All() {
+		for _, f := range d.upstreamForwardings(iface) {
+			if err := d.upstream.forward(f); err != nil {
+				log.WithField("container", id).Warnf("Unable to forward the port %s/%d on the router: %s", f.Proto, f.HostPort, err)
+			}
+		}
+	}
+}
+
+// startUpstreamRenewal renews the forwardings until stopUpstreamRenewal is
+// called.
+func (d *Driver) startUpstreamRenewal() {
+	stop := make(chan struct{})
+	d.upstream.stop = stop
+	go func() {
+		ticker := time.NewTicker(upstreamLifetime / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			d.renewUpstream()
+		}
+	}()
+}
+
+func (d *Driver) stopUpstreamRenewal() {
+	if d.upstream != nil && d.upstream.stop != nil {
+		close(d.upstream.stop)
+		d.upstream.stop = nil
+	}
+}
+
+// releaseUpstream asks the router to stop forwarding the ports of iface.
+func (d *Driver) releaseUpstream(iface *networkInterface) {
+	d.portsLock.Lock()
+	forwardings := iface.Upstream
+	iface.Upstream = nil
+	d.portsLock.Unlock()
+	for _, f := range forwardings {
+		d.upstream.remove(f)
+	}
+}
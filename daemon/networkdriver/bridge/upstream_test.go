@@ -0,0 +1,146 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+// fakeRouter forwards its ports plus offset, keeping the forwardings by
+// port of the host.
+type fakeRouter struct {
+	offset      int
+	err         error
+	forwardings map[int]int
+}
+
+func (r *fakeRouter) AddPortMapping(proto string, internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if externalPort == internalPort {
+		externalPort += r.offset
+	}
+	r.forwardings[internalPort] = externalPort
+	return externalPort, nil
+}
+
+func (r *fakeRouter) DeletePortMapping(proto string, internalPort, externalPort int) error {
+	delete(r.forwardings, internalPort)
+	return nil
+}
+
+func TestUpstreamForwarding(t *testing.T) {
+	router := &fakeRouter{offset: 1, err: fmt.Errorf("no router"), forwardings: make(map[int]int)}
+	defer func(discover func(string) (portForwarder, error)) { discoverForwarder = discover }(discoverForwarder)
+	discoverForwarder = func(protocol string) (portForwarder, error) {
+		return router, nil
+	}
+
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+	freePort := findFreePort(t)
+
+	if res := d.Allocate(eng.Job("allocate_interface", "container_id")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	job := newPortAllocationJob(eng, freePort)
+	job.SetenvBool("Upstream", true)
+	if res := d.AllocatePort(job); res == engine.StatusOK {
+		t.Fatal("Expected the forwarding to require an upstream forwarding protocol")
+	}
+
+	// The router is unreachable, the port is mapped nonetheless
+	d.upstream = &upstreamRouter{protocol: upstreamNatpmp}
+	job = newPortAllocationJob(eng, freePort)
+	job.SetenvBool("Upstream", true)
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Exists("UpstreamPort") {
+		t.Fatalf("Expected no upstream port, got %s", out.Get("UpstreamPort"))
+	}
+
+	// The renewal forwards the port once the router is back
+	router.err = nil
+	d.renewUpstream()
+	if port := router.forwardings[freePort]; port != freePort+1 {
+		t.Fatalf("Expected the router to forward its port %d, got %v", freePort+1, router.forwardings)
+	}
+	forwardings := d.upstreamForwardings(d.currentInterfaces.Get("container_id"))
+	if len(forwardings) != 1 || d.upstream.port(forwardings[0]) != freePort+1 {
+		t.Fatalf("Expected the forwarding to be recorded, got %v", forwardings)
+	}
+	// The port of the router is kept
+	d.renewUpstream()
+	if port := router.forwardings[freePort]; port != freePort+1 {
+		t.Fatalf("Expected the router to keep forwarding its port %d, got %v", freePort+1, router.forwardings)
+	}
+
+	if err := eng.Job("release_interface", "container_id").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(router.forwardings) != 0 {
+		t.Fatalf("Expected the forwarding to be removed from the router, got %v", router.forwardings)
+	}
+}
+
+// blockingRouter holds the forwarding of the port blocked until unblocked.
+type blockingRouter struct {
+	blocked   int
+	entered   chan struct{} // closed once the router forwards the port blocked
+	unblocked chan struct{}
+}
+
+func (r *blockingRouter) AddPortMapping(proto string, internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	if internalPort == r.blocked {
+		close(r.entered)
+		<-r.unblocked
+	}
+	return externalPort, nil
+}
+
+func (r *blockingRouter) DeletePortMapping(proto string, internalPort, externalPort int) error {
+	return nil
+}
+
+func TestUpstreamForwardingConcurrent(t *testing.T) {
+	router := &blockingRouter{blocked: 8080, entered: make(chan struct{}), unblocked: make(chan struct{})}
+	defer func(discover func(string) (portForwarder, error)) { discoverForwarder = discover }(discoverForwarder)
+	discoverForwarder = func(protocol string) (portForwarder, error) {
+		return router, nil
+	}
+	u := &upstreamRouter{protocol: upstreamUpnp}
+
+	slow := &upstreamForwarding{Proto: "tcp", HostPort: 8080}
+	slowDone := make(chan error, 1)
+	go func() { slowDone <- u.forward(slow) }()
+	<-router.entered
+
+	// The router being slow to forward a port doesn't hold the others up
+	done := make(chan error, 1)
+	go func() { done <- u.forward(&upstreamForwarding{Proto: "tcp", HostPort: 8081}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the port to be forwarded while the router forwards another one")
+	}
+
+	close(router.unblocked)
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+	if port := u.port(slow); port != 8080 {
+		t.Fatalf("Expected the router to forward the port 8080, got %d", port)
+	}
+}
@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"net"
+	"time"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+)
+
+// Floating ips, such as the virtual ips keepalived moves between two hosts
+// with VRRP, can have ports published on them while another host holds
+// them. The driver watches the addresses of the host, and the userland
+// proxies of the ports of a floating ip listen only while it is there.
+const floatingIPCheckInterval = time.Second
+
+// hostAddrs returns the addresses of the host.
+var hostAddrs = net.InterfaceAddrs
+
+// isFloatingIP tells whether ip is one of the floating ips.
+func (d *Driver) isFloatingIP(ip net.IP) bool {
+	for _, floating := range d.config.FloatingIPs {
+		if floating.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFloatingIPs has the proxies of the floating ips which arrived on the
+// host listen, and those of the floating ips which left stop.
+func (d *Driver) checkFloatingIPs() {
+	addrs, err := hostAddrs()
+	if err != nil {
+		log.Debugf("Unable to list the addresses of the host: %s", err)
+		return
+	}
+	for _, ip := range d.config.FloatingIPs {
+		present := false
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				present = true
+				break
+			}
+		}
+		if d.floatingPresent[ip.String()] == present {
+			continue
+		}
+		d.floatingPresent[ip.String()] = present
+
+		rebound, err := portmapper.SetFloatingIPPresent(ip, present)
+		state := "left"
+		if present {
+			state = "arrived"
+		}
+		log.Infof("The floating ip %s %s, %d port mappings rebound", ip, state, len(rebound))
+		if err != nil {
+			log.Errorf("Unable to serve the ports of the floating ip %s: %s", ip, err)
+		}
+		d.logEvent(d.eng, eventFloatingIP, "", ip.String()+" "+state)
+	}
+}
+
+// startFloatingIPWatch checks the floating ips until stopFloatingIPWatch is
+// called.
+func (d *Driver) startFloatingIPWatch() {
+	stop := make(chan struct{})
+	d.floatingIPStop = stop
+	go func() {
+		ticker := time.NewTicker(floatingIPCheckInterval)
+		defer ticker.Stop()
+		for {
+			d.checkFloatingIPs()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (d *Driver) stopFloatingIPWatch() {
+	if d.floatingIPStop != nil {
+		close(d.floatingIPStop)
+		d.floatingIPStop = nil
+	}
+}
@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/engine"
+)
+
+func TestFloatingIPs(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	d := initTestDriver(t, eng)
+	vip := net.ParseIP("192.168.77.100")
+	d.config.FloatingIPs = []net.IP{vip}
+	portmapper.SetFloatingIPs(d.config.FloatingIPs)
+	defer portmapper.SetFloatingIPs(nil)
+
+	var addrs []net.Addr
+	defer func(saved func() ([]net.Addr, error)) { hostAddrs = saved }(hostAddrs)
+	hostAddrs = func() ([]net.Addr, error) {
+		return addrs, nil
+	}
+
+	// The floating ip is published on while another host holds it, even
+	// with publishing interfaces
+	d.publishIPs = []net.IP{net.ParseIP("127.0.0.1")}
+	if res := d.Allocate(eng.Job("allocate_interface", "container_id")); res != engine.StatusOK {
+		t.Fatal("Failed to allocate network interface")
+	}
+	defer d.Release(eng.Job("release_interface", "container_id"))
+	job := newPortAllocationJob(eng, findFreePort(t))
+	job.Setenv("HostIP", vip.String())
+	if res := d.AllocatePort(job); res != engine.StatusOK {
+		t.Fatal("Failed to publish a port on the floating ip")
+	}
+
+	d.checkFloatingIPs()
+	if d.floatingPresent[vip.String()] {
+		t.Fatal("Expected the floating ip to be elsewhere")
+	}
+
+	addrs = []net.Addr{&net.IPNet{IP: vip, Mask: net.CIDRMask(24, 32)}}
+	d.checkFloatingIPs()
+	if !d.floatingPresent[vip.String()] {
+		t.Fatal("Expected the floating ip to have arrived")
+	}
+	// The proxies already listen
+	if rebound, _ := portmapper.SetFloatingIPPresent(vip, true); rebound != nil {
+		t.Fatalf("Expected the port mappings to be rebound already, got %v", rebound)
+	}
+
+	addrs = nil
+	d.checkFloatingIPs()
+	if d.floatingPresent[vip.String()] {
+		t.Fatal("Expected the floating ip to have left")
+	}
+}
@@ -2,6 +2,8 @@ package ipallocator
 
 import (
 	"errors"
+	"expvar"
+	"math"
 	"math/big"
 	"net"
 	"sync"
@@ -45,6 +47,9 @@ var (
 var (
 	lock         = sync.Mutex{}
 	allocatedIPs = networkSet{}
+
+	// Published as /debug/vars when the api runs in debug mode
+	counters = expvar.NewMap("ipallocator")
 )
 
 // RegisterSubnet registers network in global allocator with bounds
@@ -87,10 +92,56 @@ func RequestIP(network *net.IPNet, ip net.IP) (net.IP, error) {
 		allocatedIPs[key] = allocated
 	}
 
+	var err error
 	if ip == nil {
-		return allocated.getNextIP()
+		ip, err = allocated.getNextIP()
+	} else {
+		ip, err = allocated.checkIP(ip)
+	}
+	switch err {
+	case nil:
+		counters.Add("requests", 1)
+	case ErrNoAvailableIPs:
+		counters.Add("exhausted", 1)
+	default:
+		counters.Add("failures", 1)
 	}
-	return allocated.checkIP(ip)
+	return ip, err
+}
+
+// RequestIPInRange requests the first available ip of subnet, a part of
+// the given network.
+func RequestIPInRange(network, subnet *net.IPNet) (net.IP, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	key := network.String()
+	allocated, ok := allocatedIPs[key]
+	if !ok {
+		allocated = newAllocatedMap(network)
+		allocatedIPs[key] = allocated
+	}
+
+	// The network and broadcast addresses of the subnet are skipped too
+	firstIP, lastIP := networkdriver.NetworkRange(subnet)
+	begin := big.NewInt(0).Add(ipToBigInt(firstIP), big.NewInt(1))
+	end := big.NewInt(0).Sub(ipToBigInt(lastIP), big.NewInt(1))
+	if begin.Cmp(allocated.begin) == -1 {
+		begin = allocated.begin
+	}
+	if end.Cmp(allocated.end) == 1 {
+		end = allocated.end
+	}
+	for pos := begin; pos.Cmp(end) <= 0; pos = big.NewInt(0).Add(pos, big.NewInt(1)) {
+		ip := bigIntToIP(pos)
+		if _, ok := allocated.p[ip.String()]; ok {
+			continue
+		}
+		allocated.p[ip.String()] = struct{}{}
+		counters.Add("requests", 1)
+		return ip, nil
+	}
+	counters.Add("exhausted", 1)
+	return nil, ErrNoAvailableIPs
 }
 
 // ReleaseIP adds the provided ip back into the pool of
@@ -100,10 +151,62 @@ func ReleaseIP(network *net.IPNet, ip net.IP) error {
 	defer lock.Unlock()
 	if allocated, exists := allocatedIPs[network.String()]; exists {
 		delete(allocated.p, ip.String())
+		counters.Add("releases", 1)
 	}
 	return nil
 }
 
+// Usage returns the number of ips allocated on the given network and the
+// number of ips the network can hand out.
+func Usage(network *net.IPNet) (allocated, size int) {
+	lock.Lock()
+	defer lock.Unlock()
+	n, ok := allocatedIPs[network.String()]
+	if !ok {
+		n = newAllocatedMap(network)
+	}
+	size = int(n.size().Int64())
+	return len(n.p), size
+}
+
+// RemainingIPs returns the number of ips the given network can still hand
+// out, at most math.MaxInt32 for the large IPv6 networks.
+func RemainingIPs(network *net.IPNet) int {
+	lock.Lock()
+	defer lock.Unlock()
+	n, ok := allocatedIPs[network.String()]
+	if !ok {
+		n = newAllocatedMap(network)
+	}
+	remaining := n.size()
+	remaining.Sub(remaining, big.NewInt(int64(len(n.p))))
+	if remaining.Cmp(big.NewInt(math.MaxInt32)) > 0 {
+		return math.MaxInt32
+	}
+	return int(remaining.Int64())
+}
+
+// Utilization returns the percentage of the ips allocated on each network
+// ips were requested from, keyed by the network in CIDR notation.
+func Utilization() map[string]float64 {
+	lock.Lock()
+	defer lock.Unlock()
+	percentages := make(map[string]float64, len(allocatedIPs))
+	for key, n := range allocatedIPs {
+		size, _ := new(big.Float).SetInt(n.size()).Float64()
+		if size > 0 {
+			percentages[key] = float64(len(n.p)) * 100 / size
+		}
+	}
+	return percentages
+}
+
+// size returns the number of ips of the range handed out.
+func (allocated *allocatedMap) size() *big.Int {
+	size := big.NewInt(0).Sub(allocated.end, allocated.begin)
+	return size.Add(size, big.NewInt(1))
+}
+
 func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
 	if _, ok := allocated.p[ip.String()]; ok {
 		return nil, ErrIPAlreadyAllocated
@@ -2,6 +2,7 @@ package ipallocator
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"net"
 	"testing"
@@ -593,6 +594,85 @@ func TestAllocateFromRange(t *testing.T) {
 	}
 }
 
+func TestUsage(t *testing.T) {
+	defer reset()
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	if allocated, size := Usage(network); allocated != 0 || size != 253 {
+		t.Fatalf("Expected 0/253 ips allocated, got %d/%d", allocated, size)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := RequestIP(network, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allocated, size := Usage(network); allocated != 3 || size != 253 {
+		t.Fatalf("Expected 3/253 ips allocated, got %d/%d", allocated, size)
+	}
+}
+
+func TestRemainingIPs(t *testing.T) {
+	defer reset()
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := RequestIP(network, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if remaining := RemainingIPs(network); remaining != 250 {
+		t.Fatalf("Expected 250 ips remaining, got %d", remaining)
+	}
+	percentages := Utilization()
+	if len(percentages) != 1 || percentages["192.168.0.1/24"] != float64(3)*100/253 {
+		t.Fatalf("Expected 3/253 of the network to be used, got %v", percentages)
+	}
+
+	// The remaining ips of the large networks are bounded
+	ipv6 := &net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)}
+	if remaining := RemainingIPs(ipv6); remaining != math.MaxInt32 {
+		t.Fatalf("Expected the remaining ips to be bounded, got %d", remaining)
+	}
+}
+
+func TestRequestIPInRange(t *testing.T) {
+	defer reset()
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+	// 192.168.0.65 - 192.168.0.66
+	subnet := &net.IPNet{
+		IP:   []byte{192, 168, 0, 64},
+		Mask: []byte{255, 255, 255, 252},
+	}
+
+	if _, err := RequestIP(network, net.IPv4(192, 168, 0, 65)); err != nil {
+		t.Fatal(err)
+	}
+	ip, err := RequestIPInRange(network, subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.IPv4(192, 168, 0, 66), ip)
+	if _, err := RequestIPInRange(network, subnet); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected ErrNoAvailableIPs error, got %v", err)
+	}
+
+	// The range is that of the network at most
+	ip, err = RequestIPInRange(network, &net.IPNet{IP: []byte{192, 168, 0, 0}, Mask: []byte{255, 255, 0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.IPv4(192, 168, 0, 2), ip)
+}
+
 func assertIPEquals(t *testing.T, ip1, ip2 net.IP) {
 	if !ip1.Equal(ip2) {
 		t.Fatalf("Expected IP %s, got %s", ip1, ip2)
@@ -35,10 +35,14 @@ func TestOverlapingNameservers(t *testing.T) {
 }
 
 func TestCheckRouteOverlaps(t *testing.T) {
-	orig := networkGetRoutesFct
+	orig, origAddrs := networkGetRoutesFct, interfaceAddrsFct
 	defer func() {
 		networkGetRoutesFct = orig
+		interfaceAddrsFct = origAddrs
 	}()
+	interfaceAddrsFct = func() ([]netlink.IfAddr, error) {
+		return nil, nil
+	}
 	networkGetRoutesFct = func() ([]netlink.Route, error) {
 		routesData := []string{"10.0.2.0/32", "10.0.3.0/24", "10.0.42.0/24", "172.16.42.0/24", "192.168.142.0/24"}
 
@@ -61,6 +65,44 @@ func TestCheckRouteOverlaps(t *testing.T) {
 	}
 }
 
+func TestCheckRouteOverlapsExclusions(t *testing.T) {
+	orig, origAddrs := networkGetRoutesFct, interfaceAddrsFct
+	defer func() {
+		networkGetRoutesFct = orig
+		interfaceAddrsFct = origAddrs
+	}()
+	eth0 := &net.Interface{Name: "eth0"}
+	networkGetRoutesFct = func() ([]netlink.Route, error) {
+		_, vpn, _ := net.ParseCIDR("10.0.0.0/8")
+		return []netlink.Route{{IPNet: vpn, Iface: &net.Interface{Name: "tun0"}}}, nil
+	}
+	interfaceAddrsFct = func() ([]netlink.IfAddr, error) {
+		// An address without a route of its own
+		ip, network, _ := net.ParseCIDR("10.0.2.15/32")
+		return []netlink.IfAddr{{Iface: eth0, IP: ip, IPNet: network}}, nil
+	}
+
+	_, netX, _ := net.ParseCIDR("10.1.0.0/16")
+	err := CheckRouteOverlaps(netX)
+	if e, ok := err.(*OverlapError); !ok || e.Address || e.Iface != "tun0" || e.Conflict.String() != "10.0.0.0/8" {
+		t.Fatalf("Expected an overlap with the route to 10.0.0.0/8 on tun0, got %v", err)
+	}
+	_, ignored, _ := net.ParseCIDR("10.0.0.0/8")
+	if err := CheckRouteOverlaps(netX, ignored); err != nil {
+		t.Fatalf("Expected the route to 10.0.0.0/8 to be ignored, got %s", err)
+	}
+
+	// The addresses are checked even within the ignored prefixes
+	_, netX, _ = net.ParseCIDR("10.0.2.0/24")
+	err = CheckRouteOverlaps(netX, ignored)
+	if e, ok := err.(*OverlapError); !ok || !e.Address || e.Iface != "eth0" {
+		t.Fatalf("Expected an overlap with the address of eth0, got %v", err)
+	}
+	if msg := err.Error(); msg != "requested network 10.0.2.0/24 overlaps with the address 10.0.2.15/32 on eth0" {
+		t.Fatalf("Unexpected error message %q", msg)
+	}
+}
+
 func TestCheckNameserverOverlaps(t *testing.T) {
 	nameservers := []string{"10.0.2.3/32", "192.168.102.1/32"}
 
@@ -173,3 +215,19 @@ func TestNetworkRange(t *testing.T) {
 		t.Error(last.String())
 	}
 }
+
+func TestMacAddrGeneration(t *testing.T) {
+	ip := net.ParseIP("192.168.0.1")
+	mac := GenerateMacAddr(ip).String()
+
+	// Should be consistent.
+	if GenerateMacAddr(ip).String() != mac {
+		t.Fatal("Inconsistent MAC address")
+	}
+
+	// Should be unique.
+	ip2 := net.ParseIP("192.168.0.2")
+	if GenerateMacAddr(ip2).String() == mac {
+		t.Fatal("Non-unique MAC address")
+	}
+}
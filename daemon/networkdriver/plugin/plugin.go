@@ -0,0 +1,346 @@
+// Package plugin hands the networks of the containers over to an external
+// plugin, so that third parties can network them their own way, such as
+// with an SDN or a cloud fabric, without changing the daemon.
+//
+// The plugin serves JSON-RPC 1.0 on a unix socket, as net/rpc/jsonrpc does,
+// and is called:
+//
+//	NetworkDriver.CreateNetwork  once, when the daemon starts
+//	NetworkDriver.CreateEndpoint when a container is about to start, to get its addresses
+//	NetworkDriver.Join           once the container runs, with its pid and its published ports
+//	NetworkDriver.Leave          when the container stopped
+//
+// The interface of an endpoint given a bridge is set up by the daemon as for
+// the bridge driver, with a veth pair. Without one, the plugin sets up the
+// network of the container in its namespace, when it joins.
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/networkdriver"
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
+	"github.com/docker/docker/engine"
+)
+
+// DefaultNetwork is the network of the daemon, unless it has an instance
+// name.
+const DefaultNetwork = "docker"
+
+type CreateNetworkRequest struct {
+	NetworkID string
+	Options   map[string]string // settings of the daemon network, ex: "Mtu"
+}
+
+type CreateNetworkResponse struct{}
+
+type CreateEndpointRequest struct {
+	NetworkID  string
+	EndpointID string            // id of the container
+	Options    map[string]string // settings of the container network, ex: "RequestedMac"
+}
+
+type CreateEndpointResponse struct {
+	Address    string // address of the container, in CIDR notation
+	Gateway    string
+	MacAddress string // generated by the daemon if empty
+	Bridge     string // bridge the daemon attaches the container to, empty for the plugin to set up the container network
+}
+
+type PortBinding struct {
+	Proto         string // "tcp" or "udp"
+	HostIP        string
+	HostPort      int
+	ContainerPort int
+}
+
+type JoinRequest struct {
+	NetworkID    string
+	EndpointID   string
+	Pid          int           // process of the container, in the network namespace to set up
+	PortBindings []PortBinding // host ports reserved by the daemon for the plugin to forward
+}
+
+type JoinResponse struct{}
+
+type LeaveRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+type LeaveResponse struct{}
+
+// NetworkInfo describes the network of the plugin, as the bridge driver
+// describes its own.
+type NetworkInfo struct {
+	Name       string
+	Driver     string
+	Subnets    []string // of the addresses the plugin gave
+	Containers int
+}
+
+type endpoint struct {
+	subnet string
+	ports  []PortBinding
+}
+
+// Driver implements the network jobs of the daemon by calling the plugin.
+type Driver struct {
+	socket  string
+	network string
+
+	sync.Mutex
+	endpoints map[string]*endpoint
+}
+
+// InitDriver is the init_networkdriver job using the plugin listening on
+// the socket given in Plugin.
+func InitDriver(job *engine.Job) engine.Status {
+	network := job.Getenv("Instance")
+	if network == "" {
+		network = DefaultNetwork
+	}
+	d := NewDriver(job.Getenv("Plugin"), network)
+
+	options := make(map[string]string)
+	for key, value := range job.Environ() {
+		if key != "Plugin" {
+			options[key] = value
+		}
+	}
+	if err := d.call("NetworkDriver.CreateNetwork", &CreateNetworkRequest{NetworkID: network, Options: options}, &CreateNetworkResponse{}); err != nil {
+		return job.Error(err)
+	}
+	if err := d.Install(job.Eng); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func NewDriver(socket, network string) *Driver {
+	return &Driver{
+		socket:    socket,
+		network:   network,
+		endpoints: make(map[string]*endpoint),
+	}
+}
+
+// Install registers the network jobs of the driver in eng.
+func (d *Driver) Install(eng *engine.Engine) error {
+	for name, f := range map[string]engine.Handler{
+		"allocate_interface":     d.Allocate,
+		"release_interface":      d.Release,
+		"allocate_port":          d.AllocatePort,
+		"attach_interface":       d.Attach,
+		"link":                   d.Link,
+		"list_networks":          d.ListNetworks,
+		"restore_interface":      d.Restore,
+		"shutdown_networkdriver": d.Shutdown,
+	} {
+		if err := eng.Register(name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call calls the plugin, on a connection of its own so that a restarted
+// plugin is reached again.
+func (d *Driver) call(method string, args, reply interface{}) error {
+	client, err := jsonrpc.Dial("unix", d.socket)
+	if err != nil {
+		return fmt.Errorf("Unable to reach the network plugin %s: %s", d.socket, err)
+	}
+	defer client.Close()
+	if err := client.Call(method, args, reply); err != nil {
+		if err == rpc.ErrShutdown {
+			return fmt.Errorf("Unable to reach the network plugin %s: %s", d.socket, err)
+		}
+		return fmt.Errorf("The network plugin failed %s: %s", method, err)
+	}
+	return nil
+}
+
+func (d *Driver) Allocate(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	var ep CreateEndpointResponse
+	if err := d.call("NetworkDriver.CreateEndpoint", &CreateEndpointRequest{
+		NetworkID:  d.network,
+		EndpointID: id,
+		Options:    job.Environ(),
+	}, &ep); err != nil {
+		return job.Error(err)
+	}
+
+	ip, network, err := net.ParseCIDR(ep.Address)
+	if err != nil {
+		d.leave(id)
+		return job.Errorf("The network plugin gave the invalid address %q: %s", ep.Address, err)
+	}
+	mac := ep.MacAddress
+	if mac == "" {
+		mac = networkdriver.GenerateMacAddr(ip).String()
+	}
+	size, _ := network.Mask.Size()
+
+	d.Lock()
+	d.endpoints[id] = &endpoint{subnet: network.String()}
+	d.Unlock()
+
+	out := engine.Env{}
+	out.Set("IP", ip.String())
+	out.Set("Mask", net.IP(network.Mask).String())
+	out.Set("Gateway", ep.Gateway)
+	out.Set("MacAddress", mac)
+	out.Set("Bridge", ep.Bridge)
+	out.SetInt("IPPrefixLen", size)
+	out.WriteTo(job.Stdout)
+	return engine.StatusOK
+}
+
+// AllocatePort reserves a host port, forwarded by the plugin once the
+// container joins.
+func (d *Driver) AllocatePort(job *engine.Job) engine.Status {
+	var (
+		id     = job.Args[0]
+		proto  = job.Getenv("Proto")
+		hostIP = net.ParseIP("0.0.0.0")
+	)
+	if ip := job.Getenv("HostIP"); ip != "" {
+		if hostIP = net.ParseIP(ip); hostIP == nil {
+			return job.Errorf("Bad parameter: invalid host ip %s", ip)
+		}
+	}
+	if proto != "tcp" && proto != "udp" {
+		return job.Errorf("unsupported address type %s", proto)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	ep := d.endpoints[id]
+	if ep == nil {
+		return job.Errorf("No network endpoint for %s", id)
+	}
+	port, err := portallocator.RequestPort(hostIP, proto, job.GetenvInt("HostPort"))
+	if err != nil {
+		return job.Error(err)
+	}
+	ep.ports = append(ep.ports, PortBinding{
+		Proto:         proto,
+		HostIP:        hostIP.String(),
+		HostPort:      port,
+		ContainerPort: job.GetenvInt("ContainerPort"),
+	})
+
+	out := engine.Env{}
+	out.Set("HostIP", hostIP.String())
+	out.SetInt("HostPort", port)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// Attach joins the running container, whose process is given in Pid, to
+// the network.
+func (d *Driver) Attach(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	d.Lock()
+	ep := d.endpoints[id]
+	d.Unlock()
+	if ep == nil {
+		return job.Errorf("No network endpoint for %s", id)
+	}
+	if err := d.call("NetworkDriver.Join", &JoinRequest{
+		NetworkID:    d.network,
+		EndpointID:   id,
+		Pid:          job.GetenvInt("Pid"),
+		PortBindings: ep.ports,
+	}, &JoinResponse{}); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func (d *Driver) Release(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	d.Lock()
+	_, exists := d.endpoints[id]
+	d.Unlock()
+	if !exists {
+		return job.Errorf("No network endpoint for %s", id)
+	}
+	if err := d.leave(id); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// leave tells the plugin the container left and frees its host ports.
+func (d *Driver) leave(id string) error {
+	d.Lock()
+	ep := d.endpoints[id]
+	delete(d.endpoints, id)
+	d.Unlock()
+	if ep != nil {
+		for _, p := range ep.ports {
+			portallocator.ReleasePort(net.ParseIP(p.HostIP), p.Proto, p.HostPort)
+		}
+	}
+	return d.call("NetworkDriver.Leave", &LeaveRequest{NetworkID: d.network, EndpointID: id}, &LeaveResponse{})
+}
+
+// ListNetworks is the job writing the network of the plugin, as a JSON list
+// of NetworkInfo.
+func (d *Driver) ListNetworks(job *engine.Job) engine.Status {
+	info := NetworkInfo{Name: d.network, Driver: "plugin", Subnets: []string{}}
+	d.Lock()
+	seen := make(map[string]bool)
+	for _, ep := range d.endpoints {
+		if !seen[ep.subnet] {
+			seen[ep.subnet] = true
+			info.Subnets = append(info.Subnets, ep.subnet)
+		}
+	}
+	info.Containers = len(d.endpoints)
+	d.Unlock()
+	sort.Strings(info.Subnets)
+
+	if err := json.NewEncoder(job.Stdout).Encode([]NetworkInfo{info}); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// Link leaves the communication between linked containers to the plugin.
+func (d *Driver) Link(job *engine.Job) engine.Status {
+	return engine.StatusOK
+}
+
+// Restore makes the daemon allocate the interfaces of the restored
+// containers again, the plugin keeps what it needs itself.
+func (d *Driver) Restore(job *engine.Job) engine.Status {
+	return job.Errorf("The network plugin restores no interface")
+}
+
+func (d *Driver) Shutdown(job *engine.Job) engine.Status {
+	d.Lock()
+	ids := make([]string, 0, len(d.endpoints))
+	for id := range d.endpoints {
+		ids = append(ids, id)
+	}
+	d.Unlock()
+	for _, id := range ids {
+		if err := d.leave(id); err != nil {
+			log.Infof("Unable to release the network endpoint of %s: %s", id, err)
+		}
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+// NetworkDriver is a plugin giving the containers the addresses of
+// 10.1.0.0/24, keeping what it was called with.
+type NetworkDriver struct {
+	networks []string
+	joined   map[string]*JoinRequest
+	next     int
+}
+
+func (p *NetworkDriver) CreateNetwork(req *CreateNetworkRequest, resp *CreateNetworkResponse) error {
+	p.networks = append(p.networks, req.NetworkID)
+	return nil
+}
+
+func (p *NetworkDriver) CreateEndpoint(req *CreateEndpointRequest, resp *CreateEndpointResponse) error {
+	if req.Options["RequestedIP"] == "10.1.0.1" {
+		return fmt.Errorf("10.1.0.1 is the gateway")
+	}
+	p.next++
+	resp.Address = fmt.Sprintf("10.1.0.%d/24", p.next+1)
+	resp.Gateway = "10.1.0.1"
+	return nil
+}
+
+func (p *NetworkDriver) Join(req *JoinRequest, resp *JoinResponse) error {
+	p.joined[req.EndpointID] = req
+	return nil
+}
+
+func (p *NetworkDriver) Leave(req *LeaveRequest, resp *LeaveResponse) error {
+	delete(p.joined, req.EndpointID)
+	return nil
+}
+
+func servePlugin(t *testing.T, p *NetworkDriver) (string, func()) {
+	dir, err := ioutil.TempDir("", "docker-network-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := path.Join(dir, "plugin.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(p); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return socket, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func runJob(t *testing.T, job *engine.Job) engine.Env {
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return *out
+}
+
+func TestPluginDriver(t *testing.T) {
+	p := &NetworkDriver{joined: make(map[string]*JoinRequest)}
+	socket, stop := servePlugin(t, p)
+	defer stop()
+
+	eng := engine.New()
+	eng.Logging = false
+	if err := eng.Register("init_networkdriver", InitDriver); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("init_networkdriver")
+	job.Setenv("Plugin", socket)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.networks) != 1 || p.networks[0] != DefaultNetwork {
+		t.Fatalf("Expected the network %s to be created, got %v", DefaultNetwork, p.networks)
+	}
+
+	out := runJob(t, eng.Job("allocate_interface", "container_id"))
+	if out.Get("IP") != "10.1.0.2" || out.GetInt("IPPrefixLen") != 24 || out.Get("Gateway") != "10.1.0.1" {
+		t.Fatalf("Expected the address the plugin gave, got %v", out)
+	}
+	if out.Get("MacAddress") != "02:42:0a:01:00:02" {
+		t.Fatalf("Expected the MAC address of the ip, got %s", out.Get("MacAddress"))
+	}
+
+	job = eng.Job("allocate_port", "container_id")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("HostPort", "18080")
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", "80")
+	out = runJob(t, job)
+	if out.GetInt("HostPort") != 18080 {
+		t.Fatalf("Expected the host port 18080, got %v", out)
+	}
+
+	var listed bytes.Buffer
+	job = eng.Job("list_networks")
+	job.Stdout.Add(&listed)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var networks []NetworkInfo
+	if err := json.Unmarshal(listed.Bytes(), &networks); err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 || networks[0].Name != DefaultNetwork || networks[0].Driver != "plugin" || networks[0].Containers != 1 ||
+		len(networks[0].Subnets) != 1 || networks[0].Subnets[0] != "10.1.0.0/24" {
+		t.Fatalf("Unexpected networks %+v", networks)
+	}
+
+	job = eng.Job("attach_interface", "container_id")
+	job.SetenvInt("Pid", 42)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	joined := p.joined["container_id"]
+	if joined == nil || joined.Pid != 42 {
+		t.Fatalf("Expected the container to join with its pid, got %v", joined)
+	}
+	expected := PortBinding{Proto: "tcp", HostIP: "127.0.0.1", HostPort: 18080, ContainerPort: 80}
+	if len(joined.PortBindings) != 1 || joined.PortBindings[0] != expected {
+		t.Fatalf("Expected the port binding %v, got %v", expected, joined.PortBindings)
+	}
+
+	if err := eng.Job("release_interface", "container_id").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := p.joined["container_id"]; exists {
+		t.Fatal("Expected the container to leave the network")
+	}
+	// The host port is free again
+	job = eng.Job("allocate_interface", "other_id")
+	job.Stdout.AddEnv()
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	job = eng.Job("allocate_port", "other_id")
+	job.Setenv("HostIP", "127.0.0.1")
+	job.Setenv("HostPort", "18080")
+	job.Setenv("Proto", "tcp")
+	job.Setenv("ContainerPort", "80")
+	runJob(t, job)
+
+	// The errors of the plugin are those of the jobs
+	job = eng.Job("allocate_interface", "gateway_id")
+	job.Setenv("RequestedIP", "10.1.0.1")
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected the error of the plugin")
+	}
+}
+
+func TestPluginUnreachable(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+	eng.Register("init_networkdriver", InitDriver)
+	job := eng.Job("init_networkdriver")
+	job.Setenv("Plugin", "/nonexistent/plugin.sock")
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected the daemon to fail without its plugin")
+	}
+}
@@ -0,0 +1,140 @@
+// Package policy asks an external policy engine about the addresses and
+// ports allocated to the containers, so that sites can deny them or change
+// them, such as to veto a static ip or to give the containers of a tenant
+// the addresses of its own range.
+//
+// The policy engine serves JSON-RPC 1.0 on a unix socket, as the network
+// plugins do, and is called synchronously:
+//
+//	NetworkPolicy.PreAllocateIP    before the ip of a container is allocated
+//	NetworkPolicy.PostAllocateIP   once it is, before the container gets it
+//	NetworkPolicy.PreAllocatePort  before a port of a container is published
+//	NetworkPolicy.PostAllocatePort once it is, before the container gets it
+//
+// Any of them can deny the allocation by giving a reason in Deny. An engine
+// unreachable denies them all.
+package policy
+
+import (
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+type PreAllocateIPRequest struct {
+	Container   string            // id of the container
+	Network     string            // network of the bridge, in CIDR notation
+	RequestedIP string            // static ip asked for, empty if none
+	Options     map[string]string // settings of the container network, ex: "ContainerName"
+}
+
+type PreAllocateIPResponse struct {
+	Deny        string // reason the allocation is denied, empty to allow it
+	RequestedIP string // ip to allocate instead, empty to keep the request
+	Range       string // subnet of the network to allocate the ip from, in CIDR notation, empty for any
+}
+
+type PostAllocateIPRequest struct {
+	Container  string
+	IP         string
+	MacAddress string
+}
+
+type PostAllocateIPResponse struct {
+	Deny string // reason the ip is released, empty to keep it
+}
+
+type PreAllocatePortRequest struct {
+	Container     string
+	Proto         string // "tcp" or "udp"
+	HostIP        string
+	HostPort      int // 0 for any
+	ContainerPort int
+	Options       map[string]string // settings of the port mapping
+}
+
+type PreAllocatePortResponse struct {
+	Deny     string // reason the port isn't published, empty to publish it
+	HostIP   string // host ip to publish the port on instead, empty to keep the request
+	HostPort int    // host port to publish instead, 0 to keep the request
+}
+
+type PostAllocatePortRequest struct {
+	Container     string
+	Proto         string
+	HostIP        string
+	HostPort      int
+	ContainerIP   string
+	ContainerPort int
+}
+
+type PostAllocatePortResponse struct {
+	Deny string // reason the port is unpublished, empty to keep it
+}
+
+// Client calls the policy engine listening on a unix socket.
+type Client struct {
+	socket string
+}
+
+func NewClient(socket string) *Client {
+	return &Client{socket: socket}
+}
+
+// call calls the policy engine, on a connection of its own so that a
+// restarted engine is reached again.
+func (c *Client) call(method string, args, reply interface{}) error {
+	client, err := jsonrpc.Dial("unix", c.socket)
+	if err != nil {
+		return fmt.Errorf("Unable to reach the network policy engine %s: %s", c.socket, err)
+	}
+	defer client.Close()
+	if err := client.Call(method, args, reply); err != nil {
+		if err == rpc.ErrShutdown {
+			return fmt.Errorf("Unable to reach the network policy engine %s: %s", c.socket, err)
+		}
+		return fmt.Errorf("The network policy engine failed %s: %s", method, err)
+	}
+	return nil
+}
+
+// denied returns the error of an allocation denied for reason, nil if it
+// is allowed.
+func denied(reason string) error {
+	if reason == "" {
+		return nil
+	}
+	return fmt.Errorf("Denied by the network policy: %s", reason)
+}
+
+func (c *Client) PreAllocateIP(req *PreAllocateIPRequest) (*PreAllocateIPResponse, error) {
+	var resp PreAllocateIPResponse
+	if err := c.call("NetworkPolicy.PreAllocateIP", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, denied(resp.Deny)
+}
+
+func (c *Client) PostAllocateIP(req *PostAllocateIPRequest) error {
+	var resp PostAllocateIPResponse
+	if err := c.call("NetworkPolicy.PostAllocateIP", req, &resp); err != nil {
+		return err
+	}
+	return denied(resp.Deny)
+}
+
+func (c *Client) PreAllocatePort(req *PreAllocatePortRequest) (*PreAllocatePortResponse, error) {
+	var resp PreAllocatePortResponse
+	if err := c.call("NetworkPolicy.PreAllocatePort", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, denied(resp.Deny)
+}
+
+func (c *Client) PostAllocatePort(req *PostAllocatePortRequest) error {
+	var resp PostAllocatePortResponse
+	if err := c.call("NetworkPolicy.PostAllocatePort", req, &resp); err != nil {
+		return err
+	}
+	return denied(resp.Deny)
+}
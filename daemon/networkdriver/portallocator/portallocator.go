@@ -2,6 +2,7 @@ package portallocator
 
 import (
 	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"sync"
@@ -15,7 +16,7 @@ type portMap struct {
 func newPortMap() *portMap {
 	return &portMap{
 		p:    map[int]struct{}{},
-		last: EndPortRange,
+		last: endPortRange,
 	}
 }
 
@@ -45,6 +46,13 @@ var (
 
 	defaultIP = net.ParseIP("0.0.0.0")
 	globalMap = ipMapping{}
+
+	// The range of the ports allocated when none is requested
+	beginPortRange = BeginPortRange
+	endPortRange   = EndPortRange
+
+	// Published as /debug/vars when the api runs in debug mode
+	counters = expvar.NewMap("portallocator")
 )
 
 type ErrPortAlreadyAllocated struct {
@@ -99,15 +107,19 @@ func RequestPort(ip net.IP, proto string, port int) (int, error) {
 	if port > 0 {
 		if _, ok := mapping.p[port]; !ok {
 			mapping.p[port] = struct{}{}
+			counters.Add("requests", 1)
 			return port, nil
 		}
+		counters.Add("conflicts", 1)
 		return 0, NewErrPortAlreadyAllocated(ipstr, port)
 	}
 
 	port, err := mapping.findPort()
 	if err != nil {
+		counters.Add("exhausted", 1)
 		return 0, err
 	}
+	counters.Add("requests", 1)
 	return port, nil
 }
 
@@ -123,7 +135,10 @@ func ReleasePort(ip net.IP, proto string, port int) error {
 	if !ok {
 		return nil
 	}
-	delete(protomap[proto].p, port)
+	if _, ok := protomap[proto].p[port]; ok {
+		delete(protomap[proto].p, port)
+		counters.Add("releases", 1)
+	}
 	return nil
 }
 
@@ -135,10 +150,89 @@ func ReleaseAll() error {
 	return nil
 }
 
+// SetPortRange changes the range of the ports allocated when none is
+// requested. The ports already allocated are kept.
+func SetPortRange(begin, end int) error {
+	if begin < 1 || end > 65535 || begin > end {
+		return fmt.Errorf("Invalid port range %d-%d", begin, end)
+	}
+	mutex.Lock()
+	beginPortRange, endPortRange = begin, end
+	mutex.Unlock()
+	return nil
+}
+
+// PortRange returns the range of the ports allocated when none is requested.
+func PortRange() (begin, end int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return beginPortRange, endPortRange
+}
+
+// Usage returns the number of ports of the dynamic range allocated for
+// proto, summed over all ips, and the size of the range.
+func Usage(proto string) (allocated, size int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, protomap := range globalMap {
+		if pm, ok := protomap[proto]; ok {
+			allocated += pm.allocatedInRange()
+		}
+	}
+	return allocated, endPortRange - beginPortRange + 1
+}
+
+// RemainingPorts returns the number of ports of the dynamic range still
+// free for proto on ip, nil for 0.0.0.0.
+func RemainingPorts(ip net.IP, proto string) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if ip == nil {
+		ip = defaultIP
+	}
+	remaining := endPortRange - beginPortRange + 1
+	if protomap, ok := globalMap[ip.String()]; ok {
+		if pm, ok := protomap[proto]; ok {
+			remaining -= pm.allocatedInRange()
+		}
+	}
+	return remaining
+}
+
+// Utilization returns the percentage of the dynamic range allocated for
+// proto on each ip ports were requested on, keyed by the ip.
+func Utilization(proto string) map[string]float64 {
+	mutex.Lock()
+	defer mutex.Unlock()
+	size := float64(endPortRange - beginPortRange + 1)
+	percentages := make(map[string]float64, len(globalMap))
+	for ip, protomap := range globalMap {
+		if pm, ok := protomap[proto]; ok {
+			percentages[ip] = float64(pm.allocatedInRange()) * 100 / size
+		}
+	}
+	return percentages
+}
+
+// allocatedInRange returns the number of ports of the dynamic range
+// allocated.
+func (pm *portMap) allocatedInRange() int {
+	allocated := 0
+	for port := range pm.p {
+		if port >= beginPortRange && port <= endPortRange {
+			allocated++
+		}
+	}
+	return allocated
+}
+
 func (pm *portMap) findPort() (int, error) {
-	for port := pm.last + 1; port != pm.last; port++ {
-		if port > EndPortRange {
-			port = BeginPortRange
+	// The last port might be out of the range if it changed since
+	port := pm.last
+	for i := beginPortRange; i <= endPortRange; i++ {
+		port++
+		if port < beginPortRange || port > endPortRange {
+			port = beginPortRange
 		}
 
 		if _, ok := pm.p[port]; !ok {
@@ -230,3 +230,85 @@ func TestNoDuplicateBPR(t *testing.T) {
 		t.Fatalf("Acquire(0) allocated the same port twice: %d", port)
 	}
 }
+
+func TestUsage(t *testing.T) {
+	defer reset()
+
+	for _, ip := range []net.IP{defaultIP, net.ParseIP("127.0.0.1")} {
+		if _, err := RequestPort(ip, "tcp", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Out of the dynamic range
+	if _, err := RequestPort(defaultIP, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	if allocated, size := Usage("tcp"); allocated != 2 || size != EndPortRange-BeginPortRange+1 {
+		t.Fatalf("Expected 2 tcp ports allocated, got %d/%d", allocated, size)
+	}
+	if allocated, _ := Usage("udp"); allocated != 0 {
+		t.Fatalf("Expected no udp port allocated, got %d", allocated)
+	}
+}
+
+func TestRemainingPorts(t *testing.T) {
+	defer reset()
+
+	localhost := net.ParseIP("127.0.0.1")
+	for i := 0; i < 2; i++ {
+		if _, err := RequestPort(localhost, "tcp", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Out of the dynamic range
+	if _, err := RequestPort(localhost, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	size := EndPortRange - BeginPortRange + 1
+	if remaining := RemainingPorts(localhost, "tcp"); remaining != size-2 {
+		t.Fatalf("Expected %d tcp ports remaining, got %d", size-2, remaining)
+	}
+	if remaining := RemainingPorts(nil, "tcp"); remaining != size {
+		t.Fatalf("Expected the ports of 0.0.0.0 to remain, got %d", remaining)
+	}
+	if percentages := Utilization("tcp"); len(percentages) != 1 || percentages["127.0.0.1"] != float64(2)*100/float64(size) {
+		t.Fatalf("Expected 2 ports of the range to be used on 127.0.0.1, got %v", percentages)
+	}
+	if percentages := Utilization("udp"); percentages["127.0.0.1"] != 0 {
+		t.Fatalf("Expected no udp port to be used, got %v", percentages)
+	}
+}
+
+func TestSetPortRange(t *testing.T) {
+	defer reset()
+	defer SetPortRange(BeginPortRange, EndPortRange)
+
+	if err := SetPortRange(40000, 39999); err == nil {
+		t.Fatal("Expected an error for an inverted range")
+	}
+
+	if _, err := RequestPort(defaultIP, "tcp", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPortRange(40000, 40001); err != nil {
+		t.Fatal(err)
+	}
+	if begin, end := PortRange(); begin != 40000 || end != 40001 {
+		t.Fatalf("Expected the range to be 40000-40001, got %d-%d", begin, end)
+	}
+	for _, expected := range []int{40000, 40001} {
+		if port, err := RequestPort(defaultIP, "tcp", 0); err != nil {
+			t.Fatal(err)
+		} else if port != expected {
+			t.Fatalf("Expected port %d got %d", expected, port)
+		}
+	}
+	if _, err := RequestPort(defaultIP, "tcp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected ErrAllPortsAllocated, got %v", err)
+	}
+	if allocated, size := Usage("tcp"); allocated != 2 || size != 2 {
+		t.Fatalf("Expected 2 of 2 ports allocated, got %d of %d", allocated, size)
+	}
+}
@@ -0,0 +1,97 @@
+package portmapper
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+var (
+	activatedLock sync.Mutex
+	// the sockets bound by systemd for the published ports, by
+	// ip:port/proto, served by the userland proxies of their mappings
+	activated = make(map[string]*os.File)
+)
+
+// SetActivatedSockets hands over the sockets bound by systemd for the
+// published ports before the daemon started, so that the mappings of their
+// ports don't bind them again: their connections, even those made while
+// the daemon was starting, are served by the userland proxies.
+func SetActivatedSockets(files []*os.File) error {
+	sockets := make(map[string]*os.File)
+	for _, f := range files {
+		addr, err := socketAddr(f)
+		if err != nil {
+			return err
+		}
+		sockets[getKey(addr)] = f
+	}
+	activatedLock.Lock()
+	activated = sockets
+	activatedLock.Unlock()
+	return nil
+}
+
+// AddActivatedSocket hands over a socket bound for a published port, such
+// as by the daemon for a container started on demand.
+func AddActivatedSocket(f *os.File) error {
+	addr, err := socketAddr(f)
+	if err != nil {
+		return err
+	}
+	activatedLock.Lock()
+	activated[getKey(addr)] = f
+	activatedLock.Unlock()
+	return nil
+}
+
+// RemoveActivatedSocket takes back a socket given to AddActivatedSocket.
+func RemoveActivatedSocket(f *os.File) {
+	activatedLock.Lock()
+	defer activatedLock.Unlock()
+	for key, o := range activated {
+		if o == f {
+			delete(activated, key)
+		}
+	}
+}
+
+// activatedSocket returns the socket bound by systemd for a host port, nil
+// if there is none.
+func activatedSocket(proto string, ip net.IP, port int) *os.File {
+	if ip == nil || ip.IsUnspecified() {
+		ip = net.IPv4zero
+	}
+	var addr net.Addr = &net.TCPAddr{IP: ip, Port: port}
+	if proto == "udp" {
+		addr = &net.UDPAddr{IP: ip, Port: port}
+	}
+	activatedLock.Lock()
+	defer activatedLock.Unlock()
+	return activated[getKey(addr)]
+}
+
+// socketAddr returns the address a socket is bound to, the unspecified
+// addresses being 0.0.0.0.
+func socketAddr(f *os.File) (net.Addr, error) {
+	if l, err := net.FileListener(f); err == nil {
+		defer l.Close()
+		if addr, ok := l.Addr().(*net.TCPAddr); ok {
+			if addr.IP.IsUnspecified() {
+				addr.IP = net.IPv4zero
+			}
+			return addr, nil
+		}
+	}
+	if c, err := net.FilePacketConn(f); err == nil {
+		defer c.Close()
+		if addr, ok := c.LocalAddr().(*net.UDPAddr); ok {
+			if addr.IP.IsUnspecified() {
+				addr.IP = net.IPv4zero
+			}
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("The socket activated file %s is neither a TCP listener nor a UDP socket", f.Name())
+}
@@ -0,0 +1,83 @@
+package portmapper
+
+import (
+	"sync"
+
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// The mappings being set up at the same time, such as the many ports of a
+// container, have their forwarding rules added together by the firewalls
+// able to, rather than with commands of their own: the rules queued while a
+// batch is being added make up the next batch.
+
+// batchFirewall is a Firewall adding the forwarding rules of several
+// mappings at once, as an iptables chain does with iptables-restore.
+type batchFirewall interface {
+	Firewall
+	ForwardAll(forwardings []iptables.Forwarding) error
+}
+
+type forwardRequest struct {
+	forwarding iptables.Forwarding
+	done       chan error
+}
+
+var batches = struct {
+	sync.Mutex
+	queued map[batchFirewall][]*forwardRequest
+	adding map[batchFirewall]bool
+}{
+	queued: make(map[batchFirewall][]*forwardRequest),
+	adding: make(map[batchFirewall]bool),
+}
+
+// forward adds the forwarding rules of f along with those of the other
+// mappings being set up on the firewall.
+func forward(fw batchFirewall, f iptables.Forwarding) error {
+	req := &forwardRequest{forwarding: f, done: make(chan error, 1)}
+
+	batches.Lock()
+	batches.queued[fw] = append(batches.queued[fw], req)
+	if batches.adding[fw] {
+		// Added with the next batch
+		batches.Unlock()
+		return <-req.done
+	}
+	batches.adding[fw] = true
+	for len(batches.queued[fw]) > 0 {
+		batch := batches.queued[fw]
+		delete(batches.queued, fw)
+		batches.Unlock()
+		addBatch(fw, batch)
+		batches.Lock()
+	}
+	delete(batches.adding, fw)
+	batches.Unlock()
+	return <-req.done
+}
+
+// addBatch adds the forwarding rules of the batch at once, or one mapping
+// after the other if that fails, for each to get the error of its own.
+func addBatch(fw batchFirewall, batch []*forwardRequest) {
+	forwardings := make([]iptables.Forwarding, len(batch))
+	for i, req := range batch {
+		forwardings[i] = req.forwarding
+	}
+	if len(batch) > 1 {
+		if err := fw.ForwardAll(forwardings); err == nil {
+			for _, req := range batch {
+				req.done <- nil
+			}
+			return
+		}
+	}
+	for _, req := range batch {
+		f := req.forwarding
+		if fw.Forward(iptables.Check, f.IP, f.Port, f.Proto, f.DestAddr, f.DestPort) == nil {
+			req.done <- nil
+			continue
+		}
+		req.done <- fw.Forward(iptables.Add, f.IP, f.Port, f.Proto, f.DestAddr, f.DestPort)
+	}
+}
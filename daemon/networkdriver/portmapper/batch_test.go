@@ -0,0 +1,121 @@
+package portmapper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// batchingFirewall holds the rules in memory, its first rule being added
+// only once release is closed.
+type batchingFirewall struct {
+	sync.Mutex
+	rules   map[string]bool
+	batches [][]iptables.Forwarding
+	release chan struct{}
+}
+
+func (f *batchingFirewall) Forward(action iptables.Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error {
+	if action == iptables.Add {
+		<-f.release
+	}
+	f.Lock()
+	defer f.Unlock()
+	key := fmt.Sprintf("%s:%s:%d", proto, ip, port)
+	switch action {
+	case iptables.Check:
+		if !f.rules[key] {
+			return errors.New("no such rule")
+		}
+	case iptables.Add:
+		f.rules[key] = true
+	case iptables.Delete:
+		delete(f.rules, key)
+	}
+	return nil
+}
+
+func (f *batchingFirewall) NoTrack(action iptables.Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error {
+	return nil
+}
+
+func (f *batchingFirewall) ForwardAll(forwardings []iptables.Forwarding) error {
+	f.Lock()
+	defer f.Unlock()
+	f.batches = append(f.batches, forwardings)
+	for _, fw := range forwardings {
+		f.rules[fmt.Sprintf("%s:%s:%d", fw.Proto, fw.IP, fw.Port)] = true
+	}
+	return nil
+}
+
+func TestMapConcurrently(t *testing.T) {
+	defer reset()
+
+	var (
+		fw = &batchingFirewall{
+			rules:   make(map[string]bool),
+			release: make(chan struct{}),
+		}
+		hostIP    = net.ParseIP("172.16.0.1")
+		container = net.ParseIP("192.168.0.1")
+		wg        sync.WaitGroup
+		errs      = make(chan error, 11)
+	)
+	mapPort := func(port int) {
+		defer wg.Done()
+		if _, err := MapOnChain(fw, &net.TCPAddr{IP: container, Port: port}, hostIP, 2000+port, false); err != nil {
+			errs <- err
+		}
+	}
+
+	// The first mapping holds its batch, the others queue up meanwhile
+	wg.Add(1)
+	go mapPort(0)
+	waitQueued := func(n int) {
+		for start := time.Now(); ; {
+			batches.Lock()
+			adding, queued := batches.adding[fw], len(batches.queued[fw])
+			batches.Unlock()
+			if adding && queued == n {
+				return
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("Expected %d mappings to be queued, got %d", n, queued)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitQueued(0)
+	for port := 1; port <= 10; port++ {
+		wg.Add(1)
+		go mapPort(port)
+	}
+	waitQueued(10)
+	close(fw.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Failed to map the port: %s", err)
+	}
+
+	if len(currentMappings) != 11 || len(fw.rules) != 11 {
+		t.Fatalf("Expected 11 mappings with their rules, got %d mappings and %d rules", len(currentMappings), len(fw.rules))
+	}
+	if len(fw.batches) != 1 || len(fw.batches[0]) != 10 {
+		t.Fatalf("Expected the rules of the 10 mappings queued to be added at once, got %v", fw.batches)
+	}
+	if len(startingMappings) != 0 {
+		t.Fatalf("Expected the mappings to be set up, got %v starting", startingMappings)
+	}
+	for _, m := range currentMappings {
+		if err := Unmap(m.host); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
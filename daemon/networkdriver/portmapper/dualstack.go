@@ -0,0 +1,49 @@
+package portmapper
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+var (
+	fallbackLock sync.Mutex
+	// the addresses of the other family of the containers, by their ip,
+	// which the userland proxies of their TCP ports dial too
+	fallbackAddrs = make(map[string]*net.IPAddr)
+)
+
+// SetFallbackAddr has the userland proxies of the TCP ports published by
+// the container of ip dial fallback along with ip, whichever connects
+// first serving the connection. A nil fallback removes it. The proxies
+// started from then on are concerned.
+func SetFallbackAddr(ip net.IP, fallback *net.IPAddr) {
+	fallbackLock.Lock()
+	defer fallbackLock.Unlock()
+	if fallback == nil {
+		delete(fallbackAddrs, ip.String())
+		return
+	}
+	fallbackAddrs[ip.String()] = fallback
+}
+
+// fallbackAddr returns the address of the other family of the container of
+// ip, nil if there is none.
+func fallbackAddr(ip net.IP) *net.IPAddr {
+	fallbackLock.Lock()
+	defer fallbackLock.Unlock()
+	return fallbackAddrs[ip.String()]
+}
+
+// parseIPAddr parses an ip along with its zone, such as fe80::1%docker0.
+func parseIPAddr(s string) *net.IPAddr {
+	var zone string
+	if i := strings.LastIndex(s, "%"); i != -1 {
+		s, zone = s[:i], s[i+1:]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	return &net.IPAddr{IP: ip, Zone: zone}
+}
@@ -0,0 +1,44 @@
+package portmapper
+
+import (
+	"net"
+
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// Firewall sets up the rules forwarding the host ports to the containers.
+// An iptables chain is the firewall on Linux, a pf anchor on BSD.
+type Firewall interface {
+	// Forward adds, checks or deletes the rules redirecting the traffic of
+	// a host port to the container.
+	Forward(action iptables.Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error
+	// NoTrack adds, checks or deletes the rules exempting the traffic of a
+	// mapping served by the userland proxy from connection tracking.
+	NoTrack(action iptables.Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error
+}
+
+// SetFirewall sets the firewall of the mappings of Map and MapUntracked.
+func SetFirewall(f Firewall) {
+	chain = f
+}
+
+// dryRunFirewall tells whether the changes to f are only reported, such as
+// on the chain of a driver in dry-run mode, for the userland proxies of its
+// mappings to be logged as well.
+func dryRunFirewall(f Firewall) bool {
+	d, ok := f.(interface {
+		DryRun() bool
+	})
+	return ok && d.DryRun()
+}
+
+// sameFirewall tells whether a and b hold the same rules. An iptables chain
+// created again, such as by the reconciliation, is still the same chain.
+func sameFirewall(a, b Firewall) bool {
+	ca, ok := a.(*iptables.Chain)
+	cb, ok2 := b.(*iptables.Chain)
+	if ok && ok2 {
+		return ca.Name == cb.Name && ca.Ipv6 == cb.Ipv6
+	}
+	return a == b
+}
@@ -0,0 +1,12 @@
+// +build freebsd
+
+package portmapper
+
+import (
+	"github.com/docker/docker/pkg/pf"
+)
+
+func init() {
+	// The ports are mapped with pf unless SetFirewall says otherwise
+	chain = pf.NewAnchor(pf.DefaultAnchor, "")
+}
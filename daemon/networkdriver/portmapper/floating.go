@@ -0,0 +1,117 @@
+package portmapper
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Floating ips, such as the virtual ips VRRP moves between the hosts, are
+// only local while the host holds them. The ports published on them keep
+// their rules, but their userland proxies only listen while the ip is on
+// the host: they are started when it arrives and stopped when it leaves.
+
+// floatingIPs tells whether each floating ip is on the host.
+var floatingIPs = make(map[string]bool)
+
+// SetFloatingIPs declares the floating ips, elsewhere until
+// SetFloatingIPPresent tells otherwise.
+func SetFloatingIPs(ips []net.IP) {
+	lock.Lock()
+	defer lock.Unlock()
+	floatingIPs = make(map[string]bool)
+	for _, ip := range ips {
+		floatingIPs[ip.String()] = false
+	}
+}
+
+// AddFloatingIPs declares the floating ips not declared yet, elsewhere
+// until SetFloatingIPPresent tells otherwise, for the drivers of a process
+// to each declare theirs.
+func AddFloatingIPs(ips []net.IP) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, ip := range ips {
+		if _, exists := floatingIPs[ip.String()]; !exists {
+			floatingIPs[ip.String()] = false
+		}
+	}
+}
+
+// floatingElsewhere tells whether ip is a floating ip another host holds.
+// The lock must be held.
+func floatingElsewhere(ip net.IP) bool {
+	present, floating := floatingIPs[ip.String()]
+	return floating && !present
+}
+
+// SetFloatingIPPresent starts the userland proxies of the ports published
+// on the floating ip when it arrives on the host, and stops them when it
+// leaves. It returns the mappings whose proxies were started or stopped,
+// and the first proxy which failed to start, the others being started
+// nonetheless.
+func SetFloatingIPPresent(ip net.IP, present bool) ([]MappingState, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	key := ip.String()
+	if was, floating := floatingIPs[key]; !floating || was == present {
+		return nil, nil
+	}
+	floatingIPs[key] = present
+
+	var (
+		rebound  []MappingState
+		firstErr error
+	)
+	for _, m := range currentMappings {
+		hostIP, _ := getIPAndPort(m.host)
+		if !hostIP.Equal(ip) {
+			continue
+		}
+		changed, err := m.rebind()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if changed {
+			rebound = append(rebound, m.state())
+		}
+	}
+	return rebound, firstErr
+}
+
+// rebind starts the userland proxy of m if it should listen, and stops it
+// if it shouldn't, returning whether it did either. The lock must be held.
+func (m *mapping) rebind() (bool, error) {
+	listen := m.shouldListen()
+	if m.listening == listen {
+		return false, nil
+	}
+	if listen {
+		newProxy := NewProxy
+		if m.dryRun {
+			newProxy = newDryRunProxy
+		}
+		// A stopped proxy can't be started again
+		hostIP, hostPort := getIPAndPort(m.host)
+		containerIP, containerPort := getIPAndPort(m.container)
+		proxy := newProxy(m.proto, hostIP, hostPort, containerIP, containerPort)
+		if err := proxy.Start(); err != nil {
+			atomic.AddUint64(&proxyErrors, 1)
+			return false, err
+		}
+		m.userlandProxy = proxy
+	} else {
+		m.userlandProxy.Stop()
+	}
+	m.listening = listen
+	return true, nil
+}
+
+// shouldListen tells whether the userland proxy of m should run: neither
+// its floating host ip is elsewhere nor its container suspended. The lock
+// must be held.
+func (m *mapping) shouldListen() bool {
+	hostIP, _ := getIPAndPort(m.host)
+	containerIP, _ := getIPAndPort(m.container)
+	return !floatingElsewhere(hostIP) && !suspendedIPs[containerIP.String()]
+}
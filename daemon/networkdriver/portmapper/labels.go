@@ -0,0 +1,40 @@
+package portmapper
+
+import "net"
+
+// The mappings can carry a name and labels (ex: service=web, env=staging),
+// listed along with them, for the tools to tell the mappings apart and
+// filter them without keeping an index of their own.
+
+// Label names the mapping of host and replaces its labels.
+func Label(host net.Addr, name string, labels map[string]string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	m, exists := currentMappings[getKey(host)]
+	if !exists {
+		return ErrPortNotMapped
+	}
+	m.name = name
+	m.labels = nil
+	if len(labels) > 0 {
+		m.labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			m.labels[k] = v
+		}
+	}
+	return nil
+}
+
+// Labels returns the name and the labels of the mapping of host, empty if
+// it isn't mapped.
+func Labels(host net.Addr) (string, map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	m, exists := currentMappings[getKey(host)]
+	if !exists {
+		return "", nil
+	}
+	return m.name, m.labels
+}
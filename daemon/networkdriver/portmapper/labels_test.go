@@ -0,0 +1,36 @@
+package portmapper
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLabel(t *testing.T) {
+	defer reset()
+
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}
+	host, err := Map(container, net.ParseIP("192.168.0.1"), 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unmap(host)
+
+	labels := map[string]string{"service": "web", "env": "staging"}
+	if err := Label(host, "http", labels); err != nil {
+		t.Fatal(err)
+	}
+	// The labels are copied
+	labels["env"] = "production"
+
+	name, got := Labels(host)
+	if name != "http" || len(got) != 2 || got["service"] != "web" || got["env"] != "staging" {
+		t.Fatalf("Unexpected name %s and labels %v", name, got)
+	}
+	if state := Mappings(); len(state) != 1 || state[0].Name != "http" || state[0].Labels["service"] != "web" {
+		t.Fatalf("Expected the labels to be listed, got %+v", state)
+	}
+
+	if err := Label(&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 8081}, "http", nil); err != ErrPortNotMapped {
+		t.Fatalf("Expected %s, got %v", ErrPortNotMapped, err)
+	}
+}
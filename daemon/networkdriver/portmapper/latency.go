@@ -0,0 +1,65 @@
+package portmapper
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/docker/docker/pkg/proxy"
+)
+
+// The userland proxies of the TCP ports measure how long their connections
+// take to reach the containers and to get their first byte. Each proxy
+// process reports its latency distributions on a pipe to the daemon, which
+// keeps the last ones along with the mapping.
+
+// latencyReportInterval is how often a proxy reports its latency
+// distributions, when they changed.
+const latencyReportInterval = time.Second
+
+// latencyReporter is a proxy measuring the latency of its connections.
+type latencyReporter interface {
+	Latency() proxy.LatencyStats
+}
+
+// reportLatency writes the latency distributions of p to w as they change,
+// until w is closed.
+func reportLatency(p latencyReporter, w *os.File) {
+	var (
+		enc  = json.NewEncoder(w)
+		last uint64
+	)
+	for range time.Tick(latencyReportInterval) {
+		stats := p.Latency()
+		if count := stats.Connect.Count + stats.FirstByte.Count; count != last {
+			if err := enc.Encode(stats); err != nil {
+				return
+			}
+			last = count
+		}
+	}
+}
+
+// readLatency keeps the latency distributions reported by the proxy
+// process on r, until it exits.
+func (p *proxyCommand) readLatency(r *os.File) {
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	for {
+		var stats proxy.LatencyStats
+		if err := dec.Decode(&stats); err != nil {
+			return
+		}
+		p.latencyLock.Lock()
+		p.latency = &stats
+		p.latencyLock.Unlock()
+	}
+}
+
+// reportedLatency returns the last latency distributions reported by the
+// proxy, nil if none were.
+func (p *proxyCommand) reportedLatency() *proxy.LatencyStats {
+	p.latencyLock.Lock()
+	defer p.latencyLock.Unlock()
+	return p.latency
+}
@@ -2,13 +2,16 @@ package portmapper
 
 import (
 	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/networkdriver/portallocator"
 	"github.com/docker/docker/pkg/iptables"
+	"github.com/docker/docker/pkg/proxy"
 )
 
 type mapping struct {
@@ -16,16 +19,27 @@ type mapping struct {
 	userlandProxy UserlandProxy
 	host          net.Addr
 	container     net.Addr
+	untracked     bool // bypasses conntrack, served by the userland proxy only
+	chain         Firewall
+	listening     bool // the userland proxy runs, false while the floating host ip is elsewhere or the container suspended
+	dryRun        bool // the userland proxy is logged rather than run
+	name          string
+	labels        map[string]string
 }
 
 var (
-	chain *iptables.Chain
+	chain Firewall
 	lock  sync.Mutex
 
 	// udp:ip:port
 	currentMappings = make(map[string]*mapping)
+	// mappings being set up, their rules added and userland proxy started
+	startingMappings = make(map[string]bool)
 
 	NewProxy = NewProxyCommand
+
+	proxyErrors uint64 // userland proxies that failed to start
+	dryRun      bool
 )
 
 var (
@@ -34,20 +48,81 @@ var (
 	ErrPortNotMapped             = errors.New("port is not mapped")
 )
 
+func init() {
+	// Published as /debug/vars when the api runs in debug mode
+	expvar.Publish("portmapper", expvar.Func(func() interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		return map[string]interface{}{
+			"mappings":     len(currentMappings),
+			"proxy_errors": ProxyErrors(),
+		}
+	}))
+}
+
 func SetIptablesChain(c *iptables.Chain) {
+	if c == nil {
+		chain = nil
+		return
+	}
 	chain = c
 }
 
+// SetDryRun keeps the userland proxies from being started, they are logged
+// instead. The iptables rules are left to iptables.SetDryRun. The mappings
+// on a chain in dry-run mode are logged regardless.
+func SetDryRun(enabled bool) {
+	lock.Lock()
+	dryRun = enabled
+	lock.Unlock()
+}
+
+// ProxyErrors returns how many userland proxies failed to start.
+func ProxyErrors() uint64 {
+	return atomic.LoadUint64(&proxyErrors)
+}
+
 func Map(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err error) {
+	return mapPort(chain, container, hostIP, hostPort, false)
+}
+
+// MapUntracked maps a port without connection tracking. No DNAT rule is
+// installed, the kernel not forwarding the untracked traffic directly: all
+// of it goes through the userland proxy and is exempted from conntrack,
+// which keeps high connection rate or UDP heavy services from exhausting the
+// conntrack table. The packets reach the host in the UNTRACKED state, for
+// the INPUT chain of the host to accept.
+func MapUntracked(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err error) {
+	return mapPort(chain, container, hostIP, hostPort, true)
+}
+
+// MapOnChain maps a port with its rules in c rather than in the firewall
+// given to SetFirewall, for the networks of several bridges to share the
+// host ports. Without c, only the userland proxy is started.
+func MapOnChain(c Firewall, container net.Addr, hostIP net.IP, hostPort int, untracked bool) (host net.Addr, err error) {
+	return mapPort(c, container, hostIP, hostPort, untracked)
+}
+
+func mapPort(c Firewall, container net.Addr, hostIP net.IP, hostPort int, untracked bool) (host net.Addr, err error) {
 	lock.Lock()
-	defer lock.Unlock()
+	locked := true
+	defer func() {
+		if locked {
+			lock.Unlock()
+		}
+	}()
 
 	var (
 		m                 *mapping
 		proto             string
 		allocatedHostPort int
 		proxy             UserlandProxy
+		newProxy          = NewProxy
+		dry               = dryRun || dryRunFirewall(c)
 	)
+	if dry {
+		newProxy = newDryRunProxy
+	}
 
 	switch container.(type) {
 	case *net.TCPAddr:
@@ -62,7 +137,7 @@ func Map(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err er
 			container: container,
 		}
 
-		proxy = NewProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
+		proxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
 	case *net.UDPAddr:
 		proto = "udp"
 		if allocatedHostPort, err = portallocator.RequestPort(hostIP, proto, hostPort); err != nil {
@@ -75,7 +150,7 @@ func Map(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err er
 			container: container,
 		}
 
-		proxy = NewProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
+		proxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
 	default:
 		return nil, ErrUnknownBackendAddressType
 	}
@@ -88,19 +163,37 @@ func Map(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err er
 	}()
 
 	key := getKey(m.host)
-	if _, exists := currentMappings[key]; exists {
+	if _, exists := currentMappings[key]; exists || startingMappings[key] {
 		return nil, ErrPortMappedForIP
 	}
 
+	// The rules are added and the userland proxy started without the lock,
+	// for the many ports of a container to be mapped concurrently
+	startingMappings[key] = true
+	listen := m.shouldListen()
+	lock.Unlock()
+	locked = false
+	defer func() {
+		if !locked {
+			lock.Lock()
+			locked = true
+		}
+		delete(startingMappings, key)
+	}()
+
+	m.untracked = untracked
+	m.chain = c
+	m.dryRun = dry
 	containerIP, containerPort := getIPAndPort(m.container)
-	if err := forward(iptables.Add, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+	if err := m.setupRules(iptables.Add, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+		m.setupRules(iptables.Delete, hostIP, allocatedHostPort, containerIP.String(), containerPort)
 		return nil, err
 	}
 
 	cleanup := func() error {
 		// need to undo the iptables rules before we return
 		proxy.Stop()
-		forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
+		m.setupRules(iptables.Delete, hostIP, allocatedHostPort, containerIP.String(), containerPort)
 		if err := portallocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
 			return err
 		}
@@ -108,18 +201,40 @@ func Map(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err er
 		return nil
 	}
 
-	if err := proxy.Start(); err != nil {
-		if err := cleanup(); err != nil {
-			return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
+	m.userlandProxy = proxy
+	if listen {
+		if err := proxy.Start(); err != nil {
+			atomic.AddUint64(&proxyErrors, 1)
+			if err := cleanup(); err != nil {
+				return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
+			}
+			return nil, err
 		}
-		return nil, err
+		m.listening = true
+	}
+
+	lock.Lock()
+	locked = true
+	// The floating host ip or the container might have moved meanwhile
+	if _, err := m.rebind(); err != nil {
+		log.Errorf("Unable to rebind the userland proxy of %s: %s", key, err)
 	}
-	m.userlandProxy = proxy
 	currentMappings[key] = m
 	return m.host, nil
 }
 
 func Unmap(host net.Addr) error {
+	return unmap(host, false)
+}
+
+// UnmapReset unmaps host as Unmap does, its userland proxy aborting the TCP
+// connections in flight with a RST rather than letting them end, for their
+// clients to fail fast once the container is gone.
+func UnmapReset(host net.Addr) error {
+	return unmap(host, true)
+}
+
+func unmap(host net.Addr, reset bool) error {
 	lock.Lock()
 	defer lock.Unlock()
 
@@ -129,13 +244,19 @@ func Unmap(host net.Addr) error {
 		return ErrPortNotMapped
 	}
 
-	data.userlandProxy.Stop()
+	if data.listening {
+		if r, ok := data.userlandProxy.(resettableProxy); ok && reset {
+			r.Reset()
+		} else {
+			data.userlandProxy.Stop()
+		}
+	}
 
 	delete(currentMappings, key)
 
 	containerIP, containerPort := getIPAndPort(data.container)
 	hostIP, hostPort := getIPAndPort(data.host)
-	if err := forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+	if err := data.setupRules(iptables.Delete, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
 		log.Errorf("Error on iptables delete: %s", err)
 	}
 
@@ -148,6 +269,89 @@ func Unmap(host net.Addr) error {
 	return nil
 }
 
+// Reinstall sets up again the missing rules of the mappings of firewall c,
+// such as after the firewall was flushed by someone else, and returns the
+// mappings repaired.
+func Reinstall(c Firewall) ([]MappingState, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var (
+		repaired []MappingState
+		firstErr error
+	)
+	for _, m := range currentMappings {
+		if m.chain == nil || !sameFirewall(m.chain, c) {
+			continue
+		}
+		containerIP, containerPort := getIPAndPort(m.container)
+		hostIP, hostPort := getIPAndPort(m.host)
+		if m.setupRules(iptables.Check, hostIP, hostPort, containerIP.String(), containerPort) == nil {
+			continue
+		}
+		// Some of the rules might be left
+		m.setupRules(iptables.Delete, hostIP, hostPort, containerIP.String(), containerPort)
+		if err := m.setupRules(iptables.Add, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		repaired = append(repaired, m.state())
+	}
+	return repaired, firstErr
+}
+
+// MappingState describes a port mapping, for debugging purposes.
+type MappingState struct {
+	Proto     string
+	Host      string
+	Container string
+	Untracked bool
+	Name      string              `json:",omitempty"`
+	Labels    map[string]string   `json:",omitempty"`
+	Latency   *proxy.LatencyStats `json:",omitempty"` // of the connections since the userland proxy started, TCP only
+}
+
+// Mappings returns the port mappings currently set up.
+func Mappings() []MappingState {
+	lock.Lock()
+	defer lock.Unlock()
+
+	out := make([]MappingState, 0, len(currentMappings))
+	for _, m := range currentMappings {
+		out = append(out, m.state())
+	}
+	return out
+}
+
+// Lookup returns the mapping of host.
+func Lookup(host net.Addr) (MappingState, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	m, exists := currentMappings[getKey(host)]
+	if !exists {
+		return MappingState{}, ErrPortNotMapped
+	}
+	return m.state(), nil
+}
+
+func (m *mapping) state() MappingState {
+	state := MappingState{
+		Proto:     m.proto,
+		Host:      m.host.String(),
+		Container: m.container.String(),
+		Untracked: m.untracked,
+		Name:      m.name,
+		Labels:    m.labels,
+	}
+	if p, ok := m.userlandProxy.(*proxyCommand); ok && m.listening {
+		state.Latency = p.reportedLatency()
+	}
+	return state
+}
+
 func getKey(a net.Addr) string {
 	switch t := a.(type) {
 	case *net.TCPAddr:
@@ -168,9 +372,22 @@ func getIPAndPort(a net.Addr) (net.IP, int) {
 	return nil, 0
 }
 
-func forward(action iptables.Action, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
-	if chain == nil {
+// setupRules adds, checks or deletes the firewall rules of the mapping. The
+// rules in place already, such as those adopted from a previous run, are not
+// added twice. The forwarding rules are added in batches with those of the
+// other mappings being set up.
+func (m *mapping) setupRules(action iptables.Action, hostIP net.IP, hostPort int, containerIP string, containerPort int) error {
+	if m.chain == nil {
+		return nil
+	}
+	rules := m.chain.Forward
+	if m.untracked {
+		rules = m.chain.NoTrack
+	} else if fw, ok := m.chain.(batchFirewall); ok && action == iptables.Add {
+		return forward(fw, iptables.Forwarding{IP: hostIP, Port: hostPort, Proto: m.proto, DestAddr: containerIP, DestPort: containerPort})
+	}
+	if action == iptables.Add && rules(iptables.Check, hostIP, hostPort, m.proto, containerIP, containerPort) == nil {
 		return nil
 	}
-	return chain.Forward(action, sourceIP, sourcePort, proto, containerIP, containerPort)
+	return rules(action, hostIP, hostPort, m.proto, containerIP, containerPort)
 }
@@ -2,6 +2,9 @@ package portmapper
 
 import (
 	"net"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/daemon/networkdriver/portallocator"
@@ -150,3 +153,315 @@ func TestMapAllPortsSingleInterface(t *testing.T) {
 		hosts = []net.Addr{}
 	}
 }
+
+func TestMapUntrackedPorts(t *testing.T) {
+	defer reset()
+
+	dstAddr := &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 53}
+	srcAddr := &net.UDPAddr{Port: 1053, IP: net.ParseIP("172.16.0.1")}
+
+	host, err := MapUntracked(dstAddr, srcAddr.IP, srcAddr.Port)
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+	if m := currentMappings[getKey(host)]; m == nil || !m.untracked {
+		t.Fatal("Expected an untracked mapping")
+	}
+	if _, err := Map(dstAddr, srcAddr.IP, srcAddr.Port); err == nil {
+		t.Fatal("Port is in use - mapping should have failed")
+	}
+	if err := Unmap(host); err != nil {
+		t.Fatalf("Failed to release port: %s", err)
+	}
+}
+
+func TestUntrackedReplies(t *testing.T) {
+	defer reset()
+	iptables.SetDryRun(true)
+	defer iptables.SetDryRun(false)
+
+	var replies []string
+	iptables.SetRecorder(func(cmd string, args []string, err error) {
+		if args[3] == "OUTPUT" && strings.Contains(strings.Join(args, " "), "--sport") {
+			replies = append(replies, strings.Join(args, " "))
+		}
+	})
+	defer iptables.SetRecorder(nil)
+
+	// The replies of the proxy are told from the other traffic of the host
+	// by the address the port is published on
+	c := &iptables.Chain{Name: "TEST", Bridge: "docker0"}
+	host, err := MapOnChain(c, &net.UDPAddr{IP: net.ParseIP("172.17.0.2"), Port: 53}, net.ParseIP("127.0.0.1"), 1053, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unmap(host)
+	if len(replies) != 1 || replies[0] != "-t raw -A OUTPUT -p udp -s 127.0.0.1 --sport 1053 -j NOTRACK" {
+		t.Fatalf("Expected the untracked replies to come from the published address, got %v", replies)
+	}
+}
+
+func TestUntrackedRules(t *testing.T) {
+	defer reset()
+	iptables.SetDryRun(true)
+	defer iptables.SetDryRun(false)
+
+	var added []string
+	iptables.SetRecorder(func(cmd string, args []string, err error) {
+		for _, arg := range args {
+			if arg == "-A" || arg == "-I" {
+				added = append(added, strings.Join(args, " "))
+				break
+			}
+		}
+	})
+	defer iptables.SetRecorder(nil)
+
+	// Both legs of the proxied connection are exempted, and nothing is
+	// DNATed to the container
+	c := &iptables.Chain{Name: "TEST", Bridge: "docker0"}
+	host, err := MapOnChain(c, &net.TCPAddr{IP: net.ParseIP("172.17.0.2"), Port: 80}, net.ParseIP("0.0.0.0"), 8080, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unmap(host)
+	expected := []string{
+		"-t raw -A PREROUTING -p tcp -d 0/0 --dport 8080 -m addrtype --dst-type LOCAL -j NOTRACK",
+		"-t raw -A OUTPUT -p tcp --sport 8080 -j NOTRACK",
+		"-t raw -A OUTPUT -p tcp -d 172.17.0.2 --dport 80 -j NOTRACK",
+		"-t raw -A PREROUTING -i docker0 -p tcp -s 172.17.0.2 --sport 80 -j NOTRACK",
+	}
+	if !reflect.DeepEqual(added, expected) {
+		t.Fatalf("Expected the rules %v, got %v", expected, added)
+	}
+}
+
+func TestReinstall(t *testing.T) {
+	defer reset()
+	iptables.SetDryRun(true)
+	defer iptables.SetDryRun(false)
+
+	var added []string
+	iptables.SetRecorder(func(cmd string, args []string, err error) {
+		if args[0] == "-t" && args[2] == "-A" {
+			added = append(added, args[3])
+		}
+	})
+	defer iptables.SetRecorder(nil)
+
+	c := &iptables.Chain{Name: "TEST", Bridge: "docker0"}
+	other := &iptables.Chain{Name: "OTHER", Bridge: "docker1"}
+	host, err := MapOnChain(c, &net.TCPAddr{IP: net.ParseIP("172.17.0.2"), Port: 80}, net.ParseIP("0.0.0.0"), 8080, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unmap(host)
+	otherHost, err := MapOnChain(other, &net.TCPAddr{IP: net.ParseIP("172.18.0.2"), Port: 80}, net.ParseIP("0.0.0.0"), 8081, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unmap(otherHost)
+
+	// Without iptables to check them, the rules are all missing
+	added = nil
+	repaired, err := Reinstall(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repaired) != 1 || repaired[0].Container != "172.17.0.2:80" || len(added) != 1 || added[0] != "TEST" {
+		t.Fatalf("Expected the mapping of TEST only to be reinstalled, got %v %v", repaired, added)
+	}
+}
+
+func TestActivatedSockets(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	f, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := SetActivatedSockets([]*os.File{f}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetActivatedSockets(nil)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	if activatedSocket("tcp", net.ParseIP("0.0.0.0"), port) != f {
+		t.Fatal("Expected the socket activated for the host port")
+	}
+	if activatedSocket("udp", net.ParseIP("0.0.0.0"), port) != nil || activatedSocket("tcp", net.ParseIP("127.0.0.1"), port) != nil {
+		t.Fatal("Expected no socket activated for the other addresses")
+	}
+
+	p := NewProxyCommand("tcp", net.ParseIP("0.0.0.0"), port, net.ParseIP("172.17.0.2"), 80).(*proxyCommand)
+	if p.activated != f || p.cmd.Args[len(p.cmd.Args)-1] != "-activated" {
+		t.Fatalf("Expected the proxy to serve the activated socket, got %v", p.cmd.Args)
+	}
+}
+
+func TestFallbackAddr(t *testing.T) {
+	ip := net.ParseIP("172.17.0.2")
+	SetFallbackAddr(ip, &net.IPAddr{IP: net.ParseIP("fe80::42:acff:fe11:2"), Zone: "docker0"})
+
+	p := NewProxyCommand("tcp", net.ParseIP("0.0.0.0"), 8080, ip, 80).(*proxyCommand)
+	if args := strings.Join(p.cmd.Args, " "); !strings.Contains(args, "-fallback-ip fe80::42:acff:fe11:2%docker0") {
+		t.Fatalf("Expected the proxy to dial the fallback address too, got %s", args)
+	}
+	if addr := parseIPAddr("fe80::42:acff:fe11:2%docker0"); addr == nil || addr.Zone != "docker0" || !addr.IP.Equal(net.ParseIP("fe80::42:acff:fe11:2")) {
+		t.Fatalf("Unexpected fallback address %v", addr)
+	}
+	if p := NewProxyCommand("udp", net.ParseIP("0.0.0.0"), 8080, ip, 80).(*proxyCommand); strings.Contains(strings.Join(p.cmd.Args, " "), "-fallback-ip") {
+		t.Fatal("Expected the UDP proxies to dial the container ip only")
+	}
+
+	SetFallbackAddr(ip, nil)
+	p = NewProxyCommand("tcp", net.ParseIP("0.0.0.0"), 8080, ip, 80).(*proxyCommand)
+	if args := strings.Join(p.cmd.Args, " "); strings.Contains(args, "-fallback-ip") {
+		t.Fatalf("Expected the fallback address to be removed, got %s", args)
+	}
+}
+
+// countingProxy counts the userland proxies listening.
+type countingProxy struct {
+	listening *int
+}
+
+func (p *countingProxy) Start() error {
+	*p.listening++
+	return nil
+}
+
+func (p *countingProxy) Stop() error {
+	*p.listening--
+	return nil
+}
+
+func TestMapFloatingIP(t *testing.T) {
+	defer reset()
+	defer SetFloatingIPs(nil)
+	listening := 0
+	defer func(newProxy func(string, net.IP, int, net.IP, int) UserlandProxy) { NewProxy = newProxy }(NewProxy)
+	NewProxy = func(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) UserlandProxy {
+		return &countingProxy{listening: &listening}
+	}
+
+	vip := net.ParseIP("192.168.0.100")
+	SetFloatingIPs([]net.IP{vip})
+	hostAddr := &net.TCPAddr{IP: vip, Port: 80}
+	if _, err := Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 8080}, vip, 80); err != nil {
+		t.Fatal(err)
+	}
+	if listening != 0 {
+		t.Fatal("Expected the proxy to wait for the floating ip")
+	}
+
+	rebound, err := SetFloatingIPPresent(vip, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listening != 1 || len(rebound) != 1 || rebound[0].Host != hostAddr.String() {
+		t.Fatalf("Expected the proxy to listen once the floating ip arrived, got %d, %v", listening, rebound)
+	}
+	if rebound, _ := SetFloatingIPPresent(vip, true); rebound != nil {
+		t.Fatalf("Expected nothing to change, got %v", rebound)
+	}
+
+	if _, err := SetFloatingIPPresent(vip, false); err != nil {
+		t.Fatal(err)
+	}
+	if listening != 0 {
+		t.Fatal("Expected the proxy to stop once the floating ip left")
+	}
+	if err := Unmap(hostAddr); err != nil {
+		t.Fatal(err)
+	}
+	if listening != 0 {
+		t.Fatal("Expected the stopped proxy not to be stopped again")
+	}
+}
+
+func TestSuspendContainer(t *testing.T) {
+	defer reset()
+	defer SetFloatingIPs(nil)
+	listening := 0
+	defer func(newProxy func(string, net.IP, int, net.IP, int) UserlandProxy) { NewProxy = newProxy }(NewProxy)
+	NewProxy = func(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) UserlandProxy {
+		return &countingProxy{listening: &listening}
+	}
+
+	containerIP := net.ParseIP("172.16.0.2")
+	if _, err := Map(&net.TCPAddr{IP: containerIP, Port: 8080}, net.ParseIP("127.0.0.1"), 8081); err != nil {
+		t.Fatal(err)
+	}
+	if listening != 1 {
+		t.Fatal("Expected the proxy to listen")
+	}
+	if rebound, err := SetSuspended(containerIP, true); err != nil || len(rebound) != 1 || listening != 0 {
+		t.Fatalf("Expected the proxy to stop while the container is suspended, got %d, %v, %v", listening, rebound, err)
+	}
+
+	// A port published while the container is suspended waits for it too,
+	// as does the proxy of a floating ip arriving
+	vip := net.ParseIP("192.168.0.100")
+	SetFloatingIPs([]net.IP{vip})
+	if _, err := Map(&net.TCPAddr{IP: containerIP, Port: 8080}, vip, 80); err != nil {
+		t.Fatal(err)
+	}
+	if rebound, _ := SetFloatingIPPresent(vip, true); rebound != nil || listening != 0 {
+		t.Fatalf("Expected the proxies of the suspended container not to listen, got %d, %v", listening, rebound)
+	}
+
+	if rebound, err := SetSuspended(containerIP, false); err != nil || len(rebound) != 2 || listening != 2 {
+		t.Fatalf("Expected the proxies to listen once the container is resumed, got %d, %v, %v", listening, rebound, err)
+	}
+	if rebound, _ := SetSuspended(containerIP, false); rebound != nil {
+		t.Fatalf("Expected nothing to change, got %v", rebound)
+	}
+	Unmap(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8081})
+	Unmap(&net.TCPAddr{IP: vip, Port: 80})
+}
+
+// resettingProxy records how it was stopped.
+type resettingProxy struct {
+	stopped *string
+}
+
+func (p *resettingProxy) Start() error { return nil }
+
+func (p *resettingProxy) Stop() error {
+	*p.stopped = "stop"
+	return nil
+}
+
+func (p *resettingProxy) Reset() error {
+	*p.stopped = "reset"
+	return nil
+}
+
+func TestUnmapReset(t *testing.T) {
+	defer reset()
+	var stopped string
+	defer func(newProxy func(string, net.IP, int, net.IP, int) UserlandProxy) { NewProxy = newProxy }(NewProxy)
+	NewProxy = func(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) UserlandProxy {
+		return &resettingProxy{stopped: &stopped}
+	}
+
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.3"), Port: 8080}
+	host, err := Map(container, net.ParseIP("127.0.0.1"), 8082)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmap(host); err != nil || stopped != "stop" {
+		t.Fatalf("Expected the proxy to stop gracefully, got %q, %v", stopped, err)
+	}
+	if host, err = Map(container, net.ParseIP("127.0.0.1"), 8082); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmapReset(host); err != nil || stopped != "reset" {
+		t.Fatalf("Expected the proxy to reset its connections, got %q, %v", stopped, err)
+	}
+}
@@ -10,9 +10,12 @@ import (
 	"os/exec"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/proxy"
 	"github.com/docker/docker/pkg/reexec"
 )
@@ -28,24 +31,50 @@ type UserlandProxy interface {
 	Stop() error
 }
 
+// resettableProxy is a userland proxy able to abort its connections in
+// flight when it stops.
+type resettableProxy interface {
+	UserlandProxy
+	Reset() error
+}
+
 // proxyCommand wraps an exec.Cmd to run the userland TCP and UDP
 // proxies as separate processes.
 type proxyCommand struct {
-	cmd *exec.Cmd
+	cmd       *exec.Cmd
+	proto     string
+	activated *os.File // the socket bound by systemd for the host port, if any
+
+	latencyLock sync.Mutex
+	latency     *proxy.LatencyStats // reported by the proxy process, nil until it does
 }
 
 // execProxy is the reexec function that is registered to start the userland proxies
 func execProxy() {
 	f := os.NewFile(3, "signal-parent")
-	host, container := parseHostContainerAddrs()
+	host, container, fallback, activated, latencyFd := parseHostContainerAddrs()
 
-	p, err := proxy.NewProxy(host, container)
+	var (
+		p   proxy.Proxy
+		err error
+	)
+	if activated {
+		p, err = proxy.NewProxyFromFile(os.NewFile(4, "activated"), container)
+	} else {
+		p, err = proxy.NewProxy(host, container)
+	}
 	if err != nil {
 		fmt.Fprintf(f, "1\n%s", err)
 		f.Close()
 		os.Exit(1)
 	}
 	go handleStopSignals(p)
+	if t, ok := p.(*proxy.TCPProxy); ok && fallback != nil {
+		t.SetFallbackBackend(fallback)
+	}
+	if r, ok := p.(latencyReporter); ok && latencyFd > 0 {
+		go reportLatency(r, os.NewFile(uintptr(latencyFd), "latency"))
+	}
 	fmt.Fprint(f, "0\n")
 	f.Close()
 
@@ -54,14 +83,20 @@ func execProxy() {
 }
 
 // parseHostContainerAddrs parses the flags passed on reexec to create the TCP or UDP
-// net.Addrs to map the host and container ports
-func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
+// net.Addrs to map the host and container ports, the TCP address of the
+// container of the other family if any, whether the host port is the socket
+// activated file passed as fd 4, and the fd to report the latency of the
+// connections on, 0 for none
+func parseHostContainerAddrs() (host net.Addr, container net.Addr, fallback *net.TCPAddr, activated bool, latencyFd int) {
 	var (
 		proto         = flag.String("proto", "tcp", "proxy protocol")
 		hostIP        = flag.String("host-ip", "", "host ip")
 		hostPort      = flag.Int("host-port", -1, "host port")
 		containerIP   = flag.String("container-ip", "", "container ip")
 		containerPort = flag.Int("container-port", -1, "container port")
+		fallbackIP    = flag.String("fallback-ip", "", "container ip of the other family, dialed along with container-ip")
+		hostFile      = flag.Bool("activated", false, "serve the socket activated file passed as fd 4")
+		latency       = flag.Int("latency-fd", 0, "report the latency of the connections on this fd")
 	)
 
 	flag.Parse()
@@ -70,6 +105,9 @@ func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
 	case "tcp":
 		host = &net.TCPAddr{IP: net.ParseIP(*hostIP), Port: *hostPort}
 		container = &net.TCPAddr{IP: net.ParseIP(*containerIP), Port: *containerPort}
+		if addr := parseIPAddr(*fallbackIP); addr != nil {
+			fallback = &net.TCPAddr{IP: addr.IP, Port: *containerPort, Zone: addr.Zone}
+		}
 	case "udp":
 		host = &net.UDPAddr{IP: net.ParseIP(*hostIP), Port: *hostPort}
 		container = &net.UDPAddr{IP: net.ParseIP(*containerIP), Port: *containerPort}
@@ -77,15 +115,26 @@ func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
 		log.Fatalf("unsupported protocol %s", *proto)
 	}
 
-	return host, container
+	return host, container, fallback, *hostFile, *latency
 }
 
+// resetter is a proxy able to abort its connections in flight.
+type resetter interface {
+	Reset()
+}
+
+// handleStopSignals closes the proxy on SIGTERM, letting the connections in
+// flight end, and resets them on SIGUSR1.
 func handleStopSignals(p proxy.Proxy) {
 	s := make(chan os.Signal, 10)
-	signal.Notify(s, os.Interrupt, syscall.SIGTERM, syscall.SIGSTOP)
+	signal.Notify(s, os.Interrupt, syscall.SIGTERM, syscall.SIGSTOP, syscall.SIGUSR1)
 
-	for _ = range s {
-		p.Close()
+	for sig := range s {
+		if r, ok := p.(resetter); ok && sig == syscall.SIGUSR1 {
+			r.Reset()
+		} else {
+			p.Close()
+		}
 
 		os.Exit(0)
 	}
@@ -100,8 +149,16 @@ func NewProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.
 		"-container-ip", containerIP.String(),
 		"-container-port", strconv.Itoa(containerPort),
 	}
+	activated := activatedSocket(proto, hostIP, hostPort)
+	if activated != nil {
+		args = append(args, "-activated")
+	}
+	if fallback := fallbackAddr(containerIP); fallback != nil && proto == "tcp" {
+		args = append(args, "-fallback-ip", fallback.String())
+	}
 
 	return &proxyCommand{
+		proto: proto,
 		cmd: &exec.Cmd{
 			Path: reexec.Self(),
 			Args: args,
@@ -109,9 +166,28 @@ func NewProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.
 				Pdeathsig: syscall.SIGTERM, // send a sigterm to the proxy if the daemon process dies
 			},
 		},
+		activated: activated,
 	}
 }
 
+// dryRunProxy logs the userland proxy it stands for instead of running it.
+type dryRunProxy struct {
+	args []string
+}
+
+func newDryRunProxy(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) UserlandProxy {
+	return &dryRunProxy{args: NewProxyCommand(proto, hostIP, hostPort, containerIP, containerPort).(*proxyCommand).cmd.Args}
+}
+
+func (p *dryRunProxy) Start() error {
+	logrus.Infof("Dry run: %s", strings.Join(p.args, " "))
+	return nil
+}
+
+func (p *dryRunProxy) Stop() error {
+	return nil
+}
+
 func (p *proxyCommand) Start() error {
 	r, w, err := os.Pipe()
 	if err != nil {
@@ -119,10 +195,28 @@ func (p *proxyCommand) Start() error {
 	}
 	defer r.Close()
 	p.cmd.ExtraFiles = []*os.File{w}
+	if p.activated != nil {
+		p.cmd.ExtraFiles = append(p.cmd.ExtraFiles, p.activated)
+	}
+	var latencyR, latencyW *os.File
+	if p.proto == "tcp" {
+		if latencyR, latencyW, err = os.Pipe(); err != nil {
+			return fmt.Errorf("proxy unable to open os.Pipe %s", err)
+		}
+		defer latencyW.Close()
+		p.cmd.ExtraFiles = append(p.cmd.ExtraFiles, latencyW)
+		p.cmd.Args = append(p.cmd.Args, "-latency-fd", strconv.Itoa(2+len(p.cmd.ExtraFiles)))
+	}
 	if err := p.cmd.Start(); err != nil {
+		if latencyR != nil {
+			latencyR.Close()
+		}
 		return err
 	}
 	w.Close()
+	if latencyR != nil {
+		go p.readLatency(latencyR)
+	}
 
 	errchan := make(chan error, 1)
 	go func() {
@@ -146,8 +240,17 @@ func (p *proxyCommand) Start() error {
 }
 
 func (p *proxyCommand) Stop() error {
+	return p.stop(os.Interrupt)
+}
+
+// Reset stops the proxy, aborting its TCP connections in flight with a RST.
+func (p *proxyCommand) Reset() error {
+	return p.stop(syscall.SIGUSR1)
+}
+
+func (p *proxyCommand) stop(sig os.Signal) error {
 	if p.cmd.Process != nil {
-		if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		if err := p.cmd.Process.Signal(sig); err != nil {
 			return err
 		}
 		return p.cmd.Wait()
@@ -0,0 +1,53 @@
+package portmapper
+
+import (
+	"net"
+)
+
+// The network of a container can be cut off for a while, to see how its
+// clients cope with it. Its published ports keep their rules, the traffic
+// being dropped further on, but their userland proxies would accept the
+// connections they can't forward: they are stopped while the container is
+// suspended, and started again once it is resumed.
+
+// suspendedIPs are the ips of the suspended containers.
+var suspendedIPs = make(map[string]bool)
+
+// SetSuspended stops the userland proxies of the ports published by the
+// container of ip when it is suspended, and starts them again when it is
+// resumed. It returns the mappings whose proxies were started or stopped,
+// and the first proxy which failed to start, the others being started
+// nonetheless.
+func SetSuspended(ip net.IP, suspended bool) ([]MappingState, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	key := ip.String()
+	if suspendedIPs[key] == suspended {
+		return nil, nil
+	}
+	if suspended {
+		suspendedIPs[key] = true
+	} else {
+		delete(suspendedIPs, key)
+	}
+
+	var (
+		rebound  []MappingState
+		firstErr error
+	)
+	for _, m := range currentMappings {
+		containerIP, _ := getIPAndPort(m.container)
+		if !containerIP.Equal(ip) {
+			continue
+		}
+		changed, err := m.rebind()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if changed {
+			rebound = append(rebound, m.state())
+		}
+	}
+	return rebound, firstErr
+}
@@ -0,0 +1,379 @@
+// Package sriov gives each container a virtual function of an SR-IOV network
+// card, for a near line rate networking without the bridge, the veth pairs
+// nor the NAT of the bridge driver.
+//
+// The virtual functions of the physical function given to the daemon are
+// the inventory of the driver: one of them is assigned to a container when
+// its interface is allocated, its MAC address and VLAN being set on the
+// physical function, and goes back to the inventory when the interface is
+// released. Once the container runs, the network device of its virtual
+// function is moved into the namespace of the container and becomes its
+// eth0, with an address of the network of the physical function.
+//
+// The containers being on the network of the physical function, they are
+// reached at their own addresses: no port is published on the host.
+package sriov
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/networkdriver"
+	"github.com/docker/docker/daemon/networkdriver/ipallocator"
+	"github.com/docker/docker/engine"
+)
+
+// containerIface is the name of the virtual function in the namespace of
+// the container.
+const containerIface = "eth0"
+
+// sysfsRoot is where sysfs is mounted, which lists the virtual functions.
+var sysfsRoot = "/sys"
+
+// runCommand runs the commands setting up the virtual functions.
+var runCommand = func(name string, args ...string) error {
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %s (%s)", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// NetworkInfo describes the network of the physical function, as the
+// bridge driver describes its own, along with its virtual functions.
+type NetworkInfo struct {
+	Name       string
+	Driver     string
+	Subnets    []string
+	Containers int
+	VFs        int // virtual functions of the physical function
+	FreeVFs    int // virtual functions left for the containers
+}
+
+// vf is a virtual function of the physical function.
+type vf struct {
+	Index     int
+	Container string // assigned to, empty if free
+}
+
+type endpoint struct {
+	vf  *vf
+	ip  net.IP
+	mac net.HardwareAddr
+}
+
+// Driver implements the network jobs of the daemon with the virtual
+// functions of pf.
+type Driver struct {
+	pf      string
+	vlan    int
+	mtu     int
+	network *net.IPNet // of the physical function, whose IP is the gateway
+	gateway net.IP
+
+	sync.Mutex
+	vfs       []*vf
+	endpoints map[string]*endpoint
+}
+
+// InitDriver is the init_networkdriver job using the virtual functions of
+// the physical function given in SriovPF, on the network given in
+// SriovNetwork as the address of its gateway in CIDR notation (ex:
+// 192.168.1.1/24), tagged with the VLAN given in SriovVlan if not 0. The
+// addresses given are those of FixedCIDR if set.
+func InitDriver(job *engine.Job) engine.Status {
+	gateway, network, err := net.ParseCIDR(job.Getenv("SriovNetwork"))
+	if err != nil {
+		return job.Errorf("Invalid SR-IOV network %q, it must be the address of its gateway in CIDR notation (ex: 192.168.1.1/24)", job.Getenv("SriovNetwork"))
+	}
+	if gateway.To4() == nil {
+		return job.Errorf("Invalid SR-IOV network %s, it must be an IPv4 network", network)
+	}
+	vlan := job.GetenvInt("SriovVlan")
+	if vlan < 0 || vlan > 4094 {
+		return job.Errorf("Invalid SR-IOV VLAN %d, it must be between 1 and 4094, or 0 for none", vlan)
+	}
+	d, err := NewDriver(job.Getenv("SriovPF"), network, gateway, vlan)
+	if err != nil {
+		return job.Error(err)
+	}
+	d.mtu = job.GetenvInt("Mtu")
+
+	if fixedCIDR := job.Getenv("FixedCIDR"); fixedCIDR != "" {
+		_, subnet, err := net.ParseCIDR(fixedCIDR)
+		if err != nil {
+			return job.Error(err)
+		}
+		if err := ipallocator.RegisterSubnet(network, subnet); err != nil {
+			return job.Error(err)
+		}
+	}
+	// The gateway isn't given to a container
+	ipallocator.RequestIP(network, gateway)
+
+	if err := d.Install(job.Eng); err != nil {
+		return job.Error(err)
+	}
+	log.Infof("Networking the containers with the %d virtual functions of %s", len(d.vfs), d.pf)
+	return engine.StatusOK
+}
+
+// NewDriver returns the driver of the virtual functions of pf.
+func NewDriver(pf string, network *net.IPNet, gateway net.IP, vlan int) (*Driver, error) {
+	vfs, err := discoverVFs(pf)
+	if err != nil {
+		return nil, err
+	}
+	if len(vfs) == 0 {
+		return nil, fmt.Errorf("The physical function %s has no virtual function, set them up with %s first", pf, numVFsPath(pf))
+	}
+	return &Driver{
+		pf:        pf,
+		vlan:      vlan,
+		network:   network,
+		gateway:   gateway,
+		vfs:       vfs,
+		endpoints: make(map[string]*endpoint),
+	}, nil
+}
+
+// Install registers the network jobs of the driver in eng.
+func (d *Driver) Install(eng *engine.Engine) error {
+	for name, f := range map[string]engine.Handler{
+		"allocate_interface":     d.Allocate,
+		"release_interface":      d.Release,
+		"allocate_port":          d.AllocatePort,
+		"attach_interface":       d.Attach,
+		"link":                   d.Link,
+		"list_networks":          d.ListNetworks,
+		"restore_interface":      d.Restore,
+		"shutdown_networkdriver": d.Shutdown,
+	} {
+		if err := eng.Register(name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func numVFsPath(pf string) string {
+	return filepath.Join(sysfsRoot, "class/net", pf, "device/sriov_numvfs")
+}
+
+// discoverVFs lists the virtual functions of pf, from the virtfn links of
+// its device.
+func discoverVFs(pf string) ([]*vf, error) {
+	device := filepath.Join(sysfsRoot, "class/net", pf, "device")
+	if _, err := ioutil.ReadDir(device); err != nil {
+		return nil, fmt.Errorf("Unable to find the physical function %s: %s", pf, err)
+	}
+	links, err := filepath.Glob(filepath.Join(device, "virtfn*"))
+	if err != nil {
+		return nil, err
+	}
+	var vfs []*vf
+	for _, link := range links {
+		index, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(link), "virtfn"))
+		if err != nil {
+			continue
+		}
+		vfs = append(vfs, &vf{Index: index})
+	}
+	sort.Sort(byIndex(vfs))
+	return vfs, nil
+}
+
+type byIndex []*vf
+
+func (v byIndex) Len() int           { return len(v) }
+func (v byIndex) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byIndex) Less(i, j int) bool { return v[i].Index < v[j].Index }
+
+// vfIface returns the name of the network device of the virtual function
+// index of pf, in the namespace of the host. It is looked up each time, a
+// device coming back from the namespace of a container under another name.
+func vfIface(pf string, index int) (string, error) {
+	dir := filepath.Join(sysfsRoot, "class/net", pf, "device", "virtfn"+strconv.Itoa(index), "net")
+	names, err := ioutil.ReadDir(dir)
+	if err != nil || len(names) == 0 {
+		return "", fmt.Errorf("The virtual function %d of %s has no network device", index, pf)
+	}
+	return names[0].Name(), nil
+}
+
+// setVF sets the MAC address and the VLAN of a virtual function on the
+// physical function.
+func (d *Driver) setVF(index int, mac net.HardwareAddr, vlan int) error {
+	return runCommand("ip", "link", "set", "dev", d.pf, "vf", strconv.Itoa(index), "mac", mac.String(), "vlan", strconv.Itoa(vlan))
+}
+
+// Allocate assigns a free virtual function to the container, and gives it
+// an address of the network of the physical function.
+func (d *Driver) Allocate(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	d.Lock()
+	defer d.Unlock()
+
+	if d.endpoints[id] != nil {
+		return job.Errorf("The container %s has a virtual function already", id)
+	}
+	var free *vf
+	for _, v := range d.vfs {
+		if v.Container == "" {
+			free = v
+			break
+		}
+	}
+	if free == nil {
+		return job.Errorf("The %d virtual functions of %s are all taken", len(d.vfs), d.pf)
+	}
+
+	ip, err := ipallocator.RequestIP(d.network, net.ParseIP(job.Getenv("RequestedIP")))
+	if err != nil {
+		return job.Error(err)
+	}
+	mac, err := net.ParseMAC(job.Getenv("RequestedMac"))
+	if err != nil {
+		mac = networkdriver.GenerateMacAddr(ip)
+	}
+	if err := d.setVF(free.Index, mac, d.vlan); err != nil {
+		ipallocator.ReleaseIP(d.network, ip)
+		return job.Error(err)
+	}
+	free.Container = id
+	d.endpoints[id] = &endpoint{vf: free, ip: ip, mac: mac}
+
+	size, _ := d.network.Mask.Size()
+	out := engine.Env{}
+	out.Set("IP", ip.String())
+	out.Set("Mask", net.IP(d.network.Mask).String())
+	out.Set("Gateway", d.gateway.String())
+	out.Set("MacAddress", mac.String())
+	out.Set("Bridge", "")
+	out.SetInt("IPPrefixLen", size)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// AllocatePort refuses to publish the ports of the containers, which are
+// reached at their own addresses.
+func (d *Driver) AllocatePort(job *engine.Job) engine.Status {
+	return job.Errorf("The SR-IOV network publishes no port, the containers are reached at their own addresses")
+}
+
+// Attach moves the virtual function of the running container, whose
+// process is given in Pid, into its network namespace and sets it up.
+func (d *Driver) Attach(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	d.Lock()
+	ep := d.endpoints[id]
+	d.Unlock()
+	if ep == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	iface, err := vfIface(d.pf, ep.vf.Index)
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := runCommand("ip", "link", "set", "dev", iface, "netns", job.Getenv("Pid")); err != nil {
+		return job.Error(err)
+	}
+
+	size, _ := d.network.Mask.Size()
+	nsenter := []string{"--net=/proc/" + job.Getenv("Pid") + "/ns/net", "ip"}
+	commands := [][]string{
+		{"link", "set", "dev", iface, "name", containerIface},
+		{"addr", "add", fmt.Sprintf("%s/%d", ep.ip, size), "dev", containerIface},
+	}
+	if d.mtu != 0 {
+		commands = append(commands, []string{"link", "set", "dev", containerIface, "mtu", strconv.Itoa(d.mtu)})
+	}
+	commands = append(commands,
+		[]string{"link", "set", "dev", containerIface, "up"},
+		[]string{"route", "add", "default", "via", d.gateway.String()},
+	)
+	for _, args := range commands {
+		if err := runCommand("nsenter", append(nsenter, args...)...); err != nil {
+			return job.Error(err)
+		}
+	}
+	return engine.StatusOK
+}
+
+func (d *Driver) Release(job *engine.Job) engine.Status {
+	id := job.Args[0]
+	d.Lock()
+	defer d.Unlock()
+	if d.endpoints[id] == nil {
+		return job.Errorf("No network information for %s", id)
+	}
+	d.release(id)
+	return engine.StatusOK
+}
+
+// release puts the virtual function of the container back into the
+// inventory, once its MAC address and VLAN are cleared, and frees its
+// address. Its network device comes back to the host with the end of the
+// namespace of the container. The lock must be held.
+func (d *Driver) release(id string) {
+	ep := d.endpoints[id]
+	delete(d.endpoints, id)
+	if err := d.setVF(ep.vf.Index, make(net.HardwareAddr, 6), 0); err != nil {
+		log.Infof("Unable to clear the virtual function %d of %s: %s", ep.vf.Index, d.pf, err)
+	}
+	ep.vf.Container = ""
+	if err := ipallocator.ReleaseIP(d.network, ep.ip); err != nil {
+		log.Infof("Unable to release ip %s: %s", ep.ip, err)
+	}
+}
+
+// ListNetworks is the job writing the network of the physical function,
+// as a JSON list of NetworkInfo.
+func (d *Driver) ListNetworks(job *engine.Job) engine.Status {
+	d.Lock()
+	info := NetworkInfo{
+		Name:       d.pf,
+		Driver:     "sriov",
+		Subnets:    []string{d.network.String()},
+		Containers: len(d.endpoints),
+		VFs:        len(d.vfs),
+		FreeVFs:    len(d.vfs) - len(d.endpoints),
+	}
+	d.Unlock()
+
+	if err := json.NewEncoder(job.Stdout).Encode([]NetworkInfo{info}); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// Link leaves the communication between the containers to the network of
+// the physical function.
+func (d *Driver) Link(job *engine.Job) engine.Status {
+	return engine.StatusOK
+}
+
+// Restore makes the daemon allocate the interfaces of the restored
+// containers again, the inventory starting afresh with the daemon.
+func (d *Driver) Restore(job *engine.Job) engine.Status {
+	return job.Errorf("The SR-IOV network restores no interface")
+}
+
+func (d *Driver) Shutdown(job *engine.Job) engine.Status {
+	d.Lock()
+	defer d.Unlock()
+	for id := range d.endpoints {
+		d.release(id)
+	}
+	return engine.StatusOK
+}
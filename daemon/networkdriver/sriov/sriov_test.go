@@ -0,0 +1,154 @@
+package sriov
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/engine"
+)
+
+// fakeSysfs sets up a sysfs where pf has a virtual function per name in
+// vfs, and records the commands run.
+func fakeSysfs(t *testing.T, pf string, vfs ...string) (*[]string, func()) {
+	root, err := ioutil.TempDir("", "docker-sriov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range vfs {
+		if err := os.MkdirAll(filepath.Join(root, "class/net", pf, "device", "virtfn"+strconv.Itoa(i), "net", name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var commands []string
+	run := runCommand
+	sysfsRoot = root
+	runCommand = func(name string, args ...string) error {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return nil
+	}
+	return &commands, func() {
+		os.RemoveAll(root)
+		sysfsRoot = "/sys"
+		runCommand = run
+	}
+}
+
+func runJob(t *testing.T, job *engine.Job) engine.Env {
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return *out
+}
+
+func TestSriovDriver(t *testing.T) {
+	commands, cleanup := fakeSysfs(t, "eth2", "eth2v0", "eth2v1")
+	defer cleanup()
+
+	eng := engine.New()
+	eng.Logging = false
+	if err := eng.Register("init_networkdriver", InitDriver); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("init_networkdriver")
+	job.Setenv("SriovPF", "eth2")
+	job.Setenv("SriovNetwork", "192.168.50.1/24")
+	job.SetenvInt("SriovVlan", 100)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := runJob(t, eng.Job("allocate_interface", "first"))
+	if out.Get("IP") != "192.168.50.2" || out.GetInt("IPPrefixLen") != 24 || out.Get("Gateway") != "192.168.50.1" || out.Get("Bridge") != "" {
+		t.Fatalf("Expected an address of the network of the physical function, got %v", out)
+	}
+	if last := (*commands)[len(*commands)-1]; last != "ip link set dev eth2 vf 0 mac 02:42:c0:a8:32:02 vlan 100" {
+		t.Fatalf("Expected the virtual function to be set up, got %s", last)
+	}
+	runJob(t, eng.Job("allocate_interface", "second"))
+	if err := eng.Job("allocate_interface", "third").Run(); err == nil {
+		t.Fatal("Expected the virtual functions to be all taken")
+	}
+	job = eng.Job("allocate_port", "first")
+	job.Setenv("Proto", "tcp")
+	job.SetenvInt("ContainerPort", 80)
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected no port to be published")
+	}
+
+	var listed bytes.Buffer
+	job = eng.Job("list_networks")
+	job.Stdout.Add(&listed)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var networks []NetworkInfo
+	if err := json.Unmarshal(listed.Bytes(), &networks); err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 || networks[0].Driver != "sriov" || networks[0].VFs != 2 || networks[0].FreeVFs != 0 || networks[0].Containers != 2 {
+		t.Fatalf("Unexpected networks %+v", networks)
+	}
+
+	*commands = nil
+	job = eng.Job("attach_interface", "first")
+	job.SetenvInt("Pid", 42)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []string{
+		"ip link set dev eth2v0 netns 42",
+		"nsenter --net=/proc/42/ns/net ip link set dev eth2v0 name eth0",
+		"nsenter --net=/proc/42/ns/net ip addr add 192.168.50.2/24 dev eth0",
+		"nsenter --net=/proc/42/ns/net ip link set dev eth0 up",
+		"nsenter --net=/proc/42/ns/net ip route add default via 192.168.50.1",
+	} {
+		if i >= len(*commands) || (*commands)[i] != expected {
+			t.Fatalf("Expected %q, got %v", expected, *commands)
+		}
+	}
+
+	// The virtual function and the address go back to the inventory
+	*commands = nil
+	if err := eng.Job("release_interface", "first").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*commands) != 1 || (*commands)[0] != "ip link set dev eth2 vf 0 mac 00:00:00:00:00:00 vlan 0" {
+		t.Fatalf("Expected the virtual function to be cleared, got %v", *commands)
+	}
+	job = eng.Job("allocate_interface", "third")
+	job.Setenv("RequestedIP", "192.168.50.2")
+	if out := runJob(t, job); out.Get("IP") != "192.168.50.2" {
+		t.Fatalf("Expected the released address, got %v", out)
+	}
+}
+
+func TestSriovWithoutVFs(t *testing.T) {
+	_, cleanup := fakeSysfs(t, "eth2")
+	defer cleanup()
+
+	eng := engine.New()
+	eng.Logging = false
+	eng.Register("init_networkdriver", InitDriver)
+	for _, env := range [][2]string{
+		{"eth2", "192.168.51.1/24"},
+		{"eth3", "192.168.51.1/24"},
+		{"eth2", "192.168.51.1"},
+	} {
+		job := eng.Job("init_networkdriver")
+		job.Setenv("SriovPF", env[0])
+		job.Setenv("SriovNetwork", env[1])
+		if err := job.Run(); err == nil {
+			t.Fatalf("Expected the SR-IOV network %v to be refused", env)
+		}
+	}
+}
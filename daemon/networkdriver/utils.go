@@ -10,6 +10,7 @@ import (
 
 var (
 	networkGetRoutesFct = netlink.NetworkGetRoutes
+	interfaceAddrsFct   = interfaceAddrs
 	ErrNoDefaultRoute   = errors.New("no default route")
 )
 
@@ -28,20 +29,94 @@ func CheckNameserverOverlaps(nameservers []string, toCheck *net.IPNet) error {
 	return nil
 }
 
-func CheckRouteOverlaps(toCheck *net.IPNet) error {
-	networks, err := networkGetRoutesFct()
+// OverlapError is the network of the host a requested network overlaps.
+type OverlapError struct {
+	Network  *net.IPNet // the requested network
+	Conflict *net.IPNet // the destination of the route, or the network of the address
+	Iface    string     // interface of the route or of the address, empty if unknown
+	Address  bool       // Conflict is the network of an address of Iface rather than a route
+}
+
+func (e *OverlapError) Error() string {
+	what := "the route to"
+	if e.Address {
+		what = "the address"
+	}
+	msg := fmt.Sprintf("requested network %s overlaps with %s %s", e.Network, what, e.Conflict)
+	if e.Iface != "" {
+		msg += " on " + e.Iface
+	}
+	return msg
+}
+
+// CheckRouteOverlaps returns an *OverlapError if toCheck overlaps a route
+// of the host or an address of its interfaces. The routes within the
+// ignored prefixes, such as the aggregate of a corporate network which
+// isn't local, are left out, the addresses of the interfaces being checked
+// still.
+func CheckRouteOverlaps(toCheck *net.IPNet, ignored ...*net.IPNet) error {
+	routes, err := networkGetRoutesFct()
 	if err != nil {
 		return err
 	}
+	for _, route := range routes {
+		if route.IPNet == nil || WithinAny(route.IPNet, ignored) {
+			continue
+		}
+		if NetworkOverlaps(toCheck, route.IPNet) {
+			e := &OverlapError{Network: toCheck, Conflict: route.IPNet}
+			if route.Iface != nil {
+				e.Iface = route.Iface.Name
+			}
+			return e
+		}
+	}
 
-	for _, network := range networks {
-		if network.IPNet != nil && NetworkOverlaps(toCheck, network.IPNet) {
-			return ErrNetworkOverlaps
+	addrs, err := interfaceAddrsFct()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if NetworkOverlaps(toCheck, addr.IPNet) {
+			return &OverlapError{Network: toCheck, Conflict: addr.IPNet, Iface: addr.Iface.Name, Address: true}
 		}
 	}
 	return nil
 }
 
+// interfaceAddrs returns the addresses of the interfaces of the host.
+func interfaceAddrs() ([]netlink.IfAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var out []netlink.IfAddr
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				out = append(out, netlink.IfAddr{Iface: &ifaces[i], IP: ipNet.IP, IPNet: ipNet})
+			}
+		}
+	}
+	return out, nil
+}
+
+// WithinAny returns whether network is one of the prefixes or within one.
+func WithinAny(network *net.IPNet, prefixes []*net.IPNet) bool {
+	ones, bits := network.Mask.Size()
+	for _, p := range prefixes {
+		pOnes, pBits := p.Mask.Size()
+		if bits == pBits && ones >= pOnes && p.Contains(network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
 // Detects overlap between one IPNet and another
 func NetworkOverlaps(netX *net.IPNet, netY *net.IPNet) bool {
 	if firstIP, _ := NetworkRange(netX); netY.Contains(firstIP) {
@@ -122,3 +197,28 @@ func GetDefaultRouteIface() (*net.Interface, error) {
 	}
 	return nil, ErrNoDefaultRoute
 }
+
+// GenerateMacAddr generates a IEEE802 compliant MAC address from the given IP address.
+//
+// The generator is guaranteed to be consistent: the same IP will always yield the same
+// MAC address. This is to avoid ARP cache issues.
+func GenerateMacAddr(ip net.IP) net.HardwareAddr {
+	hw := make(net.HardwareAddr, 6)
+
+	// The first byte of the MAC address has to comply with these rules:
+	// 1. Unicast: Set the least-significant bit to 0.
+	// 2. Address is locally administered: Set the second-least-significant bit (U/L) to 1.
+	// 3. As "small" as possible: The veth address has to be "smaller" than the bridge address.
+	hw[0] = 0x02
+
+	// The first 24 bits of the MAC represent the Organizationally Unique Identifier (OUI).
+	// Since this address is locally administered, we can do whatever we want as long as
+	// it doesn't conflict with other addresses.
+	hw[1] = 0x42
+
+	// Insert the IP address into the last 32 bits of the MAC address.
+	// This is a simple way to guarantee the address will be consistent and unique.
+	copy(hw[2:], ip.To4())
+
+	return hw
+}
@@ -0,0 +1,23 @@
+package daemon
+
+import (
+	"github.com/docker/docker/engine"
+)
+
+// ListNetworks writes the networks the containers of the host can join, as
+// a JSON list of their name, driver, subnets and number of containers, read
+// from the network driver. A daemon without networking offers none.
+func (daemon *Daemon) ListNetworks(job *engine.Job) engine.Status {
+	if daemon.config.DisableNetwork {
+		if _, err := job.Stdout.Write([]byte("[]\n")); err != nil {
+			return job.Error(err)
+		}
+		return engine.StatusOK
+	}
+	networks := job.Eng.Job("list_networks")
+	networks.Stdout.Add(job.Stdout)
+	if err := networks.Run(); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
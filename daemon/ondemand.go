@@ -0,0 +1,243 @@
+package daemon
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/networkdriver/portmapper"
+	"github.com/docker/docker/nat"
+)
+
+// The sockets of the published TCP ports of the containers started on
+// demand are held by the daemon for as long as the containers exist. While
+// a container is stopped, the daemon accepts on them: the first connection
+// starts the container and is forwarded to it once it accepts. Running, the
+// sockets are served by the userland proxies of the port mappings, the
+// connections made meanwhile waiting in their backlog.
+
+// onDemandTimeout bounds the time the first connection waits for the
+// started container to accept it.
+const onDemandTimeout = 30 * time.Second
+
+type onDemandPort struct {
+	addr     string   // host ip:port
+	port     nat.Port // port of the container
+	file     *os.File
+	listener net.Listener // accepting while the container is stopped
+}
+
+type onDemandPorts struct {
+	sync.Mutex
+	ports  map[string][]*onDemandPort // by container id
+	closed bool
+}
+
+func newOnDemandPorts() *onDemandPorts {
+	return &onDemandPorts{ports: make(map[string][]*onDemandPort)}
+}
+
+// onDemandBindings returns the published ports of a container started on
+// demand, by host address. The host ports the network driver picks can't
+// be held before it does.
+func (daemon *Daemon) onDemandBindings(container *Container) map[string]nat.Port {
+	hostConfig := container.hostConfig
+	if hostConfig == nil || !hostConfig.StartOnDemand || !hostConfig.NetworkMode.IsPrivate() || container.Config.NetworkDisabled || daemon.config.DisableNetwork {
+		return nil
+	}
+	if daemon.config.NetworkRootless || daemon.config.NetworkPlugin != "" || daemon.config.SriovPF != "" {
+		log.Warnf("Container %s can't start on demand: only the ports of the bridge network can be held", container.ID)
+		return nil
+	}
+	bindings := make(map[string]nat.Port)
+	for port, portBindings := range hostConfig.PortBindings {
+		if port.Proto() != "tcp" {
+			continue
+		}
+		for _, b := range portBindings {
+			hostPort, err := strconv.Atoi(b.HostPort)
+			if err != nil || hostPort == 0 {
+				continue
+			}
+			ip := net.ParseIP(b.HostIp)
+			if ip == nil {
+				ip = daemon.config.DefaultIp
+			}
+			bindings[net.JoinHostPort(ip.String(), strconv.Itoa(hostPort))] = port
+		}
+	}
+	return bindings
+}
+
+// holdOnDemand holds the sockets of the published ports of a container
+// started on demand, accepting on them if accept is set. The sockets of the
+// ports no longer published are released.
+func (daemon *Daemon) holdOnDemand(container *Container, accept bool) {
+	bindings := daemon.onDemandBindings(container)
+
+	o := daemon.onDemand
+	o.Lock()
+	defer o.Unlock()
+	if o.closed {
+		bindings = nil
+	}
+
+	var held []*onDemandPort
+	for _, p := range o.ports[container.ID] {
+		if port, exists := bindings[p.addr]; exists && port == p.port {
+			held = append(held, p)
+			delete(bindings, p.addr)
+			continue
+		}
+		p.release()
+	}
+	for addr, port := range bindings {
+		p, err := bindOnDemand(addr, port)
+		if err != nil {
+			log.Errorf("Unable to hold the port %s of %s for its start on demand: %s", addr, container.ID, err)
+			continue
+		}
+		held = append(held, p)
+	}
+
+	for _, p := range held {
+		if !accept {
+			p.stopAccepting()
+			continue
+		}
+		if err := p.accept(daemon, container); err != nil {
+			log.Errorf("Unable to accept on the port %s of %s: %s", p.addr, container.ID, err)
+		}
+	}
+	if len(held) == 0 {
+		delete(o.ports, container.ID)
+	} else {
+		o.ports[container.ID] = held
+	}
+}
+
+// releaseOnDemand releases the sockets held for a container.
+func (daemon *Daemon) releaseOnDemand(container *Container) {
+	o := daemon.onDemand
+	o.Lock()
+	defer o.Unlock()
+	for _, p := range o.ports[container.ID] {
+		p.release()
+	}
+	delete(o.ports, container.ID)
+}
+
+// closeOnDemand releases all the sockets held, for good.
+func (daemon *Daemon) closeOnDemand() {
+	o := daemon.onDemand
+	o.Lock()
+	defer o.Unlock()
+	o.closed = true
+	for id, ports := range o.ports {
+		for _, p := range ports {
+			p.release()
+		}
+		delete(o.ports, id)
+	}
+}
+
+func bindOnDemand(addr string, port nat.Port) (*onDemandPort, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		return nil, err
+	}
+	// The port mappings serve the socket rather than binding the port
+	if err := portmapper.AddActivatedSocket(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &onDemandPort{addr: addr, port: port, file: f}, nil
+}
+
+func (p *onDemandPort) accept(daemon *Daemon, container *Container) error {
+	if p.listener != nil {
+		return nil
+	}
+	l, err := net.FileListener(p.file)
+	if err != nil {
+		return err
+	}
+	p.listener = l
+	go daemon.startOnDemand(container, p.port, l)
+	return nil
+}
+
+func (p *onDemandPort) stopAccepting() {
+	if p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+}
+
+func (p *onDemandPort) release() {
+	p.stopAccepting()
+	portmapper.RemoveActivatedSocket(p.file)
+	p.file.Close()
+}
+
+// startOnDemand starts the container on the first connection accepted on l,
+// and forwards the connection to it.
+func (daemon *Daemon) startOnDemand(container *Container, port nat.Port, l net.Listener) {
+	conn, err := l.Accept()
+	if err != nil {
+		// No longer accepting
+		return
+	}
+	log.Infof("Starting %s on demand, for a connection from %s", container.ID, conn.RemoteAddr())
+	if err := container.Start(); err != nil {
+		log.Errorf("Unable to start %s on demand: %s", container.ID, err)
+		conn.Close()
+		return
+	}
+	forwardOnDemand(conn, container, port)
+}
+
+// forwardOnDemand forwards conn to the port of the container, once the
+// container accepts it.
+func forwardOnDemand(conn net.Conn, container *Container, port nat.Port) {
+	defer conn.Close()
+
+	addr := net.JoinHostPort(container.NetworkSettings.IPAddress, port.Port())
+	var (
+		backend net.Conn
+		err     error
+	)
+	for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+		if backend, err = net.DialTimeout("tcp", addr, time.Second); err == nil {
+			break
+		}
+		if time.Since(start) > onDemandTimeout || !container.IsRunning() {
+			log.Errorf("%s started on demand didn't accept on %s: %s", container.ID, addr, err)
+			return
+		}
+	}
+	defer backend.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(backend, conn)
+		if c, ok := backend.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(conn, backend)
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.CloseWrite()
+	}
+	<-done
+}
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/runconfig"
+)
+
+func TestHoldOnDemand(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_, hostPort, _ := net.SplitHostPort(addr)
+	l.Close()
+
+	daemon := &Daemon{config: &Config{DefaultIp: net.ParseIP("0.0.0.0")}, onDemand: newOnDemandPorts()}
+	container := &Container{
+		ID:     "container_id",
+		Config: &runconfig.Config{},
+		hostConfig: &runconfig.HostConfig{
+			StartOnDemand: true,
+			PortBindings: nat.PortMap{
+				"80/tcp": {{HostIp: "127.0.0.1", HostPort: hostPort}},
+				"53/udp": {{HostIp: "127.0.0.1", HostPort: "5353"}},
+				"22/tcp": {{HostIp: "127.0.0.1"}},
+			},
+		},
+	}
+
+	// The container isn't started without a connection
+	daemon.holdOnDemand(container, false)
+	if ports := daemon.onDemand.ports[container.ID]; len(ports) != 1 || ports[0].addr != addr || ports[0].port != "80/tcp" {
+		t.Fatalf("Expected the tcp port %s to be held, got %v", addr, ports)
+	}
+	if l, err := net.Listen("tcp", addr); err == nil {
+		l.Close()
+		t.Fatalf("Expected %s to be held", addr)
+	}
+
+	// The port is no longer published
+	container.hostConfig.StartOnDemand = false
+	daemon.holdOnDemand(container, false)
+	if ports := daemon.onDemand.ports[container.ID]; len(ports) != 0 {
+		t.Fatalf("Expected no port to be held, got %v", ports)
+	}
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Expected %s to be released: %s", addr, err)
+	}
+	l.Close()
+
+	daemon.closeOnDemand()
+	container.hostConfig.StartOnDemand = true
+	daemon.holdOnDemand(container, true)
+	if ports := daemon.onDemand.ports[container.ID]; len(ports) != 0 {
+		t.Fatalf("Expected no port to be held once closed, got %v", ports)
+	}
+}
+
+func TestForwardOnDemand(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+	_, port, _ := net.SplitHostPort(backend.Addr().String())
+
+	container := &Container{State: NewState(), NetworkSettings: &NetworkSettings{IPAddress: "127.0.0.1"}}
+	container.State.Running = true
+	client, conn := net.Pipe()
+	go forwardOnDemand(conn, container, nat.Port(port+"/tcp"))
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("Expected the connection to be forwarded, got %q", reply)
+	}
+	client.Close()
+}
@@ -0,0 +1,123 @@
+package daemon
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The containers with a park policy are parked once their network has been
+// idle for long enough, so that many of them can be hosted while few are in
+// use: stopped, they start again on the next connection if started on
+// demand. Paused, they are unpaused as soon as traffic reaches them again,
+// the connections waiting meanwhile retrying.
+
+// parkInterval is the period the network activity of the containers is
+// checked with, and so the delay to unpause them.
+var parkInterval = 10 * time.Second
+
+// parkStopTimeout is the time the containers have to stop when parked,
+// before being killed.
+const parkStopTimeout = 10
+
+// watchIdle parks the idle containers until stop is closed.
+func (daemon *Daemon) watchIdle(stop chan struct{}) {
+	ticker := time.NewTicker(parkInterval)
+	defer ticker.Stop()
+
+	paused := make(map[string]time.Time) // containers paused for idleness, with when
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var (
+			containers = daemon.List()
+			current    = make(map[string]time.Time)
+		)
+		// The containers destroyed are forgotten
+		for _, container := range containers {
+			if pausedAt, exists := paused[container.ID]; exists {
+				current[container.ID] = pausedAt
+			}
+		}
+		paused = current
+		for _, container := range containers {
+			daemon.parkIdle(container, paused, time.Now())
+		}
+	}
+}
+
+// parkIdle parks the container if its network has been idle, as of now,
+// for as long as its park policy says, or unpauses it if it was paused for
+// idleness and traffic reached it since.
+func (daemon *Daemon) parkIdle(container *Container, paused map[string]time.Time, now time.Time) {
+	if container.hostConfig == nil || container.hostConfig.ParkPolicy.Action == "" || !container.IsRunning() {
+		delete(paused, container.ID)
+		return
+	}
+	policy := container.hostConfig.ParkPolicy
+
+	pausedAt, parked := paused[container.ID]
+	if parked && !container.IsPaused() {
+		// Unpaused by someone else
+		delete(paused, container.ID)
+		parked = false
+	}
+	if !parked && container.IsPaused() {
+		// Paused by someone else
+		return
+	}
+
+	lastActive, err := daemon.networkActivity(container)
+	if err != nil {
+		log.Debugf("Unable to get the network activity of %s: %s", container.ID, err)
+		return
+	}
+
+	if parked {
+		if lastActive.After(pausedAt) {
+			log.Infof("Unpausing %s, its network active again", container.ID)
+			if err := daemon.eng.Job("unpause", container.ID).Run(); err != nil {
+				log.Errorf("Unable to unpause %s: %s", container.ID, err)
+				return
+			}
+			delete(paused, container.ID)
+		}
+		return
+	}
+
+	if now.Sub(lastActive) < time.Duration(policy.IdleMinutes)*time.Minute {
+		return
+	}
+	log.Infof("Parking %s, its network idle since %s", container.ID, lastActive)
+	switch policy.Action {
+	case "stop":
+		job := daemon.eng.Job("stop", container.ID)
+		job.SetenvInt("t", parkStopTimeout)
+		if err := job.Run(); err != nil {
+			log.Errorf("Unable to stop %s: %s", container.ID, err)
+		}
+	case "pause":
+		if err := daemon.eng.Job("pause", container.ID).Run(); err != nil {
+			log.Errorf("Unable to pause %s: %s", container.ID, err)
+			return
+		}
+		paused[container.ID] = now
+	}
+}
+
+// networkActivity returns the last time the container sent or received
+// traffic, as seen by the network driver.
+func (daemon *Daemon) networkActivity(container *Container) (time.Time, error) {
+	job := daemon.eng.Job("network_activity", container.ID)
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := job.Run(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, out.Get("LastActive"))
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/runconfig"
+)
+
+func TestParkIdle(t *testing.T) {
+	var (
+		eng        = engine.New()
+		lastActive = time.Now()
+		jobs       []string
+	)
+	eng.Logging = false
+	eng.Register("network_activity", func(job *engine.Job) engine.Status {
+		out := engine.Env{}
+		out.Set("LastActive", lastActive.Format(time.RFC3339Nano))
+		out.WriteTo(job.Stdout)
+		return engine.StatusOK
+	})
+	for _, name := range []string{"stop", "pause", "unpause"} {
+		name := name
+		eng.Register(name, func(job *engine.Job) engine.Status {
+			jobs = append(jobs, name)
+			return engine.StatusOK
+		})
+	}
+
+	daemon := &Daemon{eng: eng}
+	container := &Container{
+		ID:         "container_id",
+		State:      NewState(),
+		hostConfig: &runconfig.HostConfig{ParkPolicy: runconfig.ParkPolicy{Action: "pause", IdleMinutes: 5}},
+	}
+	container.State.Running = true
+	paused := make(map[string]time.Time)
+
+	// Not idle for long enough
+	daemon.parkIdle(container, paused, lastActive.Add(4*time.Minute))
+	if len(jobs) != 0 {
+		t.Fatalf("Expected the container to be left alone, got %v", jobs)
+	}
+
+	pausedAt := lastActive.Add(5 * time.Minute)
+	daemon.parkIdle(container, paused, pausedAt)
+	if len(jobs) != 1 || jobs[0] != "pause" || !paused[container.ID].Equal(pausedAt) {
+		t.Fatalf("Expected the container to be paused, got %v", jobs)
+	}
+	container.State.Paused = true
+
+	// Still idle
+	daemon.parkIdle(container, paused, pausedAt.Add(time.Minute))
+	if len(jobs) != 1 {
+		t.Fatalf("Expected the container to stay paused, got %v", jobs)
+	}
+
+	lastActive = pausedAt.Add(time.Second)
+	daemon.parkIdle(container, paused, pausedAt.Add(time.Minute))
+	if len(jobs) != 2 || jobs[1] != "unpause" {
+		t.Fatalf("Expected the container to be unpaused, got %v", jobs)
+	}
+	if _, exists := paused[container.ID]; exists {
+		t.Fatal("Expected the container to be no longer parked")
+	}
+	container.State.Paused = false
+
+	// Paused by the user, it is left alone
+	container.State.Paused = true
+	daemon.parkIdle(container, paused, lastActive.Add(time.Hour))
+	if len(jobs) != 2 {
+		t.Fatalf("Expected the container paused by the user to be left alone, got %v", jobs)
+	}
+	container.State.Paused = false
+
+	container.hostConfig.ParkPolicy.Action = "stop"
+	daemon.parkIdle(container, paused, lastActive.Add(time.Hour))
+	if len(jobs) != 3 || jobs[2] != "stop" {
+		t.Fatalf("Expected the container to be stopped, got %v", jobs)
+	}
+	if _, exists := paused[container.ID]; exists {
+		t.Fatal("Expected the stopped container not to be unpaused")
+	}
+}
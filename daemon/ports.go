@@ -0,0 +1,284 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/nat"
+)
+
+// settingsPort is a port mapping read from the network settings of a
+// container, in the form of those of the network driver.
+type settingsPort struct {
+	Proto         string
+	HostIP        string
+	HostPort      int
+	ContainerPort int
+}
+
+// settingsPorts lists the port mappings of the network settings, sorted by
+// container port.
+func settingsPorts(ports nat.PortMap) []settingsPort {
+	out := []settingsPort{}
+	for port, bindings := range ports {
+		for _, b := range bindings {
+			hostPort, err := strconv.Atoi(b.HostPort)
+			if err != nil {
+				continue
+			}
+			out = append(out, settingsPort{
+				Proto:         port.Proto(),
+				HostIP:        b.HostIp,
+				HostPort:      hostPort,
+				ContainerPort: port.Int(),
+			})
+		}
+	}
+	sort.Sort(byContainerPort(out))
+	return out
+}
+
+type byContainerPort []settingsPort
+
+func (p byContainerPort) Len() int      { return len(p) }
+func (p byContainerPort) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byContainerPort) Less(i, j int) bool {
+	if p[i].ContainerPort != p[j].ContainerPort {
+		return p[i].ContainerPort < p[j].ContainerPort
+	}
+	if p[i].Proto != p[j].Proto {
+		return p[i].Proto < p[j].Proto
+	}
+	return p[i].HostPort < p[j].HostPort
+}
+
+// ContainerPorts writes the ports the container is published on, as a JSON
+// list of the host ip and port, the protocol and the container port of
+// each mapping, read from the network driver. The containers without a
+// network of their own, or not running, have none. The network drivers
+// keeping no port mappings, such as the network plugins, have those of the
+// network settings of the container written instead.
+func (daemon *Daemon) ContainerPorts(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	mode := container.hostConfig.NetworkMode
+	if !container.IsRunning() || container.Config.NetworkDisabled || !mode.IsPrivate() {
+		if _, err := job.Stdout.Write([]byte("[]\n")); err != nil {
+			return job.Error(err)
+		}
+		return engine.StatusOK
+	}
+	if !job.Eng.Exists("port_mappings") {
+		if err := json.NewEncoder(job.Stdout).Encode(settingsPorts(container.NetworkSettings.Ports)); err != nil {
+			return job.Error(err)
+		}
+		return engine.StatusOK
+	}
+	mappings := job.Eng.Job("port_mappings", container.ID)
+	mappings.Stdout.Add(job.Stdout)
+	if err := mappings.Run(); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerPublish publishes more ports of a running container, given in
+// Ports as to docker run -p, without restarting it: the network driver maps
+// them and starts their proxies right away. The ports are added to those of
+// the container, published again when it restarts, and written as the JSON
+// map of the ports to their host bindings.
+func (daemon *Daemon) ContainerPublish(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	exposed, bindings, err := nat.ParsePortSpecs(job.GetenvList("Ports"))
+	if err != nil {
+		return job.Error(err)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	mode := container.hostConfig.NetworkMode
+	if !container.Running {
+		return job.Errorf("Cannot publish ports of %s, it is not running", name)
+	}
+	if container.Config.NetworkDisabled || !mode.IsPrivate() {
+		return job.Errorf("Cannot publish ports of %s, it has no network of its own", name)
+	}
+
+	var (
+		published  = make(nat.PortMap)
+		settings   = container.NetworkSettings
+		hostConfig = container.hostConfig
+	)
+	if settings.Ports == nil {
+		settings.Ports = make(nat.PortMap)
+	}
+	if hostConfig.PortBindings == nil {
+		hostConfig.PortBindings = make(nat.PortMap)
+	}
+	if container.Config.ExposedPorts == nil {
+		container.Config.ExposedPorts = make(nat.PortSet)
+	}
+	var (
+		ports     = make([]nat.Port, 0, len(exposed))
+		requested = make(nat.PortMap)
+	)
+	for port := range exposed {
+		ports = append(ports, port)
+		// allocatePorts replaces the requested bindings by the published ones
+		requested[port] = append([]nat.PortBinding{}, bindings[port]...)
+	}
+	allocated, err := container.allocatePorts(job.Eng, ports, bindings)
+	for _, port := range allocated {
+		published[port] = bindings[port]
+		settings.Ports[port] = append(settings.Ports[port], bindings[port]...)
+		hostConfig.PortBindings[port] = append(hostConfig.PortBindings[port], requested[port]...)
+		container.Config.ExposedPorts[port] = struct{}{}
+	}
+	// The ports published before a failure stay published
+	if len(published) > 0 {
+		if err := container.toDisk(); err != nil {
+			return job.Error(err)
+		}
+		if err := container.WriteHostConfig(); err != nil {
+			return job.Error(err)
+		}
+	}
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := json.NewEncoder(job.Stdout).Encode(published); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerUnpublish unpublishes ports of a running container, given in
+// Ports as [ip:]hostPort[/proto], without restarting it nor touching its
+// other ports. The ports are left out of those published when it restarts.
+func (daemon *Daemon) ContainerUnpublish(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	mode := container.hostConfig.NetworkMode
+	if !container.Running {
+		return job.Errorf("Cannot unpublish ports of %s, it is not running", name)
+	}
+	if container.Config.NetworkDisabled || !mode.IsPrivate() {
+		return job.Errorf("Cannot unpublish ports of %s, it has no network of its own", name)
+	}
+
+	var err error
+	unpublished := 0
+	for _, spec := range job.GetenvList("Ports") {
+		if err = container.unpublishPort(job.Eng, spec); err != nil {
+			break
+		}
+		unpublished++
+	}
+	// The ports unpublished before a failure stay unpublished
+	if unpublished > 0 {
+		if err := container.toDisk(); err != nil {
+			return job.Error(err)
+		}
+		if err := container.WriteHostConfig(); err != nil {
+			return job.Error(err)
+		}
+	}
+	if err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// unpublishPort has the network driver unmap the host port of spec, and
+// removes its binding from the container. The lock must be held.
+func (container *Container) unpublishPort(eng *engine.Engine, spec string) error {
+	proto := "tcp"
+	if i := strings.LastIndex(spec, "/"); i != -1 {
+		proto, spec = spec[i+1:], spec[:i]
+	}
+	var hostIP, hostPort string
+	if i := strings.LastIndex(spec, ":"); i != -1 {
+		hostIP, hostPort = spec[:i], spec[i+1:]
+	} else {
+		hostPort = spec
+	}
+	if _, err := strconv.ParseUint(hostPort, 10, 16); err != nil {
+		return fmt.Errorf("Invalid host port: %s", hostPort)
+	}
+	if hostIP != "" && net.ParseIP(hostIP) == nil {
+		return fmt.Errorf("Invalid ip address: %s", hostIP)
+	}
+
+	job := eng.Job("release_port", container.ID)
+	job.Setenv("Proto", proto)
+	job.Setenv("HostPort", hostPort)
+	job.Setenv("HostIP", hostIP)
+	out, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		port      = nat.NewPort(proto, out.Get("ContainerPort"))
+		published = nat.PortBinding{HostIp: out.Get("HostIP"), HostPort: hostPort}
+		removed   bool
+	)
+	container.NetworkSettings.Ports[port], _ = removeBinding(container.NetworkSettings.Ports[port], func(b nat.PortBinding) bool {
+		return b == published
+	})
+	// The binding requested the host port, or else any port of its ip
+	bindings := container.hostConfig.PortBindings[port]
+	if bindings, removed = removeBinding(bindings, func(b nat.PortBinding) bool {
+		return b.HostPort == hostPort && (b.HostIp == "" || b.HostIp == published.HostIp)
+	}); !removed {
+		bindings, _ = removeBinding(bindings, func(b nat.PortBinding) bool {
+			return b.HostPort == "" && (b.HostIp == "" || b.HostIp == published.HostIp)
+		})
+	}
+	if len(bindings) == 0 {
+		delete(container.hostConfig.PortBindings, port)
+	} else {
+		container.hostConfig.PortBindings[port] = bindings
+	}
+	return nil
+}
+
+// removeBinding removes the first binding matching from bindings.
+func removeBinding(bindings []nat.PortBinding, match func(nat.PortBinding) bool) ([]nat.PortBinding, bool) {
+	for i, b := range bindings {
+		if match(b) {
+			return append(bindings[:i:i], bindings[i+1:]...), true
+		}
+	}
+	return bindings, false
+}
@@ -0,0 +1,27 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/nat"
+)
+
+func TestSettingsPorts(t *testing.T) {
+	ports := nat.PortMap{
+		"80/tcp":  {{HostIp: "0.0.0.0", HostPort: "8080"}, {HostIp: "127.0.0.1", HostPort: "8081"}},
+		"53/udp":  {{HostIp: "0.0.0.0", HostPort: "5353"}},
+		"443/tcp": nil,
+	}
+	expected := []settingsPort{
+		{Proto: "udp", HostIP: "0.0.0.0", HostPort: 5353, ContainerPort: 53},
+		{Proto: "tcp", HostIP: "0.0.0.0", HostPort: 8080, ContainerPort: 80},
+		{Proto: "tcp", HostIP: "127.0.0.1", HostPort: 8081, ContainerPort: 80},
+	}
+	if got := settingsPorts(ports); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, got)
+	}
+	if got := settingsPorts(nil); got == nil || len(got) != 0 {
+		t.Fatalf("Expected an empty list, got %+v", got)
+	}
+}
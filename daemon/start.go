@@ -59,6 +59,11 @@ func (daemon *Daemon) setHostConfig(container *Container, hostConfig *runconfig.
 			}
 		}
 	}
+	if hostConfig.NetworkFrom != "" {
+		if err := daemon.cloneNetwork(container, hostConfig); err != nil {
+			return err
+		}
+	}
 	// Register any links from the host config before starting the container
 	if err := daemon.RegisterLinks(container, hostConfig); err != nil {
 		return err
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"regexp"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/nat"
+)
+
+// A virtual machine run alongside the containers, such as a QEMU/KVM guest,
+// can get a tap device on the bridge with an address of the pool of the
+// containers, its ports being published as those of a container. The
+// network driver knows the tap as tapPrefix followed by its name, and
+// restores it after a restart of the daemon.
+const tapPrefix = "tap:"
+
+var validTapNamePattern = regexp.MustCompile(`^` + validContainerNameChars + `+$`)
+
+// CreateTap creates the tap device of the virtual machine named in the
+// first argument, the Device or dkt- followed by the name, owned by User if
+// set. The machine gets the RequestedIP or any free address, and its Ports
+// are published. It writes the IP, IPPrefixLen, Gateway and MacAddress the
+// machine is to use, the TapDevice and the published Ports.
+func (daemon *Daemon) CreateTap(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s NAME\n", job.Name)
+	}
+	name := job.Args[0]
+	if !validTapNamePattern.MatchString(name) {
+		return job.Errorf("Invalid tap name (%s), only %s are allowed", name, validContainerNameChars)
+	}
+	if daemon.config.DisableNetwork {
+		return job.Errorf("Cannot create the tap %s, the daemon has no network", name)
+	}
+	exposed, bindings, err := nat.ParsePortSpecs(job.GetenvList("Ports"))
+	if err != nil {
+		return job.Error(err)
+	}
+	device := job.Getenv("Device")
+	if device == "" {
+		device = "dkt-" + name
+	}
+
+	id := tapPrefix + name
+	allocate := job.Eng.Job("allocate_interface", id)
+	allocate.Setenv("Tap", device)
+	allocate.Setenv("TapUser", job.Getenv("User"))
+	allocate.Setenv("RequestedIP", job.Getenv("RequestedIP"))
+	allocate.Setenv("RequestedMac", job.Getenv("RequestedMac"))
+	env, err := allocate.Stdout.AddEnv()
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := allocate.Run(); err != nil {
+		return job.Error(err)
+	}
+
+	published := make(nat.PortMap)
+	for port := range exposed {
+		for _, b := range bindings[port] {
+			publish := job.Eng.Job("allocate_port", id)
+			publish.Setenv("HostIP", b.HostIp)
+			publish.Setenv("HostPort", b.HostPort)
+			publish.Setenv("Proto", port.Proto())
+			publish.Setenv("ContainerPort", port.Port())
+			portEnv, err := publish.Stdout.AddEnv()
+			if err != nil {
+				return job.Error(err)
+			}
+			if err := publish.Run(); err != nil {
+				job.Eng.Job("release_interface", id).Run()
+				return job.Error(err)
+			}
+			published[port] = append(published[port], nat.PortBinding{HostIp: portEnv.Get("HostIP"), HostPort: portEnv.Get("HostPort")})
+		}
+	}
+
+	out := engine.Env{}
+	out.Set("IP", env.Get("IP"))
+	out.SetInt("IPPrefixLen", env.GetInt("IPPrefixLen"))
+	out.Set("Gateway", env.Get("Gateway"))
+	out.Set("MacAddress", env.Get("MacAddress"))
+	out.Set("TapDevice", env.Get("HostInterfaceName"))
+	out.SetJson("Ports", published)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// DeleteTap removes the tap device of the virtual machine named in the first
+// argument, releasing its address and its ports.
+func (daemon *Daemon) DeleteTap(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s NAME\n", job.Name)
+	}
+	if err := job.Eng.Job("release_interface", tapPrefix+job.Args[0]).Run(); err != nil {
+		return job.Errorf("No such tap: %s", job.Args[0])
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/engine"
+)
+
+// ContainerCapture streams a pcap of the traffic of a running container on
+// the job's stdout, narrowed by the BPF expression "Filter" and stopped after
+// "Duration" seconds or "Count" packets, each packet cut to "Snaplen" bytes.
+func (daemon *Daemon) ContainerCapture(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if err := container.checkOwnNetwork(name, "capture the traffic of"); err != nil {
+		return job.Error(err)
+	}
+
+	capture := job.Eng.Job("capture_traffic", container.ID)
+	copyEnv(capture, job, "Filter", "Duration", "Count", "Snaplen")
+	capture.Stdout.Add(job.Stdout)
+	if err := capture.Run(); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ContainerNetem injects the latency "Delay", the jitter "Jitter", the packet
+// loss "Loss" and the reordering "Reorder" in the traffic to a running
+// container, in place of the faults injected so far, all empty clearing them.
+// The faults are injected across restarts of the daemon, until the container
+// stops.
+func (daemon *Daemon) ContainerNetem(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if err := container.checkOwnNetwork(name, "inject faults in the traffic of"); err != nil {
+		return job.Error(err)
+	}
+
+	set := job.Eng.Job("set_netem", container.ID)
+	copyEnv(set, job, "Delay", "Jitter", "Loss", "Reorder")
+	if err := set.Run(); err != nil {
+		return job.Error(err)
+	}
+	container.LogEvent("netem")
+	return engine.StatusOK
+}
+
+// ContainerPartition cuts the traffic between a running container and each
+// of the running containers of the "With" list, until ContainerUnpartition
+// restores it, or after "Duration" seconds if given.
+func (daemon *Daemon) ContainerPartition(job *engine.Job) engine.Status {
+	return daemon.setPartition(job, "-I")
+}
+
+// ContainerUnpartition restores the traffic between a container and each of
+// the containers of the "With" list cut off by ContainerPartition.
+func (daemon *Daemon) ContainerUnpartition(job *engine.Job) engine.Status {
+	return daemon.setPartition(job, "-D")
+}
+
+func (daemon *Daemon) setPartition(job *engine.Job, action string) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+	name := job.Args[0]
+	container := daemon.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if err := container.checkOwnNetwork(name, "partition"); err != nil {
+		return job.Error(err)
+	}
+	with := job.GetenvList("With")
+	if len(with) == 0 {
+		return job.Errorf("Bad parameter: the containers to partition %s from are missing", name)
+	}
+
+	var ips []string
+	for _, otherName := range with {
+		other := daemon.Get(otherName)
+		if other == nil {
+			return job.Errorf("No such container: %s", otherName)
+		}
+		if err := other.checkOwnNetwork(otherName, "partition"); err != nil {
+			return job.Error(err)
+		}
+		ips = append(ips, other.NetworkSettings.IPAddress)
+	}
+
+	// One pair at a time, for the containers of the list to keep talking
+	// to each other
+	for _, ip := range ips {
+		partition := job.Eng.Job("partition", action)
+		partition.SetenvList("IPs", []string{container.NetworkSettings.IPAddress, ip})
+		copyEnv(partition, job, "Duration")
+		if err := partition.Run(); err != nil {
+			return job.Error(err)
+		}
+	}
+	if action == "-I" {
+		container.LogEvent("partition")
+	} else {
+		container.LogEvent("unpartition")
+	}
+	return engine.StatusOK
+}
+
+// checkOwnNetwork tells whether the traffic of the container can be acted
+// on, the error saying what couldn't be done to it.
+func (container *Container) checkOwnNetwork(name, action string) error {
+	if !container.IsRunning() {
+		return fmt.Errorf("Cannot %s %s, it is not running", action, name)
+	}
+	if container.Config.NetworkDisabled || !container.hostConfig.NetworkMode.IsPrivate() {
+		return fmt.Errorf("Cannot %s %s, it has no network of its own", action, name)
+	}
+	return nil
+}
+
+// copyEnv passes the variables of a job set on to another.
+func copyEnv(dst, src *engine.Job, keys ...string) {
+	for _, key := range keys {
+		if src.EnvExists(key) {
+			dst.Setenv(key, src.Getenv(key))
+		}
+	}
+}
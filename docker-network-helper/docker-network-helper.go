@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/docker/docker/daemon/networkdriver/bridge"
+)
+
+// The network helper is a binary of its own, rather than a reexec entrypoint
+// of docker, for its setuid copy to run nothing else.
+func main() {
+	bridge.HelperMain()
+}
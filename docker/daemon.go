@@ -39,6 +39,19 @@ func mainDaemon() {
 		log.Fatal(err)
 	}
 
+	// Clean up after a previous daemon instead of starting
+	if daemonCfg.NetworkRollback {
+		job := eng.Job("network_rollback")
+		job.Setenv("Root", daemonCfg.Root)
+		job.Setenv("Instance", daemonCfg.NetworkInstance)
+		job.Setenv("Helper", daemonCfg.NetworkHelper)
+		job.SetenvBool("DryRun", daemonCfg.NetworkDryRun)
+		if err := job.Run(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// load registry service
 	if err := registry.NewService(daemonCfg.InsecureRegistries).Install(eng); err != nil {
 		log.Fatal(err)
@@ -25,6 +25,12 @@ const (
 )
 
 func main() {
+	// A setuid copy of docker would let its users run the daemon, the
+	// client and each reexec entrypoint, chosen by argv[0], as root
+	if os.Geteuid() != os.Getuid() {
+		fmt.Fprintln(os.Stderr, "docker doesn't run setuid, the network helper is the docker-network-helper binary")
+		os.Exit(1)
+	}
 	if reexec.Init() {
 		return
 	}
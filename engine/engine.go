@@ -70,6 +70,13 @@ func (eng *Engine) Register(name string, handler Handler) error {
 	return nil
 }
 
+// Exists tells whether a handler is registered for the job name, leaving
+// the catchall aside.
+func (eng *Engine) Exists(name string) bool {
+	_, exists := eng.handlers[name]
+	return exists
+}
+
 func (eng *Engine) RegisterCatchall(catchall Handler) {
 	eng.catchall = catchall
 }
@@ -35,6 +35,18 @@ func TestRegister(t *testing.T) {
 	defer unregister("dummy2")
 }
 
+func TestExists(t *testing.T) {
+	eng := New()
+	eng.RegisterCatchall(func(job *Job) Status { return StatusOK })
+	if eng.Exists("dummy") {
+		t.Fatal("Expected the catchall not to count")
+	}
+	eng.Register("dummy", func(job *Job) Status { return StatusOK })
+	if !eng.Exists("dummy") {
+		t.Fatal("Expected dummy to exist")
+	}
+}
+
 func TestJob(t *testing.T) {
 	eng := New()
 	job1 := eng.Job("dummy1", "--level=awesome")
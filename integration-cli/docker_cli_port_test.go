@@ -126,6 +126,99 @@ func TestPortList(t *testing.T) {
 	logDone("port - test port list")
 }
 
+func TestPortPublish(t *testing.T) {
+	runCmd := exec.Command(dockerBinary, "run", "-d", "-p", "9876:80", "busybox", "top")
+	out, _, err := runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	ID := stripTrailingCharacters(out)
+
+	runCmd = exec.Command(dockerBinary, "port", "-p", "9877:81", ID)
+	out, _, err = runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !assertPortList(t, out, []string{"81/tcp -> 0.0.0.0:9877"}) {
+		t.Error("Published port is not correct")
+	}
+
+	runCmd = exec.Command(dockerBinary, "port", ID)
+	out, _, err = runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !assertPortList(t, out, []string{
+		"80/tcp -> 0.0.0.0:9876",
+		"81/tcp -> 0.0.0.0:9877"}) {
+		t.Error("Port list is not correct\n", out)
+	}
+
+	// The published port is kept across restarts
+	runCmd = exec.Command(dockerBinary, "restart", ID)
+	if out, _, err = runCommandWithOutput(runCmd); err != nil {
+		t.Fatal(out, err)
+	}
+	runCmd = exec.Command(dockerBinary, "port", ID, "81")
+	out, _, err = runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !assertPortList(t, out, []string{"0.0.0.0:9877"}) {
+		t.Error("Port list is not correct after a restart\n", out)
+	}
+
+	deleteAllContainers()
+
+	logDone("port - test publishing a port of a running container")
+}
+
+func TestPortUnpublish(t *testing.T) {
+	runCmd := exec.Command(dockerBinary, "run", "-d", "-p", "9876:80", "-p", "9877:81", "busybox", "top")
+	out, _, err := runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	ID := stripTrailingCharacters(out)
+
+	runCmd = exec.Command(dockerBinary, "port", "--unpublish", "9877/tcp", ID)
+	if out, _, err = runCommandWithOutput(runCmd); err != nil {
+		t.Fatal(out, err)
+	}
+
+	runCmd = exec.Command(dockerBinary, "port", ID)
+	out, _, err = runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !assertPortList(t, out, []string{"80/tcp -> 0.0.0.0:9876"}) {
+		t.Error("Port list is not correct\n", out)
+	}
+
+	// The port stays unpublished across restarts
+	runCmd = exec.Command(dockerBinary, "restart", ID)
+	if out, _, err = runCommandWithOutput(runCmd); err != nil {
+		t.Fatal(out, err)
+	}
+	runCmd = exec.Command(dockerBinary, "port", ID)
+	out, _, err = runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !assertPortList(t, out, []string{"80/tcp -> 0.0.0.0:9876"}) {
+		t.Error("Port list is not correct after a restart\n", out)
+	}
+
+	runCmd = exec.Command(dockerBinary, "port", "--unpublish", "9877/tcp", ID)
+	if out, _, err = runCommandWithOutput(runCmd); err == nil {
+		t.Fatal("Expected a port not published to fail", out)
+	}
+
+	deleteAllContainers()
+
+	logDone("port - test unpublishing a port of a running container")
+}
+
 func assertPortList(t *testing.T, out string, expected []string) bool {
 	//lines := strings.Split(out, "\n")
 	lines := strings.Split(strings.Trim(out, "\n "), "\n")
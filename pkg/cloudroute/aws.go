@@ -0,0 +1,315 @@
+package cloudroute
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ec2APIVersion = "2016-11-15"
+
+// ec2Endpoint returns the EC2 API of region.
+var ec2Endpoint = func(region string) string {
+	return "https://ec2." + region + ".amazonaws.com/"
+}
+
+// AWS routes the subnets to the instance in the route table of its subnet,
+// or in the main route table of its VPC, and turns the source/destination
+// check of the instance off. The routes going to another instance are left
+// alone, unless that instance is gone. It calls the EC2 API with the credentials of
+// the IAM role of the instance, which must be allowed
+// ec2:DescribeRouteTables, ec2:CreateRoute, ec2:ReplaceRoute,
+// ec2:DeleteRoute and ec2:ModifyInstanceAttribute.
+type AWS struct {
+	instanceID string
+	region     string
+	subnetID   string
+	vpcID      string
+
+	sync.Mutex
+	routeTable string // empty until found
+}
+
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+}
+
+type ec2Error struct {
+	Code    string `xml:"Errors>Error>Code"`
+	Message string `xml:"Errors>Error>Message"`
+}
+
+func (e *ec2Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newAWS(routeTable string) (*AWS, error) {
+	a := &AWS{routeTable: routeTable}
+	var err error
+	if a.instanceID, err = metadata("/latest/meta-data/instance-id"); err != nil {
+		return nil, err
+	}
+	zone, err := metadata("/latest/meta-data/placement/availability-zone")
+	if err != nil {
+		return nil, err
+	}
+	// us-east-1a is a zone of us-east-1
+	a.region = strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")
+	mac, err := metadata("/latest/meta-data/mac")
+	if err != nil {
+		return nil, err
+	}
+	if a.subnetID, err = metadata("/latest/meta-data/network/interfaces/macs/" + mac + "/subnet-id"); err != nil {
+		return nil, err
+	}
+	if a.vpcID, err = metadata("/latest/meta-data/network/interfaces/macs/" + mac + "/vpc-id"); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// credentials reads the temporary credentials of the role of the instance.
+func (a *AWS) credentials() (*awsCredentials, error) {
+	role, err := metadata("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, fmt.Errorf("The instance has no IAM role: %s", err)
+	}
+	data, err := metadata("/latest/meta-data/iam/security-credentials/" + strings.Split(role, "\n")[0])
+	if err != nil {
+		return nil, err
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// call calls the action of the EC2 API with params.
+func (a *AWS) call(action string, params url.Values) ([]byte, error) {
+	creds, err := a.credentials()
+	if err != nil {
+		return nil, err
+	}
+	params.Set("Action", action)
+	params.Set("Version", ec2APIVersion)
+	body := params.Encode()
+	req, err := http.NewRequest("POST", ec2Endpoint(a.region), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, body, a.region, "ec2", creds, time.Now().UTC())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &ec2Error{}
+		if err := xml.Unmarshal(data, e); err != nil || e.Code == "" {
+			return nil, fmt.Errorf("%s failed: %s", action, resp.Status)
+		}
+		return nil, e
+	}
+	return data, nil
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// signV4 signs req, of the given body, with the Signature Version 4 of AWS.
+func signV4(req *http.Request, body, region, service string, creds *awsCredentials, now time.Time) {
+	var (
+		amzDate = now.Format("20060102T150405Z")
+		day     = now.Format("20060102")
+		scope   = day + "/" + region + "/" + service + "/aws4_request"
+	)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if creds.Token != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	var canonicalHeaders string
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders += h + ":" + strings.TrimSpace(value) + "\n"
+	}
+	signedHeaders := strings.Join(headers, ";")
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders, signedHeaders, sha256Hex(body)}, "\n")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyId, scope, signedHeaders, signature))
+}
+
+type describeRouteTablesResponse struct {
+	RouteTableIDs []string `xml:"routeTableSet>item>routeTableId"`
+}
+
+// findRouteTable returns the route table of the subnet of the instance, or
+// the main one of its VPC if the subnet has none of its own.
+func (a *AWS) findRouteTable() (string, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.routeTable != "" {
+		return a.routeTable, nil
+	}
+	for _, filters := range []url.Values{
+		{"Filter.1.Name": {"association.subnet-id"}, "Filter.1.Value.1": {a.subnetID}},
+		{"Filter.1.Name": {"vpc-id"}, "Filter.1.Value.1": {a.vpcID}, "Filter.2.Name": {"association.main"}, "Filter.2.Value.1": {"true"}},
+	} {
+		data, err := a.call("DescribeRouteTables", filters)
+		if err != nil {
+			return "", err
+		}
+		var resp describeRouteTablesResponse
+		if err := xml.Unmarshal(data, &resp); err != nil {
+			return "", err
+		}
+		if len(resp.RouteTableIDs) > 0 {
+			a.routeTable = resp.RouteTableIDs[0]
+			return a.routeTable, nil
+		}
+	}
+	return "", fmt.Errorf("No route table found for the subnet %s", a.subnetID)
+}
+
+func (a *AWS) AddRoute(subnet *net.IPNet) error {
+	// The instance forwards the traffic of the subnet, which isn't its own
+	if _, err := a.call("ModifyInstanceAttribute", url.Values{
+		"InstanceId":            {a.instanceID},
+		"SourceDestCheck.Value": {"false"},
+	}); err != nil {
+		return err
+	}
+	table, err := a.findRouteTable()
+	if err != nil {
+		return err
+	}
+	params := url.Values{
+		"RouteTableId":         {table},
+		"DestinationCidrBlock": {subnet.String()},
+		"InstanceId":           {a.instanceID},
+	}
+	_, err = a.call("CreateRoute", params)
+	if e, ok := err.(*ec2Error); !ok || e.Code != "RouteAlreadyExists" {
+		return err
+	}
+	// The subnet is routed already, the bridges of the hosts sharing their
+	// default network
+	route, err := a.findRoute(table, subnet)
+	if err != nil || route == nil {
+		return err
+	}
+	switch {
+	case route.InstanceId == a.instanceID:
+		return nil
+	case route.State == "blackhole":
+		// The instance it went to is gone
+		_, err = a.call("ReplaceRoute", params)
+		return err
+	}
+	return fmt.Errorf("The route table %s routes %s to %s already", table, subnet, route.target())
+}
+
+func (a *AWS) RemoveRoute(subnet *net.IPNet) error {
+	table, err := a.findRouteTable()
+	if err != nil {
+		return err
+	}
+	// The route may have been taken over by another instance since
+	route, err := a.findRoute(table, subnet)
+	if err != nil || route == nil || route.InstanceId != a.instanceID {
+		return err
+	}
+	_, err = a.call("DeleteRoute", url.Values{
+		"RouteTableId":         {table},
+		"DestinationCidrBlock": {subnet.String()},
+	})
+	if e, ok := err.(*ec2Error); ok && e.Code == "InvalidRoute.NotFound" {
+		return nil
+	}
+	return err
+}
+
+type ec2Route struct {
+	DestinationCidrBlock string `xml:"destinationCidrBlock"`
+	InstanceId           string `xml:"instanceId"`
+	GatewayId            string `xml:"gatewayId"`
+	NetworkInterfaceId   string `xml:"networkInterfaceId"`
+	State                string `xml:"state"`
+}
+
+// target returns what the route goes to.
+func (r *ec2Route) target() string {
+	for _, target := range []string{r.InstanceId, r.NetworkInterfaceId, r.GatewayId} {
+		if target != "" {
+			return target
+		}
+	}
+	return "another target"
+}
+
+type describeRoutesResponse struct {
+	Routes []*ec2Route `xml:"routeTableSet>item>routeSet>item"`
+}
+
+// findRoute returns the route of subnet in table, or nil if there is none.
+func (a *AWS) findRoute(table string, subnet *net.IPNet) (*ec2Route, error) {
+	data, err := a.call("DescribeRouteTables", url.Values{"RouteTableId.1": {table}})
+	if err != nil {
+		return nil, err
+	}
+	var resp describeRoutesResponse
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	for _, route := range resp.Routes {
+		if route.DestinationCidrBlock == subnet.String() {
+			return route, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,78 @@
+// Package cloudroute routes a subnet to the host in the network of its
+// cloud, the route table of the VPC on AWS or the router of Neutron on
+// OpenStack, and lets the host carry the traffic of the subnet, so that the
+// subnets of the hosts reach each other without an overlay.
+package cloudroute
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	providerAWS       = "aws"
+	providerOpenStack = "openstack"
+)
+
+var (
+	// Metadata service of the instance, on both clouds
+	metadataURL = "http://169.254.169.254"
+
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Router routes subnets to the host in the network of its cloud.
+type Router interface {
+	// AddRoute routes subnet to the host, and lets the host send and
+	// receive its traffic. It fails if subnet is routed to another host
+	// already. Adding the route again repairs it.
+	AddRoute(subnet *net.IPNet) error
+	// RemoveRoute removes the route of subnet if it goes to the host,
+	// leaving the route of another host alone.
+	RemoveRoute(subnet *net.IPNet) error
+}
+
+// New returns the router of spec, "aws" or "openstack", followed by
+// ":" and the id of the route table or of the router if given, found from
+// the network of the host otherwise.
+func New(spec string) (Router, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	id := ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+	switch parts[0] {
+	case providerAWS:
+		return newAWS(id)
+	case providerOpenStack:
+		return newOpenStack(id)
+	}
+	return nil, fmt.Errorf("Unknown cloud %s, expected %s or %s", parts[0], providerAWS, providerOpenStack)
+}
+
+// ValidSpec tells whether spec names a cloud New knows.
+func ValidSpec(spec string) bool {
+	name := strings.SplitN(spec, ":", 2)[0]
+	return name == providerAWS || name == providerOpenStack
+}
+
+// metadata reads a value of the metadata service.
+func metadata(path string) (string, error) {
+	resp, err := httpClient.Get(metadataURL + path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to reach the metadata service: %s", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to read %s from the metadata service: %s", path, resp.Status)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
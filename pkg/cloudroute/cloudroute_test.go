@@ -0,0 +1,308 @@
+package cloudroute
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	for _, spec := range []string{"aws", "aws:rtb-1234", "openstack", "openstack:4b3f"} {
+		if !ValidSpec(spec) {
+			t.Fatalf("Expected %s to be valid", spec)
+		}
+	}
+	if ValidSpec("gce") {
+		t.Fatal("Expected gce to be refused")
+	}
+	if _, err := New("gce"); err == nil {
+		t.Fatal("Expected gce to be refused")
+	}
+}
+
+// Example of the documentation of the Signature Version 4 of AWS
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signV4(req, "", "us-east-1", "iam", &awsCredentials{
+		AccessKeyId:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, now)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Fatalf("Expected %s, got %s", expected, auth)
+	}
+}
+
+func serveMetadata(t *testing.T, values map[string]string) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := values[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v))
+	}))
+	saved := metadataURL
+	metadataURL = server.URL
+	return func() {
+		metadataURL = saved
+		server.Close()
+	}
+}
+
+func TestAWS(t *testing.T) {
+	defer serveMetadata(t, map[string]string{
+		"/latest/meta-data/instance-id":                                         "i-1234",
+		"/latest/meta-data/placement/availability-zone":                         "eu-west-1b",
+		"/latest/meta-data/mac":                                                 "0a:1b:2c:3d:4e:5f",
+		"/latest/meta-data/network/interfaces/macs/0a:1b:2c:3d:4e:5f/subnet-id": "subnet-1",
+		"/latest/meta-data/network/interfaces/macs/0a:1b:2c:3d:4e:5f/vpc-id":    "vpc-1",
+		"/latest/meta-data/iam/security-credentials/":                           "docker-host",
+		"/latest/meta-data/iam/security-credentials/docker-host":                `{"AccessKeyId": "AKID", "SecretAccessKey": "secret", "Token": "token"}`,
+	})()
+
+	var (
+		calls []url.Values
+		// The route of the subnet in the route table, none if empty
+		routeInstance, routeState string
+	)
+	ec2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Security-Token") != "token" {
+			t.Errorf("Unsigned request %v", r.Header)
+		}
+		r.ParseForm()
+		calls = append(calls, r.PostForm)
+		switch r.PostForm.Get("Action") {
+		case "DescribeRouteTables":
+			switch {
+			case r.PostForm.Get("RouteTableId.1") == "rtb-main":
+				w.Write([]byte(`<DescribeRouteTablesResponse><routeTableSet><item><routeTableId>rtb-main</routeTableId><routeSet>` +
+					`<item><destinationCidrBlock>10.0.0.0/16</destinationCidrBlock><gatewayId>local</gatewayId><state>active</state></item>` +
+					`<item><destinationCidrBlock>172.17.0.0/16</destinationCidrBlock><instanceId>` + routeInstance + `</instanceId><state>` + routeState + `</state></item>` +
+					`</routeSet></item></routeTableSet></DescribeRouteTablesResponse>`))
+			case r.PostForm.Get("Filter.1.Name") == "vpc-id":
+				// The subnet has no route table of its own
+				w.Write([]byte(`<DescribeRouteTablesResponse><routeTableSet><item><routeTableId>rtb-main</routeTableId></item></routeTableSet></DescribeRouteTablesResponse>`))
+			default:
+				w.Write([]byte(`<DescribeRouteTablesResponse><routeTableSet/></DescribeRouteTablesResponse>`))
+			}
+		case "CreateRoute":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<Response><Errors><Error><Code>RouteAlreadyExists</Code><Message>exists</Message></Error></Errors></Response>`))
+		}
+	}))
+	defer ec2.Close()
+	savedEndpoint := ec2Endpoint
+	ec2Endpoint = func(region string) string {
+		if region != "eu-west-1" {
+			t.Errorf("Expected the region eu-west-1, got %s", region)
+		}
+		return ec2.URL + "/"
+	}
+	defer func() { ec2Endpoint = savedEndpoint }()
+	actions := func() string {
+		var actions []string
+		for _, c := range calls {
+			actions = append(actions, c.Get("Action"))
+		}
+		return strings.Join(actions, ",")
+	}
+
+	r, err := New("aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, subnet, _ := net.ParseCIDR("172.17.0.0/16")
+
+	// The instance the route went to is gone
+	routeInstance, routeState = "i-5678", "blackhole"
+	if err := r.AddRoute(subnet); err != nil {
+		t.Fatal(err)
+	}
+	if a := actions(); a != "ModifyInstanceAttribute,DescribeRouteTables,DescribeRouteTables,CreateRoute,DescribeRouteTables,ReplaceRoute" {
+		t.Fatalf("Unexpected calls %v", a)
+	}
+	if calls[0].Get("SourceDestCheck.Value") != "false" || calls[0].Get("InstanceId") != "i-1234" {
+		t.Fatalf("Expected the source/destination check to be turned off, got %v", calls[0])
+	}
+	replace := calls[5]
+	if replace.Get("RouteTableId") != "rtb-main" || replace.Get("DestinationCidrBlock") != "172.17.0.0/16" || replace.Get("InstanceId") != "i-1234" {
+		t.Fatalf("Unexpected route %v", replace)
+	}
+
+	// Routed to the host already
+	routeInstance, routeState = "i-1234", "active"
+	calls = nil
+	if err := r.AddRoute(subnet); err != nil {
+		t.Fatal(err)
+	}
+	if a := actions(); a != "ModifyInstanceAttribute,CreateRoute,DescribeRouteTables" {
+		t.Fatalf("Unexpected calls %v", a)
+	}
+
+	calls = nil
+	if err := r.RemoveRoute(subnet); err != nil {
+		t.Fatal(err)
+	}
+	if a := actions(); a != "DescribeRouteTables,DeleteRoute" || calls[1].Get("RouteTableId") != "rtb-main" {
+		t.Fatalf("Unexpected calls %v", calls)
+	}
+
+	// The route of another host is neither taken over nor removed
+	routeInstance, routeState = "i-5678", "active"
+	calls = nil
+	if err := r.AddRoute(subnet); err == nil || !strings.Contains(err.Error(), "i-5678") {
+		t.Fatalf("Expected the route of i-5678 to be left alone, got %v", err)
+	}
+	if err := r.RemoveRoute(subnet); err != nil {
+		t.Fatal(err)
+	}
+	if a := actions(); strings.Contains(a, "ReplaceRoute") || strings.Contains(a, "DeleteRoute") {
+		t.Fatalf("Expected the route of i-5678 to be left alone, got %v", a)
+	}
+}
+
+func TestOpenStack(t *testing.T) {
+	defer serveMetadata(t, map[string]string{
+		"/openstack/latest/meta_data.json": `{"uuid": "instance-1"}`,
+	})()
+
+	var (
+		pairs    []neutronAddressPair
+		routes   = []neutronRoute{{Destination: "10.1.0.0/16", Nexthop: "192.168.0.4"}}
+		revision = 3
+		// Changes made by another host between the reads and the updates
+		racing = 1
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/v3/auth/tokens":
+			w.Header().Set("X-Subject-Token", "token")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token": {"catalog": [{"type": "network", "endpoints": [{"interface": "public", "url": "` + server.URL + `/neutron/"}]}]}}`))
+			return
+		case r.Header.Get("X-Auth-Token") != "token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/neutron/v2.0/ports" && r.URL.Query().Get("device_id") == "instance-1":
+			port := map[string]interface{}{
+				"id": "port-1", "network_id": "net-1", "device_id": "instance-1",
+				"fixed_ips":             []map[string]string{{"subnet_id": "subnet-1", "ip_address": "192.168.0.5"}},
+				"allowed_address_pairs": pairs,
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"ports": []interface{}{port}})
+		case r.URL.Path == "/neutron/v2.0/ports":
+			w.Write([]byte(`{"ports": [{"id": "port-2", "device_id": "router-1", "fixed_ips": [{"subnet_id": "subnet-1", "ip_address": "192.168.0.1"}]}]}`))
+		case r.URL.Path == "/neutron/v2.0/ports/port-1" && r.Method == "PUT":
+			var update struct {
+				Port struct {
+					AllowedAddressPairs []neutronAddressPair `json:"allowed_address_pairs"`
+				} `json:"port"`
+			}
+			json.Unmarshal(body, &update)
+			pairs = update.Port.AllowedAddressPairs
+		case r.URL.Path == "/neutron/v2.0/routers/router-1" && r.Method == "GET":
+			json.NewEncoder(w).Encode(map[string]interface{}{"router": map[string]interface{}{"routes": routes, "revision_number": revision}})
+			if racing > 0 {
+				racing--
+				revision++
+			}
+		case r.URL.Path == "/neutron/v2.0/routers/router-1" && r.Method == "PUT":
+			if r.Header.Get("If-Match") != "revision_number="+strconv.Itoa(revision) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			var update struct {
+				Router struct {
+					Routes []neutronRoute `json:"routes"`
+				} `json:"router"`
+			}
+			json.Unmarshal(body, &update)
+			routes = update.Router.Routes
+			revision++
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for key, value := range map[string]string{
+		"OS_AUTH_URL":     server.URL + "/v3",
+		"OS_USERNAME":     "admin",
+		"OS_PASSWORD":     "secret",
+		"OS_PROJECT_NAME": "docker",
+	} {
+		saved := os.Getenv(key)
+		os.Setenv(key, value)
+		defer os.Setenv(key, saved)
+	}
+
+	r, err := New("openstack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, subnet, _ := net.ParseCIDR("172.17.0.0/16")
+	// Adding it twice keeps a single route
+	for i := 0; i < 2; i++ {
+		if err := r.AddRoute(subnet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(routes) != 2 || routes[1] != (neutronRoute{Destination: "172.17.0.0/16", Nexthop: "192.168.0.5"}) {
+		t.Fatalf("Unexpected routes %v", routes)
+	}
+	if len(pairs) != 1 || pairs[0].IPAddress != "172.17.0.0/16" {
+		t.Fatalf("Unexpected address pairs %v", pairs)
+	}
+
+	if err := r.RemoveRoute(subnet); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Destination != "10.1.0.0/16" || len(pairs) != 0 {
+		t.Fatalf("Expected the route and the address pair to be removed, got %v, %v", routes, pairs)
+	}
+
+	// The route of another host is neither taken over nor removed
+	routes = append(routes, neutronRoute{Destination: "172.17.0.0/16", Nexthop: "192.168.0.6"})
+	if err := r.AddRoute(subnet); err == nil || !strings.Contains(err.Error(), "192.168.0.6") {
+		t.Fatalf("Expected the route to 192.168.0.6 to be left alone, got %v", err)
+	}
+	if err := r.RemoveRoute(subnet); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[1].Nexthop != "192.168.0.6" || len(pairs) != 0 {
+		t.Fatalf("Expected the route to 192.168.0.6 to be left alone, got %v, %v", routes, pairs)
+	}
+}
+
+func TestGCEAliasRanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/network-interfaces/0/ip-aliases/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("10.8.1.0/24\n10.8.9.4/32\n"))
+	}))
+	defer server.Close()
+	defer func(saved string) { metadataURL = saved }(metadataURL)
+	metadataURL = server.URL
+
+	ranges, err := GCEAliasRanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 2 || ranges[0].String() != "10.8.1.0/24" || ranges[1].String() != "10.8.9.4/32" {
+		t.Fatalf("Unexpected alias IP ranges %v", ranges)
+	}
+}
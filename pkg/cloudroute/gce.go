@@ -0,0 +1,41 @@
+package cloudroute
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// GCEAliasRanges returns the alias IP ranges of the first network interface
+// of the GCE instance. The VPC routes them to the instance natively, so
+// that their addresses need neither a route of their own nor NAT.
+func GCEAliasRanges() ([]*net.IPNet, error) {
+	req, err := http.NewRequest("GET", metadataURL+"/computeMetadata/v1/instance/network-interfaces/0/ip-aliases/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach the metadata service: %s", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to read the alias IP ranges from the metadata service: %s", resp.Status)
+	}
+	var ranges []*net.IPNet
+	for _, line := range strings.Fields(string(data)) {
+		_, r, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid alias IP range %s: %s", line, err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
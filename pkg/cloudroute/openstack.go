@@ -0,0 +1,359 @@
+package cloudroute
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpenStack routes the subnets to the instance in the Neutron router of its
+// network, and adds them to the allowed address pairs of its port so that
+// the port security of Neutron lets their traffic through. The routes going
+// to another instance are left alone, the routes of the router being
+// changed only at the revision they were read at. It
+// authenticates against Keystone v3 with the usual OS_AUTH_URL,
+// OS_USERNAME, OS_PASSWORD, OS_PROJECT_NAME, OS_USER_DOMAIN_NAME,
+// OS_PROJECT_DOMAIN_NAME and OS_REGION_NAME of the environment of the
+// daemon.
+type OpenStack struct {
+	authURL       string
+	username      string
+	password      string
+	project       string
+	userDomain    string
+	projectDomain string
+	region        string
+	instanceID    string
+
+	sync.Mutex
+	router string // empty until found
+}
+
+// maxRouterUpdates is how many times the routes of the router are changed
+// before giving up, when other hosts keep changing them in between.
+const maxRouterUpdates = 5
+
+var errRevisionChanged = errors.New("The router was changed meanwhile")
+
+type neutronPort struct {
+	ID        string `json:"id"`
+	NetworkID string `json:"network_id"`
+	DeviceID  string `json:"device_id"`
+	FixedIPs  []struct {
+		SubnetID  string `json:"subnet_id"`
+		IPAddress string `json:"ip_address"`
+	} `json:"fixed_ips"`
+	AllowedAddressPairs []neutronAddressPair `json:"allowed_address_pairs"`
+}
+
+type neutronAddressPair struct {
+	IPAddress  string `json:"ip_address"`
+	MacAddress string `json:"mac_address,omitempty"`
+}
+
+type neutronRoute struct {
+	Destination string `json:"destination"`
+	Nexthop     string `json:"nexthop"`
+}
+
+func getenvDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func newOpenStack(router string) (*OpenStack, error) {
+	o := &OpenStack{
+		authURL:       strings.TrimRight(os.Getenv("OS_AUTH_URL"), "/"),
+		username:      os.Getenv("OS_USERNAME"),
+		password:      os.Getenv("OS_PASSWORD"),
+		project:       os.Getenv("OS_PROJECT_NAME"),
+		userDomain:    getenvDefault("OS_USER_DOMAIN_NAME", "Default"),
+		projectDomain: getenvDefault("OS_PROJECT_DOMAIN_NAME", "Default"),
+		region:        os.Getenv("OS_REGION_NAME"),
+		router:        router,
+	}
+	if o.authURL == "" || o.username == "" || o.password == "" || o.project == "" {
+		return nil, fmt.Errorf("OS_AUTH_URL, OS_USERNAME, OS_PASSWORD and OS_PROJECT_NAME must be set to route on OpenStack")
+	}
+	data, err := metadata("/openstack/latest/meta_data.json")
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return nil, err
+	}
+	o.instanceID = meta.UUID
+	return o, nil
+}
+
+// authenticate returns a token of Keystone and the url of Neutron.
+func (o *OpenStack) authenticate() (string, string, error) {
+	var auth struct {
+		Auth struct {
+			Identity struct {
+				Methods  []string `json:"methods"`
+				Password struct {
+					User struct {
+						Name     string            `json:"name"`
+						Domain   map[string]string `json:"domain"`
+						Password string            `json:"password"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+			Scope struct {
+				Project struct {
+					Name   string            `json:"name"`
+					Domain map[string]string `json:"domain"`
+				} `json:"project"`
+			} `json:"scope"`
+		} `json:"auth"`
+	}
+	auth.Auth.Identity.Methods = []string{"password"}
+	auth.Auth.Identity.Password.User.Name = o.username
+	auth.Auth.Identity.Password.User.Domain = map[string]string{"name": o.userDomain}
+	auth.Auth.Identity.Password.User.Password = o.password
+	auth.Auth.Scope.Project.Name = o.project
+	auth.Auth.Scope.Project.Domain = map[string]string{"name": o.projectDomain}
+	body, err := json.Marshal(auth)
+	if err != nil {
+		return "", "", err
+	}
+
+	resp, err := httpClient.Post(o.authURL+"/auth/tokens", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", "", fmt.Errorf("Unable to reach Keystone: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", "", fmt.Errorf("Unable to authenticate against Keystone: %s", resp.Status)
+	}
+	var token struct {
+		Token struct {
+			Catalog []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", "", err
+	}
+
+	// The internal endpoint of the region, the public one otherwise
+	var endpoint string
+	for _, service := range token.Token.Catalog {
+		if service.Type != "network" {
+			continue
+		}
+		for _, e := range service.Endpoints {
+			if o.region != "" && e.Region != o.region {
+				continue
+			}
+			if e.Interface == "internal" || (e.Interface == "public" && endpoint == "") {
+				endpoint = e.URL
+			}
+		}
+	}
+	if endpoint == "" {
+		return "", "", fmt.Errorf("No network endpoint in the catalog of Keystone")
+	}
+	return resp.Header.Get("X-Subject-Token"), strings.TrimRight(endpoint, "/"), nil
+}
+
+// neutron calls Neutron, decoding the response into out if not nil.
+func (o *OpenStack) neutron(token, method, url string, in, out interface{}) error {
+	return o.neutronIf(token, method, url, 0, in, out)
+}
+
+// neutronIf calls Neutron as neutron does, the change only being made if
+// the resource is at the given revision, if not 0. It fails with
+// errRevisionChanged otherwise.
+func (o *OpenStack) neutronIf(token, method, url string, revision int, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("Content-Type", "application/json")
+	if revision != 0 {
+		req.Header.Set("If-Match", "revision_number="+strconv.Itoa(revision))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errRevisionChanged
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s failed: %s %s", method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// port returns the port of the instance, and its address the routes go to.
+func (o *OpenStack) port(token, endpoint string) (*neutronPort, string, error) {
+	var ports struct {
+		Ports []*neutronPort `json:"ports"`
+	}
+	if err := o.neutron(token, "GET", endpoint+"/v2.0/ports?device_id="+url.QueryEscape(o.instanceID), nil, &ports); err != nil {
+		return nil, "", err
+	}
+	for _, p := range ports.Ports {
+		for _, ip := range p.FixedIPs {
+			if parsed := net.ParseIP(ip.IPAddress); parsed != nil && parsed.To4() != nil {
+				return p, ip.IPAddress, nil
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("No port with an IPv4 address found for the instance %s", o.instanceID)
+}
+
+// findRouter returns the router of the subnet of port.
+func (o *OpenStack) findRouter(token, endpoint string, port *neutronPort) (string, error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.router != "" {
+		return o.router, nil
+	}
+	var ports struct {
+		Ports []*neutronPort `json:"ports"`
+	}
+	query := "?device_owner=network:router_interface&network_id=" + url.QueryEscape(port.NetworkID)
+	if err := o.neutron(token, "GET", endpoint+"/v2.0/ports"+query, nil, &ports); err != nil {
+		return "", err
+	}
+	for _, p := range ports.Ports {
+		for _, ip := range p.FixedIPs {
+			for _, own := range port.FixedIPs {
+				if ip.SubnetID == own.SubnetID {
+					o.router = p.DeviceID
+					return o.router, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("No router found for the network %s", port.NetworkID)
+}
+
+// update changes the routes of the router of the instance, then the
+// address pairs of its port.
+func (o *OpenStack) update(subnet *net.IPNet, add bool) error {
+	token, endpoint, err := o.authenticate()
+	if err != nil {
+		return err
+	}
+	port, nexthop, err := o.port(token, endpoint)
+	if err != nil {
+		return err
+	}
+	router, err := o.findRouter(token, endpoint, port)
+	if err != nil {
+		return err
+	}
+	destination := subnet.String()
+
+	// The other hosts change the routes of the router too, the change being
+	// made again if one did in between
+	for i := 0; ; i++ {
+		err := o.updateRoutes(token, endpoint, router, destination, nexthop, add)
+		if err == nil {
+			break
+		}
+		if err != errRevisionChanged || i == maxRouterUpdates-1 {
+			return err
+		}
+	}
+
+	pairs := []neutronAddressPair{}
+	for _, p := range port.AllowedAddressPairs {
+		if p.IPAddress != destination {
+			pairs = append(pairs, p)
+		}
+	}
+	if add {
+		pairs = append(pairs, neutronAddressPair{IPAddress: destination})
+	}
+	return o.neutron(token, "PUT", endpoint+"/v2.0/ports/"+port.ID, map[string]interface{}{
+		"port": map[string]interface{}{"allowed_address_pairs": pairs},
+	}, nil)
+}
+
+// updateRoutes adds the route of destination to nexthop to the routes of
+// router, or removes it. It fails if destination is routed to another
+// nexthop already, leaving that route alone.
+func (o *OpenStack) updateRoutes(token, endpoint, router, destination, nexthop string, add bool) error {
+	var current struct {
+		Router struct {
+			Routes         []neutronRoute `json:"routes"`
+			RevisionNumber int            `json:"revision_number"`
+		} `json:"router"`
+	}
+	if err := o.neutron(token, "GET", endpoint+"/v2.0/routers/"+router, nil, &current); err != nil {
+		return err
+	}
+	routes := []neutronRoute{}
+	for _, r := range current.Router.Routes {
+		if r.Destination != destination {
+			routes = append(routes, r)
+			continue
+		}
+		if r.Nexthop != nexthop {
+			if add {
+				return fmt.Errorf("The router %s routes %s to %s already", router, destination, r.Nexthop)
+			}
+			routes = append(routes, r)
+		} else if add {
+			// Routed to the host already
+			return nil
+		}
+	}
+	if add {
+		routes = append(routes, neutronRoute{Destination: destination, Nexthop: nexthop})
+	} else if len(routes) == len(current.Router.Routes) {
+		return nil
+	}
+	return o.neutronIf(token, "PUT", endpoint+"/v2.0/routers/"+router, current.Router.RevisionNumber, map[string]interface{}{
+		"router": map[string]interface{}{"routes": routes},
+	}, nil)
+}
+
+func (o *OpenStack) AddRoute(subnet *net.IPNet) error {
+	return o.update(subnet, true)
+}
+
+func (o *OpenStack) RemoveRoute(subnet *net.IPNet) error {
+	return o.update(subnet, false)
+}
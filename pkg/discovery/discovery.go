@@ -0,0 +1,186 @@
+// Package discovery registers services in the service discovery backends
+// the load balancers watch: the agent of Consul, or etcd.
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Interval of the health checks of Consul
+	checkInterval = "10s"
+
+	// The etcd keys expire unless registered again before, so that the
+	// services of a host which died are forgotten
+	etcdTTL = 90
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Service is a port of the host registered in a backend.
+type Service struct {
+	ID      string   // unique among the services of the backend
+	Name    string   // shared by the instances of the service
+	Address string   // empty for the address of the host
+	Port    int      // port of the host
+	Proto   string   // tcp or udp
+	Tags    []string // ex: "80/tcp"
+}
+
+// Backend keeps the services registered in a service discovery backend.
+// Registering a service again refreshes it.
+type Backend interface {
+	Register(s *Service) error
+	Deregister(s *Service) error
+}
+
+// New returns the backend of rawurl, either consul://host:port for the
+// agent of Consul, or etcd://host:port/prefix for the keys of etcd under
+// prefix.
+func New(rawurl string) (Backend, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Missing host in the discovery backend %s", rawurl)
+	}
+	switch u.Scheme {
+	case "consul":
+		return &Consul{url: "http://" + u.Host}, nil
+	case "etcd":
+		prefix := strings.Trim(u.Path, "/")
+		if prefix == "" {
+			prefix = "services"
+		}
+		return &Etcd{url: "http://" + u.Host, prefix: prefix}, nil
+	}
+	return nil, fmt.Errorf("Unknown discovery backend %s, expected consul:// or etcd://", rawurl)
+}
+
+func do(method, url, contentType string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Consul registers the services in the agent of Consul, which checks their
+// health.
+type Consul struct {
+	url string
+}
+
+type consulCheck struct {
+	TCP      string `json:",omitempty"`
+	Interval string `json:",omitempty"`
+}
+
+type consulService struct {
+	ID      string
+	Name    string
+	Tags    []string `json:",omitempty"`
+	Address string   `json:",omitempty"`
+	Port    int
+	Check   *consulCheck `json:",omitempty"`
+}
+
+func (c *Consul) Register(s *Service) error {
+	cs := &consulService{
+		ID:      s.ID,
+		Name:    s.Name,
+		Tags:    s.Tags,
+		Address: s.Address,
+		Port:    s.Port,
+	}
+	// The TCP ports are checked by the agent, of the host if the service
+	// has no address of its own. UDP can't be checked.
+	if s.Proto == "tcp" {
+		addr := s.Address
+		if addr == "" {
+			addr = "127.0.0.1"
+		}
+		cs.Check = &consulCheck{TCP: net.JoinHostPort(addr, fmt.Sprint(s.Port)), Interval: checkInterval}
+	}
+	body, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+	return do("PUT", c.url+"/v1/agent/service/register", "application/json", body)
+}
+
+func (c *Consul) Deregister(s *Service) error {
+	return do("PUT", c.url+"/v1/agent/service/deregister/"+url.QueryEscape(s.ID), "", nil)
+}
+
+// Etcd registers the services as the keys prefix/name/id of etcd, holding
+// the address, port and health of the instances, in the v2 keys API.
+type Etcd struct {
+	url    string
+	prefix string
+}
+
+type etcdInstance struct {
+	Host   string
+	Port   int
+	Proto  string
+	Tags   []string `json:",omitempty"`
+	Health string
+}
+
+func (e *Etcd) key(s *Service) string {
+	return fmt.Sprintf("%s/v2/keys/%s/%s/%s", e.url, e.prefix, url.QueryEscape(s.Name), url.QueryEscape(s.ID))
+}
+
+func (e *Etcd) Register(s *Service) error {
+	host := s.Address
+	if host == "" {
+		ip, err := outboundIP()
+		if err != nil {
+			return err
+		}
+		host = ip.String()
+	}
+	value, err := json.Marshal(&etcdInstance{Host: host, Port: s.Port, Proto: s.Proto, Tags: s.Tags, Health: "passing"})
+	if err != nil {
+		return err
+	}
+	form := url.Values{"value": {string(value)}, "ttl": {fmt.Sprint(etcdTTL)}}
+	return do("PUT", e.key(s), "application/x-www-form-urlencoded", []byte(form.Encode()))
+}
+
+func (e *Etcd) Deregister(s *Service) error {
+	return do("DELETE", e.key(s), "", nil)
+}
+
+// outboundIP returns the address of the host on its default route, no
+// packet being sent.
+func outboundIP() (net.IP, error) {
+	conn, err := net.Dial("udp4", "8.8.8.8:53")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find the address of the host: %s", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
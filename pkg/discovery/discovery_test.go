@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type request struct {
+	method, path, body string
+}
+
+func recordRequests(requests *[]request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		*requests = append(*requests, request{r.Method, r.URL.Path, string(body)})
+	}))
+}
+
+func TestNew(t *testing.T) {
+	if b, err := New("etcd://127.0.0.1:4001"); err != nil || b.(*Etcd).prefix != "services" {
+		t.Fatalf("Expected the default prefix, got %v, %v", b, err)
+	}
+	for _, invalid := range []string{"zookeeper://127.0.0.1:2181", "consul://", "127.0.0.1:8500"} {
+		if _, err := New(invalid); err == nil {
+			t.Fatalf("Expected %s to be refused", invalid)
+		}
+	}
+}
+
+func TestConsul(t *testing.T) {
+	var requests []request
+	server := recordRequests(&requests)
+	defer server.Close()
+
+	b, err := New("consul://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{ID: "c1:0.0.0.0:8080:tcp", Name: "web", Port: 8080, Proto: "tcp", Tags: []string{"80/tcp"}}
+	if err := b.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Deregister(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].method != "PUT" || requests[0].path != "/v1/agent/service/register" {
+		t.Fatalf("Unexpected requests %v", requests)
+	}
+	var registered consulService
+	if err := json.Unmarshal([]byte(requests[0].body), &registered); err != nil {
+		t.Fatal(err)
+	}
+	if registered.Name != "web" || registered.Port != 8080 || registered.Address != "" || registered.Check == nil || registered.Check.TCP != "127.0.0.1:8080" {
+		t.Fatalf("Unexpected registration %s", requests[0].body)
+	}
+	if requests[1].path != "/v1/agent/service/deregister/c1:0.0.0.0:8080:tcp" {
+		t.Fatalf("Unexpected deregistration %v", requests[1])
+	}
+}
+
+func TestEtcd(t *testing.T) {
+	var requests []request
+	server := recordRequests(&requests)
+	defer server.Close()
+
+	b, err := New("etcd://" + strings.TrimPrefix(server.URL, "http://") + "/lb/services/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{ID: "c1:10.0.0.1:53:udp", Name: "dns", Address: "10.0.0.1", Port: 53, Proto: "udp"}
+	if err := b.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Deregister(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].method != "PUT" || requests[0].path != "/v2/keys/lb/services/dns/c1:10.0.0.1:53:udp" {
+		t.Fatalf("Unexpected requests %v", requests)
+	}
+	if !strings.Contains(requests[0].body, "ttl=90") || !strings.Contains(requests[0].body, "%22Host%22%3A%2210.0.0.1%22") {
+		t.Fatalf("Unexpected registration %s", requests[0].body)
+	}
+	if requests[1].method != "DELETE" || requests[1].path != requests[0].path {
+		t.Fatalf("Unexpected deregistration %v", requests[1])
+	}
+}
@@ -1,13 +1,18 @@
 package iptables
 
 import (
+	"bytes"
 	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -17,55 +22,208 @@ type Action string
 const (
 	Add    Action = "-A"
 	Delete Action = "-D"
+	Check  Action = "-C"
 )
 
 var (
 	ErrIptablesNotFound = errors.New("Iptables not found")
 	nat                 = []string{"-t", "nat"}
 	supportsXlock       = false
+
+	stats struct {
+		sync.Mutex
+		calls    uint64
+		failures uint64
+		duration time.Duration
+	}
+
+	// The settings of the package functions and of the chains they make
+	defaultContext = NewContext()
 )
 
+const DefaultTimeout = 30 * time.Second
+
+// Context holds the settings the commands are run with, for the users of
+// the firewall which each need their own, such as several network drivers
+// in one process. The package functions run with a default context.
+type Context struct {
+	recorder func(cmd string, args []string, err error)
+	dryRun   bool
+
+	// The rules carry the comment returned by commenter, if the comment
+	// match is supported
+	commenter        func(args []string) string
+	supportsComments bool
+
+	// The rules are only deleted if deleteCheck, if set, agrees
+	deleteCheck func(args []string) bool
+
+	// The commands are run by runner, if set, rather than executed
+	runner func(cmd string, args []string) ([]byte, error)
+
+	// The commands are killed past the timeout, as when another process
+	// holds the xtables lock for good
+	timeout time.Duration
+}
+
+// NewContext returns a context running the commands as they are, with the
+// default timeout.
+func NewContext() *Context {
+	return &Context{timeout: DefaultTimeout}
+}
+
 type Chain struct {
-	Ipv6   bool
-	Name   string
-	Bridge string
+	Ipv6       bool
+	Name       string
+	Bridge     string
+	Interfaces []string // if not empty, only traffic coming in on these interfaces is forwarded
+
+	adopted bool     // taken over from a previous run, along with rules which may be in place already
+	ctx     *Context // nil for the default context
+}
+
+// context returns the context the rules of c are changed with.
+func (c *Chain) context() *Context {
+	if c.ctx == nil {
+		return defaultContext
+	}
+	return c.ctx
+}
+
+// DryRun tells whether the changes to c are only reported, for the users
+// of the chain to hold back theirs as well.
+func (c *Chain) DryRun() bool {
+	return c.context().dryRun
 }
 
 func init() {
+	// Published as /debug/vars when the api runs in debug mode
+	expvar.Publish("iptables", expvar.Func(func() interface{} {
+		calls, failures, duration := Stats()
+		return map[string]interface{}{
+			"calls":       calls,
+			"failures":    failures,
+			"duration_ms": duration.Seconds() * 1000,
+		}
+	}))
 	supportsXlock = exec.Command("iptables", "--wait", "-L", "-n").Run() == nil
 }
 
-func NewChain(ipv6 bool, name, bridge string) (*Chain, error) {
-	if output, err := Raw(ipv6, "-t", "nat", "-N", name); err != nil {
+func NewChain(ipv6 bool, name, bridge string, ifaces []string) (*Chain, error) {
+	return defaultContext.NewChain(ipv6, name, bridge, ifaces)
+}
+
+// NewChain creates the chain as the package function does, its rules being
+// changed with ctx.
+func (ctx *Context) NewChain(ipv6 bool, name, bridge string, ifaces []string) (*Chain, error) {
+	if output, err := ctx.Raw(ipv6, "-t", "nat", "-N", name); err != nil {
 		return nil, err
 	} else if len(output) != 0 {
 		return nil, fmt.Errorf("Error creating new iptables chain: %s", output)
 	}
 	chain := &Chain{
-		Ipv6:   ipv6,
-		Name:   name,
-		Bridge: bridge,
+		Ipv6:       ipv6,
+		Name:       name,
+		Bridge:     bridge,
+		Interfaces: ifaces,
+		ctx:        ctx,
+	}
+	if err := chain.addJumps(false); err != nil {
+		return nil, err
 	}
+	return chain, nil
+}
 
-	loopbackCidr := LoopbackCidr(ipv6)
-	if err := chain.Prerouting(Add, "-m", "addrtype", "--dst-type", "LOCAL"); err != nil {
-		return nil, fmt.Errorf("Failed to inject docker in PREROUTING chain: %s", err)
+// AdoptChain takes over a chain left by a previous run along with its
+// rules, adding the jumps to it which are missing. It returns nil if there
+// is no such chain.
+func AdoptChain(ipv6 bool, name, bridge string, ifaces []string) (*Chain, error) {
+	return defaultContext.AdoptChain(ipv6, name, bridge, ifaces)
+}
+
+// AdoptChain takes over the chain as the package function does, its rules
+// being changed with ctx.
+func (ctx *Context) AdoptChain(ipv6 bool, name, bridge string, ifaces []string) (*Chain, error) {
+	if _, err := ctx.Raw(ipv6, "-t", "nat", "-n", "-L", name); err != nil {
+		return nil, nil
 	}
-	if err := chain.Output(Add, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", loopbackCidr); err != nil {
-		return nil, fmt.Errorf("Failed to inject docker in OUTPUT chain: %s", err)
+	chain := &Chain{
+		Ipv6:       ipv6,
+		Name:       name,
+		Bridge:     bridge,
+		Interfaces: ifaces,
+		adopted:    true,
+		ctx:        ctx,
+	}
+	if err := chain.addJumps(true); err != nil {
+		return nil, err
 	}
 	return chain, nil
 }
 
+// addJumps makes the local traffic jump to the chain, skipping the jumps
+// already in place if onlyMissing is set.
+func (c *Chain) addJumps(onlyMissing bool) error {
+	if len(c.Interfaces) == 0 {
+		args := []string{"-m", "addrtype", "--dst-type", "LOCAL"}
+		if !onlyMissing || c.Prerouting(Check, args...) != nil {
+			if err := c.Prerouting(Add, args...); err != nil {
+				return fmt.Errorf("Failed to inject docker in PREROUTING chain: %s", err)
+			}
+		}
+	}
+	for _, iface := range c.Interfaces {
+		args := []string{"-i", iface, "-m", "addrtype", "--dst-type", "LOCAL"}
+		if !onlyMissing || c.Prerouting(Check, args...) != nil {
+			if err := c.Prerouting(Add, args...); err != nil {
+				return fmt.Errorf("Failed to inject docker in PREROUTING chain for %s: %s", iface, err)
+			}
+		}
+	}
+	args := []string{"-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", LoopbackCidr(c.Ipv6)}
+	if !onlyMissing || c.Output(Check, args...) != nil {
+		if err := c.Output(Add, args...); err != nil {
+			return fmt.Errorf("Failed to inject docker in OUTPUT chain: %s", err)
+		}
+	}
+	return nil
+}
+
+// Exists returns whether the chain is set up, with the local traffic
+// jumping to it. The chain disappears along with its rules when the firewall
+// is flushed by someone else.
+func (c *Chain) Exists() bool {
+	return c.Output(Check, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", LoopbackCidr(c.Ipv6)) == nil
+}
+
 func RemoveExistingChain(ipv6 bool, name string) error {
+	return defaultContext.RemoveExistingChain(ipv6, name)
+}
+
+// RemoveExistingChain removes the chain as the package function does, with
+// ctx.
+func (ctx *Context) RemoveExistingChain(ipv6 bool, name string) error {
 	chain := &Chain{
 		Ipv6: ipv6,
 		Name: name,
+		ctx:  ctx,
 	}
 	return chain.Remove()
 }
 
 func (c *Chain) Forward(action Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error {
+	for _, args := range c.forwardRules(action, ip, port, proto, dest_addr, dest_port) {
+		if output, err := c.context().Raw(c.Ipv6, args...); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return fmt.Errorf("Error iptables forward: %s", output)
+		}
+	}
+	return nil
+}
+
+// forwardRules returns the arguments of the iptables commands of Forward.
+func (c *Chain) forwardRules(action Action, ip net.IP, port int, proto, dest_addr string, dest_port int) [][]string {
 	daddr := ip.String()
 	if ip.IsUnspecified() {
 		// iptables interprets "0.0.0.0" as "0.0.0.0/32", whereas we
@@ -73,35 +231,77 @@ func (c *Chain) Forward(action Action, ip net.IP, port int, proto, dest_addr str
 		// value" by both iptables and ip6tables.
 		daddr = "0/0"
 	}
-	if output, err := Raw(c.Ipv6, "-t", "nat", fmt.Sprint(action), c.Name,
+	rules := [][]string{{"-t", "nat", fmt.Sprint(action), c.Name,
 		"-p", proto,
 		"-d", daddr,
 		"--dport", strconv.Itoa(port),
 		"!", "-i", c.Bridge,
 		"-j", "DNAT",
-		"--to-destination", net.JoinHostPort(dest_addr, strconv.Itoa(dest_port))); err != nil {
-		return err
-	} else if len(output) != 0 {
-		return fmt.Errorf("Error iptables forward: %s", output)
+		"--to-destination", net.JoinHostPort(dest_addr, strconv.Itoa(dest_port))}}
+
+	// The local connections to the loopback go to the userland proxy, but
+	// for those to a loopback alias, which have a jump of their own
+	if IsLoopbackAlias(ip) {
+		rules = append(rules, []string{"-t", "nat", fmt.Sprint(action), "OUTPUT",
+			"-p", proto,
+			"-d", daddr,
+			"--dport", strconv.Itoa(port),
+			"-j", c.Name})
 	}
 
 	fAction := action
 	if fAction == Add {
 		fAction = "-I"
 	}
-	if output, err := Raw(c.Ipv6, string(fAction), "FORWARD",
+	return append(rules, []string{string(fAction), "FORWARD",
 		"!", "-i", c.Bridge,
 		"-o", c.Bridge,
 		"-p", proto,
 		"-d", dest_addr,
 		"--dport", strconv.Itoa(dest_port),
-		"-j", "ACCEPT"); err != nil {
-		return err
-	} else if len(output) != 0 {
-		return fmt.Errorf("Error iptables forward: %s", output)
-	}
+		"-j", "ACCEPT"})
+}
 
-	return nil
+// NoTrack exempts the connections of a port mapping from connection tracking
+// in the raw table. Untracked traffic can't be NATed, so there is no direct
+// forwarding to the container: it is served by the userland proxy, both the
+// client and the container legs of the proxied connection being exempted.
+func (c *Chain) NoTrack(action Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error {
+	daddr := ip.String()
+	if ip.IsUnspecified() {
+		daddr = "0/0"
+	}
+	// Bound to all the addresses, the proxy holds the port on each of them
+	// and no other socket of the host sends from it
+	replies := []string{"OUTPUT", "-p", proto}
+	if !ip.IsUnspecified() {
+		replies = append(replies, "-s", daddr)
+	}
+	var firstErr error
+	for _, args := range [][]string{
+		// client -> proxy
+		{"PREROUTING", "-p", proto, "-d", daddr, "--dport", strconv.Itoa(port), "-m", "addrtype", "--dst-type", "LOCAL"},
+		// proxy -> client
+		append(replies, "--sport", strconv.Itoa(port)),
+		// proxy -> container
+		{"OUTPUT", "-p", proto, "-d", dest_addr, "--dport", strconv.Itoa(dest_port)},
+		// container -> proxy
+		{"PREROUTING", "-i", c.Bridge, "-p", proto, "-s", dest_addr, "--sport", strconv.Itoa(dest_port)},
+	} {
+		a := append([]string{"-t", "raw", string(action)}, args...)
+		output, err := c.context().Raw(c.Ipv6, append(a, "-j", "NOTRACK")...)
+		if err == nil && len(output) != 0 {
+			err = fmt.Errorf("Error iptables notrack: %s", output)
+		}
+		if err != nil && action != Delete {
+			return err
+		}
+		// Keep deleting the other rules, some may have been set up
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func (c *Chain) Prerouting(action Action, args ...string) error {
@@ -109,7 +309,7 @@ func (c *Chain) Prerouting(action Action, args ...string) error {
 	if len(args) > 0 {
 		a = append(a, args...)
 	}
-	if output, err := Raw(c.Ipv6, append(a, "-j", c.Name)...); err != nil {
+	if output, err := c.context().Raw(c.Ipv6, append(a, "-j", c.Name)...); err != nil {
 		return err
 	} else if len(output) != 0 {
 		return fmt.Errorf("Error iptables prerouting: %s", output)
@@ -122,7 +322,7 @@ func (c *Chain) Output(action Action, args ...string) error {
 	if len(args) > 0 {
 		a = append(a, args...)
 	}
-	if output, err := Raw(c.Ipv6, append(a, "-j", c.Name)...); err != nil {
+	if output, err := c.context().Raw(c.Ipv6, append(a, "-j", c.Name)...); err != nil {
 		return err
 	} else if len(output) != 0 {
 		return fmt.Errorf("Error iptables output: %s", output)
@@ -130,6 +330,13 @@ func (c *Chain) Output(action Action, args ...string) error {
 	return nil
 }
 
+// IsLoopbackAlias tells whether ip is a loopback address other than
+// 127.0.0.1, such as 127.10.0.2, for the ports of several containers to be
+// published locally on the same port.
+func IsLoopbackAlias(ip net.IP) bool {
+	return ip.To4() != nil && ip.IsLoopback() && !ip.Equal(net.IPv4(127, 0, 0, 1))
+}
+
 func LoopbackCidr(ipv6 bool) string {
 	if ipv6 {
 		return "::1/128"
@@ -140,6 +347,8 @@ func LoopbackCidr(ipv6 bool) string {
 
 func (c *Chain) Remove() error {
 	// Ignore errors - This could mean the chains were never set up
+	c.removeJumps("PREROUTING") // Catches the per interface jumps of previous runs
+	c.removeJumps("OUTPUT")     // Catches the jumps of the loopback aliases
 	c.Prerouting(Delete, "-m", "addrtype", "--dst-type", "LOCAL")
 	c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", LoopbackCidr(c.Ipv6))
 	c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL") // Created in versions <= 0.1.6
@@ -147,26 +356,63 @@ func (c *Chain) Remove() error {
 	c.Prerouting(Delete)
 	c.Output(Delete)
 
-	Raw(c.Ipv6, "-t", "nat", "-F", c.Name)
-	Raw(c.Ipv6, "-t", "nat", "-X", c.Name)
+	c.context().Raw(c.Ipv6, "-t", "nat", "-F", c.Name)
+	c.context().Raw(c.Ipv6, "-t", "nat", "-X", c.Name)
 
 	return nil
 }
 
+// removeJumps deletes every rule of the given nat chain that jumps to c.
+func (c *Chain) removeJumps(from string) {
+	c.context().DeleteMatching(c.Ipv6, "nat", from, func(rule []string) bool {
+		return len(rule) > 2 && rule[len(rule)-2] == "-j" && rule[len(rule)-1] == c.Name
+	})
+}
+
+// DeleteMatching deletes the rules of a chain for which match returns true.
+// Rules are handed to match in the `iptables -S` format, without the leading
+// "-A <chain>".
+func DeleteMatching(ipv6 bool, table, chain string, match func(rule []string) bool) error {
+	return defaultContext.DeleteMatching(ipv6, table, chain, match)
+}
+
+// DeleteMatching deletes the rules as the package function does, with ctx.
+func (ctx *Context) DeleteMatching(ipv6 bool, table, chain string, match func(rule []string) bool) error {
+	output, err := ctx.Raw(ipv6, "-t", table, "-S", chain)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != chain || !match(fields[2:]) {
+			continue
+		}
+		if _, err := ctx.Raw(ipv6, append([]string{"-t", table, "-D"}, fields[1:]...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Check if an existing rule exists
 func Exists(ipv6 bool, args ...string) bool {
+	return defaultContext.Exists(ipv6, args...)
+}
+
+// Exists checks the rule as the package function does, with ctx.
+func (ctx *Context) Exists(ipv6 bool, args ...string) bool {
 	// iptables -C, --check option was added in v.1.4.11
 	// http://ftp.netfilter.org/pub/iptables/changes-iptables-1.4.11.txt
 
 	// try -C
 	// if exit status is 0 then return true, the rule exists
-	if _, err := Raw(ipv6, append([]string{"-C"}, args...)...); err == nil {
+	if _, err := ctx.Raw(ipv6, append([]string{"-C"}, args...)...); err == nil {
 		return true
 	}
 
 	// parse iptables-save for the rule
 	rule := strings.Replace(strings.Join(args, " "), "-t nat ", "", -1)
-	existingRules, _ := exec.Command("iptables-save").Output()
+	existingRules, _ := ctx.run("iptables-save")
 
 	// regex to replace ips in rule
 	// because MASQUERADE rule will not be exactly what was passed
@@ -178,26 +424,273 @@ func Exists(ipv6 bool, args ...string) bool {
 	)
 }
 
+// Stats returns how many times iptables was run, how many of these runs
+// failed, and the total time spent running it.
+func Stats() (calls, failures uint64, duration time.Duration) {
+	stats.Lock()
+	defer stats.Unlock()
+	return stats.calls, stats.failures, stats.duration
+}
+
+// SetRecorder registers a function told about every iptables run, such as
+// to keep a journal of the changes made to the firewall.
+func SetRecorder(f func(cmd string, args []string, err error)) {
+	defaultContext.SetRecorder(f)
+}
+
+// SetRecorder registers the recorder of the commands run with ctx.
+func (ctx *Context) SetRecorder(f func(cmd string, args []string, err error)) {
+	ctx.recorder = f
+}
+
+// SetDryRun keeps Raw from changing the firewall: the changes are only
+// reported to the recorder, while listings and checks still run.
+func SetDryRun(enabled bool) {
+	defaultContext.SetDryRun(enabled)
+}
+
+// SetDryRun keeps the commands run with ctx from changing the firewall.
+func (ctx *Context) SetDryRun(enabled bool) {
+	ctx.dryRun = enabled
+}
+
+// isChange returns whether an iptables command changes the firewall.
+func isChange(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "-A", "-C", "-D", "-I", "-R", "-F", "-Z", "-N", "-X", "-P", "-E",
+			"--append", "--check", "--delete", "--insert", "--replace", "--flush", "--zero",
+			"--new-chain", "--delete-chain", "--policy", "--rename-chain":
+			return arg != "-C" && arg != "--check"
+		}
+	}
+	return false
+}
+
+// SetCommenter makes the rules added, checked or deleted carry the comment f
+// returns for their arguments, such as the owner of the rule. f must return
+// the same comment when a rule is deleted as when it was added. Rules with a
+// comment given already and rules getting an empty comment are left alone.
+func SetCommenter(f func(args []string) string) {
+	defaultContext.SetCommenter(f)
+}
+
+// SetCommenter makes the rules changed with ctx carry the comments of f.
+func (ctx *Context) SetCommenter(f func(args []string) string) {
+	if f != nil && !ctx.supportsComments {
+		ctx.supportsComments = ctx.probeComments()
+		if !ctx.supportsComments {
+			log.Infof("The iptables comment match is not supported, the rules won't be commented")
+		}
+	}
+	ctx.commenter = f
+}
+
+// probeComments returns whether iptables supports the comment match, in
+// which case checking a missing rule fails with the status 1 rather than 2.
+func (ctx *Context) probeComments() bool {
+	output, err := ctx.run("iptables", "-C", "OUTPUT", "-m", "comment", "--comment", "docker-probe", "-j", "RETURN")
+	if err == nil {
+		return true
+	}
+	if ctx.runner != nil {
+		// The exit status is lost on the way back
+		return !strings.Contains(string(output), "Couldn't load match")
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus() == 1
+		}
+	}
+	return false
+}
+
+// withComment adds the comment of the commenter to a rule being added,
+// checked or deleted, ahead of its target.
+func (ctx *Context) withComment(args []string) []string {
+	if ctx.commenter == nil || !ctx.supportsComments {
+		return args
+	}
+	action := -1
+	for i, arg := range args {
+		switch arg {
+		case "--comment":
+			return args
+		case "-A", "-I", "-D", "-C", "-R", "--append", "--insert", "--delete", "--check", "--replace":
+			if action == -1 {
+				action = i
+			}
+		}
+	}
+	// A rule deleted by number has no specification
+	if action == -1 || (len(args) == action+3 && isRuleNumber(args[action+2])) {
+		return args
+	}
+	comment := ctx.commenter(args)
+	if comment == "" {
+		return args
+	}
+
+	target := len(args)
+	for i := action + 2; i < len(args); i++ {
+		if args[i] == "-j" || args[i] == "--jump" || args[i] == "-g" || args[i] == "--goto" {
+			target = i
+			break
+		}
+	}
+	out := make([]string, 0, len(args)+4)
+	out = append(out, args[:target]...)
+	out = append(out, "-m", "comment", "--comment", comment)
+	return append(out, args[target:]...)
+}
+
+// SetDeleteCheck makes the rules only be deleted when f returns true for
+// their arguments, such as when the rule was added by the daemon. The rules
+// deleted by number are not checked.
+func SetDeleteCheck(f func(args []string) bool) {
+	defaultContext.SetDeleteCheck(f)
+}
+
+// SetDeleteCheck makes the rules deleted with ctx be checked with f first.
+func (ctx *Context) SetDeleteCheck(f func(args []string) bool) {
+	ctx.deleteCheck = f
+}
+
+// isRuleDeletion returns whether args delete a rule by its specification.
+func isRuleDeletion(args []string) bool {
+	for i, arg := range args {
+		if arg == "-D" || arg == "--delete" {
+			return !(len(args) == i+3 && isRuleNumber(args[i+2]))
+		}
+	}
+	return false
+}
+
+func isRuleNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// SetTimeout bounds the time an iptables command can take, waiting for the
+// xtables lock included. With 0 the commands take as long as they need.
+func SetTimeout(d time.Duration) {
+	defaultContext.SetTimeout(d)
+}
+
+// SetTimeout bounds the time the commands run with ctx can take.
+func (ctx *Context) SetTimeout(d time.Duration) {
+	ctx.timeout = d
+}
+
+// combinedOutput runs cmd as cmd.CombinedOutput does, killing it if it runs
+// for longer than timeout.
+func combinedOutput(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	if timeout == 0 {
+		return cmd.CombinedOutput()
+	}
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return b.Bytes(), err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		return b.Bytes(), fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// SetRunner makes f run the iptables commands in place of Raw, such as a
+// privileged helper letting the daemon run without CAP_NET_ADMIN. nil runs
+// them again.
+func SetRunner(f func(cmd string, args []string) ([]byte, error)) {
+	defaultContext.SetRunner(f)
+}
+
+// SetRunner makes f run the commands of ctx, or nil runs them again.
+func (ctx *Context) SetRunner(f func(cmd string, args []string) ([]byte, error)) {
+	ctx.runner = f
+}
+
+// run runs an iptables command, with the runner if there is one.
+func (ctx *Context) run(cmd string, args ...string) ([]byte, error) {
+	if ctx.runner != nil {
+		return ctx.runner(cmd, args)
+	}
+	return exec.Command(cmd, args...).CombinedOutput()
+}
+
+// runTimed runs an iptables command as run does, killing it past the
+// timeout when it is executed.
+func (ctx *Context) runTimed(cmd string, args []string) ([]byte, error) {
+	if ctx.runner != nil {
+		log.Debugf("%s, %v", cmd, args)
+		return ctx.runner(cmd, args)
+	}
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		return nil, ErrIptablesNotFound
+	}
+	log.Debugf("%s, %v", path, args)
+	return combinedOutput(exec.Command(path, args...), ctx.timeout)
+}
+
 func Raw(ipv6 bool, args ...string) ([]byte, error) {
-	var cmd string
+	return defaultContext.Raw(ipv6, args...)
+}
+
+// Raw runs iptables as the package function does, with ctx.
+func (ctx *Context) Raw(ipv6 bool, args ...string) ([]byte, error) {
+	var (
+		cmd  string
+		orig []string
+	)
 	if ipv6 {
 		cmd = "ip6tables"
 	} else {
 		cmd = "iptables"
 	}
-
-	path, err := exec.LookPath(cmd)
-	if err != nil {
-		return nil, ErrIptablesNotFound
+	if ctx.deleteCheck != nil && isRuleDeletion(args) && !ctx.deleteCheck(args) {
+		log.Infof("Not deleting the rule %s %s, it wasn't added by docker", cmd, strings.Join(args, " "))
+		return []byte{}, nil
+	}
+	orig = ctx.withComment(args)
+	args = orig
+
+	if ctx.dryRun && isChange(args) {
+		log.Debugf("Dry run, not running %s %v", cmd, args)
+		if ctx.recorder != nil {
+			ctx.recorder(cmd, orig, nil)
+		}
+		return []byte{}, nil
 	}
 
 	if supportsXlock {
 		args = append([]string{"--wait"}, args...)
 	}
 
-	log.Debugf("%s, %v", path, args)
-
-	output, err := exec.Command(path, args...).CombinedOutput()
+	start := time.Now()
+	output, err := ctx.runTimed(cmd, args)
+	if err == ErrIptablesNotFound {
+		return nil, err
+	}
+	stats.Lock()
+	stats.calls++
+	stats.duration += time.Since(start)
+	if err != nil {
+		stats.failures++
+	}
+	stats.Unlock()
+	if ctx.recorder != nil {
+		ctx.recorder(cmd, orig, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("%v failed: %v %v: %s (%s)", cmd, cmd, strings.Join(args, " "), output, err)
 	}
@@ -0,0 +1,144 @@
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Forwarding is a host port forwarded to a container by Chain.Forward.
+type Forwarding struct {
+	IP       net.IP
+	Port     int
+	Proto    string
+	DestAddr string
+	DestPort int
+}
+
+// ForwardAll adds the rules of all the forwardings at once, as Forward
+// would one by one. The rules in place already on a chain adopted from a
+// previous run are not added twice.
+func (c *Chain) ForwardAll(forwardings []Forwarding) error {
+	var rules [][]string
+	for _, f := range forwardings {
+		if c.adopted && c.Forward(Check, f.IP, f.Port, f.Proto, f.DestAddr, f.DestPort) == nil {
+			continue
+		}
+		rules = append(rules, c.forwardRules(Add, f.IP, f.Port, f.Proto, f.DestAddr, f.DestPort)...)
+	}
+	return c.context().Restore(c.Ipv6, rules)
+}
+
+// Restore adds the rules with a single iptables-restore, which adds them
+// all or none, rather than with an iptables command each. Each rule is
+// given as to Raw, appending or inserting it in the filter table unless it
+// has "-t TABLE". The rules are added one by one with Raw when the commands
+// go to a runner, in dry run, and when iptables-restore fails.
+func Restore(ipv6 bool, rules [][]string) error {
+	return defaultContext.Restore(ipv6, rules)
+}
+
+// Restore adds the rules as the package function does, with ctx.
+func (ctx *Context) Restore(ipv6 bool, rules [][]string) error {
+	if len(rules) < 2 || ctx.runner != nil || ctx.dryRun {
+		return ctx.rawAll(ipv6, rules)
+	}
+	cmd, iptablesCmd := "iptables-restore", "iptables"
+	if ipv6 {
+		cmd, iptablesCmd = "ip6tables-restore", "ip6tables"
+	}
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		return ctx.rawAll(ipv6, rules)
+	}
+
+	commented := make([][]string, len(rules))
+	for i, args := range rules {
+		commented[i] = ctx.withComment(args)
+	}
+	restore := exec.Command(path, "--noflush")
+	restore.Stdin = strings.NewReader(restoreInput(commented))
+	log.Debugf("%s, %d rules", path, len(rules))
+
+	start := time.Now()
+	output, err := combinedOutput(restore, ctx.timeout)
+	stats.Lock()
+	stats.calls++
+	stats.duration += time.Since(start)
+	if err != nil {
+		stats.failures++
+	}
+	stats.Unlock()
+	if err != nil {
+		log.Debugf("%s failed, adding the rules one by one: %s (%s)", cmd, output, err)
+		return ctx.rawAll(ipv6, rules)
+	}
+	if ctx.recorder != nil {
+		for _, args := range commented {
+			ctx.recorder(iptablesCmd, args, nil)
+		}
+	}
+	return nil
+}
+
+// rawAll runs the iptables commands one by one, up to the first failing.
+func (ctx *Context) rawAll(ipv6 bool, rules [][]string) error {
+	for _, args := range rules {
+		if output, err := ctx.Raw(ipv6, args...); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return fmt.Errorf("Error iptables restore: %s", output)
+		}
+	}
+	return nil
+}
+
+// restoreInput returns the input of iptables-restore adding the rules,
+// grouped by table in the order they come.
+func restoreInput(rules [][]string) string {
+	var (
+		tables  []string
+		byTable = make(map[string][]string)
+	)
+	for _, args := range rules {
+		table := "filter"
+		line := make([]string, 0, len(args))
+		for i := 0; i < len(args); i++ {
+			if args[i] == "-t" && i+1 < len(args) {
+				table = args[i+1]
+				i++
+				continue
+			}
+			line = append(line, quoteRestoreArg(args[i]))
+		}
+		if _, exists := byTable[table]; !exists {
+			tables = append(tables, table)
+		}
+		byTable[table] = append(byTable[table], strings.Join(line, " "))
+	}
+
+	var b bytes.Buffer
+	for _, table := range tables {
+		fmt.Fprintf(&b, "*%s\n", table)
+		for _, line := range byTable[table] {
+			fmt.Fprintln(&b, line)
+		}
+		fmt.Fprintln(&b, "COMMIT")
+	}
+	return b.String()
+}
+
+// quoteRestoreArg quotes an argument iptables-restore would split, such as
+// a comment with spaces.
+func quoteRestoreArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'") {
+		return arg
+	}
+	return strconv.Quote(arg)
+}
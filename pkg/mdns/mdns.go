@@ -0,0 +1,269 @@
+// Package mdns advertises services on the local network with multicast DNS
+// and DNS Service Discovery (RFC 6762 and RFC 6763), for them to be found by
+// the Bonjour and Avahi browsers. It answers for its services and for the
+// name of the host they are on, the other names of the host being left to
+// the responder of the host, if any.
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// TTL of the records, the unique ones being announced again before
+	// they expire by the queries they answer
+	recordTTL = 120
+
+	servicesName = "_services._dns-sd._udp.local."
+)
+
+var (
+	groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+	// Delay between the two announcements of a service
+	announceDelay = time.Second
+)
+
+// Service is a service of the host advertised on the local network.
+type Service struct {
+	Instance string   // name shown to the users, ex: "web"
+	Type     string   // service type, ex: "_http._tcp"
+	Port     int      // port of the host
+	Text     []string // key=value pairs of the TXT record
+}
+
+func (s *Service) typeName() string {
+	return s.Type + ".local."
+}
+
+func (s *Service) instanceName() string {
+	return s.Instance + "." + s.typeName()
+}
+
+// Responder answers the queries for its services on the local network of
+// an interface.
+type Responder struct {
+	host  string // name of the host, ex: "myhost.local."
+	addrs []net.IP
+	conn  *net.UDPConn
+
+	sync.Mutex
+	services map[string]*Service // by lowercase instance name
+	closed   bool
+}
+
+// NewResponder answers the queries on the local network of iface, giving
+// the IPv4 addresses of iface as those of the services.
+func NewResponder(iface *net.Interface) (*Responder, error) {
+	addrs, err := ifaceIPv4s(iface)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", iface, groupAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen for mDNS queries on %s: %s", iface.Name, err)
+	}
+	r := newResponder(conn, strings.Split(hostname, ".")[0]+".local.", addrs)
+	go r.serve()
+	return r, nil
+}
+
+func newResponder(conn *net.UDPConn, host string, addrs []net.IP) *Responder {
+	return &Responder{
+		host:     host,
+		addrs:    addrs,
+		conn:     conn,
+		services: make(map[string]*Service),
+	}
+}
+
+func ifaceIPv4s(iface *net.Interface) ([]net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("Interface %s has no IPv4 address", iface.Name)
+	}
+	return ips, nil
+}
+
+// Addrs returns the addresses the services are reachable at.
+func (r *Responder) Addrs() []net.IP {
+	return r.addrs
+}
+
+// Register advertises s, in place of the service of the same instance and
+// type if any. The dots of the instance name are replaced with dashes.
+func (r *Responder) Register(s *Service) {
+	s.Instance = strings.Replace(s.Instance, ".", "-", -1)
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	r.services[strings.ToLower(s.instanceName())] = s
+	r.Unlock()
+
+	// Announced twice, in case the first announcement is lost
+	r.send(groupAddr, 0, nil, r.serviceRecords(s, recordTTL), r.hostRecords(recordTTL))
+	time.AfterFunc(announceDelay, func() {
+		r.Lock()
+		registered := r.services[strings.ToLower(s.instanceName())] == s
+		r.Unlock()
+		if registered {
+			r.send(groupAddr, 0, nil, r.serviceRecords(s, recordTTL), r.hostRecords(recordTTL))
+		}
+	})
+}
+
+// Unregister stops advertising the service of the given instance and type,
+// telling the browsers it is gone.
+func (r *Responder) Unregister(instance, serviceType string) {
+	s := &Service{Instance: strings.Replace(instance, ".", "-", -1), Type: serviceType}
+	r.Lock()
+	existing := r.services[strings.ToLower(s.instanceName())]
+	delete(r.services, strings.ToLower(s.instanceName()))
+	r.Unlock()
+	if existing != nil {
+		r.send(groupAddr, 0, nil, r.serviceRecords(existing, 0), nil)
+	}
+}
+
+// Close stops answering, telling the browsers the services are gone.
+func (r *Responder) Close() error {
+	r.Lock()
+	services := r.services
+	r.services = make(map[string]*Service)
+	r.closed = true
+	r.Unlock()
+	for _, s := range services {
+		r.send(groupAddr, 0, nil, r.serviceRecords(s, 0), nil)
+	}
+	return r.conn.Close()
+}
+
+// serviceRecords returns the PTR, SRV and TXT records of s.
+func (r *Responder) serviceRecords(s *Service, ttl uint32) []record {
+	return []record{
+		ptrRecord(s.typeName(), s.instanceName(), ttl),
+		srvRecord(s.instanceName(), r.host, s.Port, ttl),
+		txtRecord(s.instanceName(), s.Text, ttl),
+	}
+}
+
+func (r *Responder) hostRecords(ttl uint32) []record {
+	var records []record
+	for _, ip := range r.addrs {
+		records = append(records, aRecord(r.host, ip, ttl))
+	}
+	return records
+}
+
+func (r *Responder) send(addr *net.UDPAddr, id uint16, questions []question, answers, extra []record) {
+	if len(answers) == 0 {
+		return
+	}
+	if _, err := r.conn.WriteToUDP(packResponse(id, questions, answers, extra), addr); err != nil {
+		log.Debugf("Unable to send the mDNS answer to %s: %s", addr, err)
+	}
+}
+
+func (r *Responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		r.handle(buf[:n], from)
+	}
+}
+
+// handle answers a query. The queries from port 5353 are answered on the
+// multicast group, the others, from simple resolvers, to them directly.
+func (r *Responder) handle(msg []byte, from *net.UDPAddr) {
+	h, questions, err := parseQuery(msg)
+	if err != nil || h.Flags&flagResponse != 0 {
+		return
+	}
+	answers, extra := r.answer(questions)
+	if from.Port != groupAddr.Port {
+		r.send(from, h.ID, questions, answers, extra)
+		return
+	}
+	r.send(groupAddr, 0, nil, answers, extra)
+}
+
+// answer returns the answers to the questions, along with the additional
+// records the browsers need next.
+func (r *Responder) answer(questions []question) (answers, extra []record) {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.services) == 0 {
+		return nil, nil
+	}
+	hostAnswered := false
+	for _, q := range questions {
+		if q.Class&^unicast != classIN {
+			continue
+		}
+		switch {
+		case q.Name == servicesName && (q.Type == typePTR || q.Type == typeANY):
+			seen := make(map[string]bool)
+			for _, s := range r.services {
+				if !seen[strings.ToLower(s.typeName())] {
+					seen[strings.ToLower(s.typeName())] = true
+					answers = append(answers, ptrRecord(servicesName, s.typeName(), recordTTL))
+				}
+			}
+		case q.Name == strings.ToLower(r.host) && (q.Type == typeA || q.Type == typeANY):
+			if !hostAnswered {
+				hostAnswered = true
+				answers = append(answers, r.hostRecords(recordTTL)...)
+			}
+		case q.Type == typePTR || q.Type == typeANY:
+			for _, s := range r.services {
+				if strings.ToLower(s.typeName()) == q.Name {
+					records := r.serviceRecords(s, recordTTL)
+					answers = append(answers, records[0])
+					extra = append(extra, records[1:]...)
+				}
+			}
+			if s := r.services[q.Name]; s != nil && q.Type == typeANY {
+				answers = append(answers, r.serviceRecords(s, recordTTL)[1:]...)
+			}
+		case q.Type == typeSRV || q.Type == typeTXT:
+			if s := r.services[q.Name]; s != nil {
+				records := r.serviceRecords(s, recordTTL)
+				if q.Type == typeSRV {
+					answers = append(answers, records[1])
+				} else {
+					answers = append(answers, records[2])
+				}
+			}
+		}
+	}
+	if len(answers) > 0 && !hostAnswered {
+		extra = append(extra, r.hostRecords(recordTTL)...)
+	}
+	return answers, extra
+}
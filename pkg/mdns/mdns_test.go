@@ -0,0 +1,156 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func packQuery(id uint16, name string, qtype uint16) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	b = appendName(b, name)
+	return append(b, byte(qtype>>8), byte(qtype), 0, classIN)
+}
+
+// parseResponse returns the id and the records of a response.
+func parseResponse(t *testing.T, msg []byte) (uint16, []record) {
+	h, _, err := parseQuery(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Skip the questions
+	off := 12
+	for i := 0; i < int(h.QDCount); i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off = next + 4
+	}
+	var records []record
+	for i := 0; i < int(h.ANCount+h.NSCount+h.ARCount); i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := int(binary.BigEndian.Uint16(msg[next+8:]))
+		records = append(records, record{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(msg[next:]),
+			Class: binary.BigEndian.Uint16(msg[next+2:]),
+			TTL:   binary.BigEndian.Uint32(msg[next+4:]),
+			Data:  msg[next+10 : next+10+size],
+		})
+		off = next + 10 + size
+	}
+	return h.ID, records
+}
+
+func findRecord(records []record, name string, rtype uint16) *record {
+	for i, r := range records {
+		if r.Name == name && r.Type == rtype {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+func receive(t *testing.T, conn *net.UDPConn) []byte {
+	buf := make([]byte, 9000)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func listenLocal(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestResponder(t *testing.T) {
+	group := listenLocal(t)
+	defer group.Close()
+	defer func(addr *net.UDPAddr, delay time.Duration) { groupAddr, announceDelay = addr, delay }(groupAddr, announceDelay)
+	groupAddr = group.LocalAddr().(*net.UDPAddr)
+	announceDelay = time.Hour
+
+	r := newResponder(listenLocal(t), "myhost.local.", []net.IP{net.ParseIP("192.168.1.10").To4()})
+	defer r.Close()
+
+	r.Register(&Service{Instance: "web.app", Type: "_http._tcp", Port: 8080, Text: []string{"port=80/tcp"}})
+	_, records := parseResponse(t, receive(t, group))
+	ptr := findRecord(records, "_http._tcp.local.", typePTR)
+	if ptr == nil {
+		t.Fatalf("Expected the service to be announced, got %v", records)
+	}
+	if target, _, _ := readName(ptr.Data, 0); target != "web-app._http._tcp.local." {
+		t.Fatalf("Expected the instance web-app, got %s", target)
+	}
+
+	// A browser asking from another port is answered directly
+	client := listenLocal(t)
+	defer client.Close()
+	r.handle(packQuery(42, "_HTTP._tcp.local.", typePTR), client.LocalAddr().(*net.UDPAddr))
+	id, records := parseResponse(t, receive(t, client))
+	if id != 42 {
+		t.Fatalf("Expected the id of the query, got %d", id)
+	}
+	srv := findRecord(records, "web-app._http._tcp.local.", typeSRV)
+	if srv == nil || binary.BigEndian.Uint16(srv.Data[4:]) != 8080 {
+		t.Fatalf("Expected the port of the service, got %v", records)
+	}
+	if target, _, _ := readName(srv.Data, 6); target != "myhost.local." {
+		t.Fatalf("Expected the service on myhost.local., got %s", target)
+	}
+	txt := findRecord(records, "web-app._http._tcp.local.", typeTXT)
+	if txt == nil || string(txt.Data) != "\x0bport=80/tcp" {
+		t.Fatalf("Expected the text of the service, got %v", txt)
+	}
+	a := findRecord(records, "myhost.local.", typeA)
+	if a == nil || !net.IP(a.Data).Equal(net.ParseIP("192.168.1.10")) {
+		t.Fatalf("Expected the address of the host, got %v", records)
+	}
+
+	r.handle(packQuery(1, servicesName, typePTR), client.LocalAddr().(*net.UDPAddr))
+	_, records = parseResponse(t, receive(t, client))
+	if ptr := findRecord(records, servicesName, typePTR); ptr == nil {
+		t.Fatalf("Expected the service types to be listed, got %v", records)
+	}
+
+	// Gone
+	r.Unregister("web.app", "_http._tcp")
+	_, records = parseResponse(t, receive(t, group))
+	if ptr := findRecord(records, "_http._tcp.local.", typePTR); ptr == nil || ptr.TTL != 0 {
+		t.Fatalf("Expected the browsers to be told the service is gone, got %v", records)
+	}
+	if answers, _ := r.answer([]question{{Name: "_http._tcp.local.", Type: typePTR, Class: classIN}}); len(answers) != 0 {
+		t.Fatalf("Expected no answer once unregistered, got %v", answers)
+	}
+}
+
+func TestReadCompressedName(t *testing.T) {
+	msg := appendName(make([]byte, 12), "_http._tcp.local.")
+	// web, then a pointer to _http._tcp.local. at offset 12
+	msg = append(msg, 3, 'w', 'e', 'b', 0xc0, 12)
+	name, next, err := readName(msg, len(msg)-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "web._http._tcp.local." || next != len(msg) {
+		t.Fatalf("Unexpected name %s ending at %d", name, next)
+	}
+
+	// A loop
+	if _, _, err := readName([]byte{0xc0, 0}, 0); err == nil {
+		t.Fatal("Expected the compression loop to be refused")
+	}
+}
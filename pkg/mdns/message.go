@@ -0,0 +1,190 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// The parts of DNS messages (RFC 1035) an mDNS responder needs: the
+// questions it is asked, and the PTR, SRV, TXT and A records it answers.
+
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN    = 1
+	cacheFlush = 0x8000 // the record replaces those cached, for the unique records
+	unicast    = 0x8000 // the question asks for a unicast answer
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+)
+
+type header struct {
+	ID                                 uint16
+	Flags                              uint16
+	QDCount, ANCount, NSCount, ARCount uint16
+}
+
+type question struct {
+	Name  string // fully qualified, lowercase
+	Type  uint16
+	Class uint16
+}
+
+type record struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// readName reads the name at off in msg, following the compression
+// pointers, and returns it with the offset past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var (
+		labels []string
+		end    = -1 // past the name, once a pointer was followed
+		jumps  int
+	)
+	for {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("truncated name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			off++
+			if end < 0 {
+				end = off
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, fmt.Errorf("truncated name")
+			}
+			if jumps++; jumps > 10 {
+				return "", 0, fmt.Errorf("compression loop")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, fmt.Errorf("truncated label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// appendName appends name, uncompressed.
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// parseQuery returns the header and the questions of a message.
+func parseQuery(msg []byte) (*header, []question, error) {
+	if len(msg) < 12 {
+		return nil, nil, fmt.Errorf("short message")
+	}
+	h := &header{
+		ID:      binary.BigEndian.Uint16(msg[0:]),
+		Flags:   binary.BigEndian.Uint16(msg[2:]),
+		QDCount: binary.BigEndian.Uint16(msg[4:]),
+		ANCount: binary.BigEndian.Uint16(msg[6:]),
+		NSCount: binary.BigEndian.Uint16(msg[8:]),
+		ARCount: binary.BigEndian.Uint16(msg[10:]),
+	}
+	var (
+		questions []question
+		off       = 12
+	)
+	for i := 0; i < int(h.QDCount); i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, nil, fmt.Errorf("truncated question")
+		}
+		questions = append(questions, question{
+			Name:  strings.ToLower(name),
+			Type:  binary.BigEndian.Uint16(msg[next:]),
+			Class: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		off = next + 4
+	}
+	return h, questions, nil
+}
+
+// packResponse builds a response with the given questions, answers and
+// additional records.
+func packResponse(id uint16, questions []question, answers, extra []record) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], flagResponse|flagAuthoritative)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(extra)))
+	for _, q := range questions {
+		b = appendName(b, q.Name)
+		b = append(b, byte(q.Type>>8), byte(q.Type), byte(q.Class>>8), byte(q.Class))
+	}
+	for _, r := range append(answers, extra...) {
+		b = appendName(b, r.Name)
+		var fixed [10]byte
+		binary.BigEndian.PutUint16(fixed[0:], r.Type)
+		binary.BigEndian.PutUint16(fixed[2:], r.Class)
+		binary.BigEndian.PutUint32(fixed[4:], r.TTL)
+		binary.BigEndian.PutUint16(fixed[8:], uint16(len(r.Data)))
+		b = append(b, fixed[:]...)
+		b = append(b, r.Data...)
+	}
+	return b
+}
+
+func ptrRecord(name, target string, ttl uint32) record {
+	return record{Name: name, Type: typePTR, Class: classIN, TTL: ttl, Data: appendName(nil, target)}
+}
+
+func srvRecord(name, target string, port int, ttl uint32) record {
+	data := make([]byte, 6) // priority and weight 0
+	binary.BigEndian.PutUint16(data[4:], uint16(port))
+	return record{Name: name, Type: typeSRV, Class: classIN | cacheFlush, TTL: ttl, Data: appendName(data, target)}
+}
+
+func txtRecord(name string, text []string, ttl uint32) record {
+	var data []byte
+	for _, s := range text {
+		if len(s) > 255 {
+			s = s[:255]
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	if len(data) == 0 {
+		// A TXT record has at least an empty string
+		data = []byte{0}
+	}
+	return record{Name: name, Type: typeTXT, Class: classIN | cacheFlush, TTL: ttl, Data: data}
+}
+
+func aRecord(name string, ip net.IP, ttl uint32) record {
+	return record{Name: name, Type: typeA, Class: classIN | cacheFlush, TTL: ttl, Data: []byte(ip.To4())}
+}
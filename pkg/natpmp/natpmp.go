@@ -0,0 +1,183 @@
+// Package natpmp requests port forwardings from a NAT-PMP gateway, as
+// specified by RFC 6886 and found on many home routers.
+package natpmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Port is the port the gateways listen on.
+const Port = 5351
+
+const (
+	opMapUDP = 1
+	opMapTCP = 2
+)
+
+var resultErrors = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// Client talks to the gateway at addr. A request is sent again after
+// Timeout, doubled after each attempt, Attempts times in all.
+type Client struct {
+	addr     string
+	Timeout  time.Duration
+	Attempts int
+}
+
+// NewClient returns a client of the gateway at ip.
+func NewClient(ip net.IP) *Client {
+	return newClient(net.JoinHostPort(ip.String(), fmt.Sprint(Port)))
+}
+
+func newClient(addr string) *Client {
+	return &Client{addr: addr, Timeout: 250 * time.Millisecond, Attempts: 4}
+}
+
+func opcode(proto string) (byte, error) {
+	switch proto {
+	case "tcp":
+		return opMapTCP, nil
+	case "udp":
+		return opMapUDP, nil
+	}
+	return 0, fmt.Errorf("unsupported protocol %s", proto)
+}
+
+// AddPortMapping asks the gateway to forward its externalPort to the
+// internalPort of the host for lifetime, and returns the port the gateway
+// forwards, which can differ from the one asked for.
+func (c *Client) AddPortMapping(proto string, internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	op, err := opcode(proto)
+	if err != nil {
+		return 0, err
+	}
+	mapped, err := c.mapPort(op, internalPort, externalPort, uint32(lifetime/time.Second))
+	if err != nil {
+		return 0, fmt.Errorf("NAT-PMP mapping of %s/%d failed: %s", proto, internalPort, err)
+	}
+	return mapped, nil
+}
+
+// DeletePortMapping asks the gateway to stop forwarding to the internalPort
+// of the host.
+func (c *Client) DeletePortMapping(proto string, internalPort, externalPort int) error {
+	op, err := opcode(proto)
+	if err != nil {
+		return err
+	}
+	if _, err := c.mapPort(op, internalPort, 0, 0); err != nil {
+		return fmt.Errorf("NAT-PMP removal of the mapping of %s/%d failed: %s", proto, internalPort, err)
+	}
+	return nil
+}
+
+func (c *Client) mapPort(op byte, internalPort, externalPort int, lifetime uint32) (int, error) {
+	request := make([]byte, 12)
+	request[1] = op
+	binary.BigEndian.PutUint16(request[4:], uint16(internalPort))
+	binary.BigEndian.PutUint16(request[6:], uint16(externalPort))
+	binary.BigEndian.PutUint32(request[8:], lifetime)
+
+	response, err := c.call(request, 16)
+	if err != nil {
+		return 0, err
+	}
+	if int(binary.BigEndian.Uint16(response[8:])) != internalPort {
+		return 0, fmt.Errorf("the gateway answered for the port %d", binary.BigEndian.Uint16(response[8:]))
+	}
+	return int(binary.BigEndian.Uint16(response[10:])), nil
+}
+
+// call sends request until the gateway answers it, and returns the answer
+// once checked.
+func (c *Client) call(request []byte, size int) ([]byte, error) {
+	conn, err := net.Dial("udp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	response := make([]byte, 16)
+	timeout := c.Timeout
+	for i := 0; i < c.Attempts; i, timeout = i+1, timeout*2 {
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(response)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			// Answers to earlier requests are skipped
+			if n < 4 || response[0] != 0 || response[1] != request[1]|0x80 {
+				continue
+			}
+			if result := binary.BigEndian.Uint16(response[2:]); result != 0 {
+				if msg, exists := resultErrors[result]; exists {
+					return nil, fmt.Errorf("%s", msg)
+				}
+				return nil, fmt.Errorf("result code %d", result)
+			}
+			if n < size {
+				return nil, fmt.Errorf("short answer of %d bytes", n)
+			}
+			return response[:n], nil
+		}
+	}
+	return nil, fmt.Errorf("no answer from %s", c.addr)
+}
+
+// DefaultGateway returns the gateway of the default IPv4 route, the one
+// NAT-PMP is spoken with.
+func DefaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseDefaultGateway(f)
+}
+
+// parseDefaultGateway reads the gateway of the default route out of the
+// /proc/net/route table, whose addresses are in hexadecimal, in the byte
+// order of the host: little-endian, the daemon running on amd64.
+func parseDefaultGateway(r io.Reader) (net.IP, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[2])
+		if err != nil || len(gateway) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(gateway))
+		if !ip.IsUnspecified() {
+			return ip, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no default gateway")
+}
@@ -0,0 +1,109 @@
+package natpmp
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveGateway answers the mapping requests as a gateway forwarding the
+// external port asked for plus offset, or fails them with result.
+func serveGateway(t *testing.T, offset int, result uint16) (*Client, chan []byte) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan []byte, 10)
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 12)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := append([]byte{}, buf[:n]...)
+			requests <- request
+			response := make([]byte, 16)
+			response[1] = request[1] | 0x80
+			binary.BigEndian.PutUint16(response[2:], result)
+			copy(response[8:10], request[4:6])
+			external := binary.BigEndian.Uint16(request[6:])
+			if external != 0 {
+				external += uint16(offset)
+			}
+			binary.BigEndian.PutUint16(response[10:], external)
+			copy(response[12:], request[8:])
+			conn.WriteTo(response, addr)
+		}
+	}()
+	c := newClient(conn.LocalAddr().String())
+	c.Timeout = 50 * time.Millisecond
+	return c, requests
+}
+
+func TestAddPortMapping(t *testing.T) {
+	c, requests := serveGateway(t, 1, 0)
+
+	port, err := c.AddPortMapping("tcp", 8080, 80, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 81 {
+		t.Fatalf("Expected the port the gateway forwards, got %d", port)
+	}
+	request := <-requests
+	if request[1] != opMapTCP || binary.BigEndian.Uint16(request[4:]) != 8080 || binary.BigEndian.Uint32(request[8:]) != 3600 {
+		t.Fatalf("Unexpected request %v", request)
+	}
+
+	if err := c.DeletePortMapping("udp", 5353, 81); err != nil {
+		t.Fatal(err)
+	}
+	request = <-requests
+	if request[1] != opMapUDP || binary.BigEndian.Uint16(request[6:]) != 0 || binary.BigEndian.Uint32(request[8:]) != 0 {
+		t.Fatalf("Expected a removal request, got %v", request)
+	}
+}
+
+func TestAddPortMappingRefused(t *testing.T) {
+	c, _ := serveGateway(t, 0, 2)
+	if _, err := c.AddPortMapping("tcp", 8080, 8080, time.Hour); err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Fatalf("Expected the gateway to refuse the mapping, got %v", err)
+	}
+}
+
+func TestNoGateway(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := newClient(conn.LocalAddr().String())
+	c.Timeout = 10 * time.Millisecond
+	c.Attempts = 2
+	if _, err := c.AddPortMapping("tcp", 8080, 8080, time.Hour); err == nil {
+		t.Fatal("Expected no answer")
+	}
+}
+
+func TestParseDefaultGateway(t *testing.T) {
+	routes := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+docker0	000011AC	00000000	0001	0	0	0	0000FFFF	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	0	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+`
+	ip, err := parseDefaultGateway(strings.NewReader(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("192.168.1.1")) {
+		t.Fatalf("Expected the gateway 192.168.1.1, got %s", ip)
+	}
+
+	if _, err := parseDefaultGateway(strings.NewReader(strings.Split(routes, "eth0")[0])); err == nil {
+		t.Fatal("Expected no default gateway")
+	}
+}
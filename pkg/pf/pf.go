@@ -0,0 +1,183 @@
+// Package pf sets up the port mappings of the daemon with the pf firewall of
+// the BSDs. The rules are loaded in an anchor of their own, which pf.conf
+// has to call:
+//
+//	nat-anchor "docker"
+//	rdr-anchor "docker"
+//	anchor "docker"
+package pf
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// DefaultAnchor is the anchor of the rules of the daemon.
+const DefaultAnchor = "docker"
+
+// pfctl runs the pfctl command with the rules in stdin.
+var pfctl = func(stdin string, args ...string) ([]byte, error) {
+	cmd := exec.Command("pfctl", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	return cmd.CombinedOutput()
+}
+
+// rule is a rule of the anchor, found back in the rules loaded by the parts
+// pfctl lists them with.
+type rule struct {
+	text  string
+	parts []string
+}
+
+// Anchor holds the rules of the port mappings and of the masquerading of the
+// containers, the pf counterpart of an iptables chain. pf anchors can't be
+// changed a rule at a time, every change loads all the rules again.
+type Anchor struct {
+	Name   string
+	Bridge string // the mapped traffic comes in on the other interfaces
+
+	mu          sync.Mutex
+	translation []rule // nat and rdr rules
+	filter      []rule
+}
+
+func NewAnchor(name, bridge string) *Anchor {
+	return &Anchor{Name: name, Bridge: bridge}
+}
+
+// Forward adds, checks or deletes the rdr rule redirecting the traffic of a
+// host port to the container.
+func (a *Anchor) Forward(action iptables.Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error {
+	r := rule{
+		text: fmt.Sprintf("rdr pass%s proto %s from any to %s port %d -> %s port %d",
+			a.on(), proto, address(ip), port, dest_addr, dest_port),
+		parts: []string{"rdr", "proto " + proto, fmt.Sprintf("port = %d -> %s port %d", port, dest_addr, dest_port)},
+	}
+	return a.apply(action, &a.translation, r)
+}
+
+// NoTrack adds, checks or deletes the rules letting the traffic of a mapping
+// served by the userland proxy through without keeping its state.
+func (a *Anchor) NoTrack(action iptables.Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error {
+	r := rule{
+		text:  fmt.Sprintf("pass quick proto %s from any to %s port %d no state", proto, address(ip), port),
+		parts: []string{"pass quick", "proto " + proto, fmt.Sprintf("port = %d", port), "no state"},
+	}
+	return a.apply(action, &a.filter, r)
+}
+
+// Masquerade adds, checks or deletes the nat rule giving the address of the
+// external interface to the traffic of the containers of network leaving
+// the host.
+func (a *Anchor) Masquerade(action iptables.Action, network *net.IPNet, external string) error {
+	r := rule{
+		text:  fmt.Sprintf("nat on %s from %s to ! %s -> (%s)", external, network, network, external),
+		parts: []string{"nat on " + external, "from " + network.String()},
+	}
+	return a.apply(action, &a.translation, r)
+}
+
+// Flush removes the rules of the anchor.
+func (a *Anchor) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.translation, a.filter = nil, nil
+	if output, err := pfctl("", "-a", a.Name, "-F", "all"); err != nil {
+		return fmt.Errorf("Error flushing the pf anchor %s: %s (%s)", a.Name, output, err)
+	}
+	return nil
+}
+
+func (a *Anchor) apply(action iptables.Action, rules *[]rule, r rule) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	i := indexOf(*rules, r)
+	switch action {
+	case iptables.Add:
+		if i != -1 {
+			return nil
+		}
+		*rules = append(*rules, r)
+	case iptables.Delete:
+		if i == -1 {
+			return fmt.Errorf("No such rule in the pf anchor %s: %s", a.Name, r.text)
+		}
+		*rules = append((*rules)[:i], (*rules)[i+1:]...)
+	case iptables.Check:
+		if i == -1 {
+			return fmt.Errorf("No such rule in the pf anchor %s: %s", a.Name, r.text)
+		}
+		return a.check(r)
+	default:
+		return fmt.Errorf("Unsupported action %s", action)
+	}
+	return a.load()
+}
+
+// load loads the rules of the anchor, replacing those loaded.
+func (a *Anchor) load() error {
+	var lines []string
+	for _, r := range a.translation {
+		lines = append(lines, r.text)
+	}
+	for _, r := range a.filter {
+		lines = append(lines, r.text)
+	}
+	log.Debugf("pf anchor %s: %v", a.Name, lines)
+	if output, err := pfctl(strings.Join(lines, "\n")+"\n", "-a", a.Name, "-f", "-"); err != nil {
+		return fmt.Errorf("Error loading the pf anchor %s: %s (%s)", a.Name, output, err)
+	}
+	return nil
+}
+
+// check makes sure r is loaded, someone could have flushed the anchor.
+func (a *Anchor) check(r rule) error {
+	output, err := pfctl("", "-a", a.Name, "-s", "all")
+	if err != nil {
+		return fmt.Errorf("Error listing the pf anchor %s: %s (%s)", a.Name, output, err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		found := true
+		for _, part := range r.parts {
+			if !strings.Contains(line, part) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return nil
+		}
+	}
+	return fmt.Errorf("The rule isn't loaded in the pf anchor %s: %s", a.Name, r.text)
+}
+
+func (a *Anchor) on() string {
+	if a.Bridge == "" {
+		return ""
+	}
+	return " on ! " + a.Bridge
+}
+
+func address(ip net.IP) string {
+	if ip == nil || ip.IsUnspecified() {
+		return "any"
+	}
+	return ip.String()
+}
+
+func indexOf(rules []rule, r rule) int {
+	for i, o := range rules {
+		if o.text == r.text {
+			return i
+		}
+	}
+	return -1
+}
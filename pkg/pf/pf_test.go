@@ -0,0 +1,67 @@
+package pf
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/iptables"
+)
+
+// fakePfctl keeps the rules loaded in the anchors, and lists them the way
+// pfctl does.
+type fakePfctl struct {
+	loaded string
+}
+
+func (f *fakePfctl) run(stdin string, args ...string) ([]byte, error) {
+	switch args[2] {
+	case "-f":
+		f.loaded = stdin
+	case "-F":
+		f.loaded = ""
+	case "-s":
+		// pfctl lists the host ports with an operator
+		return []byte(strings.Replace(f.loaded, "port 8080", "port = 8080", -1)), nil
+	}
+	return nil, nil
+}
+
+func TestAnchor(t *testing.T) {
+	f := &fakePfctl{}
+	defer func(orig func(string, ...string) ([]byte, error)) { pfctl = orig }(pfctl)
+	pfctl = f.run
+
+	a := NewAnchor(DefaultAnchor, "docker0")
+	if err := a.Forward(iptables.Add, net.ParseIP("0.0.0.0"), 8080, "tcp", "172.17.0.2", 80); err != nil {
+		t.Fatal(err)
+	}
+	_, network, _ := net.ParseCIDR("172.17.0.0/16")
+	if err := a.Masquerade(iptables.Add, network, "em0"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "rdr pass on ! docker0 proto tcp from any to any port 8080 -> 172.17.0.2 port 80\n" +
+		"nat on em0 from 172.17.0.0/16 to ! 172.17.0.0/16 -> (em0)\n"
+	if f.loaded != expected {
+		t.Fatalf("Expected the rules\n%s, got\n%s", expected, f.loaded)
+	}
+	if err := a.Forward(iptables.Check, net.ParseIP("0.0.0.0"), 8080, "tcp", "172.17.0.2", 80); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone flushed the anchor
+	f.loaded = ""
+	if err := a.Forward(iptables.Check, net.ParseIP("0.0.0.0"), 8080, "tcp", "172.17.0.2", 80); err == nil {
+		t.Fatal("Expected the flushed rule to be missing")
+	}
+
+	if err := a.Forward(iptables.Delete, net.ParseIP("0.0.0.0"), 8080, "tcp", "172.17.0.2", 80); err != nil {
+		t.Fatal(err)
+	}
+	if f.loaded != "nat on em0 from 172.17.0.0/16 to ! 172.17.0.0/16 -> (em0)\n" {
+		t.Fatalf("Expected the rdr rule to be deleted, got\n%s", f.loaded)
+	}
+	if err := a.Forward(iptables.Delete, net.ParseIP("0.0.0.0"), 8080, "tcp", "172.17.0.2", 80); err == nil {
+		t.Fatal("Expected the deleted rule to be missing")
+	}
+}
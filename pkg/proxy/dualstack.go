@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"net"
+	"time"
+)
+
+// A backend can have an address of each family, such as a container with
+// an IPv4 address and an IPv6 one. The proxy dials the one which connected
+// last, and the other one too if it fails or takes longer than
+// fallbackDelay, the first to connect serving the connection, as Happy
+// Eyeballs (RFC 6555) does. The service gets reached when one of the
+// stacks of the backend is misconfigured.
+
+// fallbackDelay is how long the proxy waits for the preferred address
+// before dialing the other one too.
+const fallbackDelay = 300 * time.Millisecond
+
+// SetFallbackBackend has the proxy dial addr along with the backend
+// address, for the connections accepted from then on.
+func (proxy *TCPProxy) SetFallbackBackend(addr *net.TCPAddr) {
+	proxy.lock.Lock()
+	proxy.fallbackAddr = addr
+	proxy.lock.Unlock()
+}
+
+type dialResult struct {
+	conn *net.TCPConn
+	addr *net.TCPAddr
+	err  error
+}
+
+// dialBackend connects to the backend, at its backend address or at its
+// fallback one, whichever connects first.
+func (proxy *TCPProxy) dialBackend() (*net.TCPConn, error) {
+	proxy.lock.Lock()
+	primary, secondary := proxy.backendAddr, proxy.fallbackAddr
+	if proxy.preferFallback && secondary != nil {
+		primary, secondary = secondary, primary
+	}
+	proxy.lock.Unlock()
+	if secondary == nil {
+		return net.DialTCP("tcp", nil, primary)
+	}
+
+	results := make(chan dialResult, 2)
+	dial := func(addr *net.TCPAddr) {
+		conn, err := net.DialTCP("tcp", nil, addr)
+		results <- dialResult{conn, addr, err}
+	}
+	go dial(primary)
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+
+	var (
+		delay    = timer.C
+		pending  = 1
+		firstErr error
+	)
+	for {
+		select {
+		case <-delay:
+			delay = nil
+			pending++
+			go dial(secondary)
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proxy.lock.Lock()
+				proxy.preferFallback = r.addr == proxy.fallbackAddr
+				proxy.lock.Unlock()
+				if pending > 0 {
+					// The other address might connect still
+					go func() {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if delay != nil {
+				delay = nil
+				pending++
+				go dial(secondary)
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets of the
+// latency histograms.
+var LatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram is the distribution of durations: Buckets counts those up to
+// each of LatencyBuckets, and those above the last are only in Count.
+type Histogram struct {
+	Buckets []uint64
+	Count   uint64
+	Sum     float64 // seconds
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Buckets == nil {
+		h.Buckets = make([]uint64, len(LatencyBuckets))
+	}
+	seconds := d.Seconds()
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			h.Buckets[i]++
+			break
+		}
+	}
+	h.Count++
+	h.Sum += seconds
+}
+
+// Cumulative returns the number of durations up to each of LatencyBuckets.
+func (h Histogram) Cumulative() []uint64 {
+	out := make([]uint64, len(LatencyBuckets))
+	var total uint64
+	for i := range out {
+		if i < len(h.Buckets) {
+			total += h.Buckets[i]
+		}
+		out[i] = total
+	}
+	return out
+}
+
+// LatencyStats are the latency distributions of the connections of a TCP
+// proxy: Connect is the time to connect to the backend, FirstByte the time
+// from the connection accepted to the first byte of the backend.
+type LatencyStats struct {
+	Connect   Histogram
+	FirstByte Histogram
+}
+
+func (s LatencyStats) copy() LatencyStats {
+	s.Connect.Buckets = append([]uint64(nil), s.Connect.Buckets...)
+	s.FirstByte.Buckets = append([]uint64(nil), s.FirstByte.Buckets...)
+	return s
+}
+
+// latencies records the latency distributions of a proxy.
+type latencies struct {
+	sync.Mutex
+	stats LatencyStats
+}
+
+func (l *latencies) observeConnect(d time.Duration) {
+	l.Lock()
+	l.stats.Connect.observe(d)
+	l.Unlock()
+}
+
+func (l *latencies) observeFirstByte(d time.Duration) {
+	l.Lock()
+	l.stats.FirstByte.observe(d)
+	l.Unlock()
+}
+
+func (l *latencies) snapshot() LatencyStats {
+	l.Lock()
+	defer l.Unlock()
+	return l.stats.copy()
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for _, d := range []time.Duration{200 * time.Microsecond, 3 * time.Millisecond, 4 * time.Millisecond, time.Minute} {
+		h.observe(d)
+	}
+	if h.Count != 4 {
+		t.Fatalf("Expected 4 durations, got %d", h.Count)
+	}
+	cumulative := h.Cumulative()
+	// Up to 0.5ms, 1ms, 2.5ms and 5ms, the minute being above all the buckets
+	for i, expected := range []uint64{1, 1, 1, 3} {
+		if cumulative[i] != expected {
+			t.Fatalf("Expected %d durations up to %gs, got %d", expected, LatencyBuckets[i], cumulative[i])
+		}
+	}
+	if last := cumulative[len(cumulative)-1]; last != 3 {
+		t.Fatalf("Expected 3 durations in the buckets, got %d", last)
+	}
+	if h.Sum < 60 || h.Sum > 60.01 {
+		t.Fatalf("Unexpected sum %g", h.Sum)
+	}
+}
+
+func TestTCPProxyLatency(t *testing.T) {
+	backend := NewEchoServer(t, "tcp", "127.0.0.1:0")
+	defer backend.Close()
+	backend.Run()
+	frontendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	proxy, err := NewTCPProxy(frontendAddr, backend.LocalAddr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProxy(t, "tcp", proxy)
+
+	// The first byte is counted once it was written to the client
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := proxy.Latency()
+		if stats.Connect.Count == 1 && stats.FirstByte.Count == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the latency of one connection, got %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
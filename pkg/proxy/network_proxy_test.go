@@ -126,6 +126,60 @@ func TestTCP4Proxy(t *testing.T) {
 	testProxy(t, "tcp", proxy)
 }
 
+func TestTCPProxyReset(t *testing.T) {
+	backend := NewEchoServer(t, "tcp", "127.0.0.1:0")
+	defer backend.Close()
+	backend.Run()
+	proxy, err := NewTCPProxy(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}, backend.LocalAddr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Run()
+	client, err := net.Dial("tcp", proxy.FrontendAddr().String())
+	if err != nil {
+		t.Fatalf("Can't connect to the proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	// The connection is in flight once echoed
+	if _, err := client.Write(testBuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, testBufSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy.Reset()
+	if _, err := client.Read(make([]byte, 1)); err == nil || err == io.EOF || !strings.Contains(err.Error(), "reset") {
+		t.Fatalf("Expected the connection to be reset, got %v", err)
+	}
+	if _, err := net.Dial("tcp", proxy.FrontendAddr().String()); err == nil {
+		t.Fatal("Expected the proxy to stop accepting connections")
+	}
+}
+
+// The listener stands for a socket bound by systemd
+func TestTCPProxyFromFile(t *testing.T) {
+	backend := NewEchoServer(t, "tcp", "127.0.0.1:0")
+	defer backend.Close()
+	backend.Run()
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	f, err := listener.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	proxy, err := NewProxyFromFile(f, backend.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProxyAt(t, "tcp", proxy, listener.Addr().String())
+}
+
 func TestTCP6Proxy(t *testing.T) {
 	backend := NewEchoServer(t, "tcp", "[::1]:0")
 	defer backend.Close()
@@ -214,3 +268,28 @@ func TestUDPWriteError(t *testing.T) {
 		t.Fatal(fmt.Errorf("Expected [%v] but got [%v]", testBuf, recvBuf))
 	}
 }
+
+func TestTCPProxyFallback(t *testing.T) {
+	backend := NewEchoServer(t, "tcp", "127.0.0.1:0")
+	defer backend.Close()
+	backend.Run()
+
+	// The backend address refuses the connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusing := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	frontendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	proxy, err := NewTCPProxy(frontendAddr, refusing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.SetFallbackBackend(backend.LocalAddr().(*net.TCPAddr))
+	testProxy(t, "tcp", proxy)
+	if !proxy.preferFallback {
+		t.Fatal("Expected the fallback address to be dialed first from then on")
+	}
+}
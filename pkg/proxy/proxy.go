@@ -1,10 +1,16 @@
 package proxy
 
 import (
+	"expvar"
 	"fmt"
 	"net"
+	"os"
 )
 
+// counters are published by the process running the proxies, which is the
+// docker-proxy process for the port mappings of the daemon.
+var counters = expvar.NewMap("proxy")
+
 type Proxy interface {
 	// Start forwarding traffic back and forth the front and back-end
 	// addresses.
@@ -27,3 +33,34 @@ func NewProxy(frontendAddr, backendAddr net.Addr) (Proxy, error) {
 		panic(fmt.Errorf("Unsupported protocol"))
 	}
 }
+
+// NewProxyFromFile returns a proxy serving the socket f, bound to the
+// frontend address already, such as by systemd before the daemon started.
+func NewProxyFromFile(f *os.File, backendAddr net.Addr) (Proxy, error) {
+	switch backend := backendAddr.(type) {
+	case *net.UDPAddr:
+		conn, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, err
+		}
+		listener, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			return nil, fmt.Errorf("%s is not a UDP socket", f.Name())
+		}
+		return newUDPProxy(listener, backend), nil
+	case *net.TCPAddr:
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, err
+		}
+		listener, ok := l.(*net.TCPListener)
+		if !ok {
+			l.Close()
+			return nil, fmt.Errorf("%s is not a TCP listener", f.Name())
+		}
+		return newTCPProxy(listener, backend), nil
+	default:
+		return nil, fmt.Errorf("Unsupported protocol")
+	}
+}
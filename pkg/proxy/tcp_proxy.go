@@ -3,7 +3,9 @@ package proxy
 import (
 	"io"
 	"net"
+	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -12,6 +14,12 @@ type TCPProxy struct {
 	listener     *net.TCPListener
 	frontendAddr *net.TCPAddr
 	backendAddr  *net.TCPAddr
+
+	lock           sync.Mutex
+	clients        map[*net.TCPConn]bool // connections in flight, for Reset to abort them
+	fallbackAddr   *net.TCPAddr          // other address of the backend, of the other family, nil if none
+	preferFallback bool                  // the fallback address connected last
+	latency        latencies
 }
 
 func NewTCPProxy(frontendAddr, backendAddr *net.TCPAddr) (*TCPProxy, error) {
@@ -19,26 +27,48 @@ func NewTCPProxy(frontendAddr, backendAddr *net.TCPAddr) (*TCPProxy, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newTCPProxy(listener, backendAddr), nil
+}
+
+func newTCPProxy(listener *net.TCPListener, backendAddr *net.TCPAddr) *TCPProxy {
 	// If the port in frontendAddr was 0 then ListenTCP will have a picked
 	// a port to listen on, hence the call to Addr to get that actual port:
 	return &TCPProxy{
 		listener:     listener,
 		frontendAddr: listener.Addr().(*net.TCPAddr),
 		backendAddr:  backendAddr,
-	}, nil
+		clients:      make(map[*net.TCPConn]bool),
+	}
 }
 
 func (proxy *TCPProxy) clientLoop(client *net.TCPConn, quit chan bool) {
-	backend, err := net.DialTCP("tcp", nil, proxy.backendAddr)
+	accepted := time.Now()
+	backend, err := proxy.dialBackend()
 	if err != nil {
 		log.Printf("Can't forward traffic to backend tcp/%v: %s\n", proxy.backendAddr, err)
+		counters.Add("tcp_dial_failures", 1)
 		client.Close()
 		return
 	}
+	proxy.latency.observeConnect(time.Since(accepted))
 
 	event := make(chan int64)
 	var broker = func(to, from *net.TCPConn) {
-		written, err := io.Copy(to, from)
+		var (
+			written int64
+			err     error
+		)
+		if from == backend {
+			written, err = copyFirst(to, from)
+			if written > 0 {
+				proxy.latency.observeFirstByte(time.Since(accepted))
+			}
+		}
+		if err == nil {
+			var n int64
+			n, err = io.Copy(to, from)
+			written += n
+		}
 		if err != nil {
 			// If the socket we are writing to is shutdown with
 			// SHUT_WR, forward it to the other end of the pipe:
@@ -72,6 +102,22 @@ func (proxy *TCPProxy) clientLoop(client *net.TCPConn, quit chan bool) {
 	backend.Close()
 }
 
+// copyFirst copies the first bytes read from from, for the time they took
+// to come to be known. The rest goes through io.Copy, and its splice.
+func copyFirst(to io.Writer, from io.Reader) (int64, error) {
+	buf := make([]byte, 32*1024)
+	n, err := from.Read(buf)
+	if n > 0 {
+		if _, err := to.Write(buf[:n]); err != nil {
+			return 0, err
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return int64(n), err
+}
+
 func (proxy *TCPProxy) Run() {
 	quit := make(chan bool)
 	defer close(quit)
@@ -81,8 +127,40 @@ func (proxy *TCPProxy) Run() {
 			log.Printf("Stopping proxy on tcp/%v for tcp/%v (%s)", proxy.frontendAddr, proxy.backendAddr, err)
 			return
 		}
-		go proxy.clientLoop(client.(*net.TCPConn), quit)
+		proxy.track(client.(*net.TCPConn), true)
+		go func(client *net.TCPConn) {
+			defer proxy.track(client, false)
+			proxy.clientLoop(client, quit)
+		}(client.(*net.TCPConn))
+	}
+}
+
+func (proxy *TCPProxy) track(client *net.TCPConn, inFlight bool) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	if inFlight {
+		proxy.clients[client] = true
+	} else {
+		delete(proxy.clients, client)
+	}
+}
+
+// Reset stops accepting connections and aborts those in flight with a RST,
+// for their clients to fail fast rather than wait on a backend gone.
+func (proxy *TCPProxy) Reset() {
+	// Aborted before the listener closes, which closes them gracefully
+	proxy.lock.Lock()
+	for client := range proxy.clients {
+		client.SetLinger(0)
+		client.Close()
 	}
+	proxy.lock.Unlock()
+	proxy.listener.Close()
+}
+
+// Latency returns the latency distributions of the connections so far.
+func (proxy *TCPProxy) Latency() LatencyStats {
+	return proxy.latency.snapshot()
 }
 
 func (proxy *TCPProxy) Close()                 { proxy.listener.Close() }
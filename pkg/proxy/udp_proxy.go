@@ -54,12 +54,16 @@ func NewUDPProxy(frontendAddr, backendAddr *net.UDPAddr) (*UDPProxy, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newUDPProxy(listener, backendAddr), nil
+}
+
+func newUDPProxy(listener *net.UDPConn, backendAddr *net.UDPAddr) *UDPProxy {
 	return &UDPProxy{
 		listener:       listener,
 		frontendAddr:   listener.LocalAddr().(*net.UDPAddr),
 		backendAddr:    backendAddr,
 		connTrackTable: make(connTrackMap),
-	}, nil
+	}
 }
 
 func (proxy *UDPProxy) replyLoop(proxyConn *net.UDPConn, clientAddr *net.UDPAddr, clientKey *connTrackKey) {
@@ -117,6 +121,7 @@ func (proxy *UDPProxy) Run() {
 			proxyConn, err = net.DialUDP("udp", nil, proxy.backendAddr)
 			if err != nil {
 				log.Printf("Can't proxy a datagram to udp/%s: %s\n", proxy.backendAddr, err)
+				counters.Add("udp_dial_failures", 1)
 				proxy.connTrackLock.Unlock()
 				continue
 			}
@@ -2,22 +2,66 @@ package systemd
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// PortsFDName is the name of the sockets systemd binds for the published
+// ports of the containers, given with FileDescriptorName= in their socket
+// unit. They are handed to the port mappings rather than served by the api.
+const PortsFDName = "docker-ports"
 
-	"github.com/coreos/go-systemd/activation"
+const listenFdsStart = 3
+
+var (
+	portFiles     []*os.File
+	portFilesOnce sync.Once
 )
 
+// listenFDNames returns the names of the socket activated files, in the
+// order of their fds. The files systemd didn't name have empty names.
+func listenFDNames() []string {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	if len(names) != nfds {
+		names = make([]string, nfds)
+	}
+	return names
+}
+
 // ListenFD returns the specified socket activated files as a slice of
-// net.Listeners or all of the activated files if "*" is given.
+// net.Listeners or all of the activated files if "*" is given. The sockets
+// of the published ports are left out.
 func ListenFD(addr string) ([]net.Listener, error) {
 	// socket activation
-	listeners, err := activation.Listeners(false)
-	if err != nil {
-		return nil, err
+	names := listenFDNames()
+	listeners := make([]net.Listener, len(names))
+	found := 0
+	for i, name := range names {
+		if name == PortsFDName {
+			continue
+		}
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		if l, err := net.FileListener(os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))); err == nil {
+			listeners[i] = l
+			found++
+		}
 	}
 
-	if listeners == nil || len(listeners) == 0 {
+	if found == 0 {
 		return nil, errors.New("No sockets found")
 	}
 
@@ -26,15 +70,40 @@ func ListenFD(addr string) ([]net.Listener, error) {
 		addr = "*"
 	}
 
+	if addr == "*" {
+		all := make([]net.Listener, 0, found)
+		for _, l := range listeners {
+			if l != nil {
+				all = append(all, l)
+			}
+		}
+		return all, nil
+	}
+
 	fdNum, _ := strconv.Atoi(addr)
-	fdOffset := fdNum - 3
-	if (addr != "*") && (len(listeners) < int(fdOffset)+1) {
+	fdOffset := fdNum - listenFdsStart
+	if fdOffset < 0 || len(listeners) < fdOffset+1 {
 		return nil, errors.New("Too few socket activated files passed in")
 	}
-
-	if addr == "*" {
-		return listeners, nil
+	if listeners[fdOffset] == nil {
+		return nil, fmt.Errorf("The socket activated file %d is not a listener of the api", fdNum)
 	}
 
 	return []net.Listener{listeners[fdOffset]}, nil
 }
+
+// PortFiles returns the socket activated files named PortsFDName, bound by
+// systemd for the published ports before the daemon started.
+func PortFiles() []*os.File {
+	portFilesOnce.Do(func() {
+		for i, name := range listenFDNames() {
+			if name != PortsFDName {
+				continue
+			}
+			fd := listenFdsStart + i
+			syscall.CloseOnExec(fd)
+			portFiles = append(portFiles, os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd)))
+		}
+	})
+	return portFiles
+}
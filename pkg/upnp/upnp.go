@@ -0,0 +1,270 @@
+// Package upnp requests port forwardings from the Internet Gateway Device of
+// the local network, through the WANIPConnection or WANPPPConnection service
+// of UPnP IGD.
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr   = "239.255.255.250:1900"
+	searchType = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+
+	// Description of the port mappings on the gateway
+	mappingDescription = "docker"
+
+	// Error of the gateways which only support permanent mappings
+	errOnlyPermanentLeases = 725
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Client calls the connection service of a gateway.
+type Client struct {
+	controlURL  string
+	serviceType string
+	internalIP  net.IP // address of the host the ports are forwarded to
+}
+
+type service struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type device struct {
+	Services []service `xml:"serviceList>service"`
+	Devices  []device  `xml:"deviceList>device"`
+}
+
+type description struct {
+	URLBase string `xml:"URLBase"`
+	Device  device `xml:"device"`
+}
+
+// findService returns the first connection service of d or of its
+// embedded devices.
+func (d *device) findService() *service {
+	for i, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return &d.Services[i]
+		}
+	}
+	for i := range d.Devices {
+		if s := d.Devices[i].findService(); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// Discover searches the local network for a gateway with SSDP, for up to
+// timeout.
+func Discover(timeout time.Duration) (*Client, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: " + searchType + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), addr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("No UPnP gateway found: %s", err)
+		}
+		location, err := parseSearchResponse(buf[:n])
+		if err != nil {
+			continue
+		}
+		if c, err := NewClient(location); err == nil {
+			return c, nil
+		}
+	}
+}
+
+// parseSearchResponse returns the location of the description of the device
+// answering a search.
+func parseSearchResponse(data []byte) (string, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("no location in the answer")
+	}
+	return location, nil
+}
+
+// NewClient returns a client of the gateway described at location.
+func NewClient(location string) (*Client, error) {
+	resp, err := httpClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to get the description of the gateway %s: %s", location, resp.Status)
+	}
+	var desc description
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("Invalid description of the gateway %s: %s", location, err)
+	}
+	s := desc.Device.findService()
+	if s == nil {
+		return nil, fmt.Errorf("The gateway %s has no connection service", location)
+	}
+
+	base := location
+	if desc.URLBase != "" {
+		base = desc.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	controlURL, err := baseURL.Parse(s.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ports are forwarded to the address the host reaches the gateway from
+	host := controlURL.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return &Client{
+		controlURL:  controlURL.String(),
+		serviceType: s.ServiceType,
+		internalIP:  conn.LocalAddr().(*net.UDPAddr).IP,
+	}, nil
+}
+
+// soapError is the error of a failed action.
+type soapError struct {
+	Code        int    `xml:"Body>Fault>detail>UPnPError>errorCode"`
+	Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+}
+
+func (e *soapError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Description, e.Code)
+}
+
+// call runs an action of the connection service, with its arguments given
+// in order as alternating names and values.
+func (c *Client) call(action string, args ...string) error {
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%s xmlns:u="%s">`, action, c.serviceType)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(body, "<%s>", args[i])
+		xml.EscapeText(body, []byte(args[i+1]))
+		fmt.Fprintf(body, "</%s>", args[i])
+	}
+	fmt.Fprintf(body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequest("POST", c.controlURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, c.serviceType, action))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	soapErr := &soapError{}
+	if err := xml.NewDecoder(resp.Body).Decode(soapErr); err != nil || soapErr.Code == 0 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return soapErr
+}
+
+func protocol(proto string) (string, error) {
+	switch proto {
+	case "tcp", "udp":
+		return strings.ToUpper(proto), nil
+	}
+	return "", fmt.Errorf("unsupported protocol %s", proto)
+}
+
+// AddPortMapping asks the gateway to forward its externalPort to the
+// internalPort of the host for lifetime, for good if the gateway only
+// supports permanent mappings. It returns the port forwarded, which is the
+// one asked for.
+func (c *Client) AddPortMapping(proto string, internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	p, err := protocol(proto)
+	if err != nil {
+		return 0, err
+	}
+	add := func(lease int) error {
+		return c.call("AddPortMapping",
+			"NewRemoteHost", "",
+			"NewExternalPort", fmt.Sprint(externalPort),
+			"NewProtocol", p,
+			"NewInternalPort", fmt.Sprint(internalPort),
+			"NewInternalClient", c.internalIP.String(),
+			"NewEnabled", "1",
+			"NewPortMappingDescription", mappingDescription,
+			"NewLeaseDuration", fmt.Sprint(lease))
+	}
+	err = add(int(lifetime / time.Second))
+	if e, ok := err.(*soapError); ok && e.Code == errOnlyPermanentLeases {
+		err = add(0)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("UPnP mapping of %s/%d failed: %s", proto, externalPort, err)
+	}
+	return externalPort, nil
+}
+
+// DeletePortMapping asks the gateway to stop forwarding its externalPort.
+func (c *Client) DeletePortMapping(proto string, internalPort, externalPort int) error {
+	p, err := protocol(proto)
+	if err != nil {
+		return err
+	}
+	if err := c.call("DeletePortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", fmt.Sprint(externalPort),
+		"NewProtocol", p); err != nil {
+		return fmt.Errorf("UPnP removal of the mapping of %s/%d failed: %s", proto, externalPort, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package upnp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const gatewayDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+        <controlURL>/ctl/L3F</controlURL>
+      </service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const upnpError = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>
+<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail>
+</s:Fault></s:Body></s:Envelope>`
+
+// gateway is a fake gateway only supporting permanent mappings, keeping the
+// actions it was called with.
+type gateway struct {
+	actions []string
+	bodies  []string
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/rootDesc.xml":
+		fmt.Fprint(w, gatewayDescription)
+	case "/ctl/IPConn":
+		body, _ := ioutil.ReadAll(r.Body)
+		g.actions = append(g.actions, r.Header.Get("SOAPAction"))
+		g.bodies = append(g.bodies, string(body))
+		if strings.Contains(string(body), "<NewLeaseDuration>3600<") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, upnpError, 725, "OnlyPermanentLeasesSupported")
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPortMapping(t *testing.T) {
+	g := &gateway{}
+	server := httptest.NewServer(g)
+	defer server.Close()
+
+	c, err := NewClient(server.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.controlURL != server.URL+"/ctl/IPConn" {
+		t.Fatalf("Expected the control url of the WANIPConnection service, got %s", c.controlURL)
+	}
+	if !c.internalIP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("Expected the address reaching the gateway, got %s", c.internalIP)
+	}
+
+	port, err := c.AddPortMapping("tcp", 49153, 8080, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 8080 {
+		t.Fatalf("Expected the port asked for, got %d", port)
+	}
+	// The gateway only supports permanent mappings
+	if len(g.actions) != 2 || !strings.Contains(g.bodies[1], "<NewLeaseDuration>0<") {
+		t.Fatalf("Expected a permanent mapping to be asked for again, got %v", g.bodies)
+	}
+	for _, expected := range []string{"<NewExternalPort>8080<", "<NewInternalPort>49153<", "<NewProtocol>TCP<", "<NewInternalClient>127.0.0.1<"} {
+		if !strings.Contains(g.bodies[1], expected) {
+			t.Fatalf("Expected %s in the request, got %s", expected, g.bodies[1])
+		}
+	}
+	if g.actions[0] != `"urn:schemas-upnp-org:service:WANIPConnection:1#AddPortMapping"` {
+		t.Fatalf("Unexpected action %s", g.actions[0])
+	}
+
+	if err := c.DeletePortMapping("udp", 49153, 8080); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.actions) != 3 || !strings.HasSuffix(g.actions[2], `#DeletePortMapping"`) || !strings.Contains(g.bodies[2], "<NewProtocol>UDP<") {
+		t.Fatalf("Expected the mapping to be removed, got %v", g.bodies)
+	}
+}
+
+func TestNoConnectionService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<root><device><serviceList></serviceList></device></root>`)
+	}))
+	defer server.Close()
+	if _, err := NewClient(server.URL); err == nil {
+		t.Fatal("Expected the gateway to have no connection service")
+	}
+}
+
+func TestParseSearchResponse(t *testing.T) {
+	location, err := parseSearchResponse([]byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nLOCATION: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location != "http://192.168.1.1:5000/rootDesc.xml" {
+		t.Fatalf("Unexpected location %s", location)
+	}
+	if _, err := parseSearchResponse([]byte("HTTP/1.1 200 OK\r\n\r\n")); err == nil {
+		t.Fatal("Expected no location")
+	}
+}
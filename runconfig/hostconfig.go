@@ -39,6 +39,13 @@ type RestartPolicy struct {
 	MaximumRetryCount int
 }
 
+// ParkPolicy parks the container once its network has been idle for
+// IdleMinutes: Action is "stop" or "pause", empty not to park it.
+type ParkPolicy struct {
+	Action      string
+	IdleMinutes int
+}
+
 type HostConfig struct {
 	Binds           []string
 	ContainerIDFile string
@@ -56,6 +63,22 @@ type HostConfig struct {
 	CapAdd          []string
 	CapDrop         []string
 	RestartPolicy   RestartPolicy
+	StartOnDemand   bool              // start the stopped container on a connection to its published TCP ports
+	PublishUpstream bool              // have the router upstream forward the published ports as well
+	ServiceVIP      string            // service the container backs behind a VIP shared with the other backends, empty if none
+	PublishTTL      string            // duration after which the published ports expire on each start (ex: "1h"), empty for never
+	PortNames       map[string]string // names of the published ports, by container port (ex: "80/tcp")
+	PortLabels      map[string]string // labels of the published ports (ex: service=web)
+	NoTrackPorts    []string          // published ports bypassing connection tracking, served by the userland proxy only (ex: "53/udp")
+	Mtu             int               // MTU of the container interface, below the one of the bridge, 0 for the bridge's
+	Sysctls         map[string]string // kernel parameters of the network namespace (ex: net.core.somaxconn=1024)
+	NetDevices      []string          // physical interfaces of the host moved into the container while it runs
+	StickyIP        bool              // keep the ip of the container from one start to the next, until it is removed
+	AllowMetadata   bool              // exempt from the block of the metadata service and the link-local addresses of the daemon
+	MaxPorts        int               // ports the container can publish, 0 for the limit of the daemon
+	MaxAddresses    int               // secondary addresses the container can have, 0 for the limit of the daemon
+	NetworkFrom     string            // container whose network settings are copied, with addresses of its own, empty if none
+	ParkPolicy      ParkPolicy
 }
 
 // This is used by the create command when you want to set both the
@@ -84,12 +107,29 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 		Privileged:      job.GetenvBool("Privileged"),
 		PublishAllPorts: job.GetenvBool("PublishAllPorts"),
 		NetworkMode:     NetworkMode(job.Getenv("NetworkMode")),
+		StartOnDemand:   job.GetenvBool("StartOnDemand"),
+		PublishUpstream: job.GetenvBool("PublishUpstream"),
+		ServiceVIP:      job.Getenv("ServiceVIP"),
+		PublishTTL:      job.Getenv("PublishTTL"),
+		Mtu:             job.GetenvInt("Mtu"),
+		StickyIP:        job.GetenvBool("StickyIP"),
+		AllowMetadata:   job.GetenvBool("AllowMetadata"),
+		MaxPorts:        job.GetenvInt("MaxPorts"),
+		MaxAddresses:    job.GetenvInt("MaxAddresses"),
+		NetworkFrom:     job.Getenv("NetworkFrom"),
 	}
 
 	job.GetenvJson("LxcConf", &hostConfig.LxcConf)
 	job.GetenvJson("PortBindings", &hostConfig.PortBindings)
 	job.GetenvJson("Devices", &hostConfig.Devices)
 	job.GetenvJson("RestartPolicy", &hostConfig.RestartPolicy)
+	job.GetenvJson("ParkPolicy", &hostConfig.ParkPolicy)
+	job.GetenvJson("PortNames", &hostConfig.PortNames)
+	job.GetenvJson("PortLabels", &hostConfig.PortLabels)
+	job.GetenvJson("Sysctls", &hostConfig.Sysctls)
+	if NoTrackPorts := job.GetenvList("NoTrackPorts"); NoTrackPorts != nil {
+		hostConfig.NoTrackPorts = NoTrackPorts
+	}
 	if Binds := job.GetenvList("Binds"); Binds != nil {
 		hostConfig.Binds = Binds
 	}
@@ -114,6 +154,9 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 	if CapDrop := job.GetenvList("CapDrop"); CapDrop != nil {
 		hostConfig.CapDrop = CapDrop
 	}
+	if NetDevices := job.GetenvList("NetDevices"); NetDevices != nil {
+		hostConfig.NetDevices = NetDevices
+	}
 
 	return hostConfig
 }
@@ -5,6 +5,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/nat"
 	"github.com/docker/docker/opts"
@@ -44,6 +45,11 @@ func Parse(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Config,
 		flCapAdd      = opts.NewListOpts(nil)
 		flCapDrop     = opts.NewListOpts(nil)
 		flSecurityOpt = opts.NewListOpts(nil)
+		flPortNames   = opts.NewListOpts(nil)
+		flPortLabels  = opts.NewListOpts(nil)
+		flNoTrack     = opts.NewListOpts(nil)
+		flSysctls     = opts.NewListOpts(nil)
+		flNetDevices  = opts.NewListOpts(nil)
 
 		flNetwork         = cmd.Bool([]string{"#n", "#-networking"}, true, "Enable networking for this container")
 		flPrivileged      = cmd.Bool([]string{"#privileged", "-privileged"}, false, "Give extended privileges to this container")
@@ -60,6 +66,17 @@ func Parse(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Config,
 		flCpuset          = cmd.String([]string{"-cpuset"}, "", "CPUs in which to allow execution (0-3, 0,1)")
 		flNetMode         = cmd.String([]string{"-net"}, "bridge", "Set the Network mode for the container\n'bridge': creates a new network stack for the container on the docker bridge\n'none': no networking for this container\n'container:<name|id>': reuses another container network stack\n'host': use the host network stack inside the container.  Note: the host mode gives the container full access to local system services such as D-bus and is therefore considered insecure.")
 		flRestartPolicy   = cmd.String([]string{"-restart"}, "", "Restart policy to apply when a container exits (no, on-failure[:max-retry], always)")
+		flStartOnDemand   = cmd.Bool([]string{"-start-on-demand"}, false, "Start the stopped container on the first connection to one of its published TCP ports")
+		flPublishUpstream = cmd.Bool([]string{"-publish-upstream"}, false, "Have the router of the local network forward the published ports as well, as set up for the daemon")
+		flPublishTTL      = cmd.String([]string{"-publish-ttl"}, "", "Unpublish the ports of the container this long after it starts, for a temporary share (ex: 1h)")
+		flMtu             = cmd.Int([]string{"-mtu"}, 0, "Set the MTU of the container interface, below the one of the bridge (ex: for a container whose traffic goes through a VPN)")
+		flMaxPorts        = cmd.Int([]string{"-max-ports"}, 0, "Limit the ports the container can publish, in place of the limit of the daemon")
+		flMaxAddresses    = cmd.Int([]string{"-max-addresses"}, 0, "Limit the secondary addresses the container can have, in place of the limit of the daemon")
+		flStickyIP        = cmd.Bool([]string{"-sticky-ip"}, false, "Keep the IP address of the container from one start to the next, and across restarts of the daemon, until it is removed")
+		flAllowMetadata   = cmd.Bool([]string{"-allow-metadata"}, false, "Let the container reach the cloud metadata service and the other link-local addresses, which the daemon blocks with --block-metadata")
+		flNetworkFrom     = cmd.String([]string{"-network-from"}, "", "Copy the published ports, DNS settings, links and network mode of this container, the new one getting addresses of its own")
+		flServiceVIP      = cmd.String([]string{"-service-vip"}, "", "Back the named service behind a VIP of the bridge network shared with its other containers, the healthiest one receiving the traffic")
+		flParkPolicy      = cmd.String([]string{"-park"}, "", "Park the container once its network has been idle for some minutes (stop:minutes, pause:minutes)")
 	)
 
 	cmd.Var(&flAttach, []string{"a", "-attach"}, "Attach to STDIN, STDOUT or STDERR.")
@@ -72,6 +89,11 @@ func Parse(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Config,
 
 	cmd.Var(&flPublish, []string{"p", "-publish"}, fmt.Sprintf("Publish a container's port to the host\nformat: %s\n(use 'docker port' to see the actual mapping)", nat.PortSpecTemplateFormat))
 	cmd.Var(&flExpose, []string{"#expose", "-expose"}, "Expose a port from the container without publishing it to your host")
+	cmd.Var(&flPortNames, []string{"-port-name"}, "Name a published port of the container, listed along with its mapping, as port[/proto]=name (ex: 80/tcp=web)")
+	cmd.Var(&flPortLabels, []string{"-port-label"}, "Label the published ports of the container, listed along with their mappings, as key=value (ex: env=staging)")
+	cmd.Var(&flNoTrack, []string{"-notrack"}, "Bypass connection tracking for a published port of the container, served by the userland proxy only, as port[/proto] (ex: 53/udp)")
+	cmd.Var(&flNetDevices, []string{"-net-device"}, "Move this physical interface of the host into the container while it runs, such as for a router or an IDS (ex: eth1)")
+	cmd.Var(&flSysctls, []string{"-sysctl"}, "Set a kernel parameter of the network namespace of the container, as key=value (ex: net.core.somaxconn=1024)")
 	cmd.Var(&flDns, []string{"#dns", "-dns"}, "Set custom DNS servers")
 	cmd.Var(&flDnsSearch, []string{"-dns-search"}, "Set custom DNS search domains")
 	cmd.Var(&flExtraHosts, []string{"-add-host"}, "Add a custom host-to-IP mapping (host:ip)")
@@ -235,6 +257,54 @@ func Parse(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Config,
 		return nil, nil, cmd, err
 	}
 
+	parkPolicy, err := parseParkPolicy(*flParkPolicy)
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+
+	portNames, err := parsePortNames(flPortNames.GetAll())
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+	portLabels, err := parsePortLabels(flPortLabels.GetAll())
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+	noTrackPorts, err := parseNoTrackPorts(flNoTrack.GetAll())
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+	sysctls, err := parseSysctls(flSysctls.GetAll())
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+	if flNetDevices.Len() > 0 && !netMode.IsPrivate() {
+		return nil, nil, cmd, fmt.Errorf("Conflicting options: --net-device and the network mode (--net) %s, the interfaces are moved into the network namespace of the container", netMode)
+	}
+
+	if *flStickyIP && !netMode.IsPrivate() {
+		return nil, nil, cmd, fmt.Errorf("Conflicting options: --sticky-ip and the network mode (--net) %s, the container has no ip of its own", netMode)
+	}
+
+	if *flAllowMetadata && !netMode.IsPrivate() {
+		return nil, nil, cmd, fmt.Errorf("Conflicting options: --allow-metadata and the network mode (--net) %s, the container has no network of its own", netMode)
+	}
+
+	if *flMaxPorts < 0 || *flMaxAddresses < 0 {
+		return nil, nil, cmd, fmt.Errorf("Invalid --max-ports or --max-addresses, the limits must be positive")
+	}
+
+	if *flPublishTTL != "" {
+		if ttl, err := time.ParseDuration(*flPublishTTL); err != nil || ttl <= 0 {
+			return nil, nil, cmd, fmt.Errorf("Invalid --publish-ttl %s, it must be a positive duration (ex: 1h)", *flPublishTTL)
+		}
+	}
+
+	// The smallest MTU of IPv4
+	if *flMtu != 0 && *flMtu < 68 {
+		return nil, nil, cmd, fmt.Errorf("Invalid --mtu %d, it must be at least 68", *flMtu)
+	}
+
 	config := &Config{
 		Hostname:        hostname,
 		Domainname:      domainname,
@@ -276,6 +346,22 @@ func Parse(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Config,
 		CapAdd:          flCapAdd.GetAll(),
 		CapDrop:         flCapDrop.GetAll(),
 		RestartPolicy:   restartPolicy,
+		StartOnDemand:   *flStartOnDemand,
+		ParkPolicy:      parkPolicy,
+		PublishUpstream: *flPublishUpstream,
+		ServiceVIP:      *flServiceVIP,
+		PublishTTL:      *flPublishTTL,
+		PortNames:       portNames,
+		PortLabels:      portLabels,
+		NoTrackPorts:    noTrackPorts,
+		Mtu:             *flMtu,
+		Sysctls:         sysctls,
+		NetDevices:      flNetDevices.GetAll(),
+		StickyIP:        *flStickyIP,
+		AllowMetadata:   *flAllowMetadata,
+		MaxPorts:        *flMaxPorts,
+		MaxAddresses:    *flMaxAddresses,
+		NetworkFrom:     *flNetworkFrom,
 	}
 
 	if sysInfo != nil && flMemory > 0 && !sysInfo.SwapLimit {
@@ -330,6 +416,33 @@ func parseRestartPolicy(policy string) (RestartPolicy, error) {
 	return p, nil
 }
 
+// parseParkPolicy parses a park policy, given as action:minutes.
+func parseParkPolicy(policy string) (ParkPolicy, error) {
+	p := ParkPolicy{}
+
+	if policy == "" {
+		return p, nil
+	}
+
+	parts := strings.Split(policy, ":")
+	if len(parts) != 2 {
+		return p, fmt.Errorf("invalid park policy %s, expected action:minutes", policy)
+	}
+	switch parts[0] {
+	case "stop", "pause":
+		p.Action = parts[0]
+	default:
+		return p, fmt.Errorf("invalid park action %s", parts[0])
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes <= 0 {
+		return p, fmt.Errorf("invalid park idle time %s, expected a number of minutes", parts[1])
+	}
+	p.IdleMinutes = minutes
+
+	return p, nil
+}
+
 // options will come in the format of name.key=value or name.option
 func parseDriverOpts(opts opts.ListOpts) (map[string][]string, error) {
 	out := make(map[string][]string, len(opts.GetAll()))
@@ -404,3 +517,71 @@ func ParseDevice(device string) (DeviceMapping, error) {
 	}
 	return deviceMapping, nil
 }
+
+// parsePortNames parses the names of the ports, given as port[/proto]=name,
+// by container port.
+func parsePortNames(names []string) (map[string]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, n := range names {
+		parts := strings.SplitN(n, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid port name %s, it must be port[/proto]=name (ex: 80/tcp=web)", n)
+		}
+		proto, port := nat.SplitProtoPort(parts[0])
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("Invalid port name %s, it must be port[/proto]=name (ex: 80/tcp=web)", n)
+		}
+		m[string(nat.NewPort(proto, port))] = parts[1]
+	}
+	return m, nil
+}
+
+// parseNoTrackPorts parses the ports bypassing connection tracking, given as
+// port[/proto].
+func parseNoTrackPorts(ports []string) ([]string, error) {
+	var parsed []string
+	for _, p := range ports {
+		proto, port := nat.SplitProtoPort(p)
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("Invalid untracked port %s, it must be port[/proto] (ex: 53/udp)", p)
+		}
+		parsed = append(parsed, string(nat.NewPort(proto, port)))
+	}
+	return parsed, nil
+}
+
+// parsePortLabels parses the labels of the ports, given as key=value.
+func parsePortLabels(labels []string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, l := range labels {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid port label %s, it must be key=value (ex: env=staging)", l)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
+
+// parseSysctls parses the kernel parameters of the network namespace, given
+// as key=value. Which ones a container can set is up to the network driver.
+func parseSysctls(sysctls []string) (map[string]string, error) {
+	if len(sysctls) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, s := range sysctls {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid sysctl %s, it must be key=value (ex: net.core.somaxconn=1024)", s)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
@@ -58,3 +58,161 @@ func TestNetHostname(t *testing.T) {
 		t.Fatalf("Expected error ErrConflictNetworkHostname, got: %s", err)
 	}
 }
+
+func TestParseParkPolicy(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--park=pause:30", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.ParkPolicy != (ParkPolicy{Action: "pause", IdleMinutes: 30}) {
+		t.Fatalf("Expected the container to be paused after 30 minutes, got %+v", hostConfig.ParkPolicy)
+	}
+
+	for _, policy := range []string{"stop", "stop:0", "stop:soon", "kill:10", "stop:10:1"} {
+		if _, _, _, err := parseRun([]string{"--park=" + policy, "img", "cmd"}, nil); err == nil {
+			t.Fatalf("Expected the park policy %s to be invalid", policy)
+		}
+	}
+}
+
+func TestParsePublishTTL(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--publish-ttl=1h", "-p", "8080:80", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.PublishTTL != "1h" {
+		t.Fatalf("Expected the ports to expire after 1h, got %q", hostConfig.PublishTTL)
+	}
+	for _, ttl := range []string{"1", "-1h", "0s", "an hour"} {
+		if _, _, _, err := parseRun([]string{"--publish-ttl=" + ttl, "img", "cmd"}, nil); err == nil {
+			t.Fatalf("Expected the publish TTL %s to be invalid", ttl)
+		}
+	}
+}
+
+func TestParseMtu(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--mtu=1400", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.Mtu != 1400 {
+		t.Fatalf("Expected the MTU 1400, got %d", hostConfig.Mtu)
+	}
+	for _, mtu := range []string{"-1", "67", "large"} {
+		if _, _, _, err := parseRun([]string{"--mtu=" + mtu, "img", "cmd"}, nil); err == nil {
+			t.Fatalf("Expected the MTU %s to be invalid", mtu)
+		}
+	}
+}
+
+func TestParseNetDevices(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--net-device=eth1", "--net-device=eth2", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostConfig.NetDevices) != 2 || hostConfig.NetDevices[0] != "eth1" || hostConfig.NetDevices[1] != "eth2" {
+		t.Fatalf("Unexpected interfaces %v", hostConfig.NetDevices)
+	}
+	if _, _, _, err := parseRun([]string{"--net-device=eth1", "--net=host", "img", "cmd"}, nil); err == nil {
+		t.Fatal("Expected --net-device to be refused with the host network")
+	}
+}
+
+func TestParseStickyIP(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--sticky-ip", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hostConfig.StickyIP {
+		t.Fatal("Expected the ip of the container to be sticky")
+	}
+	if _, _, _, err := parseRun([]string{"--sticky-ip", "--net=none", "img", "cmd"}, nil); err == nil {
+		t.Fatal("Expected --sticky-ip to be refused without a network of its own")
+	}
+}
+
+func TestParseAllowMetadata(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--allow-metadata", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hostConfig.AllowMetadata {
+		t.Fatal("Expected the container to reach the metadata service")
+	}
+	if _, _, _, err := parseRun([]string{"--allow-metadata", "--net=host", "img", "cmd"}, nil); err == nil {
+		t.Fatal("Expected --allow-metadata to be refused without a network of its own")
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--max-ports=10", "--max-addresses=2", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.MaxPorts != 10 || hostConfig.MaxAddresses != 2 {
+		t.Fatalf("Unexpected limits %d %d", hostConfig.MaxPorts, hostConfig.MaxAddresses)
+	}
+	if _, _, _, err := parseRun([]string{"--max-ports=-1", "img", "cmd"}, nil); err == nil {
+		t.Fatal("Expected a negative limit to be refused")
+	}
+}
+
+func TestParseNetworkFrom(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--network-from=web-blue", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.NetworkFrom != "web-blue" {
+		t.Fatalf("Expected the network of web-blue to be copied, got %q", hostConfig.NetworkFrom)
+	}
+}
+
+func TestParseSysctls(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--sysctl=net.core.somaxconn=1024", "--sysctl=net.ipv4.ip_local_port_range=10000 20000", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostConfig.Sysctls) != 2 || hostConfig.Sysctls["net.core.somaxconn"] != "1024" || hostConfig.Sysctls["net.ipv4.ip_local_port_range"] != "10000 20000" {
+		t.Fatalf("Unexpected sysctls %v", hostConfig.Sysctls)
+	}
+	for _, sysctl := range []string{"net.core.somaxconn", "=1024", "net.core.somaxconn="} {
+		if _, _, _, err := parseRun([]string{"--sysctl=" + sysctl, "img", "cmd"}, nil); err == nil {
+			t.Fatalf("Expected the sysctl %s to be invalid", sysctl)
+		}
+	}
+}
+
+func TestParseNoTrackPorts(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"-p", "53:53/udp", "-p", "80:80", "--notrack=53/udp", "--notrack=80", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostConfig.NoTrackPorts) != 2 || hostConfig.NoTrackPorts[0] != "53/udp" || hostConfig.NoTrackPorts[1] != "80/tcp" {
+		t.Fatalf("Unexpected untracked ports %v", hostConfig.NoTrackPorts)
+	}
+
+	for _, arg := range []string{"--notrack=dns", "--notrack=65536/udp", "--notrack="} {
+		if _, _, _, err := parseRun([]string{arg, "img", "cmd"}, nil); err == nil {
+			t.Fatalf("Expected %s to be invalid", arg)
+		}
+	}
+}
+
+func TestParsePortNamesAndLabels(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"-p", "80:80", "-p", "53:53/udp", "--port-name=80=web", "--port-name=53/udp=dns", "--port-label=env=staging", "img", "cmd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.PortNames["80/tcp"] != "web" || hostConfig.PortNames["53/udp"] != "dns" {
+		t.Fatalf("Unexpected port names %v", hostConfig.PortNames)
+	}
+	if len(hostConfig.PortLabels) != 1 || hostConfig.PortLabels["env"] != "staging" {
+		t.Fatalf("Unexpected port labels %v", hostConfig.PortLabels)
+	}
+
+	for _, arg := range []string{"--port-name=web", "--port-name=http=web", "--port-name=80=", "--port-label=staging", "--port-label==staging"} {
+		if _, _, _, err := parseRun([]string{arg, "img", "cmd"}, nil); err == nil {
+			t.Fatalf("Expected %s to be invalid", arg)
+		}
+	}
+}